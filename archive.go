@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// defaultZipEntries は、zipアーカイブの中で変換するファイル名の既定のパターンです。
+const defaultZipEntries = "*.xml"
+
+// isZipPath は、出力先がzipアーカイブ (拡張子 .zip) かを返します。
+func isZipPath(p string) bool {
+	return strings.EqualFold(filepath.Ext(p), ".zip")
+}
+
+// matchZipEntry は、zipアーカイブの中のファイル名 name が pattern に一致するかを返します。
+// pattern に / を含まない場合はファイル名の最後の要素と比較し、大文字と小文字は区別しません。
+func matchZipEntry(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return matched
+}
+
+// runTransformArchive は、zipアーカイブの入力のうち opts.ZipEntries に一致するファイルをルールに基づいて変換し、
+// それ以外のファイルはそのまま複製して、新しいzipアーカイブとして出力します。
+// カウンターは merge と同じく、ファイルをまたいで続けて採番されます。
+func runTransformArchive(ctx context.Context, rules *obufuku.RuleSet, ruleFilepath, inputFilepath, outputFilepath string, opts transformOptions) error {
+	if opts.Compress {
+		return fmt.Errorf("--compress cannot be used with zip archive output '%s'", outputFilepath)
+	}
+	if opts.ValidateOutput != "" {
+		return fmt.Errorf("--validate-output cannot be used with zip archive output '%s'", outputFilepath)
+	}
+	pattern := opts.ZipEntries
+	if pattern == "" {
+		pattern = defaultZipEntries
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid zip entry pattern '%s': %w", pattern, err)
+	}
+
+	if err := checkInputSize(inputFilepath, opts.MaxInputSize); err != nil {
+		return err
+	}
+	archive, archiveFile, err := openZipArchive(inputFilepath)
+	if err != nil {
+		return fmt.Errorf("error opening input archive '%s': %w", inputFilepath, err)
+	}
+	defer archiveFile.Close()
+
+	output, err := createOutput(outputFilepath, opts)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	zipWriter := zip.NewWriter(output)
+	if err := zipWriter.SetComment(archive.Comment); err != nil {
+		return fmt.Errorf("error writing output archive '%s': %w", outputFilepath, err)
+	}
+	var total obufuku.TransformResult
+	var transformed, copied int
+	var names []string
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || !matchZipEntry(pattern, entry.Name) {
+			// 変換しないファイルは、圧縮されたまま複製する
+			if err := zipWriter.Copy(entry); err != nil {
+				return fmt.Errorf("error copying '%s' from archive '%s': %w", entry.Name, inputFilepath, err)
+			}
+			copied++
+			continue
+		}
+
+		opts.audit.setDocument(entry.Name)
+		result, err := transformZipEntry(ctx, rules, zipWriter, entry, opts.MaxInputSize)
+		total.Add(result, entry.Name+": ")
+		if err != nil {
+			printWarnings(total)
+			if aborted(ctx, err) {
+				output.Discard()
+			}
+			return fmt.Errorf("error processing XML '%s' in archive '%s': %w", entry.Name, inputFilepath, err)
+		}
+		transformed++
+		names = append(names, entry.Name)
+	}
+	printWarnings(total)
+
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("error writing output archive '%s': %w", outputFilepath, err)
+	}
+	var skipped []string
+	if skipUnchanged(output, opts) {
+		skipped = []string{outputFilepath}
+	} else if err := output.Finish(); err != nil {
+		return err
+	}
+
+	if opts.report != nil {
+		return opts.report.render(runReport{Command: "archive", Rules: ruleFilepath, Inputs: []string{inputFilepath}, Output: outputFilepath, Files: names, Skipped: skipped, TransformResult: total})
+	}
+	fmt.Printf("XML archive processing completed. Rules: '%s', Input: '%s', Output: '%s'\n", ruleFilepath, inputFilepath, outputFilepath)
+	fmt.Printf("  Entries: %d transformed, %d copied\n", transformed, copied)
+	printSkipped(skipped)
+	printResult(total)
+	return nil
+}
+
+// transformZipEntry は、zipアーカイブの中のファイル entry を変換し、同じ名前と属性で zipWriter に書き込みます。
+// entry の展開後の内容は limit バイト (0 は無制限) までしか読み込めません。
+func transformZipEntry(ctx context.Context, rules *obufuku.RuleSet, zipWriter *zip.Writer, entry *zip.File, limit int64) (obufuku.TransformResult, error) {
+	reader, err := entry.Open()
+	if err != nil {
+		return obufuku.TransformResult{}, err
+	}
+	defer reader.Close()
+
+	// 変換後の大きさとCRCは書き込み時に計算し直す
+	header := entry.FileHeader
+	header.CRC32 = 0
+	header.CompressedSize64 = 0
+	header.UncompressedSize64 = 0
+	writer, err := zipWriter.CreateHeader(&header)
+	if err != nil {
+		return obufuku.TransformResult{}, err
+	}
+	return rules.Transform(ctx, limitInput(reader, entry.Name, limit), writer)
+}
+
+// openZipArchive は、入力のzipアーカイブを開きます。読み込み後は、返された io.Closer で閉じる必要があります。
+// S3 のオブジェクトはランダムアクセスできないため、一時ファイルに書き出してから開きます。
+func openZipArchive(filename string) (*zip.Reader, io.Closer, error) {
+	var file inputFile
+	var closer io.Closer
+	switch {
+	case isS3URI(filename):
+		body, err := openS3Object(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		spooled, err := spoolS3Object(body)
+		body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		file = spooled
+		closer = &multiCloser{closers: []io.Closer{spooled, removeFile(spooled.Name())}}
+	case isSFTPURI(filename):
+		sftpFile, err := openSFTPFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		file, closer = sftpFile, sftpFile
+	default:
+		osFile, err := os.Open(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		file, closer = osFile, osFile
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+	return archive, closer, nil
+}
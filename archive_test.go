@@ -0,0 +1,139 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+func TestMatchZipEntry(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.xml", "a.xml", true},
+		{"*.xml", "dir/sub/a.XML", true},
+		{"*.xml", "a.txt", false},
+		{"dir/*.xml", "dir/a.xml", true},
+		{"dir/*.xml", "other/a.xml", false},
+		{"dir/*.xml", "dir/sub/a.xml", false},
+	}
+	for _, tt := range tests {
+		if got := matchZipEntry(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchZipEntry(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+// writeZip は、entries (名前と内容の組) を順に格納したzipアーカイブを作成し、そのパスを返します。
+func writeZip(t *testing.T, dir, name string, entries [][2]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entries {
+		f, err := w.Create(e[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(f, e[1])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, dir, name, buf.String())
+}
+
+// readZip は、zipアーカイブ path の中身を名前の順に返します。
+func readZip(t *testing.T, path string) [][2]string {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var entries [][2]string
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, [2]string{f.Name, string(data)})
+	}
+	return entries
+}
+
+func TestTransformArchive(t *testing.T) {
+	dir := t.TempDir()
+	cfg := obufuku.Config{
+		NameRules:         []obufuku.ConfigNameRule{{Old: "b", New: "c"}},
+		PrependChildRules: []obufuku.ConfigInsertRule{{Target: "a", Template: "<n>%d</n>", Counter: "n"}},
+		Counters:          map[string]obufuku.ConfigCounter{"n": {}},
+		Output:            obufuku.ConfigOutput{Compact: true},
+	}
+	rulePath := writeRules(t, dir, cfg)
+	inputPath := writeZip(t, dir, "in.zip", [][2]string{
+		{"one.xml", "<a><b>1</b></a>"},
+		{"readme.txt", "<b>not xml rules</b>"},
+		{"dir/two.XML", "<a><b>2</b></a>"},
+	})
+	outputPath := filepath.Join(dir, "out.zip")
+	if err := runTransform(context.Background(), rulePath, inputPath, outputPath, transformOptions{}); err != nil {
+		t.Fatalf("runTransform: %v", err)
+	}
+
+	// カウンターはファイルをまたいで続き、一致しないファイルはそのまま複製される
+	want := [][2]string{
+		{"one.xml", "<a><n>1</n><c>1</c></a>"},
+		{"readme.txt", "<b>not xml rules</b>"},
+		{"dir/two.XML", "<a><n>2</n><c>2</c></a>"},
+	}
+	got := readZip(t, outputPath)
+	if len(got) != len(want) {
+		t.Fatalf("entries = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestTransformArchiveErrors(t *testing.T) {
+	dir := t.TempDir()
+	rulePath := writeRules(t, dir, obufuku.Config{})
+	inputPath := writeZip(t, dir, "in.zip", [][2]string{{"bad.xml", "<a><b></a>"}})
+	outputPath := filepath.Join(dir, "out.zip")
+
+	tests := []struct {
+		name string
+		opts transformOptions
+		// created は、エラーの前に出力先が作成されるかどうかです。
+		created bool
+	}{
+		{"invalid pattern", transformOptions{ZipEntries: "["}, false},
+		{"compress", transformOptions{Compress: true}, false},
+		{"malformed entry", transformOptions{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := runTransform(context.Background(), rulePath, inputPath, outputPath, tt.opts); err == nil {
+				t.Error("runTransform succeeded, want an error")
+			}
+			if _, err := os.Stat(outputPath); !tt.created && !os.IsNotExist(err) {
+				t.Errorf("output archive was created: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// auditRecord は、監査ログの1行 (ルールによる1つの変更) です。
+type auditRecord struct {
+	// Document は、変更した文書です。入力ファイルのほか、zipアーカイブの中のファイル名、
+	// CSV の行 ("input.csv line 3")、分割した要素の出力ファイルになります。
+	// merge の passes のパスによる変更では、出力ファイルになります。
+	Document string `json:"document"`
+	Rule     string `json:"rule"`
+	Path     string `json:"path"`
+	// Offset・Line・Column は、変更したトークンの直後の、Document での位置です。
+	// passes のパスによる変更では、前のパスが出力した中間の文書での位置になります。
+	Offset int64  `json:"offset"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	// Description は、ルールファイルでルールに設定された説明です。
+	Description string `json:"description,omitempty"`
+}
+
+// auditLog は、ルールによる変更を1行に1件の JSON (JSON Lines) で監査ファイルに書き込む Observer です。
+// passes のパスは並行して動くため、書き込みは mu で保護します。
+type auditLog struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	w        *bufio.Writer
+	enc      *json.Encoder
+	document string
+	// err は、最初の書き込みエラーです。Close で報告します。
+	err error
+}
+
+// openAuditLog は、opts.Audit の監査ファイルを作成し、rules のルールの適用を記録するよう登録します。
+// document は、最初に変更を記録する文書の名前です。監査ファイルが指定されていない場合は nil を返します。
+func openAuditLog(rules *obufuku.RuleSet, opts transformOptions, document string) (*auditLog, error) {
+	if opts.Audit == "" {
+		return nil, nil
+	}
+	file, err := os.Create(opts.Audit)
+	if err != nil {
+		return nil, fmt.Errorf("error creating audit file '%s': %w", opts.Audit, err)
+	}
+	w := bufio.NewWriter(file)
+	a := &auditLog{path: opts.Audit, file: file, w: w, enc: json.NewEncoder(w), document: document}
+	a.enc.SetEscapeHTML(false)
+	rules.AddObserver(a)
+	return a, nil
+}
+
+// setDocument は、以降の変更を記録する文書の名前を設定します。
+func (a *auditLog) setDocument(document string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.document = document
+}
+
+// RuleApplied は obufuku.Observer インターフェースを実装します。
+func (a *auditLog) RuleApplied(event obufuku.RuleEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return
+	}
+	document := a.document
+	if event.Input != "" {
+		// merge では、ルールを適用した入力ファイルを記録する
+		document = event.Input
+	}
+	a.err = a.enc.Encode(auditRecord{
+		Document:    document,
+		Rule:        event.Rule,
+		Path:        event.Path,
+		Offset:      event.Offset,
+		Line:        event.Line,
+		Column:      event.Column,
+		Before:      event.Before,
+		After:       event.After,
+		Description: event.Description,
+	})
+}
+
+// Close は、書き込んでいない記録を書き出して監査ファイルを閉じ、書き込み中のエラーがあれば返します。
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.err
+	if flushErr := a.w.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing audit file '%s': %w", a.path, err)
+	}
+	return nil
+}
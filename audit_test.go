@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// readAudit は、監査ファイルの記録を読み込みます。
+func readAudit(t *testing.T, path string) []auditRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []auditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("audit line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestAudit(t *testing.T) {
+	dir := t.TempDir()
+	cfg := obufuku.Config{
+		NameRules:  []obufuku.ConfigNameRule{{Old: "b", New: "c", Description: "renamed in v2"}},
+		ValueRules: []obufuku.ConfigValueRule{{Target: "v", Type: "append", Params: map[string]interface{}{"suffix": "<!>"}}},
+	}
+	rulePath := writeRules(t, dir, cfg)
+	inputPath := writeFile(t, dir, "in.xml", "<a>\n<b/>\n<v>x</v>\n</a>")
+	auditPath := filepath.Join(dir, "audit.jsonl")
+	if err := runTransform(context.Background(), rulePath, inputPath, filepath.Join(dir, "out.xml"), transformOptions{Audit: auditPath}); err != nil {
+		t.Fatalf("runTransform: %v", err)
+	}
+	want := []auditRecord{
+		{Document: inputPath, Rule: "name_rules[0]", Path: "/a/c", Offset: 8, Line: 2, Column: 5, Before: "b", After: "c", Description: "renamed in v2"},
+		{Document: inputPath, Rule: "value_rules[0]", Path: "/a/v", Offset: 13, Line: 3, Column: 5, Before: "x", After: "x<!>"},
+	}
+	if got := readAudit(t, auditPath); !reflect.DeepEqual(got, want) {
+		t.Errorf("audit = %+v, want %+v", got, want)
+	}
+}
+
+func TestAuditCreateError(t *testing.T) {
+	dir := t.TempDir()
+	rulePath, inputPath := writeRules(t, dir, obufuku.Config{}), writeFile(t, dir, "in.xml", "<a/>")
+	opts := transformOptions{Audit: filepath.Join(dir, "missing", "audit.jsonl")}
+	if err := runTransform(context.Background(), rulePath, inputPath, filepath.Join(dir, "out.xml"), opts); err == nil {
+		t.Error("runTransform succeeded, want an error for the audit file")
+	}
+}
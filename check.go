@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// runCheck は、各入力ファイルが整形式のXMLかを検査し、見つかった問題を位置とともに出力します。
+// 問題のあるファイルが1つでもあればエラーを返します。
+func runCheck(inputFilepaths []string, secure bool, maxProblems int) error {
+	input := obufuku.ConfigInput{Secure: secure}
+	invalid := 0
+	for _, inputFilepath := range inputFilepaths {
+		inputFile, err := openInput(inputFilepath)
+		if err != nil {
+			return fmt.Errorf("error opening input file '%s': %w", inputFilepath, err)
+		}
+		problems, err := obufuku.CheckWellFormed(inputFile, input, maxProblems)
+		inputFile.Close()
+		if err != nil {
+			return fmt.Errorf("error reading input file '%s': %w", inputFilepath, err)
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s: well-formed\n", inputFilepath)
+			continue
+		}
+		invalid++
+		for _, problem := range problems {
+			fmt.Printf("%s:%s\n", inputFilepath, problem)
+		}
+		fmt.Printf("%s: %d problem(s)\n", inputFilepath, len(problems))
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d file(s) are not well-formed", invalid, len(inputFilepaths))
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// checksumAlgorithms は、--checksum で指定できるハッシュアルゴリズムです。
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// newChecksumHash は、アルゴリズム名に対応するハッシュを作成します。
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm: '%s' (supported: md5, sha1, sha256, sha512)", algorithm)
+	}
+	return newHash(), nil
+}
+
+// writeChecksumFile は、targetPath のチェックサムを sha256sum などと同じ
+// "<digest>  <ファイル名>" の形式で、targetPath に拡張子 .<algorithm> を付けたファイルに書き込みます。
+// targetPath が S3 や SFTP のURIの場合は、チェックサムファイルも同じ場所に書き込みます。
+func writeChecksumFile(targetPath, algorithm string, sum []byte) (string, error) {
+	checksumPath := targetPath + "." + algorithm
+	name := filepath.Base(targetPath)
+	if isS3URI(targetPath) || isSFTPURI(targetPath) {
+		name = path.Base(targetPath)
+	}
+	line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum), name)
+	write := func() error { return os.WriteFile(checksumPath, []byte(line), 0o644) }
+	switch {
+	case isS3URI(targetPath):
+		write = func() error { return putS3Object(checksumPath, []byte(line)) }
+	case isSFTPURI(targetPath):
+		write = func() error { return writeSFTPFile(checksumPath, []byte(line)) }
+	}
+	if err := write(); err != nil {
+		return "", fmt.Errorf("error writing checksum file '%s': %w", checksumPath, err)
+	}
+	return checksumPath, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+func TestChecksumFile(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		newHash   func() hash.Hash
+		output    string
+	}{
+		{"sha256", sha256.New, "out.xml"},
+		{"md5", md5.New, "out.xml"},
+		// 圧縮する場合は、圧縮後のファイルのチェックサムになる
+		{"sha256", sha256.New, "out.xml.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm+" "+tt.output, func(t *testing.T) {
+			dir := t.TempDir()
+			rulePath, inputPath := writeRules(t, dir, obufuku.Config{}), writeFile(t, dir, "in.xml", "<a><b/></a>")
+			outputPath := filepath.Join(dir, tt.output)
+			if err := runTransform(context.Background(), rulePath, inputPath, outputPath, transformOptions{Checksum: tt.algorithm}); err != nil {
+				t.Fatalf("runTransform: %v", err)
+			}
+			output, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := tt.newHash()
+			h.Write(output)
+			sidecar, err := os.ReadFile(outputPath + "." + tt.algorithm)
+			if err != nil {
+				t.Fatalf("checksum file: %v", err)
+			}
+			if want := hex.EncodeToString(h.Sum(nil)) + "  " + tt.output + "\n"; string(sidecar) != want {
+				t.Errorf("checksum file = %q, want %q", sidecar, want)
+			}
+		})
+	}
+}
+
+func TestChecksumUnknownAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	rulePath, inputPath := writeRules(t, dir, obufuku.Config{}), writeFile(t, dir, "in.xml", "<a/>")
+	if err := runTransform(context.Background(), rulePath, inputPath, filepath.Join(dir, "out.xml"), transformOptions{Checksum: "crc32"}); err == nil {
+		t.Error("unknown checksum algorithm was accepted")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+func TestTransformPersistCounters(t *testing.T) {
+	dir := t.TempDir()
+	cfg := obufuku.Config{
+		PrependChildRules: []obufuku.ConfigInsertRule{{Target: "a", Template: "<n>%d</n>", Counter: "n"}},
+		Counters:          map[string]obufuku.ConfigCounter{"n": {Persist: filepath.Join(dir, "state.json")}},
+		Output:            obufuku.ConfigOutput{Compact: true},
+	}
+	rulePath, inputPath, outputPath := writeRules(t, dir, cfg), writeFile(t, dir, "in.xml", "<a/>"), filepath.Join(dir, "out.xml")
+	// 実行のたびに、前回の続きから採番する
+	for _, want := range []string{"<a><n>1</n></a>", "<a><n>2</n></a>"} {
+		if err := runTransform(context.Background(), rulePath, inputPath, outputPath, transformOptions{}); err != nil {
+			t.Fatalf("runTransform: %v", err)
+		}
+		got, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("output = %q, want %q", got, want)
+		}
+	}
+
+	// 変換に失敗した場合は保存しない
+	badPath := writeFile(t, dir, "bad.xml", "<a><b></a>")
+	if err := runTransform(context.Background(), rulePath, badPath, outputPath, transformOptions{}); err == nil {
+		t.Fatal("runTransform succeeded with malformed input, want an error")
+	}
+	if state, _ := os.ReadFile(filepath.Join(dir, "state.json")); string(state) != "{\n  \"n\": 2\n}\n" {
+		t.Errorf("state file = %q, want the value of the last successful run", state)
+	}
+}
+
+func TestUntrustedRules(t *testing.T) {
+	// サーバーが受け取ったルールでは、サーバーの環境から値を読み込む設定を拒否する
+	t.Setenv("OBUFUKU_TEST_START", "5")
+	dir := t.TempDir()
+	rows := writeFile(t, dir, "rows.csv", "id\n1\n")
+	tests := []struct {
+		name string
+		cfg  obufuku.Config
+	}{
+		{"start_from", obufuku.Config{Counters: map[string]obufuku.ConfigCounter{"n": {StartFrom: &obufuku.ConfigCounterSource{Env: "OBUFUKU_TEST_START"}}}}},
+		{"persist", obufuku.Config{Counters: map[string]obufuku.ConfigCounter{"n": {Persist: filepath.Join(dir, "state.json")}}}},
+		{"csv rows", obufuku.Config{InsertRules: []obufuku.ConfigInsertRule{{Target: "a", Template: `<n>{{csv "id"}}</n>`, CSV: rows}}}},
+		{"start_from in a pass", obufuku.Config{Passes: []obufuku.Config{{Counters: map[string]obufuku.ConfigCounter{"n": {StartFrom: &obufuku.ConfigCounterSource{Command: []string{"echo", "5"}}}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parseRuleSet(data, "rules.json", transformOptions{untrusted: true}); err == nil {
+				t.Error("parseRuleSet succeeded, want an error")
+			}
+			if _, err := parseRuleSet(data, "rules.json", transformOptions{}); err != nil {
+				t.Errorf("parseRuleSet of trusted rules: %v", err)
+			}
+		})
+	}
+}
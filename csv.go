@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// utf8BOM は、表計算ソフトなどが CSV の先頭に付けるバイト順マークです。
+const utf8BOM = "\ufeff"
+
+// runTransformCSV は、CSV の入力の列 opts.CSVColumn の各セルをXMLとしてルールに基づいて変換し、
+// 他の列はそのままで CSV として出力します。1行目は列名の見出しとして扱い、空のセルは変換しません。
+// セルはUTF-8の文字列として扱うため、入出力のエンコーディングの設定は使用できません。
+// カウンターは merge と同じく、行をまたいで続けて採番されます。
+func runTransformCSV(ctx context.Context, rules *obufuku.RuleSet, ruleFilepath, inputFilepath, outputFilepath string, opts transformOptions) error {
+	if rules.OutputEncoding != nil {
+		return fmt.Errorf("output encoding '%s' cannot be used with CSV columns", rules.Output.Encoding)
+	}
+	if enc := rules.Input.Encoding; enc != "" && enc != "auto" && !strings.EqualFold(enc, "UTF-8") {
+		return fmt.Errorf("input encoding '%s' cannot be used with CSV columns", enc)
+	}
+	if opts.ValidateOutput != "" {
+		return fmt.Errorf("--validate-output cannot be used with CSV output '%s'", outputFilepath)
+	}
+
+	inputFile, err := openLimitedInput(inputFilepath, opts.MaxInputSize)
+	if err != nil {
+		return fmt.Errorf("error opening input file '%s': %w", inputFilepath, err)
+	}
+	defer inputFile.Close()
+	reader := csv.NewReader(inputFile)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("error reading CSV header of '%s': %w", inputFilepath, err)
+	}
+	bom := strings.HasPrefix(header[0], utf8BOM)
+	header[0] = strings.TrimPrefix(header[0], utf8BOM)
+	column := -1
+	for i, name := range header {
+		if name == opts.CSVColumn {
+			column = i
+			break
+		}
+	}
+	if column < 0 {
+		return fmt.Errorf("column '%s' not found in CSV header of '%s'", opts.CSVColumn, inputFilepath)
+	}
+
+	output, err := createOutput(outputFilepath, opts)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	if bom {
+		if _, err := io.WriteString(output, utf8BOM); err != nil {
+			return fmt.Errorf("error writing output file '%s': %w", outputFilepath, err)
+		}
+	}
+	writer := csv.NewWriter(output)
+	writer.Write(header)
+
+	var total obufuku.TransformResult
+	var cells int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading CSV '%s': %w", inputFilepath, err)
+		}
+		if column < len(record) && record[column] != "" {
+			line, _ := reader.FieldPos(column)
+			var cell bytes.Buffer
+			opts.audit.setDocument(fmt.Sprintf("%s line %d", inputFilepath, line))
+			result, err := rules.Transform(ctx, strings.NewReader(record[column]), &cell)
+			total.Add(result, fmt.Sprintf("line %d: ", line))
+			if err != nil {
+				printWarnings(total)
+				if aborted(ctx, err) {
+					output.Discard()
+				}
+				return fmt.Errorf("error processing XML in column '%s' at line %d of '%s': %w", opts.CSVColumn, line, inputFilepath, err)
+			}
+			record[column] = cell.String()
+			cells++
+		}
+		writer.Write(record)
+	}
+	printWarnings(total)
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing output file '%s': %w", outputFilepath, err)
+	}
+	files, skipped := []string{outputFilepath}, []string(nil)
+	if skipUnchanged(output, opts) {
+		files, skipped = nil, files
+	} else if err := output.Finish(); err != nil {
+		return err
+	}
+
+	if opts.report != nil {
+		return opts.report.render(runReport{Command: "csv", Rules: ruleFilepath, Inputs: []string{inputFilepath}, Output: outputFilepath, Files: files, Skipped: skipped, TransformResult: total})
+	}
+	fmt.Printf("CSV processing completed. Rules: '%s', Input: '%s', Output: '%s'\n", ruleFilepath, inputFilepath, outputFilepath)
+	fmt.Printf("  Cells transformed: %d (column '%s')\n", cells, opts.CSVColumn)
+	printSkipped(skipped)
+	printResult(total)
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// transformCSV は、設定 cfg で CSV の input の列 column を変換し、出力ファイルの内容を返します。
+func transformCSV(t *testing.T, cfg obufuku.Config, column, input string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	rulePath, inputPath, outputPath := writeRules(t, dir, cfg), writeFile(t, dir, "in.csv", input), filepath.Join(dir, "out.csv")
+	if err := runTransform(context.Background(), rulePath, inputPath, outputPath, transformOptions{CSVColumn: column}); err != nil {
+		return "", err
+	}
+	output, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), nil
+}
+
+func TestTransformCSV(t *testing.T) {
+	cfg := obufuku.Config{
+		NameRules:         []obufuku.ConfigNameRule{{Old: "b", New: "c"}},
+		PrependChildRules: []obufuku.ConfigInsertRule{{Target: "a", Template: "<n>%d</n>", Counter: "n"}},
+		Counters:          map[string]obufuku.ConfigCounter{"n": {}},
+		Output:            obufuku.ConfigOutput{Compact: true},
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "rows",
+			input: "id,xml\n1,<a><b>x</b></a>\n2,\"<a><b>y, z</b></a>\"\n",
+			want:  "id,xml\n1,<a><n>1</n><c>x</c></a>\n2,\"<a><n>2</n><c>y, z</c></a>\"\n",
+		},
+		{
+			name:  "empty and missing cells",
+			input: "id,xml\n1,\n2\n3,<a/>\n",
+			want:  "id,xml\n1,\n2\n3,<a><n>1</n></a>\n",
+		},
+		{
+			name:  "byte order mark",
+			input: utf8BOM + "xml,id\n<a/>,1\n",
+			want:  utf8BOM + "xml,id\n<a><n>1</n></a>,1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := transformCSV(t, cfg, "xml", tt.input)
+			if err != nil {
+				t.Fatalf("runTransform: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformCSVErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    obufuku.Config
+		column string
+		input  string
+	}{
+		{"unknown column", obufuku.Config{}, "missing", "id,xml\n1,<a/>\n"},
+		{"empty input", obufuku.Config{}, "xml", ""},
+		{"malformed cell", obufuku.Config{}, "xml", "id,xml\n1,<a><b></a>\n"},
+		{"output encoding", obufuku.Config{Output: obufuku.ConfigOutput{Encoding: "Shift_JIS"}}, "xml", "xml\n<a/>\n"},
+		{"input encoding", obufuku.Config{Input: obufuku.ConfigInput{Encoding: "Shift_JIS"}}, "xml", "xml\n<a/>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := transformCSV(t, tt.cfg, tt.column, tt.input); err == nil {
+				t.Error("runTransform succeeded, want an error")
+			}
+		})
+	}
+}
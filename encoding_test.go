@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+func TestOutputEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		input    string
+		want     string
+	}{
+		{
+			name:     "shift_jis",
+			encoding: "Shift_JIS",
+			input:    `<?xml version="1.0" encoding="UTF-8"?><a>日本語</a>`,
+			want:     `<?xml version="1.0" encoding="Shift_JIS"?><a>日本語</a>`,
+		},
+		{
+			name:     "lower-case name",
+			encoding: "shift_jis",
+			input:    `<?xml version="1.0"?><a>ｶﾅ</a>`,
+			want:     `<?xml version="1.0" encoding="Shift_JIS"?><a>ｶﾅ</a>`,
+		},
+		{
+			name:     "utf-8",
+			encoding: "utf-8",
+			input:    `<?xml version="1.0"?><a>日本語</a>`,
+			want:     `<?xml version="1.0" encoding="UTF-8"?><a>日本語</a>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := transformFile(t, obufuku.Config{Output: obufuku.ConfigOutput{Compact: true, Encoding: tt.encoding}}, tt.input)
+			if !strings.EqualFold(tt.encoding, "utf-8") {
+				decoded, err := japanese.ShiftJIS.NewDecoder().String(got)
+				if err != nil {
+					t.Fatalf("output is not %s: %v", tt.encoding, err)
+				}
+				got = decoded
+			}
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputEncodingUnknown(t *testing.T) {
+	_, err := tryTransformFile(t, obufuku.Config{Output: obufuku.ConfigOutput{Encoding: "no-such-encoding"}}, "<a/>")
+	if err == nil || !strings.Contains(err.Error(), "no-such-encoding") {
+		t.Errorf("runTransform error = %v, want an unsupported encoding error", err)
+	}
+}
+
+func TestInputEncoding(t *testing.T) {
+	const text = "日本語のテキストとｶﾀｶﾅ"
+	tests := []struct {
+		name     string
+		setting  string
+		encoding encoding.Encoding
+		decl     string
+	}{
+		{"declared shift_jis", "", japanese.ShiftJIS, `<?xml version="1.0" encoding="Shift_JIS"?>`},
+		{"declared euc-jp", "", japanese.EUCJP, `<?xml version="1.0" encoding="EUC-JP"?>`},
+		{"explicit shift_jis", "Shift_JIS", japanese.ShiftJIS, ""},
+		{"explicit overrides declaration", "EUC-JP", japanese.EUCJP, `<?xml version="1.0" encoding="Shift_JIS"?>`},
+		{"auto shift_jis", "auto", japanese.ShiftJIS, ""},
+		{"auto euc-jp", "auto", japanese.EUCJP, ""},
+		{"auto iso-2022-jp", "auto", japanese.ISO2022JP, ""},
+		{"auto utf-8", "auto", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tt.decl + "<a>" + text + "</a>"
+			if tt.encoding != nil {
+				encoded, err := tt.encoding.NewEncoder().String(input)
+				if err != nil {
+					t.Fatal(err)
+				}
+				input = encoded
+			}
+			got := transformFile(t, obufuku.Config{Input: obufuku.ConfigInput{Encoding: tt.setting}, Output: obufuku.ConfigOutput{Compact: true}}, input)
+			if !strings.HasSuffix(got, "<a>"+text+"</a>") {
+				t.Errorf("output = %q, want the text decoded as UTF-8", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// extractOptions は、コマンドラインで指定された extract のオプションです。
+type extractOptions struct {
+	transformOptions
+	// TSV が true の場合、出力ファイル名に関わらずタブ区切りで出力します。
+	TSV bool
+}
+
+// isTSVPath は、出力ファイル名がタブ区切りの拡張子 (.tsv、.tsv.gz) かを返します。
+func isTSVPath(p string) bool {
+	p = strings.TrimSuffix(strings.ToLower(p), ".gz")
+	return strings.HasSuffix(p, ".tsv")
+}
+
+// runExtract は、マッピングファイルに基づいてXMLから要素の値を取り出し、繰り返しの要素ごとに1行の
+// CSV (出力ファイルの拡張子が .tsv の場合はTSV) として出力します。1行目は列名の見出しです。
+func runExtract(ctx context.Context, mappingFilepath, inputFilepath, outputFilepath string, opts extractOptions) error {
+	data, err := readConfigFile(mappingFilepath)
+	if err != nil {
+		return fmt.Errorf("failed to read mapping file '%s': %w", mappingFilepath, err)
+	}
+	var config obufuku.ExtractConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse mapping file '%s': %w", mappingFilepath, err)
+	}
+	if opts.HTML {
+		config.Input.HTML = true
+	}
+	if opts.Secure {
+		config.Input.Secure = true
+	}
+	extractor, err := obufuku.NewExtractor(config)
+	if err != nil {
+		return fmt.Errorf("invalid mapping file '%s': %w", mappingFilepath, err)
+	}
+	if opts.Checksum != "" {
+		if _, err := newChecksumHash(opts.Checksum); err != nil {
+			return err
+		}
+	}
+
+	inputFile, err := openLimitedInput(inputFilepath, opts.MaxInputSize)
+	if err != nil {
+		return fmt.Errorf("error opening input file '%s': %w", inputFilepath, err)
+	}
+	defer inputFile.Close()
+
+	output, err := createOutput(outputFilepath, opts.transformOptions)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	writer := csv.NewWriter(output)
+	if opts.TSV || isTSVPath(outputFilepath) {
+		writer.Comma = '\t'
+	}
+	writer.Write(extractor.Header())
+	records, err := extractor.Extract(ctx, inputFile, func(record []string) error {
+		return writer.Write(record)
+	})
+	if err != nil {
+		if aborted(ctx, err) {
+			output.Discard()
+		}
+		return fmt.Errorf("error processing XML '%s': %w", inputFilepath, err)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing output file '%s': %w", outputFilepath, err)
+	}
+	if err := output.Finish(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Extraction completed. Mapping: '%s', Input: '%s', Output: '%s'\n", mappingFilepath, inputFilepath, outputFilepath)
+	fmt.Printf("  Records: %d\n", records)
+	return nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+	"github.com/hizuheka/go-ObuFuku/obufukupb"
+)
+
+// grpcChunkSize は、変換結果をレスポンスに分割する大きさです。
+const grpcChunkSize = 64 * 1024
+
+// runServeGRPC は、address で gRPC の変換サービスを起動し、ctx が取り消されるまで要求を処理します。
+// rulesDir が空でない場合、リクエストはこのディレクトリのルールファイルを名前で参照できます。
+// manageRules が true の場合は、ルールセットを実行中に登録・有効化するサービスも公開します
+// (rulesDir が空の場合、登録したルールセットはメモリ上に保持します)。
+func runServeGRPC(ctx context.Context, address, rulesDir string, manageRules bool, opts transformOptions) error {
+	// ルールはクライアントから受け取るため、サーバーの環境から値を読み込む設定を許可しない
+	opts.untrusted = true
+	if err := loadPluginDirs(opts); err != nil {
+		return err
+	}
+	var store ruleStore
+	switch {
+	case rulesDir != "":
+		store = newDiskRuleStore(rulesDir)
+	case manageRules:
+		store = newMemoryRuleStore()
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on '%s': %w", address, err)
+	}
+
+	server := grpc.NewServer()
+	obufukupb.RegisterTransformServiceServer(server, &transformServer{store: store, opts: opts})
+	if manageRules {
+		obufukupb.RegisterRuleServiceServer(server, &ruleServer{store: store, opts: opts})
+	}
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	log.Printf("gRPC transform service listening on %s", listener.Addr())
+	if err := server.Serve(listener); err != nil {
+		return fmt.Errorf("gRPC server stopped: %w", err)
+	}
+	return nil
+}
+
+// transformServer は、obufukupb.TransformServiceServer の実装です。
+type transformServer struct {
+	obufukupb.UnimplementedTransformServiceServer
+
+	// store は、名前で参照するルールセットの保存先です (nil の場合は名前で参照できない)。
+	store ruleStore
+	opts  transformOptions
+}
+
+// Transform は、最初のリクエストのルールセットで、続くリクエストのXMLを変換して返します。
+// カウンターはリクエストごとに初期値から数え始めます。
+func (s *transformServer) Transform(stream obufukupb.TransformService_TransformServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	ref := first.GetRules()
+	if ref == nil {
+		return status.Error(codes.InvalidArgument, "the first request must specify the rules")
+	}
+	rules, err := s.loadRules(ref)
+	if err != nil {
+		return transformStatus(err)
+	}
+
+	ctx := stream.Context()
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+		defer cancel()
+	}
+
+	// リクエストの入力を、変換処理が読み込むパイプに流し込む
+	input, inputWriter := io.Pipe()
+	defer input.Close()
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				inputWriter.Close()
+				return
+			}
+			if err != nil {
+				inputWriter.CloseWithError(err)
+				return
+			}
+			if req.GetRules() != nil {
+				inputWriter.CloseWithError(status.Error(codes.InvalidArgument, "the rules can only be specified in the first request"))
+				return
+			}
+			if _, err := inputWriter.Write(req.GetChunk()); err != nil {
+				return
+			}
+		}
+	}()
+
+	output := bufio.NewWriterSize(limitOutput(chunkSender{stream}, "", s.opts.MaxOutputSize), grpcChunkSize)
+	result, err := rules.Transform(ctx, limitInput(input, "", s.opts.MaxInputSize), output)
+	if err == nil {
+		err = output.Flush()
+	}
+	if err != nil {
+		return transformStatus(err)
+	}
+
+	summary := &obufukupb.TransformSummary{
+		Elements:     int64(result.Elements),
+		RuleHits:     make(map[string]int64, len(result.RuleHits)),
+		Warnings:     result.Warnings,
+		BytesRead:    result.BytesRead,
+		BytesWritten: result.BytesWritten,
+	}
+	for name, n := range result.RuleHits {
+		summary.RuleHits[name] = int64(n)
+	}
+	return stream.Send(&obufukupb.TransformResponse{Payload: &obufukupb.TransformResponse_Summary{Summary: summary}})
+}
+
+// loadRules は、リクエストで指定されたルールセットを組み立てます。
+func (s *transformServer) loadRules(ref *obufukupb.RulesReference) (*obufuku.RuleSet, error) {
+	switch source := ref.GetSource().(type) {
+	case *obufukupb.RulesReference_Name:
+		if s.store == nil {
+			return nil, status.Error(codes.FailedPrecondition, "the server has no rules directory; send the rules as config_json")
+		}
+		ruleFile, _, err := s.store.Get(source.Name, ref.GetVersion())
+		if err != nil {
+			return nil, ruleStoreStatus(source.Name, ref.GetVersion(), err)
+		}
+		return parseRuleSet(ruleFile, source.Name, s.opts)
+	case *obufukupb.RulesReference_ConfigJson:
+		return parseRuleSet(source.ConfigJson, "config_json", s.opts)
+	}
+	return nil, status.Error(codes.InvalidArgument, "the rules reference is empty")
+}
+
+// transformStatus は、変換処理のエラーを gRPC のステータスに変換します。
+func transformStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	var (
+		configErr *obufuku.RuleConfigError
+		parseErr  *obufuku.ParseError
+		limitErr  *sizeLimitError
+	)
+	if errors.As(err, &limitErr) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if errors.As(err, &configErr) || errors.As(err, &parseErr) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// chunkSender は、書き込まれたデータを変換結果の chunk としてレスポンスに送る io.Writer です。
+type chunkSender struct {
+	stream obufukupb.TransformService_TransformServer
+}
+
+// Write は io.Writer インターフェースを実装します。
+func (c chunkSender) Write(p []byte) (int, error) {
+	chunk := append([]byte(nil), p...)
+	if err := c.stream.Send(&obufukupb.TransformResponse{Payload: &obufukupb.TransformResponse_Chunk{Chunk: chunk}}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ruleStoreStatus は、ルールストアのエラーを gRPC のステータスに変換します。
+// version は、要求されたバージョンです (0 の場合は有効化されたバージョン)。
+func ruleStoreStatus(name string, version int64, err error) error {
+	if errors.Is(err, errRulesNotFound) {
+		if version == 0 {
+			return status.Errorf(codes.NotFound, "rules '%s' not found or no version is active", name)
+		}
+		return status.Errorf(codes.NotFound, "rules '%s' version %d not found", name, version)
+	}
+	if validateRuleName(name) != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// ruleServer は、obufukupb.RuleServiceServer の実装です。
+type ruleServer struct {
+	obufukupb.UnimplementedRuleServiceServer
+
+	store ruleStore
+	opts  transformOptions
+}
+
+// UploadRules は、ルールを検証してから新しいバージョンとして保存します。
+func (s *ruleServer) UploadRules(ctx context.Context, req *obufukupb.UploadRulesRequest) (*obufukupb.RuleSetVersion, error) {
+	if err := validateRuleName(req.GetName()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := parseRuleSet(req.GetConfigJson(), req.GetName(), s.opts); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	version, err := s.store.Upload(req.GetName(), req.GetConfigJson(), req.GetActivate())
+	if err != nil {
+		return nil, ruleStoreStatus(req.GetName(), 0, err)
+	}
+	log.Printf("rules '%s' version %d uploaded (activated: %t)", req.GetName(), version.Version, req.GetActivate())
+	return ruleVersionMessage(version), nil
+}
+
+// ListRules は、保存されているルールセットの一覧を返します。
+func (s *ruleServer) ListRules(ctx context.Context, req *obufukupb.ListRulesRequest) (*obufukupb.ListRulesResponse, error) {
+	infos, err := s.store.List()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &obufukupb.ListRulesResponse{}
+	for _, info := range infos {
+		resp.RuleSets = append(resp.RuleSets, ruleSetInfoMessage(info))
+	}
+	return resp, nil
+}
+
+// GetRules は、ルールセットのバージョンの内容を返します。
+func (s *ruleServer) GetRules(ctx context.Context, req *obufukupb.GetRulesRequest) (*obufukupb.GetRulesResponse, error) {
+	data, version, err := s.store.Get(req.GetName(), req.GetVersion())
+	if err != nil {
+		return nil, ruleStoreStatus(req.GetName(), req.GetVersion(), err)
+	}
+	return &obufukupb.GetRulesResponse{Name: req.GetName(), Version: version, ConfigJson: data}, nil
+}
+
+// ActivateRules は、ルールセットのバージョンを有効化します。以降の変換リクエストから使われます。
+func (s *ruleServer) ActivateRules(ctx context.Context, req *obufukupb.ActivateRulesRequest) (*obufukupb.RuleSetInfo, error) {
+	info, err := s.store.Activate(req.GetName(), req.GetVersion())
+	if err != nil {
+		return nil, ruleStoreStatus(req.GetName(), req.GetVersion(), err)
+	}
+	log.Printf("rules '%s' version %d activated", req.GetName(), req.GetVersion())
+	return ruleSetInfoMessage(info), nil
+}
+
+// ruleVersionMessage は、ルールセットのバージョンの情報をレスポンスの形式に変換します。
+func ruleVersionMessage(version ruleVersion) *obufukupb.RuleSetVersion {
+	return &obufukupb.RuleSetVersion{
+		Version:    version.Version,
+		UploadedAt: timestamppb.New(version.UploadedAt),
+		Sha256:     version.SHA256,
+	}
+}
+
+// ruleSetInfoMessage は、ルールセットの情報をレスポンスの形式に変換します。
+func ruleSetInfoMessage(info ruleSetInfo) *obufukupb.RuleSetInfo {
+	msg := &obufukupb.RuleSetInfo{Name: info.Name, ActiveVersion: info.ActiveVersion}
+	for _, version := range info.Versions {
+		msg.Versions = append(msg.Versions, ruleVersionMessage(version))
+	}
+	return msg
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// openInput は、入力ファイルを開きます。拡張子 (.gz, .zip) または先頭のマジックバイトから
+// 圧縮形式を判定し、gzip は展開しながら、zip は含まれる1つのXMLファイルを読み込みます。
+// s3://bucket/key 形式のURIの場合は S3 のオブジェクトを、sftp://user@host/path 形式のURIの場合は
+// SFTP サーバー上のファイルを読み込みます。
+func openInput(filename string) (io.ReadCloser, error) {
+	if isS3URI(filename) {
+		return openS3Input(filename)
+	}
+	var file inputFile
+	var err error
+	if isSFTPURI(filename) {
+		file, err = openSFTPFile(filename)
+	} else {
+		file, err = os.Open(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, len(zipMagic))
+	n, err := io.ReadFull(file, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		file.Close()
+		return nil, err
+	}
+	magic = magic[:n]
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// SFTP は1回の読み込みごとにサーバーと往復するため、大きな単位でまとめて読み込む
+	var reader io.Reader = file
+	if isSFTPURI(filename) {
+		reader = bufio.NewReaderSize(file, sftpBufferSize)
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch {
+	case ext == ".gz" || bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read gzip input '%s': %w", filename, err)
+		}
+		return &multiCloser{Reader: gz, closers: []io.Closer{gz, file}}, nil
+
+	case ext == ".zip" || bytes.HasPrefix(magic, zipMagic):
+		entry, err := openSingleZipEntry(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read zip input '%s': %w", filename, err)
+		}
+		return &multiCloser{Reader: entry, closers: []io.Closer{entry, file}}, nil
+
+	default:
+		return &multiCloser{Reader: reader, closers: []io.Closer{file}}, nil
+	}
+}
+
+// inputFile は、圧縮形式の判定と zip の読み込みに必要な、ランダムアクセスできる入力ファイルです。
+type inputFile interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	Stat() (os.FileInfo, error)
+}
+
+// openSingleZipEntry は、zipアーカイブに含まれる唯一のXMLファイルを開きます。
+// ファイルが1つだけならその名前に関わらず、複数あれば拡張子 .xml のものが1つだけの場合に限り選びます。
+func openSingleZipEntry(file inputFile) (io.ReadCloser, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	var files, xmlFiles []*zip.File
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files = append(files, f)
+		if strings.EqualFold(path.Ext(f.Name), ".xml") {
+			xmlFiles = append(xmlFiles, f)
+		}
+	}
+	switch {
+	case len(files) == 1:
+		return files[0].Open()
+	case len(xmlFiles) == 1:
+		return xmlFiles[0].Open()
+	case len(files) == 0:
+		return nil, fmt.Errorf("archive contains no files")
+	default:
+		return nil, fmt.Errorf("archive must contain exactly one XML file (found %d)", len(xmlFiles))
+	}
+}
+
+// multiCloser は、Close 時に複数のリソースを順に閉じるReadCloserです。
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close は io.Closer インターフェースを実装します。
+func (m *multiCloser) Close() error {
+	var firstErr error
+	for _, c := range m.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gzipData は、data を gzip で圧縮したバイト列を返します。
+func gzipData(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipData は、files (名前と内容の組) を格納した zip アーカイブのバイト列を返します。
+func zipData(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(files); i += 2 {
+		w, err := zw.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenInput(t *testing.T) {
+	const doc = "<a/>"
+	tests := []struct {
+		name string
+		file string
+		data []byte
+		err  string
+	}{
+		{"plain", "in.xml", []byte(doc), ""},
+		{"gzip", "in.xml.gz", gzipData(t, doc), ""},
+		{"gzip without extension", "in.xml", gzipData(t, doc), ""},
+		{"zip with one file", "in.zip", zipData(t, "data.txt", doc), ""},
+		{"zip with one xml file", "in.zip", zipData(t, "readme.txt", "x", "doc.xml", doc), ""},
+		{"zip without extension", "in.bin", zipData(t, "doc.xml", doc), ""},
+		{"empty zip", "in.zip", zipData(t), "no files"},
+		{"zip with two xml files", "in.zip", zipData(t, "a.xml", doc, "b.xml", doc), "exactly one XML file"},
+		{"broken gzip", "in.xml.gz", []byte("not gzip"), "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			r, err := openInput(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("openInput error = %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("openInput: %v", err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != doc {
+				t.Errorf("read %q, want %q", got, doc)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// kafkaMaxPollRecords は、1回にまとめて変換・送信・コミットするメッセージの最大数です。
+const kafkaMaxPollRecords = 500
+
+// デッドレターに送るメッセージに付けるヘッダーの名前です。
+const (
+	// kafkaErrorHeader は、変換できなかった理由です。
+	kafkaErrorHeader = "obufuku-error"
+	// kafkaSourceHeader は、元のメッセージの位置 (<トピック>/<パーティション>/<オフセット>) です。
+	kafkaSourceHeader = "obufuku-source"
+)
+
+// kafkaOptions は、serve-kafka の接続先とトピックの設定です。
+type kafkaOptions struct {
+	Brokers []string
+	Group   string
+	// InputTopic は、変換するXMLのメッセージを読み込むトピックです。
+	InputTopic string
+	// OutputTopic は、変換結果を送信するトピックです。
+	OutputTopic string
+	// DeadLetterTopic が空でない場合、変換できないメッセージをこのトピックに送信して処理を続けます。
+	// 空の場合は、変換できないメッセージがあると処理を中断します。
+	DeadLetterTopic string
+}
+
+// runServeKafka は、Kafka の入力トピックのメッセージをルールファイルに基づいて1つずつ変換し、
+// 出力トピックに送信します。ctx が取り消されるまで処理を続けます。
+//
+// メッセージは、変換結果 (またはデッドレター) の送信が完了してからオフセットをコミットするため、
+// 途中で停止しても失われることはありません (再開時に同じメッセージを再び処理することがあります)。
+// 変換結果のメッセージには、元のメッセージのキーとヘッダーを引き継ぎます。
+// カウンターはメッセージをまたいで続けて採番し、persist を指定したカウンターの値はコミットのたびに保存します。
+func runServeKafka(ctx context.Context, ruleFilepath string, kopts kafkaOptions, opts transformOptions) error {
+	rules, err := loadRuleSet(ruleFilepath, opts)
+	if err != nil {
+		return err
+	}
+	if kopts.InputTopic == "" || kopts.OutputTopic == "" {
+		return fmt.Errorf("both an input topic and an output topic are required")
+	}
+	if kopts.InputTopic == kopts.OutputTopic || kopts.InputTopic == kopts.DeadLetterTopic {
+		return fmt.Errorf("the input topic '%s' cannot also be an output topic", kopts.InputTopic)
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(kopts.Brokers...),
+		kgo.ConsumerGroup(kopts.Group),
+		kgo.ConsumeTopics(kopts.InputTopic),
+		kgo.DisableAutoCommit(),
+		// 処理中のメッセージのコミットが済むまで、パーティションの割り当てを変更させない
+		kgo.BlockRebalanceOnPoll(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	defer func() {
+		// 最後に読み込んだメッセージの割り当てを解放してから、グループを抜ける
+		client.AllowRebalance()
+		client.Close()
+	}()
+
+	log.Printf("Kafka transform consuming '%s' (group '%s') and producing to '%s'", kopts.InputTopic, kopts.Group, kopts.OutputTopic)
+	for {
+		fetches := client.PollRecords(ctx, kafkaMaxPollRecords)
+		if ctx.Err() != nil {
+			return nil
+		}
+		var fetchErr error
+		fetches.EachError(func(topic string, partition int32, err error) {
+			if fetchErr == nil {
+				fetchErr = fmt.Errorf("failed to fetch from '%s' partition %d: %w", topic, partition, err)
+			}
+		})
+		if fetchErr != nil {
+			return fetchErr
+		}
+
+		// 取り消されても、読み込んだメッセージは最後まで処理してからコミットする
+		if err := processKafkaRecords(context.WithoutCancel(ctx), client, rules, fetches.Records(), kopts, opts); err != nil {
+			return err
+		}
+		client.AllowRebalance()
+	}
+}
+
+// processKafkaRecords は、records を変換して送信し、送信が完了したらオフセットをコミットします。
+func processKafkaRecords(ctx context.Context, client *kgo.Client, rules *obufuku.RuleSet, records []*kgo.Record, kopts kafkaOptions, opts transformOptions) error {
+	if len(records) == 0 {
+		return nil
+	}
+	outputs := make([]*kgo.Record, 0, len(records))
+	for _, record := range records {
+		output, err := transformKafkaRecord(ctx, rules, record, kopts.OutputTopic, opts)
+		if err == nil {
+			outputs = append(outputs, output)
+			continue
+		}
+
+		source := fmt.Sprintf("%s/%d/%d", record.Topic, record.Partition, record.Offset)
+		if kopts.DeadLetterTopic == "" {
+			return fmt.Errorf("error processing message %s: %w", source, err)
+		}
+		log.Printf("Warning: message %s sent to dead-letter topic '%s': %v", source, kopts.DeadLetterTopic, err)
+		headers := append(append([]kgo.RecordHeader(nil), record.Headers...),
+			kgo.RecordHeader{Key: kafkaErrorHeader, Value: []byte(err.Error())},
+			kgo.RecordHeader{Key: kafkaSourceHeader, Value: []byte(source)},
+		)
+		outputs = append(outputs, &kgo.Record{Topic: kopts.DeadLetterTopic, Key: record.Key, Value: record.Value, Headers: headers})
+	}
+
+	if err := client.ProduceSync(ctx, outputs...).FirstErr(); err != nil {
+		return fmt.Errorf("failed to produce messages: %w", err)
+	}
+	if err := client.CommitRecords(ctx, records...); err != nil {
+		return fmt.Errorf("failed to commit offsets: %w", err)
+	}
+	// コミットしたメッセージまでのカウンターの値を保存し、再開時に続きから採番する
+	return rules.SaveCounters()
+}
+
+// transformKafkaRecord は、メッセージの値を1つのXML文書として変換し、topic に送信するメッセージを作成します。
+// opts の時間と大きさの上限を超えたメッセージは、変換できなかったものとして扱います。
+func transformKafkaRecord(ctx context.Context, rules *obufuku.RuleSet, record *kgo.Record, topic string, opts transformOptions) (*kgo.Record, error) {
+	if limit := opts.MaxInputSize; limit > 0 && int64(len(record.Value)) > limit {
+		return nil, &sizeLimitError{Flag: "max-input-size", Limit: limit}
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	var output bytes.Buffer
+	if _, err := rules.Transform(ctx, bytes.NewReader(record.Value), limitOutput(&output, "", opts.MaxOutputSize)); err != nil {
+		return nil, err
+	}
+	if output.Len() == 0 {
+		return nil, errors.New("message produced no output")
+	}
+	return &kgo.Record{Topic: topic, Key: record.Key, Value: output.Bytes(), Headers: record.Headers}, nil
+}
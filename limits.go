@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// byteSize は、"500MB" や "2GiB" のように単位を付けて指定できるバイト数のフラグです。0 は無制限を表します。
+type byteSize int64
+
+// byteUnits は、byteSize で使える単位と倍率です。長い接尾辞から順に照合し、formatByteSize では大きな単位から使います。
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"TB", 1000 * 1000 * 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"MB", 1000 * 1000}, {"KB", 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// String は flag.Value インターフェースを実装します。
+func (s *byteSize) String() string {
+	if s == nil || *s == 0 {
+		return "0"
+	}
+	return formatByteSize(int64(*s))
+}
+
+// Set は flag.Value インターフェースを実装します。
+func (s *byteSize) Set(value string) error {
+	text := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(text, unit.suffix) {
+			text, multiplier = strings.TrimSpace(strings.TrimSuffix(text, unit.suffix)), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/multiplier {
+		return fmt.Errorf("invalid size '%s' (e.g. 500MB, 2GiB or a number of bytes)", value)
+	}
+	*s = byteSize(n * multiplier)
+	return nil
+}
+
+// formatByteSize は、n を割り切れる最も大きな単位 (2進の単位を優先します) で表した文字列を返します。
+func formatByteSize(n int64) string {
+	for _, unit := range byteUnits {
+		if len(unit.suffix) < 2 || unit.suffix == "B" || n < unit.size || n%unit.size != 0 {
+			continue
+		}
+		suffix := unit.suffix
+		if len(suffix) == 3 {
+			suffix = suffix[:1] + "i" + suffix[2:]
+		}
+		return fmt.Sprintf("%d%s", n/unit.size, suffix)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// sizeLimitError は、入力または出力が --max-input-size / --max-output-size の上限を超えたことを表すエラーです。
+type sizeLimitError struct {
+	// Flag は上限を指定したオプションの名前、Name は入力または出力の名前 (空の場合もある) です。
+	Flag  string
+	Name  string
+	Limit int64
+}
+
+func (e *sizeLimitError) Error() string {
+	what := "input"
+	if e.Flag == "max-output-size" {
+		what = "output"
+	}
+	if e.Name != "" {
+		what += fmt.Sprintf(" '%s'", e.Name)
+	}
+	return fmt.Sprintf("%s exceeds the maximum size of %s (--%s)", what, formatByteSize(e.Limit), e.Flag)
+}
+
+// limitedReader は、limit バイトを超えて読み込もうとすると sizeLimitError を返す io.Reader です。
+type limitedReader struct {
+	r     io.Reader
+	name  string
+	limit int64
+	read  int64
+}
+
+// Read は io.Reader インターフェースを実装します。
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.read > l.limit {
+		return 0, &sizeLimitError{Flag: "max-input-size", Name: l.name, Limit: l.limit}
+	}
+	// 上限を1バイトでも超えれば分かるよう、上限の次のバイトまで読み込む
+	if max := l.limit + 1 - l.read; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return 0, &sizeLimitError{Flag: "max-input-size", Name: l.name, Limit: l.limit}
+	}
+	return n, err
+}
+
+// limitedWriter は、合計で limit バイトを超えて書き込もうとすると sizeLimitError を返す io.Writer です。
+// 上限を超える書き込みは、一部も書き込みません。
+type limitedWriter struct {
+	w       io.Writer
+	name    string
+	limit   int64
+	written int64
+}
+
+// Write は io.Writer インターフェースを実装します。
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.written+int64(len(p)) > l.limit {
+		return 0, &sizeLimitError{Flag: "max-output-size", Name: l.name, Limit: l.limit}
+	}
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	return n, err
+}
+
+// limitInput は、limit が正の場合に r を limit バイトまでしか読み込めないReaderで包みます。
+func limitInput(r io.Reader, name string, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, name: name, limit: limit}
+}
+
+// limitOutput は、limit が正の場合に w に limit バイトまでしか書き込めないWriterで包みます。
+func limitOutput(w io.Writer, name string, limit int64) io.Writer {
+	if limit <= 0 {
+		return w
+	}
+	return &limitedWriter{w: w, name: name, limit: limit}
+}
+
+// checkInputSize は、ローカルの入力ファイルが limit バイトを超えていれば、読み込む前に sizeLimitError を返します。
+// 圧縮された入力の展開後の大きさや、S3・SFTP の入力は、読み込みながら limitInput で検査します。
+func checkInputSize(filename string, limit int64) error {
+	if limit <= 0 || isS3URI(filename) || isSFTPURI(filename) {
+		return nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil || !info.Mode().IsRegular() {
+		// 開けない場合のエラーは、開くときに報告する
+		return nil
+	}
+	if info.Size() > limit {
+		return &sizeLimitError{Flag: "max-input-size", Name: filename, Limit: limit}
+	}
+	return nil
+}
+
+// openLimitedInput は、openInput と同じく入力ファイルを開き、展開後の内容を limit バイト (0 は無制限) までしか
+// 読み込めないようにします。ローカルのファイルが limit バイトを超えている場合は、開く前にエラーにします。
+func openLimitedInput(filename string, limit int64) (io.ReadCloser, error) {
+	if err := checkInputSize(filename, limit); err != nil {
+		return nil, err
+	}
+	file, err := openInput(filename)
+	if err != nil || limit <= 0 {
+		return file, err
+	}
+	return &multiCloser{Reader: limitInput(file, filename, limit), closers: []io.Closer{file}}, nil
+}
+
+// aborted は、処理が取り消されたか時間切れになったか、入力・出力の大きさの上限を超えたために err で
+// 中断したかを判定します。中断した場合、呼び出し側は書きかけの出力を削除します。
+func aborted(ctx context.Context, err error) bool {
+	var limitErr *sizeLimitError
+	return ctx.Err() != nil || errors.As(err, &limitErr)
+}
+
+// addSizeFlags は、入力と出力の大きさの上限のオプションを fs に登録します。
+func addSizeFlags(fs *flag.FlagSet, opts *transformOptions) {
+	fs.Var((*byteSize)(&opts.MaxInputSize), "max-input-size", "abort when the input (after decompression) exceeds this size, e.g. 500MB or 2GiB (0 means no limit)")
+	fs.Var((*byteSize)(&opts.MaxOutputSize), "max-output-size", "abort and remove the incomplete output when it exceeds this size before compression, e.g. 1GB (0 means no limit)")
+}
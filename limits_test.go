@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   int64
+		format string
+	}{
+		{"0", 0, "0"},
+		{"1500", 1500, "1500 bytes"},
+		{"500MB", 500 * 1000 * 1000, "500MB"},
+		{"2GiB", 2 << 30, "2GiB"},
+		{"2gib", 2 << 30, "2GiB"},
+		{"10k", 10 << 10, "10KiB"},
+		{"3 KB", 3000, "3KB"},
+		{"1024B", 1024, "1KiB"},
+	}
+	for _, tt := range tests {
+		var s byteSize
+		if err := s.Set(tt.value); err != nil {
+			t.Errorf("Set(%q): %v", tt.value, err)
+			continue
+		}
+		if int64(s) != tt.want {
+			t.Errorf("Set(%q) = %d, want %d", tt.value, s, tt.want)
+		}
+		if got := s.String(); got != tt.format {
+			t.Errorf("String() of %q = %q, want %q", tt.value, got, tt.format)
+		}
+	}
+	for _, value := range []string{"", "MB", "-1", "1.5GB", "10XB", "9999999TiB"} {
+		var s byteSize
+		if err := s.Set(value); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int64
+		input   string
+		wantErr bool
+	}{
+		{"under", 5, "abcd", false},
+		{"exact", 4, "abcd", false},
+		{"over", 3, "abcd", true},
+		{"unlimited", 0, "abcd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(limitInput(strings.NewReader(tt.input), "in", tt.limit))
+			var limitErr *sizeLimitError
+			if tt.wantErr != errors.As(err, &limitErr) {
+				t.Fatalf("ReadAll error = %v, want a size limit error: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.input {
+				t.Errorf("read %q, want %q", got, tt.input)
+			}
+		})
+	}
+}
+
+func TestLimitedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := limitOutput(&buf, "out", 5)
+	if _, err := io.WriteString(w, "abc"); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	_, err := io.WriteString(w, "def")
+	var limitErr *sizeLimitError
+	if !errors.As(err, &limitErr) || limitErr.Flag != "max-output-size" {
+		t.Fatalf("second write error = %v, want a size limit error", err)
+	}
+	if buf.String() != "abc" {
+		t.Errorf("written %q, want %q (nothing of the rejected write)", buf.String(), "abc")
+	}
+	if _, err := io.WriteString(w, "de"); err != nil {
+		t.Errorf("write up to the limit: %v", err)
+	}
+}
+
+func TestTransformSizeLimits(t *testing.T) {
+	const input = "<a><b>0123456789</b></a>"
+	tests := []struct {
+		name string
+		opts transformOptions
+		flag string
+	}{
+		{"input", transformOptions{MaxInputSize: 10}, "max-input-size"},
+		{"output", transformOptions{MaxOutputSize: 10}, "max-output-size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			rulePath, inputPath, outputPath := writeRules(t, dir, obufuku.Config{}), writeFile(t, dir, "in.xml", input), filepath.Join(dir, "out.xml")
+			err := runTransform(context.Background(), rulePath, inputPath, outputPath, tt.opts)
+			var limitErr *sizeLimitError
+			if !errors.As(err, &limitErr) || limitErr.Flag != tt.flag {
+				t.Fatalf("runTransform error = %v, want a size limit error for --%s", err, tt.flag)
+			}
+			if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+				t.Errorf("the incomplete output was left behind (stat: %v)", err)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	rulePath, inputPath, outputPath := writeRules(t, dir, obufuku.Config{}), writeFile(t, dir, "in.xml", input), filepath.Join(dir, "out.xml")
+	if err := runTransform(context.Background(), rulePath, inputPath, outputPath, transformOptions{MaxInputSize: 1 << 10, MaxOutputSize: 1 << 10}); err != nil {
+		t.Errorf("runTransform under the limits: %v", err)
+	}
+}
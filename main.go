@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
 )
 
 // main関数は、サブコマンドのルーターとして機能します。
@@ -11,7 +20,7 @@ func main() {
 	// サブコマンドが指定されているかチェック
 	if len(os.Args) < 2 {
 		fmt.Fprintf(os.Stderr, "Usage: %s <command> [arguments]\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Available commands: transform\n")
+		fmt.Fprintf(os.Stderr, "Available commands: transform, merge, check, extract, validate-xml, import-xslt, serve-grpc, serve-kafka\n")
 		os.Exit(1)
 	}
 
@@ -21,23 +30,323 @@ func main() {
 	// サブコマンドに応じて処理を分岐
 	switch subcommand {
 	case "transform":
-		// transform コマンドの引数が正しいかチェック (program + transform + rules + input + output = 5)
-		if len(os.Args) != 5 {
-			fmt.Fprintf(os.Stderr, "Usage: %s transform <rules.json> <input.xml> <output.xml>\n", os.Args[0])
+		// transform コマンドのオプションを解析
+		fs := flag.NewFlagSet("transform", flag.ExitOnError)
+		opts := addTransformFlags(fs)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: %s transform [options] <rules.json> <input.xml> <output.xml>\n", os.Args[0])
+			fmt.Fprintf(os.Stderr, "       %s transform [options] <rules.json> <input.zip> <output.zip>\n", os.Args[0])
+			fmt.Fprintf(os.Stderr, "       %s transform --split <path> [options] <rules.json> <input.xml> <output-%%05d.xml>\n", os.Args[0])
+			fmt.Fprintf(os.Stderr, "       %s transform --csv-column <name> [options] <rules.json> <input.csv> <output.csv>\n", os.Args[0])
+			fmt.Fprintf(os.Stderr, "       %s transform --verify-roundtrip [options] <rules.json> <input.xml>\n", os.Args[0])
+			fs.PrintDefaults()
+		}
+		verifyRoundTrip := fs.Bool("verify-roundtrip", false, "transform the input without applying any rules and report every difference between input and output instead of writing a file")
+		fs.StringVar(&opts.Split, "split", "", "transform each element matching this path (e.g. /root/records/record) as a separate document, writing to the output path with %d replaced by the fragment number")
+		fs.StringVar(&opts.CSVColumn, "csv-column", "", "read the input as CSV with a header row and transform the XML in each cell of this column")
+		fs.StringVar(&opts.EmitPatch, "emit-patch", "", "also write a unified diff between the input and the output to this file (single XML file only)")
+		fs.StringVar(&opts.ZipEntries, "zip-entries", defaultZipEntries, "when the output ends in .zip, transform the entries of the input archive whose names match this pattern and copy the others unchanged")
+		addReportFlags(fs, opts)
+		prof := addProfileFlags(fs, false)
+		fs.Parse(os.Args[2:])
+		stopProfiling := mustStartProfiling(*prof)
+		defer stopProfiling()
+
+		// 往復検証では出力ファイルを指定しない (rules + input = 2)
+		ctx, cancel := commandContext(opts.Timeout)
+		defer cancel()
+		if *verifyRoundTrip {
+			if fs.NArg() != 2 {
+				fs.Usage()
+				os.Exit(1)
+			}
+			if err := runVerifyRoundTrip(ctx, fs.Arg(0), fs.Arg(1), *opts); err != nil {
+				fatal("Error during round-trip verification", err)
+			}
+			break
+		}
+
+		// transform コマンドの引数が正しいかチェック (rules + input + output = 3)
+		if fs.NArg() != 3 {
+			fs.Usage()
 			os.Exit(1)
 		}
-		ruleFilepath := os.Args[2]
-		inputFilepath := os.Args[3]
-		outputFilepath := os.Args[4]
+		ruleFilepath := fs.Arg(0)
+		inputFilepath := fs.Arg(1)
+		outputFilepath := fs.Arg(2)
 
 		// XML変換処理を実行
-		if err := runTransform(ruleFilepath, inputFilepath, outputFilepath); err != nil {
-			log.Fatalf("Error during transform: %v", err)
+		if err := runTransform(ctx, ruleFilepath, inputFilepath, outputFilepath, *opts); err != nil {
+			fatal("Error during transform", err)
+		}
+
+	case "merge":
+		// merge コマンドのオプションを解析
+		fs := flag.NewFlagSet("merge", flag.ExitOnError)
+		opts := addTransformFlags(fs)
+		root := fs.String("root", "documents", "name of the container element that wraps each input's root element")
+		addReportFlags(fs, opts)
+		prof := addProfileFlags(fs, false)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: %s merge [options] <rules.json> <output.xml> <input.xml>...\n", os.Args[0])
+			fs.PrintDefaults()
+		}
+		fs.Parse(os.Args[2:])
+
+		// merge コマンドの引数が正しいかチェック (rules + output + 1つ以上の input)
+		if fs.NArg() < 3 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		ruleFilepath := fs.Arg(0)
+		outputFilepath := fs.Arg(1)
+		inputFilepaths := fs.Args()[2:]
+
+		// XML結合処理を実行
+		stopProfiling := mustStartProfiling(*prof)
+		defer stopProfiling()
+		ctx, cancel := commandContext(opts.Timeout)
+		defer cancel()
+		if err := runMerge(ctx, ruleFilepath, inputFilepaths, outputFilepath, *root, *opts); err != nil {
+			fatal("Error during merge", err)
+		}
+
+	case "check":
+		// check コマンドのオプションを解析
+		fs := flag.NewFlagSet("check", flag.ExitOnError)
+		secure := fs.Bool("secure", false, "report DOCTYPE declarations that reference external DTDs")
+		maxProblems := fs.Int("max-problems", 100, "stop checking a file after this many problems")
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: %s check [options] <input.xml>...\n", os.Args[0])
+			fs.PrintDefaults()
+		}
+		fs.Parse(os.Args[2:])
+
+		// check コマンドの引数が正しいかチェック (1つ以上の input)
+		if fs.NArg() < 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		// 整形式の検査を実行
+		if err := runCheck(fs.Args(), *secure, *maxProblems); err != nil {
+			fatal("Error during check", err)
+		}
+
+	case "extract":
+		// extract コマンドのオプションを解析
+		fs := flag.NewFlagSet("extract", flag.ExitOnError)
+		opts := &extractOptions{}
+		fs.BoolVar(&opts.HTML, "html", false, "read HTML or almost-XML input with an HTML tokenizer (unquoted attributes, void and unclosed tags, script/style text, HTML entities)")
+		fs.BoolVar(&opts.Secure, "secure", false, "reject DOCTYPE declarations that reference external DTDs or external entities")
+		fs.StringVar(&opts.Checksum, "checksum", "", "write a checksum sidecar file for the output (md5, sha1, sha256, sha512)")
+		fs.BoolVar(&opts.Compress, "compress", false, "gzip-compress the output (implied when the output path ends in .gz)")
+		fs.BoolVar(&opts.TSV, "tsv", false, "write tab-separated values (implied when the output path ends in .tsv)")
+		fs.DurationVar(&opts.Timeout, "timeout", 0, "abort and remove the incomplete output after this duration (e.g. 90m; 0 means no limit)")
+		addSizeFlags(fs, &opts.transformOptions)
+		prof := addProfileFlags(fs, false)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: %s extract [options] <mapping.json> <input.xml> <output.csv>\n", os.Args[0])
+			fs.PrintDefaults()
+		}
+		fs.Parse(os.Args[2:])
+
+		// extract コマンドの引数が正しいかチェック (mapping + input + output = 3)
+		if fs.NArg() != 3 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		// 値の取り出しを実行
+		stopProfiling := mustStartProfiling(*prof)
+		defer stopProfiling()
+		ctx, cancel := commandContext(opts.Timeout)
+		defer cancel()
+		if err := runExtract(ctx, fs.Arg(0), fs.Arg(1), fs.Arg(2), *opts); err != nil {
+			fatal("Error during extract", err)
+		}
+
+	case "validate-xml":
+		// validate-xml コマンドのオプションを解析
+		fs := flag.NewFlagSet("validate-xml", flag.ExitOnError)
+		xsd := fs.String("xsd", "", "XML Schema (.xsd) to validate against (requires xmllint)")
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: %s validate-xml --xsd <schema.xsd> <file.xml>...\n", os.Args[0])
+			fs.PrintDefaults()
+		}
+		fs.Parse(os.Args[2:])
+
+		// validate-xml コマンドの引数が正しいかチェック (スキーマと1つ以上のファイル)
+		if *xsd == "" || fs.NArg() < 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		// XML Schema による検証を実行
+		if err := runValidateXML(*xsd, fs.Args()); err != nil {
+			fatal("Error during validate-xml", err)
+		}
+
+	case "import-xslt":
+		// import-xslt コマンドのオプションを解析
+		fs := flag.NewFlagSet("import-xslt", flag.ExitOnError)
+		strict := fs.Bool("strict", false, "fail without writing rules when any construct cannot be translated")
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: %s import-xslt [options] <stylesheet.xsl> [<rules.json>]\n", os.Args[0])
+			fs.PrintDefaults()
+		}
+		fs.Parse(os.Args[2:])
+
+		// import-xslt コマンドの引数が正しいかチェック (stylesheet と省略可能な出力先)
+		if fs.NArg() < 1 || fs.NArg() > 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		// スタイルシートの変換を実行
+		if err := runImportXSLT(fs.Arg(0), fs.Arg(1), *strict); err != nil {
+			fatal("Error during import-xslt", err)
+		}
+
+	case "serve-grpc":
+		// serve-grpc コマンドのオプションを解析
+		fs := flag.NewFlagSet("serve-grpc", flag.ExitOnError)
+		opts := &transformOptions{}
+		listen := fs.String("listen", ":50051", "address to listen on")
+		rulesDir := fs.String("rules-dir", "", "directory of rule files (<name>.json) that requests can refer to by name")
+		manageRules := fs.Bool("manage-rules", false, "expose the rule management service to upload, list and activate rule set versions (kept in memory without --rules-dir)")
+		fs.BoolVar(&opts.Secure, "secure", false, "reject DOCTYPE declarations that reference external DTDs or external entities")
+		fs.StringVar(&opts.Plugins, "plugins", "", "directory of Go plugins (*.so) that register custom value rule types")
+		fs.StringVar(&opts.WASMPlugins, "wasm-plugins", "", "directory of sandboxed WebAssembly modules (*.wasm) registered as value rule types named after their files")
+		fs.DurationVar(&opts.Timeout, "timeout", 0, "abort a request that takes longer than this duration (0 means no limit)")
+		addSizeFlags(fs, opts)
+		prof := addProfileFlags(fs, true)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: %s serve-grpc [options]\n", os.Args[0])
+			fs.PrintDefaults()
+		}
+		fs.Parse(os.Args[2:])
+
+		// serve-grpc コマンドは引数を取らない
+		if fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+
+		// gRPC サービスを起動
+		stopProfiling := mustStartProfiling(*prof)
+		defer stopProfiling()
+		ctx, cancel := commandContext(0)
+		defer cancel()
+		if err := runServeGRPC(ctx, *listen, *rulesDir, *manageRules, *opts); err != nil {
+			fatal("Error during serve-grpc", err)
+		}
+
+	case "serve-kafka":
+		// serve-kafka コマンドのオプションを解析
+		fs := flag.NewFlagSet("serve-kafka", flag.ExitOnError)
+		opts := &transformOptions{}
+		kopts := kafkaOptions{}
+		brokers := fs.String("brokers", "localhost:9092", "comma-separated list of Kafka brokers")
+		fs.StringVar(&kopts.Group, "group", "go-obufuku", "consumer group that tracks the processed messages")
+		fs.StringVar(&kopts.InputTopic, "input-topic", "", "topic to read XML messages from (required)")
+		fs.StringVar(&kopts.OutputTopic, "output-topic", "", "topic to write transformed messages to (required)")
+		fs.StringVar(&kopts.DeadLetterTopic, "dead-letter-topic", "", "topic to write messages that cannot be transformed to (without it, such a message stops processing)")
+		fs.BoolVar(&opts.Canonical, "canonical", false, "output Exclusive XML Canonicalization (C14N) form")
+		fs.BoolVar(&opts.HTML, "html", false, "read HTML or almost-XML input with an HTML tokenizer (unquoted attributes, void and unclosed tags, script/style text, HTML entities)")
+		fs.BoolVar(&opts.Secure, "secure", false, "reject DOCTYPE declarations that reference external DTDs or external entities")
+		fs.StringVar(&opts.Plugins, "plugins", "", "directory of Go plugins (*.so) that register custom value rule types")
+		fs.StringVar(&opts.WASMPlugins, "wasm-plugins", "", "directory of sandboxed WebAssembly modules (*.wasm) registered as value rule types named after their files")
+		fs.DurationVar(&opts.Timeout, "timeout", 0, "treat a message whose transformation takes longer than this duration as failed (0 means no limit)")
+		addSizeFlags(fs, opts)
+		prof := addProfileFlags(fs, true)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: %s serve-kafka [options] <rules.json>\n", os.Args[0])
+			fs.PrintDefaults()
+		}
+		fs.Parse(os.Args[2:])
+
+		// serve-kafka コマンドはルールファイルを引数に取る
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		kopts.Brokers = strings.Split(*brokers, ",")
+
+		// メッセージの変換を開始
+		stopProfiling := mustStartProfiling(*prof)
+		defer stopProfiling()
+		ctx, cancel := commandContext(0)
+		defer cancel()
+		if err := runServeKafka(ctx, fs.Arg(0), kopts, *opts); err != nil {
+			fatal("Error during serve-kafka", err)
 		}
 
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: '%s'\n", subcommand)
-		fmt.Fprintf(os.Stderr, "Available commands: transform\n")
+		fmt.Fprintf(os.Stderr, "Available commands: transform, merge, check, extract, validate-xml, import-xslt, serve-grpc, serve-kafka\n")
 		os.Exit(1)
 	}
 }
+
+// fatal は、エラーを出力し、エラーの種類に応じた終了コードで終了します。
+// 終了コードは、ルールの設定の誤りが 3、入力のXMLの誤りが 4、変換・出力の失敗が 5、その他が 1 です。
+func fatal(prefix string, err error) {
+	log.Printf("%s: %v", prefix, err)
+	var (
+		configErr *obufuku.RuleConfigError
+		parseErr  *obufuku.ParseError
+		encodeErr *obufuku.EncodeError
+	)
+	switch {
+	case errors.As(err, &configErr):
+		os.Exit(3)
+	case errors.As(err, &parseErr):
+		os.Exit(4)
+	case errors.As(err, &encodeErr):
+		os.Exit(5)
+	}
+	os.Exit(1)
+}
+
+// mustStartProfiling は、プロファイルの取得を始めます。始められない場合はエラーを出力して終了します。
+func mustStartProfiling(p profileOptions) func() {
+	stop, err := startProfiling(p)
+	if err != nil {
+		fatal("Error starting profiling", err)
+	}
+	return stop
+}
+
+// addTransformFlags は、変換結果の出力に関する共通のオプションを fs に登録します。
+// 返された transformOptions には、fs.Parse の後に値が設定されます。
+func addTransformFlags(fs *flag.FlagSet) *transformOptions {
+	opts := &transformOptions{}
+	fs.BoolVar(&opts.Canonical, "canonical", false, "output Exclusive XML Canonicalization (C14N) form")
+	fs.BoolVar(&opts.HTML, "html", false, "read HTML or almost-XML input with an HTML tokenizer (unquoted attributes, void and unclosed tags, script/style text, HTML entities)")
+	fs.BoolVar(&opts.Secure, "secure", false, "reject DOCTYPE declarations that reference external DTDs or external entities")
+	fs.StringVar(&opts.Checksum, "checksum", "", "write a checksum sidecar file for the output (md5, sha1, sha256, sha512)")
+	fs.BoolVar(&opts.Compress, "compress", false, "gzip-compress the output (implied when the output path ends in .gz)")
+	fs.StringVar(&opts.Plugins, "plugins", "", "directory of Go plugins (*.so) that register custom value rule types")
+	fs.StringVar(&opts.WASMPlugins, "wasm-plugins", "", "directory of sandboxed WebAssembly modules (*.wasm) registered as value rule types named after their files")
+	fs.StringVar(&opts.ValidateOutput, "validate-output", "", "validate the output against this XML Schema (requires xmllint) and fail on violations")
+	fs.BoolVar(&opts.ValidateWarn, "validate-warn", false, "with --validate-output, report violations as warnings instead of failing")
+	fs.BoolVar(&opts.SkipUnchanged, "skip-unchanged", false, "do not rewrite the output when no rule matched, leaving an existing output file and its modification time untouched (reported as skipped)")
+	fs.StringVar(&opts.Audit, "audit", "", "record every change made by the rules (rule, element path, input offset/line/column, old and new value) in this file as JSON Lines")
+	fs.DurationVar(&opts.Timeout, "timeout", 0, "abort and remove the incomplete output after this duration (e.g. 90m; 0 means no limit)")
+	addSizeFlags(fs, opts)
+	return opts
+}
+
+// commandContext は、SIGINT・SIGTERM を受け取るか、timeout (0 は無制限) を過ぎると取り消されるコンテキストを返します。
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
@@ -0,0 +1,94 @@
+package obufuku
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// base64_encode と base64_decode の文字セット (params["alphabet"]) です。
+const (
+	base64Standard = "standard"
+	base64URL      = "url"
+)
+
+// base64Encoding は、params の "alphabet" ("standard" (既定) または "url") と "padding" (既定は true) から
+// base64 の符号化方式を返します。
+func base64Encoding(kind string, params map[string]interface{}) (*base64.Encoding, error) {
+	alphabet := base64Standard
+	if v, ok := params["alphabet"]; ok {
+		if alphabet, ok = v.(string); !ok {
+			return nil, fmt.Errorf("invalid 'alphabet' for %s rule: must be a string", kind)
+		}
+	}
+	padding := true
+	if v, ok := params["padding"]; ok {
+		if padding, ok = v.(bool); !ok {
+			return nil, fmt.Errorf("invalid 'padding' for %s rule: must be a boolean", kind)
+		}
+	}
+	var enc *base64.Encoding
+	switch alphabet {
+	case base64Standard:
+		enc = base64.StdEncoding
+	case base64URL:
+		enc = base64.URLEncoding
+	default:
+		return nil, fmt.Errorf("unknown 'alphabet' for %s rule: '%s' (available: %s, %s)", kind, alphabet, base64Standard, base64URL)
+	}
+	if !padding {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+	return enc, nil
+}
+
+// newBase64EncodeFunc は、値 (UTF-8 のバイト列) を base64 で符号化する置換関数を作成します。
+func newBase64EncodeFunc(params map[string]interface{}) (ValueReplaceFunc, error) {
+	enc, err := base64Encoding("base64_encode", params)
+	if err != nil {
+		return nil, err
+	}
+	return func(oldValue string) string {
+		return enc.EncodeToString([]byte(oldValue))
+	}, nil
+}
+
+// newBase64DecodeFunc は、base64 で符号化された値を復号する置換関数を作成します。
+// 値の中の空白 (折り返しの改行など) は無視し、パディングの有無はどちらも受け付けます。
+// 復号できない場合と、復号した結果が XML に含められる UTF-8 のテキストでない場合は、params["on_error"] に従って
+// 変換を中断するか ("error"、既定)、値をそのまま残します ("keep")。
+func newBase64DecodeFunc(params map[string]interface{}) (ValueContextFunc, error) {
+	enc, err := base64Encoding("base64_decode", params)
+	if err != nil {
+		return nil, err
+	}
+	onError, ok := params["on_error"].(string)
+	if _, set := params["on_error"]; set && !ok {
+		return nil, fmt.Errorf("invalid 'on_error' for base64_decode rule: must be a string")
+	}
+	keep, err := parseOnError(onError)
+	if err != nil {
+		return nil, err
+	}
+	enc = enc.WithPadding(base64.NoPadding)
+	return func(oldValue string, ctx ValueContext) (string, error) {
+		s := strings.TrimRight(strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, oldValue), "=")
+		decoded, err := enc.DecodeString(s)
+		if err == nil {
+			err = validateText("decoded value", string(decoded))
+		}
+		if err != nil {
+			if keep {
+				return oldValue, nil
+			}
+			return "", fmt.Errorf("invalid base64 value: %w", err)
+		}
+		return string(decoded), nil
+	}, nil
+}
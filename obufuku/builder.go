@@ -0,0 +1,280 @@
+package obufuku
+
+// Builder は、ルールファイルを書く代わりにGoのコードで RuleSet を組み立てるためのビルダーです。
+// 各メソッドはルールを追加して自身を返すため、呼び出しを連結できます。
+//
+//	rules, err := obufuku.NewBuilder().
+//		RenameTag("a", "b").
+//		InsertBefore("item", "<note/>").
+//		Build()
+//
+// ルールはルールファイルと同じ順に適用され、検証は Build で NewRuleSet と同じく行われます。
+type Builder struct {
+	config Config
+}
+
+// NewBuilder は、ルールが空の Builder を作成します。
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// RenameTag は、タグ名 old を new に置換するルールを追加します (name_rules)。
+func (b *Builder) RenameTag(old, new string) *Builder {
+	b.config.NameRules = append(b.config.NameRules, ConfigNameRule{Old: old, New: new})
+	return b
+}
+
+// InsertBefore は、タグ target の要素の前に template を挿入するルールを追加します (insert_rules)。
+func (b *Builder) InsertBefore(target, template string) *Builder {
+	return b.InsertBeforeCounted(target, template, "")
+}
+
+// InsertBeforeCounted は、InsertBefore と同じですが、template の %d をカウンター counter の値で置き換えます。
+func (b *Builder) InsertBeforeCounted(target, template, counter string) *Builder {
+	b.config.InsertRules = append(b.config.InsertRules, ConfigInsertRule{Target: target, Template: template, Counter: counter})
+	return b
+}
+
+// InsertAfter は、タグ target の要素の後に template を挿入するルールを追加します (insert_after_rules)。
+func (b *Builder) InsertAfter(target, template string) *Builder {
+	return b.InsertAfterCounted(target, template, "")
+}
+
+// InsertAfterCounted は、InsertAfter と同じですが、template の %d をカウンター counter の値で置き換えます。
+func (b *Builder) InsertAfterCounted(target, template, counter string) *Builder {
+	b.config.InsertAfterRules = append(b.config.InsertAfterRules, ConfigInsertRule{Target: target, Template: template, Counter: counter})
+	return b
+}
+
+// PrependChild は、タグ target の要素の子の先頭に template を挿入するルールを追加します (prepend_child_rules)。
+func (b *Builder) PrependChild(target, template string) *Builder {
+	return b.PrependChildCounted(target, template, "")
+}
+
+// PrependChildCounted は、PrependChild と同じですが、template の %d をカウンター counter の値で置き換えます。
+func (b *Builder) PrependChildCounted(target, template, counter string) *Builder {
+	b.config.PrependChildRules = append(b.config.PrependChildRules, ConfigInsertRule{Target: target, Template: template, Counter: counter})
+	return b
+}
+
+// ReplaceValue は、タグ target の値を種類 typ の置換で書き換えるルールを追加します (value_rules)。
+// typ には ValueFuncs が返す名前を指定します。
+func (b *Builder) ReplaceValue(target, typ string, params map[string]interface{}) *Builder {
+	b.config.ValueRules = append(b.config.ValueRules, ConfigValueRule{Target: target, Type: typ, Params: params})
+	return b
+}
+
+// Prepend は、タグ target の値の先頭に prefix を付けるルールを追加します。
+func (b *Builder) Prepend(target, prefix string) *Builder {
+	return b.ReplaceValue(target, "prepend", map[string]interface{}{"prefix": prefix})
+}
+
+// Append は、タグ target の値の末尾に suffix を付けるルールを追加します。
+func (b *Builder) Append(target, suffix string) *Builder {
+	return b.ReplaceValue(target, "append", map[string]interface{}{"suffix": suffix})
+}
+
+// Script は、タグ target の値を式 expr の評価結果に置き換えるルールを追加します。
+func (b *Builder) Script(target, expr string) *Builder {
+	return b.ReplaceValue(target, scriptValueType, map[string]interface{}{"expr": expr})
+}
+
+// NormalizeDates は、タグ targets の要素の日付 (和暦を含む) を format の形式 (空の場合は 2006-01-02) に
+// そろえるルールを追加します (date_rules)。入力の形式は既定のもの、解釈できない日付はエラーになります。
+func (b *Builder) NormalizeDates(format string, targets ...string) *Builder {
+	b.config.DateRules = append(b.config.DateRules, ConfigDateRule{Targets: targets, Format: format})
+	return b
+}
+
+// NormalizeUnicode は、タグ target の要素 (target が空の場合はすべての要素) のテキストと属性値を
+// 正規化形式 form (NFC、NFD、NFKC、NFKD) にするルールを追加します (unicode_rules)。
+func (b *Builder) NormalizeUnicode(target, form string) *Builder {
+	b.config.UnicodeRules = append(b.config.UnicodeRules, ConfigUnicodeRule{Target: target, Form: form})
+	return b
+}
+
+// Wrap は、タグ target の要素の子をタグ wrapper の要素で囲むルールを追加します (wrap_rules)。
+func (b *Builder) Wrap(target, wrapper string) *Builder {
+	b.config.WrapRules = append(b.config.WrapRules, ConfigWrapRule{Target: target, Wrapper: wrapper})
+	return b
+}
+
+// ReplaceCDATA は、raw タグの中身の old を new に置換するルールを追加します (cdata_rules)。
+func (b *Builder) ReplaceCDATA(old, new string) *Builder {
+	b.config.CdataRules = append(b.config.CdataRules, ConfigCdataRule{Old: old, New: new})
+	return b
+}
+
+// Capture は、タグ target の要素のテキストを変数 variable に取り込むルールを追加します (capture_rules)。
+func (b *Builder) Capture(target, variable string) *Builder {
+	b.config.CaptureRules = append(b.config.CaptureRules, ConfigCaptureRule{Target: target, Variable: variable})
+	return b
+}
+
+// Summary は、タグ target の要素を function ("count" または "sum") で集計し、
+// ルート要素の子の末尾にタグ element の要素として挿入するルールを追加します (summary_rules)。
+func (b *Builder) Summary(target, element, function string) *Builder {
+	b.config.SummaryRules = append(b.config.SummaryRules, ConfigSummaryRule{Target: target, Element: element, Function: function})
+	return b
+}
+
+// Delete は、タグ target の要素を削除するルールを追加します (delete_rules)。
+func (b *Builder) Delete(target string) *Builder {
+	b.config.DeleteRules = append(b.config.DeleteRules, ConfigDeleteRule{Target: target})
+	return b
+}
+
+// DeleteMatching は、タグ target の要素をテキストが正規表現 pattern に一致する場合に削除するルールを追加します (delete_rules)。
+func (b *Builder) DeleteMatching(target, pattern string) *Builder {
+	b.config.DeleteRules = append(b.config.DeleteRules, ConfigDeleteRule{Target: target, Pattern: pattern})
+	return b
+}
+
+// DeleteIfEmpty は、タグ target の要素をテキストも子要素も無い場合に削除するルールを追加します (delete_rules)。
+func (b *Builder) DeleteIfEmpty(target string) *Builder {
+	b.config.DeleteRules = append(b.config.DeleteRules, ConfigDeleteRule{Target: target, IfEmpty: true})
+	return b
+}
+
+// Dedupe は、タグ parent の要素の子要素 (child が空でなければそのタグ名のもの) のうち、
+// 直前の子要素と同じ内容のものを削除するルールを追加します (dedupe_rules)。all が true の場合は、連続していない重複も削除します。
+func (b *Builder) Dedupe(parent, child string, all bool) *Builder {
+	scope := dedupeConsecutive
+	if all {
+		scope = dedupeAll
+	}
+	b.config.DedupeRules = append(b.config.DedupeRules, ConfigDedupeRule{Parent: parent, Child: child, Scope: scope})
+	return b
+}
+
+// Reorder は、タグ target の要素の子要素を order のタグ名の順に並べ替えるルールを追加します (reorder_rules)。
+// order に無いタグ名の子要素は末尾に並べます。
+func (b *Builder) Reorder(target string, order ...string) *Builder {
+	b.config.ReorderRules = append(b.config.ReorderRules, ConfigReorderRule{Target: target, Order: order})
+	return b
+}
+
+// Assert は、タグ target の要素が子要素 children と属性 attributes をすべて持つことを検査するルールを追加します (assert_rules)。
+// fail が true の場合は、違反を警告ではなくエラーにします。
+func (b *Builder) Assert(target string, children, attributes []string, fail bool) *Builder {
+	b.config.AssertRules = append(b.config.AssertRules, ConfigAssertRule{Target: target, Children: children, Attributes: attributes, Fail: fail})
+	return b
+}
+
+// NormalizeCase は、タグ under の要素とその子孫 (under が空の場合は文書全体) のタグ名を
+// 形 c ("lower"、"upper" または "camel") に統一するルールを追加します (case_rules)。
+func (b *Builder) NormalizeCase(c, under string) *Builder {
+	b.config.CaseRules = append(b.config.CaseRules, ConfigCaseRule{Case: c, Under: under})
+	return b
+}
+
+// RenamePrefix は、名前空間の接頭辞 old を new に書き換えるルールを追加します (prefix_rules)。
+func (b *Builder) RenamePrefix(old, new string) *Builder {
+	b.config.PrefixRules = append(b.config.PrefixRules, ConfigPrefixRule{Old: old, New: new})
+	return b
+}
+
+// RenameNamespace は、名前空間URI old を new に書き換えるルールを追加します (namespace_rules)。
+func (b *Builder) RenameNamespace(old, new string) *Builder {
+	b.config.NamespaceRules = append(b.config.NamespaceRules, ConfigNamespaceRule{Old: old, New: new})
+	return b
+}
+
+// ReplaceRoot は、タグ target のルート要素 (target が空の場合はすべてのルート要素) の名前を name にし
+// (name が空の場合は変えません)、attributes の属性を設定するルールを追加します (root_rules)。
+// replace が true の場合は、名前空間の宣言以外の元の属性を削除します。
+func (b *Builder) ReplaceRoot(target, name string, attributes map[string]string, replace bool) *Builder {
+	b.config.RootRules = append(b.config.RootRules, ConfigRootRule{Target: target, Name: name, Attributes: attributes, Replace: replace})
+	return b
+}
+
+// SchemaLocation は、タグ target の要素 (target が空の場合はルート要素) に、名前空間 namespace のスキーマの場所
+// location を設定するルールを追加します (schema_location_rules)。namespace が空の場合は xsi:noNamespaceSchemaLocation を設定します。
+func (b *Builder) SchemaLocation(target, namespace, location string) *Builder {
+	b.config.SchemaLocationRules = append(b.config.SchemaLocationRules, ConfigSchemaLocationRule{Target: target, Namespace: namespace, Location: location})
+	return b
+}
+
+// AddNil は、タグ target の要素が空の場合に xsi:nil="true" を加えるルールを追加します (nil_rules)。
+func (b *Builder) AddNil(target string) *Builder {
+	b.config.NilRules = append(b.config.NilRules, ConfigNilRule{Target: target, Mode: nilAdd})
+	return b
+}
+
+// StripNil は、タグ target の要素の xsi:nil="true" を削除して空の要素にするルールを追加します (nil_rules)。
+func (b *Builder) StripNil(target string) *Builder {
+	b.config.NilRules = append(b.config.NilRules, ConfigNilRule{Target: target, Mode: nilStrip})
+	return b
+}
+
+// SetDefaultNamespace は、タグ target のルート要素 (target が空の場合はすべてのルート要素) の既定の名前空間を
+// uri にするルールを追加します (default_namespace_rules)。scrub が true の場合は、子孫の既定の名前空間の宣言も削除します。
+func (b *Builder) SetDefaultNamespace(target, uri string, scrub bool) *Builder {
+	b.config.DefaultNamespaceRules = append(b.config.DefaultNamespaceRules, ConfigDefaultNamespaceRule{Target: target, URI: uri, Scrub: scrub})
+	return b
+}
+
+// UnquoteAttributes は、タグ target の要素 (target が空の場合はすべての要素) の属性 attributes
+// (省略した場合はすべての属性) の値から、全体を囲む余分なダブルクォートを削除するルールを追加します (attr_cleanup_rules)。
+func (b *Builder) UnquoteAttributes(target string, attributes ...string) *Builder {
+	b.config.AttrCleanupRules = append(b.config.AttrCleanupRules, ConfigAttrCleanupRule{Target: target, Attributes: attributes})
+	return b
+}
+
+// DeleteAttributes は、タグ target の要素 (target が空の場合はすべての要素) から、名前がパターン patterns
+// ("data-*" など) のいずれかに一致する属性を削除するルールを追加します (attr_delete_rules)。
+func (b *Builder) DeleteAttributes(target string, patterns ...string) *Builder {
+	b.config.AttrDeleteRules = append(b.config.AttrDeleteRules, ConfigAttrDeleteRule{Target: target, Patterns: patterns})
+	return b
+}
+
+// RawTags は、中身をCDATAとしてそのまま出力するタグを追加します (raw_tags)。
+func (b *Builder) RawTags(tags ...string) *Builder {
+	b.config.RawTags = append(b.config.RawTags, tags...)
+	return b
+}
+
+// Counter は、開始値 start のカウンター name を定義します (counters)。
+func (b *Builder) Counter(name string, start int) *Builder {
+	if b.config.Counters == nil {
+		b.config.Counters = make(map[string]ConfigCounter)
+	}
+	b.config.Counters[name] = ConfigCounter{Start: start}
+	return b
+}
+
+// Filters は、組み込みのフィルター (ルールの種類) を適用する順序を指定します (filters)。
+func (b *Builder) Filters(names ...string) *Builder {
+	b.config.Filters = append(b.config.Filters, names...)
+	return b
+}
+
+// Pass は、これまでのルール (と先に追加したパス) の出力に続けて適用するパスとして、pass のルールを追加します (passes)。
+// pass には入出力設定を指定できません。
+func (b *Builder) Pass(pass *Builder) *Builder {
+	b.config.Passes = append(b.config.Passes, pass.Config())
+	return b
+}
+
+// Input は、入力の読み込みに関する設定を指定します。
+func (b *Builder) Input(input ConfigInput) *Builder {
+	b.config.Input = input
+	return b
+}
+
+// Output は、出力形式に関する設定を指定します。
+func (b *Builder) Output(output ConfigOutput) *Builder {
+	b.config.Output = output
+	return b
+}
+
+// Config は、これまでに追加したルールをルールファイルと同じ形式の設定として返します。
+// json.Marshal すると、ルールファイルとして保存できます。
+func (b *Builder) Config() Config {
+	return b.config
+}
+
+// Build は、設定を検証して RuleSet を組み立てます。
+func (b *Builder) Build() (*RuleSet, error) {
+	return NewRuleSet(b.config)
+}
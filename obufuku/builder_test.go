@@ -0,0 +1,79 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	rules, err := NewBuilder().
+		RenameTag("b", "c").
+		Append("c", "!").
+		InsertAfter("b", "<n/>").
+		Output(compactOutput).
+		Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	var out bytes.Buffer
+	if _, err := rules.Transform(context.Background(), strings.NewReader(`<a><b>x</b></a>`), &out); err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	if want := `<a><c>x!</c><n></n></a>`; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestBuilderConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *Builder
+		want    Config
+	}{
+		{"empty", NewBuilder(), Config{}},
+		{"rename", NewBuilder().RenameTag("a", "b").RenameTag("c", "d"),
+			Config{NameRules: []ConfigNameRule{{Old: "a", New: "b"}, {Old: "c", New: "d"}}}},
+		{"inserts", NewBuilder().InsertBefore("a", "<x/>").InsertAfterCounted("a", "<n>%d</n>", "c").PrependChild("a", "<y/>").Counter("c", 5),
+			Config{
+				InsertRules:       []ConfigInsertRule{{Target: "a", Template: "<x/>"}},
+				InsertAfterRules:  []ConfigInsertRule{{Target: "a", Template: "<n>%d</n>", Counter: "c"}},
+				PrependChildRules: []ConfigInsertRule{{Target: "a", Template: "<y/>"}},
+				Counters:          map[string]ConfigCounter{"c": {Start: 5}},
+			}},
+		{"values", NewBuilder().Prepend("a", "<").Append("a", ">").Script("b", "upper(value)"),
+			Config{ValueRules: []ConfigValueRule{
+				{Target: "a", Type: "prepend", Params: params{"prefix": "<"}},
+				{Target: "a", Type: "append", Params: params{"suffix": ">"}},
+				{Target: "b", Type: "script", Params: params{"expr": "upper(value)"}},
+			}}},
+		{"wrap, raw tags and cdata", NewBuilder().Wrap("a", "w").RawTags("r", "s").ReplaceCDATA("x", "y"),
+			Config{
+				WrapRules:  []ConfigWrapRule{{Target: "a", Wrapper: "w"}},
+				RawTags:    []string{"r", "s"},
+				CdataRules: []ConfigCdataRule{{Old: "x", New: "y"}},
+			}},
+		{"input and output", NewBuilder().Input(ConfigInput{Secure: true}).Output(ConfigOutput{Compact: true}),
+			Config{Input: ConfigInput{Secure: true}, Output: ConfigOutput{Compact: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.builder.Config(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Config() = %+v, want %+v", got, tt.want)
+			}
+			if _, err := tt.builder.Build(); err != nil {
+				t.Errorf("Build: %v", err)
+			}
+		})
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	// 検証は NewRuleSet と同じく Build で行われる
+	_, err := NewBuilder().ReplaceValue("a", "no_such_type", nil).Build()
+	if err == nil || !strings.Contains(err.Error(), "no_such_type") {
+		t.Errorf("Build error = %v, want an error for the unknown value rule type", err)
+	}
+}
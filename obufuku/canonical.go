@@ -0,0 +1,139 @@
+package obufuku
+
+import (
+	"encoding/xml"
+	"sort"
+	"unicode/utf8"
+)
+
+// canonicalAttr は、正規化出力で並べ替えるための属性の情報です。
+type canonicalAttr struct {
+	uri   string
+	local string
+	name  string
+	value string
+}
+
+// SetCanonical は、Exclusive XML Canonicalization (コメントなし) の形式で出力するよう設定します。
+// XML宣言・DOCTYPE宣言・コメント・文書要素外の空白は出力せず、空要素は開始タグと終了タグの組で、
+// 属性は名前空間URIとローカル名の順に並べ、名前空間宣言は実際に使われる要素にのみ出力します。
+func (e *tokenEncoder) SetCanonical(on bool) {
+	e.canonical = on
+}
+
+// skipCanonical は、正規化出力に含めないトークンかどうかを判定します。
+func (e *tokenEncoder) skipCanonical(t xml.Token) bool {
+	switch t := t.(type) {
+	case xml.Comment, xml.Directive:
+		return true
+	case xml.ProcInst:
+		return t.Target == "xml"
+	case xml.CharData:
+		return len(e.tags) == 0
+	}
+	return false
+}
+
+// writeCanonicalStart は、正規化出力の開始タグを '>' の手前まで書き出します。
+func (e *tokenEncoder) writeCanonicalStart(start xml.StartElement) {
+	e.tags = append(e.tags, start.Name)
+	e.scopes = append(e.scopes, namespaceBindings(start.Attr))
+	var parent map[string]string
+	if len(e.rendered) > 0 {
+		parent = e.rendered[len(e.rendered)-1]
+	}
+
+	// 要素と属性で実際に使われている接頭辞を集める
+	prefix, uri := e.resolvePrefix(start.Name, false)
+	used := map[string]string{prefix: uri}
+	var attrs []canonicalAttr
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "" || attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue
+		}
+		p, u := e.resolvePrefix(attr.Name, true)
+		name := attr.Name.Local
+		if p != "" {
+			used[p] = u
+			name = p + ":" + name
+		}
+		attrs = append(attrs, canonicalAttr{uri: u, local: attr.Name.Local, name: name, value: attr.Value})
+	}
+
+	// 祖先で出力済みの宣言と異なるものだけを出力する
+	var decls []namespaceBinding
+	for p, u := range used {
+		if p == "xml" || p != "" && u == "" || parent[p] == u {
+			continue
+		}
+		decls = append(decls, namespaceBinding{prefix: p, uri: u})
+	}
+	sort.Slice(decls, func(i, j int) bool { return decls[i].prefix < decls[j].prefix })
+	sort.SliceStable(attrs, func(i, j int) bool {
+		if attrs[i].uri != attrs[j].uri {
+			return attrs[i].uri < attrs[j].uri
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	rendered := parent
+	if len(decls) > 0 {
+		rendered = make(map[string]string, len(parent)+len(decls))
+		for p, u := range parent {
+			rendered[p] = u
+		}
+		for _, d := range decls {
+			rendered[d.prefix] = d.uri
+		}
+	}
+	e.rendered = append(e.rendered, rendered)
+
+	e.w.WriteByte('<')
+	e.w.WriteString(e.qualifiedName(start.Name, false))
+	for _, d := range decls {
+		if d.prefix == "" {
+			e.w.WriteString(` xmlns="`)
+		} else {
+			e.w.WriteString(` xmlns:` + d.prefix + `="`)
+		}
+		canonicalEscape(e.w, []byte(d.uri), true)
+		e.w.WriteByte('"')
+	}
+	for _, attr := range attrs {
+		e.w.WriteString(" " + attr.name + `="`)
+		canonicalEscape(e.w, []byte(attr.value), true)
+		e.w.WriteByte('"')
+	}
+}
+
+// canonicalEscape は、正規化XMLの規則でテキストまたは属性値をエスケープして書き出します。
+func canonicalEscape(w textWriter, s []byte, isAttr bool) {
+	last := 0
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRune(s[i:])
+		i += width
+		var esc string
+		switch {
+		case r == '&':
+			esc = "&amp;"
+		case r == '<':
+			esc = "&lt;"
+		case r == '>' && !isAttr:
+			esc = "&gt;"
+		case r == '"' && isAttr:
+			esc = "&quot;"
+		case r == '\t' && isAttr:
+			esc = "&#x9;"
+		case r == '\n' && isAttr:
+			esc = "&#xA;"
+		case r == '\r':
+			esc = "&#xD;"
+		default:
+			continue
+		}
+		w.Write(s[last : i-width])
+		w.WriteString(esc)
+		last = i
+	}
+	w.Write(s[last:])
+}
@@ -0,0 +1,45 @@
+package obufuku
+
+import "testing"
+
+func TestCanonicalOutput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "declaration, comments and empty elements",
+			input: "<?xml version=\"1.0\"?>\n<!-- c --><a><b/><!-- c --></a>\n",
+			want:  `<a><b></b></a>`,
+		},
+		{
+			name:  "attribute order",
+			input: `<a z="1" b="2" xmlns:p="urn:p" p:a="3"/>`,
+			want:  `<a xmlns:p="urn:p" b="2" z="1" p:a="3"></a>`,
+		},
+		{
+			name:  "unused namespace declarations",
+			input: `<a xmlns:p="urn:p" xmlns:q="urn:q"><p:b/></a>`,
+			want:  `<a><p:b xmlns:p="urn:p"></p:b></a>`,
+		},
+		{
+			name:  "default namespace",
+			input: `<a xmlns="urn:a"><b xmlns="urn:a"/><c xmlns="urn:c"/></a>`,
+			want:  `<a xmlns="urn:a"><b></b><c xmlns="urn:c"></c></a>`,
+		},
+		{
+			name:  "escaping",
+			input: `<a x="&lt;&quot;&#9;">&gt;&amp;&#13;</a>`,
+			want:  `<a x="&lt;&quot;&#x9;">&gt;&amp;&#xD;</a>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := transformString(t, Config{Output: ConfigOutput{Canonical: true}}, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
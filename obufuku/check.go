@@ -0,0 +1,335 @@
+package obufuku
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// defaultMaxProblems は、CheckWellFormed が報告する問題の数の既定の上限です。
+const defaultMaxProblems = 100
+
+// Problem は、XMLが整形式でない箇所です。
+type Problem struct {
+	// Line と Column は、問題を検出した入力の位置 (1始まり、Column は文字単位) です。
+	Line   int
+	Column int
+	// Message は、問題の内容です。
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, p.Message)
+}
+
+// CheckWellFormed は、r のXMLを変換せずに読み込み、整形式でない箇所を入力の順に返します。
+// 構文エラーの後も次のタグから読み込みを再開するため、1回の検査で複数の問題を報告できます
+// (再開後の問題は、先の問題から派生したものである可能性があります)。
+// タグの対応、属性の重複、名前空間接頭辞の宣言、文書要素の数も検査します。
+// エンコーディングは内容から自動判定し、入力設定 input の limits と secure を DOCTYPE 宣言の検査に使います。
+// 問題が max 個 (0 以下の場合は 100 個) に達すると検査を打ち切ります。
+// 入力を読み込めない場合はエラーを返します。
+func CheckWellFormed(r io.Reader, input ConfigInput, max int) ([]Problem, error) {
+	if max <= 0 {
+		max = defaultMaxProblems
+	}
+	utf8Reader, err := NewInputReader(r, autoEncoding)
+	if err != nil {
+		return nil, err
+	}
+	c := &wellFormednessChecker{
+		src:    &positionReader{r: bufio.NewReader(utf8Reader), line: 1},
+		secure: input.Secure,
+		limits: buildInputLimits(input.Limits),
+		max:    max,
+	}
+	return c.problems, c.run()
+}
+
+// openElement は、検査中に開いている要素です。
+type openElement struct {
+	name  xml.Name
+	attrs []xml.Attr
+	line  int
+}
+
+// wellFormednessChecker は、CheckWellFormed の検査の状態です。
+type wellFormednessChecker struct {
+	src      *positionReader
+	secure   bool
+	limits   InputLimits
+	max      int
+	problems []Problem
+	stack    []openElement
+	entities map[string]string
+	rootSeen bool
+
+	// 処理中のトークンの開始位置
+	line, column int
+}
+
+// errTooManyProblems は、報告する問題の数が上限に達したことを表します。
+var errTooManyProblems = errors.New("too many problems")
+
+// report は、現在の入力の位置で問題を記録します。上限に達した場合は errTooManyProblems を返します。
+func (c *wellFormednessChecker) report(format string, args ...interface{}) error {
+	return c.reportAt(c.src.line, c.src.column(), format, args...)
+}
+
+// reportAt は、指定した位置で問題を記録します。
+func (c *wellFormednessChecker) reportAt(line, column int, format string, args ...interface{}) error {
+	c.problems = append(c.problems, Problem{Line: line, Column: column, Message: fmt.Sprintf(format, args...)})
+	if len(c.problems) >= c.max {
+		return errTooManyProblems
+	}
+	return nil
+}
+
+// run は、入力の終わりまで検査します。構文エラーの後は、次の '<' からデコーダを作り直して再開します。
+func (c *wellFormednessChecker) run() error {
+	for {
+		decoder := xml.NewDecoder(c.src)
+		decoder.Strict = true
+		decoder.Entity = c.entities
+		decoder.CharsetReader = newCharsetReader(autoEncoding)
+		start := c.src.offset
+		err := c.scan(decoder)
+		if err == io.EOF {
+			return c.finish()
+		}
+		if errors.Is(err, errTooManyProblems) {
+			return nil
+		}
+		var syntaxErr *xml.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			return err
+		}
+		if err := c.report("%s", syntaxErr.Msg); err != nil {
+			return nil
+		}
+		if syntaxErr.Msg == "unexpected EOF" {
+			return c.finish()
+		}
+		// 次のタグまで読み飛ばす (進まない場合は少なくとも1バイト読み飛ばす)
+		if c.src.offset == start {
+			if _, err := c.src.ReadByte(); err == io.EOF {
+				return c.finish()
+			}
+		}
+		if err := c.src.skipTo('<'); err == io.EOF {
+			return c.finish()
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// scan は、デコーダが構文エラーを返すか入力が終わるまで、トークンを読んで検査します。
+func (c *wellFormednessChecker) scan(decoder *xml.Decoder) error {
+	for {
+		// タグに関する問題は、タグの位置 (直前のトークンの終わり) で報告する
+		c.line, c.column = c.src.line, c.src.column()
+		token, err := decoder.RawToken()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			err = c.startElement(t)
+		case xml.EndElement:
+			err = c.endElement(t)
+		case xml.CharData:
+			if len(c.stack) == 0 && strings.TrimSpace(string(t)) != "" {
+				leading := string(t)[:len(t)-len(strings.TrimLeft(string(t), " \t\r\n"))]
+				if n := strings.Count(leading, "\n"); n > 0 {
+					c.line, c.column = c.line+n, len(leading)-strings.LastIndex(leading, "\n")
+				}
+				err = c.reportAt(c.line, c.column, "text outside the root element")
+			}
+		case xml.Directive:
+			err = c.directive(t, decoder)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// startElement は、開始タグの属性の重複と名前空間接頭辞を検査し、要素を開きます。
+func (c *wellFormednessChecker) startElement(se xml.StartElement) error {
+	if len(c.stack) == 0 {
+		if c.rootSeen {
+			if err := c.reportAt(c.line, c.column, "element <%s> is a second root element", qualifiedRawName(se.Name)); err != nil {
+				return err
+			}
+		}
+		c.rootSeen = true
+	}
+	seen := make(map[xml.Name]bool)
+	for _, attr := range se.Attr {
+		if seen[attr.Name] {
+			if err := c.reportAt(c.line, c.column, "attribute '%s' is repeated in <%s>", qualifiedRawName(attr.Name), qualifiedRawName(se.Name)); err != nil {
+				return err
+			}
+		}
+		seen[attr.Name] = true
+	}
+	c.stack = append(c.stack, openElement{name: se.Name, attrs: se.Attr, line: c.line})
+	if !c.prefixBound(se.Name.Space) {
+		if err := c.reportAt(c.line, c.column, "undeclared namespace prefix '%s' in <%s>", se.Name.Space, qualifiedRawName(se.Name)); err != nil {
+			return err
+		}
+	}
+	for _, attr := range se.Attr {
+		if attr.Name.Space != "xmlns" && !c.prefixBound(attr.Name.Space) {
+			if err := c.reportAt(c.line, c.column, "undeclared namespace prefix '%s' in attribute '%s'", attr.Name.Space, qualifiedRawName(attr.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// endElement は、終了タグが開いている要素と対応しているかを検査し、要素を閉じます。
+// 対応しない場合は、祖先の要素の終了タグであればそこまでを閉じ、そうでなければ (名前の誤りとみなして)
+// 直近の要素を閉じます。
+func (c *wellFormednessChecker) endElement(ee xml.EndElement) error {
+	if len(c.stack) == 0 {
+		return c.reportAt(c.line, c.column, "unexpected end element </%s>", qualifiedRawName(ee.Name))
+	}
+	top := c.stack[len(c.stack)-1]
+	if top.name == ee.Name {
+		c.stack = c.stack[:len(c.stack)-1]
+		return nil
+	}
+	if err := c.reportAt(c.line, c.column, "element <%s> opened on line %d closed by </%s>", qualifiedRawName(top.name), top.line, qualifiedRawName(ee.Name)); err != nil {
+		return err
+	}
+	for i := len(c.stack) - 1; i >= 0; i-- {
+		if c.stack[i].name == ee.Name {
+			c.stack = c.stack[:i]
+			return nil
+		}
+	}
+	c.stack = c.stack[:len(c.stack)-1]
+	return nil
+}
+
+// directive は、DOCTYPE宣言を検査し、内部サブセットの実体を以降の読み込みで展開できるようにします。
+func (c *wellFormednessChecker) directive(d xml.Directive, decoder *xml.Decoder) error {
+	doctype, err := parseDoctype(d)
+	if err == nil && doctype != nil {
+		if c.secure && doctype.SystemID != "" {
+			err = fmt.Errorf("external DTD reference '%s' is not allowed in secure mode", doctype.SystemID)
+		} else {
+			err = checkEntityExpansion(doctype.Entities, c.limits)
+		}
+	}
+	if err != nil {
+		return c.report("%v", err)
+	}
+	if doctype != nil && len(doctype.Entities) > 0 {
+		c.entities = expandEntities(doctype.Entities, c.entities)
+		decoder.Entity = c.entities
+	}
+	return nil
+}
+
+// finish は、入力の終わりで閉じられていない要素と文書要素の有無を検査します。
+func (c *wellFormednessChecker) finish() error {
+	for i := len(c.stack) - 1; i >= 0; i-- {
+		if err := c.report("element <%s> opened on line %d is not closed", qualifiedRawName(c.stack[i].name), c.stack[i].line); err != nil {
+			return nil
+		}
+	}
+	if !c.rootSeen {
+		c.report("no root element")
+	}
+	return nil
+}
+
+// prefixBound は、名前空間接頭辞が開いている要素で宣言されているかを判定します。
+func (c *wellFormednessChecker) prefixBound(prefix string) bool {
+	if prefix == "" || prefix == "xml" || prefix == "xmlns" {
+		return true
+	}
+	for i := len(c.stack) - 1; i >= 0; i-- {
+		for _, attr := range c.stack[i].attrs {
+			if attr.Name.Space == "xmlns" && attr.Name.Local == prefix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// qualifiedRawName は、RawToken が返す名前を接頭辞付きの名前に戻します。
+func qualifiedRawName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// positionReader は、読み込んだバイト数と位置 (行・文字) を数える io.ByteReader です。
+// xml.Decoder は io.ByteReader から1バイトずつ読むため、デコーダが読んだ位置と一致します。
+type positionReader struct {
+	r      *bufio.Reader
+	offset int64
+	line   int
+	col    int
+}
+
+// ReadByte は io.ByteReader インターフェースを実装します。
+func (p *positionReader) ReadByte() (byte, error) {
+	b, err := p.r.ReadByte()
+	if err != nil {
+		return b, err
+	}
+	p.offset++
+	if b == '\n' {
+		p.line++
+		p.col = 0
+	} else if b&0xC0 != 0x80 {
+		// UTF-8 の継続バイトは数えず、文字単位で数える
+		p.col++
+	}
+	return b, nil
+}
+
+// Read は io.Reader インターフェースを実装します。
+func (p *positionReader) Read(buf []byte) (int, error) {
+	for i := range buf {
+		b, err := p.ReadByte()
+		if err != nil {
+			return i, err
+		}
+		buf[i] = b
+	}
+	return len(buf), nil
+}
+
+// column は、最後に読んだ文字の桁 (1始まり) を返します。
+func (p *positionReader) column() int {
+	if p.col == 0 {
+		return 1
+	}
+	return p.col
+}
+
+// skipTo は、次に c が現れる手前まで読み飛ばします。
+func (p *positionReader) skipTo(c byte) error {
+	for {
+		next, err := p.r.Peek(1)
+		if err != nil {
+			return err
+		}
+		if next[0] == c {
+			return nil
+		}
+		p.ReadByte()
+	}
+}
@@ -0,0 +1,49 @@
+package obufuku
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckWellFormed(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		problems []string
+	}{
+		{"well-formed", "<a>\n<b/>\n</a>", nil},
+		{"mismatched end tag", "<a>\n<b></c>\n</a>", []string{"2:3: element <b> opened on line 2 closed by </c>"}},
+		{"duplicate attribute and undeclared prefix", "<a>\n<b x='1' x='2'/>\n<p:c/>\n</a>", []string{"2:1: attribute 'x' is repeated in <b>", "3:1: undeclared namespace prefix 'p' in <p:c>"}},
+		{"several syntax errors", "<a>\n<b x=1/>\n<c>&nope;</c>\n<d <e/>\n</a>", []string{"2:6: unquoted or missing attribute value in element", "3:9: invalid character entity &nope;", "4:4: expected attribute name in element"}},
+		{"two document elements", "<a/>\n<b/>", []string{"2:1: element <b> is a second root element"}},
+		{"unclosed elements", "<a>\n<b>", []string{"2:3: element <b> opened on line 2 is not closed", "2:3: element <a> opened on line 1 is not closed"}},
+		{"external DTD in secure mode", "<!DOCTYPE a SYSTEM \"a.dtd\">\n<a/>", []string{"1:27: external DTD reference 'a.dtd' is not allowed in secure mode"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := CheckWellFormed(strings.NewReader(tt.input), ConfigInput{Secure: true}, 0)
+			if err != nil {
+				t.Fatalf("CheckWellFormed: %v", err)
+			}
+			var got []string
+			for _, p := range problems {
+				got = append(got, p.String())
+			}
+			if !reflect.DeepEqual(got, tt.problems) {
+				t.Errorf("problems = %q, want %q", got, tt.problems)
+			}
+		})
+	}
+}
+
+func TestCheckWellFormedMaxProblems(t *testing.T) {
+	input := "<a>" + strings.Repeat("<b x='1' x='2'/>", 10) + "</a>"
+	problems, err := CheckWellFormed(strings.NewReader(input), ConfigInput{}, 3)
+	if err != nil {
+		t.Fatalf("CheckWellFormed: %v", err)
+	}
+	if len(problems) != 3 {
+		t.Errorf("got %d problems, want 3", len(problems))
+	}
+}
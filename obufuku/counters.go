@@ -0,0 +1,175 @@
+package obufuku
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// counterStart は、カウンターの開始値を返します。start_from が指定されていれば、その読み込み元から読み込みます。
+func counterStart(c ConfigCounter) (int, error) {
+	src := c.StartFrom
+	if src == nil {
+		return c.Start, nil
+	}
+	if c.Persist != "" {
+		return 0, fmt.Errorf("'start_from' cannot be combined with 'persist'")
+	}
+	set := 0
+	for _, ok := range []bool{src.File != "", src.Env != "", len(src.Command) > 0} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return 0, fmt.Errorf("exactly one of 'file', 'env' or 'command' must be set in 'start_from'")
+	}
+
+	var value, from string
+	switch {
+	case src.File != "":
+		from = fmt.Sprintf("file '%s'", src.File)
+		data, err := os.ReadFile(src.File)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read start value from %s: %w", from, err)
+		}
+		value = string(data)
+	case src.Env != "":
+		from = fmt.Sprintf("environment variable '%s'", src.Env)
+		v, ok := os.LookupEnv(src.Env)
+		if !ok {
+			return 0, fmt.Errorf("%s for the start value is not set", from)
+		}
+		value = v
+	default:
+		from = fmt.Sprintf("command '%s'", strings.Join(src.Command, " "))
+		var stderr bytes.Buffer
+		cmd := exec.Command(src.Command[0], src.Command[1:]...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+			return 0, fmt.Errorf("failed to run %s for the start value: %w", from, err)
+		}
+		value = string(out)
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid start value %q from %s", strings.TrimSpace(value), from)
+	}
+	return start, nil
+}
+
+// loadCounters は、persist を指定したカウンターの値を状態ファイルから読み込み、開始値の代わりに使います。
+// 状態ファイルが無い場合や、ファイルにカウンターの値が無い場合は開始値のままです。
+// パスで定義したカウンターも対象にし、SaveCounters で書き込むカウンターとして記録します。
+func (rs *RuleSet) loadCounters(config Config, counters map[string]*Counter) error {
+	defs := []map[string]ConfigCounter{config.Counters}
+	for _, pass := range config.Passes {
+		defs = append(defs, pass.Counters)
+	}
+	states := make(map[string]map[string]int)
+	for _, def := range defs {
+		for name, c := range def {
+			if c.Persist == "" {
+				continue
+			}
+			state, ok := states[c.Persist]
+			if !ok {
+				var err error
+				if state, err = readCounterState(c.Persist); err != nil {
+					return &RuleConfigError{Rule: "counters." + name, Err: err}
+				}
+				states[c.Persist] = state
+			}
+			if last, ok := state[name]; ok {
+				counters[name].current = last
+			}
+			if rs.persisted == nil {
+				rs.persisted = make(map[string]map[string]*Counter)
+			}
+			if rs.persisted[c.Persist] == nil {
+				rs.persisted[c.Persist] = make(map[string]*Counter)
+			}
+			rs.persisted[c.Persist][name] = counters[name]
+		}
+	}
+	return nil
+}
+
+// SaveCounters は、persist を指定したカウンターの現在値を、それぞれの状態ファイルに書き込みます。
+// 状態ファイルにある他のカウンター (別のルールファイルのものなど) の値は維持します。
+// 次回の実行で続きから採番できるよう、変換を正常に終えた後に呼び出します。
+func (rs *RuleSet) SaveCounters() error {
+	paths := make([]string, 0, len(rs.persisted))
+	for path := range rs.persisted {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		state, err := readCounterState(path)
+		if err != nil {
+			return err
+		}
+		for name, c := range rs.persisted[path] {
+			state[name] = c.current
+		}
+		if err := writeCounterState(path, state); err != nil {
+			return fmt.Errorf("failed to save counter state '%s': %w", path, err)
+		}
+	}
+	return nil
+}
+
+// readCounterState は、カウンターの状態ファイル (カウンター名から最後の値へのJSONオブジェクト) を読み込みます。
+// ファイルが無い場合は、空の状態を返します。
+func readCounterState(path string) (map[string]int, error) {
+	state := make(map[string]int)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read counter state '%s': %w", path, err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse counter state '%s': %w", path, err)
+	}
+	return state, nil
+}
+
+// writeCounterState は、一時ファイルに書き込んでから名前を変更することで、状態ファイルを置き換えます。
+// 書き込みの途中で中断しても、前回の状態が壊れないようにするためです。
+func writeCounterState(path string, state map[string]int) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".counters-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,121 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPersistCounters(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "counters.json")
+	if err := os.WriteFile(state, []byte(`{"other": 7}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{
+		InsertRules: []ConfigInsertRule{{Target: "b", Template: "<n>%d</n>", Counter: "n"}},
+		Counters:    map[string]ConfigCounter{"n": {Start: 100, Persist: state}},
+		Output:      compactOutput,
+	}
+	// 1回目は開始値から、2回目は保存した値の続きから採番する
+	for _, want := range []string{"<a><n>101</n><b></b><n>102</n><b></b></a>", "<a><n>103</n><b></b><n>104</n><b></b></a>"} {
+		rs, err := NewRuleSet(cfg)
+		if err != nil {
+			t.Fatalf("NewRuleSet: %v", err)
+		}
+		var out bytes.Buffer
+		if _, err := rs.Transform(context.Background(), strings.NewReader("<a><b/><b/></a>"), &out); err != nil {
+			t.Fatalf("Transform: %v", err)
+		}
+		if out.String() != want {
+			t.Errorf("output = %q, want %q", out.String(), want)
+		}
+		if err := rs.SaveCounters(); err != nil {
+			t.Fatalf("SaveCounters: %v", err)
+		}
+	}
+
+	// 状態ファイルにある他のカウンターの値は維持する
+	data, err := os.ReadFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"n\": 104,\n  \"other\": 7\n}\n"; string(data) != want {
+		t.Errorf("state file = %q, want %q", data, want)
+	}
+}
+
+func TestPersistCountersInvalidState(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "counters.json")
+	if err := os.WriteFile(state, []byte(`not json`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewRuleSet(Config{Counters: map[string]ConfigCounter{"n": {Persist: state}}})
+	var configErr *RuleConfigError
+	if !errors.As(err, &configErr) || configErr.Rule != "counters.n" {
+		t.Errorf("NewRuleSet error = %v, want a RuleConfigError for counters.n", err)
+	}
+}
+
+func TestCounterStartFrom(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "last.txt")
+	if err := os.WriteFile(file, []byte(" 41\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OBUFUKU_TEST_LAST", "7")
+	tests := []struct {
+		name string
+		src  ConfigCounterSource
+		want string
+	}{
+		{"file", ConfigCounterSource{File: file}, "<a><n>42</n></a>"},
+		{"env", ConfigCounterSource{Env: "OBUFUKU_TEST_LAST"}, "<a><n>8</n></a>"},
+		{"command", ConfigCounterSource{Command: []string{"echo", "99"}}, "<a><n>100</n></a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := tt.src
+			cfg := Config{
+				PrependChildRules: []ConfigInsertRule{{Target: "a", Template: "<n>%d</n>", Counter: "n"}},
+				Counters:          map[string]ConfigCounter{"n": {Start: 1000, StartFrom: &src}},
+				Output:            compactOutput,
+			}
+			if got, _ := transformString(t, cfg, "<a/>"); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCounterStartFromErrors(t *testing.T) {
+	dir := t.TempDir()
+	notNumber := filepath.Join(dir, "not-number.txt")
+	if err := os.WriteFile(notNumber, []byte("abc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		counter ConfigCounter
+	}{
+		{"no source", ConfigCounter{StartFrom: &ConfigCounterSource{}}},
+		{"two sources", ConfigCounter{StartFrom: &ConfigCounterSource{File: notNumber, Env: "HOME"}}},
+		{"with persist", ConfigCounter{Persist: filepath.Join(dir, "state.json"), StartFrom: &ConfigCounterSource{Env: "HOME"}}},
+		{"missing file", ConfigCounter{StartFrom: &ConfigCounterSource{File: filepath.Join(dir, "missing")}}},
+		{"not a number", ConfigCounter{StartFrom: &ConfigCounterSource{File: notNumber}}},
+		{"unset env", ConfigCounter{StartFrom: &ConfigCounterSource{Env: "OBUFUKU_TEST_UNSET"}}},
+		{"failing command", ConfigCounter{StartFrom: &ConfigCounterSource{Command: []string{"false"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRuleSet(Config{Counters: map[string]ConfigCounter{"n": tt.counter}})
+			var configErr *RuleConfigError
+			if !errors.As(err, &configErr) || configErr.Rule != "counters.n" {
+				t.Errorf("NewRuleSet error = %v, want a RuleConfigError for counters.n", err)
+			}
+		})
+	}
+}
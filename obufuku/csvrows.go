@@ -0,0 +1,89 @@
+package obufuku
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// csvRows は、挿入ルールのテンプレートに埋め込む値を、CSVファイルの行から順に取り出します。
+// ルールが一致するたびに次の行に進み、テンプレートの {{csv "列名"}} をその行の値で置き換えます。
+// カウンターと同じく、行はルールセットで変換する文書をまたいで進みます。
+type csvRows struct {
+	path string
+	// columns は、1行目の見出しの列名から列の位置への map です。
+	columns map[string]int
+	rows    [][]string
+	// current は、現在の行 (rows の位置) です。最初の一致の前は -1 です。
+	current int
+}
+
+// loadCSVRows は、CSVファイル path を読み込みます。1行目は列名の見出しとして扱います。
+func loadCSVRows(path string) (*csvRows, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file '%s': %w", path, err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("CSV file '%s' has no header row", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV file '%s': %w", path, err)
+	}
+	// 表計算ソフトなどが先頭に付けるバイト順マークは、列名に含めない
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	c := &csvRows{path: path, columns: make(map[string]int, len(header)), current: -1}
+	for i, name := range header {
+		if _, dup := c.columns[name]; !dup {
+			c.columns[name] = i
+		}
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return c, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV file '%s': %w", path, err)
+		}
+		c.rows = append(c.rows, record)
+	}
+}
+
+// advance は、次の行に進みます。行が残っていなければエラーを返します。
+func (c *csvRows) advance() error {
+	if c.current+1 >= len(c.rows) {
+		return fmt.Errorf("CSV file '%s' has no more rows (all %d rows are used)", c.path, len(c.rows))
+	}
+	c.current++
+	return nil
+}
+
+// placeholderFuncs は、テンプレートで現在の行の値を参照する {{csv "列名"}} のプレースホルダーです。
+func (c *csvRows) placeholderFuncs() map[string]placeholderFactory {
+	return map[string]placeholderFactory{
+		"csv": func(args []string) (func() string, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("csv takes 1 argument (column name)")
+			}
+			column, ok := c.columns[args[0]]
+			if !ok {
+				return nil, fmt.Errorf("column '%s' not found in CSV header of '%s'", args[0], c.path)
+			}
+			return func() string {
+				// 検査で埋め込む場合など、まだ行に進んでいなければ空にする
+				if c.current < 0 || column >= len(c.rows[c.current]) {
+					return ""
+				}
+				return c.rows[c.current][column]
+			}, nil
+		},
+	}
+}
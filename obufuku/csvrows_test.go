@@ -0,0 +1,73 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCSV は、data の内容のCSVファイルを作成し、そのパスを返します。
+func writeCSV(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rows.csv")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInsertCSVRows(t *testing.T) {
+	path := writeCSV(t, "\ufeffname,note\nAlice,<&>\n\"Bob, Jr.\"\nCarol,x\n")
+	rs, err := NewRuleSet(Config{
+		PrependChildRules: []ConfigInsertRule{{Target: "a", Template: `<n note="{{csv "note"}}">{{csv "name"}}</n>`, CSV: path}},
+		Output:            compactOutput,
+	})
+	if err != nil {
+		t.Fatalf("NewRuleSet: %v", err)
+	}
+	// 行は一致するたびに進み、変換する文書をまたいで続く。足りない列は空になる
+	for _, tt := range []struct{ input, want string }{
+		{"<r><a/><a/></r>", `<r><a><n note="&lt;&amp;&gt;">Alice</n></a><a><n note="">Bob, Jr.</n></a></r>`},
+		{"<a/>", `<a><n note="x">Carol</n></a>`},
+	} {
+		var out bytes.Buffer
+		if _, err := rs.Transform(context.Background(), strings.NewReader(tt.input), &out); err != nil {
+			t.Fatalf("Transform: %v", err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("output = %q, want %q", out.String(), tt.want)
+		}
+	}
+
+	// 行が足りなくなった場合はエラーになる
+	if _, err := rs.Transform(context.Background(), strings.NewReader("<a/>"), &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "no more rows") {
+		t.Errorf("Transform error = %v, want an error about running out of rows", err)
+	}
+}
+
+func TestInsertCSVRowsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		csv      string
+	}{
+		{"unknown column", `<n>{{csv "missing"}}</n>`, writeCSV(t, "name\nAlice\n")},
+		{"empty file", `<n>{{csv "name"}}</n>`, writeCSV(t, "")},
+		{"missing file", `<n>{{csv "name"}}</n>`, filepath.Join(t.TempDir(), "missing.csv")},
+		{"csv without argument", `<n>{{csv}}</n>`, writeCSV(t, "name\nAlice\n")},
+		{"csv placeholder without file", `<n>{{csv "name"}}</n>`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRuleSet(Config{InsertAfterRules: []ConfigInsertRule{{Target: "a", Template: tt.template, CSV: tt.csv}}})
+			var configErr *RuleConfigError
+			if !errors.As(err, &configErr) || configErr.Rule != "insert_after_rules[0]" {
+				t.Errorf("NewRuleSet error = %v, want a RuleConfigError for insert_after_rules[0]", err)
+			}
+		})
+	}
+}
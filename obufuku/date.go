@@ -0,0 +1,209 @@
+package obufuku
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/width"
+)
+
+// defaultDateLayouts は、日付の正規化で入力の形式が指定されていない場合に試す形式 (Go の時刻のレイアウト) です。
+// 和暦の日付 (令和6年1月5日、R6.1.5 など) は、形式の指定に関わらず解釈します。
+var defaultDateLayouts = []string{
+	"2006-1-2",
+	"2006/1/2",
+	"2006.1.2",
+	"20060102",
+	"2006年1月2日",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-1-2 15:04:05",
+	"2006/1/2 15:04:05",
+	"2006/1/2 15:04",
+}
+
+// defaultDateFormat は、日付の正規化の既定の出力形式 (ISO 8601 の日付) です。
+const defaultDateFormat = "2006-01-02"
+
+// japaneseEra は、和暦の元号と、その初日です。
+type japaneseEra struct {
+	name   string
+	letter string
+	start  time.Time
+}
+
+// japaneseEras は、和暦の元号です (新しい順)。明治はグレゴリオ暦を採用した明治6年以降を対象とします。
+var japaneseEras = []japaneseEra{
+	{"令和", "R", time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)},
+	{"平成", "H", time.Date(1989, 1, 8, 0, 0, 0, 0, time.UTC)},
+	{"昭和", "S", time.Date(1926, 12, 25, 0, 0, 0, 0, time.UTC)},
+	{"大正", "T", time.Date(1912, 7, 30, 0, 0, 0, 0, time.UTC)},
+	{"明治", "M", time.Date(1868, 1, 25, 0, 0, 0, 0, time.UTC)},
+}
+
+// japaneseDatePattern は、和暦の日付 (令和6年1月5日、令和元年5月1日、R6.1.5、H31/04/30 など) です。
+var japaneseDatePattern = regexp.MustCompile(`^(明治|大正|昭和|平成|令和|[MTSHRmtshr])\s*(元|[0-9]{1,2})\s*(?:年|[./-])\s*([0-9]{1,2})\s*(?:月|[./-])\s*([0-9]{1,2})\s*日?$`)
+
+// dateNormalizer は、いくつかの形式の日付を1つの形式にそろえます。
+type dateNormalizer struct {
+	layouts []string
+	format  string
+	keep    bool
+}
+
+// newDateNormalizer は、layouts (空の場合は defaultDateLayouts) の形式か和暦の日付を format の形式
+// (空の場合は defaultDateFormat) にそろえる dateNormalizer を作成します。
+// onError は、日付を解釈できなかった場合の扱い ("error" または "keep") です。
+func newDateNormalizer(layouts []string, format, onError string) (*dateNormalizer, error) {
+	d := &dateNormalizer{layouts: layouts, format: format}
+	if len(d.layouts) == 0 {
+		d.layouts = defaultDateLayouts
+	}
+	if d.format == "" {
+		d.format = defaultDateFormat
+	}
+	keep, err := parseOnError(onError)
+	if err != nil {
+		return nil, err
+	}
+	d.keep = keep
+	return d, nil
+}
+
+// normalize は、日付 value を出力形式にします。空白だけの値はそのまま返します。
+// 全角の数字や記号は半角にしてから解釈します。
+func (d *dateNormalizer) normalize(value string) (string, error) {
+	s := strings.TrimSpace(width.Narrow.String(value))
+	if s == "" {
+		return value, nil
+	}
+	t, ok, err := parseJapaneseDate(s)
+	if err != nil {
+		if d.keep {
+			return value, nil
+		}
+		return "", err
+	}
+	for _, layout := range d.layouts {
+		if ok {
+			break
+		}
+		var parseErr error
+		t, parseErr = time.Parse(layout, s)
+		ok = parseErr == nil
+	}
+	if !ok {
+		if d.keep {
+			return value, nil
+		}
+		return "", fmt.Errorf("unrecognized date '%s'", value)
+	}
+	return t.Format(d.format), nil
+}
+
+// parseJapaneseDate は、和暦の日付 s を解釈します。和暦の日付の形でなければ ok が false になり、
+// 形は合っていても存在しない日付 (平成31年5月1日など、元号の期間外の日付を含む) の場合はエラーを返します。
+func parseJapaneseDate(s string) (t time.Time, ok bool, err error) {
+	m := japaneseDatePattern.FindStringSubmatch(s)
+	if m == nil {
+		return t, false, nil
+	}
+	for i, era := range japaneseEras {
+		if m[1] != era.name && strings.ToUpper(m[1]) != era.letter {
+			continue
+		}
+		year := 1
+		if m[2] != "元" {
+			year, _ = strconv.Atoi(m[2])
+		}
+		month, _ := strconv.Atoi(m[3])
+		day, _ := strconv.Atoi(m[4])
+		t = time.Date(era.start.Year()+year-1, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+		if year < 1 || t.Month() != time.Month(month) || t.Day() != day {
+			return t, false, fmt.Errorf("invalid date '%s'", s)
+		}
+		if t.Before(era.start) || i > 0 && !t.Before(japaneseEras[i-1].start) {
+			return t, false, fmt.Errorf("date '%s' is outside the %s era", s, era.name)
+		}
+		return t, true, nil
+	}
+	return t, false, nil
+}
+
+// newDateFunc は、値置換ルールの種類 "date" の置換関数を作成します。
+// params["layouts"] は入力の形式 (Go の時刻のレイアウト) の配列、params["format"] は出力の形式、
+// params["on_error"] は日付を解釈できなかった場合の扱いです。いずれも省略できます。
+func newDateFunc(params map[string]interface{}) (ValueContextFunc, error) {
+	var layouts []string
+	if v, ok := params["layouts"]; ok {
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid 'layouts' for date rule: must be an array of strings")
+		}
+		for _, item := range list {
+			layout, ok := item.(string)
+			if !ok || layout == "" {
+				return nil, fmt.Errorf("invalid 'layouts' for date rule: must be an array of strings")
+			}
+			layouts = append(layouts, layout)
+		}
+	}
+	format, ok := params["format"].(string)
+	if _, set := params["format"]; set && !ok {
+		return nil, fmt.Errorf("invalid 'format' for date rule: must be a string")
+	}
+	onError, ok := params["on_error"].(string)
+	if _, set := params["on_error"]; set && !ok {
+		return nil, fmt.Errorf("invalid 'on_error' for date rule: must be a string")
+	}
+	d, err := newDateNormalizer(layouts, format, onError)
+	if err != nil {
+		return nil, err
+	}
+	return func(oldValue string, ctx ValueContext) (string, error) {
+		return d.normalize(oldValue)
+	}, nil
+}
+
+// dateFilter は、要素のテキストの日付を1つの形式にそろえます (date_rules)。最初に一致したルールだけを適用します。
+// value_rules と同じく置換後のタグ名と照合し、raw_tags の要素の中身は対象にしません。
+type dateFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f dateFilter) CharData(w TokenWriter, el *Element, text *Text) error {
+	if text.Raw {
+		return nil
+	}
+	for i, rule := range f.p.dateRules {
+		if !rule.appliesTo(el.Start.Name.Local) {
+			continue
+		}
+		f.p.targetSeen(hitDateRules, i)
+		newValue, err := rule.Func(text.Data, ValueContext{Element: el.Start, Variables: f.p.variables})
+		if err != nil {
+			return &EncodeError{Rule: fmt.Sprintf("%s[%d]", hitDateRules, i), Err: err}
+		}
+		if newValue != text.Data {
+			f.p.ruleApplied(hitDateRules, i, "", text.Data, newValue)
+			text.Data = newValue
+			text.Modified = true
+		}
+		return nil
+	}
+	return nil
+}
+
+// appliesTo は、タグ name の要素がルールの対象かを判定します。
+func (rule DateRule) appliesTo(name string) bool {
+	for _, tag := range rule.TargetTags {
+		if tag == name {
+			return true
+		}
+	}
+	return false
+}
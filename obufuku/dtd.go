@@ -0,0 +1,355 @@
+package obufuku
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// doctypeDecl は、DOCTYPE宣言から読み取った文書型名、外部サブセットの識別子、
+// 内部サブセットで宣言された実体です。
+type doctypeDecl struct {
+	Name     string
+	PublicID string
+	SystemID string
+	Entities []entityDecl
+}
+
+// entityDecl は、内部サブセットの <!ENTITY> 宣言です。
+type entityDecl struct {
+	Name string
+	// Parameter が true の場合、パラメータ実体 (%name;) の宣言です。
+	Parameter bool
+	// Value は、内部実体の置換テキストです (文字参照・実体参照は展開しません)。
+	Value string
+	// PublicID と SystemID は、外部実体の識別子です。SystemID が空でなければ外部実体です。
+	PublicID string
+	SystemID string
+}
+
+// parseDoctype は、ディレクティブがDOCTYPE宣言であれば、その内容を解析します。
+// DOCTYPE宣言以外のディレクティブの場合は nil を返します。
+func parseDoctype(d xml.Directive) (*doctypeDecl, error) {
+	s := &dtdScanner{s: string(d)}
+	if !s.consumeKeyword("DOCTYPE") {
+		return nil, nil
+	}
+	doctype := &doctypeDecl{Name: s.name()}
+	if doctype.Name == "" {
+		return nil, fmt.Errorf("DOCTYPE declaration has no document type name")
+	}
+	var err error
+	if doctype.PublicID, doctype.SystemID, err = s.externalID(); err != nil {
+		return nil, fmt.Errorf("invalid DOCTYPE declaration: %w", err)
+	}
+	s.skipSpace()
+	if !s.consume("[") {
+		return doctype, nil
+	}
+
+	// 内部サブセットのマークアップ宣言を順に読む (ENTITY 以外は読み飛ばす)
+	for {
+		s.skipSpace()
+		switch {
+		case s.eof():
+			return nil, fmt.Errorf("invalid DOCTYPE declaration: internal subset is not terminated")
+		case s.consume("]"):
+			return doctype, nil
+		case s.consume("<!ENTITY"):
+			entity, err := s.entityDecl()
+			if err != nil {
+				return nil, fmt.Errorf("invalid ENTITY declaration in DOCTYPE: %w", err)
+			}
+			doctype.Entities = append(doctype.Entities, entity)
+		case s.consume("<"):
+			if err := s.skipMarkup(); err != nil {
+				return nil, fmt.Errorf("invalid DOCTYPE declaration: %w", err)
+			}
+		default:
+			// パラメータ実体参照などの宣言以外の記述
+			s.pos++
+		}
+	}
+}
+
+// dtdScanner は、DOCTYPE宣言の文字列を先頭から読み進める簡易的な字句解析器です。
+type dtdScanner struct {
+	s   string
+	pos int
+}
+
+func (s *dtdScanner) eof() bool {
+	return s.pos >= len(s.s)
+}
+
+func (s *dtdScanner) skipSpace() {
+	for !s.eof() && isDTDSpace(s.s[s.pos]) {
+		s.pos++
+	}
+}
+
+// consume は、現在位置が prefix で始まっていれば読み進めて true を返します。
+func (s *dtdScanner) consume(prefix string) bool {
+	if strings.HasPrefix(s.s[s.pos:], prefix) {
+		s.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+// consumeKeyword は、空白を読み飛ばした後に keyword が単語として続いていれば読み進めます。
+func (s *dtdScanner) consumeKeyword(keyword string) bool {
+	s.skipSpace()
+	rest := s.s[s.pos:]
+	if !strings.HasPrefix(rest, keyword) || len(rest) > len(keyword) && !isDTDSpace(rest[len(keyword)]) && rest[len(keyword)] != '[' && rest[len(keyword)] != '>' {
+		return false
+	}
+	s.pos += len(keyword)
+	return true
+}
+
+// name は、空白を読み飛ばした後の名前 (空白・引用符・括弧・'>' の手前まで) を読み取ります。
+func (s *dtdScanner) name() string {
+	s.skipSpace()
+	start := s.pos
+	for !s.eof() && !isDTDSpace(s.s[s.pos]) && !strings.ContainsRune(`"'[]>`, rune(s.s[s.pos])) {
+		s.pos++
+	}
+	return s.s[start:s.pos]
+}
+
+// quoted は、空白を読み飛ばした後の引用符で囲まれた文字列を読み取ります。
+func (s *dtdScanner) quoted() (string, error) {
+	s.skipSpace()
+	if s.eof() || s.s[s.pos] != '"' && s.s[s.pos] != '\'' {
+		return "", fmt.Errorf("quoted literal expected")
+	}
+	quote := s.s[s.pos]
+	end := strings.IndexByte(s.s[s.pos+1:], quote)
+	if end < 0 {
+		return "", fmt.Errorf("unterminated literal")
+	}
+	value := s.s[s.pos+1 : s.pos+1+end]
+	s.pos += end + 2
+	return value, nil
+}
+
+// externalID は、SYSTEM または PUBLIC で始まる外部識別子があれば読み取ります。
+func (s *dtdScanner) externalID() (publicID, systemID string, err error) {
+	switch {
+	case s.consumeKeyword("SYSTEM"):
+		systemID, err = s.quoted()
+	case s.consumeKeyword("PUBLIC"):
+		if publicID, err = s.quoted(); err == nil {
+			systemID, err = s.quoted()
+		}
+	}
+	return publicID, systemID, err
+}
+
+// entityDecl は、"<!ENTITY" に続く実体宣言を '>' まで読み取ります。
+func (s *dtdScanner) entityDecl() (entityDecl, error) {
+	var entity entityDecl
+	s.skipSpace()
+	if s.consume("%") {
+		entity.Parameter = true
+	}
+	if entity.Name = s.name(); entity.Name == "" {
+		return entity, fmt.Errorf("entity name expected")
+	}
+	s.skipSpace()
+	var err error
+	if !s.eof() && (s.s[s.pos] == '"' || s.s[s.pos] == '\'') {
+		entity.Value, err = s.quoted()
+	} else {
+		entity.PublicID, entity.SystemID, err = s.externalID()
+		if err == nil && entity.SystemID == "" {
+			err = fmt.Errorf("entity '%s' has neither a value nor an external identifier", entity.Name)
+		}
+	}
+	if err != nil {
+		return entity, err
+	}
+	return entity, s.skipMarkup()
+}
+
+// skipMarkup は、引用符内の '>' を考慮して、現在のマークアップ宣言の終わりまで読み飛ばします。
+func (s *dtdScanner) skipMarkup() error {
+	for !s.eof() {
+		switch c := s.s[s.pos]; c {
+		case '"', '\'':
+			if _, err := s.quoted(); err != nil {
+				return err
+			}
+		case '>':
+			s.pos++
+			return nil
+		default:
+			s.pos++
+		}
+	}
+	return fmt.Errorf("markup declaration is not terminated")
+}
+
+func isDTDSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// checkEntityExpansion は、内部実体を再帰的に展開した場合の参照の展開回数と置換後の大きさを
+// 宣言から見積もり、上限を超える実体 (billion laughs 攻撃など) や循環参照があればエラーを返します。
+// 本文の読み込みで実体を展開する前に検査するほか、DOCTYPE宣言は出力にも引き継がれるため、後続の処理を守る目的も兼ねます。
+func checkEntityExpansion(entities []entityDecl, limits InputLimits) error {
+	internal := make(map[string]string)
+	for _, e := range entities {
+		// 同じ名前の宣言は最初のものが有効
+		if _, ok := internal[e.Name]; !e.Parameter && e.SystemID == "" && !ok {
+			internal[e.Name] = e.Value
+		}
+	}
+
+	type expansion struct {
+		refs int64
+		size int64
+	}
+	done := make(map[string]expansion)
+	active := make(map[string]bool)
+	var expand func(name string) (expansion, error)
+	expand = func(name string) (expansion, error) {
+		if x, ok := done[name]; ok {
+			return x, nil
+		}
+		if active[name] {
+			return expansion{}, fmt.Errorf("entity '%s' references itself recursively", name)
+		}
+		active[name] = true
+		defer delete(active, name)
+
+		value := internal[name]
+		var x expansion
+		for i := 0; i < len(value); {
+			amp := strings.IndexByte(value[i:], '&')
+			semi := -1
+			if amp >= 0 {
+				semi = strings.IndexByte(value[i+amp:], ';')
+			}
+			if amp < 0 || semi < 0 {
+				x.size += int64(len(value) - i)
+				break
+			}
+			x.size += int64(amp)
+			ref := value[i+amp+1 : i+amp+semi]
+			i += amp + semi + 1
+			if _, ok := internal[ref]; !ok || strings.HasPrefix(ref, "#") {
+				// 文字参照・定義済み実体・未宣言の実体は展開せずに数える
+				x.size += int64(len(ref) + 2)
+				continue
+			}
+			child, err := expand(ref)
+			if err != nil {
+				return expansion{}, err
+			}
+			x.refs += 1 + child.refs
+			x.size += child.size
+			if limits.MaxEntityExpansions > 0 && x.refs > limits.MaxEntityExpansions {
+				return expansion{}, fmt.Errorf("entity '%s' requires more than %d entity expansions (input.limits.max_entity_expansions)", name, limits.MaxEntityExpansions)
+			}
+			if limits.MaxExpandedSize > 0 && x.size > limits.MaxExpandedSize {
+				return expansion{}, fmt.Errorf("entity '%s' expands to more than %d bytes (input.limits.max_expanded_size)", name, limits.MaxExpandedSize)
+			}
+		}
+		done[name] = x
+		return x, nil
+	}
+
+	for _, e := range entities {
+		if _, ok := internal[e.Name]; !ok || e.Parameter {
+			continue
+		}
+		if _, err := expand(e.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// predefinedEntities は、XMLで定義済みの実体参照の置換テキストです。
+var predefinedEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"apos": "'",
+	"quot": `"`,
+}
+
+// expandEntities は、内部実体の置換テキストに含まれる文字参照・実体参照を再帰的に展開し、
+// 実体名と展開後のテキストの対応を返します。known は、定義済み以外で既に使える実体です。
+// 置換テキスト中のマークアップは要素として解析せず、文字データとして扱います。
+// 循環参照や展開の大きさは、事前に checkEntityExpansion で検査しておく必要があります。
+func expandEntities(entities []entityDecl, known map[string]string) map[string]string {
+	internal := make(map[string]string)
+	for _, e := range entities {
+		if _, ok := internal[e.Name]; !e.Parameter && e.SystemID == "" && !ok {
+			internal[e.Name] = e.Value
+		}
+	}
+
+	expanded := make(map[string]string)
+	var expand func(name string) string
+	expand = func(name string) string {
+		if text, ok := expanded[name]; ok {
+			return text
+		}
+		value := internal[name]
+		var b strings.Builder
+		for i := 0; i < len(value); {
+			amp := strings.IndexByte(value[i:], '&')
+			semi := -1
+			if amp >= 0 {
+				semi = strings.IndexByte(value[i+amp:], ';')
+			}
+			if amp < 0 || semi < 0 {
+				b.WriteString(value[i:])
+				break
+			}
+			b.WriteString(value[i : i+amp])
+			ref := value[i+amp+1 : i+amp+semi]
+			i += amp + semi + 1
+			if r, ok := parseCharRef(ref); ok {
+				b.WriteRune(r)
+			} else if text, ok := predefinedEntities[ref]; ok {
+				b.WriteString(text)
+			} else if _, ok := internal[ref]; ok {
+				b.WriteString(expand(ref))
+			} else if text, ok := known[ref]; ok {
+				b.WriteString(text)
+			} else {
+				b.WriteString("&" + ref + ";")
+			}
+		}
+		expanded[name] = b.String()
+		return expanded[name]
+	}
+	for name := range internal {
+		expand(name)
+	}
+	return expanded
+}
+
+// parseCharRef は、"#65" や "#x41" の形式の文字参照を文字に変換します。
+func parseCharRef(ref string) (rune, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return 0, false
+	}
+	var n uint64
+	var err error
+	if strings.HasPrefix(ref, "#x") {
+		n, err = strconv.ParseUint(ref[2:], 16, 32)
+	} else {
+		n, err = strconv.ParseUint(ref[1:], 10, 32)
+	}
+	if err != nil || !isInCharacterRange(rune(n)) {
+		return 0, false
+	}
+	return rune(n), true
+}
@@ -0,0 +1,95 @@
+package obufuku
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEntityExpansionLimits(t *testing.T) {
+	const laughs = `<!DOCTYPE a [
+<!ENTITY l0 "ha">
+<!ENTITY l1 "&l0;&l0;&l0;&l0;&l0;&l0;&l0;&l0;&l0;&l0;">
+<!ENTITY l2 "&l1;&l1;&l1;&l1;&l1;&l1;&l1;&l1;&l1;&l1;">
+<!ENTITY l3 "&l2;&l2;&l2;&l2;&l2;&l2;&l2;&l2;&l2;&l2;">
+<!ENTITY l4 "&l3;&l3;&l3;&l3;&l3;&l3;&l3;&l3;&l3;&l3;">
+<!ENTITY l5 "&l4;&l4;&l4;&l4;&l4;&l4;&l4;&l4;&l4;&l4;">
+<!ENTITY l6 "&l5;&l5;&l5;&l5;&l5;&l5;&l5;&l5;&l5;&l5;">
+]><a/>`
+	tests := []struct {
+		name   string
+		input  string
+		limits ConfigLimits
+		err    string
+	}{
+		{"small entities", `<!DOCTYPE a [<!ENTITY e "x"><!ENTITY f "&e;&e;">]><a/>`, ConfigLimits{}, ""},
+		{"expansions over the default limit", laughs, ConfigLimits{}, "max_entity_expansions"},
+		{"expanded size over the limit", laughs, ConfigLimits{MaxEntityExpansions: -1, MaxExpandedSize: 1000}, "max_expanded_size"},
+		{"limits disabled", laughs, ConfigLimits{MaxEntityExpansions: -1, MaxExpandedSize: -1}, ""},
+		{"recursive entity", `<!DOCTYPE a [<!ENTITY e "&f;"><!ENTITY f "&e;">]><a/>`, ConfigLimits{}, "recursively"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tryTransformString(t, Config{Input: ConfigInput{Limits: tt.limits}}, tt.input)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Transform: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Transform error = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSecureInput(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		secure bool
+		err    string
+	}{
+		{"external DTD", `<!DOCTYPE a SYSTEM "a.dtd"><a/>`, true, "external DTD reference 'a.dtd' is not allowed in secure mode"},
+		{"public DTD", `<!DOCTYPE a PUBLIC "-//A//EN" "http://example.com/a.dtd"><a/>`, true, "external DTD reference"},
+		{"external entity", `<!DOCTYPE a [<!ENTITY e SYSTEM "file:///etc/passwd">]><a/>`, true, "external entity 'e'"},
+		{"internal entity", `<!DOCTYPE a [<!ENTITY e "x">]><a/>`, true, ""},
+		{"external DTD without secure", `<!DOCTYPE a SYSTEM "a.dtd"><a/>`, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tryTransformString(t, Config{Input: ConfigInput{Secure: tt.secure}}, tt.input)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Transform: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Transform error = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestInternalSubsetEntities(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"simple entity", `<!DOCTYPE a [<!ENTITY co "Company">]><a>&co;</a>`, "<!DOCTYPE a [<!ENTITY co \"Company\">]><a>Company</a>"},
+		{"nested entities", `<!DOCTYPE a [<!ENTITY e "x"><!ENTITY f "&e;-&e;">]><a>&f;</a>`, "<!DOCTYPE a [<!ENTITY e \"x\"><!ENTITY f \"&e;-&e;\">]><a>x-x</a>"},
+		{"character references", `<!DOCTYPE a [<!ENTITY c "&#169;&#x41;">]><a>&c;</a>`, "<!DOCTYPE a [<!ENTITY c \"&#169;&#x41;\">]><a>©A</a>"},
+		{"markup is text", `<!DOCTYPE a [<!ENTITY m "&lt;b&gt;">]><a>&m;</a>`, "<!DOCTYPE a [<!ENTITY m \"&lt;b&gt;\">]><a>&lt;b&gt;</a>"},
+		{"entity in an attribute", `<!DOCTYPE a [<!ENTITY v "1">]><a x="&v;"/>`, "<!DOCTYPE a [<!ENTITY v \"1\">]><a x=\"1\"></a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := transformString(t, Config{Output: compactOutput}, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,796 @@
+package obufuku
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// xmlNamespaceURI は、予約済みの xml 接頭辞に対応する名前空間URIです。
+const xmlNamespaceURI = "http://www.w3.org/XML/1998/namespace"
+
+// tokenEncoder は、xml.Token を順に書き出すシリアライザです。
+// インデントやエスケープは encoding/xml の Encoder と同じ書式で出力しつつ、
+// 空要素の自己終了タグ化など、標準の Encoder では制御できない出力形式を扱います。
+// また、入力の名前空間接頭辞を維持したままタグ名・属性名を出力します。
+type tokenEncoder struct {
+	w *bufio.Writer
+	// out は、w の書き込み先です。held が nil でない間は、w は held に書き込みます。
+	out io.Writer
+	// held は、出力するかどうかが未確定の部分を含むため、out に書き出さずに保留している出力です。
+	// holds は、まだ確定していない保留の数です。
+	held  *bytes.Buffer
+	holds int
+
+	prefix     string
+	indent     string
+	depth      int
+	indentedIn bool
+	putNewline bool
+	// miscOwnLine が true の場合、コメントと処理命令を独立した行に出力します
+	miscOwnLine bool
+	// attrWrapWidth が正の場合、開始タグがこの桁数を超えるときに属性を1つずつ改行して出力します
+	attrWrapWidth int
+	// sortAttrs と attrOrder は、属性を出力する順序の設定です (SetAttrOrder)
+	sortAttrs bool
+	attrOrder map[string]int
+	// charRefsAll と charRefTags は、ASCII以外の文字を数値文字参照で出力する設定です (SetCharRefs)。
+	// charRefDepth は、charRefTags の要素の中にいる間、その要素の深さ (tags の長さ) です (外では 0)。
+	charRefsAll  bool
+	charRefTags  map[string]bool
+	charRefDepth int
+	// encodable は、UTF-8以外の出力エンコーディングで文字を表せるかを判定する関数です (SetEncodable、UTF-8では nil)。
+	encodable func(r rune) bool
+
+	// 正規化出力 (Exclusive C14N) の状態
+	canonical  bool
+	rendered   []map[string]string
+	rootClosed bool
+
+	tags    []xml.Name
+	scopes  [][]namespaceBinding
+	pending *xml.StartElement
+	written bool
+}
+
+// outputBufferSize は、tokenEncoder の出力バッファの大きさです。
+// トークンごとの小さな書き込みを、改行コードの変換やファイルへの書き込みの手前でまとめます。
+const outputBufferSize = 64 * 1024
+
+// newTokenEncoder は、w に書き込む新しいtokenEncoderを作成します。
+func newTokenEncoder(w io.Writer) *tokenEncoder {
+	return &tokenEncoder{w: bufio.NewWriterSize(w, outputBufferSize), out: w}
+}
+
+// Indent は、各要素を改行し、prefix に続けて深さ分の indent を付けて出力するよう設定します。
+func (e *tokenEncoder) Indent(prefix, indent string) {
+	e.prefix = prefix
+	e.indent = indent
+}
+
+// SetMiscOwnLine は、コメントと処理命令を独立した行 (現在の深さのインデント付き) に
+// 出力するかどうかを設定します。false の場合は直前のトークンに続けて出力します。
+func (e *tokenEncoder) SetMiscOwnLine(on bool) {
+	e.miscOwnLine = on
+}
+
+// SetAttrWrapWidth は、開始タグの長さが width 桁を超える場合に、属性を1行に1つずつ
+// 子要素と区別できるよう要素より2段深いインデントで出力するよう設定します。
+// 0 の場合は折り返しません。
+func (e *tokenEncoder) SetAttrWrapWidth(width int) {
+	e.attrWrapWidth = width
+}
+
+// SetAttrOrder は、開始タグの属性を出力する順序を設定します。
+// order に列挙した名前 (接頭辞を含む) の属性をその順で先頭に並べ、sorted が true の場合は残りを名前の昇順に並べます。
+// 名前空間宣言は、入力の順のまま属性より前に出力します。
+func (e *tokenEncoder) SetAttrOrder(sorted bool, order []string) {
+	e.sortAttrs = sorted
+	e.attrOrder = nil
+	for i, name := range order {
+		if e.attrOrder == nil {
+			e.attrOrder = make(map[string]int, len(order))
+		}
+		if _, dup := e.attrOrder[name]; !dup {
+			e.attrOrder[name] = i
+		}
+	}
+}
+
+// orderAttrs は、SetAttrOrder の設定に従って並べ替えた属性を返します。start.Attr は変更しません。
+func (e *tokenEncoder) orderAttrs(attrs []xml.Attr) []xml.Attr {
+	if !e.sortAttrs && e.attrOrder == nil || len(attrs) < 2 {
+		return attrs
+	}
+	type keyed struct {
+		attr xml.Attr
+		decl bool
+		rank int
+		name string
+	}
+	keys := make([]keyed, len(attrs))
+	for i, attr := range attrs {
+		name := e.qualifiedName(attr.Name, true)
+		rank, listed := e.attrOrder[name]
+		if !listed {
+			rank = len(e.attrOrder)
+		}
+		keys[i] = keyed{attr: attr, decl: isNamespaceDecl(attr), rank: rank, name: name}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.decl != b.decl {
+			return a.decl
+		}
+		if a.decl {
+			return false
+		}
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		return e.sortAttrs && a.rank == len(e.attrOrder) && a.name < b.name
+	})
+	sorted := make([]xml.Attr, len(keys))
+	for i, k := range keys {
+		sorted[i] = k.attr
+	}
+	return sorted
+}
+
+// SetCharRefs は、ASCII以外の文字を数値文字参照で出力する範囲を設定します。
+// all が true の場合は文書全体、そうでなければ tags の要素 (属性値と子孫を含むテキスト) を対象にします。
+func (e *tokenEncoder) SetCharRefs(all bool, tags map[string]bool) {
+	e.charRefsAll = all
+	e.charRefTags = tags
+}
+
+// charRefs は、現在の位置のテキストと属性値を数値文字参照で出力するかを判定します。
+func (e *tokenEncoder) charRefs() bool {
+	return e.charRefsAll || e.charRefDepth > 0
+}
+
+// SetEncodable は、出力エンコーディングで文字を表せるかを判定する関数を設定します。
+// 表せない文字は、テキストと属性値では数値文字参照にし、名前とコメント・処理命令ではエラーにします。
+func (e *tokenEncoder) SetEncodable(encodable func(r rune) bool) {
+	e.encodable = encodable
+}
+
+// charRef は、現在の位置のテキストと属性値で、文字 r を数値文字参照で出力するかを判定します。
+func (e *tokenEncoder) charRef(r rune) bool {
+	return r >= utf8.RuneSelf && (e.charRefs() || e.encodable != nil && !e.encodable(r))
+}
+
+// checkEncodable は、文字参照にできない what の文字列 s を、出力エンコーディングで表せるかを確認します。
+func (e *tokenEncoder) checkEncodable(what string, s []byte) error {
+	if e.encodable == nil {
+		return nil
+	}
+	for _, r := range string(s) {
+		if !e.encodable(r) {
+			return fmt.Errorf("%s contains the character %U, which the output encoding cannot represent", what, r)
+		}
+	}
+	return nil
+}
+
+// WriteBlankLine は、インデント出力時に空行を1行書き出します。
+// 文書の先頭では何もしません。
+func (e *tokenEncoder) WriteBlankLine() error {
+	if len(e.prefix) == 0 && len(e.indent) == 0 || !e.putNewline {
+		return nil
+	}
+	if err := e.closePending(); err != nil {
+		return err
+	}
+	return e.w.WriteByte('\n')
+}
+
+// EncodeToken は、トークンを1つ書き出します。
+func (e *tokenEncoder) EncodeToken(t xml.Token) error {
+	if ee, ok := t.(xml.EndElement); ok {
+		return e.EncodeEnd(ee, false)
+	}
+	if e.canonical && e.skipCanonical(t) {
+		return nil
+	}
+	if err := e.closePending(); err != nil {
+		return err
+	}
+	switch t := t.(type) {
+	case xml.StartElement:
+		if t.Name.Local == "" {
+			return fmt.Errorf("xml: start tag with no name")
+		}
+		if err := e.checkNames(t); err != nil {
+			return err
+		}
+		if e.canonical {
+			e.writeCanonicalStart(t)
+		} else {
+			e.writeStart(t)
+		}
+		// '>' は次のトークンが来るまで保留し、空要素を自己終了タグにできるようにする
+		start := t
+		e.pending = &start
+	case xml.CharData:
+		if e.canonical {
+			canonicalEscape(e.w, t, false)
+		} else {
+			escapeText(e.w, t, false, e.charRef)
+		}
+	case xml.Comment:
+		if bytes.Contains(t, []byte("-->")) {
+			return fmt.Errorf("xml: EncodeToken of Comment containing --> marker")
+		}
+		if err := e.checkEncodable("comment", t); err != nil {
+			return err
+		}
+		e.writeMiscIndent()
+		e.w.WriteString("<!--")
+		e.w.Write(t)
+		e.w.WriteString("-->")
+	case xml.ProcInst:
+		if t.Target == "xml" && e.written {
+			return fmt.Errorf("xml: EncodeToken of ProcInst xml target only valid for xml declaration, first token encoded")
+		}
+		if bytes.Contains(t.Inst, []byte("?>")) {
+			return fmt.Errorf("xml: EncodeToken of ProcInst containing ?> marker")
+		}
+		if err := e.checkEncodable("processing instruction", append([]byte(t.Target+" "), t.Inst...)); err != nil {
+			return err
+		}
+		e.writeMiscIndent()
+		// 正規化出力では、文書要素の前後の処理命令を改行で区切る
+		if e.canonical && e.rootClosed {
+			e.w.WriteByte('\n')
+		}
+		e.w.WriteString("<?")
+		e.w.WriteString(t.Target)
+		if len(t.Inst) > 0 {
+			e.w.WriteByte(' ')
+			e.w.Write(t.Inst)
+		}
+		e.w.WriteString("?>")
+		if e.canonical && len(e.tags) == 0 && !e.rootClosed {
+			e.w.WriteByte('\n')
+		}
+	case xml.Directive:
+		if err := e.checkEncodable("directive", t); err != nil {
+			return err
+		}
+		e.w.WriteString("<!")
+		e.w.Write(t)
+		e.w.WriteString(">")
+	default:
+		return fmt.Errorf("xml: EncodeToken of invalid token type")
+	}
+	e.written = true
+	return nil
+}
+
+// EncodeEnd は、終了タグを書き出します。selfClose が true で、直前に書き出した
+// 開始タグが同じ要素のもの (つまり空要素) であれば、<tag/> の形式で閉じます。
+func (e *tokenEncoder) EncodeEnd(ee xml.EndElement, selfClose bool) error {
+	if ee.Name.Local == "" {
+		return fmt.Errorf("xml: end tag with no name")
+	}
+	if len(e.tags) == 0 {
+		return fmt.Errorf("xml: end tag </%s> without start tag", ee.Name.Local)
+	}
+	if top := e.tags[len(e.tags)-1]; top != ee.Name {
+		return fmt.Errorf("xml: end tag </%s> does not match start tag <%s>", ee.Name.Local, top.Local)
+	}
+
+	if e.pending != nil && selfClose && !e.canonical {
+		e.pending = nil
+		e.w.WriteString("/>")
+		e.depth--
+		e.indentedIn = false
+	} else {
+		if err := e.closePending(); err != nil {
+			return err
+		}
+		e.writeIndent(-1)
+		e.w.WriteString("</")
+		e.w.WriteString(e.qualifiedName(ee.Name, false))
+		e.w.WriteByte('>')
+	}
+	e.tags = e.tags[:len(e.tags)-1]
+	e.scopes = e.scopes[:len(e.scopes)-1]
+	if len(e.tags) < e.charRefDepth {
+		e.charRefDepth = 0
+	}
+	if e.canonical {
+		e.rendered = e.rendered[:len(e.rendered)-1]
+		e.rootClosed = len(e.tags) == 0
+	}
+	return nil
+}
+
+// checkNames は、開始タグの要素名や属性名を、文字参照を使わずに出力できることを確認します。
+// ASCII以外の文字を数値文字参照で出力する位置ではASCII以外の文字を、それ以外では出力エンコーディングで
+// 表せない文字を含む名前を、そのまま出力せずにエラーにします。
+func (e *tokenEncoder) checkNames(start xml.StartElement) error {
+	refs := e.charRefs() || e.charRefTags[start.Name.Local]
+	if !refs && e.encodable == nil {
+		return nil
+	}
+	// 名前空間の宣言を解決できるよう、要素のスコープを加えた状態で出力する名前を求める
+	e.scopes = append(e.scopes, namespaceBindings(start.Attr))
+	defer func() { e.scopes = e.scopes[:len(e.scopes)-1] }()
+	names := []string{e.qualifiedName(start.Name, false)}
+	for _, attr := range start.Attr {
+		if attr.Name.Local != "" {
+			names = append(names, e.qualifiedName(attr.Name, true))
+		}
+	}
+	for i, name := range names {
+		what := fmt.Sprintf("element name '%s'", name)
+		if i > 0 {
+			what = fmt.Sprintf("attribute name '%s'", name)
+		}
+		if !refs {
+			if err := e.checkEncodable(what, []byte(name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.IndexFunc(name, func(r rune) bool { return r >= utf8.RuneSelf }) >= 0 {
+			return fmt.Errorf("%s contains non-ASCII characters, which char_refs cannot write as character references", what)
+		}
+	}
+	return nil
+}
+
+// writeStart は、開始タグを '>' の手前まで書き出します。
+func (e *tokenEncoder) writeStart(start xml.StartElement) {
+	e.writeIndent(1)
+	e.tags = append(e.tags, start.Name)
+	e.scopes = append(e.scopes, namespaceBindings(start.Attr))
+	if e.charRefDepth == 0 && e.charRefTags[start.Name.Local] {
+		e.charRefDepth = len(e.tags)
+	}
+	name := e.qualifiedName(start.Name, false)
+	e.w.WriteByte('<')
+	e.w.WriteString(name)
+	start.Attr = e.orderAttrs(start.Attr)
+
+	if e.attrWrapWidth <= 0 {
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "" {
+				continue
+			}
+			e.w.WriteByte(' ')
+			e.writeAttr(e.w, attr)
+		}
+		return
+	}
+
+	// 折り返しの要否を判定するため、属性を先に文字列化して長さを測る
+	var attrs []string
+	var buf bytes.Buffer
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "" {
+			continue
+		}
+		buf.Reset()
+		e.writeAttr(&buf, attr)
+		attrs = append(attrs, buf.String())
+	}
+	separator := " "
+	if e.exceedsWrapWidth(name, attrs) {
+		separator = "\n" + e.prefix + strings.Repeat(e.indent, e.depth+1)
+	}
+	for _, attr := range attrs {
+		e.w.WriteString(separator)
+		e.w.WriteString(attr)
+	}
+}
+
+// writeAttr は、属性を name="value" の形式で書き出します。
+func (e *tokenEncoder) writeAttr(w textWriter, attr xml.Attr) {
+	w.WriteString(e.qualifiedName(attr.Name, true))
+	w.WriteString(`="`)
+	escapeText(w, []byte(attr.Value), true, e.charRef)
+	w.WriteString(`"`)
+}
+
+// exceedsWrapWidth は、属性を1行に並べた開始タグが折り返し桁数を超えるかを判定します。
+// インデントしない出力と、属性が1つ以下の場合は折り返しません。
+func (e *tokenEncoder) exceedsWrapWidth(name string, attrs []string) bool {
+	if len(attrs) < 2 || len(e.prefix) == 0 && len(e.indent) == 0 {
+		return false
+	}
+	width := utf8.RuneCountInString(e.prefix) + (e.depth-1)*utf8.RuneCountInString(e.indent)
+	width += len("<") + utf8.RuneCountInString(name) + len(">")
+	for _, attr := range attrs {
+		width += 1 + utf8.RuneCountInString(attr)
+	}
+	return width > e.attrWrapWidth
+}
+
+// WriteRaw は、入力のバイト列をそのまま書き出します。
+// 最小変更モードで、ルールの影響を受けないトークンを出力するために使います。
+func (e *tokenEncoder) WriteRaw(raw []byte) error {
+	if err := e.closePending(); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(raw); err != nil {
+		return err
+	}
+	if len(raw) > 0 {
+		e.written = true
+	}
+	return nil
+}
+
+// WriteUnescaped は、保留中の開始タグを確定させ、s をエスケープせずにバッファへ書き出します。
+// raw_tags の CDATA セクションのように、エンコーダを介さずに出力する内容に使います。
+// WriteRaw と異なり、文書の内容を書き出したとは記録しません。
+func (e *tokenEncoder) WriteUnescaped(s string) error {
+	if err := e.closePending(); err != nil {
+		return err
+	}
+	_, err := e.w.WriteString(s)
+	return err
+}
+
+// WriteRawStart は、開始タグを入力のバイト列のまま書き出し、要素の開始として記録します。
+func (e *tokenEncoder) WriteRawStart(se xml.StartElement, raw []byte) error {
+	if err := e.WriteRaw(raw); err != nil {
+		return err
+	}
+	e.tags = append(e.tags, se.Name)
+	e.scopes = append(e.scopes, namespaceBindings(se.Attr))
+	return nil
+}
+
+// WriteRawEnd は、終了タグを入力のバイト列のまま書き出し、要素の終了として記録します。
+// 入力が自己終了タグ (<tag/>) の場合、raw は空になります。
+func (e *tokenEncoder) WriteRawEnd(ee xml.EndElement, raw []byte) error {
+	if len(e.tags) == 0 || e.tags[len(e.tags)-1] != ee.Name {
+		return fmt.Errorf("xml: end tag </%s> does not match the current element", ee.Name.Local)
+	}
+	if err := e.WriteRaw(raw); err != nil {
+		return err
+	}
+	e.tags = e.tags[:len(e.tags)-1]
+	e.scopes = e.scopes[:len(e.scopes)-1]
+	if len(e.tags) < e.charRefDepth {
+		e.charRefDepth = 0
+	}
+	return nil
+}
+
+// Flush は、保留中の開始タグを確定させ、バッファの内容を書き出します。
+func (e *tokenEncoder) Flush() error {
+	if err := e.closePending(); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// FlushBuffer は、保留中の開始タグを確定させずに、バッファの内容だけを書き出します。
+// 後続のトークンによって開始タグが自己終了タグになる余地を残したまま、途中までの出力を送り出せます。
+func (e *tokenEncoder) FlushBuffer() error {
+	return e.w.Flush()
+}
+
+// Buffered は、バッファに溜まっていてまだ書き出していないバイト数を返します。
+func (e *tokenEncoder) Buffered() int {
+	return e.w.Buffered()
+}
+
+// holdOutput は、出力の保留を始め (保留中であれば続け)、保留している出力の現在の位置を返します。
+// 保留中の開始タグは、返す位置より前に確定させます。保留ごとに releaseHeld を1回呼び出します。
+func (e *tokenEncoder) holdOutput() (int, error) {
+	if err := e.closePending(); err != nil {
+		return 0, err
+	}
+	if err := e.w.Flush(); err != nil {
+		return 0, err
+	}
+	if e.held == nil {
+		e.held = new(bytes.Buffer)
+		e.w.Reset(e.held)
+	}
+	e.holds++
+	return e.held.Len(), nil
+}
+
+// heldOffset は、保留している出力の現在の位置を返します。
+func (e *tokenEncoder) heldOffset() (int, error) {
+	if err := e.w.Flush(); err != nil {
+		return 0, err
+	}
+	return e.held.Len(), nil
+}
+
+// heldBytes は、保留している出力の start から現在の位置までを返します。
+// 返すスライスは、次に出力を書き込むまでの間だけ有効です。
+func (e *tokenEncoder) heldBytes(start int) ([]byte, error) {
+	if err := e.w.Flush(); err != nil {
+		return nil, err
+	}
+	return e.held.Bytes()[start:], nil
+}
+
+// replaceHeld は、保留している出力の start から現在の位置までを data に置き換えます。
+func (e *tokenEncoder) replaceHeld(start int, data []byte) error {
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	e.held.Truncate(start)
+	_, err := e.w.Write(data)
+	return err
+}
+
+// dropHeld は、保留している出力の start から end までを取り除きます。
+func (e *tokenEncoder) dropHeld(start, end int) error {
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	b := e.held.Bytes()
+	n := copy(b[start:], b[end:])
+	e.held.Truncate(start + n)
+	return nil
+}
+
+// releaseHeld は、保留の1つが確定したことを記録します。
+// すべての保留が確定したら、保留している出力を書き出し、保留を終えます。
+func (e *tokenEncoder) releaseHeld() error {
+	if e.holds--; e.holds > 0 {
+		return nil
+	}
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	held := e.held
+	e.held = nil
+	e.w.Reset(e.out)
+	_, err := e.w.Write(held.Bytes())
+	return err
+}
+
+// closePending は、保留中の開始タグがあれば '>' を書き出して確定させます。
+func (e *tokenEncoder) closePending() error {
+	if e.pending == nil {
+		return nil
+	}
+	e.pending = nil
+	return e.w.WriteByte('>')
+}
+
+// writeMiscIndent は、コメントと処理命令を独立した行に置く設定の場合に改行とインデントを書き出します。
+// 直後の終了タグも独立した行になるよう、要素の直後であるという状態を解除します。
+func (e *tokenEncoder) writeMiscIndent() {
+	if !e.miscOwnLine {
+		return
+	}
+	e.writeIndent(0)
+	e.indentedIn = false
+}
+
+// writeIndent は、encoding/xml の Encoder と同じ規則で改行とインデントを書き出します。
+func (e *tokenEncoder) writeIndent(depthDelta int) {
+	if len(e.prefix) == 0 && len(e.indent) == 0 {
+		return
+	}
+	if depthDelta < 0 {
+		e.depth--
+		if e.indentedIn {
+			e.indentedIn = false
+			return
+		}
+		e.indentedIn = false
+	}
+	if e.putNewline {
+		e.w.WriteByte('\n')
+	} else {
+		e.putNewline = true
+	}
+	e.w.WriteString(e.prefix)
+	for i := 0; i < e.depth; i++ {
+		e.w.WriteString(e.indent)
+	}
+	if depthDelta > 0 {
+		e.depth++
+		e.indentedIn = true
+	}
+}
+
+// qualifiedName は、名前空間URIを現在のスコープで宣言されている接頭辞に戻した名前を返します。
+// 宣言が見つからない場合 (未宣言の接頭辞など) は、Space をそのまま接頭辞として扱います。
+func (e *tokenEncoder) qualifiedName(name xml.Name, isAttr bool) string {
+	if isAttr && name.Space == "xmlns" {
+		return "xmlns:" + name.Local
+	}
+	prefix, _ := e.resolvePrefix(name, isAttr)
+	if prefix == "" {
+		return name.Local
+	}
+	return prefix + ":" + name.Local
+}
+
+// resolvePrefix は、名前の名前空間URIに対応する接頭辞とURIを現在のスコープから探します。
+// 未宣言の接頭辞の場合は、Space を接頭辞とし、URIを空文字列として返します。
+func (e *tokenEncoder) resolvePrefix(name xml.Name, isAttr bool) (prefix, uri string) {
+	switch name.Space {
+	case "":
+		return "", ""
+	case xmlNamespaceURI:
+		return "xml", xmlNamespaceURI
+	}
+	for i := len(e.scopes) - 1; i >= 0; i-- {
+		for _, b := range e.scopes[i] {
+			if b.uri != name.Space {
+				continue
+			}
+			// 属性は既定の名前空間に属さないため、接頭辞付きの宣言を探し続ける
+			if b.prefix == "" && isAttr {
+				continue
+			}
+			return b.prefix, b.uri
+		}
+	}
+	return name.Space, ""
+}
+
+// namespaceBinding は、名前空間接頭辞とURIの対応です。
+type namespaceBinding struct {
+	prefix string
+	uri    string
+}
+
+// namespaceBindings は、属性に含まれる名前空間宣言を宣言順に取り出します。
+// 既定の名前空間 (xmlns="...") は空文字列の接頭辞として扱います。
+func namespaceBindings(attrs []xml.Attr) []namespaceBinding {
+	var bindings []namespaceBinding
+	for _, attr := range attrs {
+		var prefix string
+		switch {
+		case attr.Name.Space == "xmlns":
+			prefix = attr.Name.Local
+		case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+			prefix = ""
+		default:
+			continue
+		}
+		bindings = append(bindings, namespaceBinding{prefix: prefix, uri: attr.Value})
+	}
+	return bindings
+}
+
+// textWriter は、バイト列と文字列の両方を書き込めるWriterです。
+type textWriter interface {
+	io.Writer
+	io.StringWriter
+}
+
+// cdataSection は、text をCDATAセクションで囲んだ文字列を返します。
+// text に終端の "]]>" が含まれる場合は、"]]" と ">" の間でセクションを分割します。
+func cdataSection(text string) string {
+	return "<![CDATA[" + splitCDATAEnd(text) + "]]>"
+}
+
+// splitCDATAEnd は、text の "]]>" を、"]]" と ">" の間でCDATAセクションを分割した形にします。
+func splitCDATAEnd(text string) string {
+	return strings.ReplaceAll(text, "]]>", "]]]]><![CDATA[>")
+}
+
+// WriteCDATA は、保留中の開始タグを確定させ、text をCDATAセクションで囲んで書き出します。
+// 数値文字参照で出力する文字は、セクションの外に数値文字参照で書き出します (writeCDATAContent)。
+func (e *tokenEncoder) WriteCDATA(text string) error {
+	if err := e.closePending(); err != nil {
+		return err
+	}
+	if !e.charRefs() && e.encodable == nil {
+		_, err := e.w.WriteString(cdataSection(text))
+		return err
+	}
+	if e.writeCDATAContent(splitCDATAEnd(text), false) {
+		_, err := e.w.WriteString("]]>")
+		return err
+	}
+	return nil
+}
+
+// writeCDATAContent は、"]]>" を分割済みの s をCDATAセクションの中身として書き出し、書き出した後に
+// セクションを開いているかどうかを返します。opened は、書き出す前にセクションを開いているかどうかです。
+// 数値文字参照で出力する文字 (charRef) の並びの前でセクションを閉じ、その文字を数値文字参照にして、
+// 続く文字の前でセクションを開き直します (中身が空の場合も、閉じたままにはしません)。
+func (e *tokenEncoder) writeCDATAContent(s string, opened bool) bool {
+	for first := true; first || len(s) > 0; first = false {
+		i := strings.IndexFunc(s, e.charRef)
+		if i < 0 {
+			i = len(s)
+		}
+		if i > 0 || len(s) == 0 {
+			if !opened {
+				e.w.WriteString("<![CDATA[")
+				opened = true
+			}
+			e.w.WriteString(s[:i])
+			s = s[i:]
+		}
+		if len(s) == 0 {
+			break
+		}
+		if opened {
+			e.w.WriteString("]]>")
+			opened = false
+		}
+		j := strings.IndexFunc(s, func(r rune) bool { return !e.charRef(r) })
+		if j < 0 {
+			j = len(s)
+		}
+		for _, r := range s[:j] {
+			fmt.Fprintf(e.w, "&#x%X;", r)
+		}
+		s = s[j:]
+	}
+	return opened
+}
+
+// escapeText は、テキストをXMLとして安全な形にエスケープして書き出します。
+// escapeNewline が true の場合は改行もエスケープします (属性値用)。
+// charRef が nil でなければ、charRef が true を返す文字を数値文字参照にします。
+func escapeText(w textWriter, s []byte, escapeNewline bool, charRef func(r rune) bool) {
+	last := 0
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRune(s[i:])
+		i += width
+		var esc string
+		switch r {
+		case '"':
+			esc = "&#34;"
+		case '\'':
+			esc = "&#39;"
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '>':
+			esc = "&gt;"
+		case '\t':
+			esc = "&#x9;"
+		case '\n':
+			if !escapeNewline {
+				continue
+			}
+			esc = "&#xA;"
+		case '\r':
+			esc = "&#xD;"
+		default:
+			if !isInCharacterRange(r) || (r == utf8.RuneError && width == 1) {
+				r = '\uFFFD'
+				esc = "\uFFFD"
+			}
+			if charRef != nil && charRef(r) {
+				esc = fmt.Sprintf("&#x%X;", r)
+			}
+			if esc == "" {
+				continue
+			}
+		}
+		w.Write(s[last : i-width])
+		w.WriteString(esc)
+		last = i
+	}
+	w.Write(s[last:])
+}
+
+// isInCharacterRange は、r がXML 1.0で使用できる文字かどうかを判定します。
+func isInCharacterRange(r rune) bool {
+	return r == 0x09 ||
+		r == 0x0A ||
+		r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
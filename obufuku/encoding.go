@@ -0,0 +1,233 @@
+package obufuku
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// autoEncoding は、入力エンコーディングを内容から自動判定する設定値です。
+const autoEncoding = "auto"
+
+// detectWindow は、エンコーディングの自動判定に使う先頭バイト数です。
+const detectWindow = 64 * 1024
+
+// lookupEncoding は、エンコーディング名 (Shift_JIS, EUC-JP など) から
+// 対応するエンコーディングと、XML宣言に書き込む正式名を返します。
+// 正式名はMIMEで使う名前 (EUC-JP など) とし、それが無いエンコーディングではIANAの登録名にします。
+func lookupEncoding(name string) (encoding.Encoding, string, error) {
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		return nil, "", fmt.Errorf("unsupported encoding: '%s'", name)
+	}
+	canonical, err := ianaindex.MIME.Name(enc)
+	if err != nil {
+		if canonical, err = ianaindex.IANA.Name(enc); err != nil {
+			canonical = name
+		}
+	}
+	return enc, canonical, nil
+}
+
+// isUTF8 は、エンコーディングがUTF-8（変換不要）かどうかを判定します。
+func isUTF8(enc encoding.Encoding) bool {
+	return enc == unicode.UTF8
+}
+
+// NewEncodingWriter は、UTF-8で書き込まれたデータを指定エンコーディングに
+// 変換して w に渡すWriterを作成します。変換結果を確定させるため、
+// 書き込み完了後に必ず Close を呼び出す必要があります。
+// エンコーディングで表せない文字は、途中で止まらないよう数値文字参照 (&#12354; など) にします
+// (Processor は、最小変更モードで入力のまま出力する部分を除いて、書き出す前に文字参照にしています)。
+func NewEncodingWriter(w io.Writer, enc encoding.Encoding) io.WriteCloser {
+	return transform.NewWriter(w, encoding.HTMLEscapeUnsupported(enc.NewEncoder()))
+}
+
+// newEncodableFunc は、文字をエンコーディング enc で表せるかを判定する関数を返します。
+// ASCIIの文字は表せるものとし、それ以外の文字の判定結果は文字ごとに記録しておきます。
+func newEncodableFunc(enc encoding.Encoding) func(r rune) bool {
+	encoder := enc.NewEncoder()
+	known := make(map[rune]bool)
+	return func(r rune) bool {
+		if r < utf8.RuneSelf {
+			return true
+		}
+		ok, found := known[r]
+		if !found {
+			_, err := encoder.String(string(r))
+			ok = err == nil
+			known[r] = ok
+		}
+		return ok
+	}
+}
+
+// NewInputReader は、入力エンコーディングの設定に応じて r をUTF-8に変換するReaderを返します。
+// 設定が空の場合は r をそのまま返し、XML宣言に基づく変換は CharsetReader に任せます。
+func NewInputReader(r io.Reader, setting string) (io.Reader, error) {
+	switch setting {
+	case "":
+		return r, nil
+	case autoEncoding:
+		return &autoDecodingReader{src: bufio.NewReaderSize(r, detectWindow)}, nil
+	default:
+		enc, _, err := lookupEncoding(setting)
+		if err != nil {
+			return nil, err
+		}
+		return transform.NewReader(r, enc.NewDecoder()), nil
+	}
+}
+
+// newCharsetReader は、xml.Decoder.CharsetReader に設定する関数を返します。
+// 入力をあらかじめUTF-8に変換している場合は、宣言に関わらずそのまま読み込みます。
+func newCharsetReader(setting string) func(label string, input io.Reader) (io.Reader, error) {
+	if setting != "" {
+		return func(label string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		}
+	}
+	return func(label string, input io.Reader) (io.Reader, error) {
+		enc, _, err := lookupEncoding(label)
+		if err != nil {
+			return nil, err
+		}
+		return transform.NewReader(input, enc.NewDecoder()), nil
+	}
+}
+
+// autoDecodingReader は、最初の読み込み時に先頭バイト列からエンコーディングを判定し、
+// 以降はUTF-8に変換しながら読み込むReaderです。
+type autoDecodingReader struct {
+	src *bufio.Reader
+	r   io.Reader
+}
+
+// Read は io.Reader インターフェースを実装します。
+func (a *autoDecodingReader) Read(p []byte) (int, error) {
+	if a.r == nil {
+		head, err := a.src.Peek(detectWindow)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		a.r = transform.NewReader(a.src, detectEncoding(head).NewDecoder())
+	}
+	return a.r.Read(p)
+}
+
+// declEncodingPattern は、XML宣言の encoding 疑似属性を検出します。
+var declEncodingPattern = regexp.MustCompile(`^<\?xml[^>]*\bencoding\s*=\s*["']([A-Za-z0-9._-]+)["']`)
+
+// detectEncoding は、入力の先頭バイト列からエンコーディングを推定します。
+// BOM、XML宣言、ISO-2022-JPのエスケープシーケンス、UTF-8としての妥当性の順に判定し、
+// いずれにも該当しない場合は Shift_JIS と EUC-JP のうちより自然に読める方を選びます。
+func detectEncoding(head []byte) encoding.Encoding {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return unicode.UTF8BOM
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	}
+	if m := declEncodingPattern.FindSubmatch(head); m != nil {
+		if enc, _, err := lookupEncoding(string(m[1])); err == nil {
+			return enc
+		}
+	}
+	if bytes.Contains(head, []byte("\x1b$B")) || bytes.Contains(head, []byte("\x1b$@")) {
+		return japanese.ISO2022JP
+	}
+	if validUTF8Prefix(head) {
+		return unicode.UTF8
+	}
+	if japaneseScore(head, japanese.EUCJP) > japaneseScore(head, japanese.ShiftJIS) {
+		return japanese.EUCJP
+	}
+	return japanese.ShiftJIS
+}
+
+// validUTF8Prefix は、末尾で途切れた文字を除いて b が妥当なUTF-8かどうかを判定します。
+func validUTF8Prefix(b []byte) bool {
+	for i := 0; i < utf8.UTFMax && len(b) > 0; i++ {
+		if utf8.Valid(b) {
+			return true
+		}
+		b = b[:len(b)-1]
+	}
+	return utf8.Valid(b)
+}
+
+// japaneseScore は、b を enc で復号した結果が日本語の文章としてどれだけ自然かを数値化します。
+// ひらがな・全角カタカナ・漢字を加点し、半角カタカナと復号できなかった文字を減点します。
+func japaneseScore(b []byte, enc encoding.Encoding) int {
+	decoded, err := enc.NewDecoder().Bytes(b)
+	if err != nil {
+		return -len(b)
+	}
+	score := 0
+	for _, r := range string(decoded) {
+		switch {
+		case r == utf8.RuneError:
+			score -= 10
+		case r >= 0x3040 && r <= 0x30FF, r >= 0x4E00 && r <= 0x9FFF:
+			score += 2
+		case r >= 0xFF61 && r <= 0xFF9F:
+			score--
+		}
+	}
+	return score
+}
+
+// declAttrPattern は、XML宣言の疑似属性 (name="value") を検出します。
+var declAttrPattern = regexp.MustCompile(`([A-Za-z]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// xmlDeclaration は、XML宣言 (<?xml ... ?>) の疑似属性を保持します。
+type xmlDeclaration struct {
+	Version    string
+	Encoding   string
+	Standalone string
+}
+
+// parseXMLDeclaration は、XML宣言の内容 (例: `version="1.0" encoding="UTF-8"`) を解析します。
+func parseXMLDeclaration(inst string) xmlDeclaration {
+	var decl xmlDeclaration
+	for _, m := range declAttrPattern.FindAllStringSubmatch(inst, -1) {
+		value := m[2] + m[3]
+		switch m[1] {
+		case "version":
+			decl.Version = value
+		case "encoding":
+			decl.Encoding = value
+		case "standalone":
+			decl.Standalone = value
+		}
+	}
+	return decl
+}
+
+// String は、XML仕様の順序 (version, encoding, standalone) で宣言の内容を組み立てます。
+func (d xmlDeclaration) String() string {
+	version := d.Version
+	if version == "" {
+		version = "1.0"
+	}
+	parts := []string{fmt.Sprintf(`version="%s"`, version)}
+	if d.Encoding != "" {
+		parts = append(parts, fmt.Sprintf(`encoding="%s"`, d.Encoding))
+	}
+	if d.Standalone != "" {
+		parts = append(parts, fmt.Sprintf(`standalone="%s"`, d.Standalone))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,99 @@
+package obufuku
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestLookupEncoding(t *testing.T) {
+	tests := []struct {
+		name      string
+		canonical string
+	}{
+		{"Shift_JIS", "Shift_JIS"},
+		{"shift_jis", "Shift_JIS"},
+		{"EUC-JP", "EUC-JP"},
+		{"euc-jp", "EUC-JP"},
+		{"latin1", "ISO-8859-1"},
+		{"utf-8", "UTF-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, canonical, err := lookupEncoding(tt.name)
+			if err != nil {
+				t.Fatalf("lookupEncoding: %v", err)
+			}
+			if canonical != tt.canonical {
+				t.Errorf("name = %q, want %q", canonical, tt.canonical)
+			}
+		})
+	}
+}
+
+// transformShiftJIS は、出力エンコーディングを Shift_JIS にして input を変換し、出力をUTF-8に戻して返します。
+func transformShiftJIS(t *testing.T, cfg Config, input string) (string, error) {
+	t.Helper()
+	cfg.Output.Encoding = "Shift_JIS"
+	out, err := tryTransformString(t, cfg, input)
+	decoded, decodeErr := japanese.ShiftJIS.NewDecoder().String(out)
+	if decodeErr != nil {
+		t.Fatalf("output is not Shift_JIS: %v", decodeErr)
+	}
+	return decoded, err
+}
+
+// shiftJISDeclaration は、出力エンコーディングを Shift_JIS にしたときに追加されるXML宣言です。
+const shiftJISDeclaration = `<?xml version="1.0" encoding="Shift_JIS"?>`
+
+func TestUnencodableCharacters(t *testing.T) {
+	// Shift_JIS で表せない文字は、途中で止まらずに数値文字参照で出力する
+	tests := []struct {
+		name  string
+		cfg   Config
+		input string
+		want  string
+	}{
+		{"text", Config{}, `<a>日本é😀</a>`, `<a>日本&#xE9;&#x1F600;</a>`},
+		{"attribute", Config{}, `<a k="é日"/>`, `<a k="&#xE9;日"></a>`},
+		{"raw tags", Config{RawTags: []string{"a"}}, `<a>x&lt;é日</a>`, `<a><![CDATA[x<]]>&#xE9;<![CDATA[日]]></a>`},
+		{"minimal", Config{Output: ConfigOutput{Minimal: true}}, `<a>é<b/></a>`, `<a>&#233;<b/></a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Output.Compact = !tt.cfg.Output.Minimal
+			got, err := transformShiftJIS(t, tt.cfg, tt.input)
+			if err != nil {
+				t.Fatalf("Transform: %v", err)
+			}
+			if want := shiftJISDeclaration + tt.want; got != want {
+				t.Errorf("output = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestUnencodableCharacterErrors(t *testing.T) {
+	// 文字参照にできない位置の文字は、書き出す前にエラーにする
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"element name", `<a><é/></a>`, "element name 'é' contains the character U+00E9"},
+		{"attribute name", `<a é="1"/>`, "attribute name 'é' contains the character U+00E9"},
+		{"comment", `<a><!-- é --></a>`, "comment contains the character U+00E9"},
+		{"processing instruction", `<a><?pi é?></a>`, "processing instruction contains the character U+00E9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := transformShiftJIS(t, Config{}, tt.input)
+			var encodeErr *EncodeError
+			if !errors.As(err, &encodeErr) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Transform error = %v, want an EncodeError containing %q", err, tt.err)
+			}
+		})
+	}
+}
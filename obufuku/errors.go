@@ -0,0 +1,123 @@
+package obufuku
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RuleConfigError は、設定が不正なため RuleSet を組み立てられない場合のエラーです。
+type RuleConfigError struct {
+	// Rule は、不正なルールまたは設定の位置です ("value_rules[2]"、"output" など)。
+	Rule string
+	Err  error
+}
+
+func (e *RuleConfigError) Error() string {
+	return fmt.Sprintf("invalid rule configuration in %s: %v", e.Rule, e.Err)
+}
+
+func (e *RuleConfigError) Unwrap() error { return e.Err }
+
+// ParseError は、入力のXMLを読み込めない場合や、入力が設定の上限や制約に違反している場合のエラーです。
+type ParseError struct {
+	// Input は、複数の入力を処理している場合の入力の名前です (1つの入力の場合は空)。
+	Input string
+	// Line と Column は、エラーを検出した入力の位置 (1始まり) です。
+	Line   int
+	Column int
+	// Path は、エラーを検出したときに開いていた要素の入力上のパス ("/root/item" など) です。
+	// 入れ子の深さの上限を超えた場合は、末尾の要素だけのパス (".../item/note" など) になります。
+	Path string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse XML %s: %v", formatPosition(e.Input, e.Line, e.Column, e.Path), e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// EncodeError は、ルールの適用や出力の書き込みに失敗した場合のエラーです。
+type EncodeError struct {
+	// Input は、複数の入力を処理している場合の入力の名前です (1つの入力の場合は空)。
+	Input string
+	// Line と Column は、処理していたトークンの入力の位置 (1始まり) です。
+	Line   int
+	Column int
+	// Path は、処理していたトークンを囲む要素の入力上のパス ("/root/item" など) です。
+	Path string
+	// Rule は、失敗したルールの位置です ("value_rules[2]" など。ルールに依らない場合は空)。
+	Rule string
+	Err  error
+}
+
+func (e *EncodeError) Error() string {
+	rule := ""
+	if e.Rule != "" {
+		rule = " by " + e.Rule
+	}
+	return fmt.Sprintf("failed to transform XML %s%s: %v", formatPosition(e.Input, e.Line, e.Column, e.Path), rule, e.Err)
+}
+
+func (e *EncodeError) Unwrap() error { return e.Err }
+
+// formatPosition は、エラーメッセージに含める入力の位置を整形します。
+func formatPosition(input string, line, column int, path string) string {
+	position := fmt.Sprintf("at line %d, column %d", line, column)
+	if input != "" {
+		position = fmt.Sprintf("in '%s' %s", input, position)
+	}
+	if path != "" {
+		position += fmt.Sprintf(" (in %s)", path)
+	}
+	return position
+}
+
+// positionError は、トークンの処理中のエラーに入力の位置と開いている要素のパスを付けます。
+// ParseError と EncodeError は位置を補って返し、それ以外のエラーは EncodeError で包みます。
+func (p *Processor) positionError(input string, err error) error {
+	line, column := p.line, p.column
+	path := p.inputPath()
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		if parseErr.Line == 0 {
+			parseErr.Input, parseErr.Line, parseErr.Column = input, line, column
+			if parseErr.Path == "" {
+				parseErr.Path = path
+			}
+		}
+		return err
+	}
+	var encodeErr *EncodeError
+	if errors.As(err, &encodeErr) {
+		if encodeErr.Line == 0 {
+			encodeErr.Input, encodeErr.Line, encodeErr.Column, encodeErr.Path = input, line, column, path
+		}
+		return err
+	}
+	return &EncodeError{Input: input, Line: line, Column: column, Path: path, Err: err}
+}
+
+// maxErrorPathSegments は、入れ子の深さの上限を超えたエラーで報告するパスの、末尾の要素の数です。
+const maxErrorPathSegments = 5
+
+// inputPath は、開いている要素の入力上の名前 (ルールによる変更前の名前) のパスを返します。
+func (p *Processor) inputPath() string {
+	return p.inputPathTail(len(p.elementStack))
+}
+
+// inputPathTail は、inputPath の末尾の n 個の要素だけのパスを返します。省いた祖先は "..." で表します。
+func (p *Processor) inputPathTail(n int) string {
+	var b strings.Builder
+	stack := p.elementStack
+	if len(stack) > n {
+		stack = stack[len(stack)-n:]
+		b.WriteString("...")
+	}
+	for _, el := range stack {
+		b.WriteString("/")
+		b.WriteString(el.Input.Local)
+	}
+	return b.String()
+}
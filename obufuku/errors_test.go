@@ -0,0 +1,201 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewRuleSetErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		rule string
+	}{
+		{"unknown value type", Config{ValueRules: []ConfigValueRule{{Target: "a", Type: "no_such_type"}}}, "value_rules[0]"},
+		{"invalid script", Config{ValueRules: []ConfigValueRule{{Target: "a", Type: "append", Params: params{"suffix": "!"}}, {Target: "a", Type: "script"}}}, "value_rules[1]"},
+		{"unknown counter", Config{InsertRules: []ConfigInsertRule{{Target: "a", Template: "<n/>", Counter: "missing"}}}, "insert_rules[0]"},
+		{"unknown counter in insert after", Config{InsertAfterRules: []ConfigInsertRule{{Target: "a", Template: "<n/>"}, {Target: "a", Template: "<n/>", Counter: "missing"}}}, "insert_after_rules[1]"},
+		{"tag renamed twice", Config{NameRules: []ConfigNameRule{{Old: "a", New: "b"}, {Old: "a", New: "c"}}}, "name_rules[1]"},
+		{"rename chain", Config{NameRules: []ConfigNameRule{{Old: "a", New: "b"}, {Old: "b", New: "c"}}}, "name_rules[0]"},
+		{"target wrapped twice", Config{WrapRules: []ConfigWrapRule{{Target: "a", Wrapper: "w"}, {Target: "a", Wrapper: "v"}}}, "wrap_rules[1]"},
+		{"wrapper is renamed", Config{NameRules: []ConfigNameRule{{Old: "w", New: "x"}}, WrapRules: []ConfigWrapRule{{Target: "a", Wrapper: "w"}}}, "wrap_rules[0]"},
+		{"invalid new name", Config{NameRules: []ConfigNameRule{{Old: "a", New: "1x"}}}, "name_rules[0]"},
+		{"new name with two colons", Config{NameRules: []ConfigNameRule{{Old: "a", New: "p:q:r"}}}, "name_rules[0]"},
+		{"invalid wrapper", Config{WrapRules: []ConfigWrapRule{{Target: "a", Wrapper: "w x"}}}, "wrap_rules[0]"},
+		{"control character in cdata replacement", Config{CdataRules: []ConfigCdataRule{{Old: "x", New: "\x01"}}}, "cdata_rules[0]"},
+		{"capture without target", Config{CaptureRules: []ConfigCaptureRule{{Variable: "v"}}}, "capture_rules[0]"},
+		{"invalid variable name", Config{CaptureRules: []ConfigCaptureRule{{Target: "a", Variable: "1v"}}}, "capture_rules[0]"},
+		{"var without argument", Config{InsertRules: []ConfigInsertRule{{Target: "a", Template: "<v>{{var}}</v>"}}}, "insert_rules[0]"},
+		{"unknown summary function", Config{SummaryRules: []ConfigSummaryRule{{Target: "a", Element: "n", Function: "avg"}}}, "summary_rules[0]"},
+		{"invalid summary element", Config{SummaryRules: []ConfigSummaryRule{{Target: "a", Element: ""}}}, "summary_rules[0]"},
+		{"unknown output encoding", Config{Output: ConfigOutput{Encoding: "no-such-encoding"}}, "output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRuleSet(tt.cfg)
+			var configErr *RuleConfigError
+			if !errors.As(err, &configErr) {
+				t.Fatalf("NewRuleSet error = %v, want a RuleConfigError", err)
+			}
+			if configErr.Rule != tt.rule {
+				t.Errorf("Rule = %q, want %q", configErr.Rule, tt.rule)
+			}
+		})
+	}
+}
+
+func TestNewRuleSetSwapNames(t *testing.T) {
+	// 名前の入れ替えは連鎖ではない
+	cfg := Config{NameRules: []ConfigNameRule{{Old: "a", New: "b"}, {Old: "b", New: "a"}}, Output: compactOutput}
+	if got, _ := transformString(t, cfg, `<a><b/></a>`); got != `<b><a></a></b>` {
+		t.Errorf("output = %q, want %q", got, `<b><a></a></b>`)
+	}
+}
+
+func TestValidateText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{"plain", "abc 日本語\t\n", ""},
+		{"replacement character", "a\uFFFDb", ""},
+		{"control character", "a\x01", "contains the character U+0001"},
+		{"invalid utf-8", "ab\xff", "invalid UTF-8 at byte 2"},
+		{"noncharacter", "\uFFFE", "contains the character U+FFFE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateText("text", tt.text)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateText error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateText error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValueRuleInvalidReplacement(t *testing.T) {
+	// 置換後の値にXMLで使えない文字が含まれる場合は出力しない
+	cfg := Config{ValueRules: []ConfigValueRule{{Target: "v", Type: "script", Params: params{"expr": `"a\u0001"`}}}}
+	_, err := tryTransformString(t, cfg, "<v>x</v>")
+	var encodeErr *EncodeError
+	if !errors.As(err, &encodeErr) || encodeErr.Rule != "value_rules[0]" {
+		t.Errorf("Transform error = %v, want an EncodeError for value_rules[0]", err)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	counters := map[string]ConfigCounter{"c": {}}
+	tests := []struct {
+		name    string
+		rule    ConfigInsertRule
+		wantErr string
+	}{
+		{"unclosed tag", ConfigInsertRule{Target: "a", Template: "<n>"}, "not a well-formed XML fragment"},
+		{"mismatched tags", ConfigInsertRule{Target: "a", Template: "<n></m>"}, "not a well-formed XML fragment"},
+		{"counter without verb", ConfigInsertRule{Target: "a", Template: "<n/>", Counter: "c"}, "exactly one %d"},
+		{"counter with two verbs", ConfigInsertRule{Target: "a", Template: "<n>%d-%d</n>", Counter: "c"}, "exactly one %d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRuleSet(Config{PrependChildRules: []ConfigInsertRule{tt.rule}, Counters: counters})
+			var configErr *RuleConfigError
+			if !errors.As(err, &configErr) || configErr.Rule != "prepend_child_rules[0]" {
+				t.Fatalf("NewRuleSet error = %v, want a RuleConfigError for prepend_child_rules[0]", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewRuleSet error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSummaryNotANumber(t *testing.T) {
+	cfg := Config{SummaryRules: []ConfigSummaryRule{{Target: "price", Element: "total", Function: "sum"}}}
+	_, err := tryTransformString(t, cfg, "<a><price>1,000</price></a>")
+	var encodeErr *EncodeError
+	if !errors.As(err, &encodeErr) || encodeErr.Rule != "summary_rules[0]" {
+		t.Errorf("Transform error = %v, want an EncodeError for summary_rules[0]", err)
+	}
+}
+
+func TestTransformParseError(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		line   int
+		column int
+		path   string
+	}{
+		{"mismatched end tag", "<a>\n<b></c></a>", 2, 8, "/a/b"},
+		{"unclosed root", "<a><b></b>", 1, 11, "/a"},
+		{"over the depth limit", "<a>\n  <b><c/></b>\n</a>", 2, 10, "/a/b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Input: ConfigInput{Limits: ConfigLimits{MaxDepth: 2}}}
+			_, err := Transform(context.Background(), cfg, strings.NewReader(tt.input), &bytes.Buffer{})
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Transform error = %v, want a ParseError", err)
+			}
+			if parseErr.Line != tt.line || parseErr.Column != tt.column {
+				t.Errorf("position = %d:%d, want %d:%d", parseErr.Line, parseErr.Column, tt.line, tt.column)
+			}
+			if parseErr.Path != tt.path {
+				t.Errorf("path = %q, want %q", parseErr.Path, tt.path)
+			}
+		})
+	}
+}
+
+func TestMaxDepthErrorPath(t *testing.T) {
+	// 深い入れ子のパスは、末尾の要素だけを報告する
+	input := "<root>" + strings.Repeat("<e>", 9) + "<last/>" + strings.Repeat("</e>", 9) + "</root>"
+	cfg := Config{Input: ConfigInput{Limits: ConfigLimits{MaxDepth: 10}}}
+	_, err := Transform(context.Background(), cfg, strings.NewReader(input), &bytes.Buffer{})
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Transform error = %v, want a ParseError", err)
+	}
+	if want := ".../e/e/e/e/e"; parseErr.Path != want {
+		t.Errorf("path = %q, want %q", parseErr.Path, want)
+	}
+	if want := "element 'last' at depth 11 exceeds the maximum nesting depth of 10"; !strings.Contains(err.Error(), want) {
+		t.Errorf("Transform error = %v, want it to contain %q", err, want)
+	}
+}
+
+func TestTransformEncodeError(t *testing.T) {
+	cfg := Config{NameRules: []ConfigNameRule{{Old: "a", New: "b"}}, ValueRules: []ConfigValueRule{
+		{Target: "a", Type: "append", Params: params{"suffix": "!"}},
+		{Target: "b", Type: "script", Params: params{"expr": `next("missing")`}},
+	}}
+	_, err := Transform(context.Background(), cfg, strings.NewReader("<a>\n<b>x</b></a>"), &bytes.Buffer{})
+	var encodeErr *EncodeError
+	if !errors.As(err, &encodeErr) {
+		t.Fatalf("Transform error = %v, want an EncodeError", err)
+	}
+	if encodeErr.Rule != "value_rules[1]" {
+		t.Errorf("Rule = %q, want %q", encodeErr.Rule, "value_rules[1]")
+	}
+	if encodeErr.Line != 2 {
+		t.Errorf("Line = %d, want 2", encodeErr.Line)
+	}
+	// パスは入力上の名前で報告する
+	if encodeErr.Path != "/a/b" {
+		t.Errorf("Path = %q, want %q", encodeErr.Path, "/a/b")
+	}
+	if !strings.Contains(err.Error(), "at line 2, column 5 (in /a/b) by value_rules[1]") {
+		t.Errorf("error %q does not report the position and the rule", err)
+	}
+}
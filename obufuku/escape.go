@@ -0,0 +1,101 @@
+package obufuku
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// 実体参照の変換の種類 (値置換ルールの種類と、cdata_rules の entities)
+const (
+	entitiesUnescape = "unescape"
+	entitiesEscape   = "escape"
+	// entitiesDecode と entitiesNumeric は、埋め込まれたHTMLの文字参照を、マークアップとして意味を持つ文字
+	// ('<'、'>'、'&' と引用符) のものを除いて、文字に戻すか数値文字参照にそろえます (cdata_rules の entities のみ)。
+	entitiesDecode  = "decode"
+	entitiesNumeric = "numeric"
+)
+
+// htmlEntityValueTypes は、文字参照を変換する値置換ルールの種類と、raw_tags の要素の中身に同じ変換をする
+// cdata_rules の entities の値です。
+var htmlEntityValueTypes = map[string]string{
+	"html_unescape": entitiesUnescape,
+	"html_escape":   entitiesEscape,
+}
+
+// charRefPattern は、HTMLの文字参照 (&nbsp;、&#160;、&#xA0;) です。セミコロンで終わるものだけを対象にします。
+var charRefPattern = regexp.MustCompile(`&(?:[A-Za-z][A-Za-z0-9]*|#[0-9]+|#[xX][0-9A-Fa-f]+);`)
+
+// maxEntityLength は、分割されたテキストで次の部分と合わせて解釈する、実体参照の最大の長さです。
+// HTMLの名前付き文字参照で最も長いもの (&CounterClockwiseContourIntegral;) が収まる長さにします。
+const maxEntityLength = 40
+
+// newHTMLUnescapeFunc は、値に含まれるHTMLの文字参照 (&lt;、&amp;、&#x3042; など) を文字に戻す置換関数を作成します。
+// params["repeat"] が true の場合は、二重にエスケープされた値 (&amp;lt; など) も、変わらなくなるまで繰り返し戻します。
+func newHTMLUnescapeFunc(params map[string]interface{}) (ValueReplaceFunc, error) {
+	repeat := false
+	if v, ok := params["repeat"]; ok {
+		if repeat, ok = v.(bool); !ok {
+			return nil, fmt.Errorf("invalid 'repeat' for html_unescape rule: must be a boolean")
+		}
+	}
+	return func(oldValue string) string {
+		value := html.UnescapeString(oldValue)
+		for repeat && value != oldValue {
+			oldValue = value
+			value = html.UnescapeString(oldValue)
+		}
+		return value
+	}, nil
+}
+
+// newHTMLEscapeFunc は、値の '<'、'>'、'&' と引用符を文字参照にする置換関数を作成します。
+// 出力時のエスケープに加えてもう一段エスケープするため、出力を読み込んだ側では文字参照のままの文字列になります。
+func newHTMLEscapeFunc(params map[string]interface{}) (ValueReplaceFunc, error) {
+	return html.EscapeString, nil
+}
+
+// convertEntities は、mode に従って s の文字参照を戻すか、文字を文字参照にします。
+func convertEntities(mode, s string) string {
+	switch mode {
+	case entitiesEscape:
+		return html.EscapeString(s)
+	case entitiesDecode, entitiesNumeric:
+		return normalizeCharRefs(mode, s)
+	}
+	return html.UnescapeString(s)
+}
+
+// normalizeCharRefs は、s の文字参照のうち、マークアップとして意味を持つ文字以外のものを、mode が "decode" の場合は
+// 文字に戻し、"numeric" の場合は10進の数値文字参照 (&nbsp; は &#160;) にします。
+// 未知の名前の参照と、XMLで使えない文字の参照はそのまま残します。
+func normalizeCharRefs(mode, s string) string {
+	return charRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		text := html.UnescapeString(ref)
+		if text == ref || strings.ContainsAny(text, `<>&"'`) || validateText("", text) != nil {
+			return ref
+		}
+		if mode == entitiesDecode {
+			return text
+		}
+		var b strings.Builder
+		for _, r := range text {
+			fmt.Fprintf(&b, "&#%d;", r)
+		}
+		return b.String()
+	})
+}
+
+// convertEntitiesSplit は、分割されたテキストの部分 data に carry (前の部分の末尾) を合わせて文字参照を変換します。
+// 続き (more) がある場合は、次の部分にまたがる可能性がある末尾の文字参照を変換せず、次の carry として返します。
+// すべての部分を通して convertEntities と同じ結果になります。
+func convertEntitiesSplit(mode, carry, data string, more bool) (converted, nextCarry string) {
+	s := carry + data
+	if more && mode != entitiesEscape {
+		if i := strings.LastIndexByte(s, '&'); i >= 0 && len(s)-i < maxEntityLength && !strings.ContainsRune(s[i:], ';') {
+			s, nextCarry = s[:i], s[i:]
+		}
+	}
+	return convertEntities(mode, s), nextCarry
+}
@@ -0,0 +1,125 @@
+package obufuku
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConvertEntitiesSplit(t *testing.T) {
+	const s = "a &lt;b&gt; &nbsp;&CounterClockwiseContourIntegral; &#x3042; &#1; & c;"
+	for _, mode := range []string{entitiesUnescape, entitiesEscape, entitiesDecode, entitiesNumeric} {
+		want := convertEntities(mode, s)
+		for size := 1; size <= len(s); size++ {
+			var got, carry string
+			for start := 0; start < len(s); start += size {
+				end := min(start+size, len(s))
+				var converted string
+				converted, carry = convertEntitiesSplit(mode, carry, s[start:end], end < len(s))
+				got += converted
+			}
+			if got+carry != want {
+				t.Errorf("%s in parts of %d = %q, want %q", mode, size, got+carry, want)
+			}
+		}
+	}
+}
+
+func TestNormalizeCharRefs(t *testing.T) {
+	tests := []struct {
+		input   string
+		decode  string
+		numeric string
+	}{
+		{"&nbsp;&copy;", "\u00a0\u00a9", "&#160;&#169;"},
+		{"&#xA9;&#169;", "\u00a9\u00a9", "&#169;&#169;"},
+		{"&lt;&gt;&amp;&quot;&apos;&#60;", "&lt;&gt;&amp;&quot;&apos;&#60;", "&lt;&gt;&amp;&quot;&apos;&#60;"},
+		{"&unknown; &nbsp &#1;", "&unknown; &nbsp &#1;", "&unknown; &nbsp &#1;"},
+		{"&NotEqualTilde;", "\u2242\u0338", "&#8770;&#824;"},
+	}
+	for _, tt := range tests {
+		if got := normalizeCharRefs(entitiesDecode, tt.input); got != tt.decode {
+			t.Errorf("decode %q = %q, want %q", tt.input, got, tt.decode)
+		}
+		if got := normalizeCharRefs(entitiesNumeric, tt.input); got != tt.numeric {
+			t.Errorf("numeric %q = %q, want %q", tt.input, got, tt.numeric)
+		}
+	}
+}
+
+func TestCdataEntities(t *testing.T) {
+	tests := []struct {
+		name     string
+		entities string
+		input    string
+		want     string
+	}{
+		{"unescape", "unescape", `<r>&lt;b&gt;x&amp;amp;&lt;/b&gt;</r>`, `<r><![CDATA[<b>x&</b>]]></r>`},
+		{"escape", "escape", `<r><![CDATA[<b>"x"</b>]]></r>`, `<r><![CDATA[&lt;b&gt;&#34;x&#34;&lt;/b&gt;]]></r>`},
+		{"unchanged", "unescape", `<r>plain</r>`, `<r><![CDATA[plain]]></r>`},
+		{"decode", "decode", `<r>&amp;nbsp;&amp;copy;&amp;lt;b&amp;gt;</r>`, "<r><![CDATA[\u00a0\u00a9&lt;b&gt;]]></r>"},
+		{"numeric", "numeric", `<r>&amp;nbsp;&amp;#xA9;&amp;amp;</r>`, `<r><![CDATA[&#160;&#169;&amp;]]></r>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				RawTags:    []string{"r"},
+				CdataRules: []ConfigCdataRule{{Entities: tt.entities}},
+				Output:     compactOutput,
+			}
+			got, _ := transformString(t, cfg, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCdataEntitiesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ConfigCdataRule
+	}{
+		{"unknown mode", ConfigCdataRule{Entities: "html"}},
+		{"with old", ConfigCdataRule{Entities: "unescape", Old: "a"}},
+		{"with new", ConfigCdataRule{Entities: "escape", New: "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRuleSet(Config{RawTags: []string{"r"}, CdataRules: []ConfigCdataRule{tt.rule}})
+			var configErr *RuleConfigError
+			if !errors.As(err, &configErr) || configErr.Rule != "cdata_rules[0]" {
+				t.Errorf("NewRuleSet error = %v, want a RuleConfigError for cdata_rules[0]", err)
+			}
+		})
+	}
+}
+
+func TestHTMLEntityValueRulesOnRawTags(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  string
+		mode string
+	}{
+		{"unescape", "html_unescape", "'entities': 'unescape'"},
+		{"escape", "html_escape", "'entities': 'escape'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// raw_tags の要素の中身には適用されないため、cdata_rules を案内する
+			_, err := NewRuleSet(Config{RawTags: []string{"r"}, ValueRules: []ConfigValueRule{{Target: "r", Type: tt.typ}}})
+			var configErr *RuleConfigError
+			if !errors.As(err, &configErr) || configErr.Rule != "value_rules[0]" {
+				t.Fatalf("NewRuleSet error = %v, want a RuleConfigError for value_rules[0]", err)
+			}
+			if !strings.Contains(err.Error(), "cdata_rules") || !strings.Contains(err.Error(), tt.mode) {
+				t.Errorf("NewRuleSet error = %v, want it to point to cdata_rules with %s", err, tt.mode)
+			}
+		})
+	}
+	// raw_tags 以外の要素には適用できる
+	cfg := Config{RawTags: []string{"r"}, ValueRules: []ConfigValueRule{{Target: "v", Type: "html_unescape"}}, Output: compactOutput}
+	if got, _ := transformString(t, cfg, `<a><v>&amp;lt;b&amp;gt;</v><r>x</r></a>`); got != `<a><v>&lt;b&gt;</v><r><![CDATA[x]]></r></a>` {
+		t.Errorf("output = %q, want %q", got, `<a><v>&lt;b&gt;</v><r><![CDATA[x]]></r></a>`)
+	}
+}
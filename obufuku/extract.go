@@ -0,0 +1,204 @@
+package obufuku
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExtractConfig は、XMLから表形式のデータを取り出す extract の設定ファイルの内容です。
+type ExtractConfig struct {
+	// Record は、1行に対応する繰り返しの要素です。"/root/items/item" のように / で始まる場合は
+	// 文書要素からのパス、"item" や "items/item" のように / で始まらない場合は末尾が一致する要素を表します。
+	Record string `json:"record"`
+	// Columns は、出力する列です。
+	Columns []ExtractColumn `json:"columns"`
+	// Input は、入力の読み込みに関する設定です (ルールファイルの input と同じです)。
+	Input ConfigInput `json:"input"`
+}
+
+// ExtractColumn は、extract が出力する1つの列です。
+type ExtractColumn struct {
+	// Name は、見出しの行に出力する列名です。空の場合は Path を使います。
+	Name string `json:"name"`
+	// Path は、Record の要素からの相対パスです。"info/title" は子孫の要素のテキスト、
+	// "@id" や "info/@lang" は属性の値、"." は Record の要素自身のテキストを表します。
+	// 一致する要素が複数ある場合は最初の要素の値を使い、一致しない場合は空になります。
+	Path string `json:"path"`
+}
+
+// extractPath は、解析済みの列のパスです。
+type extractPath struct {
+	// elements は、Record の要素から値を取り出す要素までの名前です (Record 自身の場合は空)。
+	elements []string
+	// attr は、取り出す属性の名前です (要素のテキストを取り出す場合は空)。
+	attr string
+}
+
+// Extractor は、XMLの入力を読み込み、設定の Record の要素ごとに列の値を取り出します。
+type Extractor struct {
+	record  elementPattern
+	columns []extractPath
+	header  []string
+	input   InputOptions
+}
+
+// NewExtractor は、設定を検証して Extractor を作成します。
+func NewExtractor(config ExtractConfig) (*Extractor, error) {
+	if config.Record == "" {
+		return nil, fmt.Errorf("'record' is required")
+	}
+	if len(config.Columns) == 0 {
+		return nil, fmt.Errorf("at least one column is required in 'columns'")
+	}
+	input, err := buildInputOptions(config.Input, false)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := parseElementPattern(config.Record)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record: %w", err)
+	}
+	e := &Extractor{record: record, input: input}
+	for i, column := range config.Columns {
+		path, err := parseExtractPath(column.Path)
+		if err != nil {
+			return nil, fmt.Errorf("columns[%d]: %w", i, err)
+		}
+		e.columns = append(e.columns, path)
+		name := column.Name
+		if name == "" {
+			name = column.Path
+		}
+		e.header = append(e.header, name)
+	}
+	return e, nil
+}
+
+// parseExtractPath は、列のパスを解析します。
+func parseExtractPath(p string) (extractPath, error) {
+	if p == "." {
+		return extractPath{}, nil
+	}
+	var path extractPath
+	segments := strings.Split(p, "/")
+	if last := segments[len(segments)-1]; strings.HasPrefix(last, "@") {
+		path.attr = last[1:]
+		segments = segments[:len(segments)-1]
+		if !isNCName(path.attr) {
+			return path, fmt.Errorf("invalid attribute name in path '%s'", p)
+		}
+	}
+	for _, name := range segments {
+		if !isNCName(name) {
+			return path, fmt.Errorf("invalid path '%s' (expected element names separated by '/', optionally ending in '@attribute')", p)
+		}
+	}
+	path.elements = segments
+	return path, nil
+}
+
+// Header は、列名を返します。
+func (e *Extractor) Header() []string {
+	return append([]string(nil), e.header...)
+}
+
+// Extract は、r のXMLを読み込み、Record の要素を閉じるたびに列の値を emit に渡します。
+// Record の要素の中に同じ名前の要素がある場合、その要素は別の行ではなく子孫として扱います。
+// 要素と属性の名前は、名前空間接頭辞を除いたローカル名で比較します。テキストの前後の空白は取り除きます。
+// emit がエラーを返した場合は読み込みを中断します。出力した行の数を返します。
+func (e *Extractor) Extract(ctx context.Context, r io.Reader, emit func(record []string) error) (int, error) {
+	reader, err := NewInputReader(r, e.input.Encoding)
+	if err != nil {
+		return 0, err
+	}
+	state := &extractState{Extractor: e, emit: emit}
+	p := NewProcessor(reader, io.Discard, WithInputOptions(e.input), WithHooks(Hooks{
+		OnStartElement: state.startElement,
+		OnCharData:     state.charData,
+		OnEndElement:   state.endElement,
+	}))
+	_, err = p.Run(ctx)
+	return state.records, err
+}
+
+// extractState は、Extract の読み込み中の状態です。
+type extractState struct {
+	*Extractor
+	emit    func(record []string) error
+	records int
+
+	stack []string
+	// recordDepth は、読み込み中の Record の要素の深さです (Record の外では 0)。
+	recordDepth int
+	values      []string
+	found       []bool
+	// capturing は、テキストを集めている列と、その要素の深さです。
+	capturing map[int]int
+	texts     map[int]*strings.Builder
+}
+
+// startElement は、開始タグごとに呼び出され、Record の開始と列の要素を検出します。
+func (s *extractState) startElement(se *xml.StartElement) (bool, error) {
+	s.stack = append(s.stack, se.Name.Local)
+	if s.recordDepth == 0 {
+		if !s.record.match(s.stack) {
+			return true, nil
+		}
+		s.recordDepth = len(s.stack)
+		s.values = make([]string, len(s.columns))
+		s.found = make([]bool, len(s.columns))
+		s.capturing = make(map[int]int)
+		s.texts = make(map[int]*strings.Builder)
+	}
+
+	relative := s.stack[s.recordDepth:]
+	for i, column := range s.columns {
+		if s.found[i] || !equalNames(relative, column.elements) {
+			continue
+		}
+		if column.attr == "" {
+			if _, ok := s.capturing[i]; !ok {
+				s.capturing[i] = len(s.stack)
+				s.texts[i] = &strings.Builder{}
+			}
+			continue
+		}
+		for _, attr := range se.Attr {
+			if attr.Name.Local == column.attr {
+				s.values[i], s.found[i] = attr.Value, true
+				break
+			}
+		}
+	}
+	return true, nil
+}
+
+// charData は、テキストごとに呼び出され、テキストを集めている列に加えます。
+func (s *extractState) charData(cd *xml.CharData, _ ValueContext) (bool, error) {
+	for i := range s.capturing {
+		s.texts[i].Write(*cd)
+	}
+	return true, nil
+}
+
+// endElement は、終了タグごとに呼び出され、列の値を確定させ、Record の終わりで行を出力します。
+func (s *extractState) endElement(xml.EndElement) error {
+	depth := len(s.stack)
+	for i, d := range s.capturing {
+		if d == depth {
+			s.values[i], s.found[i] = strings.TrimSpace(s.texts[i].String()), true
+			delete(s.capturing, i)
+		}
+	}
+	s.stack = s.stack[:depth-1]
+	if depth != s.recordDepth {
+		return nil
+	}
+	s.recordDepth = 0
+	s.records++
+	return s.emit(s.values)
+}
@@ -0,0 +1,89 @@
+package obufuku
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// extractRows は、cfg で input から取り出した行を、列を | でつないだ文字列で返します。
+func extractRows(t *testing.T, cfg ExtractConfig, input string) []string {
+	t.Helper()
+	e, err := NewExtractor(cfg)
+	if err != nil {
+		t.Fatalf("NewExtractor: %v", err)
+	}
+	var rows []string
+	n, err := e.Extract(context.Background(), strings.NewReader(input), func(record []string) error {
+		rows = append(rows, strings.Join(record, "|"))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if n != len(rows) {
+		t.Errorf("Extract returned %d, want %d", n, len(rows))
+	}
+	return rows
+}
+
+func TestExtract(t *testing.T) {
+	const input = `<root xmlns:p="urn:p">
+  <items>
+    <item id="1"><info lang="ja"><title> 題名 </title></info><price>100</price></item>
+    <item id="2"><p:title>second</p:title><price>200</price><price>300</price></item>
+    <item id="3"><item id="nested"/></item>
+  </items>
+  <other><item id="4"/></other>
+</root>`
+	tests := []struct {
+		name    string
+		record  string
+		columns []ExtractColumn
+		want    []string
+	}{
+		{"relative record", "item", []ExtractColumn{{Path: "@id"}}, []string{"1", "2", "3", "4"}},
+		{"absolute record", "/root/items/item", []ExtractColumn{{Path: "@id"}}, []string{"1", "2", "3"}},
+		{"record with parent", "other/item", []ExtractColumn{{Path: "@id"}}, []string{"4"}},
+		{"descendant text and attribute", "/root/items/item", []ExtractColumn{{Path: "info/title"}, {Path: "info/@lang"}}, []string{"題名|ja", "|", "|"}},
+		{"first match and prefixes", "/root/items/item", []ExtractColumn{{Path: "title"}, {Path: "price"}}, []string{"|100", "second|200", "|"}},
+		{"record text", "price", []ExtractColumn{{Path: "."}}, []string{"100", "200", "300"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractRows(t, ExtractConfig{Record: tt.record, Columns: tt.columns}, input)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("rows = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractHeader(t *testing.T) {
+	e, err := NewExtractor(ExtractConfig{Record: "item", Columns: []ExtractColumn{{Name: "ID", Path: "@id"}, {Path: "title"}}})
+	if err != nil {
+		t.Fatalf("NewExtractor: %v", err)
+	}
+	if got := strings.Join(e.Header(), ","); got != "ID,title" {
+		t.Errorf("Header = %q, want %q", got, "ID,title")
+	}
+}
+
+func TestExtractConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  ExtractConfig
+	}{
+		{"no record", ExtractConfig{Columns: []ExtractColumn{{Path: "@id"}}}},
+		{"no columns", ExtractConfig{Record: "item"}},
+		{"invalid record", ExtractConfig{Record: "item[1]", Columns: []ExtractColumn{{Path: "@id"}}}},
+		{"invalid column", ExtractConfig{Record: "item", Columns: []ExtractColumn{{Path: "a//b"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewExtractor(tt.cfg); err == nil {
+				t.Error("NewExtractor succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package obufuku
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Element は、処理中の要素です。
+type Element struct {
+	// Input は、入力での要素の名前です。
+	Input xml.Name
+	// Start は、出力する開始タグです。BeforeStart で書き換えると、その内容で出力されます。
+	Start xml.StartElement
+
+	// rawStart は、最小変更モードで開始タグを入力のまま出力したかどうかです。
+	rawStart bool
+	// modified は、開始タグの名前と属性は変わらなくても、最小変更モードで入力のまま出力できない
+	// (接頭辞の書き換えなど) とフィルターが判断したかどうかです。
+	modified bool
+	// defaultNamespace は、default_namespace_rules のルールを適用している文書での、要素の既定の名前空間です
+	// (ルールを適用していなければ nil)。
+	defaultNamespace *defaultNamespaceScope
+}
+
+// Text は、処理中のテキストです。
+type Text struct {
+	// Data は、出力するテキストです。
+	Data string
+	// Raw は、raw_tags の要素の中身 (CDATAとして出力されるテキスト) であるかどうかです。
+	Raw bool
+	// Modified は、フィルターがテキストを置き換えたかどうかです。
+	// 最小変更モードでは、置き換えていないテキストは入力のまま出力されます。
+	Modified bool
+	// More は、raw_tags の要素の長いテキストが分割して渡される場合に、続きの部分があるかどうかです。
+	// 分割された部分は順に渡され、最後の部分では false です。
+	More bool
+}
+
+// TokenWriter は、フィルターが出力にトークンを追加するための書き込み先です。
+type TokenWriter interface {
+	// WriteToken は、トークンを1つ書き出します。
+	WriteToken(t xml.Token) error
+	// WriteFragment は、XMLの断片 (挿入ルールのテンプレートなど) を解析して書き出します。
+	WriteFragment(fragment string) error
+	// WriteEnd は、フィルターが書き出した要素の終了タグを書き出します。
+	// 子が無い場合は、出力設定に従って自己終了タグにします。
+	WriteEnd(name xml.Name) error
+}
+
+// TokenFilter は、要素とテキストの処理に加わるフィルターです。
+// Processor は、組み込みのルールもフィルターとして順に呼び出します。
+// BeforeStart・AfterStart・CharData はフィルターの順に、BeforeEnd・AfterEnd は逆順に呼び出されるため、
+// あるフィルターが AfterStart で書き出した要素は、後のフィルターが書き出した要素を囲みます。
+// 必要なメソッドだけを実装する場合は、BaseFilter を埋め込みます。
+type TokenFilter interface {
+	// BeforeStart は、開始タグを出力する前に呼ばれます。
+	BeforeStart(w TokenWriter, el *Element) error
+	// AfterStart は、開始タグを出力した後 (子の先頭) に呼ばれます。
+	AfterStart(w TokenWriter, el *Element) error
+	// BeforeEnd は、終了タグを出力する前 (子の末尾) に呼ばれます。
+	BeforeEnd(w TokenWriter, el *Element) error
+	// AfterEnd は、終了タグを出力した後に呼ばれます。
+	AfterEnd(w TokenWriter, el *Element) error
+	// CharData は、要素 el の中の空白以外を含むテキストを出力する前に呼ばれます。
+	CharData(w TokenWriter, el *Element, text *Text) error
+}
+
+// BaseFilter は、何もしない TokenFilter です。埋め込んで一部のメソッドだけを実装するために使います。
+type BaseFilter struct{}
+
+func (BaseFilter) BeforeStart(w TokenWriter, el *Element) error          { return nil }
+func (BaseFilter) AfterStart(w TokenWriter, el *Element) error           { return nil }
+func (BaseFilter) BeforeEnd(w TokenWriter, el *Element) error            { return nil }
+func (BaseFilter) AfterEnd(w TokenWriter, el *Element) error             { return nil }
+func (BaseFilter) CharData(w TokenWriter, el *Element, text *Text) error { return nil }
+
+// filterWriter は、フィルターに渡す TokenWriter です。
+// buffered の場合は、書き出しを pending に溜めて後で flush します。
+type filterWriter struct {
+	p        *Processor
+	buffered bool
+	pending  []func() error
+}
+
+// write は、書き出し処理を実行するか、溜めておきます。
+func (w *filterWriter) write(f func() error) error {
+	if w.buffered {
+		w.pending = append(w.pending, f)
+		return nil
+	}
+	return f()
+}
+
+// flush は、溜めておいた書き出し処理を実行します。
+func (w *filterWriter) flush() error {
+	for _, f := range w.pending {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	w.pending = nil
+	return nil
+}
+
+// WriteToken は TokenWriter インターフェースを実装します。
+func (w *filterWriter) WriteToken(t xml.Token) error {
+	t = xml.CopyToken(t)
+	return w.write(func() error {
+		return w.p.encoder.EncodeToken(t)
+	})
+}
+
+// WriteFragment は TokenWriter インターフェースを実装します。
+func (w *filterWriter) WriteFragment(fragment string) error {
+	fragmentDecoder := xml.NewDecoder(strings.NewReader(fragment))
+	for {
+		token, err := fragmentDecoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.WriteToken(token); err != nil {
+			return err
+		}
+	}
+}
+
+// writeTokens は、解析済みのトークン列を書き出します。tokens は書き換えずに共有されます。
+func (w *filterWriter) writeTokens(tokens []xml.Token) error {
+	return w.write(func() error {
+		for _, t := range tokens {
+			if err := w.p.encoder.EncodeToken(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteEnd は TokenWriter インターフェースを実装します。
+func (w *filterWriter) WriteEnd(name xml.Name) error {
+	return w.write(func() error {
+		return w.p.encoder.EncodeEnd(xml.EndElement{Name: name}, w.p.isSelfClosing(name.Local, false))
+	})
+}
+
+// 組み込みのフィルターの名前です。ルールファイルの "filters" で順序を指定できます。
+const (
+	filterReorder           = "reorder"
+	filterCase              = "case"
+	filterPrefix            = "prefix"
+	filterNamespace         = "namespace"
+	filterDefaultNamespace  = "default_namespace"
+	filterInsert            = "insert"
+	filterRename            = "rename"
+	filterRoot              = "root"
+	filterSchemaLocation    = "schema_location"
+	filterNil               = "nil"
+	filterDate              = "date"
+	filterUnicode           = "unicode"
+	filterUnquoteAttributes = "unquote_attributes"
+	filterDeleteAttributes  = "delete_attributes"
+	filterWrap              = "wrap"
+	filterPrependChild      = "prepend_child"
+	filterCapture           = "capture"
+	filterValue             = "value"
+	filterCdata             = "cdata"
+	filterSummary           = "summary"
+	filterDelete            = "delete"
+	filterDedupe            = "dedupe"
+	filterAssert            = "assert"
+)
+
+// defaultFilterOrder は、組み込みのフィルターの既定の順序です。
+// reorder は、子要素の前後への挿入ルールの断片も子要素と一緒に並べ替えるよう、先頭に置きます。
+// case は、他のルールが統一した後のタグ名と照合するよう、その次に置きます。
+var defaultFilterOrder = []string{
+	filterReorder,
+	filterCase,
+	filterPrefix,
+	filterNamespace,
+	filterDefaultNamespace,
+	filterInsert,
+	filterRename,
+	filterRoot,
+	filterSchemaLocation,
+	filterUnquoteAttributes,
+	filterDeleteAttributes,
+	filterWrap,
+	filterPrependChild,
+	filterCapture,
+	filterValue,
+	filterDate,
+	filterUnicode,
+	filterCdata,
+	filterSummary,
+	filterDelete,
+	filterDedupe,
+	filterAssert,
+	filterNil,
+}
+
+// FilterNames は、組み込みのフィルターの名前を既定の順序で返します。
+func FilterNames() []string {
+	return append([]string(nil), defaultFilterOrder...)
+}
+
+// resolveFilterOrder は、指定された順序 order に、指定されなかったフィルターを既定の順序で後ろに加えます。
+// 未知の名前や重複した名前は無視し、最初に見つかったものをエラーとして返します。
+func resolveFilterOrder(order []string) ([]string, error) {
+	var err error
+	seen := make(map[string]bool)
+	resolved := make([]string, 0, len(defaultFilterOrder))
+	for _, name := range order {
+		if seen[name] {
+			if err == nil {
+				err = fmt.Errorf("filter '%s' is listed twice", name)
+			}
+			continue
+		}
+		if !isBuiltinFilter(name) {
+			if err == nil {
+				err = fmt.Errorf("unknown filter: '%s' (available: %s)", name, strings.Join(defaultFilterOrder, ", "))
+			}
+			continue
+		}
+		seen[name] = true
+		resolved = append(resolved, name)
+	}
+	for _, name := range defaultFilterOrder {
+		if !seen[name] {
+			resolved = append(resolved, name)
+		}
+	}
+	return resolved, err
+}
+
+// isBuiltinFilter は、name が組み込みのフィルターの名前かを判定します。
+func isBuiltinFilter(name string) bool {
+	for _, builtin := range defaultFilterOrder {
+		if name == builtin {
+			return true
+		}
+	}
+	return false
+}
+
+// newBuiltinFilter は、名前に対応する組み込みのフィルターを作成します。
+func (p *Processor) newBuiltinFilter(name string) TokenFilter {
+	switch name {
+	case filterReorder:
+		return reorderFilter{p: p}
+	case filterCase:
+		return caseFilter{p: p}
+	case filterPrefix:
+		return prefixFilter{p: p}
+	case filterNamespace:
+		return namespaceFilter{p: p}
+	case filterDefaultNamespace:
+		return defaultNamespaceFilter{p: p}
+	case filterInsert:
+		return insertFilter{p: p}
+	case filterRoot:
+		return rootFilter{p: p}
+	case filterSchemaLocation:
+		return schemaLocationFilter{p: p}
+	case filterDate:
+		return dateFilter{p: p}
+	case filterUnicode:
+		return unicodeFilter{p: p}
+	case filterNil:
+		return nilFilter{p: p}
+	case filterRename:
+		return renameFilter{p: p}
+	case filterUnquoteAttributes:
+		return unquoteAttributesFilter{p: p}
+	case filterDeleteAttributes:
+		return deleteAttributesFilter{p: p}
+	case filterWrap:
+		return wrapFilter{p: p}
+	case filterPrependChild:
+		return prependChildFilter{p: p}
+	case filterCapture:
+		return captureFilter{p: p}
+	case filterValue:
+		return valueFilter{p: p}
+	case filterCdata:
+		return cdataFilter{p: p}
+	case filterSummary:
+		return summaryFilter{p: p}
+	case filterDelete:
+		return deleteFilter{p: p}
+	case filterDedupe:
+		return dedupeFilter{p: p}
+	case filterAssert:
+		return assertFilter{p: p}
+	}
+	return nil
+}
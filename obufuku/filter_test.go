@@ -0,0 +1,88 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFilterOrder(t *testing.T) {
+	cfg := Config{
+		NameRules:         []ConfigNameRule{{Old: "b", New: "c"}},
+		InsertRules:       []ConfigInsertRule{{Target: "c", Template: `<n/>`}},
+		WrapRules:         []ConfigWrapRule{{Target: "c", Wrapper: "w"}},
+		PrependChildRules: []ConfigInsertRule{{Target: "c", Template: `<p/>`}},
+		Output:            compactOutput,
+	}
+	tests := []struct {
+		name    string
+		filters []string
+		want    string
+	}{
+		{"default order", nil, "<a><c><w><p></p>x</w></c></a>"},
+		{"rename before insert", []string{"rename", "insert"}, "<a><n></n><c><w><p></p>x</w></c></a>"},
+		{"prepend child before wrap", []string{"prepend_child", "wrap"}, "<a><c><p></p><w>x</w></c></a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.Filters = tt.filters
+			got, _ := transformString(t, cfg, `<a><b>x</b></a>`)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterOrderErrors(t *testing.T) {
+	for _, filters := range [][]string{{"no_such_filter"}, {"rename", "rename"}} {
+		_, err := NewRuleSet(Config{Filters: filters})
+		var configErr *RuleConfigError
+		if !errors.As(err, &configErr) || configErr.Rule != "filters" {
+			t.Errorf("NewRuleSet with filters %q: error = %v, want a RuleConfigError for filters", filters, err)
+		}
+	}
+}
+
+// idFilter は、要素に連番の属性 id を付け、子の末尾に要素 <end/> を書き出すテスト用のフィルターです。
+type idFilter struct {
+	BaseFilter
+	n int
+}
+
+func (f *idFilter) BeforeStart(w TokenWriter, el *Element) error {
+	f.n++
+	el.Start.Attr = append(el.Start.Attr, xml.Attr{Name: xml.Name{Local: "id"}, Value: strconv.Itoa(f.n)})
+	return nil
+}
+
+func (f *idFilter) BeforeEnd(w TokenWriter, el *Element) error {
+	if el.Start.Name.Local != "b" {
+		return nil
+	}
+	return w.WriteFragment("<end/>")
+}
+
+func (f *idFilter) CharData(w TokenWriter, el *Element, text *Text) error {
+	text.Data = strings.ToUpper(text.Data)
+	text.Modified = true
+	return nil
+}
+
+func TestCustomFilter(t *testing.T) {
+	rs, err := NewRuleSet(Config{ValueRules: []ConfigValueRule{{Target: "b", Type: "append", Params: params{"suffix": "!"}}}, Output: compactOutput})
+	if err != nil {
+		t.Fatalf("NewRuleSet: %v", err)
+	}
+	var out bytes.Buffer
+	if _, err := rs.NewProcessor(strings.NewReader(`<a><b>x</b></a>`), &out, WithFilters(&idFilter{})).Run(context.Background()); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if want := "<a id=\"1\"><b id=\"2\">X!<end></end></b></a>"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,1030 @@
+package obufuku
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// insertFilter は、要素の前 (insert_rules) と後 (insert_after_rules) に断片を挿入します。
+// 前方挿入はこのフィルターの時点の開始タグ名と、後方挿入は入力のタグ名と照合します。
+type insertFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f insertFilter) BeforeStart(w TokenWriter, el *Element) error {
+	for i, rule := range f.p.insertRules {
+		if el.Start.Name.Local == rule.TargetTag && rule.attributesMatch(el.Start.Attr) {
+			fragment, err := rule.fragment(f.p.variables)
+			if err != nil {
+				return err
+			}
+			f.p.ruleApplied(hitInsertRules, i, el.Start.Name.Local, "", fragment)
+			if err := rule.write(w, fragment); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f insertFilter) AfterEnd(w TokenWriter, el *Element) error {
+	for i, rule := range f.p.insertAfterRules {
+		if el.Input.Local == rule.TargetTag && rule.attributesMatch(el.Start.Attr) {
+			fragment, err := rule.fragment(f.p.variables)
+			if err != nil {
+				return err
+			}
+			f.p.ruleApplied(hitInsertAfterRules, i, el.Start.Name.Local, "", fragment)
+			if err := rule.write(w, fragment); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// attributesMatch は、開始タグの属性 attrs が IfAttributes の属性をすべて同じ値で持つかを判定します。
+// 名前空間宣言は属性として扱いません。
+func (rule InsertBeforeRule) attributesMatch(attrs []xml.Attr) bool {
+	for name, value := range rule.IfAttributes {
+		found := false
+		for _, attr := range attrs {
+			if !isNamespaceDecl(attr) && attr.Name.Local == name && attr.Value == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// describe は、ルールが適用されなかったときの警告に使う、ルールの対象の説明を返します。
+func (rule InsertBeforeRule) describe() string {
+	target := fmt.Sprintf("target '%s'", rule.TargetTag)
+	if len(rule.IfAttributes) == 0 {
+		return target
+	}
+	names := make([]string, 0, len(rule.IfAttributes))
+	for name := range rule.IfAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	conditions := make([]string, len(names))
+	for i, name := range names {
+		conditions[i] = fmt.Sprintf("%s='%s'", name, rule.IfAttributes[name])
+	}
+	return target + " with " + strings.Join(conditions, ", ")
+}
+
+// fragment は、カウンターがあればその次の値を、プレースホルダーがあれば生成した値を
+// テンプレートに埋め込んだ、挿入する断片を返します。CSVファイルの行は、ここで次の行に進めます。
+// vars は、{{var}} で参照する、capture_rules で取り込んだ変数です。
+func (rule InsertBeforeRule) fragment(vars map[string]string) (string, error) {
+	if rule.rows != nil {
+		if err := rule.rows.advance(); err != nil {
+			return "", err
+		}
+	}
+	fragment := rule.XMLTemplate
+	if rule.Counter != nil {
+		fragment = fmt.Sprintf(fragment, rule.Counter.Next())
+	}
+	if rule.placeholders != nil {
+		fragment = rule.placeholders.expand(fragment, vars)
+	}
+	return fragment, nil
+}
+
+// write は、挿入する断片 fragment を書き出します。
+// 組み立て時に解析したトークン列があれば、fragment を解析し直さずにそれを書き出します。
+func (rule InsertBeforeRule) write(w TokenWriter, fragment string) error {
+	if fw, ok := w.(*filterWriter); ok && rule.tokens != nil {
+		return fw.writeTokens(rule.tokens)
+	}
+	return w.WriteFragment(fragment)
+}
+
+// renameFilter は、タグ名を置換します (name_rules)。最初に一致したルールだけを適用します。
+type renameFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f renameFilter) BeforeStart(w TokenWriter, el *Element) error {
+	for i, rule := range f.p.nameRules {
+		if el.Start.Name.Local == rule.OldName {
+			f.p.ruleApplied(hitNameRules, i, rule.NewName, rule.OldName, rule.NewName)
+			el.Start.Name.Local = rule.NewName
+			break
+		}
+	}
+	return nil
+}
+
+// rootFilter は、ルート要素の名前と属性を置き換えます (root_rules)。最初に一致したルールだけを適用します。
+type rootFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f rootFilter) BeforeStart(w TokenWriter, el *Element) error {
+	if len(f.p.elementStack) > 0 {
+		return nil
+	}
+	for i, rule := range f.p.rootRules {
+		if rule.TargetTag != "" && el.Input.Local != rule.TargetTag {
+			continue
+		}
+		f.p.targetSeen(hitRootRules, i)
+		before := f.startTag(el)
+		if rule.NewName != "" {
+			el.Start.Name.Local = rule.NewName
+		}
+		if rule.Replace {
+			attrs := el.Start.Attr[:0:0]
+			for _, attr := range el.Start.Attr {
+				if isNamespaceDecl(attr) {
+					attrs = append(attrs, attr)
+				}
+			}
+			el.Start.Attr = attrs
+		}
+		for _, a := range rule.attributes {
+			f.setAttr(el, a.name, a.value(f.p.variables))
+		}
+		// 名前と属性をいくつ変えても、ルート要素の1回の置き換えとして記録する
+		if after := f.startTag(el); after != before {
+			f.p.ruleApplied(hitRootRules, i, el.Start.Name.Local, before, after)
+		}
+		break
+	}
+	return nil
+}
+
+// startTag は、ルート要素 el の名前と属性を、開始タグの形 (doc k="1") で返します。
+func (f rootFilter) startTag(el *Element) string {
+	var b strings.Builder
+	b.WriteString(el.Start.Name.Local)
+	for _, attr := range el.Start.Attr {
+		fmt.Fprintf(&b, ` %s="%s"`, f.attrName(attr, el.Start.Attr), attr.Value)
+	}
+	return b.String()
+}
+
+// setAttr は、ルート要素 el の属性 name (接頭辞付きの名前や名前空間の宣言も可) の値を value にします。
+// 属性が無ければ末尾に加えます。
+func (f rootFilter) setAttr(el *Element, name, value string) {
+	for j, attr := range el.Start.Attr {
+		if f.attrName(attr, el.Start.Attr) != name {
+			continue
+		}
+		el.Start.Attr[j].Value = value
+		return
+	}
+	var attrName xml.Name
+	if prefix, local, found := strings.Cut(name, ":"); !found {
+		attrName = xml.Name{Local: name}
+	} else if prefix == "xmlns" {
+		attrName = xml.Name{Space: "xmlns", Local: local}
+	} else {
+		// 宣言されている接頭辞は名前空間URIにし、未宣言の接頭辞はそのまま出力する
+		attrName = xml.Name{Space: prefix, Local: local}
+		if prefix == "xml" {
+			attrName.Space = xmlNamespaceURI
+		}
+		for _, b := range namespaceBindings(el.Start.Attr) {
+			if b.prefix == prefix {
+				attrName.Space = b.uri
+			}
+		}
+	}
+	el.Start.Attr = append(el.Start.Attr, xml.Attr{Name: attrName, Value: value})
+}
+
+// attrName は、ルート要素の属性 attr の、ルールの設定で指定する形の名前を返します。attrs はルート要素の属性です。
+func (f rootFilter) attrName(attr xml.Attr, attrs []xml.Attr) string {
+	if attr.Name.Space == "xmlns" {
+		return "xmlns:" + attr.Name.Local
+	}
+	return f.p.qualifiedAttrName(attr.Name, attrs)
+}
+
+// unquoteAttributesFilter は、属性値全体を囲む余分なダブルクォートを削除します (attr_cleanup_rules)。
+type unquoteAttributesFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f unquoteAttributesFilter) BeforeStart(w TokenWriter, el *Element) error {
+	for i, rule := range f.p.attrCleanupRules {
+		if rule.TargetTag != "" && el.Input.Local != rule.TargetTag {
+			continue
+		}
+		f.p.targetSeen(hitAttrCleanupRules, i)
+		for j, attr := range el.Start.Attr {
+			if isNamespaceDecl(attr) || !rule.appliesTo(attr.Name.Local) {
+				continue
+			}
+			if len(attr.Value) >= 2 && attr.Value[0] == '"' && attr.Value[len(attr.Value)-1] == '"' {
+				el.Start.Attr[j].Value = attr.Value[1 : len(attr.Value)-1]
+				f.p.ruleApplied(hitAttrCleanupRules, i, el.Start.Name.Local, attr.Value, el.Start.Attr[j].Value)
+			}
+		}
+	}
+	return nil
+}
+
+// appliesTo は、名前 (接頭辞を除く) が name の属性がルールの対象かを判定します。
+func (r AttrCleanupRule) appliesTo(name string) bool {
+	if len(r.Attributes) == 0 {
+		return true
+	}
+	for _, attr := range r.Attributes {
+		if attr == name {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteAttributesFilter は、名前がパターンに一致する属性を削除します (attr_delete_rules)。
+type deleteAttributesFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f deleteAttributesFilter) BeforeStart(w TokenWriter, el *Element) error {
+	for i, rule := range f.p.attrDeleteRules {
+		if rule.TargetTag != "" && el.Input.Local != rule.TargetTag {
+			continue
+		}
+		// デコーダが返したスライスを書き換えないよう、残す属性は新しいスライスに集める
+		kept := make([]xml.Attr, 0, len(el.Start.Attr))
+		for _, attr := range el.Start.Attr {
+			if isNamespaceDecl(attr) || !f.matches(rule, attr.Name, el.Start.Attr) {
+				kept = append(kept, attr)
+				continue
+			}
+			f.p.ruleApplied(hitAttrDeleteRules, i, el.Start.Name.Local, fmt.Sprintf("%s=\"%s\"", f.p.qualifiedAttrName(attr.Name, el.Start.Attr), attr.Value), "")
+		}
+		el.Start.Attr = kept
+	}
+	return nil
+}
+
+// matches は、開始タグの属性 attrs のうち名前が name の属性が、ルールのいずれかのパターンに一致するかを判定します。
+// ':' を含むパターンは接頭辞を含む名前と、含まないパターンは接頭辞を除く名前と照合します。
+func (f deleteAttributesFilter) matches(rule AttrDeleteRule, name xml.Name, attrs []xml.Attr) bool {
+	for _, pattern := range rule.Patterns {
+		target := name.Local
+		if strings.Contains(pattern, ":") {
+			target = f.p.qualifiedAttrName(name, attrs)
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapFilter は、要素の子全体を別の要素で囲みます (wrap_rules)。
+type wrapFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f wrapFilter) AfterStart(w TokenWriter, el *Element) error {
+	wrap, found := f.p.wrapRuleMap[el.Start.Name.Local]
+	if !found {
+		return nil
+	}
+	f.p.ruleApplied(hitWrapRules, wrap.index, "", "", wrap.wrapper)
+	return w.WriteToken(xml.StartElement{Name: xml.Name{Local: wrap.wrapper}})
+}
+
+func (f wrapFilter) BeforeEnd(w TokenWriter, el *Element) error {
+	wrap, found := f.p.wrapRuleMap[el.Start.Name.Local]
+	if !found {
+		return nil
+	}
+	return w.WriteEnd(xml.Name{Local: wrap.wrapper})
+}
+
+// prependChildFilter は、要素の子の先頭に断片を挿入します (prepend_child_rules)。
+type prependChildFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f prependChildFilter) AfterStart(w TokenWriter, el *Element) error {
+	for i, rule := range f.p.prependChildRules {
+		if el.Start.Name.Local == rule.TargetTag && rule.attributesMatch(el.Start.Attr) {
+			fragment, err := rule.fragment(f.p.variables)
+			if err != nil {
+				return err
+			}
+			if rule.IfMissing != "" {
+				f.p.targetSeen(hitPrependChildRules, i)
+				if err := f.hold(w, el, i, fragment); err != nil {
+					return err
+				}
+				continue
+			}
+			f.p.ruleApplied(hitPrependChildRules, i, "", "", fragment)
+			if err := rule.write(w, fragment); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// heldInsert は、子が無いときだけ挿入する断片のうち、挿入するかどうかが未確定のものです。
+// 断片は出力の保留を始めてから書き出し、保留している出力での位置を記録しておきます。
+type heldInsert struct {
+	el         *Element
+	rule       int
+	fragment   string
+	start, end int
+}
+
+// hold は、要素 el の子の先頭に断片 fragment を書き出し、if_missing の子が見つかった場合に取り除けるよう記録します。
+func (f prependChildFilter) hold(w TokenWriter, el *Element, i int, fragment string) error {
+	rule := f.p.prependChildRules[i]
+	return w.(*filterWriter).write(func() error {
+		start, err := f.p.encoder.holdOutput()
+		if err != nil {
+			return err
+		}
+		if err := rule.write(&filterWriter{p: f.p}, fragment); err != nil {
+			return err
+		}
+		end, err := f.p.encoder.heldOffset()
+		if err != nil {
+			return err
+		}
+		f.p.heldInserts = append(f.p.heldInserts, &heldInsert{el: el, rule: i, fragment: fragment, start: start, end: end})
+		return nil
+	})
+}
+
+func (f prependChildFilter) BeforeStart(w TokenWriter, el *Element) error {
+	if len(f.p.heldInserts) == 0 || len(f.p.elementStack) == 0 {
+		return nil
+	}
+	// 親の if_missing の子であれば、親に挿入した断片を取り除く
+	parent := f.p.elementStack[len(f.p.elementStack)-1]
+	return f.resolve(func(h *heldInsert) (bool, bool) {
+		return h.el == parent && el.Input.Local == f.p.prependChildRules[h.rule].IfMissing, false
+	})
+}
+
+func (f prependChildFilter) BeforeEnd(w TokenWriter, el *Element) error {
+	if len(f.p.heldInserts) == 0 {
+		return nil
+	}
+	// 要素の終わりまで if_missing の子が無ければ、挿入した断片をそのまま出力する
+	return f.resolve(func(h *heldInsert) (bool, bool) {
+		return h.el == el, true
+	})
+}
+
+// resolve は、match が確定したとする未確定の断片を、keep に従って残すか取り除きます。
+func (f prependChildFilter) resolve(match func(h *heldInsert) (resolved, keep bool)) error {
+	var held []*heldInsert
+	for _, h := range f.p.heldInserts {
+		resolved, keep := match(h)
+		if !resolved {
+			held = append(held, h)
+			continue
+		}
+		if keep {
+			f.p.ruleApplied(hitPrependChildRules, h.rule, h.el.Start.Name.Local, "", h.fragment)
+		} else if err := f.p.dropHeld(h.start, h.end); err != nil {
+			return err
+		}
+		if err := f.p.encoder.releaseHeld(); err != nil {
+			return err
+		}
+	}
+	f.p.heldInserts = held
+	return nil
+}
+
+// captureFilter は、要素のテキストを変数に取り込みます (capture_rules)。
+// 名前置換の影響を受けないよう、入力のタグ名と照合します。raw_tags の要素の中身は対象にしません。
+type captureFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f captureFilter) CharData(w TokenWriter, el *Element, text *Text) error {
+	if text.Raw {
+		return nil
+	}
+	for i, rule := range f.p.captureRules {
+		if el.Input.Local != rule.TargetTag {
+			continue
+		}
+		if f.p.variables == nil {
+			f.p.variables = make(map[string]string)
+		}
+		f.p.ruleApplied(hitCaptureRules, i, "", f.p.variables[rule.Variable], text.Data)
+		f.p.variables[rule.Variable] = text.Data
+	}
+	return nil
+}
+
+// valueFilter は、要素のテキストを置換します (value_rules)。最初に一致したルールだけを適用します。
+// raw_tags の要素の中身は対象にしません。
+type valueFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f valueFilter) CharData(w TokenWriter, el *Element, text *Text) error {
+	if text.Raw {
+		return nil
+	}
+	for i, rule := range f.p.valueRules {
+		if el.Start.Name.Local != rule.TargetTag {
+			continue
+		}
+		oldValue := text.Data
+		var newValue string
+		if rule.ContextFunc == nil {
+			newValue = rule.ReplacementFunc(oldValue)
+		} else {
+			var err error
+			newValue, err = rule.ContextFunc(oldValue, ValueContext{Element: el.Start, Variables: f.p.variables})
+			if err != nil {
+				return &EncodeError{Rule: fmt.Sprintf("value_rules[%d]", i), Err: err}
+			}
+		}
+		if err := validateText("replacement value", newValue); err != nil {
+			return &EncodeError{Rule: fmt.Sprintf("value_rules[%d]", i), Err: err}
+		}
+		f.p.ruleApplied(hitValueRules, i, "", oldValue, newValue)
+		text.Data = newValue
+		text.Modified = true
+		return nil
+	}
+	return nil
+}
+
+// cdataFilter は、raw_tags の要素の中身の文字列を置換します (cdata_rules)。
+// 分割されたテキストでは、部分の境界にまたがる文字列も置換します。
+type cdataFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f cdataFilter) CharData(w TokenWriter, el *Element, text *Text) error {
+	if !text.Raw {
+		return nil
+	}
+	if st := f.p.split; st != nil {
+		if st.cdataCarry == nil {
+			st.cdataCarry = make([]string, len(f.p.cdataRules))
+		}
+		for i, rule := range f.p.cdataRules {
+			if rule.Entities != "" {
+				f.p.targetSeen(hitCdataRules, i)
+				converted, carry := convertEntitiesSplit(rule.Entities, st.cdataCarry[i], text.Data, text.More)
+				if converted+carry != st.cdataCarry[i]+text.Data {
+					f.p.ruleApplied(hitCdataRules, i, "", text.Data, converted)
+					text.Modified = true
+				}
+				st.cdataCarry[i] = carry
+				text.Data = converted
+				continue
+			}
+			replaced, carry, n := replaceSplit(st.cdataCarry[i], text.Data, rule.Old, rule.New, text.More)
+			st.cdataCarry[i] = carry
+			if n > 0 {
+				f.p.ruleApplied(hitCdataRules, i, "", text.Data, replaced)
+				text.Modified = true
+			}
+			text.Data = replaced
+		}
+		return nil
+	}
+	for i, rule := range f.p.cdataRules {
+		if rule.Entities != "" {
+			f.p.targetSeen(hitCdataRules, i)
+			if converted := convertEntities(rule.Entities, text.Data); converted != text.Data {
+				f.p.ruleApplied(hitCdataRules, i, "", text.Data, converted)
+				text.Data = converted
+				text.Modified = true
+			}
+			continue
+		}
+		if strings.Contains(text.Data, rule.Old) {
+			replaced := strings.ReplaceAll(text.Data, rule.Old, rule.New)
+			f.p.ruleApplied(hitCdataRules, i, "", text.Data, replaced)
+			text.Data = replaced
+			text.Modified = true
+		}
+	}
+	return nil
+}
+
+// summaryFilter は、要素を集計し、ルート要素の終了タグの直前に集計値の要素を挿入します (summary_rules)。
+// 名前置換の影響を受けないよう、入力のタグ名と照合します。raw_tags の要素の中身は合計しません。
+type summaryFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+// totals は、ルールごとの集計値を返します。
+func (f summaryFilter) totals() []summaryTotal {
+	if f.p.summaryTotals == nil {
+		f.p.summaryTotals = make([]summaryTotal, len(f.p.summaryRules))
+	}
+	return f.p.summaryTotals
+}
+
+func (f summaryFilter) BeforeStart(w TokenWriter, el *Element) error {
+	for i, rule := range f.p.summaryRules {
+		if rule.Function == summaryCount && el.Input.Local == rule.TargetTag {
+			f.p.ruleApplied(hitSummaryRules, i, el.Start.Name.Local, "", "")
+			f.totals()[i].add("1")
+		}
+	}
+	return nil
+}
+
+func (f summaryFilter) CharData(w TokenWriter, el *Element, text *Text) error {
+	for i, rule := range f.p.summaryRules {
+		if rule.Function != summarySum || text.Raw || el.Input.Local != rule.TargetTag {
+			continue
+		}
+		if err := f.totals()[i].add(text.Data); err != nil {
+			return &EncodeError{Rule: fmt.Sprintf("%s[%d]", hitSummaryRules, i), Err: err}
+		}
+		f.p.ruleApplied(hitSummaryRules, i, "", text.Data, "")
+	}
+	return nil
+}
+
+func (f summaryFilter) BeforeEnd(w TokenWriter, el *Element) error {
+	// ルート要素の終了タグの前でだけ挿入する (終了タグの処理では、要素は既にスタックから外れている)
+	if len(f.p.elementStack) > 0 {
+		return nil
+	}
+	totals := f.totals()
+	for i, rule := range f.p.summaryRules {
+		name := xml.Name{Local: rule.Element}
+		if err := w.WriteToken(xml.StartElement{Name: name}); err != nil {
+			return err
+		}
+		if err := w.WriteToken(xml.CharData(totals[i].String())); err != nil {
+			return err
+		}
+		if err := w.WriteEnd(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteFilter は、要素を条件に応じて削除します (delete_rules)。
+// 対象の要素の出力を開始タグから保留し、終了タグの後でテキストを条件と照合して、削除する場合は取り除きます。
+// 名前置換の影響を受けないよう、入力のタグ名と照合します。
+type deleteFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+// heldDelete は、削除するかどうかが未確定の要素と、保留している出力での開始位置、これまでの内容です。
+type heldDelete struct {
+	el       *Element
+	start    int
+	text     strings.Builder
+	children bool
+}
+
+func (f deleteFilter) BeforeStart(w TokenWriter, el *Element) error {
+	if n := len(f.p.heldDeletes); n > 0 && len(f.p.elementStack) > 0 && f.p.heldDeletes[n-1].el == f.p.elementStack[len(f.p.elementStack)-1] {
+		f.p.heldDeletes[n-1].children = true
+	}
+	for _, rule := range f.p.deleteRules {
+		if el.Input.Local != rule.TargetTag {
+			continue
+		}
+		start, err := f.p.encoder.holdOutput()
+		if err != nil {
+			return err
+		}
+		f.p.heldDeletes = append(f.p.heldDeletes, &heldDelete{el: el, start: start})
+		break
+	}
+	return nil
+}
+
+func (f deleteFilter) CharData(w TokenWriter, el *Element, text *Text) error {
+	if n := len(f.p.heldDeletes); n > 0 && f.p.heldDeletes[n-1].el == el {
+		f.p.heldDeletes[n-1].text.WriteString(text.Data)
+	}
+	return nil
+}
+
+func (f deleteFilter) AfterEnd(w TokenWriter, el *Element) error {
+	n := len(f.p.heldDeletes)
+	if n == 0 || f.p.heldDeletes[n-1].el != el {
+		return nil
+	}
+	h := f.p.heldDeletes[n-1]
+	f.p.heldDeletes = f.p.heldDeletes[:n-1]
+	text := h.text.String()
+	for i, rule := range f.p.deleteRules {
+		if el.Input.Local != rule.TargetTag {
+			continue
+		}
+		f.p.targetSeen(hitDeleteRules, i)
+		if !rule.matches(text, h.children) {
+			continue
+		}
+		end, err := f.p.encoder.heldOffset()
+		if err != nil {
+			return err
+		}
+		if err := f.p.dropHeld(h.start, end); err != nil {
+			return err
+		}
+		f.p.ruleApplied(hitDeleteRules, i, el.Start.Name.Local, text, "")
+		break
+	}
+	return f.p.encoder.releaseHeld()
+}
+
+// matches は、テキストが text で、子要素の有無が children の要素を削除するかどうかを判定します。
+func (rule DeleteRule) matches(text string, children bool) bool {
+	if rule.Pattern == nil && !rule.IfEmpty {
+		return true
+	}
+	if rule.Pattern != nil && rule.Pattern.MatchString(text) {
+		return true
+	}
+	return rule.IfEmpty && !children && strings.TrimSpace(text) == ""
+}
+
+// 重複を削除するルールの範囲です。
+const (
+	dedupeConsecutive = "consecutive"
+	dedupeAll         = "all"
+)
+
+// dedupeFilter は、親要素の中で同じ内容の子要素が重複している場合に、2つ目以降を削除します (dedupe_rules)。
+// 対象の子要素の出力を開始タグから保留し、終了タグの後でそれまでの子要素の内容と比較します。
+// 名前置換の影響を受けないよう、入力のタグ名と照合します。
+type dedupeFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+// dedupeParent は、重複を削除する子要素を持つ要素と、比較に使うそれまでの子要素の内容です。
+type dedupeParent struct {
+	el   *Element
+	rule int
+	// last は直前の子要素の内容のハッシュ値 (子要素がまだ無ければ nil)、
+	// seen は All の場合のそれまでの子要素の内容のハッシュ値です。
+	last *[sha256.Size]byte
+	seen map[[sha256.Size]byte]bool
+}
+
+// heldDedupe は、重複しているかどうかが未確定の子要素と、保留している出力での開始位置です。
+type heldDedupe struct {
+	el     *Element
+	parent *dedupeParent
+	start  int
+}
+
+func (f dedupeFilter) BeforeStart(w TokenWriter, el *Element) error {
+	if n := len(f.p.dedupeParents); n > 0 && len(f.p.elementStack) > 0 {
+		parent := f.p.dedupeParents[n-1]
+		rule := f.p.dedupeRules[parent.rule]
+		if parent.el == f.p.elementStack[len(f.p.elementStack)-1] && (rule.ChildTag == "" || el.Input.Local == rule.ChildTag) {
+			start, err := f.p.encoder.holdOutput()
+			if err != nil {
+				return err
+			}
+			f.p.heldDedupes = append(f.p.heldDedupes, &heldDedupe{el: el, parent: parent, start: start})
+		}
+	}
+	for i, rule := range f.p.dedupeRules {
+		if el.Input.Local == rule.ParentTag {
+			f.p.targetSeen(hitDedupeRules, i)
+			f.p.dedupeParents = append(f.p.dedupeParents, &dedupeParent{el: el, rule: i})
+			break
+		}
+	}
+	return nil
+}
+
+func (f dedupeFilter) AfterEnd(w TokenWriter, el *Element) error {
+	if n := len(f.p.dedupeParents); n > 0 && f.p.dedupeParents[n-1].el == el {
+		f.p.dedupeParents = f.p.dedupeParents[:n-1]
+	}
+	n := len(f.p.heldDedupes)
+	if n == 0 || f.p.heldDedupes[n-1].el != el {
+		return nil
+	}
+	h := f.p.heldDedupes[n-1]
+	f.p.heldDedupes = f.p.heldDedupes[:n-1]
+
+	// 兄弟要素でインデントの深さは同じだが、先頭の要素の前の改行の有無などが異なるため、前後の空白は比較しない
+	content, err := f.p.encoder.heldBytes(h.start)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(bytes.TrimSpace(content))
+	parent := h.parent
+	all := f.p.dedupeRules[parent.rule].All
+	if all && parent.seen[sum] || !all && parent.last != nil && *parent.last == sum {
+		end, err := f.p.encoder.heldOffset()
+		if err != nil {
+			return err
+		}
+		if err := f.p.dropHeld(h.start, end); err != nil {
+			return err
+		}
+		f.p.ruleApplied(hitDedupeRules, parent.rule, el.Start.Name.Local, "", "")
+	} else if all {
+		if parent.seen == nil {
+			parent.seen = make(map[[sha256.Size]byte]bool)
+		}
+		parent.seen[sum] = true
+	}
+	parent.last = &sum
+	return f.p.encoder.releaseHeld()
+}
+
+// 並べ替えるルールで、順序に無い子要素の扱いです。
+const (
+	reorderAppend = "append"
+	reorderReject = "reject"
+)
+
+// reorderFilter は、要素の子要素を指定したタグ名の順に並べ替えます (reorder_rules)。
+// 開始タグから出力を保留し、子要素ごとにその終わりの位置を記録しておき、要素の終わりで並べ替えます。
+// 最初の子要素の前のコメントなども、その子要素と一緒に移動します。
+// 名前置換の影響を受けないよう、入力のタグ名と照合します。
+type reorderFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+// reorderParent は、子要素を並べ替える要素と、保留している出力での子の先頭の位置、それまでの子要素です。
+// pending は、子の先頭の時点で開始タグの '>' をまだ書き出していなかったかどうかです。
+type reorderParent struct {
+	el       *Element
+	rule     int
+	start    int
+	pending  bool
+	children []reorderChild
+}
+
+// reorderChild は、並べ替える子要素の順序と、保留している出力での終わりの位置 (まだ終わっていなければ -1) です。
+type reorderChild struct {
+	rank int
+	end  int
+}
+
+func (f reorderFilter) BeforeStart(w TokenWriter, el *Element) error {
+	if n := len(f.p.reorderParents); n > 0 && len(f.p.elementStack) > 0 && f.p.reorderParents[n-1].el == f.p.elementStack[len(f.p.elementStack)-1] {
+		parent := f.p.reorderParents[n-1]
+		rule := f.p.reorderRules[parent.rule]
+		rank := len(rule.Order)
+		for i, name := range rule.Order {
+			if el.Input.Local == name {
+				rank = i
+				break
+			}
+		}
+		if rank == len(rule.Order) && rule.RejectUnknown {
+			return &EncodeError{Rule: fmt.Sprintf("%s[%d]", hitReorderRules, parent.rule), Err: fmt.Errorf("child '%s' is not in the order list", el.Input.Local)}
+		}
+		parent.children = append(parent.children, reorderChild{rank: rank, end: -1})
+	}
+	for i, rule := range f.p.reorderRules {
+		if el.Input.Local == rule.TargetTag {
+			if _, err := f.p.encoder.holdOutput(); err != nil {
+				return err
+			}
+			f.p.reorderParents = append(f.p.reorderParents, &reorderParent{el: el, rule: i, start: -1})
+			break
+		}
+	}
+	return nil
+}
+
+// markReorderContent は、子要素を並べ替える要素 el の開始タグと、開始タグの後のフィルターの出力
+// (ラップの開始タグや子の先頭への挿入) を書き出した後の位置を、並べ替える部分の先頭として記録します。
+func (p *Processor) markReorderContent(el *Element) error {
+	n := len(p.reorderParents)
+	if n == 0 || p.reorderParents[n-1].el != el {
+		return nil
+	}
+	start, err := p.encoder.heldOffset()
+	p.reorderParents[n-1].start = start
+	p.reorderParents[n-1].pending = p.encoder.pending != nil
+	return err
+}
+
+func (f reorderFilter) AfterEnd(w TokenWriter, el *Element) error {
+	n := len(f.p.reorderParents)
+	if n == 0 || len(f.p.elementStack) == 0 || f.p.reorderParents[n-1].el != f.p.elementStack[len(f.p.elementStack)-1] {
+		return nil
+	}
+	children := f.p.reorderParents[n-1].children
+	end, err := f.p.encoder.heldOffset()
+	if err != nil {
+		return err
+	}
+	children[len(children)-1].end = end
+	return nil
+}
+
+func (f reorderFilter) BeforeEnd(w TokenWriter, el *Element) error {
+	n := len(f.p.reorderParents)
+	if n == 0 || f.p.reorderParents[n-1].el != el {
+		return nil
+	}
+	parent := f.p.reorderParents[n-1]
+	f.p.reorderParents = f.p.reorderParents[:n-1]
+	f.p.ruleApplied(hitReorderRules, parent.rule, el.Start.Name.Local, "", "")
+	if len(parent.children) == 0 {
+		return f.p.encoder.releaseHeld()
+	}
+
+	// 子要素ごとに、直前のコメントなどを含む部分に分ける。部分の先頭の空白 (インデント) は移動せず、その位置に残す
+	content, err := f.p.encoder.heldBytes(parent.start)
+	if err != nil {
+		return err
+	}
+	if parent.pending && bytes.HasPrefix(content, []byte(">")) {
+		// 子を書き出すときに確定させた開始タグの '>'
+		parent.start++
+		content = content[1:]
+	}
+	type part struct {
+		indent, body []byte
+		rank         int
+	}
+	parts := make([]part, len(parent.children))
+	prev := parent.start
+	for i, child := range parent.children {
+		segment := content[prev-parent.start : child.end-parent.start]
+		body := bytes.TrimLeft(segment, " \t\r\n")
+		parts[i] = part{indent: segment[:len(segment)-len(body)], body: body, rank: child.rank}
+		prev = child.end
+	}
+	sorted := append([]part(nil), parts...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].rank < sorted[j].rank })
+
+	reordered := make([]byte, 0, len(content))
+	for i := range parts {
+		// 開始タグの直後のコメントの後など、空白の無い位置には、移動してきた子要素のインデントを使う
+		indent := parts[i].indent
+		if len(indent) == 0 {
+			indent = sorted[i].indent
+		}
+		reordered = append(reordered, indent...)
+		reordered = append(reordered, sorted[i].body...)
+	}
+	reordered = append(reordered, content[prev-parent.start:]...)
+	if err := f.p.encoder.replaceHeld(parent.start, reordered); err != nil {
+		return err
+	}
+	return f.p.encoder.releaseHeld()
+}
+
+// assertFilter は、要素が指定した子要素や属性を持つことを検査します (assert_rules)。
+// 属性は開始タグで、子要素は要素の終わりで検査します。名前置換の影響を受けないよう、入力の名前と照合します。
+type assertFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+// assertTarget は、子要素を検査する要素と、それまでに現れた子要素のタグ名です。
+type assertTarget struct {
+	el       *Element
+	rules    []int
+	children map[string]bool
+}
+
+func (f assertFilter) BeforeStart(w TokenWriter, el *Element) error {
+	if n := len(f.p.assertTargets); n > 0 && len(f.p.elementStack) > 0 && f.p.assertTargets[n-1].el == f.p.elementStack[len(f.p.elementStack)-1] {
+		f.p.assertTargets[n-1].children[el.Input.Local] = true
+	}
+	var target *assertTarget
+	for i, rule := range f.p.assertRules {
+		if el.Input.Local != rule.TargetTag {
+			continue
+		}
+		f.p.hit(hitAssertRules, i)
+		for _, name := range rule.Attributes {
+			if !hasAttr(el.Start.Attr, name) {
+				if err := f.violation(i, el, fmt.Sprintf("attribute '%s'", name)); err != nil {
+					return err
+				}
+			}
+		}
+		if len(rule.Children) == 0 {
+			continue
+		}
+		if target == nil {
+			target = &assertTarget{el: el, children: make(map[string]bool)}
+			f.p.assertTargets = append(f.p.assertTargets, target)
+		}
+		target.rules = append(target.rules, i)
+	}
+	return nil
+}
+
+func (f assertFilter) BeforeEnd(w TokenWriter, el *Element) error {
+	n := len(f.p.assertTargets)
+	if n == 0 || f.p.assertTargets[n-1].el != el {
+		return nil
+	}
+	target := f.p.assertTargets[n-1]
+	f.p.assertTargets = f.p.assertTargets[:n-1]
+	for _, i := range target.rules {
+		for _, name := range f.p.assertRules[i].Children {
+			if !target.children[name] {
+				if err := f.violation(i, el, fmt.Sprintf("child '%s'", name)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// violation は、i 番目のルールについて、要素 el に what が無いことを報告します。
+// ルールの fail が true の場合は、エラーを返します。
+func (f assertFilter) violation(i int, el *Element, what string) error {
+	// 開始タグの前と終了タグの前のどちらでも、要素はスタックに無い
+	path := f.p.elementPath(el.Start.Name.Local)
+	if f.p.assertRules[i].Fail {
+		return &EncodeError{Rule: fmt.Sprintf("%s[%d]", hitAssertRules, i), Err: fmt.Errorf("%s is missing %s", path, what)}
+	}
+	f.p.warn("%s[%d]: %s is missing %s", hitAssertRules, i, path, what)
+	return nil
+}
+
+// hasAttr は、attrs に名前 (接頭辞を除く) が name の属性があるかを判定します。
+func hasAttr(attrs []xml.Attr, name string) bool {
+	for _, attr := range attrs {
+		if attr.Name.Local == name {
+			return true
+		}
+	}
+	return false
+}
+
+// caseFilter は、タグ名の大文字・小文字を統一します (case_rules)。最初に当てはまったルールだけを適用します。
+type caseFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f caseFilter) BeforeStart(w TokenWriter, el *Element) error {
+	for i, rule := range f.p.caseRules {
+		if !f.under(el, rule.Under) {
+			continue
+		}
+		f.p.targetSeen(hitCaseRules, i)
+		// 変換した結果がXMLの名前として使えない場合 ("_1st" の camel など) は、元のままにする
+		old := el.Start.Name.Local
+		if name := nameCases[rule.Case](old); name != old && isQName(name) {
+			f.p.ruleApplied(hitCaseRules, i, name, old, name)
+			el.Start.Name.Local = name
+		}
+		break
+	}
+	return nil
+}
+
+// under は、要素 el が入力のタグ名が tag の要素またはその子孫であるかを判定します (tag が空なら常に true)。
+func (f caseFilter) under(el *Element, tag string) bool {
+	if tag == "" || el.Input.Local == tag {
+		return true
+	}
+	for _, ancestor := range f.p.elementStack {
+		if ancestor.Input.Local == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,232 @@
+package obufuku
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// elementPattern は、要素のパスのパターンです。"/root/items/item" のように / で始まる場合は
+// 文書要素からのパス、"item" や "items/item" のように / で始まらない場合は末尾が一致する要素を表します。
+// 要素の名前は、名前空間接頭辞を除いたローカル名で比較します。
+type elementPattern struct {
+	absolute bool
+	names    []string
+}
+
+// parseElementPattern は、要素のパスのパターンを解析します。
+func parseElementPattern(p string) (elementPattern, error) {
+	pattern := elementPattern{absolute: strings.HasPrefix(p, "/")}
+	pattern.names = strings.Split(strings.TrimPrefix(p, "/"), "/")
+	for _, name := range pattern.names {
+		if !isNCName(name) {
+			return pattern, fmt.Errorf("invalid element path '%s'", p)
+		}
+	}
+	return pattern, nil
+}
+
+// match は、文書要素から順に並べた要素の名前 stack の末尾の要素がパターンに一致するかを返します。
+func (e elementPattern) match(stack []string) bool {
+	if e.absolute {
+		return equalNames(stack, e.names)
+	}
+	return len(stack) >= len(e.names) && equalNames(stack[len(stack)-len(e.names):], e.names)
+}
+
+// equalNames は、2つの名前の並びが等しいかを返します。
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TransformFragments は、r のXMLのうち path に一致する要素ごとに、その要素を1つの文書として
+// このルールセットで変換します。path の書式は ExtractConfig の Record と同じで、
+// 一致した要素の中にある一致する要素は、別の文書ではなく子孫として扱います。
+//
+// 要素ごとに emit を1から始まる番号とともに呼び出します。emit は出力先を用意して write を呼び出し、
+// 変換結果を書き込みます (出力エンコーディングと改行コードの変換は Transform と同じく行います)。
+// 祖先の要素で宣言された名前空間は、切り出した要素の開始タグで宣言し直します。
+// 一致した要素の外の内容は出力しません。カウンターは要素をまたいで続けて採番されます。
+//
+// 結果は全要素の合計で、警告には "fragment <番号>: " を付けます。変換した要素の数も返します。
+func (rs *RuleSet) TransformFragments(ctx context.Context, r io.Reader, path string, emit func(index int, write func(w io.Writer) error) error) (TransformResult, int, error) {
+	pattern, err := parseElementPattern(path)
+	if err != nil {
+		return TransformResult{}, 0, err
+	}
+	// 入力のバイト列をそのまま切り出すため、あらかじめUTF-8に変換しておく
+	encoding := rs.Input.Encoding
+	if encoding == "" {
+		encoding = autoEncoding
+	}
+	utf8Reader, err := NewInputReader(r, encoding)
+	if err != nil {
+		return TransformResult{}, 0, err
+	}
+	input := rs.Input
+	input.Encoding = autoEncoding
+	recorder := newSpanRecorder(utf8Reader)
+	decoder := newDecoder(recorder, input)
+
+	var (
+		total TransformResult
+		count int
+		stack []string
+		// scopes は、要素ごとに宣言された名前空間 (接頭辞 → URI、既定の名前空間は "") です。
+		scopes []map[string]string
+		start  int64
+		depth  int
+	)
+	// parseError は、読み込み中のエラーに入力の位置と開いている要素のパスを付けます。
+	parseError := func(err error) error {
+		line, column := decoder.InputPos()
+		var path string
+		if len(stack) > 0 {
+			path = "/" + strings.Join(stack, "/")
+		}
+		return &ParseError{Line: line, Column: column, Path: path, Err: err}
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, count, err
+		}
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return total, count, nil
+		}
+		if err != nil {
+			return total, count, parseError(err)
+		}
+
+		switch t := token.(type) {
+		case xml.Directive:
+			entity, err := doctypeEntities(t, input, decoder.Entity)
+			if err != nil {
+				return total, count, parseError(err)
+			}
+			decoder.Entity = entity
+			input.Decoder.Entity = entity
+
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			scopes = append(scopes, namespaceDeclarations(t.Attr))
+			if depth == 0 && pattern.match(stack) {
+				start = offset
+				depth = len(stack)
+			}
+
+		case xml.EndElement:
+			if len(stack) == depth {
+				depth = 0
+				count++
+				fragment, err := recorder.Span(start, decoder.InputOffset())
+				if err != nil {
+					return total, count, err
+				}
+				fragment = redeclareNamespaces(fragment, scopes)
+				result, err := rs.transformFragment(ctx, fragment, input, count, emit)
+				total.Add(result, fmt.Sprintf("fragment %d: ", count))
+				if err != nil {
+					return total, count, fmt.Errorf("fragment %d: %w", count, err)
+				}
+			}
+			stack = stack[:len(stack)-1]
+			scopes = scopes[:len(scopes)-1]
+		}
+		if depth == 0 {
+			recorder.Discard(decoder.InputOffset())
+		}
+	}
+}
+
+// transformFragment は、切り出した要素 fragment を変換し、emit に渡します。
+func (rs *RuleSet) transformFragment(ctx context.Context, fragment []byte, input InputOptions, index int, emit func(int, func(io.Writer) error) error) (TransformResult, error) {
+	var result TransformResult
+	err := emit(index, func(w io.Writer) error {
+		out := &countingWriter{w: w}
+		writer := rs.NewWriter(out)
+		var err error
+		result, err = rs.runPasses(ctx, writer, func(w io.Writer, opts ...Option) (TransformResult, error) {
+			opts = append([]Option{WithInputOptions(input)}, opts...)
+			return rs.NewProcessor(strings.NewReader(string(fragment)), w, opts...).Run(ctx)
+		})
+		if err == nil {
+			err = writer.Close()
+		}
+		result.BytesRead, result.BytesWritten = int64(len(fragment)), out.n
+		return err
+	})
+	return result, err
+}
+
+// namespaceDeclarations は、開始タグの属性で宣言された名前空間を返します (宣言が無ければ nil)。
+func namespaceDeclarations(attrs []xml.Attr) map[string]string {
+	var declared map[string]string
+	for _, attr := range attrs {
+		prefix, ok := "", false
+		switch {
+		case attr.Name.Space == "xmlns":
+			prefix, ok = attr.Name.Local, true
+		case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+			ok = true
+		}
+		if ok {
+			if declared == nil {
+				declared = make(map[string]string)
+			}
+			declared[prefix] = attr.Value
+		}
+	}
+	return declared
+}
+
+// redeclareNamespaces は、切り出した要素の開始タグに、祖先の要素で宣言された名前空間の宣言を加えます。
+// scopes の最後は切り出した要素自身の宣言で、そこで宣言し直されている名前空間は加えません。
+func redeclareNamespaces(fragment []byte, scopes []map[string]string) []byte {
+	inherited := make(map[string]string)
+	for _, scope := range scopes[:len(scopes)-1] {
+		for prefix, uri := range scope {
+			inherited[prefix] = uri
+		}
+	}
+	for prefix := range scopes[len(scopes)-1] {
+		delete(inherited, prefix)
+	}
+	if len(inherited) == 0 {
+		return fragment
+	}
+	prefixes := make([]string, 0, len(inherited))
+	for prefix := range inherited {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	var decls strings.Builder
+	for _, prefix := range prefixes {
+		name := "xmlns"
+		if prefix != "" {
+			name += ":" + prefix
+		}
+		fmt.Fprintf(&decls, ` %s="`, name)
+		xml.EscapeText(&decls, []byte(inherited[prefix]))
+		decls.WriteString(`"`)
+	}
+	// 開始タグの名前の直後に宣言を挿入する
+	end := 1 + strings.IndexAny(string(fragment[1:]), " \t\r\n/>")
+	out := make([]byte, 0, len(fragment)+decls.Len())
+	out = append(out, fragment[:end]...)
+	out = append(out, decls.String()...)
+	return append(out, fragment[end:]...)
+}
@@ -0,0 +1,119 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// transformFragments は、cfg で input のうち path に一致する要素を変換し、要素ごとの出力を返します。
+func transformFragments(t *testing.T, cfg Config, path, input string) ([]string, TransformResult) {
+	t.Helper()
+	rs, err := NewRuleSet(cfg)
+	if err != nil {
+		t.Fatalf("NewRuleSet: %v", err)
+	}
+	var outputs []string
+	result, n, err := rs.TransformFragments(context.Background(), strings.NewReader(input), path, func(index int, write func(io.Writer) error) error {
+		if index != len(outputs)+1 {
+			t.Errorf("index = %d, want %d", index, len(outputs)+1)
+		}
+		var out bytes.Buffer
+		if err := write(&out); err != nil {
+			return err
+		}
+		outputs = append(outputs, out.String())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("TransformFragments: %v", err)
+	}
+	if n != len(outputs) {
+		t.Errorf("TransformFragments returned %d, want %d", n, len(outputs))
+	}
+	return outputs, result
+}
+
+func TestTransformFragments(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		path  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "relative path",
+			path:  "item",
+			input: `<root><items><item>1</item><x/><item>2</item></items><item>3</item></root>`,
+			want:  []string{"<item>1</item>", "<item>2</item>", "<item>3</item>"},
+		},
+		{
+			name:  "absolute path",
+			path:  "/root/items/item",
+			input: `<root><items><item>1</item><item>2</item></items><item>3</item></root>`,
+			want:  []string{"<item>1</item>", "<item>2</item>"},
+		},
+		{
+			name:  "nested matches belong to the outer element",
+			path:  "item",
+			input: `<root><item><item>inner</item></item></root>`,
+			want:  []string{"<item><item>inner</item></item>"},
+		},
+		{
+			name:  "ancestor namespaces are redeclared",
+			path:  "item",
+			input: `<root xmlns="urn:d" xmlns:p="urn:p"><item p:a="1"><p:b/></item><item xmlns:p="urn:q"/></root>`,
+			want:  []string{`<item xmlns="urn:d" xmlns:p="urn:p" p:a="1"><p:b></p:b></item>`, `<item xmlns="urn:d" xmlns:p="urn:q"></item>`},
+		},
+		{
+			name: "rules and counters continue across fragments",
+			cfg: Config{
+				NameRules:         []ConfigNameRule{{Old: "v", New: "w"}},
+				PrependChildRules: []ConfigInsertRule{{Target: "item", Template: "<n>%d</n>", Counter: "n"}},
+				Counters:          map[string]ConfigCounter{"n": {}},
+			},
+			path:  "item",
+			input: `<root><item><v/></item><item/></root>`,
+			want:  []string{"<item><n>1</n><w></w></item>", "<item><n>2</n></item>"},
+		},
+		{
+			name:  "no match",
+			path:  "missing",
+			input: `<root><item/></root>`,
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Output = compactOutput
+			got, _ := transformFragments(t, tt.cfg, tt.path, tt.input)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("fragments = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformFragmentsWarnings(t *testing.T) {
+	cfg := Config{NameRules: []ConfigNameRule{{Old: "v", New: "w"}}, Output: compactOutput}
+	_, result := transformFragments(t, cfg, "item", `<root><item/><item><v/></item></root>`)
+	if result.RuleHits["name_rules[0]"] != 1 {
+		t.Errorf("RuleHits = %v, want 1 hit for name_rules[0]", result.RuleHits)
+	}
+}
+
+func TestTransformFragmentsErrors(t *testing.T) {
+	rs, err := NewRuleSet(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"", "a//b", "item[1]"} {
+		_, _, err := rs.TransformFragments(context.Background(), strings.NewReader("<root/>"), path, func(int, func(io.Writer) error) error { return nil })
+		if err == nil {
+			t.Errorf("TransformFragments(%q) succeeded, want an error", path)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package obufuku
+
+import "bytes"
+
+// dropHeld は、保留している出力の start から end までを取り除き、
+// 取り除いた部分より後ろを指している未確定のルール (挿入した断片や削除の対象の要素など) の位置をずらします。
+func (p *Processor) dropHeld(start, end int) error {
+	if err := p.encoder.dropHeld(start, end); err != nil {
+		return err
+	}
+	shift := func(pos *int) {
+		if *pos >= end {
+			*pos -= end - start
+		}
+	}
+	for _, h := range p.heldInserts {
+		shift(&h.start)
+		shift(&h.end)
+	}
+	for _, h := range p.heldDeletes {
+		shift(&h.start)
+	}
+	for _, h := range p.heldDedupes {
+		shift(&h.start)
+	}
+	for _, h := range p.heldNils {
+		shift(&h.start)
+	}
+	for _, h := range p.heldEmpties {
+		shift(&h.start)
+		shift(&h.content)
+	}
+	for _, r := range p.reorderParents {
+		shift(&r.start)
+		for i := range r.children {
+			shift(&r.children[i].end)
+		}
+	}
+	return nil
+}
+
+// heldEmpty は、空のときに出力しない要素 (出力設定の self_closing の remove) と、保留している出力での
+// 開始タグの位置 start と開始タグの後の位置 content です。
+// pending は、content の時点で開始タグの '>' をまだ書き出していなかったかどうかです。
+type heldEmpty struct {
+	el      *Element
+	start   int
+	content int
+	pending bool
+}
+
+// holdEmpty は、要素 el が空のときに出力しない要素であれば、開始タグを書き出す前に出力の保留を始めます。
+func (p *Processor) holdEmpty(el *Element) error {
+	if !p.output.SelfClosing.Remove[el.Start.Name.Local] {
+		return nil
+	}
+	start, err := p.encoder.holdOutput()
+	if err != nil {
+		return err
+	}
+	p.heldEmpties = append(p.heldEmpties, &heldEmpty{el: el, start: start})
+	return nil
+}
+
+// markEmptyContent は、holdEmpty で保留を始めた要素 el の開始タグを書き出した後の位置を記録します。
+// 終了タグの前にこの位置から出力が増えていなければ、要素は空です。
+func (p *Processor) markEmptyContent(el *Element) error {
+	n := len(p.heldEmpties)
+	if n == 0 || p.heldEmpties[n-1].el != el {
+		return nil
+	}
+	content, err := p.encoder.heldOffset()
+	p.heldEmpties[n-1].content = content
+	p.heldEmpties[n-1].pending = p.encoder.pending != nil
+	return err
+}
+
+// isHeldEmpty は、holdEmpty で保留を始めた要素 el の開始タグの後に空白以外を出力していないか (空か) を判定します。
+// 取り除かれた子要素のインデントなどが残っていても空とします。終了タグを書き出す前に呼び出します。
+func (p *Processor) isHeldEmpty(el *Element) (bool, error) {
+	n := len(p.heldEmpties)
+	if n == 0 || p.heldEmpties[n-1].el != el {
+		return false, nil
+	}
+	h := p.heldEmpties[n-1]
+	content, err := p.encoder.heldBytes(h.content)
+	if err != nil {
+		return false, err
+	}
+	if h.pending {
+		// 子を書き出すときに確定させた開始タグの '>'
+		content = bytes.TrimPrefix(content, []byte(">"))
+	}
+	return len(bytes.TrimSpace(content)) == 0, nil
+}
+
+// releaseEmpty は、終了タグを書き出した後に、holdEmpty で保留を始めた要素 el が空であれば取り除き、保留を確定させます。
+func (p *Processor) releaseEmpty(el *Element, empty bool) error {
+	n := len(p.heldEmpties)
+	if n == 0 || p.heldEmpties[n-1].el != el {
+		return nil
+	}
+	h := p.heldEmpties[n-1]
+	p.heldEmpties = p.heldEmpties[:n-1]
+	if empty {
+		end, err := p.encoder.heldOffset()
+		if err != nil {
+			return err
+		}
+		if err := p.dropHeld(h.start, end); err != nil {
+			return err
+		}
+	}
+	return p.encoder.releaseHeld()
+}
@@ -0,0 +1,95 @@
+package obufuku
+
+import "encoding/xml"
+
+// Hooks は、Processor が入力のトークンごとに呼び出す、埋め込み側の独自の処理です。
+// 未設定の項目は呼び出されません。いずれかの関数がエラーを返した場合、変換処理を中断します。
+type Hooks struct {
+	// OnStartElement は、組み込みのルールを適用する前に、入力の開始タグごとに呼び出されます。
+	// se を書き換えると、書き換えた開始タグにルールが適用されます。
+	// false を返すと、その要素を子孫や終了タグを含めて出力しません (挿入ルールなども適用されません)。
+	OnStartElement func(se *xml.StartElement) (bool, error)
+	// OnCharData は、値置換ルールを適用する前に、入力のテキストごとに呼び出されます。
+	// ctx.Element は、テキストを含む要素 (タグ名置換後) です。
+	// cd を書き換えると、書き換えたテキストにルールが適用され、false を返すとテキストを出力しません。
+	OnCharData func(cd *xml.CharData, ctx ValueContext) (bool, error)
+	// OnEndElement は、終了タグと後方挿入ルールの内容を書き出した後に呼び出されます。
+	// ee の名前は出力した終了タグの名前です。終了タグは開始タグと対応させるため書き換えられません。
+	OnEndElement func(ee xml.EndElement) error
+}
+
+// runStartElementHooks は、開始タグのフックを登録順に呼び出します。
+// 要素を出力するかどうかと、フックが開始タグを書き換えたかどうかを返します。
+func (p *Processor) runStartElementHooks(se *xml.StartElement) (keep, modified bool, err error) {
+	if len(p.hooks) == 0 {
+		return true, false, nil
+	}
+	original := xml.StartElement{Name: se.Name, Attr: append([]xml.Attr(nil), se.Attr...)}
+	for _, h := range p.hooks {
+		if h.OnStartElement == nil {
+			continue
+		}
+		if keep, err := h.OnStartElement(se); err != nil || !keep {
+			return false, false, err
+		}
+	}
+	return true, !sameStartElement(original, *se), nil
+}
+
+// runCharDataHooks は、テキストのフックを登録順に呼び出します。
+// テキストを出力するかどうかと、フックがテキストを書き換えたかどうかを返します。
+func (p *Processor) runCharDataHooks(cd *xml.CharData) (keep, modified bool, err error) {
+	if len(p.hooks) == 0 {
+		return true, false, nil
+	}
+	original := string(*cd)
+	ctx := ValueContext{}
+	if len(p.elementStack) > 0 {
+		ctx.Element = p.elementStack[len(p.elementStack)-1].Start
+	}
+	for _, h := range p.hooks {
+		if h.OnCharData == nil {
+			continue
+		}
+		if keep, err := h.OnCharData(cd, ctx); err != nil || !keep {
+			return false, false, err
+		}
+	}
+	return true, string(*cd) != original, nil
+}
+
+// runEndElementHooks は、終了タグのフックを登録順に呼び出します。
+func (p *Processor) runEndElementHooks(ee xml.EndElement) error {
+	for _, h := range p.hooks {
+		if h.OnEndElement == nil {
+			continue
+		}
+		if err := h.OnEndElement(ee); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipToken は、フックが出力しないとした要素の中のトークンを読み飛ばし、要素の終わりまでの深さを数えます。
+func (p *Processor) skipToken(token xml.Token) {
+	switch token.(type) {
+	case xml.StartElement:
+		p.skipDepth++
+	case xml.EndElement:
+		p.skipDepth--
+	}
+}
+
+// sameStartElement は、2つの開始タグの名前と属性が等しいかを判定します。
+func sameStartElement(a, b xml.StartElement) bool {
+	if a.Name != b.Name || len(a.Attr) != len(b.Attr) {
+		return false
+	}
+	for i := range a.Attr {
+		if a.Attr[i] != b.Attr[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// runWithHooks は、ルールセット cfg とフック hooks で input を変換した出力とエラーを返します。
+func runWithHooks(t *testing.T, cfg Config, input string, hooks ...Hooks) (string, error) {
+	t.Helper()
+	rs, err := NewRuleSet(cfg)
+	if err != nil {
+		t.Fatalf("NewRuleSet: %v", err)
+	}
+	var opts []Option
+	for _, h := range hooks {
+		opts = append(opts, WithHooks(h))
+	}
+	var out bytes.Buffer
+	_, err = rs.NewProcessor(strings.NewReader(input), &out, opts...).Run(context.Background())
+	return out.String(), err
+}
+
+func TestHooks(t *testing.T) {
+	errHook := errors.New("hook failed")
+	var ended []string
+	tests := []struct {
+		name  string
+		hooks []Hooks
+		want  string
+		err   error
+	}{
+		{
+			name: "rename before rules",
+			hooks: []Hooks{{OnStartElement: func(se *xml.StartElement) (bool, error) {
+				if se.Name.Local == "x" {
+					se.Name.Local = "b"
+				}
+				return true, nil
+			}}},
+			want: "<a><item>x!</item><item>y!</item><c>z<d></d></c></a>",
+		},
+		{
+			name: "skip element",
+			hooks: []Hooks{{OnStartElement: func(se *xml.StartElement) (bool, error) {
+				return se.Name.Local != "c", nil
+			}}},
+			want: "<a><x>x</x><item>y!</item></a>",
+		},
+		{
+			name: "rewrite and drop text",
+			hooks: []Hooks{{OnCharData: func(cd *xml.CharData, ctx ValueContext) (bool, error) {
+				if ctx.Element.Name.Local == "c" {
+					return false, nil
+				}
+				*cd = xml.CharData(strings.ToUpper(string(*cd)))
+				return true, nil
+			}}},
+			want: "<a><x>X</x><item>Y!</item><c><d></d></c></a>",
+		},
+		{
+			name: "hooks run in order",
+			hooks: []Hooks{
+				{OnCharData: func(cd *xml.CharData, ctx ValueContext) (bool, error) {
+					*cd = append(*cd, '1')
+					return true, nil
+				}},
+				{OnCharData: func(cd *xml.CharData, ctx ValueContext) (bool, error) {
+					*cd = append(*cd, '2')
+					return true, nil
+				}},
+			},
+			want: "<a><x>x12</x><item>y12!</item><c>z12<d></d></c></a>",
+		},
+		{
+			name: "end elements",
+			hooks: []Hooks{{OnEndElement: func(ee xml.EndElement) error {
+				ended = append(ended, ee.Name.Local)
+				return nil
+			}}},
+			want: "<a><x>x</x><item>y!</item><c>z<d></d></c></a>",
+		},
+		{
+			name: "error",
+			hooks: []Hooks{{OnStartElement: func(se *xml.StartElement) (bool, error) {
+				return true, errHook
+			}}},
+			err: errHook,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				NameRules:  []ConfigNameRule{{Old: "b", New: "item"}},
+				ValueRules: []ConfigValueRule{{Target: "item", Type: "append", Params: params{"suffix": "!"}}},
+				Output:     compactOutput,
+			}
+			got, err := runWithHooks(t, cfg, `<a><x>x</x><b>y</b><c>z<d/></c></a>`, tt.hooks...)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("Run error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if want := "x item d c a"; strings.Join(ended, " ") != want {
+		t.Errorf("end element hooks saw %q, want %q", strings.Join(ended, " "), want)
+	}
+}
@@ -0,0 +1,317 @@
+package obufuku
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// htmlVoidElements は、HTMLで終了タグを持たない空要素です。開始タグの直後に閉じたものとして扱います。
+var htmlVoidElements = htmlSet("area", "base", "br", "col", "embed", "hr", "img", "input", "keygen", "link", "meta", "param", "source", "track", "wbr")
+
+// htmlImpliedEnd は、開始タグが暗黙に閉じる要素 closes と、閉じる要素を探す範囲の境界になる要素 boundary です。
+type htmlImpliedEnd struct {
+	closes, boundary map[string]bool
+}
+
+// htmlImpliedEnds は、開始タグのタグ名 (小文字) ごとの、暗黙に閉じる要素です。
+// <li>one<li>two の2つ目の li は1つ目の li を閉じ、兄弟の要素になります。
+var htmlImpliedEnds = func() map[string]htmlImpliedEnd {
+	ends := map[string]htmlImpliedEnd{
+		"li":       {htmlSet("li"), htmlSet("ul", "ol", "menu")},
+		"dt":       {htmlSet("dt", "dd"), htmlSet("dl")},
+		"dd":       {htmlSet("dt", "dd"), htmlSet("dl")},
+		"option":   {htmlSet("option"), htmlSet("select", "datalist", "optgroup")},
+		"optgroup": {htmlSet("option", "optgroup"), htmlSet("select")},
+		"tr":       {htmlSet("tr", "td", "th"), htmlSet("table", "thead", "tbody", "tfoot")},
+		"td":       {htmlSet("td", "th"), htmlSet("tr", "table")},
+		"th":       {htmlSet("td", "th"), htmlSet("tr", "table")},
+		"thead":    {htmlSet("thead", "tbody", "tfoot", "tr", "td", "th"), htmlSet("table")},
+		"tbody":    {htmlSet("thead", "tbody", "tfoot", "tr", "td", "th"), htmlSet("table")},
+		"tfoot":    {htmlSet("thead", "tbody", "tfoot", "tr", "td", "th"), htmlSet("table")},
+	}
+	// ブロックの要素は、開いている p を閉じる
+	paragraph := htmlImpliedEnd{htmlSet("p"), htmlSet("table", "td", "th", "caption", "button", "object", "template", "html")}
+	for _, name := range []string{"address", "article", "aside", "blockquote", "details", "div", "dl", "fieldset", "figcaption", "figure",
+		"footer", "form", "h1", "h2", "h3", "h4", "h5", "h6", "header", "hr", "main", "menu", "nav", "ol", "p", "pre", "section", "table", "ul"} {
+		ends[name] = paragraph
+	}
+	return ends
+}()
+
+// htmlSet は、names を要素とする集合を返します。
+func htmlSet(names ...string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// htmlToken は、htmlTokenReader が返すトークンと、その直後の入力での位置です。
+type htmlToken struct {
+	token        xml.Token
+	offset       int64
+	line, column int
+}
+
+// htmlTokenReader は、HTMLのトークナイザー (golang.org/x/net/html) で入力を読み込み、開始タグと終了タグの
+// 対応をそろえたXMLのトークン列として返す xml.TokenReader です。トークンの形は xml.Decoder.RawToken と同じで
+// (接頭辞は Name.Space)、名前空間は xml.NewTokenDecoder で解決します。
+//
+// 引用符の無い属性値や値の無い属性、<br> のような空要素、閉じられていない <li> や <p>、script と style の中の
+// '<' や '&' を読み込めます。タグ名と属性名の大文字・小文字は入力のままにし、HTMLの規則 (空要素や暗黙に閉じる要素) は
+// 大文字・小文字を区別せずに適用します。対応する開始タグの無い終了タグは無視し、入力の終わりでは開いている要素を閉じます。
+// XMLの名前として使えないタグはテキストとして、そのような名前の属性は読み込みません。
+type htmlTokenReader struct {
+	z *html.Tokenizer
+	// open は、開いている要素の名前です。
+	open []xml.Name
+	// queue は、返していないトークンです (暗黙に閉じた要素の終了タグなど)。
+	queue []htmlToken
+	// offset・line・column は、読み込んだ入力の位置、current は最後に返したトークンの位置です。
+	offset       int64
+	line, column int
+	current      htmlToken
+	err          error
+}
+
+// newHTMLTokenReader は、UTF-8のHTMLの入力 r を読み込む htmlTokenReader を作成します。
+func newHTMLTokenReader(r io.Reader) *htmlTokenReader {
+	z := html.NewTokenizer(r)
+	z.AllowCDATA(true)
+	return &htmlTokenReader{z: z, line: 1, column: 1, current: htmlToken{line: 1, column: 1}}
+}
+
+// Token は xml.TokenReader インターフェースを実装します。
+func (r *htmlTokenReader) Token() (xml.Token, error) {
+	for len(r.queue) == 0 {
+		if r.err != nil {
+			return nil, r.err
+		}
+		r.next()
+	}
+	r.current = r.queue[0]
+	r.queue = r.queue[1:]
+	return r.current.token, nil
+}
+
+// next は、入力から次のトークンを読み込み、XMLのトークンにして queue に加えます。
+func (r *htmlTokenReader) next() {
+	tt := r.z.Next()
+	raw := r.z.Raw()
+	before := htmlToken{offset: r.offset, line: r.line, column: r.column}
+	r.advance(raw)
+	switch tt {
+	case html.ErrorToken:
+		r.err = r.z.Err()
+		if r.err == io.EOF {
+			for len(r.open) > 0 {
+				r.closeElement(len(r.open)-1, before)
+			}
+		}
+	case html.TextToken:
+		r.emit(xml.CharData(bytes.Clone(r.z.Text())))
+	case html.StartTagToken, html.SelfClosingTagToken:
+		name, attrs, ok := parseHTMLStartTag(raw)
+		if !ok {
+			r.emit(xml.CharData(bytes.Clone(raw)))
+			return
+		}
+		if name.Space == "" {
+			if end, ok := htmlImpliedEnds[strings.ToLower(name.Local)]; ok {
+				r.closeImplied(end, before)
+			}
+		}
+		r.emit(xml.StartElement{Name: name, Attr: attrs})
+		if tt == html.SelfClosingTagToken || name.Space == "" && htmlVoidElements[strings.ToLower(name.Local)] {
+			r.emit(xml.EndElement{Name: name})
+			return
+		}
+		r.open = append(r.open, name)
+	case html.EndTagToken:
+		name, ok := parseHTMLEndTag(raw)
+		if !ok {
+			r.emit(xml.CharData(bytes.Clone(raw)))
+			return
+		}
+		if i := r.findOpen(name); i >= 0 {
+			for len(r.open) > i+1 {
+				r.closeElement(len(r.open)-1, before)
+			}
+			r.closeElement(i, htmlToken{offset: r.offset, line: r.line, column: r.column})
+		}
+	case html.CommentToken:
+		if bytes.HasPrefix(raw, []byte("<?")) {
+			// HTMLではXML宣言などの処理命令もコメントとして読み込まれる
+			r.emit(parseHTMLProcInst(raw))
+			return
+		}
+		r.emit(xml.Comment(bytes.Clone(r.z.Text())))
+	case html.DoctypeToken:
+		r.emit(xml.Directive(strings.TrimSuffix(string(raw[2:]), ">")))
+	}
+}
+
+// advance は、入力の位置を raw の後に進めます。
+func (r *htmlTokenReader) advance(raw []byte) {
+	r.offset += int64(len(raw))
+	if i := bytes.LastIndexByte(raw, '\n'); i >= 0 {
+		r.line += bytes.Count(raw, []byte("\n"))
+		r.column = len(raw) - i
+		return
+	}
+	r.column += len(raw)
+}
+
+// emit は、読み込んだ入力の位置のトークンを queue に加えます。
+func (r *htmlTokenReader) emit(token xml.Token) {
+	r.queue = append(r.queue, htmlToken{token: token, offset: r.offset, line: r.line, column: r.column})
+}
+
+// closeElement は、開いている要素のうち i 番目の要素の、位置 at の終了タグを queue に加え、その要素を閉じます。
+// i は開いている最も内側の要素である必要があります。
+func (r *htmlTokenReader) closeElement(i int, at htmlToken) {
+	at.token = xml.EndElement{Name: r.open[i]}
+	r.queue = append(r.queue, at)
+	r.open = r.open[:i]
+}
+
+// closeImplied は、開始タグが暗黙に閉じる要素が境界の要素より内側で開いていれば、
+// その要素と、それより内側の要素を閉じます。
+func (r *htmlTokenReader) closeImplied(end htmlImpliedEnd, at htmlToken) {
+	// <tr><td>a<tr> の2つ目の tr は、td だけでなく tr まで閉じるため、境界までで最も外側の要素を探す
+	outer := -1
+	for i := len(r.open) - 1; i >= 0; i-- {
+		if r.open[i].Space != "" {
+			continue
+		}
+		name := strings.ToLower(r.open[i].Local)
+		if end.closes[name] {
+			outer = i
+		}
+		if end.boundary[name] {
+			break
+		}
+	}
+	for outer >= 0 && len(r.open) > outer {
+		r.closeElement(len(r.open)-1, at)
+	}
+}
+
+// findOpen は、終了タグの名前 name に対応する開いている要素の位置を返します (無ければ -1)。
+// 名前が同じ要素が無い場合は、大文字・小文字を区別せずに探します。
+func (r *htmlTokenReader) findOpen(name xml.Name) int {
+	for i := len(r.open) - 1; i >= 0; i-- {
+		if r.open[i] == name {
+			return i
+		}
+	}
+	for i := len(r.open) - 1; i >= 0; i-- {
+		if strings.EqualFold(r.open[i].Space, name.Space) && strings.EqualFold(r.open[i].Local, name.Local) {
+			return i
+		}
+	}
+	return -1
+}
+
+// position は、最後に返したトークンの直後の入力のバイト位置と、その行と桁を返します。
+func (r *htmlTokenReader) position() (offset int64, line, column int) {
+	return r.current.offset, r.current.line, r.current.column
+}
+
+// isHTMLSpace は、c がHTMLのタグの中の空白かを返します。
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f'
+}
+
+// parseHTMLStartTag は、開始タグの入力 raw ("<a href=page.html>" など) からタグ名と属性を読み取ります。
+// 属性は html.Tokenizer と同じ規則で区切り、同じ名前 (大文字・小文字を区別しない) の属性は最初のものだけを使います。
+// タグ名がXMLの名前として使えない場合は ok が false になります。
+func parseHTMLStartTag(raw []byte) (name xml.Name, attrs []xml.Attr, ok bool) {
+	s := strings.TrimSuffix(string(raw[1:]), ">")
+	i := strings.IndexFunc(s, func(r rune) bool { return r < 0x80 && (isHTMLSpace(byte(r)) || r == '/') })
+	if i < 0 {
+		i = len(s)
+	}
+	if !isQName(s[:i]) {
+		return xml.Name{}, nil, false
+	}
+	name = htmlName(s[:i])
+	seen := make(map[string]bool)
+	for rest := s[i:]; ; {
+		rest = strings.TrimLeft(rest, " \n\r\t\f")
+		if rest == "" {
+			break
+		}
+		if rest[0] == '/' {
+			rest = rest[1:]
+			continue
+		}
+		// 属性名は空白・'/'・'=' まで (先頭の '=' は名前に含める)
+		j := 1
+		for j < len(rest) && !isHTMLSpace(rest[j]) && rest[j] != '/' && rest[j] != '=' {
+			j++
+		}
+		key, value := rest[:j], ""
+		rest = rest[j:]
+		if t := strings.TrimLeft(rest, " \n\r\t\f"); strings.HasPrefix(t, "=") {
+			t = strings.TrimLeft(t[1:], " \n\r\t\f")
+			switch {
+			case t != "" && (t[0] == '"' || t[0] == '\''):
+				if k := strings.IndexByte(t[1:], t[0]); k >= 0 {
+					value, t = t[1:1+k], t[2+k:]
+				} else {
+					value, t = t[1:], ""
+				}
+			default:
+				k := strings.IndexAny(t, " \n\r\t\f")
+				if k < 0 {
+					k = len(t)
+				}
+				value, t = t[:k], t[k:]
+			}
+			rest = t
+		}
+		if lower := strings.ToLower(key); !seen[lower] && isQName(key) {
+			seen[lower] = true
+			attrs = append(attrs, xml.Attr{Name: htmlName(key), Value: html.UnescapeString(value)})
+		}
+	}
+	return name, attrs, true
+}
+
+// parseHTMLEndTag は、終了タグの入力 raw ("</li>" など) からタグ名を読み取ります。
+// タグ名がXMLの名前として使えない場合は ok が false になります。
+func parseHTMLEndTag(raw []byte) (name xml.Name, ok bool) {
+	s := strings.TrimSuffix(string(raw[2:]), ">")
+	if i := strings.IndexAny(s, " \n\r\t\f/"); i >= 0 {
+		s = s[:i]
+	}
+	if !isQName(s) {
+		return xml.Name{}, false
+	}
+	return htmlName(s), true
+}
+
+// parseHTMLProcInst は、コメントとして読み込まれた処理命令の入力 raw ("<?xml version="1.0"?>" など) を
+// 処理命令のトークンにします。
+func parseHTMLProcInst(raw []byte) xml.ProcInst {
+	s := strings.TrimSuffix(strings.TrimSuffix(string(raw[2:]), ">"), "?")
+	i := strings.IndexAny(s, " \n\r\t")
+	if i < 0 {
+		return xml.ProcInst{Target: s}
+	}
+	return xml.ProcInst{Target: s[:i], Inst: []byte(strings.TrimLeft(s[i:], " \n\r\t"))}
+}
+
+// htmlName は、接頭辞付きの名前 s を、xml.Decoder.RawToken と同じく接頭辞を Space とする名前にします。
+func htmlName(s string) xml.Name {
+	if space, local, ok := strings.Cut(s, ":"); ok && space != "" && local != "" && !strings.Contains(local, ":") {
+		return xml.Name{Space: space, Local: local}
+	}
+	return xml.Name{Local: s}
+}
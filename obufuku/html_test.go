@@ -0,0 +1,124 @@
+package obufuku
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTMLInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "unquoted attribute",
+			input: `<p><a href=page.html>x</a></p>`,
+			want:  `<p><a href="page.html">x</a></p>`,
+		},
+		{
+			name:  "void element",
+			input: `<p><img src=x.png>text</p>`,
+			want:  `<p><img src="x.png"></img>text</p>`,
+		},
+		{
+			name:  "boolean attributes keep their case",
+			input: `<div><input type=checkbox checked DISABLED><br></div>`,
+			want:  `<div><input type="checkbox" checked="" DISABLED=""></input><br></br></div>`,
+		},
+		{
+			name:  "duplicate attribute",
+			input: `<div ID=x Id=y onclick="a<b">t</div>`,
+			want:  `<div ID="x" onclick="a&lt;b">t</div>`,
+		},
+		{
+			name:  "script text",
+			input: `<div><script>if (a < b && c) {}</script></div>`,
+			want:  `<div><script>if (a &lt; b &amp;&amp; c) {}</script></div>`,
+		},
+		{
+			name:  "style text",
+			input: `<div><style>p > a { x: "<" }</style></div>`,
+			want:  `<div><style>p &gt; a { x: &#34;&lt;&#34; }</style></div>`,
+		},
+		{
+			name:  "unclosed list items are siblings",
+			input: `<ul><li>one<li>two</ul>`,
+			want:  `<ul><li>one</li><li>two</li></ul>`,
+		},
+		{
+			name:  "nested list",
+			input: `<ul><li>a<ul><li>b</ul><li>c</ul>`,
+			want:  `<ul><li>a<ul><li>b</li></ul></li><li>c</li></ul>`,
+		},
+		{
+			name:  "table rows and cells",
+			input: `<table><tr><td>a<td>b<tr><td>c</table>`,
+			want:  `<table><tr><td>a</td><td>b</td></tr><tr><td>c</td></tr></table>`,
+		},
+		{
+			name:  "block element closes paragraph",
+			input: `<!DOCTYPE html><html><body><p>a<p>b<div>c</div></body></html>`,
+			want:  `<!DOCTYPE html><html><body><p>a</p><p>b</p><div>c</div></body></html>`,
+		},
+		{
+			name:  "html entities",
+			input: `<p>&nbsp;&copy; a &amp b</p>`,
+			want:  "<p> © a &amp; b</p>",
+		},
+		{
+			name:  "stray end tag",
+			input: `<p>1</div>2</p>`,
+			want:  `<p>12</p>`,
+		},
+		{
+			name:  "unclosed at end of input",
+			input: `<div>unclosed<span>x`,
+			want:  `<div>unclosed<span>x</span></div>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Input: ConfigInput{HTML: true}, Output: compactOutput}
+			got, _ := transformString(t, cfg, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLInputRules(t *testing.T) {
+	// HTMLとして読み込んだ要素にも、XMLの入力と同じくルールを適用する
+	cfg := Config{
+		Input:      ConfigInput{HTML: true},
+		NameRules:  []ConfigNameRule{{Old: "li", New: "item"}},
+		ValueRules: []ConfigValueRule{{Target: "item", Type: "append", Params: params{"suffix": "!"}}},
+		Output:     compactOutput,
+	}
+	got, _ := transformString(t, cfg, `<ul><li>one<li>two</ul>`)
+	if want := `<ul><item>one!</item><item>two!</item></ul>`; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestHTMLInputErrors(t *testing.T) {
+	lenient := false
+	tests := []struct {
+		name  string
+		input ConfigInput
+		out   ConfigOutput
+		err   string
+	}{
+		{"with decoder", ConfigInput{HTML: true, Decoder: ConfigDecoder{Strict: &lenient}}, ConfigOutput{}, "'html' and 'decoder' cannot be used together"},
+		{"with minimal", ConfigInput{HTML: true}, ConfigOutput{Minimal: true}, "'html' cannot be used with 'minimal'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tryTransformString(t, Config{Input: tt.input, Output: tt.out}, "<p>x</p>")
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Transform error = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package obufuku
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// 値置換ルールの種類 "kana" の変換先 (params["to"]) です。
+const (
+	kanaToKatakana          = "katakana"
+	kanaToHiragana          = "hiragana"
+	kanaToHalfwidthKatakana = "halfwidth_katakana"
+)
+
+// hiraganaKatakanaOffset は、ひらがな (ぁ〜ゖ、ゝ、ゞ) と対応するカタカナの符号位置の差です。
+const hiraganaKatakanaOffset = 'ァ' - 'ぁ'
+
+// newKanaFunc は、値のひらがなとカタカナを相互に変換する置換関数を作成します。
+// params["to"] が "katakana" の場合はひらがなと半角カタカナを (全角の) カタカナに、"hiragana" の場合は
+// カタカナと半角カタカナをひらがなに、"halfwidth_katakana" の場合はひらがなとカタカナを半角カタカナにします。
+// 対応する文字が無いもの (ヷ や、半角にできない ヮ など) と、かな以外の文字はそのまま残します。
+func newKanaFunc(params map[string]interface{}) (ValueReplaceFunc, error) {
+	to, _ := params["to"].(string)
+	switch to {
+	case kanaToKatakana, kanaToHiragana, kanaToHalfwidthKatakana:
+	default:
+		return nil, fmt.Errorf("invalid or missing 'to' for kana rule (available: %s, %s, %s)", kanaToKatakana, kanaToHiragana, kanaToHalfwidthKatakana)
+	}
+	return func(oldValue string) string {
+		return convertKana(to, oldValue)
+	}, nil
+}
+
+// convertKana は、s のかなを to の種類にそろえます。
+func convertKana(to, s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if isHalfwidthKatakana(r) {
+			// 濁点・半濁点を前の文字と合わせて全角にするため、半角カタカナの連続をまとめて変換する
+			j := i + size
+			for j < len(s) {
+				next, n := utf8.DecodeRuneInString(s[j:])
+				if !isHalfwidthKatakana(next) {
+					break
+				}
+				j += n
+			}
+			// 全角にした濁点・半濁点は結合文字 (U+3099、U+309A) になるため、NFC で前の文字と合成する
+			run := norm.NFC.String(width.Widen.String(s[i:j]))
+			if to == kanaToHalfwidthKatakana {
+				b.WriteString(s[i:j])
+			} else {
+				for _, r := range run {
+					b.WriteRune(convertKanaRune(to, r))
+				}
+			}
+			i = j
+			continue
+		}
+		i += size
+		r = convertKanaRune(to, r)
+		if to == kanaToHalfwidthKatakana && isFullwidthKatakana(r) {
+			b.WriteString(narrowKatakana(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// convertKanaRune は、全角のかな r をひらがなまたはカタカナにします (半角カタカナにする場合はカタカナにします)。
+func convertKanaRune(to string, r rune) rune {
+	switch {
+	case to != kanaToHiragana && (r >= 'ぁ' && r <= 'ゖ' || r == 'ゝ' || r == 'ゞ'):
+		return r + hiraganaKatakanaOffset
+	case to == kanaToHiragana && (r >= 'ァ' && r <= 'ヶ' || r == 'ヽ' || r == 'ヾ'):
+		return r - hiraganaKatakanaOffset
+	}
+	return r
+}
+
+// narrowKatakana は、カタカナ r を半角カタカナにします。濁音・半濁音は、清音と濁点・半濁点の2文字にします。
+// 半角にできない文字はそのまま返します。
+func narrowKatakana(r rune) string {
+	var b strings.Builder
+	for _, c := range norm.NFD.String(string(r)) {
+		switch c {
+		case '゙', '゛':
+			b.WriteRune('ﾞ')
+		case '゚', '゜':
+			b.WriteRune('ﾟ')
+		default:
+			b.WriteString(width.Narrow.String(string(c)))
+		}
+	}
+	if narrowed := b.String(); !strings.ContainsFunc(narrowed, isFullwidthKatakana) {
+		return narrowed
+	}
+	return string(r)
+}
+
+// isFullwidthKatakana は、r が半角カタカナにできる可能性のある全角の文字 (カタカナと、句読点・長音などの記号) かを判定します。
+func isFullwidthKatakana(r rune) bool {
+	return r >= '゛' && r <= '゜' || r >= '゠' && r <= 'ヿ' || r == '。' || r == '「' || r == '」' || r == '、'
+}
+
+// isHalfwidthKatakana は、r が半角カタカナ (半角の句読点・記号を含む) かを判定します。
+func isHalfwidthKatakana(r rune) bool {
+	return r >= '｡' && r <= 'ﾟ'
+}
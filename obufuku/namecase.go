@@ -0,0 +1,63 @@
+package obufuku
+
+import (
+	"strings"
+	"unicode"
+)
+
+// タグ名を統一する形です。
+const (
+	caseLower = "lower"
+	caseUpper = "upper"
+	caseCamel = "camel"
+)
+
+// nameCases は、タグ名を統一する形ごとの変換関数です。
+var nameCases = map[string]func(string) string{
+	caseLower: strings.ToLower,
+	caseUpper: strings.ToUpper,
+	caseCamel: camelCase,
+}
+
+// camelCase は、name を単語に分け、先頭の単語を小文字、以降の単語を先頭だけ大文字にしてつなげます。
+// 単語は '_'、'-'、'.' で区切られた部分と、小文字から大文字への変わり目、
+// 連続する大文字の最後の1文字が小文字に続く箇所 ("XMLFile" の "XML" と "File") で分けます。
+func camelCase(name string) string {
+	var b strings.Builder
+	for i, word := range nameWords(name) {
+		runes := []rune(strings.ToLower(word))
+		if i > 0 {
+			runes[0] = unicode.ToUpper(runes[0])
+		}
+		b.WriteString(string(runes))
+	}
+	if b.Len() == 0 {
+		return name
+	}
+	return b.String()
+}
+
+// nameWords は、camelCase のためにタグ名を単語に分けます。
+func nameWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			words = append(words, string(runes[start:end]))
+		}
+	}
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == '.':
+			flush(i)
+			start = i + 1
+		case i > start && unicode.IsUpper(r) && unicode.IsLower(runes[i-1]),
+			i > start && unicode.IsUpper(r) && unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+			flush(i)
+			start = i
+		}
+	}
+	flush(len(runes))
+	return words
+}
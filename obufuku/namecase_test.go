@@ -0,0 +1,27 @@
+package obufuku
+
+import "testing"
+
+func TestCamelCase(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"order_id", "orderId"},
+		{"order-id", "orderId"},
+		{"order.id", "orderId"},
+		{"OrderID", "orderId"},
+		{"XMLFile", "xmlFile"},
+		{"orderId", "orderId"},
+		{"ORDER_ID", "orderId"},
+		{"a__b", "aB"},
+		{"_1st", "1st"},
+		{"___", "___"},
+		{"名前_一覧", "名前一覧"},
+	}
+	for _, tt := range tests {
+		if got := camelCase(tt.name); got != tt.want {
+			t.Errorf("camelCase(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
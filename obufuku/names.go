@@ -0,0 +1,80 @@
+package obufuku
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// isNameStartChar は、r がXMLの名前 (NCName) の先頭に使える文字かを返します (XML 1.0 第5版)。
+func isNameStartChar(r rune) bool {
+	switch {
+	case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r == '_':
+		return true
+	case r >= 0xC0 && r <= 0xD6, r >= 0xD8 && r <= 0xF6, r >= 0xF8 && r <= 0x2FF,
+		r >= 0x370 && r <= 0x37D, r >= 0x37F && r <= 0x1FFF, r >= 0x200C && r <= 0x200D,
+		r >= 0x2070 && r <= 0x218F, r >= 0x2C00 && r <= 0x2FEF, r >= 0x3001 && r <= 0xD7FF,
+		r >= 0xF900 && r <= 0xFDCF, r >= 0xFDF0 && r <= 0xFFFD, r >= 0x10000 && r <= 0xEFFFF:
+		return true
+	}
+	return false
+}
+
+// isNameChar は、r がXMLの名前 (NCName) の2文字目以降に使える文字かを返します。
+func isNameChar(r rune) bool {
+	switch {
+	case isNameStartChar(r), r == '-', r == '.', r >= '0' && r <= '9', r == 0xB7,
+		r >= 0x300 && r <= 0x36F, r >= 0x203F && r <= 0x2040:
+		return true
+	}
+	return false
+}
+
+// isNCName は、s がコロンを含まないXMLの名前 (NCName) かを返します。
+func isNCName(s string) bool {
+	if s == "" || !utf8.ValidString(s) {
+		return false
+	}
+	for i, r := range s {
+		if i == 0 && !isNameStartChar(r) || !isNameChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isQName は、s が NCName または接頭辞付きの名前 (prefix:local) かを返します。
+func isQName(s string) bool {
+	prefix, local, found := strings.Cut(s, ":")
+	if !found {
+		return isNCName(s)
+	}
+	return isNCName(prefix) && isNCName(local)
+}
+
+// validateName は、ルールが出力する要素名や属性名 (what) が正しいXMLの名前かを検査します。
+func validateName(what, name string) error {
+	if !isQName(name) {
+		return fmt.Errorf("%s '%s' is not a valid XML name", what, name)
+	}
+	return nil
+}
+
+// isXMLChar は、r がXML 1.0の文書に含められる文字かを返します。
+func isXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		r >= 0x20 && r <= 0xD7FF || r >= 0xE000 && r <= 0xFFFD || r >= 0x10000 && r <= 0x10FFFF
+}
+
+// validateText は、ルールが出力するテキスト (what) にXMLで使えない文字が含まれていないかを検査します。
+func validateText(what, text string) error {
+	for i, r := range text {
+		if r == utf8.RuneError && !strings.HasPrefix(text[i:], "�") {
+			return fmt.Errorf("%s contains invalid UTF-8 at byte %d", what, i)
+		}
+		if !isXMLChar(r) {
+			return fmt.Errorf("%s contains the character %U, which is not allowed in XML", what, r)
+		}
+	}
+	return nil
+}
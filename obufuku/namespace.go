@@ -0,0 +1,305 @@
+package obufuku
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+)
+
+// 未宣言の名前空間接頭辞の扱い (InputOptions.UndeclaredPrefixes) です。
+const (
+	// undeclaredKeep は、接頭辞をそのまま出力します (既定)。
+	undeclaredKeep = "keep"
+	// undeclaredDeclare は、接頭辞に仮のURIを割り当て、使われた要素で宣言します。
+	undeclaredDeclare = "declare"
+	// undeclaredStrip は、接頭辞を取り除いてローカル名だけにします。
+	undeclaredStrip = "strip"
+	// undeclaredError は、未宣言の接頭辞をエラーとします。
+	undeclaredError = "error"
+)
+
+// undeclaredPrefixURI は、declare モードで未宣言の接頭辞に割り当てる仮のURIです。
+const undeclaredPrefixURI = "urn:x-undeclared-prefix:"
+
+// resolveUndeclaredPrefixes は、開始タグの要素名と属性名で使われている未宣言の接頭辞を
+// 入力設定に従って処理し、変更した場合は true を返します。
+// encoding/xml は未宣言の接頭辞をURIの代わりに Name.Space にそのまま残すため、
+// 祖先と自身の名前空間宣言のいずれにも一致しない Space を未宣言の接頭辞とみなします。
+func (p *Processor) resolveUndeclaredPrefixes(se *xml.StartElement) (bool, error) {
+	mode := p.input.UndeclaredPrefixes
+	if mode == "" || mode == undeclaredKeep {
+		return false, nil
+	}
+
+	// 属性を書き換えるため、デコーダが返したスライスを共有しないようコピーする
+	se.Attr = append([]xml.Attr(nil), se.Attr...)
+	var decls []xml.Attr
+	modified := false
+	resolve := func(name *xml.Name, isAttr bool) error {
+		if name.Space == "" || name.Space == xmlNamespaceURI || isAttr && name.Space == "xmlns" || p.isBoundNamespace(name.Space, se.Attr) {
+			return nil
+		}
+		prefix := name.Space
+		line := p.line
+		switch mode {
+		case undeclaredError:
+			return &ParseError{Err: fmt.Errorf("undeclared namespace prefix '%s' in '%s:%s'", prefix, prefix, name.Local)}
+		case undeclaredStrip:
+			name.Space = ""
+		case undeclaredDeclare:
+			uri := undeclaredPrefixURI + prefix
+			if !p.isBoundNamespace(uri, se.Attr) && !p.isBoundNamespace(uri, decls) {
+				decls = append(decls, xml.Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: uri})
+			}
+			name.Space = uri
+		}
+		modified = true
+		if !p.warnedPrefixes[prefix] {
+			if p.warnedPrefixes == nil {
+				p.warnedPrefixes = make(map[string]bool)
+			}
+			p.warnedPrefixes[prefix] = true
+			p.warn("undeclared namespace prefix '%s' first used on line %d (handled with mode '%s')", prefix, line, mode)
+		}
+		return nil
+	}
+
+	if err := resolve(&se.Name, false); err != nil {
+		return false, err
+	}
+	for i := range se.Attr {
+		if err := resolve(&se.Attr[i].Name, true); err != nil {
+			return false, err
+		}
+	}
+	se.Attr = append(se.Attr, decls...)
+	return modified, nil
+}
+
+// isBoundNamespace は、名前空間URIが開始タグ自身の属性または祖先の要素で宣言されているかを判定します。
+func (p *Processor) isBoundNamespace(uri string, attrs []xml.Attr) bool {
+	for _, b := range namespaceBindings(attrs) {
+		if b.uri == uri {
+			return true
+		}
+	}
+	for i := len(p.elementStack) - 1; i >= 0; i-- {
+		for _, b := range namespaceBindings(p.elementStack[i].Start.Attr) {
+			if b.uri == uri {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// qualifiedAttrName は、開始タグの属性 attrs と祖先の要素の名前空間宣言から属性名 name の接頭辞を探し、
+// 接頭辞付きの名前を返します。未宣言の接頭辞は Space をそのまま接頭辞とします。
+func (p *Processor) qualifiedAttrName(name xml.Name, attrs []xml.Attr) string {
+	switch name.Space {
+	case "":
+		return name.Local
+	case xmlNamespaceURI:
+		return "xml:" + name.Local
+	}
+	scopes := [][]xml.Attr{attrs}
+	for i := len(p.elementStack) - 1; i >= 0; i-- {
+		scopes = append(scopes, p.elementStack[i].Start.Attr)
+	}
+	for _, scope := range scopes {
+		for _, b := range namespaceBindings(scope) {
+			// 属性は既定の名前空間に属さない
+			if b.uri == name.Space && b.prefix != "" {
+				return b.prefix + ":" + name.Local
+			}
+		}
+	}
+	return name.Space + ":" + name.Local
+}
+
+// prefixFilter は、名前空間の接頭辞を書き換えます (prefix_rules)。
+// 出力する接頭辞は名前空間宣言から決まるため、宣言 xmlns:old を xmlns:new に書き換えます。
+// 未宣言の接頭辞 (入力設定の undeclared_prefixes が keep の場合) は、要素名と属性名の接頭辞を直接書き換えます。
+type prefixFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f prefixFilter) BeforeStart(w TokenWriter, el *Element) error {
+	for i, rule := range f.p.prefixRules {
+		// 最小変更モードでは、接頭辞を含む開始タグを入力のまま出力しない (終了タグも合わせて出力し直す)
+		if f.p.recorder != nil && bytes.Contains(f.p.rawToken, []byte(rule.Old+":")) {
+			el.modified = true
+		}
+		for j, attr := range el.Start.Attr {
+			if attr.Name.Space != "xmlns" || attr.Name.Local != rule.Old {
+				continue
+			}
+			for _, other := range el.Start.Attr {
+				if other.Name.Space == "xmlns" && other.Name.Local == rule.New && other.Value != attr.Value {
+					return &EncodeError{Rule: fmt.Sprintf("%s[%d]", hitPrefixRules, i), Err: fmt.Errorf("prefix '%s' is already declared for a different namespace '%s'", rule.New, other.Value)}
+				}
+			}
+			f.p.ruleApplied(hitPrefixRules, i, el.Start.Name.Local, rule.Old, rule.New)
+			el.Start.Attr[j].Name.Local = rule.New
+			el.modified = true
+		}
+		el.Start.Attr = dedupeNamespaceDecls(el.Start.Attr)
+		names := []*xml.Name{&el.Start.Name}
+		for j := range el.Start.Attr {
+			names = append(names, &el.Start.Attr[j].Name)
+		}
+		for _, name := range names {
+			if name.Space == rule.Old && !f.p.isBoundNamespace(rule.Old, el.Start.Attr) {
+				f.p.ruleApplied(hitPrefixRules, i, el.Start.Name.Local, rule.Old, rule.New)
+				name.Space = rule.New
+				el.modified = true
+			}
+		}
+	}
+	return nil
+}
+
+// namespaceFilter は、名前空間URIを書き換えます (namespace_rules)。
+// 名前空間宣言の値を書き換え、その名前空間に属する要素名と属性名の名前空間も合わせて書き換えます。
+// 出力する接頭辞は名前空間宣言から決まるため、接頭辞は変わりません。
+type namespaceFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f namespaceFilter) BeforeStart(w TokenWriter, el *Element) error {
+	if len(f.p.namespaceRules) == 0 {
+		return nil
+	}
+	if i := f.p.namespaceRule(el.Start.Name.Space); i >= 0 {
+		el.Start.Name.Space = f.p.namespaceRules[i].New
+	}
+	for j, attr := range el.Start.Attr {
+		if !isNamespaceDecl(attr) {
+			if i := f.p.namespaceRule(attr.Name.Space); i >= 0 {
+				el.Start.Attr[j].Name.Space = f.p.namespaceRules[i].New
+			}
+			continue
+		}
+		if i := f.p.namespaceRule(attr.Value); i >= 0 {
+			rule := f.p.namespaceRules[i]
+			f.p.ruleApplied(hitNamespaceRules, i, el.Start.Name.Local, rule.Old, rule.New)
+			el.Start.Attr[j].Value = rule.New
+		}
+	}
+	return nil
+}
+
+// namespaceRule は、名前空間URI uri を書き換える最初のルールの位置を返します (無ければ -1)。
+func (p *Processor) namespaceRule(uri string) int {
+	if uri == "" {
+		return -1
+	}
+	for i, rule := range p.namespaceRules {
+		if rule.Old == uri {
+			return i
+		}
+	}
+	return -1
+}
+
+// defaultNamespaceScope は、default_namespace_rules のルールを適用している文書での、要素の既定の名前空間です。
+type defaultNamespaceScope struct {
+	// rule は、ルート要素で適用したルールの位置です。
+	rule int
+	// input は、入力での既定の名前空間のURIです (宣言が無ければ空)。
+	input string
+	// mapped は、入力の既定の名前空間をルールの名前空間に置き換えているかどうかです。
+	// Scrub でない場合、既定の名前空間を宣言し直した子孫の要素とその子孫では false になります。
+	mapped bool
+}
+
+// defaultNamespaceFilter は、ルート要素の既定の名前空間を設定します (default_namespace_rules)。
+type defaultNamespaceFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f defaultNamespaceFilter) BeforeStart(w TokenWriter, el *Element) error {
+	root := len(f.p.elementStack) == 0
+	var scope defaultNamespaceScope
+	if root {
+		i := f.rootRule(el)
+		if i < 0 {
+			return nil
+		}
+		f.p.targetSeen(hitDefaultNamespaceRules, i)
+		scope = defaultNamespaceScope{rule: i, mapped: true}
+	} else {
+		parent := f.p.elementStack[len(f.p.elementStack)-1].defaultNamespace
+		if parent == nil {
+			return nil
+		}
+		scope = *parent
+	}
+	rule := f.p.defaultNamespaceRules[scope.rule]
+
+	// 要素自身の既定の名前空間の宣言
+	decl := -1
+	for j, attr := range el.Start.Attr {
+		if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			decl = j
+			break
+		}
+	}
+	if decl >= 0 {
+		scope.input = el.Start.Attr[decl].Value
+		if root || rule.Scrub {
+			if old := el.Start.Attr[decl].Value; old != rule.URI {
+				f.p.ruleApplied(hitDefaultNamespaceRules, scope.rule, el.Start.Name.Local, old, rule.URI)
+			}
+			el.Start.Attr = append(el.Start.Attr[:decl:decl], el.Start.Attr[decl+1:]...)
+			scope.mapped = true
+		} else {
+			scope.mapped = false
+		}
+	}
+	if root {
+		if decl < 0 {
+			f.p.ruleApplied(hitDefaultNamespaceRules, scope.rule, el.Start.Name.Local, "", rule.URI)
+		}
+		el.Start.Attr = append([]xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: rule.URI}}, el.Start.Attr...)
+	}
+	if scope.mapped && el.Start.Name.Space == scope.input {
+		el.Start.Name.Space = rule.URI
+	}
+	el.defaultNamespace = &scope
+	return nil
+}
+
+// rootRule は、ルート要素 el に適用する最初のルールの位置を返します (無ければ -1)。
+func (f defaultNamespaceFilter) rootRule(el *Element) int {
+	for i, rule := range f.p.defaultNamespaceRules {
+		if rule.TargetTag == "" || el.Input.Local == rule.TargetTag {
+			return i
+		}
+	}
+	return -1
+}
+
+// dedupeNamespaceDecls は、接頭辞の書き換えで重複した同じ名前空間宣言 (接頭辞もURIも同じもの) を1つにします。
+func dedupeNamespaceDecls(attrs []xml.Attr) []xml.Attr {
+	for i := 0; i < len(attrs); i++ {
+		if attrs[i].Name.Space != "xmlns" {
+			continue
+		}
+		for j := i + 1; j < len(attrs); j++ {
+			if attrs[j] == attrs[i] {
+				attrs = append(attrs[:j:j], attrs[j+1:]...)
+				j--
+			}
+		}
+	}
+	return attrs
+}
+
+// isNamespaceDecl は、attr が名前空間宣言 (xmlns または xmlns:接頭辞) かを判定します。
+func isNamespaceDecl(attr xml.Attr) bool {
+	return attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns"
+}
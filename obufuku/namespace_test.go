@@ -0,0 +1,35 @@
+package obufuku
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUndeclaredPrefixes(t *testing.T) {
+	const input = `<a xmlns:p="urn:p"><p:b/><q:c q:x="1"/></a>`
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"", "<a xmlns:p=\"urn:p\"><p:b></p:b><q:c q:x=\"1\"></q:c></a>"},
+		{"keep", "<a xmlns:p=\"urn:p\"><p:b></p:b><q:c q:x=\"1\"></q:c></a>"},
+		{"declare", "<a xmlns:p=\"urn:p\"><p:b></p:b><q:c q:x=\"1\" xmlns:q=\"urn:x-undeclared-prefix:q\"></q:c></a>"},
+		{"strip", "<a xmlns:p=\"urn:p\"><p:b></p:b><c x=\"1\"></c></a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, _ := transformString(t, Config{Input: ConfigInput{UndeclaredPrefixes: tt.mode}, Output: compactOutput}, input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	_, err := tryTransformString(t, Config{Input: ConfigInput{UndeclaredPrefixes: "error"}}, input)
+	if err == nil || !strings.Contains(err.Error(), "'q'") {
+		t.Errorf("Transform error = %v, want an error for the prefix 'q'", err)
+	}
+	if _, err := tryTransformString(t, Config{Input: ConfigInput{UndeclaredPrefixes: "fix"}}, input); err == nil {
+		t.Error("unknown undeclared_prefixes mode was accepted")
+	}
+}
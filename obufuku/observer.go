@@ -0,0 +1,103 @@
+package obufuku
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuleEvent は、ルールが1回適用されたことを表すイベントです。
+type RuleEvent struct {
+	// Rule は、ルールファイルでのルールの位置です ("value_rules[2]" など)。
+	Rule string
+	// Path は、ルールが適用された要素のパスです ("/root/item" など)。祖先の要素はタグ名置換後の名前です。
+	Path string
+	// Input は、RunMerged で複数の入力をまとめている場合の、ルールを適用した入力の名前です (それ以外は空)。
+	Input string
+	// Offset は、ルールを適用したトークンの直後の入力のバイト位置、Line と Column はその行と桁です。
+	// passes のパスでは、前のパスが出力した中間の文書での位置になります。
+	Offset       int64
+	Line, Column int
+	// Before と After は、ルールの適用前後の内容です。
+	// タグ名置換ではタグ名、値置換とCDATA置換ではテキスト、挿入ルールでは挿入したXML (Before は空)、
+	// ラップルールではラップする要素の名前 (Before は空) です。
+	Before string
+	After  string
+	// Description は、ルールファイルでルールに設定された説明 (description) です (無ければ空)。
+	Description string
+}
+
+// Observer は、ルールが適用されるたびにイベントを受け取ります。
+// ドライラン・差分・監査・計測などに使えます。RuleApplied はトークンの処理中に同期的に呼ばれます。
+type Observer interface {
+	RuleApplied(event RuleEvent)
+}
+
+// ObserverFunc は、関数を Observer として使うための型です。
+type ObserverFunc func(event RuleEvent)
+
+// RuleApplied は Observer インターフェースを実装します。
+func (f ObserverFunc) RuleApplied(event RuleEvent) {
+	f(event)
+}
+
+// AddObserver は、このルールセットで作成するすべての Processor (Transform などを含む) に Observer を追加します。
+// passes のパスのルールの適用も通知し、その Rule には "passes[0].value_rules[1]" のようにパスの位置を付けます。
+// パスは並行して動くため、パスがある場合は o が複数のゴルーチンから同時に呼ばれることがあります。
+func (rs *RuleSet) AddObserver(o Observer) {
+	rs.observers = append(rs.observers, o)
+	for i, pass := range rs.passes {
+		prefix := fmt.Sprintf("passes[%d].", i)
+		pass.AddObserver(ObserverFunc(func(event RuleEvent) {
+			event.Rule = prefix + event.Rule
+			o.RuleApplied(event)
+		}))
+	}
+}
+
+// observerOptions は、ルールセットに追加された Observer を Processor に追加するオプションを返します。
+func (rs *RuleSet) observerOptions() []Option {
+	opts := make([]Option, 0, len(rs.observers))
+	for _, o := range rs.observers {
+		opts = append(opts, WithObserver(o))
+	}
+	return opts
+}
+
+// ruleApplied は、種類 kind の i 番目のルールが適用されたことを記録し、Observer に通知します。
+// element は、ルールが適用された要素がまだ要素のスタックに無い場合の要素の名前です
+// (スタックの末尾の要素に適用した場合は空にします)。
+func (p *Processor) ruleApplied(kind string, i int, element, before, after string) {
+	p.hit(kind, i)
+	if len(p.observers) == 0 {
+		return
+	}
+	rule := fmt.Sprintf("%s[%d]", kind, i)
+	event := RuleEvent{
+		Rule:        rule,
+		Path:        p.elementPath(element),
+		Input:       p.inputName,
+		Offset:      p.offset,
+		Line:        p.line,
+		Column:      p.column,
+		Before:      before,
+		After:       after,
+		Description: p.descriptions[rule],
+	}
+	for _, o := range p.observers {
+		o.RuleApplied(event)
+	}
+}
+
+// elementPath は、要素のスタックの末尾に element を加えたパスを返します。
+func (p *Processor) elementPath(element string) string {
+	var b strings.Builder
+	for _, el := range p.elementStack {
+		b.WriteString("/")
+		b.WriteString(el.Start.Name.Local)
+	}
+	if element != "" {
+		b.WriteString("/")
+		b.WriteString(element)
+	}
+	return b.String()
+}
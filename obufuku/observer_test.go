@@ -0,0 +1,102 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestObserver(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		input string
+		want  []string
+	}{
+		{
+			name:  "rename",
+			cfg:   Config{NameRules: []ConfigNameRule{{Old: "b", New: "c"}}},
+			input: `<a><b/><b/></a>`,
+			want:  []string{"name_rules[0] /a/c \"b\"->\"c\" @7", "name_rules[0] /a/c \"b\"->\"c\" @11"},
+		},
+		{
+			name:  "value",
+			cfg:   Config{ValueRules: []ConfigValueRule{{Target: "b", Type: "append", Params: params{"suffix": "!"}}}},
+			input: `<a><b>x</b></a>`,
+			want:  []string{"value_rules[0] /a/b \"x\"->\"x!\" @7"},
+		},
+		{
+			name: "inserts and wrap",
+			cfg: Config{
+				InsertRules:       []ConfigInsertRule{{Target: "b", Template: `<n/>`}},
+				InsertAfterRules:  []ConfigInsertRule{{Target: "b", Template: `<m/>`}},
+				PrependChildRules: []ConfigInsertRule{{Target: "b", Template: `<p/>`}},
+				WrapRules:         []ConfigWrapRule{{Target: "a", Wrapper: "w"}},
+			},
+			input: `<a><b>x</b></a>`,
+			want:  []string{"wrap_rules[0] /a \"\"->\"w\" @3", "insert_rules[0] /a/b \"\"->\"<n/>\" @6", "prepend_child_rules[0] /a/b \"\"->\"<p/>\" @6", "insert_after_rules[0] /a/b \"\"->\"<m/>\" @11"},
+		},
+		{
+			name:  "cdata",
+			cfg:   Config{RawTags: []string{"r"}, CdataRules: []ConfigCdataRule{{Old: "x", New: "y"}}},
+			input: `<a><r>x</r></a>`,
+			want:  []string{"cdata_rules[0] /a/r \"x\"->\"y\" @7"},
+		},
+		{
+			name:  "root",
+			cfg:   Config{RootRules: []ConfigRootRule{{Name: "doc", Attributes: map[string]string{"v": "2"}, Replace: true}}},
+			input: `<a k="1"><b/></a>`,
+			want:  []string{"root_rules[0] /doc \"a k=\\\"1\\\"\"->\"doc v=\\\"2\\\"\" @9"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := NewRuleSet(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewRuleSet: %v", err)
+			}
+			var got []string
+			observer := ObserverFunc(func(e RuleEvent) {
+				got = append(got, fmt.Sprintf("%s %s %q->%q @%d", e.Rule, e.Path, e.Before, e.After, e.Offset))
+			})
+			if _, err := rs.NewProcessor(strings.NewReader(tt.input), &bytes.Buffer{}, WithObserver(observer)).Run(context.Background()); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddObserver(t *testing.T) {
+	cfg := Config{
+		NameRules: []ConfigNameRule{{Old: "b", New: "c"}},
+		Passes:    []Config{{NameRules: []ConfigNameRule{{Old: "c", New: "d"}}}},
+	}
+	rs, err := NewRuleSet(cfg)
+	if err != nil {
+		t.Fatalf("NewRuleSet: %v", err)
+	}
+	var mu sync.Mutex
+	var got []string
+	rs.AddObserver(ObserverFunc(func(e RuleEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, fmt.Sprintf("%s %s %d:%d", e.Rule, e.Path, e.Line, e.Column))
+	}))
+	if _, err := rs.Transform(context.Background(), strings.NewReader("<a>\n  <b/>\n</a>"), &bytes.Buffer{}); err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	// パスの位置は、前のパスが出力した中間の文書 ("<c/>" ではなく "<c>") での位置になる
+	sort.Strings(got)
+	want := []string{"name_rules[0] /a/c 2:7", "passes[0].name_rules[0] /a/d 2:6"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,246 @@
+package obufuku
+
+// Option は、NewProcessor で作成するProcessorのルールや設定を指定します。
+type Option func(*Processor)
+
+// WithNameRules は、タグ名の置換ルールを追加します。
+func WithNameRules(rules ...NameReplaceRule) Option {
+	return func(p *Processor) {
+		p.nameRules = append(p.nameRules, rules...)
+	}
+}
+
+// WithInsertBeforeRules は、対象要素の直前に断片を挿入するルールを追加します。
+func WithInsertBeforeRules(rules ...InsertBeforeRule) Option {
+	return func(p *Processor) {
+		p.insertRules = append(p.insertRules, rules...)
+	}
+}
+
+// WithInsertAfterRules は、対象要素の直後に断片を挿入するルールを追加します。
+func WithInsertAfterRules(rules ...InsertBeforeRule) Option {
+	return func(p *Processor) {
+		p.insertAfterRules = append(p.insertAfterRules, rules...)
+	}
+}
+
+// WithPrependChildRules は、対象要素の最初の子として断片を挿入するルールを追加します。
+func WithPrependChildRules(rules ...InsertBeforeRule) Option {
+	return func(p *Processor) {
+		p.prependChildRules = append(p.prependChildRules, rules...)
+	}
+}
+
+// WithValueRules は、要素の値の置換ルールを追加します。
+func WithValueRules(rules ...ValueReplaceRule) Option {
+	return func(p *Processor) {
+		p.valueRules = append(p.valueRules, rules...)
+	}
+}
+
+// WithWrapRules は、要素の子をラッパー要素で囲むルールを追加します。
+func WithWrapRules(rules ...WrapRule) Option {
+	return func(p *Processor) {
+		for _, rule := range rules {
+			p.wrapRuleMap[rule.TargetTag] = wrapRuleRef{wrapper: rule.WrapperTag, index: p.wrapRuleCount}
+			p.wrapRuleCount++
+		}
+	}
+}
+
+// WithCdataRules は、CDATAとして出力するテキストの置換ルールを追加します。
+func WithCdataRules(rules ...CdataRule) Option {
+	return func(p *Processor) {
+		p.cdataRules = append(p.cdataRules, rules...)
+	}
+}
+
+// WithCaptureRules は、要素のテキストを変数に取り込むルールを追加します。
+func WithCaptureRules(rules ...CaptureRule) Option {
+	return func(p *Processor) {
+		p.captureRules = append(p.captureRules, rules...)
+	}
+}
+
+// WithSummaryRules は、要素を集計してルート要素の子の末尾に挿入するルールを追加します。
+func WithSummaryRules(rules ...SummaryRule) Option {
+	return func(p *Processor) {
+		p.summaryRules = append(p.summaryRules, rules...)
+	}
+}
+
+// WithDeleteRules は、要素を条件に応じて削除するルールを追加します。
+func WithDeleteRules(rules ...DeleteRule) Option {
+	return func(p *Processor) {
+		p.deleteRules = append(p.deleteRules, rules...)
+	}
+}
+
+// WithDedupeRules は、重複する子要素を削除するルールを追加します。
+func WithDedupeRules(rules ...DedupeRule) Option {
+	return func(p *Processor) {
+		p.dedupeRules = append(p.dedupeRules, rules...)
+	}
+}
+
+// WithReorderRules は、子要素を並べ替えるルールを追加します。
+func WithReorderRules(rules ...ReorderRule) Option {
+	return func(p *Processor) {
+		p.reorderRules = append(p.reorderRules, rules...)
+	}
+}
+
+// WithAssertRules は、要素の子要素や属性を検査するルールを追加します。
+func WithAssertRules(rules ...AssertRule) Option {
+	return func(p *Processor) {
+		p.assertRules = append(p.assertRules, rules...)
+	}
+}
+
+// WithCaseRules は、タグ名の大文字・小文字を統一するルールを追加します。
+func WithCaseRules(rules ...CaseRule) Option {
+	return func(p *Processor) {
+		p.caseRules = append(p.caseRules, rules...)
+	}
+}
+
+// WithPrefixRules は、名前空間の接頭辞を書き換えるルールを追加します。
+func WithPrefixRules(rules ...PrefixRule) Option {
+	return func(p *Processor) {
+		p.prefixRules = append(p.prefixRules, rules...)
+	}
+}
+
+// WithNamespaceRules は、名前空間URIを書き換えるルールを追加します。
+func WithNamespaceRules(rules ...NamespaceRule) Option {
+	return func(p *Processor) {
+		p.namespaceRules = append(p.namespaceRules, rules...)
+	}
+}
+
+// WithDateRules は、日付を1つの形式にそろえるルールを追加します。
+func WithDateRules(rules ...DateRule) Option {
+	return func(p *Processor) {
+		p.dateRules = append(p.dateRules, rules...)
+	}
+}
+
+// WithUnicodeRules は、要素のテキストと属性値を Unicode の正規化形式にするルールを追加します。
+func WithUnicodeRules(rules ...UnicodeRule) Option {
+	return func(p *Processor) {
+		p.unicodeRules = append(p.unicodeRules, rules...)
+	}
+}
+
+// WithRootRules は、ルート要素を置き換えるルールを追加します。
+func WithRootRules(rules ...RootRule) Option {
+	return func(p *Processor) {
+		p.rootRules = append(p.rootRules, rules...)
+	}
+}
+
+// WithSchemaLocationRules は、スキーマの場所を設定するルールを追加します。
+func WithSchemaLocationRules(rules ...SchemaLocationRule) Option {
+	return func(p *Processor) {
+		p.schemaLocationRules = append(p.schemaLocationRules, rules...)
+	}
+}
+
+// WithNilRules は、空の要素と xsi:nil="true" の要素を相互に変換するルールを追加します。
+func WithNilRules(rules ...NilRule) Option {
+	return func(p *Processor) {
+		p.nilRules = append(p.nilRules, rules...)
+	}
+}
+
+// WithDefaultNamespaceRules は、ルート要素の既定の名前空間を設定するルールを追加します。
+func WithDefaultNamespaceRules(rules ...DefaultNamespaceRule) Option {
+	return func(p *Processor) {
+		p.defaultNamespaceRules = append(p.defaultNamespaceRules, rules...)
+	}
+}
+
+// WithAttrCleanupRules は、属性値を囲む余分なダブルクォートを削除するルールを追加します。
+func WithAttrCleanupRules(rules ...AttrCleanupRule) Option {
+	return func(p *Processor) {
+		p.attrCleanupRules = append(p.attrCleanupRules, rules...)
+	}
+}
+
+// WithAttrDeleteRules は、名前がパターンに一致する属性を削除するルールを追加します。
+func WithAttrDeleteRules(rules ...AttrDeleteRule) Option {
+	return func(p *Processor) {
+		p.attrDeleteRules = append(p.attrDeleteRules, rules...)
+	}
+}
+
+// WithRawTags は、テキストをCDATAセクションとして出力する要素名を追加します。
+func WithRawTags(tags ...string) Option {
+	return func(p *Processor) {
+		for _, tag := range tags {
+			p.rawTagMap[tag] = true
+		}
+	}
+}
+
+// WithInputOptions は、入力の読み込みに関する設定を指定します。
+// 指定しない場合は、厳密な構文検査と既定の上限で読み込みます。
+func WithInputOptions(input InputOptions) Option {
+	return func(p *Processor) {
+		p.input = input
+	}
+}
+
+// WithOutputOptions は、出力形式に関する設定を指定します。
+func WithOutputOptions(output OutputOptions) Option {
+	return func(p *Processor) {
+		p.output = output
+	}
+}
+
+// WithIndent は、インデント出力の各行の先頭に付ける prefix と、深さごとの indent を指定します。
+// 既定は prefix なし、空白2つのインデントです。コンパクト出力・最小変更モード・正規化出力では使われません。
+func WithIndent(prefix, indent string) Option {
+	return func(p *Processor) {
+		p.indentPrefix = prefix
+		p.indent = indent
+	}
+}
+
+// WithHooks は、トークンごとに呼び出す独自の処理を追加します。
+// 複数回指定した場合は、指定した順に呼び出されます。
+func WithHooks(hooks Hooks) Option {
+	return func(p *Processor) {
+		p.hooks = append(p.hooks, hooks)
+	}
+}
+
+// WithObserver は、ルールが適用されるたびにイベントを受け取る Observer を追加します。
+func WithObserver(o Observer) Option {
+	return func(p *Processor) {
+		p.observers = append(p.observers, o)
+	}
+}
+
+// WithRuleDescriptions は、ルールの説明を設定します。キーはルールファイルでの位置 ("value_rules[2]" など) で、
+// 説明は RuleEvent と TransformResult に含まれます。
+func WithRuleDescriptions(descriptions map[string]string) Option {
+	return func(p *Processor) {
+		p.descriptions = descriptions
+	}
+}
+
+// WithFilterOrder は、組み込みのフィルターを適用する順序を指定します (名前は FilterNames を参照)。
+// 指定しなかったフィルターは既定の順序で後ろに加えられ、未知の名前は無視されます。
+func WithFilterOrder(names ...string) Option {
+	return func(p *Processor) {
+		p.filterOrder = names
+	}
+}
+
+// WithFilters は、組み込みのフィルターの後に適用する独自のフィルターを追加します。
+func WithFilters(filters ...TokenFilter) Option {
+	return func(p *Processor) {
+		p.customFilters = append(p.customFilters, filters...)
+	}
+}
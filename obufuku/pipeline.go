@@ -0,0 +1,95 @@
+package obufuku
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// runPasses は、最初のパス first の出力を後続のパスに順に流し込み、最後のパスの出力を w に書き込みます。
+// first は、渡された書き込み先とオプション (中間の出力設定) で最初のパスを実行する関数です。
+// 各パスは別のゴルーチンで並行して動き、パスの間はメモリ上のパイプでつながります。
+// パスが無い場合は、first を w に対して実行するだけです。
+func (rs *RuleSet) runPasses(ctx context.Context, w io.Writer, first func(w io.Writer, opts ...Option) (TransformResult, error)) (TransformResult, error) {
+	if len(rs.passes) == 0 {
+		return first(w)
+	}
+
+	// 中間の文書はUTF-8・LF改行のまま受け渡し、XML宣言は書き換えない
+	intermediateOutput := rs.Output
+	intermediateOutput.Encoding = ""
+	intermediateOutput.Declaration = DeclarationOptions{}
+	intermediateOutput.Flush = FlushOptions{}
+	intermediateInput, _ := buildInputOptions(ConfigInput{Secure: rs.Input.Secure}, rs.Output.Minimal)
+	intermediateInput.Limits = rs.Input.Limits
+
+	stages := len(rs.passes) + 1
+	results := make([]TransformResult, stages)
+	errs := make([]error, stages)
+	readers := make([]*io.PipeReader, stages)
+	writers := make([]*io.PipeWriter, stages)
+	for i := 0; i < stages-1; i++ {
+		readers[i+1], writers[i] = io.Pipe()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(stages - 1)
+	go func() {
+		defer wg.Done()
+		results[0], errs[0] = first(writers[0], WithOutputOptions(intermediateOutput))
+		writers[0].CloseWithError(errs[0])
+	}()
+	for i := 1; i < stages; i++ {
+		pass := rs.passes[i-1]
+		if i == stages-1 {
+			proc := pass.NewProcessor(readers[i], w, WithInputOptions(intermediateInput), WithOutputOptions(rs.Output))
+			results[i], errs[i] = proc.Run(ctx)
+			// 前段が書き込み待ちで止まらないよう、残りの入力を読み捨てさせる
+			readers[i].CloseWithError(errPassAborted)
+			break
+		}
+		go func(i int) {
+			defer wg.Done()
+			proc := pass.NewProcessor(readers[i], writers[i], WithInputOptions(intermediateInput), WithOutputOptions(intermediateOutput))
+			results[i], errs[i] = proc.Run(ctx)
+			readers[i].CloseWithError(errPassAborted)
+			writers[i].CloseWithError(errs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	result := results[0]
+	for i := 1; i < stages; i++ {
+		for name, n := range results[i].RuleHits {
+			if result.RuleHits == nil {
+				result.RuleHits = make(map[string]int)
+			}
+			result.RuleHits[fmt.Sprintf("passes[%d].%s", i-1, name)] = n
+		}
+		for name, description := range results[i].RuleDescriptions {
+			if result.RuleDescriptions == nil {
+				result.RuleDescriptions = make(map[string]string)
+			}
+			result.RuleDescriptions[fmt.Sprintf("passes[%d].%s", i-1, name)] = description
+		}
+		for _, warning := range results[i].Warnings {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("pass %d: %s", i, warning))
+		}
+	}
+	result.BytesWritten = results[stages-1].BytesWritten
+	// 前段のエラーが後段に伝わるため、最初のパスのエラーを返す
+	for i, err := range errs {
+		if err != nil && !errors.Is(err, errPassAborted) {
+			if i == 0 {
+				return result, err
+			}
+			return result, fmt.Errorf("pass %d: %w", i, err)
+		}
+	}
+	return result, nil
+}
+
+// errPassAborted は、後段のパスが終了したため前段のパスの書き込みを打ち切ったことを表します。
+var errPassAborted = errors.New("subsequent pass stopped reading")
@@ -0,0 +1,86 @@
+package obufuku
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPasses(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		input string
+		want  string
+	}{
+		{
+			name:  "passes run in order",
+			cfg:   Config{NameRules: []ConfigNameRule{{Old: "b", New: "c"}}, Passes: []Config{{NameRules: []ConfigNameRule{{Old: "c", New: "d"}}}}},
+			input: `<a><b/></a>`,
+			want:  "<a><d></d></a>",
+		},
+		{
+			name: "later passes see inserted elements",
+			cfg: Config{
+				InsertRules: []ConfigInsertRule{{Target: "b", Template: `<n>x</n>`}},
+				Passes:      []Config{{ValueRules: []ConfigValueRule{{Target: "n", Type: "append", Params: params{"suffix": "!"}}}}},
+			},
+			input: `<a><b/></a>`,
+			want:  "<a><n>x!</n><b></b></a>",
+		},
+		{
+			name: "passes use counters of the top level and their own",
+			cfg: Config{
+				Counters: map[string]ConfigCounter{"c": {}},
+				Passes: []Config{
+					{InsertAfterRules: []ConfigInsertRule{{Target: "b", Template: `<m>%d</m>`, Counter: "c"}}},
+					{PrependChildRules: []ConfigInsertRule{{Target: "b", Template: `<k>%d</k>`, Counter: "d"}}, Counters: map[string]ConfigCounter{"d": {Start: 100}}},
+				},
+			},
+			input: `<a><b/><b/></a>`,
+			want:  "<a><b><k>101</k></b><m>1</m><b><k>102</k></b><m>2</m></a>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Output = compactOutput
+			got, _ := transformString(t, tt.cfg, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPassesResult(t *testing.T) {
+	cfg := Config{
+		NameRules: []ConfigNameRule{{Old: "b", New: "c"}},
+		Passes:    []Config{{NameRules: []ConfigNameRule{{Old: "c", New: "d"}}}},
+		Output:    compactOutput,
+	}
+	_, result := transformString(t, cfg, `<a><b/><b/></a>`)
+	want := map[string]int{"name_rules[0]": 2, "passes[0].name_rules[0]": 2}
+	if !reflect.DeepEqual(result.RuleHits, want) {
+		t.Errorf("RuleHits = %v, want %v", result.RuleHits, want)
+	}
+}
+
+func TestPassesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"nested passes", Config{Passes: []Config{{Passes: []Config{{}}}}}},
+		{"output in a pass", Config{Passes: []Config{{Output: compactOutput}}}},
+		{"counter defined twice", Config{Counters: map[string]ConfigCounter{"c": {}}, Passes: []Config{{Counters: map[string]ConfigCounter{"c": {}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRuleSet(tt.cfg)
+			var configErr *RuleConfigError
+			if !errors.As(err, &configErr) || configErr.Rule != "passes[0]" {
+				t.Errorf("NewRuleSet error = %v, want a RuleConfigError for passes[0]", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package obufuku
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	mathrand "math/rand/v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// placeholderPattern は、テンプレートや値置換ルールのプレースホルダー ({{uuid}} など) です。
+// 名前の後に、空白で区切った引数 (空白を含む場合はダブルクォートで囲む) を続けられます。
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)((?:\s+(?:"(?:[^"\\]|\\.)*"|[^\s"}]+))*)\s*\}\}`)
+
+// placeholderFactory は、プレースホルダーの引数から、値を生成する関数を作成する関数です。
+type placeholderFactory func(args []string) (func() string, error)
+
+// placeholderFuncs は、どのテンプレートでも使えるプレースホルダーの名前ごとの placeholderFactory です。
+var placeholderFuncs = map[string]placeholderFactory{
+	"uuid":        newUUIDPlaceholder,
+	"now":         newNowPlaceholder,
+	"rand_int":    newRandIntPlaceholder,
+	"rand_string": newRandStringPlaceholder,
+}
+
+// placeholders は、テンプレートに含まれるプレースホルダーと、それぞれの値を生成する関数です。
+// 関数は、capture_rules で取り込んだ変数の値を受け取ります。
+// 同じプレースホルダーが複数回現れる場合も、それぞれ別の値を生成します。
+type placeholders map[string]func(vars map[string]string) string
+
+// parsePlaceholders は、template に含まれるプレースホルダーを解析します。プレースホルダーが無ければ nil を返します。
+// extra は、ルールによって使えるプレースホルダー (CSVの列の値など) です。
+func parsePlaceholders(template string, extra map[string]placeholderFactory) (placeholders, error) {
+	var result placeholders
+	for _, m := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if _, ok := result[m[0]]; ok {
+			continue
+		}
+		args, err := placeholderArgs(m[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid placeholder '%s': %w", m[0], err)
+		}
+		f, err := newPlaceholder(m[1], args, extra)
+		if err != nil {
+			return nil, fmt.Errorf("invalid placeholder '%s': %w", m[0], err)
+		}
+		if f == nil {
+			return nil, fmt.Errorf("unknown placeholder '%s'", m[0])
+		}
+		if result == nil {
+			result = make(placeholders)
+		}
+		result[m[0]] = f
+	}
+	return result, nil
+}
+
+// newPlaceholder は、名前 name と引数 args のプレースホルダーの値を生成する関数を作成します。
+// 未知の名前の場合は nil を返します。
+func newPlaceholder(name string, args []string, extra map[string]placeholderFactory) (func(vars map[string]string) string, error) {
+	// {{var "名前"}} は、生成する値ではなく変換中に取り込んだ変数の値を参照する
+	if name == "var" {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("var takes 1 argument (variable name)")
+		}
+		variable := args[0]
+		return func(vars map[string]string) string { return vars[variable] }, nil
+	}
+	newFunc, ok := extra[name]
+	if !ok {
+		newFunc, ok = placeholderFuncs[name]
+	}
+	if !ok {
+		return nil, nil
+	}
+	f, err := newFunc(args)
+	if err != nil {
+		return nil, err
+	}
+	return func(map[string]string) string { return f() }, nil
+}
+
+// placeholderArgs は、プレースホルダーの引数を空白で区切り、ダブルクォートで囲まれたものは引用符を外します。
+func placeholderArgs(s string) ([]string, error) {
+	var args []string
+	for _, field := range placeholderArgPattern.FindAllString(s, -1) {
+		if strings.HasPrefix(field, `"`) {
+			unquoted, err := strconv.Unquote(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted argument %s", field)
+			}
+			field = unquoted
+		}
+		args = append(args, field)
+	}
+	return args, nil
+}
+
+// placeholderArgPattern は、プレースホルダーの引数の1つです。
+var placeholderArgPattern = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|[^\s"}]+`)
+
+// variablePattern は、capture_rules で取り込む変数の名前 (スクリプトから vars.名前 で参照できるもの) です。
+var variablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// expand は、XMLの断片 text のプレースホルダーをそれぞれ生成した値 (XMLとしてエスケープしたもの) で置き換えます。
+// vars は、{{var}} で参照する変数です (取り込まれていない変数は空になります)。
+func (ps placeholders) expand(text string, vars map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(m string) string {
+		f, ok := ps[m]
+		if !ok {
+			return m
+		}
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(f(vars)))
+		return b.String()
+	})
+}
+
+// expandText は、テキスト text のプレースホルダーをそれぞれ生成した値で置き換えます (出力時にエスケープされる値置換ルール用)。
+func (ps placeholders) expandText(text string, vars map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(m string) string {
+		if f, ok := ps[m]; ok {
+			return f(vars)
+		}
+		return m
+	})
+}
+
+// newUUIDPlaceholder は、{{uuid}} の値として、適用するたびに新しいランダムな UUID (バージョン4) を生成する関数を作成します。
+func newUUIDPlaceholder(args []string) (func() string, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("uuid takes no arguments")
+	}
+	return newUUID, nil
+}
+
+// newUUID は、ランダムな UUID (バージョン4) を生成します。
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// newNowPlaceholder は、{{now "レイアウト" "タイムゾーン"}} の値として、適用した時刻を生成する関数を作成します。
+// レイアウトは Go の time パッケージの書式 (省略時は RFC 3339)、タイムゾーンは "Asia/Tokyo" などの
+// IANA タイムゾーン名または "UTC" (省略時はローカルのタイムゾーン) です。
+func newNowPlaceholder(args []string) (func() string, error) {
+	if len(args) > 2 {
+		return nil, fmt.Errorf("now takes at most 2 arguments (layout and time zone)")
+	}
+	layout := time.RFC3339
+	if len(args) > 0 {
+		layout = args[0]
+	}
+	loc := time.Local
+	if len(args) > 1 {
+		var err error
+		if loc, err = time.LoadLocation(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	return func() string {
+		return time.Now().In(loc).Format(layout)
+	}, nil
+}
+
+// newRandIntPlaceholder は、{{rand_int 最小値 最大値}} の値として、範囲内 (両端を含む) のランダムな整数を生成する関数を作成します。
+// テストデータの生成用で、暗号論的に安全な乱数ではありません。
+func newRandIntPlaceholder(args []string) (func() string, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("rand_int takes 2 arguments (min and max)")
+	}
+	low, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum '%s' for rand_int", args[0])
+	}
+	high, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maximum '%s' for rand_int", args[1])
+	}
+	if low > high {
+		return nil, fmt.Errorf("minimum %d is greater than maximum %d for rand_int", low, high)
+	}
+	span := uint64(high - low)
+	return func() string {
+		if span == ^uint64(0) {
+			return strconv.FormatInt(int64(mathrand.Uint64()), 10)
+		}
+		return strconv.FormatInt(low+int64(mathrand.Uint64N(span+1)), 10)
+	}, nil
+}
+
+// randStringLetters は、{{rand_string}} で使う文字です。
+const randStringLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// newRandStringPlaceholder は、{{rand_string 長さ}} の値として、英数字のランダムな文字列を生成する関数を作成します。
+// テストデータの生成用で、暗号論的に安全な乱数ではありません。
+func newRandStringPlaceholder(args []string) (func() string, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("rand_string takes 1 argument (length)")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid length '%s' for rand_string", args[0])
+	}
+	return func() string {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = randStringLetters[mathrand.IntN(len(randStringLetters))]
+		}
+		return string(b)
+	}, nil
+}
@@ -0,0 +1,122 @@
+package obufuku
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+// uuidPattern は、バージョン4の UUID です。
+const uuidPattern = `[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}`
+
+func TestPlaceholders(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		counter  string
+		want     string
+	}{
+		{"uuid", `<id>{{uuid}}</id>`, "", `<a><id>` + uuidPattern + `</id></a>`},
+		{"uuid in an attribute", `<id v="{{ uuid }}"/>`, "", `<a><id v="` + uuidPattern + `"></id></a>`},
+		{"uuid with counter", `<id n="%d">{{uuid}}</id>`, "n", `<a><id n="1">` + uuidPattern + `</id></a>`},
+		{"now", `<t>{{now}}</t>`, "", `<a><t>\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(Z|[+-]\d\d:\d\d)</t></a>`},
+		{"rand_int", `<n>{{rand_int 5 5}}</n><m>{{rand_int -2 -1}}</m>`, "", `<a><n>5</n><m>-[12]</m></a>`},
+		{"rand_string", `<s>{{rand_string 12}}</s>`, "", `<a><s>[A-Za-z0-9]{12}</s></a>`},
+		{"now with layout and zone", `<t>{{now "2006/01/02 15:04 MST" "UTC"}}</t>`, "", `<a><t>\d{4}/\d\d/\d\d \d\d:\d\d UTC</t></a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				PrependChildRules: []ConfigInsertRule{{Target: "a", Template: tt.template, Counter: tt.counter}},
+				Counters:          map[string]ConfigCounter{"n": {}},
+				Output:            compactOutput,
+			}
+			got, _ := transformString(t, cfg, "<a/>")
+			if !regexp.MustCompile(`^` + tt.want + `$`).MatchString(got) {
+				t.Errorf("output = %q, want it to match %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaceholdersDiffer(t *testing.T) {
+	// 同じプレースホルダーでも、現れるたび・適用するたびに別の値を生成する
+	cfg := Config{
+		PrependChildRules: []ConfigInsertRule{{Target: "a", Template: `<x>{{uuid}}</x><y>{{uuid}}</y>`}},
+		Output:            compactOutput,
+	}
+	got, _ := transformString(t, cfg, "<r><a/><a/></r>")
+	ids := regexp.MustCompile(uuidPattern).FindAllString(got, -1)
+	if len(ids) != 4 {
+		t.Fatalf("output = %q, want 4 UUIDs", got)
+	}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			t.Errorf("UUID %s is generated more than once", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestPlaceholderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"unknown placeholder", `<x>{{serial}}</x>`},
+		{"uuid with arguments", `<x>{{uuid 4}}</x>`},
+		{"now with unknown time zone", `<x>{{now "2006" "Nowhere/City"}}</x>`},
+		{"now with too many arguments", `<x>{{now a b c}}</x>`},
+		{"rand_int without arguments", `<x>{{rand_int}}</x>`},
+		{"rand_int not a number", `<x>{{rand_int 1 ten}}</x>`},
+		{"rand_int minimum above maximum", `<x>{{rand_int 3 2}}</x>`},
+		{"rand_string zero length", `<x>{{rand_string 0}}</x>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRuleSet(Config{InsertRules: []ConfigInsertRule{{Target: "a", Template: tt.template}}})
+			var configErr *RuleConfigError
+			if !errors.As(err, &configErr) || configErr.Rule != "insert_rules[0]" {
+				t.Errorf("NewRuleSet error = %v, want a RuleConfigError for insert_rules[0]", err)
+			}
+		})
+	}
+}
+
+func TestRandIntRange(t *testing.T) {
+	f, err := newRandIntPlaceholder([]string{"1", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		seen[f()] = true
+	}
+	if len(seen) != 3 || !seen["1"] || !seen["2"] || !seen["3"] {
+		t.Errorf("rand_int 1 3 generated %v, want exactly 1, 2 and 3", seen)
+	}
+}
+
+func TestValuePlaceholders(t *testing.T) {
+	// 値置換ルールでは、生成した値はテキストとしてエスケープされる
+	cfg := Config{
+		ValueRules: []ConfigValueRule{
+			{Target: "v", Type: "prepend", Params: params{"prefix": `{{now "2006" "UTC"}}<`}},
+			{Target: "w", Type: "append", Params: params{"suffix": `@{{now "15:04" "UTC"}}`}},
+		},
+		Output: compactOutput,
+	}
+	got, _ := transformString(t, cfg, "<a><v>x</v><w>y</w></a>")
+	if want := `^<a><v>\d{4}&lt;x</v><w>y@\d\d:\d\d</w></a>$`; !regexp.MustCompile(want).MatchString(got) {
+		t.Errorf("output = %q, want it to match %q", got, want)
+	}
+}
+
+func TestValuePlaceholderErrors(t *testing.T) {
+	_, err := NewRuleSet(Config{ValueRules: []ConfigValueRule{{Target: "v", Type: "append", Params: params{"suffix": "{{unknown}}"}}}})
+	var configErr *RuleConfigError
+	if !errors.As(err, &configErr) || configErr.Rule != "value_rules[0]" {
+		t.Errorf("NewRuleSet error = %v, want a RuleConfigError for value_rules[0]", err)
+	}
+}
@@ -0,0 +1,1001 @@
+// Package obufuku は、ルールに基づいてXMLをストリーミングで変換するエンジンです。
+// タグ名の置換、要素の挿入・ラップ、値の置換などのルールを1回の読み込みで適用し、
+// インデントやエンコーディングなどの出力形式を整えて書き出します。
+package obufuku
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// OutputOptions は、出力形式に関する実行時の設定です。
+type OutputOptions struct {
+	// Compact が true の場合、インデントや改行を行わず1行で出力します。
+	Compact bool
+	// Encoding は、出力エンコーディングの正式名 (Shift_JIS など) です。
+	// 空の場合はUTF-8のまま出力し、XML宣言も書き換えません。
+	// エンコーディングで表せない文字は、テキストと属性値では数値文字参照で出力し、名前などではエラーにします。
+	Encoding string
+	// Declaration は、XML宣言の追加・削除・書き換えの設定です。
+	Declaration DeclarationOptions
+	// SelfClosing は、空要素を <tag/> の形式で出力する条件です。
+	SelfClosing SelfClosingOptions
+	// Minimal が true の場合、ルールの影響を受けないトークンは入力のバイト列をそのまま出力し、
+	// ルールで変更された部分だけを再シリアライズします (インデントは付け直しません)。
+	Minimal bool
+	// Comments は、コメントと処理命令の配置に関する設定です。
+	Comments CommentOptions
+	// AttrWrapWidth が正の場合、この桁数を超える開始タグの属性を1行に1つずつ折り返します。
+	AttrWrapWidth int
+	// Canonical が true の場合、Exclusive XML Canonicalization の形式で出力します。
+	Canonical bool
+	// Flush は、出力を途中で書き出す間隔の設定です。
+	Flush FlushOptions
+	// Newline は、RuleSet.NewWriter が揃える出力の改行コード ("\n" または "\r\n") です。
+	// 空の場合は改行コードを変換しません。
+	Newline string
+	// SortAttributes が true の場合、開始タグの属性を名前の昇順に並べます。
+	// AttributeOrder に列挙した名前の属性は、それより前にその順で並べます (SortAttributes が false でも並べます)。
+	SortAttributes bool
+	AttributeOrder []string
+	// CharRefs は、ASCII以外の文字を数値文字参照で出力する条件です。
+	CharRefs CharRefOptions
+}
+
+// CharRefOptions は、ASCII以外の文字を数値文字参照 (&#x3042; など) で出力する条件です。
+type CharRefOptions struct {
+	// All が true の場合、文書全体のテキストと属性値を数値文字参照にします。
+	All bool
+	// Tags に含まれるタグ名 (置換後の名前) の要素は、その属性値と子孫を含むテキストを数値文字参照にします。
+	Tags map[string]bool
+}
+
+// FlushOptions は、処理の途中で出力をファイルまで書き出す間隔です。
+//
+// 入力はトークン単位で読み込み、出力は固定サイズのバッファを通して書き出すため、
+// メモリ使用量は入力ファイルの大きさではなく、要素の深さと最大のテキストノードの大きさで決まります。
+// raw_tags の要素の中身は分割して処理するため、その大きさには依存しません。
+// ただし gzip 圧縮などの途中段がデータを保持するため、指定が無い場合は
+// 出力ファイルの内容が処理の進行よりも遅れて書き込まれます。
+// 間隔を指定すると、その都度すべての段を書き出し、途中までの出力をファイルで確認できるようにします。
+// 書き出しは開始タグを確定させないため、指定の有無で出力内容は変わりません。
+type FlushOptions struct {
+	// Tokens が正の場合、入力トークンをこの数だけ処理するごとに書き出します。
+	Tokens int
+	// Bytes が正の場合、出力がこのバイト数だけ増えるごとに書き出します。
+	Bytes int64
+}
+
+// CommentOptions は、インデント出力時のコメントと処理命令 (XML宣言以外) の配置設定です。
+type CommentOptions struct {
+	// OwnLine が true の場合、コメントと処理命令を直後の要素と同じ深さの独立した行に出力します。
+	// false の場合は従来どおり直前のトークンに続けて出力します。
+	OwnLine bool
+	// PreserveBlankLines が true の場合、入力でコメントや処理命令の前後にあった空行を維持します。
+	PreserveBlankLines bool
+}
+
+// SelfClosingOptions は、空要素を自己終了タグ (<tag/>) で出力する条件です。
+type SelfClosingOptions struct {
+	// All が true の場合、すべての空要素を自己終了タグにします。
+	All bool
+	// Preserve が true の場合、入力で自己終了タグだった要素を自己終了タグのまま出力します。
+	Preserve bool
+	// Tags に含まれるタグ名 (置換後の名前) の空要素は、常に自己終了タグにします。
+	Tags map[string]bool
+	// Expand に含まれるタグ名 (置換後の名前) の空要素は、常に開始タグと終了タグ (<tag></tag>) にします。
+	Expand map[string]bool
+	// Remove に含まれるタグ名 (置換後の名前) の空要素は、出力しません。
+	Remove map[string]bool
+}
+
+// XML宣言の制御モード
+const (
+	declarationKeep   = "keep"
+	declarationAdd    = "add"
+	declarationRemove = "remove"
+)
+
+// DeclarationOptions は、出力する <?xml ... ?> 宣言の制御設定です。
+type DeclarationOptions struct {
+	// Mode は "keep" (入力に従う、既定値)、"add" (無ければ追加)、"remove" (常に削除) のいずれかです。
+	Mode string
+	// Version, Encoding, Standalone は、空でなければ宣言の各疑似属性をこの値で上書きします。
+	Version    string
+	Encoding   string
+	Standalone string
+}
+
+// InputOptions は、入力の読み込みに関する実行時の設定です。
+type InputOptions struct {
+	// Encoding は、入力エンコーディングの設定です。空の場合はXML宣言に従い、
+	// "auto" または具体的な名前の場合は入力があらかじめUTF-8に変換されているものとして扱います。
+	Encoding string
+	// HTML が true の場合、HTMLのトークナイザーで入力を読み込み、XMLのトークンにします (htmlTokenReader)。
+	// XML宣言のエンコーディングには従わないため、UTF-8以外の入力は Encoding を指定して変換しておきます。
+	HTML bool
+	// Decoder は、XMLの構文をどこまで厳密に検査するかの設定です (HTML互換の読み込みでは使いません)。
+	Decoder DecoderOptions
+	// Secure が true の場合、外部DTDの参照 (SYSTEM/PUBLIC 識別子) や外部実体を宣言する
+	// DOCTYPE宣言を含む入力を拒否します (XXE 対策)。
+	Secure bool
+	// Limits は、悪意のある入力からの保護のための上限です。
+	Limits InputLimits
+	// UndeclaredPrefixes は、宣言されていない名前空間接頭辞の扱い (undeclaredKeep など) です。
+	UndeclaredPrefixes string
+	// ControlChars は、XML 1.0で使用できない制御文字の扱い (controlCharsStrip など) です。
+	// ControlCharReplacement は、controlCharsReplace の場合に置き換える文字列です。
+	ControlChars           string
+	ControlCharReplacement string
+}
+
+// DecoderOptions は、xml.Decoder の構文検査の設定です。
+type DecoderOptions struct {
+	// Strict が false の場合、引用符の無い属性値や未定義の実体参照などを許容します。
+	Strict bool
+	// AutoClose は、終了タグを待たずに閉じたものとして扱う要素名です (Strict が false の場合のみ)。
+	AutoClose []string
+	// Entity は、定義済み以外の実体参照の名前と置換テキストです。
+	Entity map[string]string
+}
+
+// 入力の上限の既定値です。
+const (
+	defaultMaxEntityExpansions = 10000
+	defaultMaxExpandedSize     = 1 << 20
+	defaultMaxDepth            = 1000
+)
+
+// InputLimits は、悪意のある入力からの保護のための上限です。0 以下の値は上限なしを表します。
+type InputLimits struct {
+	// MaxEntityExpansions は、1つの実体を展開するために辿る実体参照の数の上限です。
+	MaxEntityExpansions int64
+	// MaxExpandedSize は、1つの実体を展開した置換テキストのバイト数の上限です。
+	MaxExpandedSize int64
+	// MaxDepth は、要素の入れ子の深さの上限です。
+	MaxDepth int
+}
+
+// wrapRuleRef は、ラップルールのラップする要素名と、ルールの位置 (適用回数の記録用) です。
+type wrapRuleRef struct {
+	wrapper string
+	index   int
+}
+
+// Processor は、XML処理のロジックと状態を保持します。
+type Processor struct {
+	decoder *inputDecoder
+	encoder *tokenEncoder
+	writer  io.Writer
+	// reader は、デコーダが読み込む、バッファ付きの入力です。
+	reader *bufio.Reader
+	// splitter は、raw_tags の要素の長いテキストを分割して読み込ませる、reader とデコーダの間の段です (raw_tags が無ければ nil)。
+	splitter *textSplitter
+
+	// 処理中のトークンの直後の入力オフセットと、入力での行・桁
+	offset       int64
+	line, column int
+	// inputName は、RunMerged で処理中の入力の名前です (Run では空)。
+	inputName string
+
+	nameRules             []NameReplaceRule
+	insertRules           []InsertBeforeRule
+	insertAfterRules      []InsertBeforeRule
+	prependChildRules     []InsertBeforeRule
+	valueRules            []ValueReplaceRule
+	dateRules             []DateRule
+	unicodeRules          []UnicodeRule
+	wrapRuleMap           map[string]wrapRuleRef
+	wrapRuleCount         int
+	cdataRules            []CdataRule
+	captureRules          []CaptureRule
+	summaryRules          []SummaryRule
+	deleteRules           []DeleteRule
+	dedupeRules           []DedupeRule
+	reorderRules          []ReorderRule
+	assertRules           []AssertRule
+	caseRules             []CaseRule
+	prefixRules           []PrefixRule
+	namespaceRules        []NamespaceRule
+	rootRules             []RootRule
+	schemaLocationRules   []SchemaLocationRule
+	nilRules              []NilRule
+	defaultNamespaceRules []DefaultNamespaceRule
+	attrCleanupRules      []AttrCleanupRule
+	attrDeleteRules       []AttrDeleteRule
+	rawTagMap             map[string]bool
+	input                 InputOptions
+	output                OutputOptions
+	indentPrefix          string
+	indent                string
+
+	elementStack       []*Element
+	declarationWritten bool
+
+	// 最小変更モードで使う入力の記録と、現在のトークンの入力バイト列
+	recorder *spanRecorder
+	rawToken []byte
+
+	// 分割された raw_tags の要素のテキストを処理している間の状態と、処理中の部分に続きがあるかどうか
+	split    *rawTextSplit
+	textMore bool
+
+	// コメント前後の空行を維持するための状態
+	blankLinePending bool
+	lastWasMisc      bool
+
+	// 入力で自己終了タグだった要素を判定するための、直前のトークンの情報
+	prevTokenWasStart bool
+	prevTokenOffset   int64
+	selfClosedInInput bool
+
+	// capture_rules で取り込んだ変数の名前と値 (文書ごとに空から始まる)
+	variables map[string]string
+	// summary_rules ごとの集計値 (文書ごとに0から始まる)
+	summaryTotals []summaryTotal
+	// 子が無いときだけ挿入する (if_missing) 断片のうち、挿入するかどうかが未確定のもの
+	heldInserts []*heldInsert
+	// 削除ルールの対象で、削除するかどうかが未確定の要素 (外側の要素から順)
+	heldDeletes []*heldDelete
+	// 重複を削除する子要素を持つ要素 (外側の要素から順) と、比較のために出力を保留している子要素
+	dedupeParents []*dedupeParent
+	heldDedupes   []*heldDedupe
+	// 子要素を並べ替える要素 (外側の要素から順)
+	reorderParents []*reorderParent
+	// 子要素を検査する要素 (外側の要素から順)
+	assertTargets []*assertTarget
+	// 空のときに出力しない要素 (外側の要素から順)
+	heldEmpties []*heldEmpty
+	// nil_rules の対象で、変換するかどうかが未確定の要素 (外側の要素から順)
+	heldNils []*heldNil
+
+	// 入力から読み込んだ要素の数
+	elements int
+
+	// 埋め込み側のフックと、フックが出力しないとした要素の中で読み飛ばしている深さ
+	hooks     []Hooks
+	skipDepth int
+
+	// ルールの適用を通知する Observer と、イベントに含めるルールの説明 (キーは "value_rules[2]" など)
+	observers    []Observer
+	descriptions map[string]string
+
+	// 要素とテキストに順に適用するフィルターと、組み込みのフィルターの順序・追加のフィルター
+	filters       []TokenFilter
+	filterOrder   []string
+	customFilters []TokenFilter
+
+	// 警告を出力済みの未宣言の名前空間接頭辞
+	warnedPrefixes map[string]bool
+
+	// 処理の結果として返す、ルールの種類ごとの適用回数・警告・以前の入力から読み込んだバイト数
+	// (seen は、ルールの種類ごとの、対象が現れたかどうか)
+	hits      map[string][]int
+	seen      map[string][]bool
+	warnings  []string
+	bytesRead int64
+	// sanitizers は、使用できない文字を取り除いた入力ごとの Reader です (警告の報告に使います)。
+	sanitizers []*controlCharReader
+
+	// 出力を途中で書き出すための、前回の書き出し以降のトークン数と書き出し時点の出力量
+	counter          *countingWriter
+	tokensSinceFlush int
+	flushedBytes     int64
+}
+
+// NewProcessor は、r を読み込んで w に書き込む新しいProcessorを、opts のルールと設定で初期化します。
+func NewProcessor(r io.Reader, w io.Writer, opts ...Option) *Processor {
+	p := &Processor{
+		wrapRuleMap: make(map[string]wrapRuleRef),
+		rawTagMap:   make(map[string]bool),
+		input: InputOptions{
+			Decoder: DecoderOptions{Strict: true},
+			Limits:  buildInputLimits(ConfigLimits{}),
+		},
+		indent:       "  ",
+		elementStack: make([]*Element, 0),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	order, _ := resolveFilterOrder(p.filterOrder)
+	for _, name := range order {
+		p.filters = append(p.filters, p.newBuiltinFilter(name))
+	}
+	p.filters = append(p.filters, p.customFilters...)
+
+	// 使用できない文字を取り除いた入力を記録し、デコードする
+	r = p.sanitizeInput(r, "")
+
+	// 最小変更モードでは、トークンごとの入力バイト列を取り出せるよう入力を記録する
+	if p.output.Minimal {
+		p.recorder = newSpanRecorder(r)
+		r = p.recorder
+	}
+
+	// 結果の報告と出力量での書き出しのため、エンコーダのバッファから送り出されたバイト数を数える
+	p.counter = &countingWriter{w: w}
+	w = p.counter
+
+	p.reader = bufio.NewReader(r)
+	if len(p.rawTagMap) > 0 && !p.output.Minimal && !p.input.HTML {
+		// raw_tags の要素の中身は大きくなりやすいため、分割して読み込みメモリ使用量を抑える
+		// (最小変更モードでは、トークンの入力バイト列を記録と対応させるため分割しない。
+		// HTML互換の読み込みでは、トークナイザーがテキストをまとめて読み込むため分割できない)
+		p.splitter = newTextSplitter(p.reader)
+		p.decoder = newDecoder(p.splitter, p.input)
+		p.decoder.CharsetReader = splitCharsetReader(p.splitter, p.decoder.CharsetReader)
+	} else {
+		p.decoder = newDecoder(p.reader, p.input)
+	}
+	p.encoder = newTokenEncoder(w)
+	p.writer = w
+	// コンパクト出力では要素間の空白を一切出力せず、最小変更モードでは入力の空白を維持する
+	if p.output.Canonical {
+		p.encoder.SetCanonical(true)
+	} else if !p.output.Compact && !p.output.Minimal {
+		p.encoder.Indent(p.indentPrefix, p.indent)
+		p.encoder.SetMiscOwnLine(p.output.Comments.OwnLine)
+		p.encoder.SetAttrWrapWidth(p.output.AttrWrapWidth)
+	}
+	if !p.output.Canonical && !p.output.Minimal {
+		p.encoder.SetAttrOrder(p.output.SortAttributes, p.output.AttributeOrder)
+		p.encoder.SetCharRefs(p.output.CharRefs.All, p.output.CharRefs.Tags)
+	}
+	if p.output.Encoding != "" {
+		if enc, _, err := lookupEncoding(p.output.Encoding); err == nil && !isUTF8(enc) {
+			p.encoder.SetEncodable(newEncodableFunc(enc))
+		}
+	}
+	return p
+}
+
+// inputDecoder は、入力を読み込むXMLデコーダです。
+// HTML互換の読み込みでは、html が読み込んだトークンの名前空間を xml.Decoder で解決し、入力の位置は html から求めます。
+type inputDecoder struct {
+	*xml.Decoder
+	html *htmlTokenReader
+}
+
+// InputOffset は、最後に読み込んだトークンの直後の入力のバイト位置を返します。
+func (d *inputDecoder) InputOffset() int64 {
+	if d.html != nil {
+		offset, _, _ := d.html.position()
+		return offset
+	}
+	return d.Decoder.InputOffset()
+}
+
+// InputPos は、最後に読み込んだトークンの直後の入力の行と桁を返します。
+func (d *inputDecoder) InputPos() (line, column int) {
+	if d.html != nil {
+		_, line, column = d.html.position()
+		return line, column
+	}
+	return d.Decoder.InputPos()
+}
+
+// newDecoder は、入力設定に従って r を読み込むXMLデコーダを作成します。
+func newDecoder(r io.Reader, input InputOptions) *inputDecoder {
+	if input.HTML {
+		html := newHTMLTokenReader(r)
+		return &inputDecoder{Decoder: xml.NewTokenDecoder(html), html: html}
+	}
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = newCharsetReader(input.Encoding)
+	decoder.Strict = input.Decoder.Strict
+	decoder.AutoClose = input.Decoder.AutoClose
+	decoder.Entity = input.Decoder.Entity
+	return &inputDecoder{Decoder: decoder}
+}
+
+// Run は、XMLの処理を実行し、処理の結果を返します。
+// ctx が取り消された場合は、次のトークンを読む前に処理を中断して ctx のエラーを返します。
+// 中断した場合、出力先には途中までの内容が書き込まれている可能性があります。
+// エラーの場合も、それまでの処理の結果を返します。
+// 正常に終えた場合は、一度も適用されなかったルールを結果の警告に含めます。
+func (p *Processor) Run(ctx context.Context) (TransformResult, error) {
+	err := p.run(ctx)
+	p.warnControlChars()
+	if err == nil {
+		p.warnUnmatchedRules()
+	}
+	return p.result(), err
+}
+
+// run は、Run の処理の本体です。入力の読み込み (デコーダの段) と出力の書き込み (エンコードの段) は
+// 別の goroutine で行い、この goroutine ではトークンの変換だけを行います。
+func (p *Processor) run(ctx context.Context) error {
+	return p.pipeOutput(func() error {
+		done := make(chan struct{})
+		batches := p.decodeTokens(done)
+		// 途中で返る場合も、返った後に入力を読まないよう、デコーダの段が止まるのを待つ
+		defer func() {
+			close(done)
+			for range batches {
+			}
+		}()
+		for batch := range batches {
+			for _, d := range batch {
+				p.offset, p.line, p.column, p.rawToken = d.offset, d.line, d.column, d.raw
+				if d.err == io.EOF {
+					return p.encoder.Flush()
+				}
+				if d.err != nil {
+					return p.positionError("", d.err)
+				}
+				if err := checkCanceled(ctx); err != nil {
+					return err
+				}
+				if err := p.handleDecoded(d); err != nil {
+					return p.positionError("", err)
+				}
+			}
+		}
+		return p.encoder.Flush()
+	})
+}
+
+// readToken は、RunMerged で入力から次のトークンを読み込み、処理中のトークンの位置を更新します。
+func (p *Processor) readToken() (xml.Token, error) {
+	token, err := p.decoder.Token()
+	p.offset = p.decoder.InputOffset()
+	p.line, p.column = p.decoder.InputPos()
+	return token, err
+}
+
+// RunMerged は、複数の入力文書を順に処理し、それぞれの文書要素を container 要素の子として
+// 1つの文書に出力します。container 要素にも他の要素と同様にルールが適用されます。
+// XML宣言は最初の入力のものだけを使い、DOCTYPE宣言は出力しません。
+// open は入力ファイルを開く関数で、各入力は処理が終わると閉じられます。
+// 入力のバイト列を記録する最小変更モードでは使えません。ctx と結果の扱いは Run と同じです。
+func (p *Processor) RunMerged(ctx context.Context, container string, inputs []string, open func(string) (io.Reader, io.Closer, error)) (TransformResult, error) {
+	err := p.pipeOutput(func() error {
+		return p.runMerged(ctx, container, inputs, open)
+	})
+	p.warnControlChars()
+	if err == nil {
+		p.warnUnmatchedRules()
+	}
+	return p.result(), err
+}
+
+// runMerged は、RunMerged の処理の本体です。
+func (p *Processor) runMerged(ctx context.Context, container string, inputs []string, open func(string) (io.Reader, io.Closer, error)) error {
+	if p.recorder != nil {
+		return fmt.Errorf("merging inputs is not supported in minimal output mode")
+	}
+	opened := false
+	openContainer := func(token xml.Token) error {
+		opened = true
+		if err := p.ensureDeclaration(token); err != nil {
+			return err
+		}
+		return p.handleStartElement(xml.StartElement{Name: xml.Name{Local: container}}, false)
+	}
+
+	for i, input := range inputs {
+		r, closer, err := open(input)
+		if err != nil {
+			return err
+		}
+		p.bytesRead += p.offset
+		p.offset = 0
+		p.inputName = input
+		p.decoder = newDecoder(p.sanitizeInput(r, input), p.input)
+		p.prevTokenWasStart = false
+		for {
+			if err := checkCanceled(ctx); err != nil {
+				closer.Close()
+				return err
+			}
+			token, err := p.readToken()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				closer.Close()
+				return p.positionError(input, &ParseError{Err: err})
+			}
+			switch t := token.(type) {
+			case xml.ProcInst:
+				if t.Target == "xml" {
+					// 最初の入力のXML宣言だけを、container 要素の前に出力する
+					if i == 0 && !opened {
+						err = p.handleToken(token)
+					}
+					if err != nil {
+						closer.Close()
+						return p.positionError(input, err)
+					}
+					continue
+				}
+			case xml.Directive:
+				if err := p.checkDirective(t); err != nil {
+					closer.Close()
+					return p.positionError(input, &ParseError{Err: err})
+				}
+				continue
+			}
+			if !opened {
+				err = openContainer(token)
+			}
+			if err == nil {
+				err = p.handleToken(token)
+			}
+			if err != nil {
+				closer.Close()
+				return p.positionError(input, err)
+			}
+		}
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+
+	if !opened {
+		if err := openContainer(nil); err != nil {
+			return err
+		}
+	}
+	p.selfClosedInInput = false
+	if err := p.handleEndElement(xml.EndElement{Name: xml.Name{Local: container}}); err != nil {
+		return err
+	}
+	return p.encoder.Flush()
+}
+
+// checkCanceled は、ctx が取り消されていれば処理を中断するためのエラーを返します。
+func checkCanceled(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("processing canceled: %w", ctx.Err())
+	default:
+		return nil
+	}
+}
+
+// handleToken は、1つのトークンを種類に応じて処理します。
+func (p *Processor) handleToken(token xml.Token) error {
+	p.trackSelfClosing(token)
+	if p.skipDepth > 0 {
+		if _, ok := token.(xml.StartElement); ok {
+			p.elements++
+		}
+		p.skipToken(token)
+		return nil
+	}
+	if err := p.ensureDeclaration(token); err != nil {
+		return err
+	}
+	if err := p.preserveBlankLine(token); err != nil {
+		return err
+	}
+	var err error
+	switch elem := token.(type) {
+	case xml.StartElement:
+		p.elements++
+		keep, modified, hookErr := p.runStartElementHooks(&elem)
+		switch {
+		case hookErr != nil:
+			err = hookErr
+		case !keep:
+			p.skipDepth = 1
+		default:
+			err = p.handleStartElement(elem, modified)
+		}
+	case xml.CharData:
+		keep, modified, hookErr := p.runCharDataHooks(&elem)
+		if hookErr != nil || !keep {
+			err = hookErr
+		} else {
+			err = p.handleCharData(elem, modified)
+		}
+	case xml.EndElement:
+		var name xml.Name
+		if len(p.elementStack) > 0 {
+			name = p.elementStack[len(p.elementStack)-1].Start.Name
+		}
+		err = p.handleEndElement(elem)
+		if err == nil {
+			err = p.runEndElementHooks(xml.EndElement{Name: name})
+		}
+	case xml.ProcInst:
+		err = p.handleProcInst(elem)
+	default:
+		err = p.handleOther(elem)
+	}
+	if err != nil {
+		return err
+	}
+	return p.periodicFlush()
+}
+
+// periodicFlush は、出力設定の間隔に達していれば、エンコーダのバッファと
+// 出力先の途中段 (gzip 圧縮など) の内容をファイルまで書き出します。
+func (p *Processor) periodicFlush() error {
+	opts := p.output.Flush
+	p.tokensSinceFlush++
+	due := opts.Tokens > 0 && p.tokensSinceFlush >= opts.Tokens
+	if opts.Bytes > 0 {
+		due = due || p.counter.n+int64(p.encoder.Buffered())-p.flushedBytes >= opts.Bytes
+	}
+	if !due {
+		return nil
+	}
+	if err := p.encoder.FlushBuffer(); err != nil {
+		return err
+	}
+	if f, ok := p.writer.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return fmt.Errorf("failed to flush output: %w", err)
+		}
+	}
+	p.tokensSinceFlush = 0
+	p.flushedBytes = p.counter.n
+	return nil
+}
+
+// writeRawToken は、最小変更モードであれば現在のトークンを入力のバイト列のまま書き出し、true を返します。
+func (p *Processor) writeRawToken() (bool, error) {
+	if p.recorder == nil {
+		return false, nil
+	}
+	return true, p.encoder.WriteRaw(p.rawToken)
+}
+
+// trackSelfClosing は、終了タグが入力で自己終了タグ (<tag/>) から生成されたものかを記録します。
+// xml.Decoder は <tag/> の終了タグを入力を読み進めずに返すため、直前の開始タグと
+// 入力オフセットが変わっていないことで判定できます。
+func (p *Processor) trackSelfClosing(token xml.Token) {
+	offset := p.offset
+	_, isEnd := token.(xml.EndElement)
+	p.selfClosedInInput = isEnd && p.prevTokenWasStart && offset == p.prevTokenOffset
+	_, p.prevTokenWasStart = token.(xml.StartElement)
+	p.prevTokenOffset = offset
+}
+
+// preserveBlankLine は、入力でコメントや処理命令の前後にあった空行を出力に残します。
+// 空白のみのテキストは破棄されるため、改行を2つ以上含む場合に空行として記録しておき、
+// 次のトークンがコメント・処理命令であるか、直前がそれらであった場合に空行を書き出します。
+func (p *Processor) preserveBlankLine(token xml.Token) error {
+	if !p.output.Comments.PreserveBlankLines || p.recorder != nil {
+		return nil
+	}
+	if cd, ok := token.(xml.CharData); ok && len(strings.TrimSpace(string(cd))) == 0 {
+		if strings.Count(string(cd), "\n") >= 2 {
+			p.blankLinePending = true
+		}
+		return nil
+	}
+
+	isMisc := false
+	switch t := token.(type) {
+	case xml.Comment:
+		isMisc = true
+	case xml.ProcInst:
+		isMisc = t.Target != "xml"
+	}
+	var err error
+	if p.blankLinePending && (isMisc || p.lastWasMisc) {
+		err = p.encoder.WriteBlankLine()
+	}
+	p.blankLinePending = false
+	p.lastWasMisc = isMisc
+	return err
+}
+
+// isSelfClosing は、空要素 name を自己終了タグで出力すべきかを判定します。
+func (p *Processor) isSelfClosing(name string, inputSelfClosed bool) bool {
+	opts := p.output.SelfClosing
+	if opts.Expand[name] {
+		return false
+	}
+	return opts.All || opts.Tags[name] || (opts.Preserve && inputSelfClosed)
+}
+
+// ensureDeclaration は、入力にXML宣言が無い場合に、最初のトークンの前に宣言を出力します。
+// 宣言の追加が指定されている場合と、UTF-8以外の出力エンコーディングを明示する必要がある場合が対象です。
+func (p *Processor) ensureDeclaration(token xml.Token) error {
+	if p.declarationWritten {
+		return nil
+	}
+	p.declarationWritten = true
+	if pi, ok := token.(xml.ProcInst); ok && pi.Target == "xml" {
+		return nil
+	}
+	if p.output.Declaration.Mode == declarationRemove {
+		return nil
+	}
+	if p.output.Encoding == "" && p.output.Declaration.Mode != declarationAdd {
+		return nil
+	}
+	decl := p.rewriteDeclaration(xmlDeclaration{Version: "1.0", Encoding: "UTF-8"})
+	return p.encoder.EncodeToken(xml.ProcInst{Target: "xml", Inst: []byte(decl.String())})
+}
+
+// rewriteDeclaration は、出力設定に合わせてXML宣言の疑似属性を書き換えます。
+// 入力はUTF-8に変換して読み込むため、出力エンコーディングの指定が無ければ UTF-8 とします。
+func (p *Processor) rewriteDeclaration(decl xmlDeclaration) xmlDeclaration {
+	opts := p.output.Declaration
+	if opts.Version != "" {
+		decl.Version = opts.Version
+	}
+	switch {
+	case opts.Encoding != "":
+		decl.Encoding = opts.Encoding
+	case p.output.Encoding != "":
+		decl.Encoding = p.output.Encoding
+	case decl.Encoding != "" && !strings.EqualFold(decl.Encoding, "UTF-8"):
+		decl.Encoding = "UTF-8"
+	}
+	if opts.Standalone != "" {
+		decl.Standalone = opts.Standalone
+	}
+	return decl
+}
+
+// handleProcInst は、処理命令を処理します。
+// XML宣言の場合は、出力設定に従って削除するか疑似属性を書き換えます。
+func (p *Processor) handleProcInst(pi xml.ProcInst) error {
+	if pi.Target == "xml" {
+		if p.output.Declaration.Mode == declarationRemove {
+			return nil
+		}
+		decl := parseXMLDeclaration(string(pi.Inst))
+		if rewritten := p.rewriteDeclaration(decl); rewritten != decl {
+			pi = xml.ProcInst{Target: pi.Target, Inst: []byte(rewritten.String())}
+			return p.encoder.EncodeToken(pi)
+		}
+	}
+	if ok, err := p.writeRawToken(); ok {
+		return err
+	}
+	if err := p.encoder.EncodeToken(pi); err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+	return nil
+}
+
+// checkDirective は、DOCTYPE宣言で宣言された実体が入力の上限を超えて展開されないか、
+// セキュアモードでは外部のリソースを参照していないかを確認します。
+// 問題が無ければ、内部サブセットで宣言された実体を以降の本文の読み込みで展開できるようにします。
+func (p *Processor) checkDirective(d xml.Directive) error {
+	entity, err := doctypeEntities(d, p.input, p.decoder.Entity)
+	if err != nil {
+		return err
+	}
+	p.decoder.Entity = entity
+	return nil
+}
+
+// doctypeEntities は、checkDirective の確認を行い、known に DOCTYPE宣言の内部サブセットで
+// 宣言された実体を加えた実体の一覧を返します。実体の宣言が無ければ known をそのまま返します。
+func doctypeEntities(d xml.Directive, input InputOptions, known map[string]string) (map[string]string, error) {
+	doctype, err := parseDoctype(d)
+	if err != nil || doctype == nil {
+		return known, err
+	}
+	if input.Secure {
+		if doctype.SystemID != "" {
+			return nil, fmt.Errorf("external DTD reference '%s' is not allowed in secure mode", doctype.SystemID)
+		}
+		for _, entity := range doctype.Entities {
+			if entity.SystemID != "" {
+				return nil, fmt.Errorf("external entity '%s' ('%s') is not allowed in secure mode", entity.Name, entity.SystemID)
+			}
+		}
+	}
+	if err := checkEntityExpansion(doctype.Entities, input.Limits); err != nil {
+		return nil, err
+	}
+	if len(doctype.Entities) == 0 {
+		return known, nil
+	}
+	entity := make(map[string]string)
+	for name, text := range known {
+		entity[name] = text
+	}
+	for name, text := range expandEntities(doctype.Entities, known) {
+		if _, ok := entity[name]; !ok {
+			entity[name] = text
+		}
+	}
+	return entity, nil
+}
+
+// handleOther は、コメントやDOCTYPE宣言など、その他のトークンを処理します。
+// DOCTYPE宣言の実体の確認は、読み込み時 (デコーダの段、または RunMerged) に済んでいます。
+func (p *Processor) handleOther(token xml.Token) error {
+	if ok, err := p.writeRawToken(); ok {
+		return err
+	}
+	if err := p.encoder.EncodeToken(token); err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+	return nil
+}
+
+// handleStartElement は、開始タグを処理します。
+// modified は、フックが開始タグを書き換えたかどうかです (最小変更モードで入力のまま出力しないため)。
+func (p *Processor) handleStartElement(se xml.StartElement, modified bool) error {
+	if limit := p.input.Limits.MaxDepth; limit > 0 && len(p.elementStack) >= limit {
+		// 上限の近くまで入れ子になったパスは長すぎるため、末尾の要素だけを報告する
+		return &ParseError{Path: p.inputPathTail(maxErrorPathSegments), Err: fmt.Errorf("element '%s' at depth %d exceeds the maximum nesting depth of %d (input.limits.max_depth)", se.Name.Local, len(p.elementStack)+1, limit)}
+	}
+	prefixesModified, err := p.resolveUndeclaredPrefixes(&se)
+	if err != nil {
+		return err
+	}
+
+	// 開始タグの前のフィルター (前方挿入・タグ名置換など)
+	el := &Element{Input: se.Name, Start: se}
+	var original xml.StartElement
+	if p.recorder != nil {
+		original = xml.StartElement{Name: se.Name, Attr: append([]xml.Attr(nil), se.Attr...)}
+		// フィルターが属性を書き換えても入力との比較に影響しないよう、スライスを共有しない
+		el.Start.Attr = append([]xml.Attr(nil), se.Attr...)
+	}
+	w := &filterWriter{p: p}
+	for _, f := range p.filters {
+		if err := f.BeforeStart(w, el); err != nil {
+			return err
+		}
+	}
+	modified = modified || prefixesModified || el.modified || p.recorder != nil && !sameStartElement(original, el.Start)
+	p.elementStack = append(p.elementStack, el)
+
+	// 実際の開始タグを書き込む
+	// 最小変更モードで変更が無ければ入力のまま出力する。ただし入力が <tag/> で、
+	// 子を追加するフィルターがある場合は開始タグと終了タグに分けて出力し直すため、
+	// 開始タグの後のフィルターの出力を溜めておき、何も無ければ入力のまま出力する
+	el.rawStart = p.recorder != nil && !modified
+	w.buffered = el.rawStart && bytes.HasSuffix(p.rawToken, []byte("/>"))
+	if err := p.holdEmpty(el); err != nil {
+		return err
+	}
+	if !w.buffered {
+		if err := p.writeStart(el); err != nil {
+			return err
+		}
+		if err := p.markEmptyContent(el); err != nil {
+			return err
+		}
+	}
+
+	// 開始タグの後のフィルター (子のラップ開始・子の先頭への挿入など)
+	for _, f := range p.filters {
+		if err := f.AfterStart(w, el); err != nil {
+			return err
+		}
+	}
+	if !w.buffered {
+		return p.markReorderContent(el)
+	}
+	el.rawStart = len(w.pending) == 0
+	if err := p.writeStart(el); err != nil {
+		return err
+	}
+	if err := p.markEmptyContent(el); err != nil {
+		return err
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return p.markReorderContent(el)
+}
+
+// writeStart は、要素の開始タグを書き出します。
+func (p *Processor) writeStart(el *Element) error {
+	if el.rawStart {
+		return p.encoder.WriteRawStart(el.Start, p.rawToken)
+	}
+	return p.encoder.EncodeToken(el.Start)
+}
+
+// handleCharData は、テキストデータを処理します。
+// modified は、フックがテキストを書き換えたかどうかです (最小変更モードで入力のまま出力しないため)。
+func (p *Processor) handleCharData(cd xml.CharData, modified bool) error {
+	// 分割されたテキストは、全体が空白のみの場合だけ破棄する
+	if p.split != nil {
+		var ok bool
+		if cd, ok = p.split.splitCharData(cd); !ok {
+			return nil
+		}
+	} else if len(bytes.TrimSpace(cd)) == 0 {
+		// 空白のみのテキストノードは破棄 (最小変更モードでは入力のまま維持)
+		if modified {
+			return nil
+		}
+		_, err := p.writeRawToken()
+		return err
+	}
+
+	text := &Text{Data: string(cd), Modified: modified, More: p.split != nil && p.textMore}
+	if len(p.elementStack) > 0 {
+		// 現在の親タグがraw_tagsで指定されたものかチェックし、フィルター (値置換など) を適用する
+		el := p.elementStack[len(p.elementStack)-1]
+		text.Raw = p.rawTagMap[el.Start.Name.Local]
+		w := &filterWriter{p: p}
+		for _, f := range p.filters {
+			if err := f.CharData(w, el, text); err != nil {
+				return err
+			}
+		}
+	}
+
+	if text.Raw {
+		// --- rawタグの中身として処理 ---
+		// 正規化出力ではCDATAセクションを使わず、エスケープしたテキストとして出力する
+		if p.output.Canonical {
+			return p.encoder.EncodeToken(xml.CharData(text.Data))
+		}
+
+		// エンコーダーのエスケープをバイパスし、CDATAで囲むことで、出力されるXMLが壊れるのを防ぐ
+		// (同じバッファを通すため、前後のトークンとの順序は保たれる)
+		if p.split != nil {
+			return p.split.writeCDATA(p.encoder, text.Data)
+		}
+		return p.encoder.WriteCDATA(text.Data)
+	}
+
+	// --- 通常のタグの中身として処理 ---
+	if !text.Modified {
+		if ok, err := p.writeRawToken(); ok {
+			return err
+		}
+	}
+	return p.encoder.EncodeToken(xml.CharData(text.Data))
+}
+
+// handleEndElement は、終了タグを処理します。
+func (p *Processor) handleEndElement(ee xml.EndElement) error {
+	if len(p.elementStack) == 0 {
+		return &ParseError{Err: fmt.Errorf("unexpected end element '%s'", ee.Name.Local)}
+	}
+
+	el := p.elementStack[len(p.elementStack)-1]
+	p.elementStack = p.elementStack[:len(p.elementStack)-1]
+
+	// 終了タグの前のフィルター (子のラップ終了など) は、開始タグの後と入れ子になるよう逆順に呼び出す
+	w := &filterWriter{p: p}
+	for i := len(p.filters) - 1; i >= 0; i-- {
+		if err := p.filters[i].BeforeEnd(w, el); err != nil {
+			return err
+		}
+	}
+
+	// 実際の終了タグを書き込む (空要素は設定に応じて自己終了タグにする)
+	// 開始タグを入力のまま出力した場合は、終了タグも入力のまま出力する
+	empty, err := p.isHeldEmpty(el)
+	if err != nil {
+		return err
+	}
+	if el.rawStart {
+		if err := p.encoder.WriteRawEnd(xml.EndElement{Name: el.Start.Name}, p.rawToken); err != nil {
+			return err
+		}
+	} else {
+		selfClose := p.isSelfClosing(el.Start.Name.Local, p.selfClosedInInput) || (p.recorder != nil && p.selfClosedInInput && !p.output.SelfClosing.Expand[el.Start.Name.Local])
+		if err := p.encoder.EncodeEnd(xml.EndElement{Name: el.Start.Name}, selfClose); err != nil {
+			return err
+		}
+	}
+	if err := p.releaseEmpty(el, empty); err != nil {
+		return err
+	}
+
+	// 終了タグの後のフィルター (後方挿入など)
+	for i := len(p.filters) - 1; i >= 0; i-- {
+		if err := p.filters[i].AfterEnd(w, el); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDeclaration(t *testing.T) {
+	tests := []struct {
+		name  string
+		decl  ConfigDeclaration
+		input string
+		want  string
+	}{
+		{"keep", ConfigDeclaration{}, `<?xml version="1.0"?><a/>`, `<?xml version="1.0"?><a></a>`},
+		{"keep without declaration", ConfigDeclaration{}, `<a/>`, `<a></a>`},
+		{"add", ConfigDeclaration{Mode: "add"}, `<a/>`, `<?xml version="1.0" encoding="UTF-8"?><a></a>`},
+		{"add rewrites existing", ConfigDeclaration{Mode: "add", Standalone: "yes"}, `<?xml version="1.0" encoding="UTF-8"?><a/>`, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?><a></a>`},
+		{"remove", ConfigDeclaration{Mode: "remove"}, `<?xml version="1.0"?><a/>`, `<a></a>`},
+		{"keep with overrides", ConfigDeclaration{Version: "1.1"}, `<?xml version="1.0" standalone="no"?><a/>`, `<?xml version="1.1" standalone="no"?><a></a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := transformString(t, Config{Output: ConfigOutput{Compact: true, Declaration: tt.decl}}, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeclarationErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		decl ConfigDeclaration
+		err  string
+	}{
+		{"unknown mode", ConfigDeclaration{Mode: "replace"}, "unknown declaration mode"},
+		{"invalid standalone", ConfigDeclaration{Standalone: "true"}, "standalone"},
+		{"encoding mismatch", ConfigDeclaration{Encoding: "Shift_JIS"}, "does not match the output encoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tryTransformString(t, Config{Output: ConfigOutput{Declaration: tt.decl}}, "<a/>")
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Transform error = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSelfClosing(t *testing.T) {
+	tests := []struct {
+		name        string
+		selfClosing ConfigSelfClosing
+		want        string
+	}{
+		{"none", ConfigSelfClosing{}, `<a><b></b><c></c><d>x</d></a>`},
+		{"all", ConfigSelfClosing{Mode: "all"}, `<a><b/><c/><d>x</d></a>`},
+		{"preserve", ConfigSelfClosing{Mode: "preserve"}, `<a><b/><c></c><d>x</d></a>`},
+		{"tags", ConfigSelfClosing{Tags: []string{"c"}}, `<a><b></b><c/><d>x</d></a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := transformString(t, Config{Output: ConfigOutput{Compact: true, SelfClosing: tt.selfClosing}}, `<a><b/><c></c><d>x</d></a>`)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := tryTransformString(t, Config{Output: ConfigOutput{SelfClosing: ConfigSelfClosing{Mode: "some"}}}, "<a/>"); err == nil {
+		t.Error("unknown self_closing mode was accepted")
+	}
+}
+
+func TestMinimalOutput(t *testing.T) {
+	const input = "<?xml version='1.0'?>\n<a  x='1' >\n\t<b>t&#x41;</b><!-- c -->\n\t<c/>\n</a>\n"
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"no rules", Config{}, input},
+		{"renamed element", Config{NameRules: []ConfigNameRule{{Old: "c", New: "d"}}}, "<?xml version='1.0'?>\n<a  x='1' >\n\t<b>t&#x41;</b><!-- c -->\n\t<d/>\n</a>\n"},
+		{"changed text", Config{ValueRules: []ConfigValueRule{{Target: "b", Type: "append", Params: map[string]interface{}{"suffix": "!"}}}}, "<?xml version='1.0'?>\n<a  x='1' >\n\t<b>tA!</b><!-- c -->\n\t<c/>\n</a>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Output.Minimal = true
+			got, _ := transformString(t, tt.cfg, input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommentPlacement(t *testing.T) {
+	const input = "<a>\n  <b/><!-- after b -->\n\n  <!-- before c -->\n  <c/>\n  <?pi x?>\n</a>"
+	tests := []struct {
+		name     string
+		comments ConfigComments
+		want     string
+	}{
+		{"inline", ConfigComments{}, "<a>\r\n  <b></b><!-- after b --><!-- before c -->\r\n  <c></c><?pi x?>\r\n</a>"},
+		{"own line", ConfigComments{Placement: "own_line"}, "<a>\r\n  <b></b>\r\n  <!-- after b -->\r\n  <!-- before c -->\r\n  <c></c>\r\n  <?pi x?>\r\n</a>"},
+		{"own line with blank lines", ConfigComments{Placement: "own_line", PreserveBlankLines: true}, "<a>\r\n  <b></b>\r\n  <!-- after b -->\r\n\r\n  <!-- before c -->\r\n  <c></c>\r\n  <?pi x?>\r\n</a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := transformString(t, Config{Output: ConfigOutput{Comments: tt.comments}}, input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := tryTransformString(t, Config{Output: ConfigOutput{Comments: ConfigComments{PreserveBlankLines: true}}}, "<a/>"); err == nil {
+		t.Error("preserve_blank_lines without own_line was accepted")
+	}
+}
+
+func TestAttrWrapWidth(t *testing.T) {
+	const input = `<a><b first="1" second="2" third="3">x</b><c short="1"/></a>`
+	tests := []struct {
+		name  string
+		width int
+		want  string
+	}{
+		{"no wrapping", 0, "<a>\r\n  <b first=\"1\" second=\"2\" third=\"3\">x</b>\r\n  <c short=\"1\"></c>\r\n</a>"},
+		{"wrap long start tags", 30, "<a>\r\n  <b\r\n      first=\"1\"\r\n      second=\"2\"\r\n      third=\"3\">x</b>\r\n  <c short=\"1\"></c>\r\n</a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := transformString(t, Config{Output: ConfigOutput{AttrWrapWidth: tt.width}}, input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlush(t *testing.T) {
+	// 途中で書き出しても、出力の内容は変わらない
+	const input = "<a><b x='1'>text</b><!-- c --><c/><d><![CDATA[x]]></d></a>"
+	want, _ := transformString(t, Config{}, input)
+	for _, flush := range []ConfigFlush{{Tokens: 1}, {Bytes: 1}, {Tokens: 2, Bytes: 10}} {
+		if got, _ := transformString(t, Config{Output: ConfigOutput{Flush: flush}}, input); got != want {
+			t.Errorf("output with flush %+v = %q, want %q", flush, got, want)
+		}
+	}
+	if _, err := tryTransformString(t, Config{Output: ConfigOutput{Flush: ConfigFlush{Tokens: -1}}}, input); err == nil {
+		t.Error("negative flush interval was accepted")
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	nested := func(depth int) string {
+		return strings.Repeat("<e>", depth) + strings.Repeat("</e>", depth)
+	}
+	tests := []struct {
+		name     string
+		maxDepth int
+		depth    int
+		err      string
+	}{
+		{"at the limit", 8, 8, ""},
+		{"over the limit", 8, 9, "exceeds the maximum nesting depth of 8"},
+		{"default limit", 0, 1000, ""},
+		{"over the default limit", 0, 1001, "exceeds the maximum nesting depth of 1000"},
+		{"unlimited", -1, 1500, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tryTransformString(t, Config{Input: ConfigInput{Limits: ConfigLimits{MaxDepth: tt.maxDepth}}}, nested(tt.depth))
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Transform: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Transform error = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecoderOptions(t *testing.T) {
+	strict, lenient := true, false
+	tests := []struct {
+		name    string
+		decoder ConfigDecoder
+		input   string
+		want    string
+	}{
+		{"unquoted attribute", ConfigDecoder{Strict: &lenient}, `<a x=1/>`, "<a x=\"1\"></a>"},
+		{"auto close", ConfigDecoder{Strict: &lenient, AutoClose: []string{"br"}}, `<a>x<br>y</a>`, "<a>x<br></br>y</a>"},
+		{"html auto close", ConfigDecoder{Strict: &lenient, HTMLAutoClose: true}, `<a><img>y</a>`, "<a><img></img>y</a>"},
+		{"custom entities", ConfigDecoder{Strict: &strict, Entities: map[string]string{"co": "Company"}}, `<a>&co;</a>`, "<a>Company</a>"},
+		{"html entities", ConfigDecoder{HTMLEntities: true}, `<a>&copy;</a>`, "<a>©</a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := transformString(t, Config{Input: ConfigInput{Decoder: tt.decoder}, Output: compactOutput}, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := tryTransformString(t, Config{Input: ConfigInput{Decoder: ConfigDecoder{AutoClose: []string{"br"}}}}, "<a/>"); err == nil {
+		t.Error("auto_close with strict parsing was accepted")
+	}
+}
+
+func TestNewProcessorOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"no options", nil, "<a>\n  <b>x</b>\n  <c>&lt;y</c>\n</a>"},
+		{"name and value rules", []Option{
+			WithNameRules(NameReplaceRule{OldName: "b", NewName: "item"}),
+			WithValueRules(ValueReplaceRule{TargetTag: "item", ReplacementFunc: strings.ToUpper}),
+			WithOutputOptions(OutputOptions{Compact: true}),
+		}, "<a><item>X</item><c>&lt;y</c></a>"},
+		{"wrap rule and raw tags", []Option{
+			WithWrapRules(WrapRule{TargetTag: "a", WrapperTag: "list"}),
+			WithRawTags("c"),
+			WithOutputOptions(OutputOptions{Compact: true}),
+		}, "<a><list><b>x</b><c><![CDATA[<y]]></c></list></a>"},
+		{"indent", []Option{WithIndent("\t", "    ")}, "\t<a>\n\t    <b>x</b>\n\t    <c>&lt;y</c>\n\t</a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if _, err := NewProcessor(strings.NewReader(`<a><b>x</b><c>&lt;y</c></a>`), &out, tt.opts...).Run(context.Background()); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package obufuku
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// rawTextChunkSize は、raw_tags の要素の中身を分割して処理する大きさの目安 (バイト数) です。
+const rawTextChunkSize = 64 * 1024
+
+// デコーダへの入力に差し込む、テキストの区切りです。xml.Decoder はテキストを1つのトークンに溜めるため、
+// 区切りを差し込んでトークンを分けます。どちらも同じ長さで、入力での位置の補正に使います。
+const (
+	// textSplitMarker は、テキストの途中に差し込む空のCDATAセクションです。
+	textSplitMarker = "<![CDATA[]]>"
+	// cdataSplitMarker は、CDATAセクションの途中に差し込む、セクションの区切りです。
+	cdataSplitMarker = "]]><![CDATA["
+)
+
+// splitState は、textSplitter が入力のどの構文の中を読んでいるかです。
+type splitState int
+
+const (
+	splitText    splitState = iota // テキスト
+	splitEntity                    // テキスト中の実体参照
+	splitOpen                      // "<" の直後
+	splitBang                      // "<!" で始まる構文の先頭
+	splitTag                       // タグ
+	splitDecl                      // "<!" で始まる宣言
+	splitPI                        // 処理命令
+	splitComment                   // コメント
+	splitCDATA                     // CDATAセクション
+)
+
+// textSplitter は、デコーダが読み込む入力の間に入り、raw_tags の要素の中身の長いテキストに
+// 区切りを差し込んで、rawTextChunkSize ごとに別のトークンとして読み込ませます。
+// 区切りは、行末の正規化や実体参照、UTF-8の文字、CDATAセクションの終わり ("]]>") を分けない位置に差し込みます。
+// 入力エンコーディングをデコーダが変換する場合は、変換前のバイト列では位置を判断できないため分割しません。
+type textSplitter struct {
+	r *bufio.Reader
+
+	// armed は、読み込み中の要素が raw_tags であるかどうかで、デコーダの段が設定します。
+	armed    bool
+	disabled bool
+
+	state splitState
+	quote byte
+	// recent は、直近に読み込んだ入力のバイト列 (下位のバイトが最新)、since は構文の先頭からのバイト数です。
+	recent uint64
+	since  int
+	// run は、現在のテキストで前回の区切り以降に読み込んだバイト数です。
+	run int
+
+	// pending は、差し込み中の区切りの残りです。
+	pending string
+	// delivered は、デコーダに渡したバイト数 (区切りを含む)、line はデコーダに渡した入力の行です。
+	delivered int64
+	line      int
+
+	// splits は、差し込んだ区切りのうち、まだ位置の補正に使っていないものです。
+	splits []textSplit
+	// injected は差し込んだ区切りの数、lastInText は最後の区切りがテキストの途中に差し込んだものかどうかです。
+	injected   int
+	lastInText bool
+
+	// 位置の補正に使った区切りの合計の長さと、区切りのある最後の行での合計の長さ
+	shift    int64
+	colLine  int
+	colShift int
+}
+
+// textSplit は、差し込んだ区切りのデコーダでの終わりの位置と行です。
+type textSplit struct {
+	end  int64
+	line int
+}
+
+// newTextSplitter は、r を読み込む新しい textSplitter を作成します。
+func newTextSplitter(r *bufio.Reader) *textSplitter {
+	return &textSplitter{r: r, line: 1}
+}
+
+// Read は io.Reader インターフェースを実装します。
+// 入力エンコーディングの変換を挟む場合に使われ、分割しない状態であれば入力をそのまま読み込みます。
+func (s *textSplitter) Read(p []byte) (int, error) {
+	if s.disabled && s.pending == "" {
+		return s.r.Read(p)
+	}
+	n := 0
+	for n < len(p) {
+		b, err := s.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		p[n] = b
+		n++
+		if s.r.Buffered() == 0 && s.pending == "" {
+			break
+		}
+	}
+	return n, nil
+}
+
+// ReadByte は io.ByteReader インターフェースを実装します。xml.Decoder は、このメソッドで1バイトずつ読み込みます。
+func (s *textSplitter) ReadByte() (byte, error) {
+	if s.pending == "" && s.canSplit() {
+		marker := cdataSplitMarker
+		if s.state == splitText {
+			marker = textSplitMarker
+		}
+		s.pending = marker
+		s.splits = append(s.splits, textSplit{end: s.delivered + int64(len(marker)), line: s.line})
+		s.injected++
+		s.lastInText = s.state == splitText
+		s.run = 0
+	}
+	if s.pending != "" {
+		b := s.pending[0]
+		s.pending = s.pending[1:]
+		s.delivered++
+		return b, nil
+	}
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return b, err
+	}
+	s.delivered++
+	if b == '\n' {
+		s.line++
+	}
+	s.advance(b)
+	return b, nil
+}
+
+// canSplit は、次のバイトの前に区切りを差し込むかどうかを判定します。
+// 区切りの後に続くテキストが空にならないよう、テキストやCDATAセクションの終わりの直前には差し込みません。
+func (s *textSplitter) canSplit() bool {
+	if !s.armed || s.disabled || s.run < rawTextChunkSize || byte(s.recent) == '\r' {
+		return false
+	}
+	var end byte
+	switch s.state {
+	case splitText:
+		end = '<'
+	case splitCDATA:
+		if byte(s.recent) == ']' {
+			return false
+		}
+		end = ']'
+	default:
+		return false
+	}
+	next, err := s.r.Peek(1)
+	return err == nil && next[0] != end && utf8.RuneStart(next[0])
+}
+
+// advance は、入力から読み込んだバイト b で構文の状態を進めます。
+func (s *textSplitter) advance(b byte) {
+	s.recent = s.recent<<8 | uint64(b)
+	s.since++
+	switch s.state {
+	case splitText, splitEntity:
+		switch {
+		case b == '<':
+			s.enter(splitOpen)
+		case b == '&':
+			s.state = splitEntity
+			s.run++
+		case s.state == splitEntity && (b == ';' || b == ' ' || b == '\t' || b == '\n' || b == '\r'):
+			s.state = splitText
+			s.run++
+		default:
+			s.run++
+		}
+	case splitOpen:
+		switch b {
+		case '!':
+			s.state = splitBang
+		case '?':
+			s.state = splitPI
+		default:
+			s.enter(splitTag)
+			s.advanceTag(b)
+		}
+	case splitBang:
+		switch {
+		case s.endsWith("<!--"):
+			s.state = splitComment
+		case s.endsWith("<![CDATA["):
+			s.state = splitCDATA
+			s.run = 0
+		case b == '>':
+			s.enter(splitText)
+		case s.since >= len("<![CDATA["):
+			s.state = splitDecl
+		}
+	case splitTag:
+		s.advanceTag(b)
+	case splitDecl:
+		if b == '>' {
+			s.enter(splitText)
+		}
+	case splitPI:
+		if s.since > len("<?") && s.endsWith("?>") {
+			s.enter(splitText)
+		}
+	case splitComment:
+		if s.since > len("<!--") && s.endsWith("-->") {
+			s.enter(splitText)
+		}
+	case splitCDATA:
+		if s.endsWith("]]>") {
+			s.enter(splitText)
+		} else {
+			s.run++
+		}
+	}
+}
+
+// advanceTag は、タグの中のバイト b で状態を進めます。属性値の中の ">" ではタグを終えません。
+func (s *textSplitter) advanceTag(b byte) {
+	switch {
+	case s.quote != 0:
+		if b == s.quote {
+			s.quote = 0
+		}
+	case b == '"' || b == '\'':
+		s.quote = b
+	case b == '>':
+		s.enter(splitText)
+	}
+}
+
+// enter は、新しい構文の先頭に移ります。
+func (s *textSplitter) enter(state splitState) {
+	s.state = state
+	s.since = 1
+	s.quote = 0
+	s.run = 0
+}
+
+// endsWith は、直近に読み込んだバイト列が suffix (8バイト以下、または "<![CDATA[") で終わるかどうかを判定します。
+func (s *textSplitter) endsWith(suffix string) bool {
+	if suffix == "<![CDATA[" {
+		// 先頭の "<" は recent に収まらないため、残りの8バイトと構文の先頭からの長さで判定する
+		return s.since == len(suffix) && s.endsWith("![CDATA[")
+	}
+	var v uint64
+	for i := 0; i < len(suffix); i++ {
+		v = v<<8 | uint64(suffix[i])
+	}
+	mask := ^uint64(0)
+	if len(suffix) < 8 {
+		mask = uint64(1)<<(8*len(suffix)) - 1
+	}
+	return s.recent&mask == v
+}
+
+// position は、デコーダでの位置 (区切りを含む) を入力での位置に補正します。
+// offset は単調に増えるため、補正に使った区切りは取り除きます。
+func (s *textSplitter) position(offset int64, line, column int) (int64, int) {
+	for len(s.splits) > 0 && s.splits[0].end <= offset {
+		split := s.splits[0]
+		s.splits = s.splits[1:]
+		s.shift += int64(len(textSplitMarker))
+		if split.line != s.colLine {
+			s.colLine, s.colShift = split.line, 0
+		}
+		s.colShift += len(textSplitMarker)
+	}
+	if line == s.colLine {
+		column -= s.colShift
+	}
+	return offset - s.shift, column
+}
+
+// rawTextSplit は、デコーダの段で分割された raw_tags の要素のテキストを処理している間の状態です。
+type rawTextSplit struct {
+	// raw は、要素が raw_tags であるかどうかです。raw_tags でなくなっていれば、つなぎ直してから処理します。
+	raw bool
+	// buf は、つなぎ直すテキスト、または最初の空白以外の部分まで保留している空白です。
+	buf []byte
+	// started は、空白以外の部分を処理したかどうか、opened はCDATAセクションを書き出し始めたかどうかです。
+	started bool
+	opened  bool
+	// cdataCarry は、cdata_rules ごとの、次の部分と合わせて置換する前の部分の末尾です。
+	cdataCarry []string
+	// tail は、次の部分と合わせて "]]>" を判定する、書き出していない末尾の "]" です。
+	tail string
+}
+
+// handleDecoded は、デコーダの段から受け取ったトークンを処理します。
+// 分割されたテキストは、要素が raw_tags であれば分割したまま処理してCDATAセクションを続けて書き出し、
+// そうでなければ (名前の置換で raw_tags でなくなった場合など) つなぎ直してから処理します。
+func (p *Processor) handleDecoded(d decodedToken) error {
+	cd, ok := d.token.(xml.CharData)
+	if !ok || (!d.more && p.split == nil) {
+		return p.handleToken(d.token)
+	}
+	if p.split == nil {
+		p.split = &rawTextSplit{raw: p.skipDepth > 0 || len(p.elementStack) > 0 && p.rawTagMap[p.elementStack[len(p.elementStack)-1].Start.Name.Local]}
+	}
+	st := p.split
+	if !st.raw {
+		st.buf = append(st.buf, cd...)
+		if d.more {
+			return nil
+		}
+		p.split = nil
+		return p.handleToken(xml.CharData(st.buf))
+	}
+	p.textMore = d.more
+	err := p.handleToken(cd)
+	if d.more || err != nil {
+		return err
+	}
+	p.split = nil
+	return st.closeCDATA(p.encoder)
+}
+
+// splitCharData は、分割されたテキストの空白を扱います。最初の空白以外の部分までは空白を保留し、
+// すべて空白であれば分割されていないテキストと同様に破棄します。処理する部分と、処理するかどうかを返します。
+func (st *rawTextSplit) splitCharData(cd xml.CharData) (xml.CharData, bool) {
+	if st.started {
+		return cd, true
+	}
+	if len(bytes.TrimSpace(cd)) == 0 {
+		st.buf = append(st.buf, cd...)
+		return nil, false
+	}
+	st.started = true
+	cd = append(st.buf, cd...)
+	st.buf = nil
+	return cd, true
+}
+
+// writeCDATA は、分割されたテキストの部分 data を、1つのCDATAセクションの続きとして書き出します。
+// セクションは最後の部分を処理した後に閉じます。
+func (st *rawTextSplit) writeCDATA(enc *tokenEncoder, data string) error {
+	data = st.tail + data
+	// "]]>" が次の部分にまたがる可能性があるため、末尾の "]" (2つまで) は次の部分と合わせて書き出す
+	n := len(data) - len(strings.TrimRight(data, "]"))
+	if n > 2 {
+		n = 2
+	}
+	data, st.tail = data[:len(data)-n], data[len(data)-n:]
+	if err := enc.closePending(); err != nil {
+		return err
+	}
+	st.opened = enc.writeCDATAContent(splitCDATAEnd(data), st.opened)
+	return nil
+}
+
+// closeCDATA は、書き出していない末尾を書き出して、CDATAセクションを閉じます。
+// 数値文字参照で出力する位置では、ASCII以外の文字で終わって閉じたセクションの後に、末尾だけのセクションを書き出します。
+func (st *rawTextSplit) closeCDATA(enc *tokenEncoder) error {
+	if st.tail != "" {
+		st.opened = enc.writeCDATAContent(st.tail, st.opened)
+	}
+	if !st.opened {
+		return nil
+	}
+	return enc.WriteUnescaped("]]>")
+}
+
+// replaceSplit は、分割されたテキストの部分 data に、carry (前の部分の末尾) を合わせて old を new に置換します。
+// 続き (more) がある場合は、次の部分にまたがって old に一致する可能性がある末尾を置換せず、次の carry として返します。
+// すべての部分を通して strings.ReplaceAll と同じ結果になります。
+func replaceSplit(carry, data, old, new string, more bool) (replaced, nextCarry string, n int) {
+	s := carry + data
+	if !more {
+		n = strings.Count(s, old)
+		return strings.ReplaceAll(s, old, new), "", n
+	}
+	// 末尾の len(old)-1 バイトより前から始まる一致は、この部分の中で完結する
+	limit := len(s) - len(old) + 1
+	var b strings.Builder
+	i := 0
+	for i < limit {
+		j := strings.Index(s[i:], old)
+		if j < 0 || i+j >= limit {
+			break
+		}
+		b.WriteString(s[i : i+j])
+		b.WriteString(new)
+		n++
+		i += j + len(old)
+	}
+	if i < limit {
+		b.WriteString(s[i:limit])
+		i = limit
+	}
+	return b.String(), s[i:], n
+}
+
+// checkSplitMarker は、テキストの途中に差し込んだ区切りのトークンが、空のCDATAセクションであることを確認します。
+func checkSplitMarker(token xml.Token) error {
+	if cd, ok := token.(xml.CharData); !ok || len(cd) > 0 {
+		return fmt.Errorf("unexpected token %T after splitting raw text", token)
+	}
+	return nil
+}
+
+// splitCharsetReader は、デコーダが入力エンコーディングを変換する場合に s での分割を止めるよう、
+// charset を包んだ CharsetReader を返します。
+func splitCharsetReader(s *textSplitter, charset func(string, io.Reader) (io.Reader, error)) func(string, io.Reader) (io.Reader, error) {
+	return func(label string, input io.Reader) (io.Reader, error) {
+		r, err := charset(label, input)
+		if r != input {
+			s.disabled = true
+		}
+		return r, err
+	}
+}
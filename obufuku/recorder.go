@@ -0,0 +1,48 @@
+package obufuku
+
+import (
+	"fmt"
+	"io"
+)
+
+// spanRecorder は、読み込んだ入力バイト列を保持し、入力オフセットの範囲を指定して
+// 元のバイト列を取り出せるようにするReaderです。最小変更モードで、ルールの影響を
+// 受けないトークンを入力そのままに出力するために使います。
+type spanRecorder struct {
+	r    io.Reader
+	buf  []byte
+	base int64 // buf[0] の入力オフセット
+}
+
+// newSpanRecorder は、r を読み込みながら記録する新しいspanRecorderを作成します。
+func newSpanRecorder(r io.Reader) *spanRecorder {
+	return &spanRecorder{r: r}
+}
+
+// Read は io.Reader インターフェースを実装します。
+func (s *spanRecorder) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.buf = append(s.buf, p[:n]...)
+	return n, err
+}
+
+// Span は、入力オフセット start から end までの元のバイト列を返します。
+func (s *spanRecorder) Span(start, end int64) ([]byte, error) {
+	if start < s.base || end < start || end-s.base > int64(len(s.buf)) {
+		return nil, fmt.Errorf("input span %d-%d is not available", start, end)
+	}
+	return s.buf[start-s.base : end-s.base], nil
+}
+
+// Discard は、入力オフセット offset より前の記録を破棄してメモリを解放します。
+func (s *spanRecorder) Discard(offset int64) {
+	if offset <= s.base {
+		return
+	}
+	n := offset - s.base
+	if n > int64(len(s.buf)) {
+		n = int64(len(s.buf))
+	}
+	s.buf = append(s.buf[:0], s.buf[n:]...)
+	s.base += n
+}
@@ -0,0 +1,167 @@
+package obufuku
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ValueFuncFactory は、ルールファイルの値置換ルールのパラメータ (params) から
+// 値の置換関数を作成する関数です。パラメータが不正な場合はエラーを返します。
+type ValueFuncFactory func(params map[string]interface{}) (ValueReplaceFunc, error)
+
+// ValueContextFuncFactory は、ValueFuncFactory と同じくパラメータから置換関数を作成しますが、
+// 対象要素の情報を参照でき、置換に失敗した場合にエラーを返せる関数を作成します。
+type ValueContextFuncFactory func(params map[string]interface{}) (ValueContextFunc, error)
+
+// valueFuncFactory は、登録された値置換の種類の作成関数です (いずれか一方が設定されます)。
+type valueFuncFactory struct {
+	plain       ValueFuncFactory
+	withContext ValueContextFuncFactory
+}
+
+var (
+	valueFuncsMu sync.RWMutex
+	valueFuncs   = map[string]valueFuncFactory{
+		"prepend":       {withContext: newPrependFunc},
+		"append":        {withContext: newAppendFunc},
+		"html_unescape": {plain: newHTMLUnescapeFunc},
+		"html_escape":   {plain: newHTMLEscapeFunc},
+		"date":          {withContext: newDateFunc},
+		"kana":          {plain: newKanaFunc},
+		"unicode":       {plain: newUnicodeFunc},
+		"base64_encode": {plain: newBase64EncodeFunc},
+		"base64_decode": {withContext: newBase64DecodeFunc},
+	}
+)
+
+// 値置換で値を変換できなかった場合の扱い (params の on_error) です。
+const (
+	// onErrorError は、変換を中断してエラーにします (既定)。
+	onErrorError = "error"
+	// onErrorKeep は、値をそのまま残します。
+	onErrorKeep = "keep"
+)
+
+// parseOnError は、値を変換できなかった場合の扱い onError を検証し、値をそのまま残すかどうかを返します。
+func parseOnError(onError string) (keep bool, err error) {
+	switch onError {
+	case "", onErrorError:
+		return false, nil
+	case onErrorKeep:
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown on_error '%s' (available: %s, %s)", onError, onErrorError, onErrorKeep)
+}
+
+// RegisterValueFunc は、ルールファイルの value_rules で "type" に指定できる値置換の種類を登録します。
+// 組み込みの種類と同じく、ルールセットの組み立て時に factory が params を受け取って置換関数を作成します。
+// 名前が空か既に登録済みの場合、または factory が nil の場合は panic します。
+// 通常はパッケージの init 関数から呼び出します。
+func RegisterValueFunc(name string, factory ValueFuncFactory) {
+	if factory == nil {
+		panic("obufuku: RegisterValueFunc requires a non-nil factory")
+	}
+	if err := registerValueFunc(name, valueFuncFactory{plain: factory}); err != nil {
+		panic("obufuku: " + err.Error())
+	}
+}
+
+// RegisterValueContextFunc は、RegisterValueFunc と同じく値置換の種類を登録します。
+// 作成される置換関数は対象要素の情報を受け取り、失敗した場合はエラーで変換処理を中断できます。
+func RegisterValueContextFunc(name string, factory ValueContextFuncFactory) {
+	if factory == nil {
+		panic("obufuku: RegisterValueContextFunc requires a non-nil factory")
+	}
+	if err := registerValueFunc(name, valueFuncFactory{withContext: factory}); err != nil {
+		panic("obufuku: " + err.Error())
+	}
+}
+
+// registerValueFunc は、名前を検査して値置換の種類を登録します。
+func registerValueFunc(name string, factory valueFuncFactory) error {
+	valueFuncsMu.Lock()
+	defer valueFuncsMu.Unlock()
+	if name == "" {
+		return fmt.Errorf("value rule type name must not be empty")
+	}
+	if _, dup := valueFuncs[name]; dup || name == scriptValueType {
+		return fmt.Errorf("value rule type '%s' is registered twice", name)
+	}
+	valueFuncs[name] = factory
+	return nil
+}
+
+// ValueFuncs は、値置換ルールで使える種類 (組み込みの script を含む) の名前を昇順で返します。
+func ValueFuncs() []string {
+	valueFuncsMu.RLock()
+	defer valueFuncsMu.RUnlock()
+	names := []string{scriptValueType}
+	for name := range valueFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupValueFunc は、登録済みの値置換の種類を探します。
+func lookupValueFunc(name string) (valueFuncFactory, bool) {
+	valueFuncsMu.RLock()
+	defer valueFuncsMu.RUnlock()
+	factory, ok := valueFuncs[name]
+	return factory, ok
+}
+
+// newPrependFunc は、値の先頭に params["prefix"] を付ける置換関数を作成します。
+// prefix のプレースホルダー ({{now}} など) は、置換するたびに生成した値に置き換えます。
+// {{var "名前"}} は、capture_rules で取り込んだ変数の値に置き換えます。
+func newPrependFunc(params map[string]interface{}) (ValueContextFunc, error) {
+	prefix, ok := params["prefix"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid or missing 'prefix' for prepend rule")
+	}
+	text, err := placeholderText(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'prefix' for prepend rule: %w", err)
+	}
+	return func(oldValue string, ctx ValueContext) (string, error) {
+		return text(ctx.Variables) + oldValue, nil
+	}, nil
+}
+
+// newAppendFunc は、値の末尾に params["suffix"] を付ける置換関数を作成します。
+// suffix のプレースホルダーは、newPrependFunc の prefix と同じく置き換えます。
+func newAppendFunc(params map[string]interface{}) (ValueContextFunc, error) {
+	suffix, ok := params["suffix"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid or missing 'suffix' for append rule")
+	}
+	text, err := placeholderText(suffix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'suffix' for append rule: %w", err)
+	}
+	return func(oldValue string, ctx ValueContext) (string, error) {
+		return oldValue + text(ctx.Variables), nil
+	}, nil
+}
+
+// placeholderText は、プレースホルダーがあれば生成した値に置き換えた s を返す関数を作成します。
+func placeholderText(s string) (func(vars map[string]string) string, error) {
+	ps, err := parsePlaceholders(s, nil)
+	if err != nil || ps == nil {
+		return func(map[string]string) string { return s }, err
+	}
+	return func(vars map[string]string) string { return ps.expandText(s, vars) }, nil
+}
+
+// PluginRegistrar は、CLIが読み込むプラグインに渡される、独自の処理の登録先です。
+// プラグインは、この型を引数に取る関数をシンボル PluginSymbol として公開します。
+type PluginRegistrar interface {
+	// RegisterValueFunc は、RegisterValueFunc と同じく値置換の種類を登録します。
+	// 登録済みの名前の場合は panic せず、プラグインの読み込みエラーとして扱われます。
+	RegisterValueFunc(name string, factory ValueFuncFactory)
+}
+
+// PluginSymbol は、プラグインが公開する登録関数のシンボル名です。
+// 関数の型は func(obufuku.PluginRegistrar) error です。
+const PluginSymbol = "ObuFukuRegister"
@@ -0,0 +1,275 @@
+package obufuku
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TransformResult は、変換処理の結果の概要です。
+type TransformResult struct {
+	// Elements は、入力から読み込んだ要素の数です。
+	Elements int
+	// RuleHits は、ルールが適用された回数です。キーはルールファイルでの位置 ("value_rules[2]" など) で、
+	// 一度も適用されなかったルールは含みません。
+	RuleHits map[string]int
+	// RuleDescriptions は、ルールファイルで説明 (description) が設定されたルールの説明です。
+	// キーは RuleHits と同じで、適用されなかったルールも含みます。
+	RuleDescriptions map[string]string
+	// Warnings は、処理を続けられる問題についての警告です。
+	Warnings []string
+	// BytesRead は、入力から読み込んだバイト数です。
+	// Processor.Run では入力エンコーディングの変換後、RuleSet.Transform では変換前のバイト数です。
+	BytesRead int64
+	// BytesWritten は、出力に書き込んだバイト数です。
+	// Processor.Run ではUTF-8のまま、RuleSet.Transform では出力エンコーディングの変換後のバイト数です。
+	BytesWritten int64
+}
+
+// ルールの種類ごとの、ルールファイルでの項目名です。
+const (
+	hitNameRules             = "name_rules"
+	hitInsertRules           = "insert_rules"
+	hitInsertAfterRules      = "insert_after_rules"
+	hitPrependChildRules     = "prepend_child_rules"
+	hitValueRules            = "value_rules"
+	hitDateRules             = "date_rules"
+	hitUnicodeRules          = "unicode_rules"
+	hitWrapRules             = "wrap_rules"
+	hitCdataRules            = "cdata_rules"
+	hitCaptureRules          = "capture_rules"
+	hitSummaryRules          = "summary_rules"
+	hitDeleteRules           = "delete_rules"
+	hitDedupeRules           = "dedupe_rules"
+	hitReorderRules          = "reorder_rules"
+	hitAssertRules           = "assert_rules"
+	hitCaseRules             = "case_rules"
+	hitPrefixRules           = "prefix_rules"
+	hitNamespaceRules        = "namespace_rules"
+	hitRootRules             = "root_rules"
+	hitSchemaLocationRules   = "schema_location_rules"
+	hitNilRules              = "nil_rules"
+	hitDefaultNamespaceRules = "default_namespace_rules"
+	hitAttrCleanupRules      = "attr_cleanup_rules"
+	hitAttrDeleteRules       = "attr_delete_rules"
+)
+
+// RuleHitNames は、RuleHits のキーを昇順で返します。
+func (r TransformResult) RuleHitNames() []string {
+	names := make([]string, 0, len(r.RuleHits))
+	for name := range r.RuleHits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Add は、複数の文書を変換した結果の合計として、other の件数、ルールの適用回数・説明と警告を r に加えます。
+// 警告には、どの文書の警告かを表す prefix を付けます。
+func (r *TransformResult) Add(other TransformResult, prefix string) {
+	r.Elements += other.Elements
+	r.BytesRead += other.BytesRead
+	r.BytesWritten += other.BytesWritten
+	for name, hits := range other.RuleHits {
+		if r.RuleHits == nil {
+			r.RuleHits = make(map[string]int)
+		}
+		r.RuleHits[name] += hits
+	}
+	for name, description := range other.RuleDescriptions {
+		if r.RuleDescriptions == nil {
+			r.RuleDescriptions = make(map[string]string)
+		}
+		r.RuleDescriptions[name] = description
+	}
+	for _, warning := range other.Warnings {
+		r.Warnings = append(r.Warnings, prefix+warning)
+	}
+}
+
+// hit は、種類 kind の i 番目のルールが適用されたことを記録します。
+func (p *Processor) hit(kind string, i int) {
+	if p.hits == nil {
+		p.hits = make(map[string][]int)
+	}
+	counts := p.hits[kind]
+	for len(counts) <= i {
+		counts = append(counts, 0)
+	}
+	counts[i]++
+	p.hits[kind] = counts
+}
+
+// targetSeen は、種類 kind の i 番目のルールの対象が現れたことを記録します。
+// 対象が現れても条件に当てはまらず何も変えなかったルール (パターンに一致しなかった削除など) を、
+// 一度も適用されなかったルールとして警告しないために使います。
+func (p *Processor) targetSeen(kind string, i int) {
+	if p.seen == nil {
+		p.seen = make(map[string][]bool)
+	}
+	seen := p.seen[kind]
+	for len(seen) <= i {
+		seen = append(seen, false)
+	}
+	seen[i] = true
+	p.seen[kind] = seen
+}
+
+// warn は、警告を記録します。
+func (p *Processor) warn(format string, args ...interface{}) {
+	p.warnings = append(p.warnings, fmt.Sprintf(format, args...))
+}
+
+// warnUnmatchedRules は、一度も適用されず、対象も現れなかったルールごとに警告を記録します。
+// 対象のタグ名の誤りなどで何もしないルールに気付けるよう、正常に処理を終えたときに呼び出します。
+func (p *Processor) warnUnmatchedRules() {
+	unmatched := func(kind string, i int, target string) {
+		if counts := p.hits[kind]; i < len(counts) && counts[i] > 0 {
+			return
+		}
+		if seen := p.seen[kind]; i < len(seen) && seen[i] {
+			return
+		}
+		p.warn("%s[%d] (%s) never matched", kind, i, target)
+	}
+	for i, rule := range p.nameRules {
+		unmatched(hitNameRules, i, fmt.Sprintf("tag '%s'", rule.OldName))
+	}
+	for i, rule := range p.insertRules {
+		unmatched(hitInsertRules, i, rule.describe())
+	}
+	for i, rule := range p.insertAfterRules {
+		unmatched(hitInsertAfterRules, i, rule.describe())
+	}
+	for i, rule := range p.prependChildRules {
+		unmatched(hitPrependChildRules, i, rule.describe())
+	}
+	for i, rule := range p.valueRules {
+		unmatched(hitValueRules, i, fmt.Sprintf("target '%s'", rule.TargetTag))
+	}
+	wrapTargets := make([]string, p.wrapRuleCount)
+	for target, wrap := range p.wrapRuleMap {
+		wrapTargets[wrap.index] = target
+	}
+	for i, target := range wrapTargets {
+		// 同じ対象のルールが複数ある場合、後のルールで上書きされたルールは対象を持たない
+		if target == "" {
+			p.warn("%s[%d] is overridden by a later rule for the same target", hitWrapRules, i)
+			continue
+		}
+		unmatched(hitWrapRules, i, fmt.Sprintf("target '%s'", target))
+	}
+	for i, rule := range p.cdataRules {
+		if rule.Entities != "" {
+			unmatched(hitCdataRules, i, fmt.Sprintf("entities '%s'", rule.Entities))
+			continue
+		}
+		unmatched(hitCdataRules, i, fmt.Sprintf("text '%s'", rule.Old))
+	}
+	for i, rule := range p.captureRules {
+		unmatched(hitCaptureRules, i, fmt.Sprintf("target '%s'", rule.TargetTag))
+	}
+	for i, rule := range p.summaryRules {
+		unmatched(hitSummaryRules, i, fmt.Sprintf("target '%s'", rule.TargetTag))
+	}
+	for i, rule := range p.deleteRules {
+		unmatched(hitDeleteRules, i, fmt.Sprintf("target '%s'", rule.TargetTag))
+	}
+	for i, rule := range p.dedupeRules {
+		unmatched(hitDedupeRules, i, fmt.Sprintf("parent '%s'", rule.ParentTag))
+	}
+	for i, rule := range p.reorderRules {
+		unmatched(hitReorderRules, i, fmt.Sprintf("target '%s'", rule.TargetTag))
+	}
+	for i, rule := range p.assertRules {
+		unmatched(hitAssertRules, i, fmt.Sprintf("target '%s'", rule.TargetTag))
+	}
+	for i, rule := range p.caseRules {
+		target := "all tags"
+		if rule.Under != "" {
+			target = fmt.Sprintf("under '%s'", rule.Under)
+		}
+		unmatched(hitCaseRules, i, target)
+	}
+	for i, rule := range p.prefixRules {
+		unmatched(hitPrefixRules, i, fmt.Sprintf("prefix '%s'", rule.Old))
+	}
+	for i, rule := range p.namespaceRules {
+		unmatched(hitNamespaceRules, i, fmt.Sprintf("namespace '%s'", rule.Old))
+	}
+	for i, rule := range p.dateRules {
+		unmatched(hitDateRules, i, fmt.Sprintf("tags '%s'", strings.Join(rule.TargetTags, "', '")))
+	}
+	for i, rule := range p.unicodeRules {
+		target := "all elements"
+		if rule.TargetTag != "" {
+			target = fmt.Sprintf("tag '%s'", rule.TargetTag)
+		}
+		unmatched(hitUnicodeRules, i, target)
+	}
+	for i, rule := range p.rootRules {
+		target := "any root"
+		if rule.TargetTag != "" {
+			target = fmt.Sprintf("root '%s'", rule.TargetTag)
+		}
+		unmatched(hitRootRules, i, target)
+	}
+	for i, rule := range p.schemaLocationRules {
+		target := "root"
+		if rule.TargetTag != "" {
+			target = fmt.Sprintf("tag '%s'", rule.TargetTag)
+		}
+		unmatched(hitSchemaLocationRules, i, target)
+	}
+	for i, rule := range p.nilRules {
+		unmatched(hitNilRules, i, fmt.Sprintf("tag '%s'", rule.TargetTag))
+	}
+	for i, rule := range p.defaultNamespaceRules {
+		target := "any root"
+		if rule.TargetTag != "" {
+			target = fmt.Sprintf("root '%s'", rule.TargetTag)
+		}
+		unmatched(hitDefaultNamespaceRules, i, target)
+	}
+	for i, rule := range p.attrCleanupRules {
+		target := "all tags"
+		if rule.TargetTag != "" {
+			target = fmt.Sprintf("target '%s'", rule.TargetTag)
+		}
+		unmatched(hitAttrCleanupRules, i, target)
+	}
+	for i, rule := range p.attrDeleteRules {
+		target := "all tags"
+		if rule.TargetTag != "" {
+			target = fmt.Sprintf("target '%s'", rule.TargetTag)
+		}
+		unmatched(hitAttrDeleteRules, i, fmt.Sprintf("attributes '%s' on %s", strings.Join(rule.Patterns, "', '"), target))
+	}
+}
+
+// result は、これまでの処理の結果を返します。
+func (p *Processor) result() TransformResult {
+	result := TransformResult{
+		Elements:     p.elements,
+		Warnings:     p.warnings,
+		BytesRead:    p.bytesRead + p.offset,
+		BytesWritten: p.counter.n,
+	}
+	for kind, counts := range p.hits {
+		for i, n := range counts {
+			if n > 0 {
+				if result.RuleHits == nil {
+					result.RuleHits = make(map[string]int)
+				}
+				result.RuleHits[fmt.Sprintf("%s[%d]", kind, i)] = n
+			}
+		}
+	}
+	if len(p.descriptions) > 0 {
+		result.RuleDescriptions = make(map[string]string, len(p.descriptions))
+		for name, description := range p.descriptions {
+			result.RuleDescriptions[name] = description
+		}
+	}
+	return result
+}
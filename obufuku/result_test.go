@@ -0,0 +1,105 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUnmatchedRuleWarnings(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		warnings []string
+	}{
+		{"all rules matched", Config{NameRules: []ConfigNameRule{{Old: "b", New: "c"}}, ValueRules: []ConfigValueRule{{Target: "c", Type: "append", Params: params{"suffix": "!"}}}}, nil},
+		{"misspelled tag", Config{NameRules: []ConfigNameRule{{Old: "b", New: "c"}, {Old: "bb", New: "x"}}}, []string{"name_rules[1] (tag 'bb') never matched"}},
+		{"missing targets", Config{
+			InsertRules:       []ConfigInsertRule{{Target: "x", Template: "<n/>"}},
+			InsertAfterRules:  []ConfigInsertRule{{Target: "b", Template: "<n/>"}, {Target: "y", Template: "<n/>"}},
+			PrependChildRules: []ConfigInsertRule{{Target: "z", Template: "<n/>"}},
+			ValueRules:        []ConfigValueRule{{Target: "v", Type: "append", Params: params{"suffix": "!"}}},
+		}, []string{"insert_rules[0] (target 'x') never matched", "insert_after_rules[1] (target 'y') never matched", "prepend_child_rules[0] (target 'z') never matched", "value_rules[0] (target 'v') never matched"}},
+		{"cdata text", Config{RawTags: []string{"r"}, CdataRules: []ConfigCdataRule{{Old: "x", New: "y"}, {Old: "none", New: "y"}}}, []string{"cdata_rules[1] (text 'none') never matched"}},
+		// 対象が現れれば、条件に当てはまらず何も変えなかったルールも警告しない
+		{"targets present without changes", Config{
+			RawTags:           []string{"r"},
+			CdataRules:        []ConfigCdataRule{{Entities: "unescape"}},
+			DeleteRules:       []ConfigDeleteRule{{Target: "b", Pattern: "^z$"}},
+			DedupeRules:       []ConfigDedupeRule{{Parent: "a"}},
+			NilRules:          []ConfigNilRule{{Target: "b", Mode: "add"}},
+			PrependChildRules: []ConfigInsertRule{{Target: "a", Template: "<n/>", IfMissing: "b"}},
+			DateRules:         []ConfigDateRule{{Targets: []string{"b"}, OnError: "keep"}},
+			CaseRules:         []ConfigCaseRule{{Case: "lower"}},
+			UnicodeRules:      []ConfigUnicodeRule{{Target: "b", Form: "NFC"}},
+			AttrCleanupRules:  []ConfigAttrCleanupRule{{Target: "b"}},
+		}, nil},
+		{"conditional rules with missing targets", Config{
+			DeleteRules:       []ConfigDeleteRule{{Target: "x", Pattern: "^z$"}},
+			DedupeRules:       []ConfigDedupeRule{{Parent: "x"}},
+			NilRules:          []ConfigNilRule{{Target: "x", Mode: "add"}},
+			PrependChildRules: []ConfigInsertRule{{Target: "x", Template: "<n/>", IfMissing: "b"}},
+			DateRules:         []ConfigDateRule{{Targets: []string{"x"}, OnError: "keep"}},
+		}, []string{"prepend_child_rules[0] (target 'x') never matched", "delete_rules[0] (target 'x') never matched", "dedupe_rules[0] (parent 'x') never matched", "date_rules[0] (tags 'x') never matched", "nil_rules[0] (tag 'x') never matched"}},
+		{"passes", Config{Passes: []Config{{NameRules: []ConfigNameRule{{Old: "q", New: "c"}}}}}, []string{"pass 1: name_rules[0] (tag 'q') never matched"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Output = compactOutput
+			_, result := transformString(t, tt.cfg, `<a><b>t</b><r>x</r></a>`)
+			if !reflect.DeepEqual(result.Warnings, tt.warnings) {
+				t.Errorf("warnings = %q, want %q", result.Warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestRuleDescriptions(t *testing.T) {
+	cfg := Config{
+		NameRules:  []ConfigNameRule{{Old: "b", New: "c", Description: "new schema"}},
+		ValueRules: []ConfigValueRule{{Target: "c", Type: "append", Params: params{"suffix": "!"}}, {Target: "x", Type: "append", Params: params{"suffix": "?"}, Description: "unused"}},
+		Passes:     []Config{{DeleteRules: []ConfigDeleteRule{{Target: "c", Description: "drop"}}}},
+		Output:     compactOutput,
+	}
+	rs, err := NewRuleSet(cfg)
+	if err != nil {
+		t.Fatalf("NewRuleSet: %v", err)
+	}
+	var mu sync.Mutex
+	events := make(map[string]string)
+	rs.AddObserver(ObserverFunc(func(e RuleEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events[e.Rule] = e.Description
+	}))
+	result, err := rs.Transform(context.Background(), strings.NewReader(`<a><b>x</b></a>`), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	want := map[string]string{"name_rules[0]": "new schema", "value_rules[1]": "unused", "passes[0].delete_rules[0]": "drop"}
+	if !reflect.DeepEqual(result.RuleDescriptions, want) {
+		t.Errorf("RuleDescriptions = %q, want %q", result.RuleDescriptions, want)
+	}
+	wantEvents := map[string]string{"name_rules[0]": "new schema", "value_rules[0]": "", "passes[0].delete_rules[0]": "drop"}
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Errorf("event descriptions = %q, want %q", events, wantEvents)
+	}
+}
+
+// TestRuleDescriptionFields は、ルールの説明を集められるよう、"_rules" で終わる設定の項目の要素が
+// すべて Description を持つことを確かめます。
+func TestRuleDescriptionFields(t *testing.T) {
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !strings.HasSuffix(strings.Split(field.Tag.Get("json"), ",")[0], "_rules") {
+			continue
+		}
+		if _, ok := field.Type.Elem().FieldByName("Description"); !ok {
+			t.Errorf("%s has no Description", field.Type.Elem().Name())
+		}
+	}
+}
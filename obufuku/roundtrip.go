@@ -0,0 +1,321 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// 往復検証で報告する差異の種類です。
+const (
+	DiffNewline      = "newline"       // 改行コードの変更
+	DiffDeclaration  = "declaration"   // XML宣言の変更・追加・削除
+	DiffWhitespace   = "whitespace"    // 空白のみのテキストや、テキスト中の空白の変更
+	DiffEmptyElement = "empty-element" // <a/> と <a></a> の書き方の変更
+	DiffEntity       = "entity"        // 文字参照・実体参照・CDATA区間など、値が同じで書き方の異なるテキスト
+	DiffAttribute    = "attribute"     // 属性の順序、引用符、空白、エスケープの変更
+	DiffTag          = "tag"           // 終了タグの書き方の変更
+	DiffComment      = "comment"       // コメント・処理命令・DOCTYPE宣言の変更・追加・削除
+	DiffText         = "text"          // テキストの値の変更
+	DiffMarkup       = "markup"        // 要素の構造の変更
+)
+
+// RoundTripDifference は、ルールを適用せずに変換した出力と入力との差異です。
+type RoundTripDifference struct {
+	// Line は、差異のある入力の行 (1始まり) です。
+	Line int
+	// Kind は、差異の種類 (DiffWhitespace など) です。
+	Kind string
+	// Message は、差異の内容です。
+	Message string
+}
+
+func (d RoundTripDifference) String() string {
+	return fmt.Sprintf("%d: %s: %s", d.Line, d.Kind, d.Message)
+}
+
+// VerifyRoundTrip は、このルールセットの入出力設定のまま、ルールを1つも適用せずに r を変換し、
+// 入力と出力の差異を入力の順に返します。何も変更しない設定でも出力が入力とどう異なるか
+// (空白の削除、空要素の書き方、文字参照の展開など) を確認するための自己検査です。
+// 入力と出力はメモリ上に読み込み、どちらもUTF-8・LF改行に揃えてからトークン単位で比較します。
+func (rs *RuleSet) VerifyRoundTrip(ctx context.Context, r io.Reader) ([]RoundTripDifference, error) {
+	input, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	identity := &RuleSet{Input: rs.Input, Output: rs.Output, OutputEncoding: rs.OutputEncoding}
+	var output bytes.Buffer
+	if _, err := identity.Transform(ctx, bytes.NewReader(input), &output); err != nil {
+		return nil, err
+	}
+
+	// 比較のため、入力と出力をUTF-8に揃える
+	inputEncoding := rs.Input.Encoding
+	if inputEncoding == "" {
+		inputEncoding = autoEncoding
+	}
+	inputText, err := decodeAll(NewInputReader(bytes.NewReader(input), inputEncoding))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode input: %w", err)
+	}
+	var outputReader io.Reader = &output
+	if rs.OutputEncoding != nil {
+		outputReader = rs.OutputEncoding.NewDecoder().Reader(outputReader)
+	}
+	outputText, err := decodeAll(outputReader, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode output: %w", err)
+	}
+
+	var diffs []RoundTripDifference
+	if from, to := newlineStyle(inputText), newlineStyle(outputText); from != to {
+		diffs = append(diffs, RoundTripDifference{Line: 1, Kind: DiffNewline, Message: fmt.Sprintf("line endings changed from %s to %s", from, to)})
+	}
+	inputTokens, err := scanRoundTripTokens(inputText, rs.Input.Decoder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse input: %w", err)
+	}
+	outputTokens, err := scanRoundTripTokens(outputText, rs.Input.Decoder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse output: %w", err)
+	}
+	return append(diffs, compareRoundTripTokens(inputTokens, outputTokens)...), nil
+}
+
+// decodeAll は、r をすべて読み込みます。err は r を作成したときのエラーです。
+func decodeAll(r io.Reader, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// newlineStyle は、テキストで使われている改行コードの名前を返します。
+func newlineStyle(text []byte) string {
+	crlf := bytes.Count(text, []byte("\r\n"))
+	lf := bytes.Count(text, []byte("\n"))
+	switch {
+	case lf == 0:
+		return "none"
+	case crlf == lf:
+		return "CRLF"
+	case crlf == 0:
+		return "LF"
+	}
+	return "mixed"
+}
+
+// roundTripToken は、比較のために読み込んだトークンと、その入力上の表記と行です。
+// 連続する文字データ (テキストとCDATA区間) は1つにまとめます。
+type roundTripToken struct {
+	token xml.Token
+	raw   string
+	line  int
+}
+
+// scanRoundTripTokens は、UTF-8のXMLを改行をLFに揃えてトークンに分割します。
+func scanRoundTripTokens(text []byte, options DecoderOptions) ([]roundTripToken, error) {
+	text = bytes.ReplaceAll(text, []byte("\r\n"), []byte("\n"))
+	decoder := newDecoder(bytes.NewReader(text), InputOptions{Encoding: autoEncoding, Decoder: options})
+	var tokens []roundTripToken
+	line, counted := 1, int64(0)
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return tokens, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line += bytes.Count(text[counted:start], []byte("\n"))
+		counted = start
+		raw := string(text[start:decoder.InputOffset()])
+		if data, ok := token.(xml.CharData); ok && len(tokens) > 0 {
+			if last, ok := tokens[len(tokens)-1].token.(xml.CharData); ok {
+				tokens[len(tokens)-1].token = append(last, data...)
+				tokens[len(tokens)-1].raw += raw
+				continue
+			}
+		}
+		tokens = append(tokens, roundTripToken{token: xml.CopyToken(token), raw: raw, line: line})
+	}
+}
+
+// compareRoundTripTokens は、入力と出力のトークン列を先頭から対応させ、差異を返します。
+// 対応しないトークンのうち、空白のみのテキスト、コメント、処理命令、DOCTYPE宣言は
+// 追加または削除として読み飛ばし、それ以外は構造の変更として両方を1つずつ進めます。
+func compareRoundTripTokens(in, out []roundTripToken) []RoundTripDifference {
+	var diffs []RoundTripDifference
+	report := func(line int, kind, format string, args ...interface{}) {
+		diffs = append(diffs, RoundTripDifference{Line: line, Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+	i, j := 0, 0
+	for i < len(in) || j < len(out) {
+		// 出力側だけのトークンは、直前の入力トークンの行で報告する
+		line := 1
+		if i < len(in) {
+			line = in[i].line
+		} else if i > 0 {
+			line = in[i-1].line
+		}
+		var a, b *roundTripToken
+		if i < len(in) {
+			a = &in[i]
+		}
+		if j < len(out) {
+			b = &out[j]
+		}
+		switch {
+		case a != nil && b != nil && reflect.TypeOf(a.token) == reflect.TypeOf(b.token) && sameTokenIdentity(a.token, b.token):
+			compareRoundTripToken(a, b, report)
+			i++
+			j++
+		case a != nil && isSkippableToken(a.token):
+			report(line, skippableKind(a.token), "%s removed", abbreviate(a.raw))
+			i++
+		case b != nil && isSkippableToken(b.token):
+			report(line, skippableKind(b.token), "%s added", abbreviate(b.raw))
+			j++
+		case a == nil:
+			report(line, DiffMarkup, "%s added", abbreviate(b.raw))
+			j++
+		case b == nil:
+			report(line, DiffMarkup, "%s removed", abbreviate(a.raw))
+			i++
+		default:
+			report(line, DiffMarkup, "%s written as %s", abbreviate(a.raw), abbreviate(b.raw))
+			i++
+			j++
+		}
+	}
+	return diffs
+}
+
+// sameTokenIdentity は、同じ型のトークンが比較の対象として対応するかを返します。
+// 要素は名前、処理命令は対象名が同じ場合に対応し、その他は常に対応します。
+func sameTokenIdentity(a, b xml.Token) bool {
+	switch a := a.(type) {
+	case xml.StartElement:
+		return a.Name == b.(xml.StartElement).Name
+	case xml.EndElement:
+		return a.Name == b.(xml.EndElement).Name
+	case xml.ProcInst:
+		return a.Target == b.(xml.ProcInst).Target
+	case xml.CharData:
+		// 空白のみのテキストは、空白以外を含むテキストとは対応させない (追加・削除として扱う)
+		return isBlank(a) == isBlank(b.(xml.CharData))
+	}
+	return true
+}
+
+// compareRoundTripToken は、対応する入力と出力のトークンを比較し、差異を report で報告します。
+func compareRoundTripToken(a, b *roundTripToken, report func(line int, kind, format string, args ...interface{})) {
+	if a.raw == b.raw {
+		return
+	}
+	switch t := a.token.(type) {
+	case xml.StartElement:
+		u := b.token.(xml.StartElement)
+		aEmpty, bEmpty := strings.HasSuffix(a.raw, "/>"), strings.HasSuffix(b.raw, "/>")
+		if aEmpty != bEmpty {
+			report(a.line, DiffEmptyElement, "%s written as %s", abbreviate(a.raw), abbreviate(b.raw))
+		}
+		switch {
+		case !sameAttrSet(t.Attr, u.Attr):
+			report(a.line, DiffAttribute, "attributes of <%s> changed: %s written as %s", t.Name.Local, abbreviate(a.raw), abbreviate(b.raw))
+		case !reflect.DeepEqual(t.Attr, u.Attr):
+			report(a.line, DiffAttribute, "attribute order of <%s> changed: %s written as %s", t.Name.Local, abbreviate(a.raw), abbreviate(b.raw))
+		case trimTagEnd(a.raw) != trimTagEnd(b.raw):
+			report(a.line, DiffAttribute, "start tag formatting changed (quotes, spacing or escaping): %s written as %s", abbreviate(a.raw), abbreviate(b.raw))
+		}
+	case xml.EndElement:
+		// 空要素の終了タグは表記を持たないため、開始タグで報告済み
+		if a.raw != "" && b.raw != "" {
+			report(a.line, DiffTag, "%s written as %s", abbreviate(a.raw), abbreviate(b.raw))
+		}
+	case xml.CharData:
+		u := b.token.(xml.CharData)
+		switch {
+		case bytes.Equal(t, u):
+			report(a.line, DiffEntity, "%s written as %s", abbreviate(a.raw), abbreviate(b.raw))
+		case strings.Join(strings.Fields(string(t)), " ") == strings.Join(strings.Fields(string(u)), " "):
+			report(a.line, DiffWhitespace, "whitespace in text changed: %s written as %s", abbreviate(a.raw), abbreviate(b.raw))
+		default:
+			report(a.line, DiffText, "%s written as %s", abbreviate(a.raw), abbreviate(b.raw))
+		}
+	case xml.ProcInst:
+		kind := DiffComment
+		if t.Target == "xml" {
+			kind = DiffDeclaration
+		}
+		report(a.line, kind, "%s written as %s", abbreviate(a.raw), abbreviate(b.raw))
+	case xml.Comment, xml.Directive:
+		report(a.line, DiffComment, "%s written as %s", abbreviate(a.raw), abbreviate(b.raw))
+	}
+}
+
+// isSkippableToken は、対応しない場合に追加・削除として読み飛ばすトークンかを返します。
+func isSkippableToken(token xml.Token) bool {
+	switch t := token.(type) {
+	case xml.CharData:
+		return isBlank(t)
+	case xml.Comment, xml.ProcInst, xml.Directive:
+		return true
+	}
+	return false
+}
+
+// skippableKind は、読み飛ばすトークンの差異の種類を返します。
+func skippableKind(token xml.Token) string {
+	switch t := token.(type) {
+	case xml.CharData:
+		return DiffWhitespace
+	case xml.ProcInst:
+		if t.Target == "xml" {
+			return DiffDeclaration
+		}
+	}
+	return DiffComment
+}
+
+// isBlank は、文字データが空白のみかを返します。
+func isBlank(data xml.CharData) bool {
+	return len(bytes.TrimSpace(data)) == 0
+}
+
+// sameAttrSet は、順序を無視して属性の名前と値が同じかを返します。
+func sameAttrSet(a, b []xml.Attr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	values := make(map[xml.Name]string, len(a))
+	for _, attr := range a {
+		values[attr.Name] = attr.Value
+	}
+	for _, attr := range b {
+		if value, ok := values[attr.Name]; !ok || value != attr.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// trimTagEnd は、開始タグの表記から閉じ括弧 (> または />) と直前の空白を取り除きます。
+func trimTagEnd(raw string) string {
+	raw = strings.TrimSuffix(strings.TrimSuffix(raw, ">"), "/")
+	return strings.TrimRight(raw, " \t\n")
+}
+
+// abbreviate は、差異の表示用に表記を引用符で囲み、長い場合は省略します。
+func abbreviate(raw string) string {
+	const max = 60
+	if r := []rune(raw); len(r) > max {
+		raw = string(r[:max]) + "..."
+	}
+	return fmt.Sprintf("%q", raw)
+}
@@ -0,0 +1,42 @@
+package obufuku
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		input string
+		diffs []string
+	}{
+		{"identical", Config{Output: compactOutput}, `<a><b x="1">t</b></a>`, nil},
+		{"rules are not applied", Config{NameRules: []ConfigNameRule{{Old: "b", New: "c"}}, Output: compactOutput}, `<a><b>t</b></a>`, nil},
+		{"self-closing and character references", Config{Output: compactOutput}, "<a>\n<b/><c>&#65;</c></a>", []string{"1: newline: line endings changed from LF to none", "1: whitespace: \"\\n\" removed", "2: empty-element: \"<b/>\" written as \"<b>\"", "2: entity: \"&#65;\" written as \"A\""}},
+		{"indentation and comments", Config{}, "<a><!-- c --><b>t</b></a>", []string{"1: newline: line endings changed from none to CRLF", "1: whitespace: \"\\n  \" added", "1: whitespace: \"\\n\" added"}},
+		{"declaration and newlines", Config{}, "<?xml version=\"1.0\"?>\r\n<a>\r\n  <b>t</b>\r\n</a>", []string{"1: whitespace: \"\\n\" removed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := NewRuleSet(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewRuleSet: %v", err)
+			}
+			diffs, err := rs.VerifyRoundTrip(context.Background(), strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("VerifyRoundTrip: %v", err)
+			}
+			var got []string
+			for _, d := range diffs {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tt.diffs) {
+				t.Errorf("differences = %q, want %q", got, tt.diffs)
+			}
+		})
+	}
+}
@@ -0,0 +1,826 @@
+package obufuku
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Counter は、インクリメントする数値を管理します。
+type Counter struct {
+	current int
+}
+
+// Next はカウンターを1つ進めて、その新しい値を返します。
+func (c *Counter) Next() int {
+	c.current++
+	return c.current
+}
+
+// --- 実行時に使われるルール構造体 ---
+type NameReplaceRule struct {
+	OldName string
+	NewName string
+}
+type InsertBeforeRule struct {
+	TargetTag   string
+	XMLTemplate string
+	Counter     *Counter
+	// IfMissing が空でない場合、対象要素にこのタグ名 (入力のタグ名) の子が無いときだけ挿入します。
+	// 子の先頭への挿入 (prepend_child_rules) でだけ使えます。
+	IfMissing string
+	// IfAttributes が空でない場合、対象要素がこれらの属性 (名前は接頭辞を除く) をすべてこの値で持つときだけ挿入します。
+	IfAttributes map[string]string
+
+	// tokens は、カウンターもプレースホルダーも使わないテンプレートを組み立て時に解析したトークン列です。
+	// 一致するたびにテンプレートを解析し直さず、このトークン列を書き出します。
+	tokens []xml.Token
+	// placeholders は、テンプレートに含まれる {{uuid}} などのプレースホルダーです。
+	placeholders placeholders
+	// rows は、一致するたびに1行ずつ進めてテンプレートに埋め込む、CSVファイルの行です (csv の指定が無ければ nil)。
+	rows *csvRows
+}
+type ValueReplaceFunc func(oldValue string) string
+
+// ValueContextFunc は、対象要素の情報を参照して値を置き換える関数です。
+type ValueContextFunc func(oldValue string, ctx ValueContext) (string, error)
+
+// ValueContext は、値を置き換える対象要素の情報です。
+type ValueContext struct {
+	// Element は、対象要素の開始タグ (名前置換後) です。
+	Element xml.StartElement
+	// Variables は、capture_rules でそれまでに取り込んだ変数の名前と値です。
+	Variables map[string]string
+}
+
+type ValueReplaceRule struct {
+	TargetTag       string
+	ReplacementFunc ValueReplaceFunc
+	// ContextFunc が nil でなければ、ReplacementFunc の代わりに使われます。
+	ContextFunc ValueContextFunc
+}
+
+// DateRule は、複数のタグの要素のテキストの日付を1つの形式にそろえるルールです。
+type DateRule struct {
+	TargetTags []string
+	// Func は、値を変換する関数です。値置換ルールの種類 "date" と同じ関数を使います。
+	Func ValueContextFunc
+}
+
+// UnicodeRule は、要素のテキストと属性値を Unicode の正規化形式にするルールです。
+type UnicodeRule struct {
+	// TargetTag が空であれば、すべての要素に適用します。
+	TargetTag string
+	Form      norm.Form
+	// Text と Attributes は、それぞれテキストと属性値を対象にするかどうかです。
+	Text       bool
+	Attributes bool
+}
+
+// 子要素をラップするためのルール
+type WrapRule struct {
+	TargetTag  string
+	WrapperTag string
+}
+type CdataRule struct {
+	Old string
+	New string
+	// Entities が空でなければ、Old と New の代わりに文字参照を戻す ("unescape") か、文字参照にします ("escape")。
+	// "decode" と "numeric" は、マークアップとして意味を持つ文字以外の文字参照を、文字に戻すか数値文字参照にそろえます。
+	Entities string
+}
+
+// SummaryRule は、要素の数または値の合計を、ルート要素の子の末尾に要素として挿入するルールです。
+type SummaryRule struct {
+	TargetTag string
+	Element   string
+	// Function は、集計方法 ("count" または "sum") です。
+	Function string
+}
+
+// DeleteRule は、要素を子孫や終了タグを含めて出力しないルールです。
+// Pattern と IfEmpty のどちらも指定しない場合は、常に削除します。
+type DeleteRule struct {
+	TargetTag string
+	// Pattern が nil でなければ、要素のテキストがこの正規表現に一致する場合に削除します。
+	Pattern *regexp.Regexp
+	// IfEmpty が true であれば、要素にテキストも子要素も無い場合に削除します。
+	IfEmpty bool
+}
+
+// DedupeRule は、親要素の中で同じ内容の子要素が重複している場合に、2つ目以降を削除するルールです。
+type DedupeRule struct {
+	ParentTag string
+	// ChildTag が空でなければ、このタグ名の子要素だけを対象にします。
+	ChildTag string
+	// All が true であれば、連続していない重複も削除します。
+	All bool
+}
+
+// ReorderRule は、要素の子要素を指定したタグ名の順に並べ替えるルールです。
+type ReorderRule struct {
+	TargetTag string
+	Order     []string
+	// RejectUnknown が true であれば、Order に無い子要素をエラーにします (false の場合は末尾に並べます)。
+	RejectUnknown bool
+}
+
+// AssertRule は、要素が指定した子要素や属性を持つことを検査するルールです。
+type AssertRule struct {
+	TargetTag  string
+	Children   []string
+	Attributes []string
+	// Fail が true であれば、違反を警告ではなくエラーにして変換を中断します。
+	Fail bool
+}
+
+// CaseRule は、タグ名の大文字・小文字を統一するルールです。
+type CaseRule struct {
+	// Case は、統一する形 ("lower"、"upper" または "camel") です。
+	Case string
+	// Under が空でなければ、このタグ名 (入力のタグ名) の要素とその子孫だけを対象にします。
+	Under string
+}
+
+// PrefixRule は、名前空間の接頭辞を書き換えるルールです。
+type PrefixRule struct {
+	Old string
+	New string
+}
+
+// NamespaceRule は、名前空間URIを書き換えるルールです。
+type NamespaceRule struct {
+	Old string
+	New string
+}
+
+// RootRule は、ルート要素の名前と属性を置き換えるルールです。
+type RootRule struct {
+	// TargetTag が空であれば、ルート要素の名前に関わらず適用します。
+	TargetTag string
+	// NewName が空であれば、要素名は変えません。
+	NewName string
+	// Attributes は、設定する属性名と値です。値には {{now}} などのプレースホルダーを使えます。
+	Attributes map[string]string
+	// Replace が true の場合、名前空間の宣言以外の元の属性を削除します。
+	Replace bool
+	// attributes は、Attributes を設定する順 (名前空間の宣言を先に、それぞれ名前順) に並べたものです。
+	attributes []rootAttribute
+}
+
+// rootAttribute は、RootRule が設定する属性です。
+type rootAttribute struct {
+	name  string
+	value func(vars map[string]string) string
+}
+
+// SchemaLocationRule は、要素にスキーマの場所 (xsi:schemaLocation または xsi:noNamespaceSchemaLocation) を設定するルールです。
+type SchemaLocationRule struct {
+	// TargetTag が空であれば、ルート要素に適用します。
+	TargetTag string
+	// Namespace が空であれば、xsi:noNamespaceSchemaLocation を設定します。
+	Namespace string
+	Location  string
+}
+
+// NilRule は、空の要素と xsi:nil="true" の要素を相互に変換するルールです。
+type NilRule struct {
+	TargetTag string
+	// Mode は、空の要素に xsi:nil="true" を加えるか ("add")、xsi:nil="true" を削除して要素を空にするか ("strip") です。
+	Mode string
+}
+
+// DefaultNamespaceRule は、ルート要素の既定の名前空間を設定するルールです。
+type DefaultNamespaceRule struct {
+	// TargetTag が空であれば、ルート要素の名前に関わらず適用します。
+	TargetTag string
+	URI       string
+	// Scrub が true の場合、子孫の要素の既定の名前空間の宣言を削除します。
+	Scrub bool
+}
+
+// AttrCleanupRule は、属性値全体を囲む余分なダブルクォートを削除するルールです。
+type AttrCleanupRule struct {
+	// TargetTag が空であれば、すべての要素を対象にします。
+	TargetTag string
+	// Attributes が空であれば、すべての属性を対象にします。
+	Attributes []string
+}
+
+// AttrDeleteRule は、名前がパターンに一致する属性を削除するルールです。
+type AttrDeleteRule struct {
+	// TargetTag が空であれば、すべての要素を対象にします。
+	TargetTag string
+	// Patterns は、削除する属性の名前のパターン (path.Match の形式) です。
+	Patterns []string
+}
+
+// CaptureRule は、要素のテキストを変数に取り込むルールです。
+type CaptureRule struct {
+	TargetTag string
+	Variable  string
+}
+
+// --- JSONファイルから読み込むための設定構造体 ---
+// 各ルールの Description は、ルールを設けた理由などを書くための自由な説明です。変換には影響せず、
+// RuleEvent・TransformResult を通じて監査ログや報告に含まれます。
+type Config struct {
+	NameRules             []ConfigNameRule             `json:"name_rules"`
+	InsertRules           []ConfigInsertRule           `json:"insert_rules"`
+	InsertAfterRules      []ConfigInsertRule           `json:"insert_after_rules"`
+	PrependChildRules     []ConfigInsertRule           `json:"prepend_child_rules"`
+	ValueRules            []ConfigValueRule            `json:"value_rules"`
+	DateRules             []ConfigDateRule             `json:"date_rules"`
+	UnicodeRules          []ConfigUnicodeRule          `json:"unicode_rules"`
+	WrapRules             []ConfigWrapRule             `json:"wrap_rules"`
+	CdataRules            []ConfigCdataRule            `json:"cdata_rules"`
+	CaptureRules          []ConfigCaptureRule          `json:"capture_rules"`
+	SummaryRules          []ConfigSummaryRule          `json:"summary_rules"`
+	DeleteRules           []ConfigDeleteRule           `json:"delete_rules"`
+	DedupeRules           []ConfigDedupeRule           `json:"dedupe_rules"`
+	ReorderRules          []ConfigReorderRule          `json:"reorder_rules"`
+	AssertRules           []ConfigAssertRule           `json:"assert_rules"`
+	CaseRules             []ConfigCaseRule             `json:"case_rules"`
+	PrefixRules           []ConfigPrefixRule           `json:"prefix_rules"`
+	NamespaceRules        []ConfigNamespaceRule        `json:"namespace_rules"`
+	RootRules             []ConfigRootRule             `json:"root_rules"`
+	SchemaLocationRules   []ConfigSchemaLocationRule   `json:"schema_location_rules"`
+	NilRules              []ConfigNilRule              `json:"nil_rules"`
+	DefaultNamespaceRules []ConfigDefaultNamespaceRule `json:"default_namespace_rules"`
+	AttrCleanupRules      []ConfigAttrCleanupRule      `json:"attr_cleanup_rules"`
+	AttrDeleteRules       []ConfigAttrDeleteRule       `json:"attr_delete_rules"`
+	RawTags               []string                     `json:"raw_tags"`
+	Counters              map[string]ConfigCounter     `json:"counters"`
+	Filters               []string                     `json:"filters"`
+	Passes                []Config                     `json:"passes"`
+	Input                 ConfigInput                  `json:"input"`
+	Output                ConfigOutput                 `json:"output"`
+}
+
+type ConfigNameRule struct {
+	Old         string `json:"old"`
+	New         string `json:"new"`
+	Description string `json:"description"`
+}
+
+// ConfigInsertRule は、挿入ルールの設定です。
+// CSV が空でない場合、このCSVファイル (1行目は列名の見出し) の行をルールが一致するたびに1行ずつ進め、
+// テンプレートの {{csv "列名"}} をその行の値で置き換えます。行が足りなくなった場合はエラーになります。
+// IfMissing は、prepend_child_rules で、対象要素にこのタグ名の子が既にある場合は挿入しないための指定です。
+// 子があるかどうかは要素の終わりまで分からないため、その間の出力をメモリに溜めます。
+// 挿入しなかった場合も、カウンターとCSVファイルの行は進みます。
+// IfAttributes は、対象要素が属性名 (接頭辞を除く) と値の組をすべて持つときだけ挿入するための指定です
+// ({"type": "appendix"} で <section type="appendix"> の場合だけ挿入します)。値は完全に一致する必要があります。
+type ConfigInsertRule struct {
+	Target       string            `json:"target"`
+	Template     string            `json:"template"`
+	Counter      string            `json:"counter"`
+	CSV          string            `json:"csv"`
+	IfMissing    string            `json:"if_missing"`
+	IfAttributes map[string]string `json:"if_attributes"`
+	Description  string            `json:"description"`
+}
+type ConfigValueRule struct {
+	Target      string                 `json:"target"`
+	Type        string                 `json:"type"`
+	Params      map[string]interface{} `json:"params"`
+	Description string                 `json:"description"`
+}
+
+type ConfigWrapRule struct {
+	Target      string `json:"target"`
+	Wrapper     string `json:"wrapper"`
+	Description string `json:"description"`
+}
+
+// ConfigCdataRule は、raw_tags の要素の中身の置換ルールの設定です。
+// 中身の文字列 Old を New に置換します。Entities に "unescape" を指定した場合は、Old と New の代わりに
+// HTMLの文字参照 (&lt;b&gt; など) を文字に戻し (中身はCDATAセクションとして出力するため、そのままマークアップになります)、
+// "escape" を指定した場合は '<' や '&' などを文字参照にします。
+// 埋め込まれたHTMLの文字参照の形をそろえる場合は、"decode" (&nbsp; や &#169; を文字に戻す) または
+// "numeric" (&nbsp; や &copy; を &#160; や &#169; にする) を指定します。どちらも、マークアップとして意味を持つ
+// 文字 ('<'、'>'、'&' と引用符) の参照と、未知の名前の参照はそのまま残します。
+// raw_tags の要素を対象にした値置換ルールの html_unescape と html_escape は、設定の誤りとして拒否します。
+type ConfigCdataRule struct {
+	Old         string `json:"old"`
+	New         string `json:"new"`
+	Entities    string `json:"entities"`
+	Description string `json:"description"`
+}
+
+// ConfigCaptureRule は、要素のテキストを変数に取り込むルールの設定です。
+// 入力のタグ名が Target の要素のテキストを変数 Variable に取り込み、それ以降の挿入ルールのテンプレートや
+// 値置換ルール (prepend、append、script) から {{var "変数名"}} などで参照できるようにします。
+// 同じ変数に複数回取り込んだ場合は、最後に取り込んだテキストになります。
+// 変数は文書ごと、パスごとに空から始まり、raw_tags の要素の中身は取り込みません。
+type ConfigCaptureRule struct {
+	Target      string `json:"target"`
+	Variable    string `json:"variable"`
+	Description string `json:"description"`
+}
+
+// ConfigSummaryRule は、集計ルールの設定です。
+// 入力のタグ名が Target の要素を集計し、ルート要素の終了タグの直前に <Element>集計値</Element> を挿入します。
+// Function が "count" (省略時) の場合は要素の数を、"sum" の場合は要素のテキストの数値の合計を挿入します。
+// sum で数値でないテキストがあった場合はエラーになります。集計は文書ごと、パスごとに行います。
+type ConfigSummaryRule struct {
+	Target      string `json:"target"`
+	Element     string `json:"element"`
+	Function    string `json:"function"`
+	Description string `json:"description"`
+}
+
+// ConfigDeleteRule は、削除ルールの設定です。
+// 入力のタグ名が Target の要素を、子孫や終了タグを含めて出力しません。
+// Pattern を指定した場合は要素のテキスト (子要素のテキストを含まず、値置換ルールを適用した後のもの) が
+// 正規表現 Pattern に一致するとき、IfEmpty が true の場合はテキストも子要素も無いときだけ削除します
+// (両方を指定した場合はいずれかに当てはまるとき)。削除するかどうかは要素の終わりまで分からないため、
+// その間の出力をメモリに溜めます。要素の前後への挿入ルールの断片は削除しません。
+type ConfigDeleteRule struct {
+	Target      string `json:"target"`
+	Pattern     string `json:"pattern"`
+	IfEmpty     bool   `json:"if_empty"`
+	Description string `json:"description"`
+}
+
+// ConfigDedupeRule は、重複する子要素を削除するルールの設定です。
+// 入力のタグ名が Parent の要素の子要素 (Child を指定した場合はそのタグ名のもの) のうち、
+// 出力した内容 (タグ・属性・子孫) が直前の対象の子要素と同じものを削除します (間にある対象外の子要素は無視します)。
+// Scope が "all" の場合は、直前に限らず、それまでの対象の子要素のいずれかと同じものを削除します
+// (省略時は "consecutive")。比較のため、子要素の出力をその終わりまでメモリに溜めます。
+type ConfigDedupeRule struct {
+	Parent      string `json:"parent"`
+	Child       string `json:"child"`
+	Scope       string `json:"scope"`
+	Description string `json:"description"`
+}
+
+// ConfigReorderRule は、子要素を並べ替えるルールの設定です。
+// 入力のタグ名が Target の要素の子要素を、入力のタグ名の Order での順に並べ替えます (同じタグ名の子要素は元の順のまま)。
+// Order に無いタグ名の子要素は、Unknown が "append" (省略時) の場合は元の順のまま末尾に並べ、"reject" の場合はエラーにします。
+// 子要素の直前のコメントやテキストは、その子要素と一緒に移動します。
+// 並べ替えのため、対象の要素の子の出力をその終わりまでメモリに溜めます。
+type ConfigReorderRule struct {
+	Target      string   `json:"target"`
+	Order       []string `json:"order"`
+	Unknown     string   `json:"unknown"`
+	Description string   `json:"description"`
+}
+
+// ConfigAssertRule は、要素の構造を検査するルールの設定です。出力は変更しません。
+// 入力のタグ名が Target の要素が、入力のタグ名が Children の子要素と、名前が Attributes の属性を
+// すべて持つことを検査し、足りないものを要素のパスとともに警告として報告します。
+// Fail が true の場合は、最初の違反で変換をエラーにします。
+type ConfigAssertRule struct {
+	Target      string   `json:"target"`
+	Children    []string `json:"children"`
+	Attributes  []string `json:"attributes"`
+	Fail        bool     `json:"fail"`
+	Description string   `json:"description"`
+}
+
+// ConfigCaseRule は、タグ名の大文字・小文字を統一するルールの設定です。
+// Case が "lower" の場合は小文字に、"upper" の場合は大文字に、"camel" の場合は単語 ('_'、'-'、'.' の区切りや
+// 大文字・小文字の変わり目) の先頭を大文字にして区切りを除いた形 (orderId など) にします。
+// Under を指定した場合は、入力のタグ名が Under の要素とその子孫だけを対象にします。
+// 複数のルールが当てはまる場合は最初のルールを適用します。
+// 名前置換ルールや挿入ルールなどは、統一した後のタグ名と照合します。
+type ConfigCaseRule struct {
+	Case        string `json:"case"`
+	Under       string `json:"under"`
+	Description string `json:"description"`
+}
+
+// ConfigPrefixRule は、名前空間の接頭辞を書き換えるルールの設定です。
+// 名前空間宣言 xmlns:Old を xmlns:New に書き換え、その名前空間の要素名と属性名を New: の接頭辞で出力します。
+// 名前空間URIは変わりません。属性値の中の接頭辞 (xsi:type="Old:Type" など) は書き換えません。
+type ConfigPrefixRule struct {
+	Old         string `json:"old"`
+	New         string `json:"new"`
+	Description string `json:"description"`
+}
+
+// ConfigNamespaceRule は、名前空間URIを書き換えるルールの設定です。
+// 名前空間宣言 (xmlns="Old" や xmlns:p="Old") の値を New に書き換え、その名前空間に属する要素名と属性名を
+// New の名前空間にします。接頭辞は変わりません。複数のルールが当てはまる場合は最初のルールを適用します
+// (ルールを続けて適用はしません)。属性値やテキストの中のURI (xsi:schemaLocation など) は書き換えません。
+type ConfigNamespaceRule struct {
+	Old         string `json:"old"`
+	New         string `json:"new"`
+	Description string `json:"description"`
+}
+
+// ConfigDateRule は、日付を1つの形式にそろえるルールの設定です。
+// Targets に列挙したタグ (置換後の名前) の要素のテキストを、Layouts のいずれかの形式 (Go の時刻のレイアウト、
+// 省略時は 2006-01-02、2006/1/2、20060102、2006年1月2日 などの一般的な形式) の日付として解釈し、
+// Format の形式 (省略時は ISO 8601 の日付 2006-01-02) で出力します。
+// 和暦の日付 (令和6年1月5日、令和元年5月1日、R6.1.5 など) と全角の数字も解釈します。
+// OnError は、日付を解釈できなかった場合に変換を中断するか ("error"、既定)、値をそのまま残すか ("keep") です。
+// 空白だけの値はそのままにします。値置換ルールの種類 "date" と同じ変換を、複数のタグにまとめて指定するためのルールです。
+type ConfigDateRule struct {
+	Targets     []string `json:"targets"`
+	Layouts     []string `json:"layouts"`
+	Format      string   `json:"format"`
+	OnError     string   `json:"on_error"`
+	Description string   `json:"description"`
+}
+
+// ConfigUnicodeRule は、Unicode の正規化のルールの設定です。
+// タグ Target (置換後の名前、省略時はすべての要素) の要素のテキストと属性値を、Form の正規化形式
+// ("NFC"、"NFD"、"NFKC"、"NFKD") にします。Scope には、"all" (既定、テキストと属性値)、"text"、"attributes" を指定します。
+// 複数のルールが当てはまる場合は最初のルールを適用します。
+// 値置換ルールの種類 "unicode" でも、タグごとにテキストを正規化できます。
+type ConfigUnicodeRule struct {
+	Target      string `json:"target"`
+	Form        string `json:"form"`
+	Scope       string `json:"scope"`
+	Description string `json:"description"`
+}
+
+// ConfigRootRule は、ルート要素を置き換えるルールの設定です。
+// 入力のタグ名が Target のルート要素 (省略時はすべてのルート要素) の名前を Name にし (省略時は変えません)、
+// Attributes の属性を設定します (既にある属性は値を置き換えます)。属性値には {{now}} や {{var "名前"}} などの
+// プレースホルダーを使えます。"xmlns:xsi" のように名前空間の宣言も加えられます。
+// Replace が true の場合は、名前空間の宣言以外の元の属性を削除してから Attributes の属性を設定します。
+// 複数のルールが当てはまる場合は最初のルールを適用します。name_rules はこのルールの前に適用されます。
+type ConfigRootRule struct {
+	Target      string            `json:"target"`
+	Name        string            `json:"name"`
+	Attributes  map[string]string `json:"attributes"`
+	Replace     bool              `json:"replace"`
+	Description string            `json:"description"`
+}
+
+// ConfigSchemaLocationRule は、スキーマの場所を設定するルールの設定です。
+// 入力のタグ名が Target の要素 (省略時はルート要素) に、Namespace が空でなければ xsi:schemaLocation の
+// "Namespace Location" の組を設定し (既に同じ名前空間の組があれば場所を置き換え、無ければ加えます)、
+// Namespace が空であれば xsi:noNamespaceSchemaLocation を Location にします。
+// xsi の名前空間が宣言されていなければ、要素に xmlns:xsi の宣言も加えます。
+// 同じ要素に当てはまるルールはすべて適用します。
+type ConfigSchemaLocationRule struct {
+	Target      string `json:"target"`
+	Namespace   string `json:"namespace"`
+	Location    string `json:"location"`
+	Description string `json:"description"`
+}
+
+// ConfigNilRule は、空の要素と xsi:nil="true" の要素を相互に変換するルールの設定です。
+// Mode が "add" の場合は、入力のタグ名が Target の要素にテキスト (空白以外) も子要素も無ければ xsi:nil="true" を加え、
+// 空白だけの内容も取り除きます。xsi の名前空間が宣言されていなければ、要素に xmlns:xsi の宣言も加えます。
+// Mode が "strip" の場合は、xsi:nil="true" (または "1") の要素から属性を削除し、内容を取り除いて空の要素にします。
+// どちらも、要素の出力を終了タグまで保留します。複数のルールが当てはまる場合は最初のルールを適用します。
+type ConfigNilRule struct {
+	Target      string `json:"target"`
+	Mode        string `json:"mode"`
+	Description string `json:"description"`
+}
+
+// ConfigDefaultNamespaceRule は、ルート要素の既定の名前空間を設定するルールの設定です。
+// 入力のタグ名が Target のルート要素 (省略時はすべてのルート要素) に xmlns="URI" を宣言し (既にある既定の名前空間の
+// 宣言は置き換えます)、ルート要素の既定の名前空間に属していた要素 (名前空間の無い文書ではすべての要素) を URI の名前空間にします。
+// Scrub が true の場合は、子孫の要素にある既定の名前空間の宣言 (xmlns="..." や xmlns="") も削除し、
+// その要素と子孫も URI の名前空間にします。false の場合、そのような要素とその子孫は元の名前空間のままです。
+// 複数のルールが当てはまる場合は最初のルールを適用します。
+type ConfigDefaultNamespaceRule struct {
+	Target      string `json:"target"`
+	URI         string `json:"uri"`
+	Scrub       bool   `json:"scrub"`
+	Description string `json:"description"`
+}
+
+// ConfigAttrCleanupRule は、属性値の整形ルールの設定です。
+// 入力のタグ名が Target の要素 (省略時はすべての要素) の、名前 (接頭辞を除く) が Attributes の属性
+// (省略時はすべての属性) の値が全体をダブルクォートで囲まれている場合 ("&quot;abc&quot;" など)、そのクォートを削除します。
+// 既定では属性値を変更しません。
+type ConfigAttrCleanupRule struct {
+	Target      string   `json:"target"`
+	Attributes  []string `json:"attributes"`
+	Description string   `json:"description"`
+}
+
+// ConfigAttrDeleteRule は、属性の削除ルールの設定です。
+// 入力のタグ名が Target の要素 (省略時はすべての要素) から、名前が Patterns のいずれかに一致する属性を削除します。
+// パターンでは '*' (任意の文字列)、'?' (任意の1文字)、[a-z] (文字の範囲) を使え ("data-*"、"temp*" など)、
+// ':' を含むパターンは接頭辞を含む名前 ("tool:*" など。接頭辞は名前空間宣言から決まる出力での接頭辞です)、
+// 含まないパターンは接頭辞を除く名前と照合します。
+// 名前空間宣言は削除しません。
+type ConfigAttrDeleteRule struct {
+	Target      string   `json:"target"`
+	Patterns    []string `json:"patterns"`
+	Description string   `json:"description"`
+}
+
+// ConfigCounter は、カウンターの設定です。
+// Persist が空でない場合、このファイルにカウンターの最後の値を保存し (RuleSet.SaveCounters)、
+// 次にルールファイルを読み込むときは Start の代わりに保存した値から続けて採番します。
+// 1つのファイルに複数のカウンターの値を保存できます。
+// StartFrom が指定されている場合は、Start の代わりにルールファイルを読み込むときに開始値を読み込みます。
+type ConfigCounter struct {
+	Start     int                  `json:"start"`
+	Persist   string               `json:"persist"`
+	StartFrom *ConfigCounterSource `json:"start_from"`
+}
+
+// ConfigCounterSource は、カウンターの開始値 (出力先のシステムが記録している最後の番号など) の読み込み元です。
+// File (ファイルの内容)、Env (環境変数の値)、Command (コマンドと引数を実行した標準出力) のいずれか1つを指定し、
+// 前後の空白を除いた値を整数として使います。
+type ConfigCounterSource struct {
+	File    string   `json:"file"`
+	Env     string   `json:"env"`
+	Command []string `json:"command"`
+}
+
+// ConfigInput は、入力の読み込みに関する設定です。
+// Encoding には "auto" (内容から自動判定) または Shift_JIS などの名前を指定します。
+// HTML が true の場合、HTMLのトークナイザーで読み込むHTML互換の読み込みを行います。引用符の無い属性値、
+// <br> のような空要素、閉じられていない <li> や <p>、script と style の中の '<' や '&'、&nbsp; などの文字参照を読み込め、
+// 開始タグと終了タグの対応はHTMLの規則でそろえます。タグ名と属性名の大文字・小文字は入力のままです。
+// XML宣言のエンコーディングには従わないため、UTF-8以外の入力には Encoding を指定します。
+// Decoder の設定や、出力の最小変更モードとは同時に指定できません。
+// Secure が true の場合、外部DTDや外部実体を参照するDOCTYPE宣言を拒否します。
+// UndeclaredPrefixes には、宣言されていない名前空間接頭辞の扱いとして
+// "keep" (既定)、"declare"、"strip"、"error" のいずれかを指定します。
+// ControlChars には、XML 1.0で使用できない制御文字 (U+0001 など) と、それを表す数値文字参照の扱いとして
+// "error" (既定、解析エラー)、"strip" (取り除く)、"replace" (ControlCharReplacement に置き換える) のいずれかを指定し、
+// 取り除いたか置き換えた文字は、入力での位置とともに警告として報告します。
+// ControlCharReplacement の既定値は U+FFFD です。置換文字列はそのままのバイト列で入力に差し込むため、
+// UTF-8以外の入力を Encoding で変換せずに読み込む場合はASCIIの文字列を指定します。
+type ConfigInput struct {
+	Encoding               string        `json:"encoding"`
+	HTML                   bool          `json:"html"`
+	Secure                 bool          `json:"secure"`
+	Limits                 ConfigLimits  `json:"limits"`
+	Decoder                ConfigDecoder `json:"decoder"`
+	UndeclaredPrefixes     string        `json:"undeclared_prefixes"`
+	ControlChars           string        `json:"control_chars"`
+	ControlCharReplacement *string       `json:"control_char_replacement"`
+}
+
+// ConfigDecoder は、XMLの構文をどこまで厳密に検査するかの設定です。
+// Strict を false にすると、引用符の無い属性値や未定義の実体参照を許容し、
+// AutoClose (および HTMLAutoClose) に挙げた要素は終了タグが無くても閉じたものとして扱います。
+// Entities (および HTMLEntities) は、定義済み以外の実体参照の置換テキストです。
+type ConfigDecoder struct {
+	Strict        *bool             `json:"strict"`
+	AutoClose     []string          `json:"auto_close"`
+	HTMLAutoClose bool              `json:"html_auto_close"`
+	Entities      map[string]string `json:"entities"`
+	HTMLEntities  bool              `json:"html_entities"`
+}
+
+// ConfigLimits は、悪意のある入力からの保護のための上限です。
+// 0 の場合は既定値を使い、負の値の場合はその上限を設けません。
+type ConfigLimits struct {
+	MaxEntityExpansions int64 `json:"max_entity_expansions"`
+	MaxExpandedSize     int64 `json:"max_expanded_size"`
+	MaxDepth            int   `json:"max_depth"`
+}
+
+// ConfigOutput は、出力形式に関する設定です。
+type ConfigOutput struct {
+	Compact     bool              `json:"compact"`
+	Encoding    string            `json:"encoding"`
+	Declaration ConfigDeclaration `json:"declaration"`
+	SelfClosing ConfigSelfClosing `json:"self_closing"`
+	Minimal     bool              `json:"minimal"`
+	Comments    ConfigComments    `json:"comments"`
+	// AttrWrapWidth は、属性を折り返す開始タグの桁数です (0 は折り返さない)。
+	AttrWrapWidth int `json:"attr_wrap_width"`
+	// Canonical は、Exclusive XML Canonicalization の形式で出力するかどうかです。
+	Canonical bool        `json:"canonical"`
+	Flush     ConfigFlush `json:"flush"`
+	// Newline は、出力の改行コードです。"crlf" (既定) または "lf" を指定します。
+	// 最小変更モードでは入力の改行コードを維持し、正規化出力では常に LF のため指定できません。
+	Newline string `json:"newline"`
+	// SortAttributes が true の場合、開始タグの属性を名前 (接頭辞を含む) の昇順に並べます。
+	// AttributeOrder に列挙した名前の属性は、その順で先頭に並べます。
+	// 名前空間宣言は、入力の順のまま属性より前に出力します。
+	// 最小変更モードと正規化出力 (属性は常に規定の順) では指定できません。
+	SortAttributes bool           `json:"sort_attributes"`
+	AttributeOrder []string       `json:"attribute_order"`
+	CharRefs       ConfigCharRefs `json:"char_refs"`
+}
+
+// ConfigCharRefs は、ASCII以外の文字を数値文字参照 (&#x3042; など) で出力する設定です。
+// Mode には "none" (既定) または "all" (文書全体) を指定し、Tags に列挙したタグ (置換後の名前) の要素は
+// Mode に関わらず、その属性値と子孫を含むテキストを数値文字参照にします。
+// raw_tags の要素の中身は、ASCII以外の文字の並びの前後でCDATAセクションを分け、その文字を数値文字参照にします。
+// 要素名と属性名は文字参照にできないため、ASCII以外の文字を含む場合はエラーにします。
+// コメントと処理命令はそのまま出力します。
+// 最小変更モードと正規化出力では指定できません。
+type ConfigCharRefs struct {
+	Mode string   `json:"mode"`
+	Tags []string `json:"tags"`
+}
+
+// ConfigFlush は、出力を途中で書き出す間隔の設定です。
+// Tokens は入力トークン数、Bytes は出力バイト数の間隔で、0 の場合はその条件では書き出しません。
+type ConfigFlush struct {
+	Tokens int `json:"tokens"`
+	Bytes  int `json:"bytes"`
+}
+
+// ConfigComments は、コメントと処理命令の配置に関する設定です。
+// Placement には "inline" (既定、直前のトークンに続ける) または "own_line" を指定します。
+type ConfigComments struct {
+	Placement          string `json:"placement"`
+	PreserveBlankLines bool   `json:"preserve_blank_lines"`
+}
+
+// ConfigSelfClosing は、空要素を自己終了タグで出力する設定です。
+// Mode には "none" (既定)、"all"、"preserve" (入力の形式を維持) を指定し、
+// Tags に列挙したタグは Mode に関わらず自己終了タグにします。
+// Expand に列挙したタグは Mode に関わらず開始タグと終了タグ (<tag></tag>) にし、
+// Remove に列挙したタグは空 (空白だけの内容を含む) の場合に出力しません。
+// タグ名は置換後の名前で、1つのタグは Tags・Expand・Remove のいずれか1つにだけ指定できます。
+// 最小変更モードで入力のまま出力する要素は、Remove を除いて入力の形式を維持します。
+type ConfigSelfClosing struct {
+	Mode   string   `json:"mode"`
+	Tags   []string `json:"tags"`
+	Expand []string `json:"expand"`
+	Remove []string `json:"remove"`
+}
+
+// ConfigDeclaration は、出力するXML宣言の制御設定です。
+// Mode には "keep" (既定)、"add"、"remove" を指定します。
+type ConfigDeclaration struct {
+	Mode       string `json:"mode"`
+	Version    string `json:"version"`
+	Encoding   string `json:"encoding"`
+	Standalone string `json:"standalone"`
+}
+
+// buildValueReplaceRule は、設定の種類 (type) に登録された値変換関数でルールを生成します。
+func buildValueReplaceRule(rule ConfigValueRule) (ValueReplaceRule, error) {
+	built := ValueReplaceRule{TargetTag: rule.Target}
+	factory, ok := lookupValueFunc(rule.Type)
+	if !ok {
+		return built, fmt.Errorf("unknown value rule type: '%s'", rule.Type)
+	}
+	var err error
+	if factory.withContext != nil {
+		built.ContextFunc, err = factory.withContext(rule.Params)
+	} else {
+		built.ReplacementFunc, err = factory.plain(rule.Params)
+	}
+	return built, err
+}
+
+// buildSelfClosingOptions は、設定を検証して自己終了タグの出力条件を生成します。
+func buildSelfClosingOptions(cfg ConfigSelfClosing) (SelfClosingOptions, error) {
+	opts := SelfClosingOptions{Tags: make(map[string]bool), Expand: make(map[string]bool), Remove: make(map[string]bool)}
+	switch cfg.Mode {
+	case "", "none":
+	case "all":
+		opts.All = true
+	case "preserve":
+		opts.Preserve = true
+	default:
+		return opts, fmt.Errorf("unknown self_closing mode: '%s'", cfg.Mode)
+	}
+	seen := make(map[string]bool)
+	for _, list := range []struct {
+		tags []string
+		set  map[string]bool
+	}{
+		{cfg.Tags, opts.Tags},
+		{cfg.Expand, opts.Expand},
+		{cfg.Remove, opts.Remove},
+	} {
+		for _, tag := range list.tags {
+			if seen[tag] && !list.set[tag] {
+				return opts, fmt.Errorf("self_closing tag '%s' is listed in more than one of 'tags', 'expand' and 'remove'", tag)
+			}
+			seen[tag] = true
+			list.set[tag] = true
+		}
+	}
+	return opts, nil
+}
+
+// buildCharRefOptions は、設定を検証して数値文字参照の出力設定を生成します。
+func buildCharRefOptions(cfg ConfigCharRefs) (CharRefOptions, error) {
+	opts := CharRefOptions{Tags: make(map[string]bool)}
+	switch cfg.Mode {
+	case "", "none":
+	case "all":
+		opts.All = true
+	default:
+		return opts, fmt.Errorf("unknown char_refs mode: '%s'", cfg.Mode)
+	}
+	for _, tag := range cfg.Tags {
+		opts.Tags[tag] = true
+	}
+	return opts, nil
+}
+
+// buildCommentOptions は、設定を検証してコメントと処理命令の配置設定を生成します。
+func buildCommentOptions(cfg ConfigComments) (CommentOptions, error) {
+	opts := CommentOptions{PreserveBlankLines: cfg.PreserveBlankLines}
+	switch cfg.Placement {
+	case "", "inline":
+	case "own_line":
+		opts.OwnLine = true
+	default:
+		return opts, fmt.Errorf("unknown comment placement: '%s'", cfg.Placement)
+	}
+	if opts.PreserveBlankLines && !opts.OwnLine {
+		return opts, fmt.Errorf("comment option 'preserve_blank_lines' requires placement 'own_line'")
+	}
+	return opts, nil
+}
+
+// buildDecoderOptions は、設定を検証してXMLデコーダの構文検査の設定を生成します。
+func buildDecoderOptions(cfg ConfigDecoder) (DecoderOptions, error) {
+	opts := DecoderOptions{Strict: true}
+	if cfg.Strict != nil {
+		opts.Strict = *cfg.Strict
+	}
+	if cfg.HTMLAutoClose {
+		opts.AutoClose = append(opts.AutoClose, xml.HTMLAutoClose...)
+	}
+	opts.AutoClose = append(opts.AutoClose, cfg.AutoClose...)
+	if len(opts.AutoClose) > 0 && opts.Strict {
+		return opts, fmt.Errorf("decoder options 'auto_close' and 'html_auto_close' require 'strict': false")
+	}
+	if cfg.HTMLEntities || len(cfg.Entities) > 0 {
+		opts.Entity = make(map[string]string)
+		if cfg.HTMLEntities {
+			for name, text := range xml.HTMLEntity {
+				opts.Entity[name] = text
+			}
+		}
+		for name, text := range cfg.Entities {
+			opts.Entity[name] = text
+		}
+	}
+	return opts, nil
+}
+
+// buildInputLimits は、設定の上限に既定値を補って入力の上限を生成します。
+func buildInputLimits(cfg ConfigLimits) InputLimits {
+	limits := InputLimits{
+		MaxEntityExpansions: defaultMaxEntityExpansions,
+		MaxExpandedSize:     defaultMaxExpandedSize,
+		MaxDepth:            defaultMaxDepth,
+	}
+	if cfg.MaxEntityExpansions != 0 {
+		limits.MaxEntityExpansions = cfg.MaxEntityExpansions
+	}
+	if cfg.MaxExpandedSize != 0 {
+		limits.MaxExpandedSize = cfg.MaxExpandedSize
+	}
+	if cfg.MaxDepth != 0 {
+		limits.MaxDepth = cfg.MaxDepth
+	}
+	return limits
+}
+
+// buildFlushOptions は、設定を検証して出力の途中書き出しの間隔を生成します。
+func buildFlushOptions(cfg ConfigFlush) (FlushOptions, error) {
+	if cfg.Tokens < 0 || cfg.Bytes < 0 {
+		return FlushOptions{}, fmt.Errorf("flush options 'tokens' and 'bytes' must not be negative")
+	}
+	return FlushOptions{Tokens: cfg.Tokens, Bytes: int64(cfg.Bytes)}, nil
+}
+
+// buildDeclarationOptions は、設定を検証してXML宣言の制御設定を生成します。
+// 宣言の encoding は実際の出力エンコーディング (未指定ならUTF-8) と一致している必要があります。
+func buildDeclarationOptions(cfg ConfigDeclaration, outputEncoding encoding.Encoding) (DeclarationOptions, error) {
+	opts := DeclarationOptions{
+		Mode:       cfg.Mode,
+		Version:    cfg.Version,
+		Encoding:   cfg.Encoding,
+		Standalone: cfg.Standalone,
+	}
+	switch opts.Mode {
+	case "":
+		opts.Mode = declarationKeep
+	case declarationKeep, declarationAdd, declarationRemove:
+	default:
+		return opts, fmt.Errorf("unknown declaration mode: '%s'", cfg.Mode)
+	}
+	switch opts.Standalone {
+	case "", "yes", "no":
+	default:
+		return opts, fmt.Errorf("invalid declaration standalone value: '%s' (must be 'yes' or 'no')", cfg.Standalone)
+	}
+	if opts.Encoding != "" {
+		enc, _, err := lookupEncoding(opts.Encoding)
+		if err != nil {
+			return opts, err
+		}
+		actual := outputEncoding
+		if actual == nil {
+			actual = unicode.UTF8
+		}
+		if enc != actual && !(isUTF8(enc) && isUTF8(actual)) {
+			return opts, fmt.Errorf("declaration encoding '%s' does not match the output encoding", cfg.Encoding)
+		}
+	}
+	return opts, nil
+}
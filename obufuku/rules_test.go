@@ -0,0 +1,665 @@
+package obufuku
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ruleTest は、構造を変えるルールのテーブルの1行です。
+type ruleTest struct {
+	name  string
+	cfg   Config
+	input string
+	want  string
+}
+
+// runRuleTests は、各行の設定で入力を変換し、出力を比べます。
+func runRuleTests(t *testing.T, tests []ruleTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Output = compactOutput
+			got, _ := transformString(t, tt.cfg, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// wantRuleConfigError は、cfg の組み立てが rule の RuleConfigError になることを確かめます。
+func wantRuleConfigError(t *testing.T, cfg Config, rule string) {
+	t.Helper()
+	_, err := NewRuleSet(cfg)
+	var configErr *RuleConfigError
+	if !errors.As(err, &configErr) || configErr.Rule != rule {
+		t.Errorf("NewRuleSet error = %v, want a RuleConfigError for %s", err, rule)
+	}
+}
+
+func TestIfMissing(t *testing.T) {
+	idRule := func(template string) Config {
+		return Config{PrependChildRules: []ConfigInsertRule{{Target: "item", Template: template, IfMissing: "id"}}}
+	}
+	runRuleTests(t, []ruleTest{
+		{"child missing", idRule("<id>new</id>"), `<a><item><name>x</name></item></a>`, `<a><item><id>new</id><name>x</name></item></a>`},
+		{"child exists", idRule("<id>new</id>"), `<a><item><name>x</name><id>1</id></item></a>`, `<a><item><name>x</name><id>1</id></item></a>`},
+		{"only direct children count", idRule("<id>new</id>"), `<a><item><sub><id>1</id></sub></item></a>`, `<a><item><id>new</id><sub><id>1</id></sub></item></a>`},
+		{"empty element", idRule("<id>new</id>"), `<a><item/></a>`, `<a><item><id>new</id></item></a>`},
+		{"nested targets", idRule("<id>new</id>"), `<a><item><item><id>1</id></item></item></a>`, `<a><item><id>new</id><item><id>1</id></item></item></a>`},
+		{
+			name: "counter advances even when skipped",
+			cfg: Config{
+				PrependChildRules: []ConfigInsertRule{{Target: "item", Template: "<id>%d</id>", Counter: "n", IfMissing: "id"}},
+				Counters:          map[string]ConfigCounter{"n": {}},
+			},
+			input: `<a><item/><item><id>x</id></item><item/></a>`,
+			want:  `<a><item><id>1</id></item><item><id>x</id></item><item><id>3</id></item></a>`,
+		},
+		{
+			name: "child renamed by a rule is matched by its input name",
+			cfg: Config{
+				NameRules:         []ConfigNameRule{{Old: "id", New: "code"}},
+				PrependChildRules: []ConfigInsertRule{{Target: "item", Template: "<code>new</code>", IfMissing: "id"}},
+			},
+			input: `<a><item><id>1</id></item><item/></a>`,
+			want:  `<a><item><code>1</code></item><item><code>new</code></item></a>`,
+		},
+	})
+}
+
+func TestIfMissingErrors(t *testing.T) {
+	wantRuleConfigError(t, Config{InsertRules: []ConfigInsertRule{{Target: "a", Template: "<x/>", IfMissing: "x"}}}, "insert_rules[0]")
+	wantRuleConfigError(t, Config{InsertAfterRules: []ConfigInsertRule{{Target: "a", Template: "<x/>", IfMissing: "x"}}}, "insert_after_rules[0]")
+}
+
+func TestDeleteRules(t *testing.T) {
+	deleteRule := func(rule ConfigDeleteRule) Config {
+		return Config{DeleteRules: []ConfigDeleteRule{rule}}
+	}
+	runRuleTests(t, []ruleTest{
+		{"always", deleteRule(ConfigDeleteRule{Target: "b"}), `<a><b>x<c/></b><d/></a>`, `<a><d></d></a>`},
+		{"pattern matches", deleteRule(ConfigDeleteRule{Target: "b", Pattern: "^tmp"}), `<a><b>tmp1</b><b>keep</b></a>`, `<a><b>keep</b></a>`},
+		{"pattern ignores child text", deleteRule(ConfigDeleteRule{Target: "b", Pattern: "tmp"}), `<a><b>x<c>tmp</c></b></a>`, `<a><b>x<c>tmp</c></b></a>`},
+		{"if empty", deleteRule(ConfigDeleteRule{Target: "b", IfEmpty: true}), `<a><b/><b> </b><b>x</b><b><c/></b></a>`, `<a><b>x</b><b><c></c></b></a>`},
+		{
+			name:  "pattern or empty",
+			cfg:   deleteRule(ConfigDeleteRule{Target: "b", Pattern: "^-$", IfEmpty: true}),
+			input: `<a><b/><b>-</b><b>1</b></a>`,
+			want:  `<a><b>1</b></a>`,
+		},
+		{"nested targets", deleteRule(ConfigDeleteRule{Target: "b", Pattern: "^x$"}), `<a><b>y<b>x</b></b><b>x<b>y</b></b></a>`, `<a><b>y</b></a>`},
+		{
+			name: "text after value rules",
+			cfg: Config{
+				ValueRules:  []ConfigValueRule{{Target: "b", Type: "script", Params: params{"expr": `value == "secret" ? "" : value`}}},
+				DeleteRules: []ConfigDeleteRule{{Target: "b", IfEmpty: true}},
+			},
+			input: `<a><b>secret</b><b>open</b></a>`,
+			want:  `<a><b>open</b></a>`,
+		},
+		{
+			name: "matched by input name",
+			cfg: Config{
+				NameRules:   []ConfigNameRule{{Old: "b", New: "c"}},
+				DeleteRules: []ConfigDeleteRule{{Target: "b", Pattern: "x"}},
+			},
+			input: `<a><b>x</b><b>y</b></a>`,
+			want:  `<a><c>y</c></a>`,
+		},
+		{
+			name: "inserted siblings are kept",
+			cfg: Config{
+				InsertAfterRules: []ConfigInsertRule{{Target: "b", Template: "<n/>"}},
+				DeleteRules:      []ConfigDeleteRule{{Target: "b"}},
+			},
+			input: `<a><b>x</b></a>`,
+			want:  `<a><n></n></a>`,
+		},
+	})
+}
+
+func TestDeleteRulesErrors(t *testing.T) {
+	wantRuleConfigError(t, Config{DeleteRules: []ConfigDeleteRule{{Target: "a", Pattern: "("}}}, "delete_rules[0]")
+}
+
+func TestDedupeRules(t *testing.T) {
+	dedupeRule := func(child, scope string) Config {
+		return Config{DedupeRules: []ConfigDedupeRule{{Parent: "list", Child: child, Scope: scope}}}
+	}
+	runRuleTests(t, []ruleTest{
+		{"consecutive", dedupeRule("", ""), `<list><i>1</i><i>1</i><i>2</i><i>1</i></list>`, `<list><i>1</i><i>2</i><i>1</i></list>`},
+		{"all", dedupeRule("", "all"), `<list><i>1</i><i>2</i><i>1</i><i>2</i></list>`, `<list><i>1</i><i>2</i></list>`},
+		{"attributes differ", dedupeRule("", ""), `<list><i k="1">x</i><i k="2">x</i><i k="2">x</i></list>`, `<list><i k="1">x</i><i k="2">x</i></list>`},
+		{"descendants compared", dedupeRule("", ""), `<list><i><v>1</v></i><i><v>2</v></i><i><v>2</v></i></list>`, `<list><i><v>1</v></i><i><v>2</v></i></list>`},
+		{"tag differs", dedupeRule("", ""), `<list><i>1</i><j>1</j></list>`, `<list><i>1</i><j>1</j></list>`},
+		{"other children ignored", dedupeRule("i", ""), `<list><i>1</i><j>1</j><j>1</j><i>1</i></list>`, `<list><i>1</i><j>1</j><j>1</j></list>`},
+		{"per parent", dedupeRule("", "all"), `<r><list><i>1</i></list><list><i>1</i></list></r>`, `<r><list><i>1</i></list><list><i>1</i></list></r>`},
+		{"grandchildren untouched", dedupeRule("", ""), `<list><i><v>1</v><v>1</v></i></list>`, `<list><i><v>1</v><v>1</v></i></list>`},
+		{
+			name: "compares the output",
+			cfg: Config{
+				ValueRules:  []ConfigValueRule{{Target: "i", Type: "script", Params: params{"expr": "upper(value)"}}},
+				DedupeRules: []ConfigDedupeRule{{Parent: "list"}},
+			},
+			input: `<list><i>a</i><i>A</i></list>`,
+			want:  `<list><i>A</i></list>`,
+		},
+	})
+}
+
+func TestDedupeRulesErrors(t *testing.T) {
+	wantRuleConfigError(t, Config{DedupeRules: []ConfigDedupeRule{{Parent: "a", Scope: "sometimes"}}}, "dedupe_rules[0]")
+}
+
+func TestReorderRules(t *testing.T) {
+	reorderRule := func(unknown string, order ...string) Config {
+		return Config{ReorderRules: []ConfigReorderRule{{Target: "p", Order: order, Unknown: unknown}}}
+	}
+	runRuleTests(t, []ruleTest{
+		{"sorted", reorderRule("", "a", "b", "c"), `<p><c>3</c><a>1</a><b>2</b></p>`, `<p><a>1</a><b>2</b><c>3</c></p>`},
+		{"same names keep order", reorderRule("", "a", "b"), `<p><b>1</b><a>2</a><b>3</b><a>4</a></p>`, `<p><a>2</a><a>4</a><b>1</b><b>3</b></p>`},
+		{"unknown appended", reorderRule("", "a"), `<p><x>1</x><a>2</a><y>3</y></p>`, `<p><a>2</a><x>1</x><y>3</y></p>`},
+		{"missing names skipped", reorderRule("", "a", "b", "c"), `<p><c/><a/></p>`, `<p><a></a><c></c></p>`},
+		{"comment moves with child", reorderRule("", "a", "b"), `<p><b/><!--A--><a/></p>`, `<p><!--A--><a></a><b></b></p>`},
+		{"comment before the first child", reorderRule("", "a", "b"), `<p><!--B--><b/><!--A--><a/></p>`, `<p><!--A--><a></a><!--B--><b></b></p>`},
+		{
+			name: "after wrapper and prepended child",
+			cfg: Config{
+				ReorderRules:      []ConfigReorderRule{{Target: "p", Order: []string{"a", "b"}}},
+				WrapRules:         []ConfigWrapRule{{Target: "p", Wrapper: "w"}},
+				PrependChildRules: []ConfigInsertRule{{Target: "p", Template: "<n/>"}},
+			},
+			input: `<p><!--B--><b/><a/></p>`,
+			want:  `<p><w><n></n><a></a><!--B--><b></b></w></p>`,
+		},
+		{"descendants kept", reorderRule("", "a", "b"), `<p><b><a>x</a></b><a><b>y</b></a></p>`, `<p><a><b>y</b></a><b><a>x</a></b></p>`},
+		{"nested targets", reorderRule("", "a", "p"), `<r><p><p><b/><a/></p><a/></p></r>`, `<r><p><a></a><p><a></a><b></b></p></p></r>`},
+		{
+			name: "matched by input name",
+			cfg: Config{
+				NameRules:    []ConfigNameRule{{Old: "a", New: "z"}},
+				ReorderRules: []ConfigReorderRule{{Target: "p", Order: []string{"a", "b"}}},
+			},
+			input: `<p><b/><a/></p>`,
+			want:  `<p><z></z><b></b></p>`,
+		},
+	})
+}
+
+func TestReorderRulesIndent(t *testing.T) {
+	// 開始タグの直後のコメントと一緒に移動しても、子要素はインデントして出力する
+	cfg := Config{ReorderRules: []ConfigReorderRule{{Target: "p", Order: []string{"a", "b"}}}}
+	got, _ := transformString(t, cfg, "<p>\n  <!--B-->\n  <b/>\n  <a/>\n</p>")
+	if want := "<p>\r\n  <a></a>\r\n  <!--B-->\r\n  <b></b>\r\n</p>"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestReorderRulesErrors(t *testing.T) {
+	wantRuleConfigError(t, Config{ReorderRules: []ConfigReorderRule{{Target: "p"}}}, "reorder_rules[0]")
+	wantRuleConfigError(t, Config{ReorderRules: []ConfigReorderRule{{Target: "p", Order: []string{"a"}, Unknown: "drop"}}}, "reorder_rules[0]")
+
+	cfg := Config{ReorderRules: []ConfigReorderRule{{Target: "p", Order: []string{"a"}, Unknown: "reject"}}}
+	_, err := tryTransformString(t, cfg, `<p><a/><x/></p>`)
+	var encodeErr *EncodeError
+	if !errors.As(err, &encodeErr) || encodeErr.Rule != "reorder_rules[0]" {
+		t.Errorf("Transform error = %v, want an EncodeError for reorder_rules[0]", err)
+	}
+}
+
+func TestAssertRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  ConfigAssertRule
+		input string
+		want  []string
+	}{
+		{"satisfied", ConfigAssertRule{Target: "b", Children: []string{"c"}, Attributes: []string{"id"}}, `<a><b id="1"><c></c></b></a>`, nil},
+		{"missing child", ConfigAssertRule{Target: "b", Children: []string{"c", "d"}}, `<a><b><c></c></b></a>`, []string{"assert_rules[0]: /a/b is missing child 'd'"}},
+		{"missing attribute", ConfigAssertRule{Target: "b", Attributes: []string{"id"}}, `<a><b></b><b id="2"></b></a>`, []string{"assert_rules[0]: /a/b is missing attribute 'id'"}},
+		{"grandchild does not count", ConfigAssertRule{Target: "b", Children: []string{"c"}}, `<a><b><x><c></c></x></b></a>`, []string{"assert_rules[0]: /a/b is missing child 'c'"}},
+		{"prefixed attribute", ConfigAssertRule{Target: "b", Attributes: []string{"id"}}, `<a xmlns:p="urn:p"><b p:id="1"></b></a>`, nil},
+		{
+			name:  "every violation reported",
+			rule:  ConfigAssertRule{Target: "b", Children: []string{"c"}, Attributes: []string{"id"}},
+			input: `<a><b></b><b id="1"><c></c></b><b></b></a>`,
+			want: []string{
+				"assert_rules[0]: /a/b is missing attribute 'id'",
+				"assert_rules[0]: /a/b is missing child 'c'",
+				"assert_rules[0]: /a/b is missing attribute 'id'",
+				"assert_rules[0]: /a/b is missing child 'c'",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{AssertRules: []ConfigAssertRule{tt.rule}, Output: compactOutput}
+			got, result := transformString(t, cfg, tt.input)
+			if got != tt.input {
+				t.Errorf("output = %q, want the input unchanged", got)
+			}
+			if !reflect.DeepEqual(result.Warnings, tt.want) {
+				t.Errorf("Warnings = %q, want %q", result.Warnings, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertRulesFail(t *testing.T) {
+	cfg := Config{AssertRules: []ConfigAssertRule{{Target: "b", Children: []string{"c"}, Fail: true}}}
+	_, err := tryTransformString(t, cfg, `<a><b></b></a>`)
+	var encodeErr *EncodeError
+	if !errors.As(err, &encodeErr) || encodeErr.Rule != "assert_rules[0]" || !strings.Contains(err.Error(), "/a/b is missing child 'c'") {
+		t.Errorf("Transform error = %v, want an EncodeError for assert_rules[0]", err)
+	}
+	wantRuleConfigError(t, Config{AssertRules: []ConfigAssertRule{{Target: "b"}}}, "assert_rules[0]")
+}
+
+func TestCaseRules(t *testing.T) {
+	caseRule := func(rules ...ConfigCaseRule) Config {
+		return Config{CaseRules: rules}
+	}
+	runRuleTests(t, []ruleTest{
+		{"lower", caseRule(ConfigCaseRule{Case: "lower"}), `<Root><Item ID="1">x</Item></Root>`, `<root><item ID="1">x</item></root>`},
+		{"upper", caseRule(ConfigCaseRule{Case: "upper"}), `<root><item/></root>`, `<ROOT><ITEM></ITEM></ROOT>`},
+		{"camel", caseRule(ConfigCaseRule{Case: "camel"}), `<order_list><Order-ID/></order_list>`, `<orderList><orderId></orderId></orderList>`},
+		{"prefix kept", caseRule(ConfigCaseRule{Case: "upper"}), `<p:a xmlns:p="urn:p"/>`, `<p:A xmlns:p="urn:p"></p:A>`},
+		{"invalid result left alone", caseRule(ConfigCaseRule{Case: "camel"}), `<a><_1st/></a>`, `<a><_1st></_1st></a>`},
+		{"under", caseRule(ConfigCaseRule{Case: "upper", Under: "b"}), `<a><b><c/></b><c/></a>`, `<a><B><C></C></B><c></c></a>`},
+		{
+			name:  "first matching rule wins",
+			cfg:   caseRule(ConfigCaseRule{Case: "upper", Under: "b"}, ConfigCaseRule{Case: "camel"}),
+			input: `<x_y><b><c_d/></b></x_y>`,
+			want:  `<xY><B><C_D></C_D></B></xY>`,
+		},
+		{
+			name: "name rules see the normalized name",
+			cfg: Config{
+				CaseRules: []ConfigCaseRule{{Case: "lower"}},
+				NameRules: []ConfigNameRule{{Old: "item", New: "entry"}},
+			},
+			input: `<List><ITEM/></List>`,
+			want:  `<list><entry></entry></list>`,
+		},
+	})
+}
+
+func TestCaseRulesErrors(t *testing.T) {
+	wantRuleConfigError(t, Config{CaseRules: []ConfigCaseRule{{Case: "title"}}}, "case_rules[0]")
+}
+
+func TestPrefixRules(t *testing.T) {
+	prefixRule := func(old, new string) Config {
+		return Config{PrefixRules: []ConfigPrefixRule{{Old: old, New: new}}}
+	}
+	runRuleTests(t, []ruleTest{
+		{"declaration and names", prefixRule("old", "new"), `<old:a xmlns:old="urn:x"><old:b old:k="1">v</old:b></old:a>`, `<new:a xmlns:new="urn:x"><new:b new:k="1">v</new:b></new:a>`},
+		{"other prefixes untouched", prefixRule("old", "new"), `<a xmlns:old="urn:x" xmlns:p="urn:p"><p:b/><old:c/></a>`, `<a xmlns:new="urn:x" xmlns:p="urn:p"><p:b></p:b><new:c></new:c></a>`},
+		{"values untouched", prefixRule("old", "new"), `<a xmlns:old="urn:x" t="old:T">old:v</a>`, `<a xmlns:new="urn:x" t="old:T">old:v</a>`},
+		{"redeclared prefix", prefixRule("old", "new"), `<old:a xmlns:old="urn:x"><old:b xmlns:old="urn:y"/></old:a>`, `<new:a xmlns:new="urn:x"><new:b xmlns:new="urn:y"></new:b></new:a>`},
+		{"same declaration merged", prefixRule("old", "new"), `<a xmlns:old="urn:x" xmlns:new="urn:x"><old:b/></a>`, `<a xmlns:new="urn:x"><new:b></new:b></a>`},
+		{
+			name:  "undeclared prefix kept",
+			cfg:   Config{PrefixRules: []ConfigPrefixRule{{Old: "old", New: "new"}}, Input: ConfigInput{UndeclaredPrefixes: "keep"}},
+			input: `<a><old:b/></a>`,
+			want:  `<a><new:b></new:b></a>`,
+		},
+	})
+}
+
+func TestPrefixRulesErrors(t *testing.T) {
+	for _, prefix := range []string{"", "1x", "a:b", "xml", "xmlns"} {
+		wantRuleConfigError(t, Config{PrefixRules: []ConfigPrefixRule{{Old: "p", New: prefix}}}, "prefix_rules[0]")
+	}
+
+	cfg := Config{PrefixRules: []ConfigPrefixRule{{Old: "old", New: "new"}}}
+	_, err := tryTransformString(t, cfg, `<a xmlns:old="urn:x" xmlns:new="urn:y"/>`)
+	var encodeErr *EncodeError
+	if !errors.As(err, &encodeErr) || encodeErr.Rule != "prefix_rules[0]" {
+		t.Errorf("Transform error = %v, want an EncodeError for prefix_rules[0]", err)
+	}
+}
+
+func TestAttrCleanupRules(t *testing.T) {
+	const input = `<a k="&quot;1&quot;"><b k="&quot;2&quot;" v="&quot;3&quot;"></b></a>`
+	cleanupRules := func(rules ...ConfigAttrCleanupRule) Config {
+		return Config{AttrCleanupRules: rules}
+	}
+	runRuleTests(t, []ruleTest{
+		{"off by default", Config{}, input, `<a k="&#34;1&#34;"><b k="&#34;2&#34;" v="&#34;3&#34;"></b></a>`},
+		{"all", cleanupRules(ConfigAttrCleanupRule{}), input, `<a k="1"><b k="2" v="3"></b></a>`},
+		{"target", cleanupRules(ConfigAttrCleanupRule{Target: "b"}), input, `<a k="&#34;1&#34;"><b k="2" v="3"></b></a>`},
+		{"attributes", cleanupRules(ConfigAttrCleanupRule{Attributes: []string{"v"}}), input, `<a k="&#34;1&#34;"><b k="&#34;2&#34;" v="3"></b></a>`},
+		{"partly quoted", cleanupRules(ConfigAttrCleanupRule{}), `<a k="&quot;1" v="a&quot;b&quot;"></a>`, `<a k="&#34;1" v="a&#34;b&#34;"></a>`},
+		{"only one pair", cleanupRules(ConfigAttrCleanupRule{}), `<a k="&quot;&quot;1&quot;&quot;"></a>`, `<a k="&#34;1&#34;"></a>`},
+		{"prefixed attribute", cleanupRules(ConfigAttrCleanupRule{Attributes: []string{"k"}}), `<a xmlns:p="urn:p" p:k="&quot;1&quot;"></a>`, `<a xmlns:p="urn:p" p:k="1"></a>`},
+	})
+}
+
+func TestIfAttributes(t *testing.T) {
+	const input = `<a><s type="appendix" id="1"></s><s type="body"></s><s></s></a>`
+	appendix := map[string]string{"type": "appendix"}
+	runRuleTests(t, []ruleTest{
+		{"insert", Config{InsertRules: []ConfigInsertRule{{Target: "s", Template: "<n/>", IfAttributes: appendix}}}, input, `<a><n></n><s type="appendix" id="1"></s><s type="body"></s><s></s></a>`},
+		{"insert after", Config{InsertAfterRules: []ConfigInsertRule{{Target: "s", Template: "<n/>", IfAttributes: appendix}}}, input, `<a><s type="appendix" id="1"></s><n></n><s type="body"></s><s></s></a>`},
+		{"prepend child", Config{PrependChildRules: []ConfigInsertRule{{Target: "s", Template: "<n/>", IfAttributes: appendix}}}, input, `<a><s type="appendix" id="1"><n></n></s><s type="body"></s><s></s></a>`},
+		{
+			name:  "all attributes must match",
+			cfg:   Config{InsertRules: []ConfigInsertRule{{Target: "s", Template: "<n/>", IfAttributes: map[string]string{"type": "appendix", "id": "2"}}}},
+			input: input,
+			want:  input,
+		},
+		{
+			name:  "prefixed attribute",
+			cfg:   Config{InsertRules: []ConfigInsertRule{{Target: "s", Template: "<n/>", IfAttributes: map[string]string{"type": "x"}}}},
+			input: `<a xmlns:p="urn:p"><s p:type="x"></s></a>`,
+			want:  `<a xmlns:p="urn:p"><n></n><s p:type="x"></s></a>`,
+		},
+	})
+}
+
+func TestIfAttributesWarning(t *testing.T) {
+	cfg := Config{InsertRules: []ConfigInsertRule{{Target: "s", Template: "<n/>", IfAttributes: map[string]string{"type": "x", "id": "1"}}}}
+	_, result := transformString(t, cfg, `<a><s type="x"></s></a>`)
+	want := []string{"insert_rules[0] (target 's' with id='1', type='x') never matched"}
+	if !reflect.DeepEqual(result.Warnings, want) {
+		t.Errorf("Warnings = %q, want %q", result.Warnings, want)
+	}
+}
+
+func TestIfAttributesErrors(t *testing.T) {
+	for _, name := range []string{"", "a b", "a=b"} {
+		cfg := Config{InsertRules: []ConfigInsertRule{{Target: "s", Template: "<n/>", IfAttributes: map[string]string{name: "x"}}}}
+		wantRuleConfigError(t, cfg, "insert_rules[0]")
+	}
+}
+
+func TestAttrDeleteRules(t *testing.T) {
+	deleteRule := func(target string, patterns ...string) Config {
+		return Config{AttrDeleteRules: []ConfigAttrDeleteRule{{Target: target, Patterns: patterns}}}
+	}
+	runRuleTests(t, []ruleTest{
+		{"exact name", deleteRule("", "id"), `<a id="1"><b id="2" k="3"></b></a>`, `<a><b k="3"></b></a>`},
+		{"wildcard", deleteRule("", "data-*"), `<a data-x="1" data-y="2" datum="3"></a>`, `<a datum="3"></a>`},
+		{"single character and range", deleteRule("", "x?", "[0-9]*"), `<a x1="1" x12="2" y="3"></a>`, `<a x12="2" y="3"></a>`},
+		{"target", deleteRule("b", "id"), `<a id="1"><b id="2"></b></a>`, `<a id="1"><b></b></a>`},
+		{"local name ignores prefix", deleteRule("", "id"), `<a xmlns:p="urn:p" p:id="1"></a>`, `<a xmlns:p="urn:p"></a>`},
+		{"qualified pattern", deleteRule("", "tool:*"), `<a xmlns:tool="urn:t" tool:x="1" x="2"></a>`, `<a xmlns:tool="urn:t" x="2"></a>`},
+		{"declarations kept", deleteRule("", "*"), `<a xmlns="urn:d" xmlns:p="urn:p" k="1"></a>`, `<a xmlns="urn:d" xmlns:p="urn:p"></a>`},
+	})
+}
+
+func TestAttrDeleteRulesErrors(t *testing.T) {
+	wantRuleConfigError(t, Config{AttrDeleteRules: []ConfigAttrDeleteRule{{}}}, "attr_delete_rules[0]")
+	wantRuleConfigError(t, Config{AttrDeleteRules: []ConfigAttrDeleteRule{{Patterns: []string{"[a-"}}}}, "attr_delete_rules[0]")
+	wantRuleConfigError(t, Config{AttrDeleteRules: []ConfigAttrDeleteRule{{Patterns: []string{"id", ""}}}}, "attr_delete_rules[0]")
+}
+
+func TestNamespaceRules(t *testing.T) {
+	namespaceRules := func(rules ...ConfigNamespaceRule) Config {
+		return Config{NamespaceRules: rules}
+	}
+	rename := ConfigNamespaceRule{Old: "urn:old", New: "urn:new"}
+	runRuleTests(t, []ruleTest{
+		{"default namespace", namespaceRules(rename), `<a xmlns="urn:old"><b/></a>`, `<a xmlns="urn:new"><b></b></a>`},
+		{"prefixed", namespaceRules(rename), `<p:a xmlns:p="urn:old"><p:b p:k="1"/></p:a>`, `<p:a xmlns:p="urn:new"><p:b p:k="1"></p:b></p:a>`},
+		{"other namespaces untouched", namespaceRules(rename), `<a xmlns="urn:other" xmlns:p="urn:old"><p:b/></a>`, `<a xmlns="urn:other" xmlns:p="urn:new"><p:b></p:b></a>`},
+		{"values untouched", namespaceRules(rename), `<a xmlns="urn:old" ref="urn:old">urn:old</a>`, `<a xmlns="urn:new" ref="urn:old">urn:old</a>`},
+		{"redeclared", namespaceRules(rename), `<a xmlns="urn:x"><b xmlns="urn:old"/></a>`, `<a xmlns="urn:x"><b xmlns="urn:new"></b></a>`},
+		{
+			name:  "not chained",
+			cfg:   namespaceRules(rename, ConfigNamespaceRule{Old: "urn:new", New: "urn:newer"}),
+			input: `<a xmlns="urn:old" xmlns:p="urn:new"/>`,
+			want:  `<a xmlns="urn:new" xmlns:p="urn:newer"></a>`,
+		},
+	})
+}
+
+func TestNamespaceRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ConfigNamespaceRule
+	}{
+		{"empty old", ConfigNamespaceRule{New: "urn:new"}},
+		{"empty new", ConfigNamespaceRule{Old: "urn:old"}},
+		{"same", ConfigNamespaceRule{Old: "urn:x", New: "urn:x"}},
+		{"xml namespace", ConfigNamespaceRule{Old: "http://www.w3.org/XML/1998/namespace", New: "urn:x"}},
+		{"to the xml namespace", ConfigNamespaceRule{Old: "urn:x", New: "http://www.w3.org/XML/1998/namespace"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantRuleConfigError(t, Config{NamespaceRules: []ConfigNamespaceRule{tt.rule}}, "namespace_rules[0]")
+		})
+	}
+}
+
+func TestDefaultNamespaceRules(t *testing.T) {
+	defaultNamespace := func(rules ...ConfigDefaultNamespaceRule) Config {
+		return Config{DefaultNamespaceRules: rules}
+	}
+	set := ConfigDefaultNamespaceRule{URI: "urn:d"}
+	scrub := ConfigDefaultNamespaceRule{URI: "urn:d", Scrub: true}
+	runRuleTests(t, []ruleTest{
+		{"no namespace", defaultNamespace(set), `<a k="1"><b/></a>`, `<a xmlns="urn:d" k="1"><b></b></a>`},
+		{"replaces the root declaration", defaultNamespace(set), `<a xmlns="urn:old"><b/></a>`, `<a xmlns="urn:d"><b></b></a>`},
+		{"prefixed elements untouched", defaultNamespace(set), `<a xmlns:p="urn:p"><p:b/></a>`, `<a xmlns="urn:d" xmlns:p="urn:p"><p:b></p:b></a>`},
+		{"redeclared descendant kept", defaultNamespace(set), `<a><b xmlns="urn:x"><c/></b></a>`, `<a xmlns="urn:d"><b xmlns="urn:x"><c></c></b></a>`},
+		{"undeclared descendant kept", defaultNamespace(set), `<a xmlns="urn:old"><b xmlns=""/></a>`, `<a xmlns="urn:d"><b xmlns=""></b></a>`},
+		{"scrub", defaultNamespace(scrub), `<a><b xmlns="urn:x"><c/></b><d xmlns=""/></a>`, `<a xmlns="urn:d"><b><c></c></b><d></d></a>`},
+		{"target", defaultNamespace(ConfigDefaultNamespaceRule{Target: "x", URI: "urn:d"}), `<a><b/></a>`, `<a><b></b></a>`},
+		{
+			name:  "first matching rule",
+			cfg:   defaultNamespace(ConfigDefaultNamespaceRule{Target: "a", URI: "urn:a"}, set),
+			input: `<a/>`,
+			want:  `<a xmlns="urn:a"></a>`,
+		},
+	})
+}
+
+func TestDefaultNamespaceRulesErrors(t *testing.T) {
+	wantRuleConfigError(t, Config{DefaultNamespaceRules: []ConfigDefaultNamespaceRule{{}}}, "default_namespace_rules[0]")
+	wantRuleConfigError(t, Config{DefaultNamespaceRules: []ConfigDefaultNamespaceRule{{URI: "http://www.w3.org/XML/1998/namespace"}}}, "default_namespace_rules[0]")
+}
+
+func TestRootRules(t *testing.T) {
+	rootRules := func(rules ...ConfigRootRule) Config {
+		return Config{RootRules: rules}
+	}
+	runRuleTests(t, []ruleTest{
+		{"rename", rootRules(ConfigRootRule{Name: "doc"}), `<a k="1"><a/></a>`, `<doc k="1"><a></a></doc>`},
+		{"attributes", rootRules(ConfigRootRule{Attributes: map[string]string{"v": "2", "k": "new"}}), `<a k="1"><b/></a>`, `<a k="new" v="2"><b></b></a>`},
+		{"replace", rootRules(ConfigRootRule{Attributes: map[string]string{"v": "2"}, Replace: true}), `<a xmlns:p="urn:p" k="1" p:x="2"/>`, `<a xmlns:p="urn:p" v="2"></a>`},
+		{
+			name:  "namespace declaration before prefixed attributes",
+			cfg:   rootRules(ConfigRootRule{Attributes: map[string]string{"xsi:type": "T", "xmlns:xsi": "http://www.w3.org/2001/XMLSchema-instance"}}),
+			input: `<a/>`,
+			want:  `<a xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="T"></a>`,
+		},
+		{"target", rootRules(ConfigRootRule{Target: "x", Name: "doc"}), `<a/>`, `<a></a>`},
+		{"first matching rule", rootRules(ConfigRootRule{Target: "a", Name: "one"}, ConfigRootRule{Name: "two"}), `<a/>`, `<one></one>`},
+		{"placeholder", rootRules(ConfigRootRule{Attributes: map[string]string{"id": `{{var "code"}}`}}), `<a/>`, `<a id=""></a>`},
+		{
+			name: "after name rules",
+			cfg: Config{
+				NameRules: []ConfigNameRule{{Old: "a", New: "b"}},
+				RootRules: []ConfigRootRule{{Target: "a", Attributes: map[string]string{"k": "1"}}},
+			},
+			input: `<a/>`,
+			want:  `<b k="1"></b>`,
+		},
+	})
+}
+
+func TestRootRulesHits(t *testing.T) {
+	// 名前と属性をまとめて置き換えても、ルールの適用は1回と数える
+	tests := []struct {
+		name string
+		rule ConfigRootRule
+		want map[string]int
+	}{
+		{"name and attributes", ConfigRootRule{Name: "doc", Attributes: map[string]string{"v": "2"}, Replace: true}, map[string]int{"root_rules[0]": 1}},
+		{"unchanged", ConfigRootRule{Attributes: map[string]string{"k": "1"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, result := transformString(t, Config{RootRules: []ConfigRootRule{tt.rule}}, `<a k="1"><b/></a>`)
+			if !reflect.DeepEqual(result.RuleHits, tt.want) {
+				t.Errorf("RuleHits = %v, want %v", result.RuleHits, tt.want)
+			}
+			if len(result.Warnings) != 0 {
+				t.Errorf("Warnings = %q, want none", result.Warnings)
+			}
+		})
+	}
+}
+
+func TestRootRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ConfigRootRule
+	}{
+		{"nothing to do", ConfigRootRule{Target: "a"}},
+		{"invalid name", ConfigRootRule{Name: "1a"}},
+		{"invalid attribute name", ConfigRootRule{Attributes: map[string]string{"a b": "1"}}},
+		{"default namespace", ConfigRootRule{Attributes: map[string]string{"xmlns": "urn:x"}}},
+		{"empty declaration", ConfigRootRule{Attributes: map[string]string{"xmlns:p": ""}}},
+		{"illegal value", ConfigRootRule{Attributes: map[string]string{"k": "\x01"}}},
+		{"invalid placeholder", ConfigRootRule{Attributes: map[string]string{"k": "{{nope}}"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantRuleConfigError(t, Config{RootRules: []ConfigRootRule{tt.rule}}, "root_rules[0]")
+		})
+	}
+}
+
+func TestSchemaLocationRules(t *testing.T) {
+	const xsi = `xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"`
+	schemaRules := func(rules ...ConfigSchemaLocationRule) Config {
+		return Config{SchemaLocationRules: rules}
+	}
+	runRuleTests(t, []ruleTest{
+		{"no namespace", schemaRules(ConfigSchemaLocationRule{Location: "a.xsd"}), `<a><b/></a>`, `<a ` + xsi + ` xsi:noNamespaceSchemaLocation="a.xsd"><b></b></a>`},
+		{"namespace", schemaRules(ConfigSchemaLocationRule{Namespace: "urn:a", Location: "a.xsd"}), `<a/>`, `<a ` + xsi + ` xsi:schemaLocation="urn:a a.xsd"></a>`},
+		{
+			name:  "replaces the location of the same namespace",
+			cfg:   schemaRules(ConfigSchemaLocationRule{Namespace: "urn:a", Location: "new.xsd"}),
+			input: `<a ` + xsi + ` xsi:schemaLocation="urn:a old.xsd urn:b b.xsd"/>`,
+			want:  `<a ` + xsi + ` xsi:schemaLocation="urn:a new.xsd urn:b b.xsd"></a>`,
+		},
+		{
+			name:  "adds a pair",
+			cfg:   schemaRules(ConfigSchemaLocationRule{Namespace: "urn:c", Location: "c.xsd"}),
+			input: `<a ` + xsi + ` xsi:schemaLocation="urn:b b.xsd"/>`,
+			want:  `<a ` + xsi + ` xsi:schemaLocation="urn:b b.xsd urn:c c.xsd"></a>`,
+		},
+		{
+			name:  "every matching rule",
+			cfg:   schemaRules(ConfigSchemaLocationRule{Namespace: "urn:a", Location: "a.xsd"}, ConfigSchemaLocationRule{Namespace: "urn:b", Location: "b.xsd"}),
+			input: `<a/>`,
+			want:  `<a ` + xsi + ` xsi:schemaLocation="urn:a a.xsd urn:b b.xsd"></a>`,
+		},
+		{
+			name:  "existing prefix for the xsi namespace",
+			cfg:   schemaRules(ConfigSchemaLocationRule{Location: "a.xsd"}),
+			input: `<a xmlns:i="http://www.w3.org/2001/XMLSchema-instance"/>`,
+			want:  `<a xmlns:i="http://www.w3.org/2001/XMLSchema-instance" i:noNamespaceSchemaLocation="a.xsd"></a>`,
+		},
+		{"target", schemaRules(ConfigSchemaLocationRule{Target: "b", Location: "b.xsd"}), `<a><b/></a>`, `<a><b ` + xsi + ` xsi:noNamespaceSchemaLocation="b.xsd"></b></a>`},
+	})
+}
+
+func TestSchemaLocationRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ConfigSchemaLocationRule
+	}{
+		{"no location", ConfigSchemaLocationRule{Namespace: "urn:a"}},
+		{"space in location", ConfigSchemaLocationRule{Location: "a b.xsd"}},
+		{"space in namespace", ConfigSchemaLocationRule{Namespace: "urn a", Location: "a.xsd"}},
+		{"illegal character", ConfigSchemaLocationRule{Location: "a\x01.xsd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantRuleConfigError(t, Config{SchemaLocationRules: []ConfigSchemaLocationRule{tt.rule}}, "schema_location_rules[0]")
+		})
+	}
+}
+
+func TestNilRules(t *testing.T) {
+	const xsi = `xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"`
+	nilRule := func(mode string) Config {
+		return Config{NilRules: []ConfigNilRule{{Target: "v", Mode: mode}}}
+	}
+	runRuleTests(t, []ruleTest{
+		{"add to empty", nilRule("add"), `<a><v/><v>1</v></a>`, `<a><v ` + xsi + ` xsi:nil="true"></v><v>1</v></a>`},
+		{"add drops whitespace", nilRule("add"), "<a><v> \n </v></a>", `<a><v ` + xsi + ` xsi:nil="true"></v></a>`},
+		{"add skips elements with children", nilRule("add"), `<a><v><c/></v></a>`, `<a><v><c></c></v></a>`},
+		{"add uses the declared prefix", nilRule("add"), `<a ` + xsi + `><v/></a>`, `<a ` + xsi + `><v xsi:nil="true"></v></a>`},
+		{"add keeps an existing nil", nilRule("add"), `<a ` + xsi + `><v xsi:nil="true"/></a>`, `<a ` + xsi + `><v xsi:nil="true"></v></a>`},
+		{"strip", nilRule("strip"), `<a ` + xsi + `><v xsi:nil="true" k="1"> </v><v xsi:nil="1"/><v>x</v></a>`, `<a ` + xsi + `><v k="1"></v><v></v><v>x</v></a>`},
+		{"strip keeps false", nilRule("strip"), `<a ` + xsi + `><v xsi:nil="false">x</v></a>`, `<a ` + xsi + `><v xsi:nil="false">x</v></a>`},
+		{"strip drops children", nilRule("strip"), `<a ` + xsi + `><v xsi:nil="true"><c/></v></a>`, `<a ` + xsi + `><v></v></a>`},
+	})
+}
+
+func TestNilRulesErrors(t *testing.T) {
+	wantRuleConfigError(t, Config{NilRules: []ConfigNilRule{{Target: "v"}}}, "nil_rules[0]")
+	wantRuleConfigError(t, Config{NilRules: []ConfigNilRule{{Target: "v", Mode: "remove"}}}, "nil_rules[0]")
+}
+
+func TestDateRules(t *testing.T) {
+	dateRule := func(rule ConfigDateRule) Config {
+		return Config{DateRules: []ConfigDateRule{rule}}
+	}
+	runRuleTests(t, []ruleTest{
+		{"every target", dateRule(ConfigDateRule{Targets: []string{"from", "to"}}), `<a><from>令和6年1月5日</from><to>2024/2/1</to><n>2024/3/1</n></a>`, `<a><from>2024-01-05</from><to>2024-02-01</to><n>2024/3/1</n></a>`},
+		{"format and layouts", dateRule(ConfigDateRule{Targets: []string{"d"}, Layouts: []string{"02.01.2006"}, Format: "2006/01/02"}), `<a><d>05.01.2024</d><d>R6.1.6</d></a>`, `<a><d>2024/01/05</d><d>2024/01/06</d></a>`},
+		{"keep", dateRule(ConfigDateRule{Targets: []string{"d"}, OnError: "keep"}), `<a><d>unknown</d></a>`, `<a><d>unknown</d></a>`},
+		{"blank", dateRule(ConfigDateRule{Targets: []string{"d"}}), `<a><d></d></a>`, `<a><d></d></a>`},
+		{
+			name: "output names",
+			cfg: Config{
+				NameRules: []ConfigNameRule{{Old: "date", New: "d"}},
+				DateRules: []ConfigDateRule{{Targets: []string{"d"}}},
+			},
+			input: `<a><date>20240105</date></a>`,
+			want:  `<a><d>2024-01-05</d></a>`,
+		},
+	})
+}
+
+func TestDateRulesErrors(t *testing.T) {
+	wantRuleConfigError(t, Config{DateRules: []ConfigDateRule{{}}}, "date_rules[0]")
+	wantRuleConfigError(t, Config{DateRules: []ConfigDateRule{{Targets: []string{"d"}, OnError: "skip"}}}, "date_rules[0]")
+
+	_, err := tryTransformString(t, Config{DateRules: []ConfigDateRule{{Targets: []string{"d"}}}}, `<a><d>unknown</d></a>`)
+	var encodeErr *EncodeError
+	if !errors.As(err, &encodeErr) || encodeErr.Rule != "date_rules[0]" {
+		t.Errorf("Transform error = %v, want an EncodeError for date_rules[0]", err)
+	}
+}
+
+func TestUnicodeRules(t *testing.T) {
+	const input = `<a k="ＡＢ"><b k="①">ｶﾞ１</b></a>`
+	unicodeRules := func(rules ...ConfigUnicodeRule) Config {
+		return Config{UnicodeRules: rules}
+	}
+	runRuleTests(t, []ruleTest{
+		{"all", unicodeRules(ConfigUnicodeRule{Form: "NFKC"}), input, `<a k="AB"><b k="1">ガ1</b></a>`},
+		{"text", unicodeRules(ConfigUnicodeRule{Form: "nfkc", Scope: "text"}), input, `<a k="ＡＢ"><b k="①">ガ1</b></a>`},
+		{"attributes", unicodeRules(ConfigUnicodeRule{Form: "NFKC", Scope: "attributes"}), input, `<a k="AB"><b k="1">ｶﾞ１</b></a>`},
+		{"target", unicodeRules(ConfigUnicodeRule{Target: "b", Form: "NFKC"}), input, `<a k="ＡＢ"><b k="1">ガ1</b></a>`},
+		{"first matching rule", unicodeRules(ConfigUnicodeRule{Target: "b", Form: "NFC"}, ConfigUnicodeRule{Form: "NFKC"}), input, `<a k="AB"><b k="①">ｶﾞ１</b></a>`},
+		{"nfd", unicodeRules(ConfigUnicodeRule{Form: "NFD"}), "<a>\u00e9</a>", "<a>e\u0301</a>"},
+		{"raw tags untouched", Config{RawTags: []string{"r"}, UnicodeRules: []ConfigUnicodeRule{{Form: "NFKC"}}}, `<a><r>１</r></a>`, `<a><r><![CDATA[１]]></r></a>`},
+	})
+}
+
+func TestUnicodeRulesErrors(t *testing.T) {
+	wantRuleConfigError(t, Config{UnicodeRules: []ConfigUnicodeRule{{Form: "NFX"}}}, "unicode_rules[0]")
+	wantRuleConfigError(t, Config{UnicodeRules: []ConfigUnicodeRule{{Form: "NFC", Scope: "names"}}}, "unicode_rules[0]")
+}
@@ -0,0 +1,859 @@
+package obufuku
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/encoding"
+)
+
+// RuleSet は、Config から組み立てた実行用のルールと入出力設定です。
+// 1つの RuleSet から複数の Processor を作成できますが、カウンターは共有されます。
+type RuleSet struct {
+	nameRules             []NameReplaceRule
+	insertRules           []InsertBeforeRule
+	insertAfterRules      []InsertBeforeRule
+	prependChildRules     []InsertBeforeRule
+	valueRules            []ValueReplaceRule
+	dateRules             []DateRule
+	unicodeRules          []UnicodeRule
+	wrapRules             []WrapRule
+	cdataRules            []CdataRule
+	captureRules          []CaptureRule
+	summaryRules          []SummaryRule
+	deleteRules           []DeleteRule
+	dedupeRules           []DedupeRule
+	reorderRules          []ReorderRule
+	assertRules           []AssertRule
+	caseRules             []CaseRule
+	attrCleanupRules      []AttrCleanupRule
+	attrDeleteRules       []AttrDeleteRule
+	prefixRules           []PrefixRule
+	namespaceRules        []NamespaceRule
+	rootRules             []RootRule
+	schemaLocationRules   []SchemaLocationRule
+	nilRules              []NilRule
+	defaultNamespaceRules []DefaultNamespaceRule
+	rawTags               []string
+	filterOrder           []string
+
+	// passes は、このルールセットのルールに続けて順に適用するパスのルールです。
+	passes []*RuleSet
+	// observers は、このルールセットで作成するすべての Processor に追加する Observer です。
+	observers []Observer
+	// descriptions は、説明が設定されたルールの、ルールファイルでの位置 ("value_rules[2]" など) と説明です。
+	descriptions map[string]string
+	// persisted は、値を保存するカウンターの、状態ファイルごとのカウンター名とカウンターです。
+	persisted map[string]map[string]*Counter
+
+	// Input は、入力の読み込みに関する設定です。
+	Input InputOptions
+	// Output は、出力形式に関する設定です。
+	Output OutputOptions
+	// OutputEncoding は、UTF-8以外の出力エンコーディングです (UTF-8の場合は nil)。
+	// Processor はUTF-8で書き出すため、出力先を NewEncodingWriter でラップして変換します。
+	OutputEncoding encoding.Encoding
+}
+
+// NewRuleSet は、設定を検証し、実行用のルールと入出力設定を組み立てます。
+// 設定に passes がある場合は、最上位のルールに続けて適用する各パスのルールも組み立てます。
+func NewRuleSet(config Config) (*RuleSet, error) {
+	rules := &RuleSet{}
+
+	// カウンターの準備 (すべてのパスで共有する)
+	counters := make(map[string]*Counter)
+	for name, counterConfig := range config.Counters {
+		start, err := counterStart(counterConfig)
+		if err != nil {
+			return nil, &RuleConfigError{Rule: "counters." + name, Err: err}
+		}
+		counters[name] = &Counter{current: start}
+	}
+	if err := rules.buildRules(config, counters); err != nil {
+		return nil, err
+	}
+
+	// パスの組み立て
+	for i, pass := range config.Passes {
+		passRules, err := newPassRuleSet(pass, counters)
+		if err != nil {
+			return nil, &RuleConfigError{Rule: fmt.Sprintf("passes[%d]", i), Err: err}
+		}
+		rules.passes = append(rules.passes, passRules)
+	}
+
+	// 保存したカウンターの値の読み込み
+	if err := rules.loadCounters(config, counters); err != nil {
+		return nil, err
+	}
+
+	// 出力設定の組み立て
+	output, outputEncoding, err := buildOutputOptions(config.Output)
+	if err != nil {
+		return nil, &RuleConfigError{Rule: "output", Err: err}
+	}
+	rules.Output = output
+	rules.OutputEncoding = outputEncoding
+
+	// 入力設定の組み立て
+	input, err := buildInputOptions(config.Input, output.Minimal)
+	if err != nil {
+		return nil, &RuleConfigError{Rule: "input", Err: err}
+	}
+	rules.Input = input
+
+	return rules, nil
+}
+
+// newPassRuleSet は、passes の1つのパスのルールを組み立てます。
+// パスの入出力は最上位の設定に従うため、パスには入出力設定や入れ子のパスを指定できません。
+// パスで定義したカウンターは counters に加え、以降のパスからも参照できます。
+func newPassRuleSet(config Config, counters map[string]*Counter) (*RuleSet, error) {
+	if len(config.Passes) > 0 {
+		return nil, fmt.Errorf("passes cannot be nested")
+	}
+	if !reflect.DeepEqual(config.Input, ConfigInput{}) || !reflect.DeepEqual(config.Output, ConfigOutput{}) {
+		return nil, fmt.Errorf("'input' and 'output' can only be set at the top level")
+	}
+	for name, counterConfig := range config.Counters {
+		if _, dup := counters[name]; dup {
+			return nil, fmt.Errorf("counter '%s' is already defined", name)
+		}
+		start, err := counterStart(counterConfig)
+		if err != nil {
+			return nil, fmt.Errorf("counters.%s: %w", name, err)
+		}
+		counters[name] = &Counter{current: start}
+	}
+	rules := &RuleSet{}
+	if err := rules.buildRules(config, counters); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// buildRules は、設定のルールを検証して組み立てます。counters は、ルールが参照するカウンターです。
+func (rules *RuleSet) buildRules(config Config, counters map[string]*Counter) error {
+	if err := checkRuleConflicts(config, counters); err != nil {
+		return err
+	}
+	if err := checkGeneratedNames(config); err != nil {
+		return err
+	}
+	if err := checkTemplates(config); err != nil {
+		return err
+	}
+	rules.descriptions = ruleDescriptions(config)
+
+	// NameRules の組み立て
+	for _, r := range config.NameRules {
+		rules.nameRules = append(rules.nameRules, NameReplaceRule{OldName: r.Old, NewName: r.New})
+	}
+
+	// InsertRules の組み立て
+	for i, r := range config.InsertRules {
+		if r.IfMissing != "" {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitInsertRules, i), Err: errIfMissingUnsupported}
+		}
+		rule, err := newInsertRule(r, counters)
+		if err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitInsertRules, i), Err: err}
+		}
+		rules.insertRules = append(rules.insertRules, rule)
+	}
+
+	// InsertAfterRules の組み立て
+	for i, r := range config.InsertAfterRules {
+		if r.IfMissing != "" {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitInsertAfterRules, i), Err: errIfMissingUnsupported}
+		}
+		rule, err := newInsertRule(r, counters)
+		if err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitInsertAfterRules, i), Err: err}
+		}
+		rules.insertAfterRules = append(rules.insertAfterRules, rule)
+	}
+
+	// PrependChildRules の組み立て
+	for i, r := range config.PrependChildRules {
+		rule, err := newInsertRule(r, counters)
+		if err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitPrependChildRules, i), Err: err}
+		}
+		rules.prependChildRules = append(rules.prependChildRules, rule)
+	}
+
+	// ValueRules の組み立て
+	for i, r := range config.ValueRules {
+		// script はカウンターを参照するため、登録された種類とは別に組み立てる
+		if r.Type == scriptValueType {
+			contextFunc, err := newScriptFunc(r.Params, counters)
+			if err != nil {
+				return &RuleConfigError{Rule: fmt.Sprintf("value_rules[%d]", i), Err: err}
+			}
+			rules.valueRules = append(rules.valueRules, ValueReplaceRule{TargetTag: r.Target, ContextFunc: contextFunc})
+			continue
+		}
+		// raw_tags の要素の中身は値置換の対象にならないため、文字参照の変換は cdata_rules で指定させる
+		if mode, ok := htmlEntityValueTypes[r.Type]; ok {
+			for _, tag := range config.RawTags {
+				if tag == r.Target {
+					return &RuleConfigError{Rule: fmt.Sprintf("value_rules[%d]", i), Err: fmt.Errorf("'%s' does not apply to the content of raw_tags element '%s'; use cdata_rules with 'entities': '%s' instead", r.Type, tag, mode)}
+				}
+			}
+		}
+		rule, err := buildValueReplaceRule(r)
+		if err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("value_rules[%d]", i), Err: err}
+		}
+		rules.valueRules = append(rules.valueRules, rule)
+	}
+
+	// DateRules の組み立て
+	for i, r := range config.DateRules {
+		if len(r.Targets) == 0 {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitDateRules, i), Err: fmt.Errorf("'targets' is required")}
+		}
+		d, err := newDateNormalizer(r.Layouts, r.Format, r.OnError)
+		if err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitDateRules, i), Err: err}
+		}
+		rules.dateRules = append(rules.dateRules, DateRule{
+			TargetTags: r.Targets,
+			Func: func(oldValue string, ctx ValueContext) (string, error) {
+				return d.normalize(oldValue)
+			},
+		})
+	}
+
+	// UnicodeRules の組み立て
+	for i, r := range config.UnicodeRules {
+		form, err := lookupNormForm(r.Form)
+		if err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitUnicodeRules, i), Err: err}
+		}
+		rule := UnicodeRule{TargetTag: r.Target, Form: form}
+		switch r.Scope {
+		case "", unicodeScopeAll:
+			rule.Text, rule.Attributes = true, true
+		case unicodeScopeText:
+			rule.Text = true
+		case unicodeScopeAttributes:
+			rule.Attributes = true
+		default:
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitUnicodeRules, i), Err: fmt.Errorf("unknown scope '%s' (available: %s, %s, %s)", r.Scope, unicodeScopeAll, unicodeScopeText, unicodeScopeAttributes)}
+		}
+		rules.unicodeRules = append(rules.unicodeRules, rule)
+	}
+
+	// WrapRules の組み立て
+	for _, r := range config.WrapRules {
+		rules.wrapRules = append(rules.wrapRules, WrapRule{TargetTag: r.Target, WrapperTag: r.Wrapper})
+	}
+
+	// CdataRules の組み立て
+	for i, r := range config.CdataRules {
+		switch r.Entities {
+		case "":
+		case entitiesUnescape, entitiesEscape, entitiesDecode, entitiesNumeric:
+			if r.Old != "" || r.New != "" {
+				return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitCdataRules, i), Err: fmt.Errorf("'entities' cannot be combined with 'old' or 'new'")}
+			}
+		default:
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitCdataRules, i), Err: fmt.Errorf("unknown entities mode '%s' (expected '%s', '%s', '%s' or '%s')", r.Entities, entitiesUnescape, entitiesEscape, entitiesDecode, entitiesNumeric)}
+		}
+		rules.cdataRules = append(rules.cdataRules, CdataRule{Old: r.Old, New: r.New, Entities: r.Entities})
+	}
+
+	// CaptureRules の組み立て (変数名はテンプレートやスクリプトから参照できる名前に限る)
+	for i, r := range config.CaptureRules {
+		if r.Target == "" {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitCaptureRules, i), Err: fmt.Errorf("'target' must not be empty")}
+		}
+		if !variablePattern.MatchString(r.Variable) {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitCaptureRules, i), Err: fmt.Errorf("invalid variable name '%s' (letters, digits and '_', not starting with a digit)", r.Variable)}
+		}
+		rules.captureRules = append(rules.captureRules, CaptureRule{TargetTag: r.Target, Variable: r.Variable})
+	}
+
+	// SummaryRules の組み立て
+	for i, r := range config.SummaryRules {
+		function := r.Function
+		if function == "" {
+			function = summaryCount
+		}
+		if function != summaryCount && function != summarySum {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitSummaryRules, i), Err: fmt.Errorf("unknown function '%s' (available: %s, %s)", r.Function, summaryCount, summarySum)}
+		}
+		rules.summaryRules = append(rules.summaryRules, SummaryRule{TargetTag: r.Target, Element: r.Element, Function: function})
+	}
+
+	// DeleteRules の組み立て
+	for i, r := range config.DeleteRules {
+		rule := DeleteRule{TargetTag: r.Target, IfEmpty: r.IfEmpty}
+		if r.Pattern != "" {
+			pattern, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitDeleteRules, i), Err: fmt.Errorf("invalid pattern: %w", err)}
+			}
+			rule.Pattern = pattern
+		}
+		rules.deleteRules = append(rules.deleteRules, rule)
+	}
+
+	// DedupeRules の組み立て
+	for i, r := range config.DedupeRules {
+		if r.Scope != "" && r.Scope != dedupeConsecutive && r.Scope != dedupeAll {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitDedupeRules, i), Err: fmt.Errorf("unknown scope '%s' (available: %s, %s)", r.Scope, dedupeConsecutive, dedupeAll)}
+		}
+		rules.dedupeRules = append(rules.dedupeRules, DedupeRule{ParentTag: r.Parent, ChildTag: r.Child, All: r.Scope == dedupeAll})
+	}
+
+	// ReorderRules の組み立て
+	for i, r := range config.ReorderRules {
+		if len(r.Order) == 0 {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitReorderRules, i), Err: fmt.Errorf("'order' must not be empty")}
+		}
+		if r.Unknown != "" && r.Unknown != reorderAppend && r.Unknown != reorderReject {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitReorderRules, i), Err: fmt.Errorf("unknown value '%s' for 'unknown' (available: %s, %s)", r.Unknown, reorderAppend, reorderReject)}
+		}
+		rules.reorderRules = append(rules.reorderRules, ReorderRule{TargetTag: r.Target, Order: r.Order, RejectUnknown: r.Unknown == reorderReject})
+	}
+
+	// AssertRules の組み立て
+	for i, r := range config.AssertRules {
+		if len(r.Children) == 0 && len(r.Attributes) == 0 {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitAssertRules, i), Err: fmt.Errorf("at least one of 'children' or 'attributes' must be set")}
+		}
+		rules.assertRules = append(rules.assertRules, AssertRule{TargetTag: r.Target, Children: r.Children, Attributes: r.Attributes, Fail: r.Fail})
+	}
+
+	// CaseRules の組み立て
+	for i, r := range config.CaseRules {
+		if _, ok := nameCases[r.Case]; !ok {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitCaseRules, i), Err: fmt.Errorf("unknown case '%s' (available: %s, %s, %s)", r.Case, caseLower, caseUpper, caseCamel)}
+		}
+		rules.caseRules = append(rules.caseRules, CaseRule{Case: r.Case, Under: r.Under})
+	}
+
+	// PrefixRules の組み立て
+	for i, r := range config.PrefixRules {
+		for _, prefix := range []string{r.Old, r.New} {
+			if !isNCName(prefix) || prefix == "xml" || prefix == "xmlns" {
+				return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitPrefixRules, i), Err: fmt.Errorf("'%s' is not a valid namespace prefix", prefix)}
+			}
+		}
+		rules.prefixRules = append(rules.prefixRules, PrefixRule{Old: r.Old, New: r.New})
+	}
+
+	// NamespaceRules の組み立て
+	for i, r := range config.NamespaceRules {
+		if r.Old == "" || r.New == "" || r.Old == r.New {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitNamespaceRules, i), Err: fmt.Errorf("'old' and 'new' must be different non-empty namespace URIs")}
+		}
+		if r.Old == xmlNamespaceURI || r.New == xmlNamespaceURI {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitNamespaceRules, i), Err: fmt.Errorf("the XML namespace '%s' cannot be renamed", xmlNamespaceURI)}
+		}
+		rules.namespaceRules = append(rules.namespaceRules, NamespaceRule{Old: r.Old, New: r.New})
+	}
+
+	// RootRules の組み立て
+	for i, r := range config.RootRules {
+		rule, err := newRootRule(r)
+		if err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitRootRules, i), Err: err}
+		}
+		rules.rootRules = append(rules.rootRules, rule)
+	}
+
+	// SchemaLocationRules の組み立て
+	for i, r := range config.SchemaLocationRules {
+		if r.Location == "" || strings.ContainsAny(r.Location+r.Namespace, " \t\r\n") {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitSchemaLocationRules, i), Err: fmt.Errorf("'location' must be non-empty and neither 'namespace' nor 'location' may contain whitespace")}
+		}
+		if err := validateText("location", r.Namespace+r.Location); err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitSchemaLocationRules, i), Err: err}
+		}
+		rules.schemaLocationRules = append(rules.schemaLocationRules, SchemaLocationRule{TargetTag: r.Target, Namespace: r.Namespace, Location: r.Location})
+	}
+
+	// NilRules の組み立て
+	for i, r := range config.NilRules {
+		if r.Mode != nilAdd && r.Mode != nilStrip {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitNilRules, i), Err: fmt.Errorf("unknown mode '%s' (available: %s, %s)", r.Mode, nilAdd, nilStrip)}
+		}
+		rules.nilRules = append(rules.nilRules, NilRule{TargetTag: r.Target, Mode: r.Mode})
+	}
+
+	// DefaultNamespaceRules の組み立て
+	for i, r := range config.DefaultNamespaceRules {
+		if r.URI == "" || r.URI == xmlNamespaceURI {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitDefaultNamespaceRules, i), Err: fmt.Errorf("invalid default namespace URI: '%s'", r.URI)}
+		}
+		rules.defaultNamespaceRules = append(rules.defaultNamespaceRules, DefaultNamespaceRule{TargetTag: r.Target, URI: r.URI, Scrub: r.Scrub})
+	}
+
+	// AttrCleanupRules の組み立て
+	for _, r := range config.AttrCleanupRules {
+		rules.attrCleanupRules = append(rules.attrCleanupRules, AttrCleanupRule{TargetTag: r.Target, Attributes: r.Attributes})
+	}
+
+	// AttrDeleteRules の組み立て
+	for i, r := range config.AttrDeleteRules {
+		if len(r.Patterns) == 0 {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitAttrDeleteRules, i), Err: fmt.Errorf("'patterns' must not be empty")}
+		}
+		for _, pattern := range r.Patterns {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitAttrDeleteRules, i), Err: fmt.Errorf("invalid attribute name pattern '%s'", pattern)}
+			}
+		}
+		rules.attrDeleteRules = append(rules.attrDeleteRules, AttrDeleteRule{TargetTag: r.Target, Patterns: r.Patterns})
+	}
+
+	// RawTags はそのままスライスとして使う
+	rules.rawTags = config.RawTags
+
+	// フィルターの順序 (指定しなかったフィルターは既定の順序で後ろに加える)
+	filterOrder, err := resolveFilterOrder(config.Filters)
+	if err != nil {
+		return &RuleConfigError{Rule: "filters", Err: err}
+	}
+	rules.filterOrder = filterOrder
+
+	return nil
+}
+
+// checkRuleConflicts は、互いに矛盾するルールや、定義されていないカウンターの参照を検出します。
+// 同じタグ名の名前置換、名前置換の連鎖 (a→b と b→c。入れ替えは許す)、名前置換の対象と同じ名前のラッパー、
+// 同じ対象のラップをエラーとします。
+func checkRuleConflicts(config Config, counters map[string]*Counter) error {
+	renamed := make(map[string]int)
+	for i, r := range config.NameRules {
+		if j, dup := renamed[r.Old]; dup {
+			return &RuleConfigError{Rule: fmt.Sprintf("name_rules[%d]", i), Err: fmt.Errorf("tag '%s' is already renamed by name_rules[%d]", r.Old, j)}
+		}
+		renamed[r.Old] = i
+	}
+	for i, r := range config.NameRules {
+		// 名前置換は1回しか適用されないため、連鎖は途中で止まる (a→b と b→a の入れ替えは除く)
+		if j, chained := renamed[r.New]; chained && r.New != r.Old && config.NameRules[j].New != r.Old {
+			return &RuleConfigError{Rule: fmt.Sprintf("name_rules[%d]", i), Err: fmt.Errorf("new name '%s' is renamed again by name_rules[%d] (rename chains are not applied)", r.New, j)}
+		}
+	}
+
+	wrapped := make(map[string]int)
+	for i, r := range config.WrapRules {
+		if j, dup := wrapped[r.Target]; dup {
+			return &RuleConfigError{Rule: fmt.Sprintf("wrap_rules[%d]", i), Err: fmt.Errorf("target '%s' is already wrapped by wrap_rules[%d]", r.Target, j)}
+		}
+		wrapped[r.Target] = i
+		if j, conflict := renamed[r.Wrapper]; conflict {
+			return &RuleConfigError{Rule: fmt.Sprintf("wrap_rules[%d]", i), Err: fmt.Errorf("wrapper '%s' is a rename target of name_rules[%d] (wrapper elements are not renamed)", r.Wrapper, j)}
+		}
+	}
+
+	undefined := func(kind string, i int, counter string) error {
+		if _, ok := counters[counter]; counter == "" || ok {
+			return nil
+		}
+		return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", kind, i), Err: fmt.Errorf("counter '%s' is not defined in 'counters'", counter)}
+	}
+	for i, r := range config.InsertRules {
+		if err := undefined(hitInsertRules, i, r.Counter); err != nil {
+			return err
+		}
+	}
+	for i, r := range config.InsertAfterRules {
+		if err := undefined(hitInsertAfterRules, i, r.Counter); err != nil {
+			return err
+		}
+	}
+	for i, r := range config.PrependChildRules {
+		if err := undefined(hitPrependChildRules, i, r.Counter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkGeneratedNames は、ルールが出力する要素名が正しいXMLの名前であること、
+// 置換後の文字列にXMLで使えない文字が含まれていないことを検査します。
+func checkGeneratedNames(config Config) error {
+	for i, r := range config.NameRules {
+		if err := validateName("new name", r.New); err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("name_rules[%d]", i), Err: err}
+		}
+	}
+	for i, r := range config.WrapRules {
+		if err := validateName("wrapper", r.Wrapper); err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("wrap_rules[%d]", i), Err: err}
+		}
+	}
+	for i, r := range config.CdataRules {
+		if err := validateText("replacement", r.New); err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("cdata_rules[%d]", i), Err: err}
+		}
+	}
+	for i, r := range config.SummaryRules {
+		if err := validateName("element", r.Element); err != nil {
+			return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", hitSummaryRules, i), Err: err}
+		}
+	}
+	return nil
+}
+
+// checkTemplates は、挿入ルールのテンプレートが、カウンターの値を埋め込んだ状態で
+// 整形式のXMLの断片 (タグが対応している) であることを検査します。
+// CSVファイルの列を参照するテンプレートは、CSVファイルを読み込んでから newInsertRule で検査します。
+func checkTemplates(config Config) error {
+	for _, rules := range []struct {
+		kind  string
+		rules []ConfigInsertRule
+	}{
+		{hitInsertRules, config.InsertRules},
+		{hitInsertAfterRules, config.InsertAfterRules},
+		{hitPrependChildRules, config.PrependChildRules},
+	} {
+		for i, r := range rules.rules {
+			if r.CSV != "" {
+				continue
+			}
+			if err := validateTemplate(r.Template, r.Counter != "", nil); err != nil {
+				return &RuleConfigError{Rule: fmt.Sprintf("%s[%d]", rules.kind, i), Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// validateTemplate は、テンプレートを変換時と同じ方法で断片として読み込めるかを検査します。
+// counter が true の場合は、カウンターの値の代わりに 1 を埋め込んで検査します。
+// プレースホルダーは、名前と引数を検査し、生成した値を埋め込んで検査します。
+// extra は、parsePlaceholders と同じく、ルールによって使えるプレースホルダーです。
+func validateTemplate(template string, counter bool, extra map[string]placeholderFactory) error {
+	fragment := template
+	if counter {
+		fragment = fmt.Sprintf(template, 1)
+		if strings.Contains(fragment, "%!") {
+			return fmt.Errorf("template '%s' must contain exactly one %%d for the counter value", template)
+		}
+	}
+	ps, err := parsePlaceholders(template, extra)
+	if err != nil {
+		return fmt.Errorf("template '%s': %w", template, err)
+	}
+	if ps != nil {
+		fragment = ps.expand(fragment, nil)
+	}
+	if _, err := parseFragment(fragment); err != nil {
+		return fmt.Errorf("template '%s' is not a well-formed XML fragment: %w", template, err)
+	}
+	return nil
+}
+
+// ruleDescriptions は、設定のルールのうち説明 (description) が設定されたものの、ルールファイルでの位置
+// ("value_rules[2]" など) と説明を返します。ルールの種類は、Config の "_rules" で終わる項目から求めます。
+func ruleDescriptions(config Config) map[string]string {
+	var descriptions map[string]string
+	v := reflect.ValueOf(config)
+	for i := 0; i < v.NumField(); i++ {
+		kind := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if !strings.HasSuffix(kind, "_rules") {
+			continue
+		}
+		rules := v.Field(i)
+		for j := 0; j < rules.Len(); j++ {
+			description := rules.Index(j).FieldByName("Description").String()
+			if description == "" {
+				continue
+			}
+			if descriptions == nil {
+				descriptions = make(map[string]string)
+			}
+			descriptions[fmt.Sprintf("%s[%d]", kind, j)] = description
+		}
+	}
+	return descriptions
+}
+
+// parseFragment は、XMLの断片を filterWriter.WriteFragment と同じ方法で解析し、トークン列を返します。
+func parseFragment(fragment string) ([]xml.Token, error) {
+	var tokens []xml.Token
+	decoder := xml.NewDecoder(strings.NewReader(fragment))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return tokens, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, xml.CopyToken(token))
+	}
+}
+
+// errIfMissingUnsupported は、子の先頭への挿入以外のルールに if_missing を指定した場合のエラーです。
+var errIfMissingUnsupported = fmt.Errorf("'if_missing' is only supported by %s", hitPrependChildRules)
+
+// newInsertRule は、挿入ルールを組み立てます。カウンターもプレースホルダーも使わないテンプレートは、ここで解析しておきます。
+// CSVファイルを使う場合は、ここで読み込んで列を参照するテンプレートを検査します。
+// それ以外のテンプレートは checkTemplates で検査済みである必要があります。
+func newInsertRule(r ConfigInsertRule, counters map[string]*Counter) (InsertBeforeRule, error) {
+	rule := InsertBeforeRule{
+		TargetTag:   r.Target,
+		XMLTemplate: r.Template,
+		Counter:     counters[r.Counter],
+		IfMissing:   r.IfMissing,
+	}
+	for name := range r.IfAttributes {
+		if name == "" || strings.ContainsAny(name, " \t\r\n<>&\"'=") {
+			return rule, fmt.Errorf("invalid attribute name in 'if_attributes': '%s'", name)
+		}
+	}
+	if len(r.IfAttributes) > 0 {
+		rule.IfAttributes = r.IfAttributes
+	}
+	var extra map[string]placeholderFactory
+	if r.CSV != "" {
+		rows, err := loadCSVRows(r.CSV)
+		if err != nil {
+			return rule, err
+		}
+		rule.rows = rows
+		extra = rows.placeholderFuncs()
+		if err := validateTemplate(r.Template, r.Counter != "", extra); err != nil {
+			return rule, err
+		}
+	}
+	rule.placeholders, _ = parsePlaceholders(r.Template, extra)
+	if rule.Counter == nil && rule.placeholders == nil {
+		rule.tokens, _ = parseFragment(r.Template)
+	}
+	return rule, nil
+}
+
+// newRootRule は、ルート要素を置き換えるルールの設定を検証して組み立てます。
+func newRootRule(r ConfigRootRule) (RootRule, error) {
+	rule := RootRule{TargetTag: r.Target, NewName: r.Name, Replace: r.Replace}
+	if r.Name != "" {
+		if err := validateName("name", r.Name); err != nil {
+			return rule, err
+		}
+	}
+	if r.Name == "" && len(r.Attributes) == 0 && !r.Replace {
+		return rule, fmt.Errorf("'name' or 'attributes' is required")
+	}
+	for name, value := range r.Attributes {
+		if err := validateName("attribute", name); err != nil {
+			return rule, err
+		}
+		if name == "xmlns" {
+			return rule, fmt.Errorf("use default_namespace_rules to set the default namespace")
+		}
+		if strings.HasPrefix(name, "xmlns:") && value == "" {
+			return rule, fmt.Errorf("namespace declaration '%s' must not be empty", name)
+		}
+		if err := validateText(fmt.Sprintf("value of attribute '%s'", name), value); err != nil {
+			return rule, err
+		}
+		text, err := placeholderText(value)
+		if err != nil {
+			return rule, fmt.Errorf("invalid value of attribute '%s': %w", name, err)
+		}
+		rule.attributes = append(rule.attributes, rootAttribute{name: name, value: text})
+	}
+	if len(r.Attributes) > 0 {
+		rule.Attributes = r.Attributes
+	}
+	// 名前空間の宣言を先に設定し、接頭辞付きの属性がその宣言を参照できるようにする
+	sort.Slice(rule.attributes, func(i, j int) bool {
+		a, b := rule.attributes[i].name, rule.attributes[j].name
+		if da, db := strings.HasPrefix(a, "xmlns:"), strings.HasPrefix(b, "xmlns:"); da != db {
+			return da
+		}
+		return a < b
+	})
+	return rule, nil
+}
+
+// buildOutputOptions は、出力設定を検証して組み立てます。
+// UTF-8以外の出力エンコーディングの場合は、そのエンコーディングも返します。
+func buildOutputOptions(config ConfigOutput) (OutputOptions, encoding.Encoding, error) {
+	output := OutputOptions{
+		Compact:        config.Compact,
+		Minimal:        config.Minimal,
+		AttrWrapWidth:  config.AttrWrapWidth,
+		Canonical:      config.Canonical,
+		SortAttributes: config.SortAttributes,
+		AttributeOrder: config.AttributeOrder,
+	}
+	if output.Canonical && (output.Minimal || config.Encoding != "" && !strings.EqualFold(config.Encoding, "UTF-8")) {
+		return output, nil, fmt.Errorf("canonical output cannot be combined with 'minimal' or a non-UTF-8 'encoding'")
+	}
+	if output.AttrWrapWidth < 0 {
+		return output, nil, fmt.Errorf("output option 'attr_wrap_width' must not be negative")
+	}
+	if output.Compact && output.Minimal {
+		return output, nil, fmt.Errorf("output options 'compact' and 'minimal' cannot be used together")
+	}
+	if (output.SortAttributes || len(output.AttributeOrder) > 0) && (output.Minimal || output.Canonical) {
+		return output, nil, fmt.Errorf("output options 'sort_attributes' and 'attribute_order' cannot be used with 'minimal' or 'canonical'")
+	}
+	charRefs, err := buildCharRefOptions(config.CharRefs)
+	if err != nil {
+		return output, nil, err
+	}
+	if (charRefs.All || len(charRefs.Tags) > 0) && (output.Minimal || output.Canonical) {
+		return output, nil, fmt.Errorf("output option 'char_refs' cannot be used with 'minimal' or 'canonical'")
+	}
+	output.CharRefs = charRefs
+	var outputEncoding encoding.Encoding
+	if config.Encoding != "" {
+		enc, name, err := lookupEncoding(config.Encoding)
+		if err != nil {
+			return output, nil, err
+		}
+		output.Encoding = name
+		if !isUTF8(enc) {
+			outputEncoding = enc
+		}
+	}
+	declaration, err := buildDeclarationOptions(config.Declaration, outputEncoding)
+	if err != nil {
+		return output, nil, err
+	}
+	output.Declaration = declaration
+	selfClosing, err := buildSelfClosingOptions(config.SelfClosing)
+	if err != nil {
+		return output, nil, err
+	}
+	output.SelfClosing = selfClosing
+	comments, err := buildCommentOptions(config.Comments)
+	if err != nil {
+		return output, nil, err
+	}
+	output.Comments = comments
+	flush, err := buildFlushOptions(config.Flush)
+	if err != nil {
+		return output, nil, err
+	}
+	output.Flush = flush
+	newline, err := buildNewline(config.Newline, output.Minimal || output.Canonical)
+	if err != nil {
+		return output, nil, err
+	}
+	output.Newline = newline
+	return output, outputEncoding, nil
+}
+
+// buildNewline は、改行コードの設定を検証し、出力の改行コードを返します。
+// preserve は、改行コードを変換しない出力形式 (最小変更モード・正規化出力) かどうかです。
+func buildNewline(setting string, preserve bool) (string, error) {
+	if preserve {
+		if setting != "" {
+			return "", fmt.Errorf("output option 'newline' cannot be used with 'minimal' or 'canonical'")
+		}
+		return "", nil
+	}
+	switch strings.ToLower(setting) {
+	case "", "crlf":
+		return newlineCRLF, nil
+	case "lf":
+		return newlineLF, nil
+	}
+	return "", fmt.Errorf("unknown newline: '%s' (expected 'crlf' or 'lf')", setting)
+}
+
+// buildInputOptions は、入力設定を検証して組み立てます。
+// minimal は、出力が最小変更モードかどうかです。
+func buildInputOptions(config ConfigInput, minimal bool) (InputOptions, error) {
+	if config.HTML && !reflect.DeepEqual(config.Decoder, ConfigDecoder{}) {
+		return InputOptions{}, fmt.Errorf("input options 'html' and 'decoder' cannot be used together")
+	}
+	if config.HTML && minimal {
+		return InputOptions{}, fmt.Errorf("input option 'html' cannot be used with 'minimal'")
+	}
+	decoder, err := buildDecoderOptions(config.Decoder)
+	if err != nil {
+		return InputOptions{}, err
+	}
+	input := InputOptions{
+		Encoding: config.Encoding,
+		HTML:     config.HTML,
+		Decoder:  decoder,
+		Secure:   config.Secure,
+		Limits:   buildInputLimits(config.Limits),
+	}
+	switch mode := config.UndeclaredPrefixes; mode {
+	case "", undeclaredKeep, undeclaredDeclare, undeclaredStrip, undeclaredError:
+		input.UndeclaredPrefixes = mode
+	default:
+		return input, fmt.Errorf("unknown undeclared_prefixes mode: '%s'", mode)
+	}
+	switch mode := config.ControlChars; mode {
+	case "", controlCharsError, controlCharsStrip, controlCharsReplace:
+		input.ControlChars = mode
+	default:
+		return input, fmt.Errorf("unknown control_chars mode: '%s'", mode)
+	}
+	input.ControlCharReplacement = "\uFFFD"
+	if config.ControlCharReplacement != nil {
+		if input.ControlChars != controlCharsReplace {
+			return input, fmt.Errorf("input option 'control_char_replacement' requires control_chars 'replace'")
+		}
+		if err := validateText("control_char_replacement", *config.ControlCharReplacement); err != nil {
+			return input, err
+		}
+		input.ControlCharReplacement = *config.ControlCharReplacement
+	}
+	if minimal && input.Encoding == "" {
+		// 最小変更モードでは入力オフセットとバイト列を対応させるため、常に事前にUTF-8へ変換する
+		input.Encoding = autoEncoding
+	}
+	return input, nil
+}
+
+// NewProcessor は、このルールセットで r を変換して w に書き込むProcessorを作成します。
+// r はUTF-8 (または入力設定で扱えるエンコーディング) のXMLである必要があります。
+// opts (WithHooks など) は、ルールセットの設定の後に適用されます。
+// passes のパスは適用されません (すべてのパスを適用するには Transform を使います)。
+func (rs *RuleSet) NewProcessor(r io.Reader, w io.Writer, opts ...Option) *Processor {
+	return NewProcessor(r, w, append([]Option{
+		WithNameRules(rs.nameRules...),
+		WithInsertBeforeRules(rs.insertRules...),
+		WithInsertAfterRules(rs.insertAfterRules...),
+		WithPrependChildRules(rs.prependChildRules...),
+		WithValueRules(rs.valueRules...),
+		WithDateRules(rs.dateRules...),
+		WithUnicodeRules(rs.unicodeRules...),
+		WithWrapRules(rs.wrapRules...),
+		WithCdataRules(rs.cdataRules...),
+		WithCaptureRules(rs.captureRules...),
+		WithSummaryRules(rs.summaryRules...),
+		WithDeleteRules(rs.deleteRules...),
+		WithDedupeRules(rs.dedupeRules...),
+		WithReorderRules(rs.reorderRules...),
+		WithAssertRules(rs.assertRules...),
+		WithCaseRules(rs.caseRules...),
+		WithPrefixRules(rs.prefixRules...),
+		WithNamespaceRules(rs.namespaceRules...),
+		WithRootRules(rs.rootRules...),
+		WithSchemaLocationRules(rs.schemaLocationRules...),
+		WithNilRules(rs.nilRules...),
+		WithDefaultNamespaceRules(rs.defaultNamespaceRules...),
+		WithAttrCleanupRules(rs.attrCleanupRules...),
+		WithAttrDeleteRules(rs.attrDeleteRules...),
+		WithRawTags(rs.rawTags...),
+		WithFilterOrder(rs.filterOrder...),
+		WithRuleDescriptions(rs.descriptions),
+		WithInputOptions(rs.Input),
+		WithOutputOptions(rs.Output),
+	}, append(rs.observerOptions(), opts...)...)...)
+}
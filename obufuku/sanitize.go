@@ -0,0 +1,191 @@
+package obufuku
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// XML 1.0 で使用できない文字の扱い (InputOptions.ControlChars) です。
+const (
+	// controlCharsError は、入力のまま読み込み、解析エラーとします (既定)。
+	controlCharsError = "error"
+	// controlCharsStrip は、使用できない文字を取り除きます。
+	controlCharsStrip = "strip"
+	// controlCharsReplace は、使用できない文字を置換文字列に置き換えます。
+	controlCharsReplace = "replace"
+)
+
+// maxReportedControlChars は、位置を警告として報告する使用できない文字の最大の数です。
+// それを超えた分は、数だけを報告します。
+const maxReportedControlChars = 100
+
+// maxCharRefLength は、数値文字参照として解釈する最大の長さです (&#x10FFFF; や &#1114111; が収まる長さ)。
+const maxCharRefLength = 12
+
+// controlChar は、入力で見つかった使用できない文字とその位置です。
+type controlChar struct {
+	r            rune
+	line, column int
+}
+
+// controlCharReader は、入力からXML 1.0で使用できない制御文字 (U+0000〜U+0008、U+000B、U+000C、U+000E〜U+001F) と、
+// 使用できない文字を表す数値文字参照 (&#1; など) を取り除くか置き換えて読み込みます。
+// 制御文字はASCII互換のどのエンコーディングでも同じバイトのため、エンコーディングを変換する前のバイト列に適用できます。
+// 数値文字参照は、CDATAセクションやコメントの中のものも対象にします。
+type controlCharReader struct {
+	r           io.Reader
+	input       string
+	replacement []byte
+	// buf は読み込み用のバッファ、in は読み込んだが処理していない入力 (途中で切れた数値文字参照)、
+	// out は処理済みで返していない出力です。
+	buf, in, out []byte
+	eof          bool
+	// line と column は、次に処理する入力のバイトの位置です (桁はバイト単位)。
+	line, column int
+
+	// found と count は、見つかった文字の位置 (報告する分) と総数です。
+	// デコーダの段で読み込まれるため、変換の段から参照するときは mu で保護します。
+	mu    sync.Mutex
+	found []controlChar
+	count int
+}
+
+// newControlCharReader は、mode に従って r の使用できない文字を取り除くか replacement に置き換えるReaderを作成します。
+// input は、警告に含める入力の名前です (空でもかまいません)。
+func newControlCharReader(r io.Reader, input, mode, replacement string) *controlCharReader {
+	c := &controlCharReader{r: r, input: input, line: 1, column: 1}
+	if mode == controlCharsReplace {
+		c.replacement = []byte(replacement)
+	}
+	return c
+}
+
+func (c *controlCharReader) Read(b []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.eof && len(c.in) == 0 {
+			return 0, io.EOF
+		}
+		if !c.eof {
+			if c.buf == nil {
+				c.buf = make([]byte, 32*1024)
+			}
+			n, err := c.r.Read(c.buf)
+			c.in = append(c.in, c.buf[:n]...)
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		c.process()
+	}
+	n := copy(b, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// process は、c.in を処理して c.out に加えます。入力の終わりでなければ、途中で切れた数値文字参照を c.in に残します。
+func (c *controlCharReader) process() {
+	in := c.in
+	i := 0
+	for i < len(in) {
+		b := in[i]
+		switch {
+		case b < 0x20 && b != '\t' && b != '\n' && b != '\r':
+			c.record(rune(b))
+			c.out = append(c.out, c.replacement...)
+			c.column++
+			i++
+			continue
+		case b == '&' && (i+1 == len(in) && !c.eof || i+1 < len(in) && in[i+1] == '#'):
+			r, n, complete := scanCharRef(in[i:])
+			if !complete && !c.eof {
+				c.in = append(c.in[:0], in[i:]...)
+				return
+			}
+			if n > 0 && !isInCharacterRange(r) {
+				c.record(r)
+				c.out = append(c.out, c.replacement...)
+				c.column += n
+				i += n
+				continue
+			}
+		case b == '\n':
+			c.out = append(c.out, b)
+			c.line++
+			c.column = 1
+			i++
+			continue
+		}
+		c.out = append(c.out, b)
+		c.column++
+		i++
+	}
+	c.in = c.in[:0]
+}
+
+// scanCharRef は、s の先頭の数値文字参照 (&#10; や &#xA;) を解析し、文字とその長さを返します。
+// 数値文字参照でない場合は n が 0 になります。complete は、続きを読まなくても判定できたかどうかです。
+func scanCharRef(s []byte) (r rune, n int, complete bool) {
+	for i := 1; i < len(s) && i < maxCharRefLength; i++ {
+		if s[i] != ';' {
+			continue
+		}
+		digits, base := string(s[2:i]), 10
+		if len(digits) > 0 && digits[0] == 'x' {
+			digits, base = digits[1:], 16
+		}
+		v, err := strconv.ParseUint(digits, base, 32)
+		if i < 2 || s[1] != '#' || err != nil {
+			return 0, 0, true
+		}
+		return rune(v), i + 1, true
+	}
+	return 0, 0, len(s) >= maxCharRefLength
+}
+
+// record は、見つかった文字 r を現在の位置とともに記録します。
+func (c *controlCharReader) record(r rune) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	if len(c.found) < maxReportedControlChars {
+		c.found = append(c.found, controlChar{r: r, line: c.line, column: c.column})
+	}
+}
+
+// sanitizeInput は、入力設定で使用できない文字の扱いが指定されていれば、r をそれを適用するReaderで包みます。
+// input は、警告に含める入力の名前です。
+func (p *Processor) sanitizeInput(r io.Reader, input string) io.Reader {
+	mode := p.input.ControlChars
+	if mode != controlCharsStrip && mode != controlCharsReplace {
+		return r
+	}
+	c := newControlCharReader(r, input, mode, p.input.ControlCharReplacement)
+	p.sanitizers = append(p.sanitizers, c)
+	return c
+}
+
+// warnControlChars は、取り除いたか置き換えた使用できない文字の位置を警告として記録します。
+func (p *Processor) warnControlChars() {
+	action := "removed"
+	if p.input.ControlChars == controlCharsReplace {
+		action = "replaced"
+	}
+	for _, c := range p.sanitizers {
+		c.mu.Lock()
+		where := ""
+		if c.input != "" {
+			where = fmt.Sprintf(" in '%s'", c.input)
+		}
+		for _, f := range c.found {
+			p.warn("%s illegal character %U%s at line %d, column %d", action, f.r, where, f.line, f.column)
+		}
+		if more := c.count - len(c.found); more > 0 {
+			p.warn("%s %d more illegal character(s)%s", action, more, where)
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,91 @@
+package obufuku
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestControlCharReader(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		input       string
+		want        string
+		wantAt      []controlChar
+		replacement string
+	}{
+		{"strip", controlCharsStrip, "a\x01b\x1fc", "abc", []controlChar{{0x01, 1, 2}, {0x1f, 1, 4}}, ""},
+		{"replace", controlCharsReplace, "a\x0bb", "a?b", []controlChar{{0x0b, 1, 2}}, "?"},
+		{"allowed whitespace", controlCharsStrip, "a\tb\r\nc", "a\tb\r\nc", nil, ""},
+		{"char refs", controlCharsReplace, "&#1;&#x1F;&#9;&#x3042;", "??&#9;&#x3042;", []controlChar{{0x01, 1, 1}, {0x1f, 1, 5}}, "?"},
+		{"not a char ref", controlCharsStrip, "&amp; &#; &#xZZ; &", "&amp; &#; &#xZZ; &", nil, ""},
+		{"lines", controlCharsStrip, "a\nb\x02", "a\nb", []controlChar{{0x02, 2, 2}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 1バイトずつ読み込んでも、途中で切れた数値文字参照を正しく扱う
+			c := newControlCharReader(iotest.OneByteReader(strings.NewReader(tt.input)), "", tt.mode, tt.replacement)
+			got, err := io.ReadAll(c)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(c.found, tt.wantAt) {
+				t.Errorf("found = %v, want %v", c.found, tt.wantAt)
+			}
+		})
+	}
+}
+
+func TestControlChars(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  ConfigInput
+		want   string
+		warned string
+	}{
+		{"strip", ConfigInput{ControlChars: "strip"}, "<a>xy</a>", "removed illegal character U+0001 at line 1, column 5"},
+		{"replace", ConfigInput{ControlChars: "replace"}, "<a>x�y</a>", "replaced illegal character U+0001 at line 1, column 5"},
+		{"custom replacement", ConfigInput{ControlChars: "replace", ControlCharReplacement: ptr("-")}, "<a>x-y</a>", "replaced illegal character U+0001 at line 1, column 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, result := transformString(t, Config{Input: tt.input, Output: compactOutput}, "<a>x\x01y</a>")
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if want := []string{tt.warned}; !reflect.DeepEqual(result.Warnings, want) {
+				t.Errorf("Warnings = %q, want %q", result.Warnings, want)
+			}
+		})
+	}
+}
+
+func TestControlCharsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input ConfigInput
+	}{
+		{"unknown mode", ConfigInput{ControlChars: "drop"}},
+		{"replacement without replace", ConfigInput{ControlChars: "strip", ControlCharReplacement: ptr("-")}},
+		{"illegal replacement", ConfigInput{ControlChars: "replace", ControlCharReplacement: ptr("\x02")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRuleSet(Config{Input: tt.input}); err == nil {
+				t.Error("NewRuleSet succeeded, want an error")
+			}
+		})
+	}
+	if _, err := tryTransformString(t, Config{}, "<a>\x01</a>"); err == nil {
+		t.Error("Transform succeeded with the default mode, want a parse error")
+	}
+}
+
+// ptr は、s へのポインタを返します。
+func ptr(s string) *string { return &s }
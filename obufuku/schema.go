@@ -0,0 +1,228 @@
+package obufuku
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// xsiNamespaceURI は、XML Schema のインスタンスの名前空間 (接頭辞 xsi) のURIです。
+const xsiNamespaceURI = "http://www.w3.org/2001/XMLSchema-instance"
+
+// schemaLocationFilter は、要素にスキーマの場所を設定します (schema_location_rules)。
+type schemaLocationFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f schemaLocationFilter) BeforeStart(w TokenWriter, el *Element) error {
+	for i, rule := range f.p.schemaLocationRules {
+		if rule.TargetTag == "" && len(f.p.elementStack) > 0 || rule.TargetTag != "" && el.Input.Local != rule.TargetTag {
+			continue
+		}
+		local := "noNamespaceSchemaLocation"
+		if rule.Namespace != "" {
+			local = "schemaLocation"
+		}
+		prefix := f.p.declareXSI(el)
+		name := prefix + ":" + local
+		j := xsiAttr(el.Start.Attr, local)
+		if j < 0 {
+			value := rule.Location
+			if rule.Namespace != "" {
+				value = rule.Namespace + " " + rule.Location
+			}
+			f.p.ruleApplied(hitSchemaLocationRules, i, el.Start.Name.Local, "", fmt.Sprintf(`%s="%s"`, name, value))
+			el.Start.Attr = append(el.Start.Attr, xml.Attr{Name: xml.Name{Space: xsiNamespaceURI, Local: local}, Value: value})
+			continue
+		}
+		attr := &el.Start.Attr[j]
+		value := rule.Location
+		if rule.Namespace != "" {
+			value = setSchemaLocation(attr.Value, rule.Namespace, rule.Location)
+		}
+		f.p.ruleApplied(hitSchemaLocationRules, i, el.Start.Name.Local, fmt.Sprintf(`%s="%s"`, name, attr.Value), fmt.Sprintf(`%s="%s"`, name, value))
+		attr.Name.Space = xsiNamespaceURI
+		attr.Value = value
+	}
+	return nil
+}
+
+// nil_rules の変換の方向です。
+const (
+	nilAdd   = "add"
+	nilStrip = "strip"
+)
+
+// nilFilter は、空の要素と xsi:nil="true" の要素を相互に変換します (nil_rules)。
+// 対象の要素の出力を開始タグから保留し、終了タグの後で変換する場合は、開始タグと終了タグだけの要素に書き直します。
+// 名前置換の影響を受けないよう、入力のタグ名と照合します。
+// 書き直した要素を他のフィルターが保留の中で扱えるよう、既定の順序では最後 (終了タグの後の処理では最初) に置きます。
+type nilFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+// heldNil は、変換するかどうかが未確定の要素と、保留している出力での開始位置、これまでの内容です。
+type heldNil struct {
+	el       *Element
+	rule     int
+	start    int
+	text     strings.Builder
+	children bool
+}
+
+func (f nilFilter) BeforeStart(w TokenWriter, el *Element) error {
+	if n := len(f.p.heldNils); n > 0 && len(f.p.elementStack) > 0 && f.p.heldNils[n-1].el == f.p.elementStack[len(f.p.elementStack)-1] {
+		f.p.heldNils[n-1].children = true
+	}
+	for i, rule := range f.p.nilRules {
+		if el.Input.Local != rule.TargetTag {
+			continue
+		}
+		f.p.targetSeen(hitNilRules, i)
+		j := xsiAttr(el.Start.Attr, "nil")
+		if rule.Mode == nilAdd && j >= 0 || rule.Mode == nilStrip && (j < 0 || !isNilValue(el.Start.Attr[j].Value)) {
+			break
+		}
+		if rule.Mode == nilStrip {
+			el.Start.Attr = append(el.Start.Attr[:j:j], el.Start.Attr[j+1:]...)
+		}
+		start, err := f.p.encoder.holdOutput()
+		if err != nil {
+			return err
+		}
+		f.p.heldNils = append(f.p.heldNils, &heldNil{el: el, rule: i, start: start})
+		break
+	}
+	return nil
+}
+
+func (f nilFilter) CharData(w TokenWriter, el *Element, text *Text) error {
+	if n := len(f.p.heldNils); n > 0 && f.p.heldNils[n-1].el == el {
+		f.p.heldNils[n-1].text.WriteString(text.Data)
+	}
+	return nil
+}
+
+func (f nilFilter) AfterEnd(w TokenWriter, el *Element) error {
+	n := len(f.p.heldNils)
+	if n == 0 || f.p.heldNils[n-1].el != el {
+		return nil
+	}
+	h := f.p.heldNils[n-1]
+	f.p.heldNils = f.p.heldNils[:n-1]
+	text := h.text.String()
+	if f.p.nilRules[h.rule].Mode == nilAdd {
+		if h.children || strings.TrimSpace(text) != "" {
+			return f.p.encoder.releaseHeld()
+		}
+		prefix := f.p.declareXSI(el)
+		el.Start.Attr = append(el.Start.Attr, xml.Attr{Name: xml.Name{Space: xsiNamespaceURI, Local: "nil"}, Value: "true"})
+		f.p.ruleApplied(hitNilRules, h.rule, el.Start.Name.Local, "", prefix+`:nil="true"`)
+	} else {
+		f.p.ruleApplied(hitNilRules, h.rule, el.Start.Name.Local, text, "")
+	}
+	// 開始タグと終了タグだけの要素に書き直す
+	end, err := f.p.encoder.heldOffset()
+	if err != nil {
+		return err
+	}
+	if err := f.p.dropHeld(h.start, end); err != nil {
+		return err
+	}
+	if err := w.WriteToken(el.Start); err != nil {
+		return err
+	}
+	if err := w.WriteEnd(el.Start.Name); err != nil {
+		return err
+	}
+	return f.p.encoder.releaseHeld()
+}
+
+// isNilValue は、xsi:nil の値 value が真を表すかを判定します。
+func isNilValue(value string) bool {
+	value = strings.TrimSpace(value)
+	return value == "true" || value == "1"
+}
+
+// xsiAttr は、属性 attrs から xsi の名前空間の属性 local (未宣言の接頭辞 xsi のものを含む) の位置を探します (無ければ -1)。
+func xsiAttr(attrs []xml.Attr, local string) int {
+	for j, attr := range attrs {
+		if attr.Name.Local == local && (attr.Name.Space == xsiNamespaceURI || attr.Name.Space == "xsi") {
+			return j
+		}
+	}
+	return -1
+}
+
+// setSchemaLocation は、xsi:schemaLocation の値 value の名前空間 namespace の場所を location にします。
+// 名前空間の組が無ければ末尾に加えます。
+func setSchemaLocation(value, namespace, location string) string {
+	fields := strings.Fields(value)
+	for k := 0; k+1 < len(fields); k += 2 {
+		if fields[k] == namespace {
+			fields[k+1] = location
+			return strings.Join(fields, " ")
+		}
+	}
+	return strings.Join(append(fields, namespace, location), " ")
+}
+
+// declareXSI は、要素 el の位置で xsi の名前空間に使える接頭辞を返します。
+// 開始タグ自身や祖先の要素で宣言されていなければ、el に宣言を加えます。
+// 接頭辞 xsi が別の名前空間に使われている場合は、xsi1、xsi2 … のうち使われていないものを宣言します。
+func (p *Processor) declareXSI(el *Element) string {
+	if prefix, ok := p.boundPrefix(xsiNamespaceURI, el.Start.Attr); ok {
+		return prefix
+	}
+	prefix := "xsi"
+	for n := 1; ; n++ {
+		if _, used := p.prefixNamespace(prefix, el.Start.Attr); !used {
+			break
+		}
+		prefix = "xsi" + strconv.Itoa(n)
+	}
+	el.Start.Attr = append(el.Start.Attr, xml.Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: xsiNamespaceURI})
+	return prefix
+}
+
+// boundPrefix は、開始タグの属性 attrs と祖先の要素の名前空間宣言から、名前空間URI uri に対応する接頭辞を探します。
+// 既定の名前空間の宣言は属性に使えないため対象にしません。
+func (p *Processor) boundPrefix(uri string, attrs []xml.Attr) (string, bool) {
+	scopes := [][]xml.Attr{attrs}
+	for i := len(p.elementStack) - 1; i >= 0; i-- {
+		scopes = append(scopes, p.elementStack[i].Start.Attr)
+	}
+	for _, scope := range scopes {
+		for _, b := range namespaceBindings(scope) {
+			if b.prefix == "" {
+				continue
+			}
+			// 内側で別のURIに宣言し直された接頭辞は使えない
+			if b.uri == uri {
+				if bound, _ := p.prefixNamespace(b.prefix, attrs); bound == uri {
+					return b.prefix, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// prefixNamespace は、開始タグの属性 attrs と祖先の要素の名前空間宣言から、接頭辞 prefix が表す名前空間URIを探します。
+func (p *Processor) prefixNamespace(prefix string, attrs []xml.Attr) (string, bool) {
+	scopes := [][]xml.Attr{attrs}
+	for i := len(p.elementStack) - 1; i >= 0; i-- {
+		scopes = append(scopes, p.elementStack[i].Start.Attr)
+	}
+	for _, scope := range scopes {
+		for _, b := range namespaceBindings(scope) {
+			if b.prefix == prefix {
+				return b.uri, true
+			}
+		}
+	}
+	return "", false
+}
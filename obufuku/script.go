@@ -0,0 +1,73 @@
+package obufuku
+
+import (
+	"fmt"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// scriptValueType は、式を評価して値を置き換える値置換ルールの種類です。
+const scriptValueType = "script"
+
+// newScriptFunc は、params["expr"] の式 (expr-lang の構文) を評価して値を置き換える関数を作成します。
+// 式では次の変数と関数を使えます。
+//
+//	value          元の値 (文字列)
+//	tag            対象要素のタグ名 (名前置換後)
+//	attrs          対象要素の属性 (ローカル名から値への map)
+//	counters       カウンターの現在値 (カウンター名から値への map)
+//	next("name")   カウンターを1つ進めて新しい値を返す
+//
+// 式の結果が文字列でない場合は fmt.Sprint で文字列に変換します。
+func newScriptFunc(params map[string]interface{}, counters map[string]*Counter) (ValueContextFunc, error) {
+	source, ok := params["expr"].(string)
+	if !ok || source == "" {
+		return nil, fmt.Errorf("invalid or missing 'expr' for script rule")
+	}
+	program, err := expr.Compile(source, expr.Env(scriptEnv("", ValueContext{}, counters)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile script rule: %w", err)
+	}
+	return func(oldValue string, ctx ValueContext) (string, error) {
+		return runScript(program, scriptEnv(oldValue, ctx, counters))
+	}, nil
+}
+
+// scriptEnv は、式の評価に使う変数と関数を用意します。
+func scriptEnv(value string, ctx ValueContext, counters map[string]*Counter) map[string]interface{} {
+	attrs := make(map[string]string, len(ctx.Element.Attr))
+	for _, attr := range ctx.Element.Attr {
+		attrs[attr.Name.Local] = attr.Value
+	}
+	current := make(map[string]int, len(counters))
+	for name, c := range counters {
+		current[name] = c.current
+	}
+	return map[string]interface{}{
+		"value":    value,
+		"tag":      ctx.Element.Name.Local,
+		"attrs":    attrs,
+		"counters": current,
+		"vars":     ctx.Variables,
+		"next": func(name string) (int, error) {
+			c, ok := counters[name]
+			if !ok {
+				return 0, fmt.Errorf("unknown counter: '%s'", name)
+			}
+			return c.Next(), nil
+		},
+	}
+}
+
+// runScript は、コンパイル済みの式を評価して結果を文字列で返します。
+func runScript(program *vm.Program, env map[string]interface{}) (string, error) {
+	result, err := expr.Run(program, env)
+	if err != nil {
+		return "", fmt.Errorf("script rule failed: %w", err)
+	}
+	if s, ok := result.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(result), nil
+}
@@ -0,0 +1,66 @@
+package obufuku
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestScriptValueRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		input string
+		want  string
+	}{
+		{"string expression", `upper(value) + "!"`, `<a><v>hi</v></a>`, "<a><v>HI!</v></a>"},
+		{"number result", `len(value) * 2`, `<a><v>abc</v></a>`, "<a><v>6</v></a>"},
+		{"tag and attributes", `tag + ":" + attrs["id"]`, `<a><v id="7">x</v></a>`, "<a><v id=\"7\">v:7</v></a>"},
+		{"next counter", `value + "-" + string(next("c"))`, `<a><v>x</v><v>y</v></a>`, "<a><v>x-5</v><v>y-6</v></a>"},
+		{"current counter", `string(counters["c"])`, `<a><v>x</v></a>`, "<a><v>4</v></a>"},
+		{"condition", `value == "" ? "empty" : trim(value)`, `<a><v> x </v></a>`, "<a><v>x</v></a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				ValueRules: []ConfigValueRule{{Target: "v", Type: "script", Params: params{"expr": tt.expr}}},
+				Counters:   map[string]ConfigCounter{"c": {Start: 4}},
+				Output:     compactOutput,
+			}
+			got, _ := transformString(t, cfg, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptValueRuleErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		params params
+		err    string
+	}{
+		{"missing expr", nil, "missing 'expr'"},
+		{"syntax error", params{"expr": `value +`}, "failed to compile"},
+		{"type mismatch", params{"expr": `value - 1`}, "failed to compile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRuleSet(Config{ValueRules: []ConfigValueRule{{Target: "v", Type: "script", Params: tt.params}}})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("NewRuleSet error = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+
+	// 評価時のエラーは、変換のエラーとして返る
+	cfg := Config{ValueRules: []ConfigValueRule{{Target: "v", Type: "script", Params: params{"expr": `next("missing")`}}}}
+	_, err := tryTransformString(t, cfg, `<v>x</v>`)
+	if err == nil || !strings.Contains(err.Error(), "unknown counter: 'missing'") {
+		t.Errorf("Transform error = %v, want an unknown counter error", err)
+	}
+	if errors.Unwrap(err) == nil {
+		t.Errorf("Transform error %v does not wrap the script error", err)
+	}
+}
@@ -0,0 +1,237 @@
+package obufuku
+
+import (
+	"encoding/xml"
+	"io"
+	"sync"
+)
+
+// Processor.Run は、デコード・変換・エンコード (出力の書き込み) の3つの段を goroutine で並行に動かします。
+// 段の間は、次の大きさのチャネルでつなぎます。
+const (
+	// stageDepth は、段の間で溜めておけるまとまり (トークンのまとまり、出力のチャンク) の数です。
+	stageDepth = 8
+	// tokenBatchSize は、デコーダの段が1回に送るトークンの最大数です。
+	tokenBatchSize = 128
+)
+
+// decodedToken は、デコーダの段が読み込んだトークンと、その入力での位置です。
+type decodedToken struct {
+	token xml.Token
+	// offset は、トークンの直後の入力オフセット、line と column はその行と桁です。
+	offset       int64
+	line, column int
+	// raw は、最小変更モードでのトークンの入力バイト列です。
+	raw []byte
+	// more は、raw_tags の要素の長いテキストを分割したもので、次のテキストのトークンに続きがあるかどうかです。
+	more bool
+	// err は、読み込みのエラーです。入力の終わりでは io.EOF になり、それ以降のトークンはありません。
+	err error
+}
+
+// decodeTokens は、デコーダの段を goroutine で開始し、読み込んだトークンをまとまりごとに送るチャネルを返します。
+// DOCTYPE宣言の実体は、続きを読み込む前にこの段で展開できるようにします。
+// raw_tags の要素の長いテキストは、p.splitter で分割して読み込み、差し込んだ区切りのトークンは送りません。
+// done が閉じられると、読み込みを止めてチャネルを閉じます。
+// 開始後は、p.decoder・p.recorder・p.splitter をこの段だけが使います。
+func (p *Processor) decodeTokens(done <-chan struct{}) <-chan []decodedToken {
+	batches := make(chan []decodedToken, stageDepth)
+	decoder, recorder, reader, input, splitter, rawTags := p.decoder, p.recorder, p.reader, p.input, p.splitter, p.rawTagMap
+	go func() {
+		defer close(batches)
+		batch := make([]decodedToken, 0, tokenBatchSize)
+		send := func() bool {
+			select {
+			case batches <- batch:
+				batch = make([]decodedToken, 0, tokenBatchSize)
+				return true
+			case <-done:
+				return false
+			}
+		}
+		// 分割の判定に使う、入力での要素名 (置換前) の入れ子と、差し込んだ区切りの処理の状態
+		var names []string
+		splits, dropMarker := 0, false
+		for {
+			// 変換の段が待っている場合や、続きの読み込みで待たされる可能性がある場合は、溜めたトークンを先に送る
+			if len(batch) > 0 && (len(batch) == tokenBatchSize || len(batches) == 0 || reader.Buffered() == 0) {
+				if !send() {
+					return
+				}
+			}
+
+			start := decoder.InputOffset()
+			token, err := decoder.Token()
+			d := decodedToken{offset: decoder.InputOffset()}
+			d.line, d.column = decoder.InputPos()
+			if splitter != nil {
+				d.offset, d.column = splitter.position(d.offset, d.line, d.column)
+				if err == nil && dropMarker {
+					dropMarker = false
+					if err = checkSplitMarker(token); err == nil {
+						continue
+					}
+				}
+				if err == nil {
+					switch t := token.(type) {
+					case xml.CharData:
+						if splitter.injected > splits {
+							splits++
+							d.more = true
+							dropMarker = splitter.lastInText
+						}
+					case xml.StartElement:
+						names = append(names, t.Name.Local)
+					case xml.EndElement:
+						if len(names) > 0 {
+							names = names[:len(names)-1]
+						}
+					}
+					splitter.armed = len(names) > 0 && rawTags[names[len(names)-1]]
+				}
+			}
+			if err == nil {
+				d.token = xml.CopyToken(token)
+				if dir, ok := token.(xml.Directive); ok {
+					entity, dirErr := doctypeEntities(dir, input, decoder.Entity)
+					if dirErr != nil {
+						err = &ParseError{Err: dirErr}
+					} else {
+						decoder.Entity = entity
+					}
+				}
+			} else if err != io.EOF {
+				err = &ParseError{Err: err}
+			}
+			if err == nil && recorder != nil {
+				// 記録は読み進めると再利用されるため、トークンの入力バイト列を複製して渡す
+				var raw []byte
+				if raw, err = recorder.Span(start, d.offset); err == nil {
+					d.raw = append([]byte(nil), raw...)
+				}
+				recorder.Discard(d.offset)
+			}
+			d.err = err
+			batch = append(batch, d)
+			if err != nil {
+				send()
+				return
+			}
+		}
+	}()
+	return batches
+}
+
+// asyncWriter は、書き込まれたデータを goroutine で下流の Writer に渡す、エンコード (出力の書き込み) の段です。
+// 下流の書き込みのエラーは、以降の Write・Flush・Close で返します。
+type asyncWriter struct {
+	w      io.Writer
+	chunks chan asyncChunk
+	// free は、書き込み済みで再利用できるチャンクのバッファです。
+	free chan []byte
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// asyncChunk は、下流に渡すデータ、または Flush の要求 (flush が nil でない場合) です。
+type asyncChunk struct {
+	data  []byte
+	flush chan error
+}
+
+// newAsyncWriter は、w に書き込む新しい asyncWriter を作成し、書き込みの goroutine を開始します。
+// 書き込みを終えたら、Close を呼び出す必要があります (w は閉じません)。
+func newAsyncWriter(w io.Writer) *asyncWriter {
+	a := &asyncWriter{
+		w:      w,
+		chunks: make(chan asyncChunk, stageDepth),
+		free:   make(chan []byte, stageDepth+1),
+		done:   make(chan struct{}),
+	}
+	go a.loop()
+	return a
+}
+
+// loop は、チャンクを順に下流に書き込みます。
+func (a *asyncWriter) loop() {
+	defer close(a.done)
+	for chunk := range a.chunks {
+		err := a.error()
+		if err == nil && len(chunk.data) > 0 {
+			if _, err = a.w.Write(chunk.data); err != nil {
+				a.setError(err)
+			}
+		}
+		if chunk.data != nil {
+			select {
+			case a.free <- chunk.data[:0]:
+			default:
+			}
+		}
+		if chunk.flush != nil {
+			if f, ok := a.w.(interface{ Flush() error }); ok && err == nil {
+				if err = f.Flush(); err != nil {
+					a.setError(err)
+				}
+			}
+			chunk.flush <- err
+		}
+	}
+}
+
+func (a *asyncWriter) error() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+func (a *asyncWriter) setError(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+// Write は io.Writer インターフェースを実装します。p は複製してから下流に渡します。
+func (a *asyncWriter) Write(p []byte) (int, error) {
+	if err := a.error(); err != nil {
+		return 0, err
+	}
+	var buf []byte
+	select {
+	case buf = <-a.free:
+	default:
+	}
+	a.chunks <- asyncChunk{data: append(buf, p...)}
+	return len(p), nil
+}
+
+// Flush は、それまでのデータを下流に書き込み終えるのを待ち、下流が Flush を持っていればそれを呼び出します。
+func (a *asyncWriter) Flush() error {
+	flushed := make(chan error, 1)
+	a.chunks <- asyncChunk{flush: flushed}
+	return <-flushed
+}
+
+// Close は、残りのデータを下流に書き込み終えるのを待ち、書き込みの goroutine を終了します。
+func (a *asyncWriter) Close() error {
+	close(a.chunks)
+	<-a.done
+	return a.error()
+}
+
+// pipeOutput は、出力をエンコードの段 (asyncWriter) を通して書き込みながら f を実行します。
+// f を終えると、残りの出力を書き込み終えるまで待ちます。
+func (p *Processor) pipeOutput(f func() error) error {
+	out := newAsyncWriter(p.counter.w)
+	p.counter.w = out
+	err := f()
+	p.counter.w = out.w
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = p.positionError("", closeErr)
+	}
+	return err
+}
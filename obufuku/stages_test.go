@@ -0,0 +1,164 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// これらのテストは、デコード・変換・エンコードの段をまたぐ処理を確かめるもので、go test -race でも実行します。
+
+// repeatElements は、n 個の要素 <name n="i">text</name> を container の下に並べた文書を返します。
+func repeatElements(container, name string, n int) string {
+	var b strings.Builder
+	b.WriteString("<" + container + ">")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `<%s n="%d">text %d</%s>`, name, i, i, name)
+	}
+	b.WriteString("</" + container + ">")
+	return b.String()
+}
+
+func TestPipelineLargeDocument(t *testing.T) {
+	// デコーダの段のまとまり (tokenBatchSize) とチャネル (stageDepth) を何度も満たす大きさにする
+	n := tokenBatchSize * stageDepth * 4
+	cfg := Config{
+		NameRules:  []ConfigNameRule{{Old: "i", New: "item"}},
+		ValueRules: []ConfigValueRule{{Target: "item", Type: "prepend", Params: params{"prefix": ">"}}},
+		Output:     compactOutput,
+	}
+	got, result := transformString(t, cfg, repeatElements("list", "i", n))
+
+	want := strings.ReplaceAll(repeatElements("list", "item", n), ">text", ">&gt;text")
+	if got != want {
+		t.Errorf("output differs from the expected document (got %d bytes, want %d bytes)", len(got), len(want))
+	}
+	if result.RuleHits["name_rules[0]"] != n || result.RuleHits["value_rules[0]"] != n {
+		t.Errorf("RuleHits = %v, want %d hits for each rule", result.RuleHits, n)
+	}
+	if result.Elements != n+1 {
+		t.Errorf("Elements = %d, want %d", result.Elements, n+1)
+	}
+}
+
+func TestPipelineRawTagsSplit(t *testing.T) {
+	// rawTextChunkSize を超える中身は分割して読み込まれるが、出力では1つのCDATAセクションにつながる
+	tests := []struct {
+		name    string
+		content string
+		text    string
+	}{
+		{"text", strings.Repeat("0123456789abcdef", rawTextChunkSize/16*3+5), strings.Repeat("0123456789abcdef", rawTextChunkSize/16*3+5)},
+		{"entities", strings.Repeat("a&amp;b&lt;", rawTextChunkSize/4), strings.Repeat("a&b<", rawTextChunkSize/4)},
+		{"multibyte", strings.Repeat("日本語のテキスト", rawTextChunkSize/8), strings.Repeat("日本語のテキスト", rawTextChunkSize/8)},
+		{"cdata", "<![CDATA[" + strings.Repeat("x]]y<&", rawTextChunkSize/2) + "]]>", strings.Repeat("x]]y<&", rawTextChunkSize/2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "<a><r>" + tt.content + "</r><b>after</b></a>"
+			cfg := Config{RawTags: []string{"r"}, NameRules: []ConfigNameRule{{Old: "b", New: "c"}}, Output: compactOutput}
+			got, _ := transformString(t, cfg, input)
+			if want := "<a><r><![CDATA[" + tt.text + "]]></r><c>after</c></a>"; got != want {
+				t.Errorf("output differs from the expected document (got %d bytes, want %d bytes)", len(got), len(want))
+			}
+		})
+	}
+}
+
+// failingWriter は、limit バイトを超えて書き込もうとするとエラーを返す Writer です。
+type failingWriter struct {
+	limit int
+	n     int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n+len(p) > w.limit {
+		return 0, errWriteFailed
+	}
+	w.n += len(p)
+	return len(p), nil
+}
+
+func TestPipelineWriteError(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+	}{
+		{"first write", 0},
+		{"after some output", 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := repeatElements("list", "i", tokenBatchSize*stageDepth*4)
+			_, err := Transform(context.Background(), Config{}, strings.NewReader(input), &failingWriter{limit: tt.limit})
+			if !errors.Is(err, errWriteFailed) {
+				t.Errorf("Transform error = %v, want %v", err, errWriteFailed)
+			}
+		})
+	}
+}
+
+// endlessReader は、<a> の後に子要素を際限なく返す Reader です。
+// 最初の読み込みで cancel を呼び出し、closed が設定された後に読み込まれると readsAfterClose を数えます。
+type endlessReader struct {
+	cancel          func()
+	started         bool
+	closed          atomic.Bool
+	readsAfterClose atomic.Int32
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	if r.closed.Load() {
+		r.readsAfterClose.Add(1)
+	}
+	if !r.started {
+		r.started = true
+		r.cancel()
+		return copy(p, "<a>"), nil
+	}
+	const child = "<b>x</b>"
+	n := 0
+	for n+len(child) <= len(p) {
+		n += copy(p[n:], child)
+	}
+	return n, nil
+}
+
+func TestPipelineCanceledWhileReading(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &endlessReader{cancel: cancel}
+	_, err := Transform(ctx, Config{}, r, io.Discard)
+	r.closed.Store(true)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Transform error = %v, want context.Canceled", err)
+	}
+	// 返った後は、デコーダの段も入力を読まない
+	if n := r.readsAfterClose.Load(); n != 0 {
+		t.Errorf("input was read %d time(s) after Transform returned", n)
+	}
+}
+
+func TestPipelineParseErrorPosition(t *testing.T) {
+	// 後ろの方のまとまりで見つかったエラーも、入力での位置を報告する
+	n := tokenBatchSize * stageDepth * 2
+	input := strings.ReplaceAll(repeatElements("list", "i", n), "</i>", "\n</i>")
+	input = strings.TrimSuffix(input, "</list>") + "<bad></i></list>"
+	_, err := Transform(context.Background(), Config{}, strings.NewReader(input), &bytes.Buffer{})
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Transform error = %v, want a ParseError", err)
+	}
+	if parseErr.Line != n+1 {
+		t.Errorf("Line = %d, want %d", parseErr.Line, n+1)
+	}
+	if parseErr.Path != "/list/bad" {
+		t.Errorf("Path = %q, want %q", parseErr.Path, "/list/bad")
+	}
+}
@@ -0,0 +1,46 @@
+package obufuku
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// 集計ルールの集計方法です。
+const (
+	summaryCount = "count"
+	summarySum   = "sum"
+)
+
+// summaryNumberPattern は、sum で合計する数値 (符号と小数点を含められる10進数) です。
+var summaryNumberPattern = regexp.MustCompile(`^[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)$`)
+
+// summaryTotal は、集計ルールの文書ごとの集計値です。
+// 小数の合計で誤差が出ないよう、有理数で合計し、入力の小数部の最大の桁数で出力します。
+type summaryTotal struct {
+	value big.Rat
+	scale int
+}
+
+// add は、テキスト text の数値を合計に加えます。数値でなければエラーを返します。
+func (t *summaryTotal) add(text string) error {
+	s := strings.TrimSpace(text)
+	if !summaryNumberPattern.MatchString(s) {
+		return fmt.Errorf("value '%s' is not a decimal number", s)
+	}
+	var v big.Rat
+	if _, ok := v.SetString(s); !ok {
+		return fmt.Errorf("value '%s' is not a decimal number", s)
+	}
+	if dot := strings.IndexByte(s, '.'); dot >= 0 && len(s)-dot-1 > t.scale {
+		t.scale = len(s) - dot - 1
+	}
+	t.value.Add(&t.value, &v)
+	return nil
+}
+
+// String は、集計値を10進数の文字列で返します。
+func (t *summaryTotal) String() string {
+	return t.value.FloatString(t.scale)
+}
@@ -0,0 +1,128 @@
+package obufuku
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Transform は、設定 cfg に従って r のXMLを変換し、w に書き込みます。
+// ファイルを介さずに、メモリ上のバッファやHTTPの本文、パイプなどを変換するための関数です。
+// w は閉じませんが、出力エンコーディングの変換は確定させてから返ります。
+func Transform(ctx context.Context, cfg Config, r io.Reader, w io.Writer) (TransformResult, error) {
+	rules, err := NewRuleSet(cfg)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	return rules.Transform(ctx, r, w)
+}
+
+// Transform は、このルールセットで r のXMLを変換し、w に書き込みます。
+// 入力エンコーディングの変換、出力エンコーディングと改行コードの変換は、設定に従って行います。
+// 結果のバイト数は、r から読み込んだバイト数と w に書き込んだバイト数です。
+func (rs *RuleSet) Transform(ctx context.Context, r io.Reader, w io.Writer) (TransformResult, error) {
+	in := &countingReader{r: r}
+	out := &countingWriter{w: w}
+	reader, err := rs.NewReader(in)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	writer := rs.NewWriter(out)
+
+	result, err := rs.runPasses(ctx, writer, func(w io.Writer, opts ...Option) (TransformResult, error) {
+		return rs.NewProcessor(reader, w, opts...).Run(ctx)
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	result.BytesRead, result.BytesWritten = in.n, out.n
+	return result, err
+}
+
+// TransformMerged は、Processor.RunMerged と同じく複数の入力文書を container 要素の下にまとめて変換し、
+// w に書き込みます。open が返す Reader は、NewReader で変換済みである必要があります。
+// 出力エンコーディングと改行コードの変換は Transform と同じく行い、w は閉じません。
+func (rs *RuleSet) TransformMerged(ctx context.Context, container string, inputs []string, open func(string) (io.Reader, io.Closer, error), w io.Writer) (TransformResult, error) {
+	writer := rs.NewWriter(w)
+	result, err := rs.runPasses(ctx, writer, func(w io.Writer, opts ...Option) (TransformResult, error) {
+		return rs.NewProcessor(strings.NewReader(""), w, opts...).RunMerged(ctx, container, inputs, open)
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	return result, err
+}
+
+// NewReader は、入力エンコーディングの設定に従って、r をProcessorで読み込めるReaderに変換します。
+func (rs *RuleSet) NewReader(r io.Reader) (io.Reader, error) {
+	return NewInputReader(r, rs.Input.Encoding)
+}
+
+// NewWriter は、Processorが書き出すUTF-8のXMLを、出力設定のエンコーディングと
+// 改行コードに変換して w に書き込むWriterを返します。Close で変換を確定させますが、w は閉じません。
+// w が Flush メソッドを持つ場合、途中書き出しの設定に従って呼び出されます。
+func (rs *RuleSet) NewWriter(w io.Writer) io.WriteCloser {
+	out := &outputWriter{Writer: w, dest: w, encoding: rs.Output.Encoding}
+
+	// 出力エンコーディングが指定されていれば、UTF-8から変換するwriterでラップ
+	if rs.OutputEncoding != nil {
+		out.encodingWriter = NewEncodingWriter(out.Writer, rs.OutputEncoding)
+		out.Writer = out.encodingWriter
+	}
+
+	// 改行コードを揃えるwriterでラップ
+	// (最小変更モードでは入力の改行コードを維持し、正規化出力では仕様どおりLFとする)
+	if rs.Output.Newline != "" {
+		out.newlineWriter = NewNewlineWriter(out.Writer, rs.Output.Newline)
+		out.Writer = out.newlineWriter
+	}
+	return out
+}
+
+// outputWriter は、RuleSet.NewWriter が返す、変換用のWriterを重ねたWriterです。
+type outputWriter struct {
+	io.Writer
+
+	dest           io.Writer
+	encodingWriter io.WriteCloser
+	newlineWriter  io.WriteCloser
+	encoding       string
+}
+
+// Flush は、出力先が Flush を持っていればそれを呼び出します。
+// エンコーディング変換は文字の途中でなければデータを保持しないため対象にしません。
+func (o *outputWriter) Flush() error {
+	if f, ok := o.dest.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close は、改行コードとエンコーディングの変換を確定させます。出力先は閉じません。
+func (o *outputWriter) Close() error {
+	if o.newlineWriter != nil {
+		if err := o.newlineWriter.Close(); err != nil {
+			return err
+		}
+	}
+	if o.encodingWriter != nil {
+		if err := o.encodingWriter.Close(); err != nil {
+			return fmt.Errorf("error encoding output as '%s': %w", o.encoding, err)
+		}
+	}
+	return nil
+}
+
+// countingReader は、io.Readerをラップし、読み込んだバイト数を数えます。
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read は io.Reader インターフェースを実装します。
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,302 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// compactOutput は、期待する出力を1行で書けるよう、要素間の空白を出力しない出力設定です。
+var compactOutput = ConfigOutput{Compact: true, Newline: "lf"}
+
+// params は、テーブルで値置換ルールのパラメータを短く書くための型です。
+type params = map[string]interface{}
+
+// transformString は、cfg で input を変換した出力と結果を返します。
+func transformString(t *testing.T, cfg Config, input string) (string, TransformResult) {
+	t.Helper()
+	var out bytes.Buffer
+	result, err := Transform(context.Background(), cfg, strings.NewReader(input), &out)
+	if err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	return out.String(), result
+}
+
+// tryTransformString は、cfg で input を変換した出力とエラーを返します。
+func tryTransformString(t *testing.T, cfg Config, input string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	_, err := Transform(context.Background(), cfg, strings.NewReader(input), &out)
+	return out.String(), err
+}
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		input string
+		want  string
+	}{
+		{
+			name:  "no rules",
+			cfg:   Config{},
+			input: `<a><b x="1">text</b></a>`,
+			want:  `<a><b x="1">text</b></a>`,
+		},
+		{
+			name:  "rename",
+			cfg:   Config{NameRules: []ConfigNameRule{{Old: "b", New: "c"}}},
+			input: `<a><b>text</b></a>`,
+			want:  `<a><c>text</c></a>`,
+		},
+		{
+			name:  "insert before with counter",
+			cfg:   Config{InsertRules: []ConfigInsertRule{{Target: "b", Template: `<n>%d</n>`, Counter: "c"}}, Counters: map[string]ConfigCounter{"c": {}}},
+			input: `<a><b/><b/></a>`,
+			want:  "<a><n>1</n><b></b><n>2</n><b></b></a>",
+		},
+		{
+			name:  "insert after",
+			cfg:   Config{InsertAfterRules: []ConfigInsertRule{{Target: "b", Template: `<n/>`}}},
+			input: `<a><b>x</b><c/></a>`,
+			want:  "<a><b>x</b><n></n><c></c></a>",
+		},
+		{
+			name:  "prepend child with counter start",
+			cfg:   Config{PrependChildRules: []ConfigInsertRule{{Target: "b", Template: `<id>%d</id>`, Counter: "c"}}, Counters: map[string]ConfigCounter{"c": {Start: 10}}},
+			input: `<a><b>x</b><b/></a>`,
+			want:  "<a><b><id>11</id>x</b><b><id>12</id></b></a>",
+		},
+		{
+			name:  "append value",
+			cfg:   Config{ValueRules: []ConfigValueRule{{Target: "b", Type: "append", Params: map[string]interface{}{"suffix": "!"}}}},
+			input: `<a><b>hi</b></a>`,
+			want:  `<a><b>hi!</b></a>`,
+		},
+		{
+			name:  "wrap children",
+			cfg:   Config{WrapRules: []ConfigWrapRule{{Target: "b", Wrapper: "w"}}},
+			input: `<a><b>x</b></a>`,
+			want:  `<a><b><w>x</w></b></a>`,
+		},
+		{
+			name:  "cdata rules in raw tags",
+			cfg:   Config{RawTags: []string{"b"}, CdataRules: []ConfigCdataRule{{Old: "x", New: "<y>"}}},
+			input: `<a><b>x</b><c>x</c></a>`,
+			want:  "<a><b><![CDATA[<y>]]></b><c>x</c></a>",
+		},
+		{
+			name:  "raw tags",
+			cfg:   Config{RawTags: []string{"b"}},
+			input: `<a><b>1 &lt; 2</b></a>`,
+			want:  "<a><b><![CDATA[1 < 2]]></b></a>",
+		},
+		{
+			name:  "raw tags containing the cdata end",
+			cfg:   Config{RawTags: []string{"b"}},
+			input: `<a><b>x]]&gt;y</b></a>`,
+			want:  "<a><b><![CDATA[x]]]]><![CDATA[>y]]></b></a>",
+		},
+		{
+			name:  "cdata rule producing the cdata end",
+			cfg:   Config{RawTags: []string{"b"}, CdataRules: []ConfigCdataRule{{Old: "x", New: "]]>"}}},
+			input: `<a><b>x</b></a>`,
+			want:  "<a><b><![CDATA[]]]]><![CDATA[>]]></b></a>",
+		},
+		{
+			name:  "static template inserted for each match",
+			cfg:   Config{InsertRules: []ConfigInsertRule{{Target: "b", Template: `<x a="1">t<y/></x>`}}},
+			input: `<a><b/><c><b/></c></a>`,
+			want:  `<a><x a="1">t<y></y></x><b></b><c><x a="1">t<y></y></x><b></b></c></a>`,
+		},
+		{
+			name: "capture into a later template",
+			cfg: Config{
+				CaptureRules:     []ConfigCaptureRule{{Target: "id", Variable: "id"}},
+				InsertAfterRules: []ConfigInsertRule{{Target: "item", Template: `<ref id="{{var "id"}}"/>`}},
+			},
+			input: `<a><item><id>1&amp;2</id></item><item><id>3</id></item></a>`,
+			want:  `<a><item><id>1&amp;2</id></item><ref id="1&amp;2"></ref><item><id>3</id></item><ref id="3"></ref></a>`,
+		},
+		{
+			name: "capture into value rules",
+			cfg: Config{
+				NameRules:    []ConfigNameRule{{Old: "code", New: "c"}},
+				CaptureRules: []ConfigCaptureRule{{Target: "code", Variable: "code"}},
+				ValueRules: []ConfigValueRule{
+					{Target: "name", Type: "prepend", Params: params{"prefix": `{{var "code"}}:`}},
+					{Target: "note", Type: "script", Params: params{"expr": `value + "/" + vars.code`}},
+				},
+			},
+			input: `<a><code>A</code><name>x</name><code>B</code><note>y</note></a>`,
+			want:  `<a><c>A</c><name>A:x</name><c>B</c><note>y/B</note></a>`,
+		},
+		{
+			name: "variables not captured yet are empty",
+			cfg: Config{
+				CaptureRules:      []ConfigCaptureRule{{Target: "id", Variable: "id"}},
+				PrependChildRules: []ConfigInsertRule{{Target: "a", Template: `<v>{{var "id"}}</v>`}},
+			},
+			input: `<a><id>1</id></a>`,
+			want:  `<a><v></v><id>1</id></a>`,
+		},
+		{
+			name: "summary count and sum",
+			cfg: Config{SummaryRules: []ConfigSummaryRule{
+				{Target: "item", Element: "count"},
+				{Target: "price", Element: "total", Function: "sum"},
+			}},
+			input: `<a><item><price> 1.5</price></item><item><price>-0.25</price></item><item><price>10</price></item></a>`,
+			want:  `<a><item><price> 1.5</price></item><item><price>-0.25</price></item><item><price>10</price></item><count>3</count><total>11.25</total></a>`,
+		},
+		{
+			name:  "summary of no elements",
+			cfg:   Config{SummaryRules: []ConfigSummaryRule{{Target: "item", Element: "count"}, {Target: "price", Element: "total", Function: "sum"}}},
+			input: `<a></a>`,
+			want:  `<a><count>0</count><total>0</total></a>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Output = compactOutput
+			got, _ := transformString(t, tt.cfg, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformResult(t *testing.T) {
+	cfg := Config{
+		NameRules:  []ConfigNameRule{{Old: "b", New: "c"}, {Old: "unused", New: "x"}},
+		ValueRules: []ConfigValueRule{{Target: "c", Type: "append", Params: params{"suffix": "!"}}},
+		WrapRules:  []ConfigWrapRule{{Target: "a", Wrapper: "w"}},
+		Output:     compactOutput,
+	}
+	_, result := transformString(t, cfg, `<a><b>1</b><b>2</b></a>`)
+	wantHits := map[string]int{"name_rules[0]": 2, "value_rules[0]": 2, "wrap_rules[0]": 1}
+	if !reflect.DeepEqual(result.RuleHits, wantHits) {
+		t.Errorf("RuleHits = %v, want %v", result.RuleHits, wantHits)
+	}
+	if want := []string{"name_rules[0]", "value_rules[0]", "wrap_rules[0]"}; !reflect.DeepEqual(result.RuleHitNames(), want) {
+		t.Errorf("RuleHitNames() = %q, want %q", result.RuleHitNames(), want)
+	}
+	if result.Elements != 3 {
+		t.Errorf("Elements = %d, want 3", result.Elements)
+	}
+}
+
+func TestTransformBytes(t *testing.T) {
+	const input = "<a><b>日本</b><c/></a>"
+	var out bytes.Buffer
+	result, err := Transform(context.Background(), Config{Output: ConfigOutput{Encoding: "Shift_JIS"}}, strings.NewReader(input), &out)
+	if err != nil {
+		t.Fatalf("Transform: %v", err)
+	}
+	if result.Elements != 3 {
+		t.Errorf("Elements = %d, want 3", result.Elements)
+	}
+	if result.BytesRead != int64(len(input)) {
+		t.Errorf("BytesRead = %d, want %d", result.BytesRead, len(input))
+	}
+	if result.BytesWritten != int64(out.Len()) {
+		t.Errorf("BytesWritten = %d, want %d", result.BytesWritten, out.Len())
+	}
+
+	// 出力エンコーディングと改行コードは、設定に従って変換される
+	got, err := japanese.ShiftJIS.NewDecoder().String(out.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<?xml version=\"1.0\" encoding=\"Shift_JIS\"?><a>\r\n  <b>日本</b>\r\n  <c></c>\r\n</a>"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestTransformCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Transform(ctx, Config{}, strings.NewReader(`<a/>`), &bytes.Buffer{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Transform error = %v, want context.Canceled", err)
+	}
+}
+
+// registerTestValueFunc は、テスト用の値置換の種類 "test_upper" を一度だけ登録します (go test -count でも重複しない)。
+var registerTestValueFunc = sync.OnceFunc(func() {
+	RegisterValueFunc("test_upper", func(params map[string]interface{}) (ValueReplaceFunc, error) {
+		return strings.ToUpper, nil
+	})
+})
+
+func TestRegisterValueFunc(t *testing.T) {
+	registerTestValueFunc()
+	found := false
+	for _, name := range ValueFuncs() {
+		found = found || name == "test_upper"
+	}
+	if !found {
+		t.Errorf("ValueFuncs() = %q, want it to include test_upper", ValueFuncs())
+	}
+	cfg := Config{ValueRules: []ConfigValueRule{{Target: "b", Type: "test_upper"}}, Output: compactOutput}
+	if got, _ := transformString(t, cfg, `<a><b>up</b></a>`); got != `<a><b>UP</b></a>` {
+		t.Errorf("output = %q, want %q", got, `<a><b>UP</b></a>`)
+	}
+}
+
+// registerTestValueContextFunc は、テスト用の種類 "test_tag_value" を一度だけ登録します。
+// 置換関数は値の前に対象要素のタグ名を付け、値が "fail" の場合はエラーを返します。
+var registerTestValueContextFunc = sync.OnceFunc(func() {
+	RegisterValueContextFunc("test_tag_value", func(params map[string]interface{}) (ValueContextFunc, error) {
+		return func(oldValue string, ctx ValueContext) (string, error) {
+			if oldValue == "fail" {
+				return "", errors.New("test failure")
+			}
+			return ctx.Element.Name.Local + ":" + oldValue, nil
+		}, nil
+	})
+})
+
+func TestRegisterValueContextFunc(t *testing.T) {
+	registerTestValueContextFunc()
+	cfg := Config{ValueRules: []ConfigValueRule{{Target: "b", Type: "test_tag_value"}}, Output: compactOutput}
+	if got, _ := transformString(t, cfg, `<a><b>x</b></a>`); got != `<a><b>b:x</b></a>` {
+		t.Errorf("output = %q, want %q", got, `<a><b>b:x</b></a>`)
+	}
+	if _, err := tryTransformString(t, cfg, `<a><b>fail</b></a>`); err == nil || !strings.Contains(err.Error(), "test failure") {
+		t.Errorf("Transform error = %v, want the error of the value function", err)
+	}
+}
+
+func TestRegisterValueFuncPanics(t *testing.T) {
+	registerTestValueFunc()
+	factory := func(params map[string]interface{}) (ValueReplaceFunc, error) { return strings.ToLower, nil }
+	tests := []struct {
+		name    string
+		typ     string
+		factory ValueFuncFactory
+	}{
+		{"empty name", "", factory},
+		{"nil factory", "test_nil", nil},
+		{"duplicate", "test_upper", factory},
+		{"built-in type", "append", factory},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterValueFunc(%q) did not panic", tt.typ)
+				}
+			}()
+			RegisterValueFunc(tt.typ, tt.factory)
+		})
+	}
+}
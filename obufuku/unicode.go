@@ -0,0 +1,99 @@
+package obufuku
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// unicode_rules の対象の範囲 (scope) です。
+const (
+	unicodeScopeAll        = "all"
+	unicodeScopeText       = "text"
+	unicodeScopeAttributes = "attributes"
+)
+
+// lookupNormForm は、Unicode の正規化形式の名前 (NFC、NFD、NFKC、NFKD、大文字小文字を区別しない) を返します。
+func lookupNormForm(name string) (norm.Form, error) {
+	switch strings.ToUpper(name) {
+	case "NFC":
+		return norm.NFC, nil
+	case "NFD":
+		return norm.NFD, nil
+	case "NFKC":
+		return norm.NFKC, nil
+	case "NFKD":
+		return norm.NFKD, nil
+	}
+	return norm.NFC, fmt.Errorf("unknown normalization form '%s' (available: NFC, NFD, NFKC, NFKD)", name)
+}
+
+// newUnicodeFunc は、値を params["form"] の形式 (NFC、NFD、NFKC、NFKD) に正規化する置換関数を作成します。
+func newUnicodeFunc(params map[string]interface{}) (ValueReplaceFunc, error) {
+	name, ok := params["form"].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid or missing 'form' for unicode rule")
+	}
+	form, err := lookupNormForm(name)
+	if err != nil {
+		return nil, err
+	}
+	return form.String, nil
+}
+
+// unicodeFilter は、要素のテキストと属性値を Unicode の正規化形式にします (unicode_rules)。
+// 最初に一致したルールだけを適用します。value_rules と同じく置換後のタグ名と照合し、
+// raw_tags の要素の中身と名前空間の宣言は対象にしません。
+type unicodeFilter struct {
+	BaseFilter
+	p *Processor
+}
+
+func (f unicodeFilter) BeforeStart(w TokenWriter, el *Element) error {
+	i := f.p.unicodeRule(el.Start.Name.Local)
+	if i < 0 || !f.p.unicodeRules[i].Attributes {
+		return nil
+	}
+	f.p.targetSeen(hitUnicodeRules, i)
+	form := f.p.unicodeRules[i].Form
+	for j, attr := range el.Start.Attr {
+		if isNamespaceDecl(attr) || form.IsNormalString(attr.Value) {
+			continue
+		}
+		value := form.String(attr.Value)
+		name := f.p.qualifiedAttrName(attr.Name, el.Start.Attr)
+		f.p.ruleApplied(hitUnicodeRules, i, el.Start.Name.Local, fmt.Sprintf(`%s="%s"`, name, attr.Value), fmt.Sprintf(`%s="%s"`, name, value))
+		el.Start.Attr[j].Value = value
+	}
+	return nil
+}
+
+func (f unicodeFilter) CharData(w TokenWriter, el *Element, text *Text) error {
+	if text.Raw {
+		return nil
+	}
+	i := f.p.unicodeRule(el.Start.Name.Local)
+	if i < 0 || !f.p.unicodeRules[i].Text {
+		return nil
+	}
+	f.p.targetSeen(hitUnicodeRules, i)
+	if f.p.unicodeRules[i].Form.IsNormalString(text.Data) {
+		return nil
+	}
+	value := f.p.unicodeRules[i].Form.String(text.Data)
+	f.p.ruleApplied(hitUnicodeRules, i, "", text.Data, value)
+	text.Data = value
+	text.Modified = true
+	return nil
+}
+
+// unicodeRule は、タグ name の要素に適用する最初の unicode_rules のルールの位置を返します (無ければ -1)。
+func (p *Processor) unicodeRule(name string) int {
+	for i, rule := range p.unicodeRules {
+		if rule.TargetTag == "" || rule.TargetTag == name {
+			return i
+		}
+	}
+	return -1
+}
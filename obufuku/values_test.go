@@ -0,0 +1,123 @@
+package obufuku
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValueTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		typ    string
+		params params
+		value  string
+		want   string
+	}{
+		{"prepend", "prepend", params{"prefix": "No."}, "12", "No.12"},
+		{"append", "append", params{"suffix": "円"}, "100", "100円"},
+		{"html_unescape", "html_unescape", nil, "&amp;lt;b&amp;gt;", "&lt;b&gt;"},
+		{"html_unescape repeat", "html_unescape", params{"repeat": true}, "&amp;amp;lt;", "&lt;"},
+		{"html_escape", "html_escape", nil, "&lt;b&gt;", "&amp;lt;b&amp;gt;"},
+
+		{"date iso", "date", nil, "2024/1/5", "2024-01-05"},
+		{"date fullwidth digits", "date", nil, "２０２４年１月５日", "2024-01-05"},
+		{"date reiwa", "date", nil, "令和6年1月5日", "2024-01-05"},
+		{"date first year of reiwa", "date", nil, "令和元年5月1日", "2019-05-01"},
+		{"date heisei letter", "date", nil, "H31.4.30", "2019-04-30"},
+		{"date showa", "date", nil, "昭和64年1月7日", "1989-01-07"},
+		{"date format", "date", params{"format": "2006年01月02日"}, "2024-01-05", "2024年01月05日"},
+		{"date layouts", "date", params{"layouts": []interface{}{"01/02/2006"}}, "01/05/2024", "2024-01-05"},
+		{"date keep unrecognized", "date", params{"on_error": "keep"}, "someday", "someday"},
+		{"date keep outside era", "date", params{"on_error": "keep"}, "平成31年5月1日", "平成31年5月1日"},
+		{"date time", "date", nil, "2024/1/5 13:45", "2024-01-05"},
+		{"date lowercase era letter", "date", nil, "r6.1.5", "2024-01-05"},
+
+		{"kana to katakana", "kana", params{"to": "katakana"}, "ひらがなｶﾀｶﾅ", "ヒラガナカタカナ"},
+		{"kana to hiragana", "kana", params{"to": "hiragana"}, "カタカナｶﾅ", "かたかなかな"},
+		{"kana composes halfwidth voiced marks", "kana", params{"to": "katakana"}, "ひらがなｶﾞｯｺｳ", "ヒラガナガッコウ"},
+		{"kana composes halfwidth semi-voiced marks", "kana", params{"to": "hiragana"}, "カタカナﾊﾟﾝ", "かたかなぱん"},
+		{"kana to halfwidth", "kana", params{"to": "halfwidth_katakana"}, "がっこうパン", "ｶﾞｯｺｳﾊﾟﾝ"},
+		{"kana keeps other characters", "kana", params{"to": "katakana"}, "漢字とABC", "漢字トABC"},
+
+		{"unicode nfkc", "unicode", params{"form": "NFKC"}, "ＡＢＣ①ｶ", "ABC1カ"},
+		{"unicode nfc", "unicode", params{"form": "NFC"}, "é", "é"},
+
+		{"base64_encode", "base64_encode", nil, "日本", "5pel5pys"},
+		{"base64_encode url without padding", "base64_encode", params{"alphabet": "url", "padding": false}, "ÿþ", "w7_Dvg"},
+		{"base64_decode", "base64_decode", nil, "5pel5pys", "日本"},
+		{"base64_decode ignores spaces and padding", "base64_decode", nil, "aGk\n=", "hi"},
+		{"base64_decode url", "base64_decode", params{"alphabet": "url"}, "w7_Dvg", "ÿþ"},
+		{"base64_decode keep", "base64_decode", params{"on_error": "keep"}, "not base64!", "not base64!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				ValueRules: []ConfigValueRule{{Target: "v", Type: tt.typ, Params: tt.params}},
+				Output:     compactOutput,
+			}
+			got, _ := transformString(t, cfg, "<v>"+tt.value+"</v>")
+			if want := "<v>" + tt.want + "</v>"; got != want {
+				t.Errorf("output = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestValueTypeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		typ    string
+		params params
+		value  string
+	}{
+		{"date unrecognized", "date", nil, "someday"},
+		{"date outside era", "date", nil, "平成31年5月1日"},
+		{"date invalid day", "date", nil, "令和6年2月30日"},
+		{"base64_decode invalid", "base64_decode", nil, "not base64!"},
+		{"base64_decode control characters", "base64_decode", nil, "AAE="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{ValueRules: []ConfigValueRule{{Target: "v", Type: tt.typ, Params: tt.params}}}
+			_, err := Transform(context.Background(), cfg, strings.NewReader("<v>"+tt.value+"</v>"), &bytes.Buffer{})
+			var encodeErr *EncodeError
+			if !errors.As(err, &encodeErr) {
+				t.Fatalf("Transform error = %v, want an EncodeError", err)
+			}
+			if encodeErr.Rule != "value_rules[0]" {
+				t.Errorf("Rule = %q, want %q", encodeErr.Rule, "value_rules[0]")
+			}
+		})
+	}
+}
+
+func TestValueTypeParamErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		typ    string
+		params params
+	}{
+		{"prepend without prefix", "prepend", nil},
+		{"html_unescape repeat not a boolean", "html_unescape", params{"repeat": "yes"}},
+		{"date layouts not an array", "date", params{"layouts": "2006"}},
+		{"date unknown on_error", "date", params{"on_error": "ignore"}},
+		{"kana without to", "kana", nil},
+		{"kana unknown to", "kana", params{"to": "romaji"}},
+		{"unicode unknown form", "unicode", params{"form": "NFX"}},
+		{"unicode without form", "unicode", nil},
+		{"base64 unknown alphabet", "base64_encode", params{"alphabet": "hex"}},
+		{"base64 padding not a boolean", "base64_decode", params{"padding": "no"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRuleSet(Config{ValueRules: []ConfigValueRule{{Target: "v", Type: tt.typ, Params: tt.params}}})
+			var configErr *RuleConfigError
+			if !errors.As(err, &configErr) || configErr.Rule != "value_rules[0]" {
+				t.Errorf("NewRuleSet error = %v, want a RuleConfigError for value_rules[0]", err)
+			}
+		})
+	}
+}
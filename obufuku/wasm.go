@@ -0,0 +1,148 @@
+package obufuku
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// WebAssembly の値置換モジュールが公開する関数の名前です。
+//
+// モジュールは線形メモリ memory と次の関数を公開します。
+//
+//	alloc(size i32) i32              size バイトの領域を確保し、その先頭アドレスを返す
+//	transform(ptr i32, len i32) i64  UTF-8の値を受け取り、結果の (アドレス << 32 | 長さ) を返す
+//	configure(ptr i32, len i32) i32  (任意) ルールの params をJSONで受け取り、成功なら 0 を返す
+//
+// transform が負の値を返した場合は失敗とみなします。結果の領域は次の呼び出しまで有効である必要があります。
+// WASI (wasi_snapshot_preview1) は使えますが、ファイルシステムやネットワークにはアクセスできません。
+const (
+	wasmAllocFunc     = "alloc"
+	wasmTransformFunc = "transform"
+	wasmConfigureFunc = "configure"
+)
+
+var (
+	wasmRuntimeOnce sync.Once
+	wasmRuntime     wazero.Runtime
+)
+
+// sharedWASMRuntime は、すべてのモジュールで共有するWebAssemblyのランタイムを返します。
+func sharedWASMRuntime() wazero.Runtime {
+	wasmRuntimeOnce.Do(func() {
+		ctx := context.Background()
+		wasmRuntime = wazero.NewRuntime(ctx)
+		wasi_snapshot_preview1.MustInstantiate(ctx, wasmRuntime)
+	})
+	return wasmRuntime
+}
+
+// RegisterWASMValueFunc は、WebAssembly モジュール wasm を値置換の種類 name として登録します。
+// モジュールはサンドボックス内で実行され、値置換ルールごとに独立したインスタンスが作られます。
+// モジュールが必要な関数を公開していない場合や、name が登録済みの場合はエラーを返します。
+func RegisterWASMValueFunc(name string, wasm []byte) error {
+	ctx := context.Background()
+	runtime := sharedWASMRuntime()
+	compiled, err := runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		return fmt.Errorf("failed to compile WebAssembly module for '%s': %w", name, err)
+	}
+	exports := compiled.ExportedFunctions()
+	for _, fn := range []string{wasmAllocFunc, wasmTransformFunc} {
+		if _, ok := exports[fn]; !ok {
+			return fmt.Errorf("WebAssembly module for '%s' does not export function '%s'", name, fn)
+		}
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		return fmt.Errorf("WebAssembly module for '%s' does not export 'memory'", name)
+	}
+
+	factory := func(params map[string]interface{}) (ValueContextFunc, error) {
+		module, err := newWASMModule(ctx, runtime, compiled, params)
+		if err != nil {
+			return nil, fmt.Errorf("value rule type '%s': %w", name, err)
+		}
+		return func(oldValue string, _ ValueContext) (string, error) {
+			return module.call(ctx, wasmTransformFunc, oldValue)
+		}, nil
+	}
+	return registerValueFunc(name, valueFuncFactory{withContext: factory})
+}
+
+// wasmModule は、値置換ルールごとのWebAssemblyモジュールのインスタンスです。
+type wasmModule struct {
+	mod   api.Module
+	alloc api.Function
+}
+
+// newWASMModule は、モジュールをインスタンス化し、公開されていれば configure に params を渡します。
+func newWASMModule(ctx context.Context, runtime wazero.Runtime, compiled wazero.CompiledModule, params map[string]interface{}) (*wasmModule, error) {
+	// 名前を付けないことで、同じモジュールを複数インスタンス化できる
+	config := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	mod, err := runtime.InstantiateModule(ctx, compiled, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate WebAssembly module: %w", err)
+	}
+	m := &wasmModule{mod: mod, alloc: mod.ExportedFunction(wasmAllocFunc)}
+	if mod.ExportedFunction(wasmConfigureFunc) == nil {
+		return m, nil
+	}
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	ptr, size, err := m.write(ctx, paramsJSON)
+	if err != nil {
+		return nil, err
+	}
+	results, err := mod.ExportedFunction(wasmConfigureFunc).Call(ctx, uint64(ptr), uint64(size))
+	if err != nil {
+		return nil, fmt.Errorf("WebAssembly function '%s' failed: %w", wasmConfigureFunc, err)
+	}
+	if len(results) != 1 || int32(results[0]) != 0 {
+		return nil, fmt.Errorf("WebAssembly function '%s' rejected params", wasmConfigureFunc)
+	}
+	return m, nil
+}
+
+// write は、モジュールのメモリを確保して data を書き込みます。
+func (m *wasmModule) write(ctx context.Context, data []byte) (uint32, uint32, error) {
+	results, err := m.alloc.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, 0, fmt.Errorf("WebAssembly function '%s' failed: %w", wasmAllocFunc, err)
+	}
+	ptr := uint32(results[0])
+	if !m.mod.Memory().Write(ptr, data) {
+		return 0, 0, fmt.Errorf("WebAssembly function '%s' returned an out-of-range address", wasmAllocFunc)
+	}
+	return ptr, uint32(len(data)), nil
+}
+
+// call は、値をモジュールのメモリに書き込んで関数 fn を呼び出し、結果の文字列を読み出します。
+func (m *wasmModule) call(ctx context.Context, fn, value string) (string, error) {
+	ptr, size, err := m.write(ctx, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	results, err := m.mod.ExportedFunction(fn).Call(ctx, uint64(ptr), uint64(size))
+	if err != nil {
+		return "", fmt.Errorf("WebAssembly function '%s' failed: %w", fn, err)
+	}
+	if int64(results[0]) < 0 {
+		return "", fmt.Errorf("WebAssembly function '%s' reported an error for value '%s'", fn, value)
+	}
+	outPtr, outSize := uint32(results[0]>>32), uint32(results[0])
+	out, ok := m.mod.Memory().Read(outPtr, outSize)
+	if !ok {
+		return "", fmt.Errorf("WebAssembly function '%s' returned an out-of-range result", fn)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,72 @@
+package obufuku
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// dropFirstByteWASM は、値の先頭の1バイトを取り除く WebAssembly モジュールです。
+// alloc は常にアドレス 1024 を返し、transform は (ptr+1, len-1) を返します。
+var dropFirstByteWASM = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // マジックナンバーとバージョン
+	// 型: (i32) -> i32, (i32, i32) -> i64
+	0x01, 0x0c, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e,
+	// 関数: alloc, transform
+	0x03, 0x03, 0x02, 0x00, 0x01,
+	// メモリ: 1ページ
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	// エクスポート: memory, alloc, transform
+	0x07, 0x1e, 0x03,
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	0x05, 'a', 'l', 'l', 'o', 'c', 0x00, 0x00,
+	0x09, 't', 'r', 'a', 'n', 's', 'f', 'o', 'r', 'm', 0x00, 0x01,
+	// コード
+	0x0a, 0x1a, 0x02,
+	0x05, 0x00, 0x41, 0x80, 0x08, 0x0b, // i32.const 1024
+	0x12, 0x00,
+	0x20, 0x00, 0x41, 0x01, 0x6a, 0xad, // i64(ptr + 1)
+	0x42, 0x20, 0x86, // << 32
+	0x20, 0x01, 0x41, 0x01, 0x6b, 0xad, // i64(len - 1)
+	0x84, 0x0b, // |
+}
+
+// registerTestWASM は、テスト用の種類 "test_wasm_drop" を一度だけ登録します。
+var registerTestWASM = sync.OnceValue(func() error {
+	return RegisterWASMValueFunc("test_wasm_drop", dropFirstByteWASM)
+})
+
+func TestRegisterWASMValueFunc(t *testing.T) {
+	if err := registerTestWASM(); err != nil {
+		t.Fatalf("RegisterWASMValueFunc: %v", err)
+	}
+	cfg := Config{ValueRules: []ConfigValueRule{{Target: "v", Type: "test_wasm_drop"}}, Output: compactOutput}
+	got, _ := transformString(t, cfg, `<a><v>#12</v><v>x日本</v></a>`)
+	if want := `<a><v>12</v><v>日本</v></a>`; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestRegisterWASMValueFuncErrors(t *testing.T) {
+	registerTestWASM()
+	emptyModule := dropFirstByteWASM[:8]
+	tests := []struct {
+		name string
+		typ  string
+		wasm []byte
+		err  string
+	}{
+		{"not a module", "test_wasm_invalid", []byte("not wasm"), "failed to compile"},
+		{"missing functions", "test_wasm_empty", emptyModule, "does not export function 'alloc'"},
+		{"duplicate", "test_wasm_drop", dropFirstByteWASM, "registered twice"},
+		{"empty name", "", dropFirstByteWASM, "must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RegisterWASMValueFunc(tt.typ, tt.wasm)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("RegisterWASMValueFunc error = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package obufuku
+
+import (
+	"bytes"
+	"io"
+)
+
+// 出力の改行コードです。
+const (
+	newlineLF   = "\n"
+	newlineCRLF = "\r\n"
+)
+
+// newlineWriter は、io.Writerをラップし、改行コード (LF と CRLF) を newline に揃えます。
+// 既に newline になっている改行はそのままのため、何度通しても結果は変わりません。
+// Write の境界で分かれた CRLF も1つの改行として扱います。
+type newlineWriter struct {
+	w       io.Writer
+	newline []byte
+	// pendingCR は、前回の Write が CR で終わり、次の LF と組になるかが未確定であることを表します。
+	pendingCR bool
+	buf       []byte
+}
+
+// NewNewlineWriter は、改行コードを newline ("\n" または "\r\n") に揃える新しいWriterを作成します。
+// 単独の CR は改行として扱わずにそのまま書き込みます。
+// 書き込みの最後が CR の場合に備え、書き込み完了後に Close を呼び出す必要があります (w は閉じません)。
+func NewNewlineWriter(w io.Writer, newline string) io.WriteCloser {
+	return &newlineWriter{w: w, newline: []byte(newline)}
+}
+
+// NewCRLFWriter は、CRLF改行コードを保証する新しいWriterを作成します。
+func NewCRLFWriter(w io.Writer) io.WriteCloser {
+	return NewNewlineWriter(w, newlineCRLF)
+}
+
+// Write は io.Writer インターフェースを実装します。
+// 書き込まれるデータ内の改行を置換してから、元のWriterに渡します。
+func (nw *newlineWriter) Write(p []byte) (int, error) {
+	buf := nw.buf[:0]
+	rest := p
+	if nw.pendingCR && len(rest) > 0 {
+		nw.pendingCR = false
+		if rest[0] == '\n' {
+			buf = append(buf, nw.newline...)
+			rest = rest[1:]
+		} else {
+			buf = append(buf, '\r')
+		}
+	}
+	for len(rest) > 0 {
+		i := bytes.IndexAny(rest, "\r\n")
+		if i < 0 {
+			buf = append(buf, rest...)
+			break
+		}
+		buf = append(buf, rest[:i]...)
+		switch {
+		case rest[i] == '\n':
+			buf = append(buf, nw.newline...)
+			rest = rest[i+1:]
+		case i+1 == len(rest):
+			// CR で終わる場合は、次の書き込みの先頭を見るまで保留する
+			nw.pendingCR = true
+			rest = nil
+		case rest[i+1] == '\n':
+			buf = append(buf, nw.newline...)
+			rest = rest[i+2:]
+		default:
+			buf = append(buf, '\r')
+			rest = rest[i+1:]
+		}
+	}
+	nw.buf = buf
+	if _, err := nw.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close は、保留している CR を書き込みます。元のWriterは閉じません。
+func (nw *newlineWriter) Close() error {
+	if !nw.pendingCR {
+		return nil
+	}
+	nw.pendingCR = false
+	_, err := nw.w.Write([]byte{'\r'})
+	return err
+}
+
+// countingWriter は、io.Writerをラップし、書き込まれたバイト数を数えます。
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write は io.Writer インターフェースを実装します。
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Flush は、ラップしたWriterが Flush を持っていればそれを呼び出します。
+func (cw *countingWriter) Flush() error {
+	if f, ok := cw.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package obufuku
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewlineWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		newline string
+		chunks  []string
+		want    string
+	}{
+		{"lf to crlf", newlineCRLF, []string{"a\nb\n"}, "a\r\nb\r\n"},
+		{"crlf stays crlf", newlineCRLF, []string{"a\r\nb\r\n"}, "a\r\nb\r\n"},
+		{"crlf to lf", newlineLF, []string{"a\r\nb\nc"}, "a\nb\nc"},
+		{"crlf split across writes", newlineCRLF, []string{"a\r", "\nb"}, "a\r\nb"},
+		{"crlf split across writes to lf", newlineLF, []string{"a\r", "\n", "b\r", "\r\n"}, "a\nb\r\n"},
+		{"lone cr", newlineLF, []string{"a\rb"}, "a\rb"},
+		{"cr at the end", newlineLF, []string{"a\r"}, "a\r"},
+		{"cr then other text", newlineCRLF, []string{"a\r", "b"}, "a\rb"},
+		{"empty writes", newlineCRLF, []string{"", "a\r", "", "\n"}, "a\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := NewNewlineWriter(&out, tt.newline)
+			for _, chunk := range tt.chunks {
+				n, err := w.Write([]byte(chunk))
+				if err != nil || n != len(chunk) {
+					t.Fatalf("Write(%q) = %d, %v, want %d, nil", chunk, n, err, len(chunk))
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestNewlineWriterIdempotent(t *testing.T) {
+	// 出力をもう一度通しても変わらない
+	input := "a\nb\r\nc\rd\r"
+	for _, newline := range []string{newlineLF, newlineCRLF} {
+		var once, twice bytes.Buffer
+		w := NewNewlineWriter(&once, newline)
+		w.Write([]byte(input))
+		w.Close()
+		w = NewNewlineWriter(&twice, newline)
+		w.Write(once.Bytes())
+		w.Close()
+		if once.String() != twice.String() {
+			t.Errorf("newline %q: second pass gives %q, want %q", newline, twice.String(), once.String())
+		}
+	}
+}
+
+func TestOutputNewline(t *testing.T) {
+	tests := []struct {
+		name    string
+		newline string
+		want    string
+	}{
+		{"default", "", "<a>\r\n  <b>x</b>\r\n</a>"},
+		{"crlf", "CRLF", "<a>\r\n  <b>x</b>\r\n</a>"},
+		{"lf", "lf", "<a>\n  <b>x</b>\n</a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := transformString(t, Config{Output: ConfigOutput{Newline: tt.newline}}, "<a><b>x</b></a>")
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("output = %q, want it to start with %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputNewlineErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		output ConfigOutput
+	}{
+		{"unknown", ConfigOutput{Newline: "cr"}},
+		{"with minimal", ConfigOutput{Minimal: true, Newline: "lf"}},
+		{"with canonical", ConfigOutput{Canonical: true, Newline: "lf"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRuleSet(Config{Output: tt.output}); err == nil {
+				t.Error("NewRuleSet succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestAttributeOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted bool
+		order  []string
+		input  string
+		want   string
+	}{
+		{"unchanged", false, nil, `<a c="3" b="2" a="1"/>`, `<a c="3" b="2" a="1"></a>`},
+		{"sorted", true, nil, `<a c="3" b="2" a="1"/>`, `<a a="1" b="2" c="3"></a>`},
+		{"order", false, []string{"id", "b"}, `<a c="3" b="2" id="0" a="1"/>`, `<a id="0" b="2" c="3" a="1"></a>`},
+		{"order then sorted", true, []string{"id"}, `<a c="3" b="2" id="0"/>`, `<a id="0" b="2" c="3"></a>`},
+		{"declarations first", true, nil, `<a z="1" xmlns:p="urn:p" p:b="2" xmlns="urn:d"/>`, `<a xmlns:p="urn:p" xmlns="urn:d" p:b="2" z="1"></a>`},
+		{"prefixed names", false, []string{"p:b"}, `<a xmlns:p="urn:p" b="1" p:b="2"/>`, `<a xmlns:p="urn:p" p:b="2" b="1"></a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := compactOutput
+			output.SortAttributes = tt.sorted
+			output.AttributeOrder = tt.order
+			got, _ := transformString(t, Config{Output: output}, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttributeOrderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		output ConfigOutput
+	}{
+		{"sorted with minimal", ConfigOutput{Minimal: true, SortAttributes: true}},
+		{"order with canonical", ConfigOutput{Canonical: true, AttributeOrder: []string{"id"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRuleSet(Config{Output: tt.output}); err == nil {
+				t.Error("NewRuleSet succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestCharRefs(t *testing.T) {
+	tests := []struct {
+		name  string
+		refs  ConfigCharRefs
+		input string
+		want  string
+	}{
+		{"none", ConfigCharRefs{}, `<a k="é">あ</a>`, `<a k="é">あ</a>`},
+		{"all", ConfigCharRefs{Mode: "all"}, `<a k="é">あ😀</a>`, `<a k="&#xE9;">&#x3042;&#x1F600;</a>`},
+		{"ascii untouched", ConfigCharRefs{Mode: "all"}, `<a>x &amp; y</a>`, `<a>x &amp; y</a>`},
+		{"comments untouched", ConfigCharRefs{Mode: "all"}, `<a><!--あ--></a>`, `<a><!--あ--></a>`},
+		{"tags", ConfigCharRefs{Tags: []string{"b"}}, `<a>あ<b k="い">う<c>え</c></b></a>`, `<a>あ<b k="&#x3044;">&#x3046;<c>&#x3048;</c></b></a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := compactOutput
+			output.CharRefs = tt.refs
+			got, _ := transformString(t, Config{Output: output}, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCharRefsRawTags(t *testing.T) {
+	// ASCII以外の文字は、CDATAセクションを分けてその間に数値文字参照で出力する
+	long := strings.Repeat("ab日本", rawTextChunkSize/4)
+	longWant := strings.Repeat("<![CDATA[ab]]>&#x65E5;&#x672C;", rawTextChunkSize/4)
+	tests := []struct {
+		name    string
+		refs    ConfigCharRefs
+		content string
+		want    string
+	}{
+		{"ascii", ConfigCharRefs{Mode: "all"}, "x &lt; y", "<![CDATA[x < y]]>"},
+		{"non-ascii in the middle", ConfigCharRefs{Mode: "all"}, "x日本y", "<![CDATA[x]]>&#x65E5;&#x672C;<![CDATA[y]]>"},
+		{"non-ascii at both ends", ConfigCharRefs{Mode: "all"}, "日x本", "&#x65E5;<![CDATA[x]]>&#x672C;"},
+		{"cdata end", ConfigCharRefs{Mode: "all"}, "é]]&gt;", "&#xE9;<![CDATA[]]]]><![CDATA[>]]>"},
+		{"tags", ConfigCharRefs{Tags: []string{"r"}}, "xé", "<![CDATA[x]]>&#xE9;"},
+		{"split", ConfigCharRefs{Mode: "all"}, long, longWant},
+		{"split with a closing bracket tail", ConfigCharRefs{Mode: "all"}, long + "]]", longWant + "<![CDATA[]]]]>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := compactOutput
+			output.CharRefs = tt.refs
+			got, _ := transformString(t, Config{RawTags: []string{"r"}, Output: output}, "<a><r>"+tt.content+"</r></a>")
+			if want := "<a><r>" + tt.want + "</r></a>"; got != want {
+				t.Errorf("output = %.200q, want %.200q", got, want)
+			}
+		})
+	}
+}
+
+func TestCharRefsNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		refs  ConfigCharRefs
+		input string
+		err   string
+	}{
+		{"element name", ConfigCharRefs{Mode: "all"}, `<a><名前/></a>`, "element name '名前'"},
+		{"attribute name", ConfigCharRefs{Mode: "all"}, `<a 属性="x"/>`, "attribute name '属性'"},
+		{"prefixed name", ConfigCharRefs{Mode: "all"}, `<a xmlns:ö="urn:x"><ö:b/></a>`, "attribute name 'xmlns:ö'"},
+		{"tag itself", ConfigCharRefs{Tags: []string{"名前"}}, `<a><名前/></a>`, "element name '名前'"},
+		{"inside tags", ConfigCharRefs{Tags: []string{"b"}}, `<a><b><名前/></b></a>`, "element name '名前'"},
+		{"outside tags", ConfigCharRefs{Tags: []string{"b"}}, `<a><名前/><b/></a>`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tryTransformString(t, Config{Output: ConfigOutput{CharRefs: tt.refs}}, tt.input)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Transform: %v", err)
+				}
+				return
+			}
+			var encodeErr *EncodeError
+			if !errors.As(err, &encodeErr) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Transform error = %v, want an EncodeError containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCharRefsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		output ConfigOutput
+	}{
+		{"unknown mode", ConfigOutput{CharRefs: ConfigCharRefs{Mode: "some"}}},
+		{"with minimal", ConfigOutput{Minimal: true, CharRefs: ConfigCharRefs{Mode: "all"}}},
+		{"with canonical", ConfigOutput{Canonical: true, CharRefs: ConfigCharRefs{Tags: []string{"a"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRuleSet(Config{Output: tt.output}); err == nil {
+				t.Error("NewRuleSet succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestEmptyElementStyles(t *testing.T) {
+	tests := []struct {
+		name  string
+		self  ConfigSelfClosing
+		names []ConfigNameRule
+		input string
+		want  string
+	}{
+		{"expand overrides all", ConfigSelfClosing{Mode: "all", Expand: []string{"b"}}, nil, `<a><b/><c/></a>`, `<a><b></b><c/></a>`},
+		{"expand overrides preserve", ConfigSelfClosing{Mode: "preserve", Expand: []string{"b"}}, nil, `<a><b/><c/></a>`, `<a><b></b><c/></a>`},
+		{"remove empty", ConfigSelfClosing{Remove: []string{"b"}}, nil, `<a><b/><b> </b><b>x</b><b><c/></b></a>`, `<a><b>x</b><b><c></c></b></a>`},
+		{"remove nested", ConfigSelfClosing{Remove: []string{"b"}}, nil, `<a><b><b/></b></a>`, `<a></a>`},
+		{"remove elements with only attributes", ConfigSelfClosing{Remove: []string{"b"}}, nil, `<a><b k="1"/></a>`, `<a></a>`},
+		{"remove uses the output name", ConfigSelfClosing{Remove: []string{"c"}}, []ConfigNameRule{{Old: "b", New: "c"}}, `<a><b/></a>`, `<a></a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := compactOutput
+			output.SelfClosing = tt.self
+			got, _ := transformString(t, Config{NameRules: tt.names, Output: output}, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyElementStylesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		self ConfigSelfClosing
+	}{
+		{"tags and expand", ConfigSelfClosing{Tags: []string{"a"}, Expand: []string{"a"}}},
+		{"expand and remove", ConfigSelfClosing{Expand: []string{"a"}, Remove: []string{"a"}}},
+		{"tags and remove", ConfigSelfClosing{Tags: []string{"a"}, Remove: []string{"a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRuleSet(Config{Output: ConfigOutput{SelfClosing: tt.self}}); err == nil {
+				t.Error("NewRuleSet succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,576 @@
+package obufuku
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// xsltNamespace は、XSLT の名前空間のURIです。
+const xsltNamespace = "http://www.w3.org/1999/XSL/Transform"
+
+// XSLTIssue は、ImportXSLT がルールに変換できなかったスタイルシートの箇所です。
+type XSLTIssue struct {
+	// Line は、スタイルシートでの行番号 (1始まり) です。
+	Line    int
+	Message string
+}
+
+func (i XSLTIssue) String() string {
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+// ImportXSLT は、XSLT 1.0 のスタイルシートのうち、ルールで表せる限られた形を
+// 同じ変換を行うルールファイルの設定に変換します。変換できるのは、恒等変換のテンプレートに
+// 次の形のテンプレートを加えたスタイルシートです (match はいずれも要素名1つ)。
+//
+//   - 要素名の置換: <new><xsl:apply-templates select="@*|node()"/></new> (xsl:element も可)
+//   - 前後への挿入: xsl:copy (または名前を置換した要素) の前後のリテラル要素
+//   - 先頭の子への挿入: xsl:copy の中で xsl:apply-templates の前にあるリテラル要素
+//   - 子要素のラップ: xsl:copy の中のリテラル要素で xsl:apply-templates を囲んだもの
+//   - 値の前後への文字列の追加: "ID-" と <xsl:value-of select="."/> の並び、concat('ID-', .) など
+//     (match="id/text()" のテンプレートも同じく扱います)
+//
+// それ以外の構文 (条件分岐、変数、名前付きテンプレート、要素の削除など) は変換せず、
+// 行番号とともに問題として返します。スタイルシートを読み込めない場合や、
+// 変換した設定がルールとして正しくない場合はエラーを返します。
+func ImportXSLT(r io.Reader) (Config, []XSLTIssue, error) {
+	root, err := parseXSLTTree(r)
+	if err != nil {
+		return Config{}, nil, err
+	}
+	if !root.isXSL("stylesheet") && !root.isXSL("transform") {
+		return Config{}, nil, fmt.Errorf("not an XSLT stylesheet: root element is '%s'", root.name.Local)
+	}
+
+	c := &xsltConverter{renamed: make(map[string]string)}
+	for _, child := range root.children {
+		if child.text != nil {
+			continue
+		}
+		switch {
+		case child.isXSL("template"):
+			c.template(child)
+		case child.isXSL("output"):
+			c.output(child)
+		default:
+			c.issue(child, "top-level element '%s' is not supported", child.qualifiedName())
+		}
+	}
+	if !c.identity {
+		c.issue(root, "the stylesheet has no identity template, so unmatched elements are not copied as they are by the rules")
+	}
+
+	if _, err := NewRuleSet(c.config); err != nil {
+		return c.config, c.issues, fmt.Errorf("the translated rules are not valid: %w", err)
+	}
+	return c.config, c.issues, nil
+}
+
+// xsltNode は、スタイルシートの要素またはテキストです。
+type xsltNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*xsltNode
+	// text は、テキストのノードの場合の内容です (要素の場合は nil)。
+	text *string
+	line int
+}
+
+// parseXSLTTree は、スタイルシートを読み込んで要素の木を作成します。
+// xsl:text の中を除き、空白だけのテキストは XSLT と同じく取り除きます。
+func parseXSLTTree(r io.Reader) (*xsltNode, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = newCharsetReader(autoEncoding)
+	var stack []*xsltNode
+	var root *xsltNode
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line, column := decoder.InputPos()
+			return nil, &ParseError{Line: line, Column: column, Err: err}
+		}
+		line, _ := decoder.InputPos()
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xsltNode{name: t.Name, attrs: t.Attr, line: line}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) == 0 {
+				continue
+			}
+			parent := stack[len(stack)-1]
+			text := string(t)
+			if strings.TrimSpace(text) == "" && !parent.isXSL("text") {
+				continue
+			}
+			parent.children = append(parent.children, &xsltNode{text: &text, line: line})
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("stylesheet has no root element")
+	}
+	return root, nil
+}
+
+// isXSL は、ノードが XSLT の要素 xsl:<local> かを返します。
+func (n *xsltNode) isXSL(local string) bool {
+	return n.text == nil && n.name.Space == xsltNamespace && n.name.Local == local
+}
+
+// isLiteral は、ノードが XSLT の要素ではないリテラル要素かを返します。
+func (n *xsltNode) isLiteral() bool {
+	return n.text == nil && n.name.Space != xsltNamespace
+}
+
+// attr は、属性の値を返します (属性が無ければ空)。
+func (n *xsltNode) attr(local string) string {
+	for _, a := range n.attrs {
+		if a.Name.Space == "" && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// qualifiedName は、メッセージに使う要素の名前です。
+func (n *xsltNode) qualifiedName() string {
+	if n.name.Space == xsltNamespace {
+		return "xsl:" + n.name.Local
+	}
+	return n.name.Local
+}
+
+// xsltConverter は、ImportXSLT の変換の状態です。
+type xsltConverter struct {
+	config Config
+	issues []XSLTIssue
+	// identity は、恒等変換のテンプレートがあったかどうかです。
+	identity bool
+	// renamed は、置換前の要素名から置換後の要素名への対応です。
+	renamed map[string]string
+}
+
+// issue は、変換できなかった箇所を記録します。
+func (c *xsltConverter) issue(n *xsltNode, format string, args ...interface{}) {
+	c.issues = append(c.issues, XSLTIssue{Line: n.line, Message: fmt.Sprintf(format, args...)})
+}
+
+// output は、xsl:output の出力エンコーディングとXML宣言の有無を出力設定に変換します。
+func (c *xsltConverter) output(n *xsltNode) {
+	for _, a := range n.attrs {
+		switch a.Name.Local {
+		case "method":
+			if a.Value != "xml" {
+				c.issue(n, "output method '%s' is not supported (only 'xml')", a.Value)
+			}
+		case "encoding":
+			c.config.Output.Encoding = a.Value
+		case "omit-xml-declaration":
+			if a.Value == "yes" {
+				c.config.Output.Declaration.Mode = "remove"
+			}
+		case "version", "indent":
+			// 出力は入力の書式を保つため、インデントの指定は意味を持たない
+		default:
+			c.issue(n, "xsl:output attribute '%s' is not supported", a.Name.Local)
+		}
+	}
+}
+
+// isIdentitySelect は、select が属性と子ノードの両方 (@*|node()) を選ぶかを返します。
+func isIdentitySelect(selectExpr string) bool {
+	s := strings.ReplaceAll(selectExpr, " ", "")
+	return s == "@*|node()" || s == "node()|@*"
+}
+
+// isChildrenSelect は、select が子ノードすべてを選ぶ (省略または node()) かを返します。
+func isChildrenSelect(selectExpr string) bool {
+	s := strings.TrimSpace(selectExpr)
+	return s == "" || s == "node()"
+}
+
+// isIdentityTemplate は、テンプレートが恒等変換 (すべてのノードをそのまま複製する) かを返します。
+func isIdentityTemplate(t *xsltNode) bool {
+	if !isIdentitySelect(t.attr("match")) || len(t.children) != 1 {
+		return false
+	}
+	body := t.children[0]
+	if body.isXSL("copy-of") {
+		return strings.TrimSpace(body.attr("select")) == "."
+	}
+	return body.isXSL("copy") && len(body.children) == 1 &&
+		body.children[0].isXSL("apply-templates") && isIdentitySelect(body.children[0].attr("select"))
+}
+
+// template は、1つのテンプレートをルールに変換します。
+func (c *xsltConverter) template(t *xsltNode) {
+	match := strings.TrimSpace(t.attr("match"))
+	switch {
+	case match == "":
+		c.issue(t, "named template '%s' is not supported", t.attr("name"))
+		return
+	case t.attr("mode") != "":
+		c.issue(t, "template mode '%s' is not supported", t.attr("mode"))
+		return
+	case isIdentityTemplate(t):
+		c.identity = true
+		return
+	}
+	if target, ok := strings.CutSuffix(match, "/text()"); ok && isNCName(target) {
+		prefix, suffix, ok := c.valueParts(t, t.children)
+		if ok {
+			c.valueRule(c.targetName(target), prefix, suffix)
+		}
+		return
+	}
+	if !isNCName(match) {
+		c.issue(t, "match pattern '%s' is not supported (only a single element name)", match)
+		return
+	}
+	if len(t.children) == 0 {
+		c.issue(t, "removing element '%s' is not supported", match)
+		return
+	}
+
+	// 要素を複製するノード (xsl:copy、または名前を置換した要素) を探し、その前後を挿入とする
+	copyIndex := -1
+	for i, child := range t.children {
+		if child.isXSL("copy") || child.isXSL("element") || (child.isLiteral() && containsInstruction(child)) {
+			if copyIndex >= 0 {
+				c.issue(child, "template for '%s' outputs more than one element", match)
+				return
+			}
+			copyIndex = i
+		}
+	}
+	if copyIndex < 0 {
+		c.issue(t, "template for '%s' does not copy the element", match)
+		return
+	}
+	copyNode := t.children[copyIndex]
+	name := match
+	switch {
+	case copyNode.isXSL("element"):
+		name = copyNode.attr("name")
+		if !isNCName(name) {
+			c.issue(copyNode, "computed element name '%s' is not supported", name)
+			return
+		}
+	case copyNode.isLiteral():
+		if copyNode.name.Space != "" || len(copyNode.attrs) > 0 {
+			c.issue(copyNode, "renaming '%s' to an element with a namespace or attributes is not supported", match)
+			return
+		}
+		name = copyNode.name.Local
+	}
+
+	before, ok := c.literals(t.children[:copyIndex])
+	if !ok {
+		return
+	}
+	after, ok := c.literals(t.children[copyIndex+1:])
+	if !ok {
+		return
+	}
+	content, ok := c.elementContent(match, copyNode)
+	if !ok {
+		return
+	}
+
+	if name != match {
+		c.config.NameRules = append(c.config.NameRules, ConfigNameRule{Old: match, New: name})
+		c.renamed[match] = name
+	}
+	if before != "" {
+		c.config.InsertRules = append(c.config.InsertRules, ConfigInsertRule{Target: name, Template: before})
+	}
+	if after != "" {
+		c.config.InsertAfterRules = append(c.config.InsertAfterRules, ConfigInsertRule{Target: name, Template: after})
+	}
+	if content.prepend != "" {
+		c.config.PrependChildRules = append(c.config.PrependChildRules, ConfigInsertRule{Target: name, Template: content.prepend})
+	}
+	if content.wrapper != "" {
+		c.config.WrapRules = append(c.config.WrapRules, ConfigWrapRule{Target: name, Wrapper: content.wrapper})
+	}
+	if content.value {
+		c.valueRule(name, content.prefix, content.suffix)
+	}
+}
+
+// targetName は、ルールの対象とする要素名 (置換後の名前) を返します。
+func (c *xsltConverter) targetName(name string) string {
+	if renamed, ok := c.renamed[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// elementContent は、複製する要素の内容から変換したルールの内容です。
+type elementContent struct {
+	prepend string
+	wrapper string
+	// value が true の場合、要素の値の前後に prefix と suffix を付けます。
+	value          bool
+	prefix, suffix string
+}
+
+// elementContent は、複製する要素 copyNode の内容を解析します。
+func (c *xsltConverter) elementContent(match string, copyNode *xsltNode) (elementContent, bool) {
+	var content elementContent
+	children := copyNode.children
+	attrsCopied := false
+	if len(children) > 0 && (children[0].isXSL("apply-templates") || children[0].isXSL("copy-of")) &&
+		strings.TrimSpace(children[0].attr("select")) == "@*" {
+		attrsCopied = true
+		children = children[1:]
+	}
+
+	for i, child := range children {
+		switch {
+		case child.isXSL("apply-templates"):
+			switch s := child.attr("select"); {
+			case isIdentitySelect(s):
+				attrsCopied = true
+			case !isChildrenSelect(s):
+				c.issue(child, "xsl:apply-templates select='%s' is not supported", s)
+				return content, false
+			}
+			if i+1 < len(children) {
+				c.issue(children[i+1], "content after the children of '%s' is not supported", match)
+				return content, false
+			}
+			prepend, ok := c.literals(children[:i])
+			if !ok {
+				return content, false
+			}
+			content.prepend = prepend
+
+		case child.isLiteral() && containsInstruction(child):
+			if len(children) != 1 || len(child.children) != 1 || !child.children[0].isXSL("apply-templates") ||
+				!isChildrenSelect(child.children[0].attr("select")) {
+				c.issue(child, "only wrapping all children of '%s' in a single element is supported", match)
+				return content, false
+			}
+			if child.name.Space != "" || len(child.attrs) > 0 {
+				c.issue(child, "wrapper element with a namespace or attributes is not supported")
+				return content, false
+			}
+			content.wrapper = child.name.Local
+
+		case child.isXSL("value-of"):
+			prefix, suffix, ok := c.valueParts(copyNode, children)
+			if !ok {
+				return content, false
+			}
+			content.value, content.prefix, content.suffix = true, prefix, suffix
+
+		case child.text == nil && !child.isLiteral() && !child.isXSL("text"):
+			c.issue(child, "'%s' in the template for '%s' is not supported", child.qualifiedName(), match)
+			return content, false
+
+		default:
+			continue
+		}
+		if !attrsCopied {
+			c.issue(copyNode, "attributes of '%s' are dropped by the stylesheet but kept by the rules", match)
+		}
+		return content, true
+	}
+	c.issue(copyNode, "the content of '%s' is not copied by the stylesheet", match)
+	return content, false
+}
+
+// containsInstruction は、リテラル要素の子孫に XSLT の命令 (xsl:text 以外) があるかを返します。
+func containsInstruction(n *xsltNode) bool {
+	for _, child := range n.children {
+		if child.text != nil {
+			continue
+		}
+		if (child.name.Space == xsltNamespace && !child.isXSL("text")) || containsInstruction(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// valueParts は、テキストと <xsl:value-of select="."/> の並び、または concat('…', ., '…') から、
+// 元の値の前後に付ける文字列を取り出します。
+func (c *xsltConverter) valueParts(parent *xsltNode, nodes []*xsltNode) (string, string, bool) {
+	var prefix, suffix strings.Builder
+	seenValue := false
+	for _, n := range nodes {
+		switch {
+		case n.text != nil || n.isXSL("text"):
+			text := nodeText(n)
+			if seenValue {
+				suffix.WriteString(text)
+			} else {
+				prefix.WriteString(text)
+			}
+		case n.isXSL("value-of") && !seenValue:
+			p, s, ok := parseValueSelect(n.attr("select"))
+			if !ok {
+				c.issue(n, "xsl:value-of select='%s' is not supported (only '.' or concat() of literals and '.')", n.attr("select"))
+				return "", "", false
+			}
+			prefix.WriteString(p)
+			suffix.WriteString(s)
+			seenValue = true
+		default:
+			c.issue(n, "'%s' in the value of '%s' is not supported", n.qualifiedName(), parent.attr("match"))
+			return "", "", false
+		}
+	}
+	if !seenValue {
+		c.issue(parent, "replacing the value of '%s' with a constant is not supported", parent.attr("match"))
+		return "", "", false
+	}
+	return prefix.String(), suffix.String(), true
+}
+
+// nodeText は、テキストのノードまたは xsl:text の内容を返します。
+func nodeText(n *xsltNode) string {
+	if n.text != nil {
+		return *n.text
+	}
+	var b strings.Builder
+	for _, child := range n.children {
+		if child.text != nil {
+			b.WriteString(*child.text)
+		}
+	}
+	return b.String()
+}
+
+// parseValueSelect は、xsl:value-of の select を解析し、元の値の前後の文字列を返します。
+// "." や "text()" のほかに、文字列リテラルと "." を連結する concat() を扱います。
+func parseValueSelect(selectExpr string) (string, string, bool) {
+	s := strings.TrimSpace(selectExpr)
+	if s == "." || s == "text()" || s == "string(.)" {
+		return "", "", true
+	}
+	inner, ok := strings.CutPrefix(s, "concat(")
+	if !ok || !strings.HasSuffix(inner, ")") {
+		return "", "", false
+	}
+	inner = strings.TrimSuffix(inner, ")")
+
+	var prefix, suffix strings.Builder
+	seenValue := false
+	for inner = strings.TrimSpace(inner); inner != ""; {
+		var arg string
+		switch quote := inner[0]; quote {
+		case '\'', '"':
+			end := strings.IndexByte(inner[1:], quote)
+			if end < 0 {
+				return "", "", false
+			}
+			arg, inner = inner[1:end+1], inner[end+2:]
+			if seenValue {
+				suffix.WriteString(arg)
+			} else {
+				prefix.WriteString(arg)
+			}
+		case '.':
+			if seenValue {
+				return "", "", false
+			}
+			seenValue, inner = true, inner[1:]
+		default:
+			return "", "", false
+		}
+		inner = strings.TrimSpace(inner)
+		if inner == "" {
+			break
+		}
+		if inner[0] != ',' {
+			return "", "", false
+		}
+		inner = strings.TrimSpace(inner[1:])
+	}
+	return prefix.String(), suffix.String(), seenValue
+}
+
+// valueRule は、要素 target の値の前後に文字列を付ける値置換ルールを加えます。
+func (c *xsltConverter) valueRule(target, prefix, suffix string) {
+	rule := ConfigValueRule{Target: target}
+	switch {
+	case prefix == "" && suffix == "":
+		return
+	case suffix == "":
+		rule.Type, rule.Params = "prepend", map[string]interface{}{"prefix": prefix}
+	case prefix == "":
+		rule.Type, rule.Params = "append", map[string]interface{}{"suffix": suffix}
+	default:
+		rule.Type = scriptValueType
+		rule.Params = map[string]interface{}{"expr": strconv.Quote(prefix) + " + value + " + strconv.Quote(suffix)}
+	}
+	c.config.ValueRules = append(c.config.ValueRules, rule)
+}
+
+// literals は、リテラル要素とテキストの並びを挿入ルールのテンプレートの文字列に変換します。
+func (c *xsltConverter) literals(nodes []*xsltNode) (string, bool) {
+	var b strings.Builder
+	for _, n := range nodes {
+		if !c.writeLiteral(&b, n) {
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+// writeLiteral は、リテラル要素またはテキストを XML として b に書き込みます。
+func (c *xsltConverter) writeLiteral(b *strings.Builder, n *xsltNode) bool {
+	switch {
+	case n.text != nil || n.isXSL("text"):
+		xml.EscapeText(b, []byte(nodeText(n)))
+		return true
+	case !n.isLiteral():
+		c.issue(n, "'%s' in inserted content is not supported", n.qualifiedName())
+		return false
+	case n.name.Space != "":
+		c.issue(n, "inserted element '%s' with a namespace is not supported", n.name.Local)
+		return false
+	}
+
+	b.WriteString("<" + n.name.Local)
+	for _, a := range n.attrs {
+		if a.Name.Space != "" || a.Name.Local == "xmlns" {
+			continue
+		}
+		if strings.Contains(a.Value, "{") {
+			c.issue(n, "attribute value template '%s' is not supported", a.Value)
+			return false
+		}
+		b.WriteString(" " + a.Name.Local + `="`)
+		xml.EscapeText(b, []byte(a.Value))
+		b.WriteString(`"`)
+	}
+	if len(n.children) == 0 {
+		b.WriteString("/>")
+		return true
+	}
+	b.WriteString(">")
+	for _, child := range n.children {
+		if !c.writeLiteral(b, child) {
+			return false
+		}
+	}
+	b.WriteString("</" + n.name.Local + ">")
+	return true
+}
@@ -0,0 +1,139 @@
+package obufuku
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// identityTemplate は、要素と属性をそのまま複製する恒等変換のテンプレートです。
+const identityTemplate = `<xsl:template match="@*|node()"><xsl:copy><xsl:apply-templates select="@*|node()"/></xsl:copy></xsl:template>`
+
+// stylesheet は、templates を恒等変換のテンプレートとともに含むスタイルシートを返します。
+func stylesheet(templates string) string {
+	return `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">` +
+		identityTemplate + templates + `</xsl:stylesheet>`
+}
+
+func TestImportXSLT(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates string
+		want      Config
+	}{
+		{
+			name:      "identity only",
+			templates: "",
+			want:      Config{},
+		},
+		{
+			name:      "rename",
+			templates: `<xsl:template match="old"><new><xsl:apply-templates select="@*|node()"/></new></xsl:template>`,
+			want:      Config{NameRules: []ConfigNameRule{{Old: "old", New: "new"}}},
+		},
+		{
+			name:      "rename with xsl:element",
+			templates: `<xsl:template match="old"><xsl:element name="new"><xsl:apply-templates select="@*|node()"/></xsl:element></xsl:template>`,
+			want:      Config{NameRules: []ConfigNameRule{{Old: "old", New: "new"}}},
+		},
+		{
+			name:      "insert before and after",
+			templates: `<xsl:template match="b"><x a="1"/><xsl:copy><xsl:apply-templates select="@*|node()"/></xsl:copy><y/></xsl:template>`,
+			want: Config{
+				InsertRules:      []ConfigInsertRule{{Target: "b", Template: `<x a="1"/>`}},
+				InsertAfterRules: []ConfigInsertRule{{Target: "b", Template: `<y/>`}},
+			},
+		},
+		{
+			name:      "prepend child",
+			templates: `<xsl:template match="b"><xsl:copy><xsl:apply-templates select="@*"/><first/><xsl:apply-templates select="node()"/></xsl:copy></xsl:template>`,
+			want:      Config{PrependChildRules: []ConfigInsertRule{{Target: "b", Template: `<first/>`}}},
+		},
+		{
+			name:      "wrap children",
+			templates: `<xsl:template match="b"><xsl:copy><xsl:apply-templates select="@*"/><w><xsl:apply-templates select="node()"/></w></xsl:copy></xsl:template>`,
+			want:      Config{WrapRules: []ConfigWrapRule{{Target: "b", Wrapper: "w"}}},
+		},
+		{
+			name:      "value prefix and suffix",
+			templates: `<xsl:template match="id"><xsl:copy><xsl:apply-templates select="@*"/>ID-<xsl:value-of select="."/>!</xsl:copy></xsl:template>`,
+			want:      Config{ValueRules: []ConfigValueRule{{Target: "id", Type: "script", Params: params{"expr": `"ID-" + value + "!"`}}}},
+		},
+		{
+			name:      "value with concat on text()",
+			templates: `<xsl:template match="id/text()"><xsl:value-of select="concat('No.', .)"/></xsl:template>`,
+			want:      Config{ValueRules: []ConfigValueRule{{Target: "id", Type: "prepend", Params: params{"prefix": "No."}}}},
+		},
+		{
+			name: "value rule targets the renamed element",
+			templates: `<xsl:template match="old"><new><xsl:apply-templates select="@*|node()"/></new></xsl:template>` +
+				`<xsl:template match="old/text()"><xsl:value-of select="concat(., '円')"/></xsl:template>`,
+			want: Config{
+				NameRules:  []ConfigNameRule{{Old: "old", New: "new"}},
+				ValueRules: []ConfigValueRule{{Target: "new", Type: "append", Params: params{"suffix": "円"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, issues, err := ImportXSLT(strings.NewReader(stylesheet(tt.templates)))
+			if err != nil {
+				t.Fatalf("ImportXSLT: %v", err)
+			}
+			if len(issues) > 0 {
+				t.Errorf("issues = %v, want none", issues)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ImportXSLT = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportXSLTIssues(t *testing.T) {
+	tests := []struct {
+		name       string
+		stylesheet string
+		want       string
+	}{
+		{"no identity template", `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform"/>`, "line 1: the stylesheet has no identity template"},
+		{"named template", stylesheet(`<xsl:template name="t"/>`), "named template 't' is not supported"},
+		{"mode", stylesheet(`<xsl:template match="a" mode="m"><xsl:copy/></xsl:template>`), "template mode 'm' is not supported"},
+		{"path pattern", stylesheet(`<xsl:template match="a/b"><xsl:copy/></xsl:template>`), "match pattern 'a/b' is not supported"},
+		{"removing an element", stylesheet(`<xsl:template match="a"/>`), "removing element 'a' is not supported"},
+		{"top-level variable", stylesheet(`<xsl:variable name="v"/>`), "top-level element 'xsl:variable' is not supported"},
+		{"conditional", stylesheet(`<xsl:template match="a"><xsl:copy><xsl:if test="@x"><b/></xsl:if><xsl:apply-templates select="@*|node()"/></xsl:copy></xsl:template>`), "'xsl:if' in the template for 'a' is not supported"},
+		{"attributes dropped", stylesheet(`<xsl:template match="a"><xsl:copy><xsl:apply-templates select="node()"/></xsl:copy></xsl:template>`), "attributes of 'a' are dropped by the stylesheet but kept by the rules"},
+		{"output method", stylesheet(`<xsl:output method="text"/>`), "output method 'text' is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, issues, err := ImportXSLT(strings.NewReader(tt.stylesheet))
+			if err != nil {
+				t.Fatalf("ImportXSLT: %v", err)
+			}
+			if len(issues) != 1 || !strings.Contains(issues[0].String(), tt.want) {
+				t.Errorf("issues = %v, want one issue containing %q", issues, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportXSLTErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		stylesheet string
+	}{
+		{"not a stylesheet", `<root/>`},
+		{"malformed", `<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">`},
+		{"invalid rules", stylesheet(`<xsl:template match="a"><b><xsl:apply-templates select="@*|node()"/></b></xsl:template>` +
+			`<xsl:template match="b"><c><xsl:apply-templates select="@*|node()"/></c></xsl:template>`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ImportXSLT(strings.NewReader(tt.stylesheet)); err == nil {
+				t.Error("ImportXSLT succeeded, want an error")
+			}
+		})
+	}
+}
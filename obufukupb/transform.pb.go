@@ -0,0 +1,971 @@
+// obufuku の変換処理とルールセットの管理を gRPC で提供するサービスの定義です。
+//
+// コードの生成:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative obufukupb/transform.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: obufukupb/transform.proto
+
+package obufukupb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// TransformRequest は、Transform のリクエストです。
+type TransformRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*TransformRequest_Rules
+	//	*TransformRequest_Chunk
+	Payload       isTransformRequest_Payload `protobuf_oneof:"payload"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransformRequest) Reset() {
+	*x = TransformRequest{}
+	mi := &file_obufukupb_transform_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransformRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransformRequest) ProtoMessage() {}
+
+func (x *TransformRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransformRequest.ProtoReflect.Descriptor instead.
+func (*TransformRequest) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TransformRequest) GetPayload() isTransformRequest_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *TransformRequest) GetRules() *RulesReference {
+	if x != nil {
+		if x, ok := x.Payload.(*TransformRequest_Rules); ok {
+			return x.Rules
+		}
+	}
+	return nil
+}
+
+func (x *TransformRequest) GetChunk() []byte {
+	if x != nil {
+		if x, ok := x.Payload.(*TransformRequest_Chunk); ok {
+			return x.Chunk
+		}
+	}
+	return nil
+}
+
+type isTransformRequest_Payload interface {
+	isTransformRequest_Payload()
+}
+
+type TransformRequest_Rules struct {
+	// rules は、変換に使うルールセットです (最初のリクエストのみ)。
+	Rules *RulesReference `protobuf:"bytes,1,opt,name=rules,proto3,oneof"`
+}
+
+type TransformRequest_Chunk struct {
+	// chunk は、入力のXMLの一部です。
+	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+}
+
+func (*TransformRequest_Rules) isTransformRequest_Payload() {}
+
+func (*TransformRequest_Chunk) isTransformRequest_Payload() {}
+
+// RulesReference は、変換に使うルールセットの指定です。
+type RulesReference struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Source:
+	//
+	//	*RulesReference_Name
+	//	*RulesReference_ConfigJson
+	Source isRulesReference_Source `protobuf_oneof:"source"`
+	// version は、name で参照するルールセットのバージョンです (0 の場合は有効化されたバージョン)。
+	Version       int64 `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RulesReference) Reset() {
+	*x = RulesReference{}
+	mi := &file_obufukupb_transform_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RulesReference) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RulesReference) ProtoMessage() {}
+
+func (x *RulesReference) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RulesReference.ProtoReflect.Descriptor instead.
+func (*RulesReference) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RulesReference) GetSource() isRulesReference_Source {
+	if x != nil {
+		return x.Source
+	}
+	return nil
+}
+
+func (x *RulesReference) GetName() string {
+	if x != nil {
+		if x, ok := x.Source.(*RulesReference_Name); ok {
+			return x.Name
+		}
+	}
+	return ""
+}
+
+func (x *RulesReference) GetConfigJson() []byte {
+	if x != nil {
+		if x, ok := x.Source.(*RulesReference_ConfigJson); ok {
+			return x.ConfigJson
+		}
+	}
+	return nil
+}
+
+func (x *RulesReference) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+type isRulesReference_Source interface {
+	isRulesReference_Source()
+}
+
+type RulesReference_Name struct {
+	// name は、サーバーのルールディレクトリにあるルールファイルの名前 (拡張子 .json を除く) です。
+	Name string `protobuf:"bytes,1,opt,name=name,proto3,oneof"`
+}
+
+type RulesReference_ConfigJson struct {
+	// config_json は、ルールファイルと同じ形式のJSONです。
+	ConfigJson []byte `protobuf:"bytes,2,opt,name=config_json,json=configJson,proto3,oneof"`
+}
+
+func (*RulesReference_Name) isRulesReference_Source() {}
+
+func (*RulesReference_ConfigJson) isRulesReference_Source() {}
+
+// TransformResponse は、Transform のレスポンスです。
+type TransformResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*TransformResponse_Chunk
+	//	*TransformResponse_Summary
+	Payload       isTransformResponse_Payload `protobuf_oneof:"payload"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransformResponse) Reset() {
+	*x = TransformResponse{}
+	mi := &file_obufukupb_transform_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransformResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransformResponse) ProtoMessage() {}
+
+func (x *TransformResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransformResponse.ProtoReflect.Descriptor instead.
+func (*TransformResponse) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *TransformResponse) GetPayload() isTransformResponse_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *TransformResponse) GetChunk() []byte {
+	if x != nil {
+		if x, ok := x.Payload.(*TransformResponse_Chunk); ok {
+			return x.Chunk
+		}
+	}
+	return nil
+}
+
+func (x *TransformResponse) GetSummary() *TransformSummary {
+	if x != nil {
+		if x, ok := x.Payload.(*TransformResponse_Summary); ok {
+			return x.Summary
+		}
+	}
+	return nil
+}
+
+type isTransformResponse_Payload interface {
+	isTransformResponse_Payload()
+}
+
+type TransformResponse_Chunk struct {
+	// chunk は、変換結果のXMLの一部です。
+	Chunk []byte `protobuf:"bytes,1,opt,name=chunk,proto3,oneof"`
+}
+
+type TransformResponse_Summary struct {
+	// summary は、変換処理の結果の概要です (最後のレスポンスのみ)。
+	Summary *TransformSummary `protobuf:"bytes,2,opt,name=summary,proto3,oneof"`
+}
+
+func (*TransformResponse_Chunk) isTransformResponse_Payload() {}
+
+func (*TransformResponse_Summary) isTransformResponse_Payload() {}
+
+// TransformSummary は、変換処理の結果の概要です。
+type TransformSummary struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// elements は、入力から読み込んだ要素の数です。
+	Elements int64 `protobuf:"varint,1,opt,name=elements,proto3" json:"elements,omitempty"`
+	// rule_hits は、ルールファイルでの位置ごとの、ルールが適用された回数です。
+	RuleHits map[string]int64 `protobuf:"bytes,2,rep,name=rule_hits,json=ruleHits,proto3" json:"rule_hits,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	// warnings は、処理を続けられる問題についての警告です。
+	Warnings []string `protobuf:"bytes,3,rep,name=warnings,proto3" json:"warnings,omitempty"`
+	// bytes_read は、入力から読み込んだバイト数です。
+	BytesRead int64 `protobuf:"varint,4,opt,name=bytes_read,json=bytesRead,proto3" json:"bytes_read,omitempty"`
+	// bytes_written は、出力に書き込んだバイト数です。
+	BytesWritten  int64 `protobuf:"varint,5,opt,name=bytes_written,json=bytesWritten,proto3" json:"bytes_written,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransformSummary) Reset() {
+	*x = TransformSummary{}
+	mi := &file_obufukupb_transform_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransformSummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransformSummary) ProtoMessage() {}
+
+func (x *TransformSummary) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransformSummary.ProtoReflect.Descriptor instead.
+func (*TransformSummary) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *TransformSummary) GetElements() int64 {
+	if x != nil {
+		return x.Elements
+	}
+	return 0
+}
+
+func (x *TransformSummary) GetRuleHits() map[string]int64 {
+	if x != nil {
+		return x.RuleHits
+	}
+	return nil
+}
+
+func (x *TransformSummary) GetWarnings() []string {
+	if x != nil {
+		return x.Warnings
+	}
+	return nil
+}
+
+func (x *TransformSummary) GetBytesRead() int64 {
+	if x != nil {
+		return x.BytesRead
+	}
+	return 0
+}
+
+func (x *TransformSummary) GetBytesWritten() int64 {
+	if x != nil {
+		return x.BytesWritten
+	}
+	return 0
+}
+
+// UploadRulesRequest は、UploadRules のリクエストです。
+type UploadRulesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// name は、ルールセットの名前です (英数字、'_'、'-'、'.')。
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// config_json は、ルールファイルと同じ形式のJSONです。登録前に検証されます。
+	ConfigJson []byte `protobuf:"bytes,2,opt,name=config_json,json=configJson,proto3" json:"config_json,omitempty"`
+	// activate が true の場合、登録したバージョンをすぐに有効化します。
+	Activate      bool `protobuf:"varint,3,opt,name=activate,proto3" json:"activate,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadRulesRequest) Reset() {
+	*x = UploadRulesRequest{}
+	mi := &file_obufukupb_transform_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadRulesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadRulesRequest) ProtoMessage() {}
+
+func (x *UploadRulesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadRulesRequest.ProtoReflect.Descriptor instead.
+func (*UploadRulesRequest) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UploadRulesRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *UploadRulesRequest) GetConfigJson() []byte {
+	if x != nil {
+		return x.ConfigJson
+	}
+	return nil
+}
+
+func (x *UploadRulesRequest) GetActivate() bool {
+	if x != nil {
+		return x.Activate
+	}
+	return false
+}
+
+// RuleSetVersion は、ルールセットの1つのバージョンです。
+type RuleSetVersion struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// version は、1から始まるバージョン番号です。
+	Version int64 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// uploaded_at は、バージョンを登録した日時です。
+	UploadedAt *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=uploaded_at,json=uploadedAt,proto3" json:"uploaded_at,omitempty"`
+	// sha256 は、ルールの内容のSHA-256ハッシュ (16進数) です。
+	Sha256        string `protobuf:"bytes,3,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RuleSetVersion) Reset() {
+	*x = RuleSetVersion{}
+	mi := &file_obufukupb_transform_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RuleSetVersion) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RuleSetVersion) ProtoMessage() {}
+
+func (x *RuleSetVersion) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RuleSetVersion.ProtoReflect.Descriptor instead.
+func (*RuleSetVersion) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RuleSetVersion) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *RuleSetVersion) GetUploadedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UploadedAt
+	}
+	return nil
+}
+
+func (x *RuleSetVersion) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+// RuleSetInfo は、ルールセットとそのバージョンの一覧です。
+type RuleSetInfo struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// active_version は、有効化されたバージョンです (0 の場合は無し、またはバージョン管理外のルールファイル)。
+	ActiveVersion int64             `protobuf:"varint,2,opt,name=active_version,json=activeVersion,proto3" json:"active_version,omitempty"`
+	Versions      []*RuleSetVersion `protobuf:"bytes,3,rep,name=versions,proto3" json:"versions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RuleSetInfo) Reset() {
+	*x = RuleSetInfo{}
+	mi := &file_obufukupb_transform_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RuleSetInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RuleSetInfo) ProtoMessage() {}
+
+func (x *RuleSetInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RuleSetInfo.ProtoReflect.Descriptor instead.
+func (*RuleSetInfo) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RuleSetInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *RuleSetInfo) GetActiveVersion() int64 {
+	if x != nil {
+		return x.ActiveVersion
+	}
+	return 0
+}
+
+func (x *RuleSetInfo) GetVersions() []*RuleSetVersion {
+	if x != nil {
+		return x.Versions
+	}
+	return nil
+}
+
+// ListRulesRequest は、ListRules のリクエストです。
+type ListRulesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRulesRequest) Reset() {
+	*x = ListRulesRequest{}
+	mi := &file_obufukupb_transform_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRulesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRulesRequest) ProtoMessage() {}
+
+func (x *ListRulesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRulesRequest.ProtoReflect.Descriptor instead.
+func (*ListRulesRequest) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{7}
+}
+
+// ListRulesResponse は、ListRules のレスポンスです。
+type ListRulesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RuleSets      []*RuleSetInfo         `protobuf:"bytes,1,rep,name=rule_sets,json=ruleSets,proto3" json:"rule_sets,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRulesResponse) Reset() {
+	*x = ListRulesResponse{}
+	mi := &file_obufukupb_transform_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRulesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRulesResponse) ProtoMessage() {}
+
+func (x *ListRulesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRulesResponse.ProtoReflect.Descriptor instead.
+func (*ListRulesResponse) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListRulesResponse) GetRuleSets() []*RuleSetInfo {
+	if x != nil {
+		return x.RuleSets
+	}
+	return nil
+}
+
+// GetRulesRequest は、GetRules のリクエストです。
+type GetRulesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// version は、取得するバージョンです (0 の場合は有効化されたバージョン)。
+	Version       int64 `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRulesRequest) Reset() {
+	*x = GetRulesRequest{}
+	mi := &file_obufukupb_transform_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRulesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRulesRequest) ProtoMessage() {}
+
+func (x *GetRulesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRulesRequest.ProtoReflect.Descriptor instead.
+func (*GetRulesRequest) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *GetRulesRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *GetRulesRequest) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+// GetRulesResponse は、GetRules のレスポンスです。
+type GetRulesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Version       int64                  `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	ConfigJson    []byte                 `protobuf:"bytes,3,opt,name=config_json,json=configJson,proto3" json:"config_json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRulesResponse) Reset() {
+	*x = GetRulesResponse{}
+	mi := &file_obufukupb_transform_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRulesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRulesResponse) ProtoMessage() {}
+
+func (x *GetRulesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRulesResponse.ProtoReflect.Descriptor instead.
+func (*GetRulesResponse) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetRulesResponse) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *GetRulesResponse) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *GetRulesResponse) GetConfigJson() []byte {
+	if x != nil {
+		return x.ConfigJson
+	}
+	return nil
+}
+
+// ActivateRulesRequest は、ActivateRules のリクエストです。
+type ActivateRulesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Version       int64                  `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ActivateRulesRequest) Reset() {
+	*x = ActivateRulesRequest{}
+	mi := &file_obufukupb_transform_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ActivateRulesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ActivateRulesRequest) ProtoMessage() {}
+
+func (x *ActivateRulesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_obufukupb_transform_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ActivateRulesRequest.ProtoReflect.Descriptor instead.
+func (*ActivateRulesRequest) Descriptor() ([]byte, []int) {
+	return file_obufukupb_transform_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ActivateRulesRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ActivateRulesRequest) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+var File_obufukupb_transform_proto protoreflect.FileDescriptor
+
+const file_obufukupb_transform_proto_rawDesc = "" +
+	"\n" +
+	"\x19obufukupb/transform.proto\x12\n" +
+	"obufuku.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"i\n" +
+	"\x10TransformRequest\x122\n" +
+	"\x05rules\x18\x01 \x01(\v2\x1a.obufuku.v1.RulesReferenceH\x00R\x05rules\x12\x16\n" +
+	"\x05chunk\x18\x02 \x01(\fH\x00R\x05chunkB\t\n" +
+	"\apayload\"m\n" +
+	"\x0eRulesReference\x12\x14\n" +
+	"\x04name\x18\x01 \x01(\tH\x00R\x04name\x12!\n" +
+	"\vconfig_json\x18\x02 \x01(\fH\x00R\n" +
+	"configJson\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\x03R\aversionB\b\n" +
+	"\x06source\"p\n" +
+	"\x11TransformResponse\x12\x16\n" +
+	"\x05chunk\x18\x01 \x01(\fH\x00R\x05chunk\x128\n" +
+	"\asummary\x18\x02 \x01(\v2\x1c.obufuku.v1.TransformSummaryH\x00R\asummaryB\t\n" +
+	"\apayload\"\x94\x02\n" +
+	"\x10TransformSummary\x12\x1a\n" +
+	"\belements\x18\x01 \x01(\x03R\belements\x12G\n" +
+	"\trule_hits\x18\x02 \x03(\v2*.obufuku.v1.TransformSummary.RuleHitsEntryR\bruleHits\x12\x1a\n" +
+	"\bwarnings\x18\x03 \x03(\tR\bwarnings\x12\x1d\n" +
+	"\n" +
+	"bytes_read\x18\x04 \x01(\x03R\tbytesRead\x12#\n" +
+	"\rbytes_written\x18\x05 \x01(\x03R\fbytesWritten\x1a;\n" +
+	"\rRuleHitsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\"e\n" +
+	"\x12UploadRulesRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1f\n" +
+	"\vconfig_json\x18\x02 \x01(\fR\n" +
+	"configJson\x12\x1a\n" +
+	"\bactivate\x18\x03 \x01(\bR\bactivate\"\x7f\n" +
+	"\x0eRuleSetVersion\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\x03R\aversion\x12;\n" +
+	"\vuploaded_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"uploadedAt\x12\x16\n" +
+	"\x06sha256\x18\x03 \x01(\tR\x06sha256\"\x80\x01\n" +
+	"\vRuleSetInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12%\n" +
+	"\x0eactive_version\x18\x02 \x01(\x03R\ractiveVersion\x126\n" +
+	"\bversions\x18\x03 \x03(\v2\x1a.obufuku.v1.RuleSetVersionR\bversions\"\x12\n" +
+	"\x10ListRulesRequest\"I\n" +
+	"\x11ListRulesResponse\x124\n" +
+	"\trule_sets\x18\x01 \x03(\v2\x17.obufuku.v1.RuleSetInfoR\bruleSets\"?\n" +
+	"\x0fGetRulesRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x03R\aversion\"a\n" +
+	"\x10GetRulesResponse\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x03R\aversion\x12\x1f\n" +
+	"\vconfig_json\x18\x03 \x01(\fR\n" +
+	"configJson\"D\n" +
+	"\x14ActivateRulesRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x03R\aversion2`\n" +
+	"\x10TransformService\x12L\n" +
+	"\tTransform\x12\x1c.obufuku.v1.TransformRequest\x1a\x1d.obufuku.v1.TransformResponse(\x010\x012\xb5\x02\n" +
+	"\vRuleService\x12I\n" +
+	"\vUploadRules\x12\x1e.obufuku.v1.UploadRulesRequest\x1a\x1a.obufuku.v1.RuleSetVersion\x12H\n" +
+	"\tListRules\x12\x1c.obufuku.v1.ListRulesRequest\x1a\x1d.obufuku.v1.ListRulesResponse\x12E\n" +
+	"\bGetRules\x12\x1b.obufuku.v1.GetRulesRequest\x1a\x1c.obufuku.v1.GetRulesResponse\x12J\n" +
+	"\rActivateRules\x12 .obufuku.v1.ActivateRulesRequest\x1a\x17.obufuku.v1.RuleSetInfoB*Z(github.com/hizuheka/go-ObuFuku/obufukupbb\x06proto3"
+
+var (
+	file_obufukupb_transform_proto_rawDescOnce sync.Once
+	file_obufukupb_transform_proto_rawDescData []byte
+)
+
+func file_obufukupb_transform_proto_rawDescGZIP() []byte {
+	file_obufukupb_transform_proto_rawDescOnce.Do(func() {
+		file_obufukupb_transform_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_obufukupb_transform_proto_rawDesc), len(file_obufukupb_transform_proto_rawDesc)))
+	})
+	return file_obufukupb_transform_proto_rawDescData
+}
+
+var file_obufukupb_transform_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_obufukupb_transform_proto_goTypes = []any{
+	(*TransformRequest)(nil),      // 0: obufuku.v1.TransformRequest
+	(*RulesReference)(nil),        // 1: obufuku.v1.RulesReference
+	(*TransformResponse)(nil),     // 2: obufuku.v1.TransformResponse
+	(*TransformSummary)(nil),      // 3: obufuku.v1.TransformSummary
+	(*UploadRulesRequest)(nil),    // 4: obufuku.v1.UploadRulesRequest
+	(*RuleSetVersion)(nil),        // 5: obufuku.v1.RuleSetVersion
+	(*RuleSetInfo)(nil),           // 6: obufuku.v1.RuleSetInfo
+	(*ListRulesRequest)(nil),      // 7: obufuku.v1.ListRulesRequest
+	(*ListRulesResponse)(nil),     // 8: obufuku.v1.ListRulesResponse
+	(*GetRulesRequest)(nil),       // 9: obufuku.v1.GetRulesRequest
+	(*GetRulesResponse)(nil),      // 10: obufuku.v1.GetRulesResponse
+	(*ActivateRulesRequest)(nil),  // 11: obufuku.v1.ActivateRulesRequest
+	nil,                           // 12: obufuku.v1.TransformSummary.RuleHitsEntry
+	(*timestamppb.Timestamp)(nil), // 13: google.protobuf.Timestamp
+}
+var file_obufukupb_transform_proto_depIdxs = []int32{
+	1,  // 0: obufuku.v1.TransformRequest.rules:type_name -> obufuku.v1.RulesReference
+	3,  // 1: obufuku.v1.TransformResponse.summary:type_name -> obufuku.v1.TransformSummary
+	12, // 2: obufuku.v1.TransformSummary.rule_hits:type_name -> obufuku.v1.TransformSummary.RuleHitsEntry
+	13, // 3: obufuku.v1.RuleSetVersion.uploaded_at:type_name -> google.protobuf.Timestamp
+	5,  // 4: obufuku.v1.RuleSetInfo.versions:type_name -> obufuku.v1.RuleSetVersion
+	6,  // 5: obufuku.v1.ListRulesResponse.rule_sets:type_name -> obufuku.v1.RuleSetInfo
+	0,  // 6: obufuku.v1.TransformService.Transform:input_type -> obufuku.v1.TransformRequest
+	4,  // 7: obufuku.v1.RuleService.UploadRules:input_type -> obufuku.v1.UploadRulesRequest
+	7,  // 8: obufuku.v1.RuleService.ListRules:input_type -> obufuku.v1.ListRulesRequest
+	9,  // 9: obufuku.v1.RuleService.GetRules:input_type -> obufuku.v1.GetRulesRequest
+	11, // 10: obufuku.v1.RuleService.ActivateRules:input_type -> obufuku.v1.ActivateRulesRequest
+	2,  // 11: obufuku.v1.TransformService.Transform:output_type -> obufuku.v1.TransformResponse
+	5,  // 12: obufuku.v1.RuleService.UploadRules:output_type -> obufuku.v1.RuleSetVersion
+	8,  // 13: obufuku.v1.RuleService.ListRules:output_type -> obufuku.v1.ListRulesResponse
+	10, // 14: obufuku.v1.RuleService.GetRules:output_type -> obufuku.v1.GetRulesResponse
+	6,  // 15: obufuku.v1.RuleService.ActivateRules:output_type -> obufuku.v1.RuleSetInfo
+	11, // [11:16] is the sub-list for method output_type
+	6,  // [6:11] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_obufukupb_transform_proto_init() }
+func file_obufukupb_transform_proto_init() {
+	if File_obufukupb_transform_proto != nil {
+		return
+	}
+	file_obufukupb_transform_proto_msgTypes[0].OneofWrappers = []any{
+		(*TransformRequest_Rules)(nil),
+		(*TransformRequest_Chunk)(nil),
+	}
+	file_obufukupb_transform_proto_msgTypes[1].OneofWrappers = []any{
+		(*RulesReference_Name)(nil),
+		(*RulesReference_ConfigJson)(nil),
+	}
+	file_obufukupb_transform_proto_msgTypes[2].OneofWrappers = []any{
+		(*TransformResponse_Chunk)(nil),
+		(*TransformResponse_Summary)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_obufukupb_transform_proto_rawDesc), len(file_obufukupb_transform_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_obufukupb_transform_proto_goTypes,
+		DependencyIndexes: file_obufukupb_transform_proto_depIdxs,
+		MessageInfos:      file_obufukupb_transform_proto_msgTypes,
+	}.Build()
+	File_obufukupb_transform_proto = out.File
+	file_obufukupb_transform_proto_goTypes = nil
+	file_obufukupb_transform_proto_depIdxs = nil
+}
@@ -0,0 +1,362 @@
+// obufuku の変換処理とルールセットの管理を gRPC で提供するサービスの定義です。
+//
+// コードの生成:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative obufukupb/transform.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.1
+// - protoc             (unknown)
+// source: obufukupb/transform.proto
+
+package obufukupb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TransformService_Transform_FullMethodName = "/obufuku.v1.TransformService/Transform"
+)
+
+// TransformServiceClient is the client API for TransformService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TransformService は、XMLをルールに基づいて変換するサービスです。
+type TransformServiceClient interface {
+	// Transform は、クライアントから分割して送られるXMLを変換し、結果を分割して返します。
+	// 最初のリクエストで rules を、以降のリクエストで chunk を送ります。
+	// レスポンスは変換結果の chunk が続き、最後に summary が1つ返ります。
+	Transform(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TransformRequest, TransformResponse], error)
+}
+
+type transformServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTransformServiceClient(cc grpc.ClientConnInterface) TransformServiceClient {
+	return &transformServiceClient{cc}
+}
+
+func (c *transformServiceClient) Transform(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TransformRequest, TransformResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TransformService_ServiceDesc.Streams[0], TransformService_Transform_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TransformRequest, TransformResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TransformService_TransformClient = grpc.BidiStreamingClient[TransformRequest, TransformResponse]
+
+// TransformServiceServer is the server API for TransformService service.
+// All implementations must embed UnimplementedTransformServiceServer
+// for forward compatibility.
+//
+// TransformService は、XMLをルールに基づいて変換するサービスです。
+type TransformServiceServer interface {
+	// Transform は、クライアントから分割して送られるXMLを変換し、結果を分割して返します。
+	// 最初のリクエストで rules を、以降のリクエストで chunk を送ります。
+	// レスポンスは変換結果の chunk が続き、最後に summary が1つ返ります。
+	Transform(grpc.BidiStreamingServer[TransformRequest, TransformResponse]) error
+	mustEmbedUnimplementedTransformServiceServer()
+}
+
+// UnimplementedTransformServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTransformServiceServer struct{}
+
+func (UnimplementedTransformServiceServer) Transform(grpc.BidiStreamingServer[TransformRequest, TransformResponse]) error {
+	return status.Error(codes.Unimplemented, "method Transform not implemented")
+}
+func (UnimplementedTransformServiceServer) mustEmbedUnimplementedTransformServiceServer() {}
+func (UnimplementedTransformServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeTransformServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TransformServiceServer will
+// result in compilation errors.
+type UnsafeTransformServiceServer interface {
+	mustEmbedUnimplementedTransformServiceServer()
+}
+
+func RegisterTransformServiceServer(s grpc.ServiceRegistrar, srv TransformServiceServer) {
+	// If the following call panics, it indicates UnimplementedTransformServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TransformService_ServiceDesc, srv)
+}
+
+func _TransformService_Transform_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TransformServiceServer).Transform(&grpc.GenericServerStream[TransformRequest, TransformResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TransformService_TransformServer = grpc.BidiStreamingServer[TransformRequest, TransformResponse]
+
+// TransformService_ServiceDesc is the grpc.ServiceDesc for TransformService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TransformService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "obufuku.v1.TransformService",
+	HandlerType: (*TransformServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Transform",
+			Handler:       _TransformService_Transform_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "obufukupb/transform.proto",
+}
+
+const (
+	RuleService_UploadRules_FullMethodName   = "/obufuku.v1.RuleService/UploadRules"
+	RuleService_ListRules_FullMethodName     = "/obufuku.v1.RuleService/ListRules"
+	RuleService_GetRules_FullMethodName      = "/obufuku.v1.RuleService/GetRules"
+	RuleService_ActivateRules_FullMethodName = "/obufuku.v1.RuleService/ActivateRules"
+)
+
+// RuleServiceClient is the client API for RuleService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// RuleService は、サーバーが変換に使うルールセットを実行中に管理するサービスです。
+// ルールセットは名前ごとにバージョンを持ち、Transform が名前で参照するのは有効化されたバージョンです。
+type RuleServiceClient interface {
+	// UploadRules は、ルールセットの新しいバージョンを登録します。
+	UploadRules(ctx context.Context, in *UploadRulesRequest, opts ...grpc.CallOption) (*RuleSetVersion, error)
+	// ListRules は、登録されているルールセットとそのバージョンを返します。
+	ListRules(ctx context.Context, in *ListRulesRequest, opts ...grpc.CallOption) (*ListRulesResponse, error)
+	// GetRules は、ルールセットのバージョンの内容を返します。
+	GetRules(ctx context.Context, in *GetRulesRequest, opts ...grpc.CallOption) (*GetRulesResponse, error)
+	// ActivateRules は、ルールセットのバージョンを有効化します。
+	ActivateRules(ctx context.Context, in *ActivateRulesRequest, opts ...grpc.CallOption) (*RuleSetInfo, error)
+}
+
+type ruleServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRuleServiceClient(cc grpc.ClientConnInterface) RuleServiceClient {
+	return &ruleServiceClient{cc}
+}
+
+func (c *ruleServiceClient) UploadRules(ctx context.Context, in *UploadRulesRequest, opts ...grpc.CallOption) (*RuleSetVersion, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RuleSetVersion)
+	err := c.cc.Invoke(ctx, RuleService_UploadRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ruleServiceClient) ListRules(ctx context.Context, in *ListRulesRequest, opts ...grpc.CallOption) (*ListRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListRulesResponse)
+	err := c.cc.Invoke(ctx, RuleService_ListRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ruleServiceClient) GetRules(ctx context.Context, in *GetRulesRequest, opts ...grpc.CallOption) (*GetRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetRulesResponse)
+	err := c.cc.Invoke(ctx, RuleService_GetRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ruleServiceClient) ActivateRules(ctx context.Context, in *ActivateRulesRequest, opts ...grpc.CallOption) (*RuleSetInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RuleSetInfo)
+	err := c.cc.Invoke(ctx, RuleService_ActivateRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RuleServiceServer is the server API for RuleService service.
+// All implementations must embed UnimplementedRuleServiceServer
+// for forward compatibility.
+//
+// RuleService は、サーバーが変換に使うルールセットを実行中に管理するサービスです。
+// ルールセットは名前ごとにバージョンを持ち、Transform が名前で参照するのは有効化されたバージョンです。
+type RuleServiceServer interface {
+	// UploadRules は、ルールセットの新しいバージョンを登録します。
+	UploadRules(context.Context, *UploadRulesRequest) (*RuleSetVersion, error)
+	// ListRules は、登録されているルールセットとそのバージョンを返します。
+	ListRules(context.Context, *ListRulesRequest) (*ListRulesResponse, error)
+	// GetRules は、ルールセットのバージョンの内容を返します。
+	GetRules(context.Context, *GetRulesRequest) (*GetRulesResponse, error)
+	// ActivateRules は、ルールセットのバージョンを有効化します。
+	ActivateRules(context.Context, *ActivateRulesRequest) (*RuleSetInfo, error)
+	mustEmbedUnimplementedRuleServiceServer()
+}
+
+// UnimplementedRuleServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRuleServiceServer struct{}
+
+func (UnimplementedRuleServiceServer) UploadRules(context.Context, *UploadRulesRequest) (*RuleSetVersion, error) {
+	return nil, status.Error(codes.Unimplemented, "method UploadRules not implemented")
+}
+func (UnimplementedRuleServiceServer) ListRules(context.Context, *ListRulesRequest) (*ListRulesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListRules not implemented")
+}
+func (UnimplementedRuleServiceServer) GetRules(context.Context, *GetRulesRequest) (*GetRulesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetRules not implemented")
+}
+func (UnimplementedRuleServiceServer) ActivateRules(context.Context, *ActivateRulesRequest) (*RuleSetInfo, error) {
+	return nil, status.Error(codes.Unimplemented, "method ActivateRules not implemented")
+}
+func (UnimplementedRuleServiceServer) mustEmbedUnimplementedRuleServiceServer() {}
+func (UnimplementedRuleServiceServer) testEmbeddedByValue()                     {}
+
+// UnsafeRuleServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RuleServiceServer will
+// result in compilation errors.
+type UnsafeRuleServiceServer interface {
+	mustEmbedUnimplementedRuleServiceServer()
+}
+
+func RegisterRuleServiceServer(s grpc.ServiceRegistrar, srv RuleServiceServer) {
+	// If the following call panics, it indicates UnimplementedRuleServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&RuleService_ServiceDesc, srv)
+}
+
+func _RuleService_UploadRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuleServiceServer).UploadRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RuleService_UploadRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuleServiceServer).UploadRules(ctx, req.(*UploadRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RuleService_ListRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuleServiceServer).ListRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RuleService_ListRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuleServiceServer).ListRules(ctx, req.(*ListRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RuleService_GetRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuleServiceServer).GetRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RuleService_GetRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuleServiceServer).GetRules(ctx, req.(*GetRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RuleService_ActivateRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ActivateRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuleServiceServer).ActivateRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RuleService_ActivateRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuleServiceServer).ActivateRules(ctx, req.(*ActivateRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RuleService_ServiceDesc is the grpc.ServiceDesc for RuleService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RuleService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "obufuku.v1.RuleService",
+	HandlerType: (*RuleServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "UploadRules",
+			Handler:    _RuleService_UploadRules_Handler,
+		},
+		{
+			MethodName: "ListRules",
+			Handler:    _RuleService_ListRules_Handler,
+		},
+		{
+			MethodName: "GetRules",
+			Handler:    _RuleService_GetRules_Handler,
+		},
+		{
+			MethodName: "ActivateRules",
+			Handler:    _RuleService_ActivateRules_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "obufukupb/transform.proto",
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputFile は、変換結果を書き込む出力ファイルです。
+// gzip 圧縮とチェックサムの計算を設定に応じて重ねたWriterとして振る舞います。
+// エンコーディングと改行コードの変換は、obufuku.RuleSet の Writer が行います。
+type outputFile struct {
+	io.Writer
+
+	path string
+	// file は、出力先のファイル、S3 へのアップロード (*s3Upload) または SFTP への書き込み (*sftpUpload) です。
+	file         io.WriteCloser
+	gzipWriter   *gzip.Writer
+	checksumHash hash.Hash
+	checksum     string
+	finished     bool
+
+	// spool は、--skip-unchanged で出力先を書き換えるかどうかが決まるまで出力を保持する一時ファイルです。
+	// spool に書き込んでいる間は file は nil で、Finish で opts に従って出力先に書き込みます。
+	spool *os.File
+	opts  transformOptions
+}
+
+// createOutput は、出力ファイルを作成し、設定に応じたWriterを重ねます。
+// s3://bucket/key 形式のURIの場合は S3 に、sftp://user@host/path 形式のURIの場合は
+// SFTP サーバーに転送しながら書き込みます。
+func createOutput(outputFilepath string, opts transformOptions) (*outputFile, error) {
+	if opts.SkipUnchanged {
+		return createSpooledOutput(outputFilepath, opts)
+	}
+	file, err := createOutputFile(outputFilepath, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := &outputFile{path: outputFilepath, file: file, checksum: opts.Checksum}
+
+	// チェックサムが指定されていれば、ファイルに書き込むバイト列からハッシュを計算する
+	var fileWriter io.Writer = file
+	if opts.Checksum != "" {
+		if out.checksumHash, err = newChecksumHash(opts.Checksum); err != nil {
+			out.Discard()
+			return nil, err
+		}
+		fileWriter = io.MultiWriter(file, out.checksumHash)
+	}
+
+	// 出力ファイル名が .gz で終わるか圧縮が指定されていれば、gzip で圧縮するwriterでラップ
+	isGzipPath := strings.EqualFold(filepath.Ext(outputFilepath), ".gz")
+	if opts.Compress || isGzipPath {
+		out.gzipWriter = gzip.NewWriter(fileWriter)
+		out.gzipWriter.Name = filepath.Base(outputFilepath)
+		if isGzipPath {
+			out.gzipWriter.Name = strings.TrimSuffix(out.gzipWriter.Name, filepath.Ext(out.gzipWriter.Name))
+		}
+		fileWriter = out.gzipWriter
+	}
+	out.Writer = limitOutput(fileWriter, outputFilepath, opts.MaxOutputSize)
+	return out, nil
+}
+
+// createSpooledOutput は、出力を一時ファイルに書き込み、Finish で初めて出力先を作成する出力ファイルを作成します。
+// Skip した場合は、出力先の既存のファイルを更新日時も含めてそのまま残します。
+func createSpooledOutput(outputFilepath string, opts transformOptions) (*outputFile, error) {
+	spool, err := os.CreateTemp("", "obufuku-output-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file for output '%s': %w", outputFilepath, err)
+	}
+	out := &outputFile{path: outputFilepath, spool: spool, opts: opts}
+	out.Writer = limitOutput(spool, outputFilepath, opts.MaxOutputSize)
+	return out, nil
+}
+
+// createOutputFile は、出力先のファイルを作成するか、S3 または SFTP への転送を開始します。
+func createOutputFile(outputFilepath string, opts transformOptions) (io.WriteCloser, error) {
+	if !isS3URI(outputFilepath) && !isSFTPURI(outputFilepath) {
+		file, err := os.Create(outputFilepath)
+		if err != nil {
+			return nil, fmt.Errorf("error creating output file '%s': %w", outputFilepath, err)
+		}
+		return file, nil
+	}
+	if opts.ValidateOutput != "" {
+		return nil, fmt.Errorf("--validate-output cannot be used with remote output '%s'", outputFilepath)
+	}
+	var file io.WriteCloser
+	var err error
+	if isS3URI(outputFilepath) {
+		file, err = newS3Upload(outputFilepath)
+	} else {
+		file, err = newSFTPUpload(outputFilepath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating output file '%s': %w", outputFilepath, err)
+	}
+	return file, nil
+}
+
+// Finish は、圧縮を確定させてファイルを閉じ、指定されていればチェックサムファイルを書き込みます。
+func (o *outputFile) Finish() error {
+	if o.spool != nil {
+		return o.finishSpool()
+	}
+	if o.gzipWriter != nil {
+		if err := o.gzipWriter.Close(); err != nil {
+			return fmt.Errorf("error compressing output file '%s': %w", o.path, err)
+		}
+	}
+	o.finished = true
+	if err := o.file.Close(); err != nil {
+		return fmt.Errorf("error closing output file '%s': %w", o.path, err)
+	}
+	if o.checksumHash != nil {
+		checksumPath, err := writeChecksumFile(o.path, o.checksum, o.checksumHash.Sum(nil))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Checksum (%s) written to '%s'\n", o.checksum, checksumPath)
+	}
+	return nil
+}
+
+// finishSpool は、一時ファイルに保持した出力を、opts に従って圧縮などをしながら出力先に書き込みます。
+func (o *outputFile) finishSpool() error {
+	o.finished = true
+	defer o.removeSpool()
+	if _, err := o.spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading temporary file for output '%s': %w", o.path, err)
+	}
+	opts := o.opts
+	opts.SkipUnchanged = false
+	out, err := createOutput(o.path, opts)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, o.spool); err != nil {
+		out.Discard()
+		return fmt.Errorf("error writing output file '%s': %w", o.path, err)
+	}
+	return out.Finish()
+}
+
+// Skip は、--skip-unchanged でルールが適用されなかった場合に、出力先に書き込まずに出力を破棄します。
+func (o *outputFile) Skip() {
+	o.finished = true
+	o.removeSpool()
+}
+
+// removeSpool は、出力を保持していた一時ファイルを閉じて削除します。
+func (o *outputFile) removeSpool() {
+	if o.spool == nil {
+		return
+	}
+	o.spool.Close()
+	os.Remove(o.spool.Name())
+}
+
+// Flush は、gzip 圧縮の途中のデータを出力ファイルに書き出します。
+func (o *outputFile) Flush() error {
+	if o.gzipWriter != nil {
+		return o.gzipWriter.Flush()
+	}
+	return nil
+}
+
+// Close は、Finish されずに処理が中断された場合に出力ファイルを閉じます。
+// S3 への出力の場合は、書きかけのオブジェクトを作成しないようアップロードを中止します。
+func (o *outputFile) Close() error {
+	if o.finished {
+		return nil
+	}
+	if o.spool != nil {
+		o.removeSpool()
+		return nil
+	}
+	if upload, ok := o.file.(*s3Upload); ok {
+		upload.Abort()
+		return nil
+	}
+	return o.file.Close()
+}
+
+// Discard は、処理が取り消された場合に、書きかけの出力ファイルを閉じて削除します。
+func (o *outputFile) Discard() {
+	if o.spool != nil {
+		// 出力先はまだ書き換えていない
+		o.finished = true
+		o.removeSpool()
+		return
+	}
+	if upload, ok := o.file.(interface{ Abort() }); ok {
+		upload.Abort()
+		o.finished = true
+		return
+	}
+	o.file.Close()
+	if err := os.Remove(o.path); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove incomplete output file '%s': %v\n", o.path, err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+func TestSkipUnchanged(t *testing.T) {
+	const previous = "previous output"
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		cfg     obufuku.Config
+		output  string
+		skipped bool
+		want    string
+	}{
+		{"no rule matched", obufuku.Config{NameRules: []obufuku.ConfigNameRule{{Old: "x", New: "y"}}}, "out.xml", true, previous},
+		{"rule matched", obufuku.Config{NameRules: []obufuku.ConfigNameRule{{Old: "b", New: "c"}}}, "out.xml", false, "<a><c></c></a>"},
+		{"compressed", obufuku.Config{NameRules: []obufuku.ConfigNameRule{{Old: "b", New: "c"}}}, "out.xml.gz", false, "<a><c></c></a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.cfg.Output = obufuku.ConfigOutput{Compact: true}
+			rulePath, inputPath := writeRules(t, dir, tt.cfg), writeFile(t, dir, "in.xml", "<a><b/></a>")
+			outputPath := writeFile(t, dir, tt.output, previous)
+			if err := os.Chtimes(outputPath, old, old); err != nil {
+				t.Fatal(err)
+			}
+			if err := runTransform(context.Background(), rulePath, inputPath, outputPath, transformOptions{SkipUnchanged: true}); err != nil {
+				t.Fatalf("runTransform: %v", err)
+			}
+			info, err := os.Stat(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if untouched := info.ModTime().Equal(old); untouched != tt.skipped {
+				t.Errorf("output left untouched = %v, want %v", untouched, tt.skipped)
+			}
+			data, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.skipped && filepath.Ext(tt.output) == ".gz" {
+				zr, err := gzip.NewReader(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("output is not gzip: %v", err)
+				}
+				if data, err = io.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(data) != tt.want {
+				t.Errorf("output = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipUnchangedNoOutput(t *testing.T) {
+	dir := t.TempDir()
+	rulePath, inputPath := writeRules(t, dir, obufuku.Config{}), writeFile(t, dir, "in.xml", "<a/>")
+	outputPath := filepath.Join(dir, "out.xml")
+	if err := runTransform(context.Background(), rulePath, inputPath, outputPath, transformOptions{SkipUnchanged: true}); err != nil {
+		t.Fatalf("runTransform: %v", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("a skipped output was created (stat: %v)", err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// patchContext は、パッチの各ハンクで変更の前後に含める変更されていない行の数です。
+const patchContext = 3
+
+// writePatch は、input から output への変更を unified diff の形式で patchPath に書き込みます。
+// oldName と newName は、パッチの見出しに書くファイル名です。差異が無い場合は空のファイルになります。
+func writePatch(patchPath, oldName, newName string, input, output []byte) error {
+	patch := unifiedDiff(oldName, input, newName, output)
+	if err := os.WriteFile(patchPath, patch, 0o644); err != nil {
+		return fmt.Errorf("error writing patch file '%s': %w", patchPath, err)
+	}
+	fmt.Printf("Patch written to '%s'\n", patchPath)
+	return nil
+}
+
+// diffPair は、変更前と変更後の行の位置の組です。
+type diffPair struct{ x, y int }
+
+// unifiedDiff は、old から new への変更を、変更前後それぞれ patchContext 行を含む unified diff にします。
+// old と new が同じ場合は nil を返します。
+//
+// 行の対応は、両方に1回ずつしか現れない行を目印にして求めます (patience diff と同様の方法)。
+// 最短の差分になるとは限りませんが、入力の大きさに対してほぼ線形の時間で求められます。
+func unifiedDiff(oldName string, old []byte, newName string, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+	x, y := diffLines(old), diffLines(new)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+	var (
+		done  diffPair // 出力済みの位置
+		chunk diffPair // 書きかけのハンクの開始位置
+		count diffPair // 書きかけのハンクの行数
+		text  []string // 書きかけのハンクの行
+	)
+	for _, m := range diffAnchors(x, y) {
+		if m.x < done.x {
+			// 前の目印から続く一致の中にある目印
+			continue
+		}
+		// 目印の前後に一致する行を広げる
+		start, end := m, m
+		for start.x > done.x && start.y > done.y && x[start.x-1] == y[start.y-1] {
+			start.x--
+			start.y--
+		}
+		for end.x < len(x) && end.y < len(y) && x[end.x] == y[end.y] {
+			end.x++
+			end.y++
+		}
+
+		// 前の一致からこの一致までを、削除と追加の行にする
+		for _, s := range x[done.x:start.x] {
+			text = append(text, "-"+s)
+			count.x++
+		}
+		for _, s := range y[done.y:start.y] {
+			text = append(text, "+"+s)
+			count.y++
+		}
+
+		// 一致が短く、前後のハンクの文脈が重なる場合は、1つのハンクにまとめる
+		if (end.x < len(x) || end.y < len(y)) && (end.x-start.x < patchContext || len(text) > 0 && end.x-start.x <= 2*patchContext) {
+			for _, s := range x[start.x:end.x] {
+				text = append(text, " "+s)
+				count.x++
+				count.y++
+			}
+			done = end
+			continue
+		}
+
+		// 書きかけのハンクに後ろの文脈を加えて書き出す
+		if len(text) > 0 {
+			n := end.x - start.x
+			if n > patchContext {
+				n = patchContext
+			}
+			for _, s := range x[start.x : start.x+n] {
+				text = append(text, " "+s)
+				count.x++
+				count.y++
+			}
+			done = diffPair{start.x + n, start.y + n}
+			// 行番号は1から数え、行が無い側はその直前の行の番号にする
+			if count.x > 0 {
+				chunk.x++
+			}
+			if count.y > 0 {
+				chunk.y++
+			}
+			fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", chunk.x, count.x, chunk.y, count.y)
+			for _, s := range text {
+				out.WriteString(s)
+			}
+			count = diffPair{}
+			text = text[:0]
+		}
+
+		if end.x >= len(x) && end.y >= len(y) {
+			break
+		}
+		// 次のハンクを、一致の末尾の文脈から始める
+		chunk = diffPair{end.x - patchContext, end.y - patchContext}
+		for _, s := range x[chunk.x:end.x] {
+			text = append(text, " "+s)
+			count.x++
+			count.y++
+		}
+		done = end
+	}
+	return out.Bytes()
+}
+
+// diffLines は、text を改行を含む行に分けます。末尾に改行が無い場合は、最後の行に diff と同じ注記を付けます。
+func diffLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n\\ No newline at end of file\n"
+	return lines
+}
+
+// diffAnchors は、x と y の両方にちょうど1回ずつ現れる行のうち、順序を保って対応させられる最も多くの組を、
+// 先頭 {0, 0} と末尾 {len(x), len(y)} の番兵とともに返します。
+func diffAnchors(x, y []string) []diffPair {
+	// 出現回数を数える: x の1回を -1、y の1回を -4 とし、両方に1回ずつの行が -5 になる
+	counts := make(map[string]int)
+	for _, s := range x {
+		if c := counts[s]; c > -2 {
+			counts[s] = c - 1
+		}
+	}
+	for _, s := range y {
+		if c := counts[s]; c > -8 {
+			counts[s] = c - 4
+		}
+	}
+
+	// 両方に1回ずつ現れる行の、y での番号 (yi の添字) を x の順に並べる
+	var xi, yi, order []int
+	for i, s := range y {
+		if counts[s] == -5 {
+			counts[s] = len(yi)
+			yi = append(yi, i)
+		}
+	}
+	for i, s := range x {
+		if j, ok := counts[s]; ok && j >= 0 {
+			xi = append(xi, i)
+			order = append(order, j)
+		}
+	}
+
+	// order の最長増加部分列を求める (patience sorting)
+	n := len(order)
+	tops := make([]int, 0, n) // 各山の一番上の値
+	length := make([]int, n)  // order[i] で終わる増加部分列の長さ
+	for i, v := range order {
+		k := sort.SearchInts(tops, v)
+		if k == len(tops) {
+			tops = append(tops, v)
+		} else {
+			tops[k] = v
+		}
+		length[i] = k + 1
+	}
+	k := len(tops)
+	anchors := make([]diffPair, k+2)
+	anchors[k+1] = diffPair{len(x), len(y)}
+	last := len(yi)
+	for i := n - 1; i >= 0 && k > 0; i-- {
+		if length[i] == k && order[i] < last {
+			anchors[k] = diffPair{xi[i], yi[order[i]]}
+			last = order[i]
+			k--
+		}
+	}
+	return anchors
+}
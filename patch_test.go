@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{
+			name: "no change",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "changed line",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			new:  "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "added lines at the start",
+			old:  "a\nb\n",
+			new:  "x\ny\na\nb\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,4 @@\n+x\n+y\n a\n b\n",
+		},
+		{
+			name: "removed last line",
+			old:  "a\nb\nc\n",
+			new:  "a\nb\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,2 @@\n a\n b\n-c\n",
+		},
+		{
+			name: "from empty",
+			old:  "",
+			new:  "a\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,1 @@\n+a\n",
+		},
+		{
+			name: "no newline at end of file",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			new:  "1\nB\n3\n4\n5\n6\n7\nH\n9\n10\n",
+			want: "--- old\n+++ new\n@@ -1,10 +1,10 @@\n 1\n-2\n+B\n 3\n 4\n 5\n 6\n 7\n-8\n+H\n 9\n 10\n",
+		},
+		{
+			name: "distant changes get separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "A\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\nL\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+A\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+L\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(unifiedDiff("old", []byte(tt.old), "new", []byte(tt.new)))
+			if got != tt.want {
+				t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// applyPatch は、unified diff の patch を old に適用した結果を返します。
+// ハンクの行数と、文脈・削除の行が old と一致しない場合はエラーを返します。
+func applyPatch(old, patch string) (string, error) {
+	oldLines := strings.SplitAfter(old, "\n")
+	lines := strings.SplitAfter(patch, "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "--- ") || !strings.HasPrefix(lines[1], "+++ ") {
+		return "", fmt.Errorf("missing header")
+	}
+	var out strings.Builder
+	cursor := 0
+	for i := 2; i < len(lines) && lines[i] != ""; {
+		var oldStart, oldCount, newStart, newCount int
+		if _, err := fmt.Sscanf(lines[i], "@@ -%d,%d +%d,%d @@\n", &oldStart, &oldCount, &newStart, &newCount); err != nil {
+			return "", fmt.Errorf("line %d: invalid hunk header %q", i+1, lines[i])
+		}
+		i++
+		if oldCount > 0 {
+			oldStart--
+		}
+		for ; cursor < oldStart; cursor++ {
+			out.WriteString(oldLines[cursor])
+		}
+		for oldCount > 0 || newCount > 0 {
+			if i >= len(lines) || lines[i] == "" {
+				return "", fmt.Errorf("hunk ends early")
+			}
+			op, text := lines[i][0], lines[i][1:]
+			i++
+			if i < len(lines) && lines[i] == "\\ No newline at end of file\n" {
+				text = strings.TrimSuffix(text, "\n")
+				i++
+			}
+			if op != '+' {
+				if cursor >= len(oldLines) || oldLines[cursor] != text {
+					return "", fmt.Errorf("line %d: %q does not match the old text", i, text)
+				}
+				cursor++
+				oldCount--
+			}
+			if op != '-' {
+				out.WriteString(text)
+				newCount--
+			}
+		}
+		if oldCount != 0 || newCount != 0 {
+			return "", fmt.Errorf("line %d: hunk line counts do not match", i)
+		}
+	}
+	for ; cursor < len(oldLines); cursor++ {
+		out.WriteString(oldLines[cursor])
+	}
+	return out.String(), nil
+}
+
+func TestUnifiedDiffApplies(t *testing.T) {
+	// 無作為な編集で作った変更後のテキストが、パッチを適用すると得られることを確かめる
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"<a>", "</a>", "<b/>", "text", "", "  <c x=\"1\"/>", "<d>"}
+	randomText := func(n int) []string {
+		lines := make([]string, n)
+		for i := range lines {
+			lines[i] = words[rng.Intn(len(words))]
+		}
+		return lines
+	}
+	join := func(lines []string, newline bool) string {
+		s := strings.Join(lines, "\n")
+		if newline && len(lines) > 0 {
+			s += "\n"
+		}
+		return s
+	}
+	for i := 0; i < 500; i++ {
+		x := randomText(rng.Intn(40))
+		y := append([]string(nil), x...)
+		for edits := rng.Intn(6); edits > 0; edits-- {
+			at := 0
+			if len(y) > 0 {
+				at = rng.Intn(len(y))
+			}
+			switch rng.Intn(3) {
+			case 0:
+				y = append(y[:at], append(randomText(1+rng.Intn(3)), y[at:]...)...)
+			case 1:
+				if len(y) > 0 {
+					y = append(y[:at], y[at+1:]...)
+				}
+			case 2:
+				if len(y) > 0 {
+					y[at] = fmt.Sprintf("changed %d", rng.Intn(100))
+				}
+			}
+		}
+		old, new := join(x, rng.Intn(4) > 0), join(y, rng.Intn(4) > 0)
+		patch := string(unifiedDiff("old", []byte(old), "new", []byte(new)))
+		if old == new {
+			if patch != "" {
+				t.Fatalf("case %d: patch for identical texts is not empty:\n%s", i, patch)
+			}
+			continue
+		}
+		got, err := applyPatch(old, patch)
+		if err != nil {
+			t.Fatalf("case %d: %v\nold:\n%q\nnew:\n%q\npatch:\n%s", i, err, old, new, patch)
+		}
+		if got != new {
+			t.Fatalf("case %d: applying the patch gives\n%q\nwant\n%q\npatch:\n%s", i, got, new, patch)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+//go:build (linux || darwin || freebsd) && cgo
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sort"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// loadPlugins は、ディレクトリ内の Go プラグイン (*.so) をファイル名の順に読み込み、
+// 各プラグインが公開する登録関数 (obufuku.PluginSymbol) を呼び出します。
+// プラグインは本体と同じバージョンの Go と obufuku パッケージでビルドされている必要があります。
+func loadPlugins(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("failed to open plugin directory '%s': %w", dir, err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		p, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to load plugin '%s': %w", path, err)
+		}
+		sym, err := p.Lookup(obufuku.PluginSymbol)
+		if err != nil {
+			return fmt.Errorf("plugin '%s' does not export '%s': %w", path, obufuku.PluginSymbol, err)
+		}
+		register, ok := sym.(func(obufuku.PluginRegistrar) error)
+		if !ok {
+			return fmt.Errorf("plugin '%s' exports '%s' with unexpected type %T (want func(obufuku.PluginRegistrar) error)", path, obufuku.PluginSymbol, sym)
+		}
+		registrar := &pluginRegistrar{path: path}
+		if err := register(registrar); err != nil {
+			return fmt.Errorf("plugin '%s' failed to register: %w", path, err)
+		}
+		if registrar.err != nil {
+			return registrar.err
+		}
+	}
+	return nil
+}
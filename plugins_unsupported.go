@@ -0,0 +1,10 @@
+//go:build !((linux || darwin || freebsd) && cgo)
+
+package main
+
+import "fmt"
+
+// loadPlugins は、Go プラグインに対応していないプラットフォームではエラーを返します。
+func loadPlugins(dir string) error {
+	return fmt.Errorf("plugins are not supported on this platform or build (requires cgo on linux, darwin or freebsd)")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+)
+
+// profileOptions は、性能の調査のためのプロファイルの出力先です。
+type profileOptions struct {
+	// CPUProfile が空でない場合、処理中の CPU プロファイルをこのファイルに書き込みます。
+	CPUProfile string
+	// MemProfile が空でない場合、処理の終わりのヒーププロファイルをこのファイルに書き込みます。
+	MemProfile string
+	// PProf が空でない場合、このアドレスで net/http/pprof のエンドポイントを公開します (サーバーのコマンドのみ)。
+	PProf string
+}
+
+// addProfileFlags は、プロファイルのオプションを fs に登録します。
+// server が true の場合は、常駐中に取得できる --pprof も登録します。
+func addProfileFlags(fs *flag.FlagSet, server bool) *profileOptions {
+	p := &profileOptions{}
+	fs.StringVar(&p.CPUProfile, "cpuprofile", "", "write a CPU profile to this file (inspect with 'go tool pprof')")
+	fs.StringVar(&p.MemProfile, "memprofile", "", "write a heap profile to this file when the command finishes")
+	if server {
+		fs.StringVar(&p.PProf, "pprof", "", "serve net/http/pprof profiling endpoints on this address (e.g. localhost:6060)")
+	}
+	return p
+}
+
+// startProfiling は、オプションに従ってプロファイルの取得を始め、取得を終えて書き込む関数を返します。
+// エラーで終了した場合 (fatal) はプロファイルを書き込みません。
+func startProfiling(p profileOptions) (func(), error) {
+	var stops []func()
+	stop := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+
+	if p.CPUProfile != "" {
+		file, err := os.Create(p.CPUProfile)
+		if err != nil {
+			return nil, fmt.Errorf("error creating CPU profile '%s': %w", p.CPUProfile, err)
+		}
+		if err := runtimepprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error starting CPU profile: %w", err)
+		}
+		stops = append(stops, func() {
+			runtimepprof.StopCPUProfile()
+			if err := file.Close(); err != nil {
+				log.Printf("Warning: error writing CPU profile '%s': %v", p.CPUProfile, err)
+			}
+		})
+	}
+
+	if p.MemProfile != "" {
+		stops = append(stops, func() {
+			if err := writeHeapProfile(p.MemProfile); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		})
+	}
+
+	if p.PProf != "" {
+		listener, err := net.Listen("tcp", p.PProf)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("error listening for pprof on '%s': %w", p.PProf, err)
+		}
+		// 既定の ServeMux に登録されるハンドラを、専用の ServeMux に登録する
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		server := &http.Server{Handler: mux}
+		go func() {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Warning: pprof server stopped: %v", err)
+			}
+		}()
+		log.Printf("pprof endpoints listening on http://%s/debug/pprof/", listener.Addr())
+		stops = append(stops, func() { server.Close() })
+	}
+	return stop, nil
+}
+
+// writeHeapProfile は、最新の状態のヒーププロファイルを path に書き込みます。
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating heap profile '%s': %w", path, err)
+	}
+	// 直前までの割り当てをプロファイルに反映させる
+	runtime.GC()
+	if err := runtimepprof.WriteHeapProfile(file); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing heap profile '%s': %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStartProfiling(t *testing.T) {
+	dir := t.TempDir()
+	p := profileOptions{CPUProfile: filepath.Join(dir, "cpu.prof"), MemProfile: filepath.Join(dir, "mem.prof")}
+	stop, err := startProfiling(p)
+	if err != nil {
+		t.Fatalf("startProfiling: %v", err)
+	}
+	stop()
+	for _, path := range []string{p.CPUProfile, p.MemProfile} {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Errorf("profile %s was not written: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestStartProfilingErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing", "cpu.prof")
+	if _, err := startProfiling(profileOptions{CPUProfile: missing}); err == nil {
+		t.Error("startProfiling succeeded with an unwritable CPU profile, want an error")
+	}
+	if _, err := startProfiling(profileOptions{PProf: "invalid address"}); err == nil {
+		t.Error("startProfiling succeeded with an invalid pprof address, want an error")
+	}
+}
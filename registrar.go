@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// pluginRegistrar は、プラグインからの登録を obufuku の登録先に取り次ぎ、
+// 名前の重複などの誤りを panic ではなくエラーとして記録します。
+type pluginRegistrar struct {
+	path string
+	err  error
+}
+
+// RegisterValueFunc は obufuku.PluginRegistrar インターフェースを実装します。
+func (r *pluginRegistrar) RegisterValueFunc(name string, factory obufuku.ValueFuncFactory) {
+	if r.err != nil {
+		return
+	}
+	for _, registered := range obufuku.ValueFuncs() {
+		if registered == name {
+			r.err = fmt.Errorf("plugin '%s' registers value rule type '%s', which is already registered", r.path, name)
+			return
+		}
+	}
+	if name == "" || factory == nil {
+		r.err = fmt.Errorf("plugin '%s' registers a value rule type without a name or factory", r.path)
+		return
+	}
+	obufuku.RegisterValueFunc(name, factory)
+}
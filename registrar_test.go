@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// upperFactory は、値を大文字にするテスト用の値置換の種類です。
+func upperFactory(params map[string]interface{}) (obufuku.ValueReplaceFunc, error) {
+	return strings.ToUpper, nil
+}
+
+// registerPluginValueFunc は、テスト用の種類 "test_plugin_upper" をプラグインの登録先から一度だけ登録します。
+var registerPluginValueFunc = sync.OnceValue(func() error {
+	r := &pluginRegistrar{path: "test.so"}
+	r.RegisterValueFunc("test_plugin_upper", upperFactory)
+	return r.err
+})
+
+func TestPluginRegistrar(t *testing.T) {
+	if err := registerPluginValueFunc(); err != nil {
+		t.Fatalf("RegisterValueFunc: %v", err)
+	}
+	cfg := obufuku.Config{ValueRules: []obufuku.ConfigValueRule{{Target: "b", Type: "test_plugin_upper"}}, Output: obufuku.ConfigOutput{Compact: true}}
+	if got, want := transformFile(t, cfg, "<a><b>up</b></a>"), "<a><b>UP</b></a>"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		name    string
+		typ     string
+		factory obufuku.ValueFuncFactory
+		err     string
+	}{
+		{"built-in type", "append", upperFactory, "'append', which is already registered"},
+		{"registered by a plugin", "test_plugin_upper", upperFactory, "already registered"},
+		{"empty name", "", upperFactory, "without a name or factory"},
+		{"nil factory", "test_plugin_nil", nil, "without a name or factory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &pluginRegistrar{path: "test.so"}
+			r.RegisterValueFunc(tt.typ, tt.factory)
+			if r.err == nil || !strings.Contains(r.err.Error(), tt.err) {
+				t.Errorf("err = %v, want an error containing %q", r.err, tt.err)
+			}
+			// 最初の誤りの後の登録は無視される
+			r.RegisterValueFunc("test_plugin_ignored", upperFactory)
+			for _, name := range obufuku.ValueFuncs() {
+				if name == "test_plugin_ignored" {
+					t.Error("registration after an error was not ignored")
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPluginsMissingDirectory(t *testing.T) {
+	if err := loadPlugins(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loadPlugins succeeded for a missing directory")
+	}
+}
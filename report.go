@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// runReport は、--report-template のテンプレートに渡す、処理の終わりの報告の内容です。
+type runReport struct {
+	// Command は、処理の種類 ("transform", "merge", "archive", "csv", "split") です。
+	Command string
+	Rules   string
+	Inputs  []string
+	Output  string
+	// Files は、処理したファイルです。分割では出力したファイル、zipアーカイブでは変換したファイル名、
+	// それ以外では出力ファイルです。
+	Files []string
+	// Skipped は、--skip-unchanged でルールが1つも適用されなかったため書き込まなかった出力ファイルです。
+	Skipped []string
+	obufuku.TransformResult
+	Started  time.Time
+	Finished time.Time
+}
+
+// ruleHit は、1つのルールの適用回数と、ルールファイルでルールに設定された説明です。
+type ruleHit struct {
+	Name        string
+	Hits        int
+	Description string
+}
+
+// Hits は、ルールの適用回数をルールファイルでの位置の順に返します。
+func (r runReport) Hits() []ruleHit {
+	hits := make([]ruleHit, 0, len(r.RuleHits))
+	for _, name := range r.RuleHitNames() {
+		hits = append(hits, ruleHit{Name: name, Hits: r.RuleHits[name], Description: r.RuleDescriptions[name]})
+	}
+	return hits
+}
+
+// Duration は、処理にかかった時間です。
+func (r runReport) Duration() time.Duration {
+	return r.Finished.Sub(r.Started)
+}
+
+// executor は、text/template と html/template のテンプレートに共通のメソッドです。
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// reportRenderer は、処理の終わりの報告を利用者のテンプレートで出力します。
+type reportRenderer struct {
+	tmpl   executor
+	output string
+	// started は、処理を始めた時刻です。
+	started time.Time
+}
+
+// addReportFlags は、報告のテンプレートに関するオプションを fs に登録します。
+func addReportFlags(fs *flag.FlagSet, opts *transformOptions) {
+	fs.StringVar(&opts.ReportTemplate, "report-template", "", "render the end-of-run report with this Go template instead of the fixed text (HTML-escaped when the file ends in .html or .htm)")
+	fs.StringVar(&opts.ReportOutput, "report-output", "", "with --report-template, write the report to this file instead of standard output")
+}
+
+// newReportRenderer は、opts.ReportTemplate のテンプレートを読み込みます。
+// テンプレートが指定されていない場合は nil を返します。
+func newReportRenderer(opts transformOptions) (*reportRenderer, error) {
+	if opts.ReportTemplate == "" {
+		if opts.ReportOutput != "" {
+			return nil, fmt.Errorf("--report-output requires --report-template")
+		}
+		return nil, nil
+	}
+	text, err := readConfigFile(opts.ReportTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report template '%s': %w", opts.ReportTemplate, err)
+	}
+	name := filepath.Base(opts.ReportTemplate)
+	var tmpl executor
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".html", ".htm":
+		tmpl, err = htmltemplate.New(name).Parse(string(text))
+	default:
+		tmpl, err = template.New(name).Parse(string(text))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse report template '%s': %w", opts.ReportTemplate, err)
+	}
+	return &reportRenderer{tmpl: tmpl, output: opts.ReportOutput, started: time.Now()}, nil
+}
+
+// render は、報告をテンプレートで出力します。
+func (r *reportRenderer) render(report runReport) error {
+	report.Started, report.Finished = r.started, time.Now()
+	if r.output == "" {
+		if err := r.tmpl.Execute(os.Stdout, report); err != nil {
+			return fmt.Errorf("error rendering report: %w", err)
+		}
+		return nil
+	}
+
+	file, err := os.Create(r.output)
+	if err != nil {
+		return fmt.Errorf("error creating report file '%s': %w", r.output, err)
+	}
+	if err := r.tmpl.Execute(file, report); err != nil {
+		file.Close()
+		os.Remove(r.output)
+		return fmt.Errorf("error rendering report: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing report file '%s': %w", r.output, err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+func TestReportTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		template string
+		want     string
+	}{
+		{
+			name:     "text",
+			file:     "report.txt",
+			template: "{{.Command}} {{len .Inputs}} {{.Elements}}{{range .Hits}} {{.Name}}={{.Hits}}{{end}}",
+			want:     "transform 1 3 name_rules[0]=2",
+		},
+		{
+			name:     "html is escaped",
+			file:     "report.html",
+			template: "<p>{{.Command}} {{index .Warnings 0}}</p>",
+			want:     "<p>transform name_rules[1] (tag &#39;&lt;none&gt;&#39;) never matched</p>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := obufuku.Config{NameRules: []obufuku.ConfigNameRule{{Old: "b", New: "c"}, {Old: "<none>", New: "d"}}}
+			opts := transformOptions{
+				ReportTemplate: writeFile(t, dir, tt.file, tt.template),
+				ReportOutput:   filepath.Join(dir, "report.out"),
+			}
+			rulePath, inputPath := writeRules(t, dir, cfg), writeFile(t, dir, "in.xml", "<a><b/><b/></a>")
+			if err := runTransform(context.Background(), rulePath, inputPath, filepath.Join(dir, "out.xml"), opts); err != nil {
+				t.Fatalf("runTransform: %v", err)
+			}
+			got, err := os.ReadFile(opts.ReportOutput)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("report = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportTemplateErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		opts transformOptions
+	}{
+		{"output without template", transformOptions{ReportOutput: filepath.Join(dir, "report.out")}},
+		{"missing template", transformOptions{ReportTemplate: filepath.Join(dir, "missing.txt")}},
+		{"invalid template", transformOptions{ReportTemplate: writeFile(t, dir, "bad.txt", "{{.Command")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newReportRenderer(tt.opts); err == nil {
+				t.Error("newReportRenderer succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// runVerifyRoundTrip は、ルールファイルの入出力設定のままルールを適用せずに入力を変換し、
+// 入力と出力の差異を行番号とともに出力します。出力ファイルは作成しません。
+func runVerifyRoundTrip(ctx context.Context, ruleFilepath, inputFilepath string, opts transformOptions) error {
+	rules, err := loadRuleSet(ruleFilepath, opts)
+	if err != nil {
+		return err
+	}
+
+	inputFile, err := openLimitedInput(inputFilepath, opts.MaxInputSize)
+	if err != nil {
+		return fmt.Errorf("error opening input file '%s': %w", inputFilepath, err)
+	}
+	defer inputFile.Close()
+
+	diffs, err := rules.VerifyRoundTrip(ctx, inputFile)
+	if err != nil {
+		return fmt.Errorf("error verifying round trip: %w", err)
+	}
+	if len(diffs) == 0 {
+		fmt.Printf("%s: output is identical to the input\n", inputFilepath)
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, diff := range diffs {
+		fmt.Printf("%s:%s\n", inputFilepath, diff)
+		counts[diff.Kind]++
+	}
+	fmt.Printf("%s: %d difference(s)\n", inputFilepath, len(diffs))
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Printf("  %s: %d\n", kind, counts[kind])
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ruleNamePattern は、ルールストアのルールセットの名前として使える文字列です。
+var ruleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// errRulesNotFound は、指定したルールセットやバージョンが無いことを表します。
+var errRulesNotFound = errors.New("rules not found")
+
+// ruleVersion は、ルールセットの1つのバージョンの情報です。
+type ruleVersion struct {
+	Version    int64
+	UploadedAt time.Time
+	SHA256     string
+}
+
+// ruleSetInfo は、ルールセットとそのバージョンの一覧です。
+// ActiveVersion が 0 の場合は、有効化されたバージョンが無いか、バージョン管理外のルールファイルです。
+type ruleSetInfo struct {
+	Name          string
+	ActiveVersion int64
+	Versions      []ruleVersion
+}
+
+// ruleStore は、サーバーが変換に使うルールセットをバージョンごとに保持する保存先です。
+type ruleStore interface {
+	// Upload は、ルールセット name の新しいバージョンとして data を保存します。
+	// activate が true の場合は、保存したバージョンを有効化します。
+	Upload(name string, data []byte, activate bool) (ruleVersion, error)
+	// List は、すべてのルールセットを名前の昇順で返します。
+	List() ([]ruleSetInfo, error)
+	// Get は、ルールセット name のバージョン version (0 の場合は有効化されたバージョン) の内容と、
+	// そのバージョン番号を返します。
+	Get(name string, version int64) ([]byte, int64, error)
+	// Activate は、ルールセット name のバージョン version を有効化します。
+	Activate(name string, version int64) (ruleSetInfo, error)
+}
+
+// validateRuleName は、ルールセットの名前として使えるかを検査します。
+func validateRuleName(name string) error {
+	if !ruleNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid rules name: '%s'", name)
+	}
+	return nil
+}
+
+// newRuleVersion は、内容 data のバージョンの情報を作成します。
+func newRuleVersion(version int64, data []byte, uploadedAt time.Time) ruleVersion {
+	sum := sha256.Sum256(data)
+	return ruleVersion{Version: version, UploadedAt: uploadedAt, SHA256: hex.EncodeToString(sum[:])}
+}
+
+// memoryRuleStore は、ルールセットをメモリ上に保持する ruleStore です。プロセスの終了で失われます。
+type memoryRuleStore struct {
+	mu   sync.RWMutex
+	sets map[string]*memoryRuleSet
+}
+
+// memoryRuleSet は、memoryRuleStore の1つのルールセットです。
+type memoryRuleSet struct {
+	versions []ruleVersion
+	data     [][]byte
+	active   int64
+}
+
+// newMemoryRuleStore は、空の memoryRuleStore を作成します。
+func newMemoryRuleStore() *memoryRuleStore {
+	return &memoryRuleStore{sets: make(map[string]*memoryRuleSet)}
+}
+
+func (s *memoryRuleStore) Upload(name string, data []byte, activate bool) (ruleVersion, error) {
+	if err := validateRuleName(name); err != nil {
+		return ruleVersion{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := s.sets[name]
+	if set == nil {
+		set = &memoryRuleSet{}
+		s.sets[name] = set
+	}
+	version := newRuleVersion(int64(len(set.versions)+1), data, time.Now())
+	set.versions = append(set.versions, version)
+	set.data = append(set.data, append([]byte(nil), data...))
+	if activate {
+		set.active = version.Version
+	}
+	return version, nil
+}
+
+func (s *memoryRuleStore) List() ([]ruleSetInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := make([]ruleSetInfo, 0, len(s.sets))
+	for name := range s.sets {
+		infos = append(infos, s.info(name))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+func (s *memoryRuleStore) Get(name string, version int64) ([]byte, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	set := s.sets[name]
+	if set == nil {
+		return nil, 0, errRulesNotFound
+	}
+	if version == 0 {
+		version = set.active
+	}
+	if version < 1 || version > int64(len(set.data)) {
+		return nil, 0, errRulesNotFound
+	}
+	return set.data[version-1], version, nil
+}
+
+func (s *memoryRuleStore) Activate(name string, version int64) (ruleSetInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := s.sets[name]
+	if set == nil || version < 1 || version > int64(len(set.versions)) {
+		return ruleSetInfo{}, errRulesNotFound
+	}
+	set.active = version
+	return s.info(name), nil
+}
+
+// info は、ルールセット name の情報を返します。呼び出し側でロックを取得している必要があります。
+func (s *memoryRuleStore) info(name string) ruleSetInfo {
+	set := s.sets[name]
+	return ruleSetInfo{Name: name, ActiveVersion: set.active, Versions: append([]ruleVersion(nil), set.versions...)}
+}
+
+// diskRuleStore は、ルールセットをディレクトリに保存する ruleStore です。
+//
+//	<dir>/<name>.json                有効化されたバージョン (手で置いたルールファイルもそのまま使える)
+//	<dir>/.versions/<name>/<N>.json  登録されたバージョン N
+//	<dir>/.versions/<name>/active    有効化されたバージョンの番号
+type diskRuleStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// newDiskRuleStore は、ディレクトリ dir を使う diskRuleStore を作成します。
+func newDiskRuleStore(dir string) *diskRuleStore {
+	return &diskRuleStore{dir: dir}
+}
+
+// versionsDir は、ルールセット name のバージョンを保存するディレクトリです。
+func (s *diskRuleStore) versionsDir(name string) string {
+	return filepath.Join(s.dir, ".versions", name)
+}
+
+func (s *diskRuleStore) Upload(name string, data []byte, activate bool) (ruleVersion, error) {
+	if err := validateRuleName(name); err != nil {
+		return ruleVersion{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions, err := s.versions(name)
+	if err != nil {
+		return ruleVersion{}, err
+	}
+	next := int64(1)
+	if len(versions) > 0 {
+		next = versions[len(versions)-1].Version + 1
+	}
+	if err := os.MkdirAll(s.versionsDir(name), 0o755); err != nil {
+		return ruleVersion{}, err
+	}
+	path := filepath.Join(s.versionsDir(name), strconv.FormatInt(next, 10)+".json")
+	if err := writeFileAtomic(path, data); err != nil {
+		return ruleVersion{}, err
+	}
+	if activate {
+		if err := s.activate(name, next); err != nil {
+			return ruleVersion{}, err
+		}
+	}
+	return newRuleVersion(next, data, time.Now()), nil
+}
+
+func (s *diskRuleStore) List() ([]ruleSetInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make(map[string]bool)
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() && ruleNamePattern.MatchString(name) {
+			names[name] = true
+		}
+	}
+	versioned, err := os.ReadDir(filepath.Join(s.dir, ".versions"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range versioned {
+		if entry.IsDir() && ruleNamePattern.MatchString(entry.Name()) {
+			names[entry.Name()] = true
+		}
+	}
+
+	infos := make([]ruleSetInfo, 0, len(names))
+	for name := range names {
+		info, err := s.info(name)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+func (s *diskRuleStore) Get(name string, version int64) ([]byte, int64, error) {
+	if err := validateRuleName(name); err != nil {
+		return nil, 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := filepath.Join(s.versionsDir(name), strconv.FormatInt(version, 10)+".json")
+	if version == 0 {
+		active, err := s.activeVersion(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		path, version = filepath.Join(s.dir, name+".json"), active
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, errRulesNotFound
+	}
+	return data, version, err
+}
+
+func (s *diskRuleStore) Activate(name string, version int64) (ruleSetInfo, error) {
+	if err := validateRuleName(name); err != nil {
+		return ruleSetInfo{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.activate(name, version); err != nil {
+		return ruleSetInfo{}, err
+	}
+	return s.info(name)
+}
+
+// activate は、バージョンの内容を <name>.json に置き換え、有効化したバージョン番号を記録します。
+func (s *diskRuleStore) activate(name string, version int64) error {
+	data, err := os.ReadFile(filepath.Join(s.versionsDir(name), strconv.FormatInt(version, 10)+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return errRulesNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, name+".json"), data); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.versionsDir(name), "active"), []byte(strconv.FormatInt(version, 10)))
+}
+
+// activeVersion は、有効化されたバージョンの番号を返します (記録が無い場合は 0)。
+func (s *diskRuleStore) activeVersion(name string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(s.versionsDir(name), "active"))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// versions は、ルールセット name の登録されたバージョンを番号の昇順で返します。
+func (s *diskRuleStore) versions(name string) ([]ruleVersion, error) {
+	entries, err := os.ReadDir(s.versionsDir(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var versions []ruleVersion
+	for _, entry := range entries {
+		base, ok := strings.CutSuffix(entry.Name(), ".json")
+		number, err := strconv.ParseInt(base, 10, 64)
+		if !ok || err != nil || number < 1 {
+			continue
+		}
+		path := filepath.Join(s.versionsDir(name), entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, newRuleVersion(number, data, stat.ModTime()))
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+// info は、ルールセット name の情報を返します。
+func (s *diskRuleStore) info(name string) (ruleSetInfo, error) {
+	versions, err := s.versions(name)
+	if err != nil {
+		return ruleSetInfo{}, err
+	}
+	active, err := s.activeVersion(name)
+	if err != nil {
+		return ruleSetInfo{}, err
+	}
+	return ruleSetInfo{Name: name, ActiveVersion: active, Versions: versions}, nil
+}
+
+// writeFileAtomic は、一時ファイルに書き込んでから名前を変更することで、path の内容を置き換えます。
+// 変換中のリクエストが書きかけのルールファイルを読み込まないようにするためです。
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRuleStores(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) ruleStore
+	}{
+		{"memory", func(t *testing.T) ruleStore { return newMemoryRuleStore() }},
+		{"disk", func(t *testing.T) ruleStore { return newDiskRuleStore(t.TempDir()) }},
+	}
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			store := st.store(t)
+			v1, err := store.Upload("orders", []byte(`{"v":1}`), true)
+			if err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			v2, err := store.Upload("orders", []byte(`{"v":2}`), false)
+			if err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if v1.Version != 1 || v2.Version != 2 || v1.SHA256 == v2.SHA256 {
+				t.Errorf("versions = %+v, %+v, want 1 and 2 with different checksums", v1, v2)
+			}
+
+			// 有効化していないバージョンは、番号を指定した場合だけ取得できる
+			data, version, err := store.Get("orders", 0)
+			if err != nil || string(data) != `{"v":1}` || version != 1 {
+				t.Errorf("Get(active) = %q, %d, %v, want version 1", data, version, err)
+			}
+			data, version, err = store.Get("orders", 2)
+			if err != nil || string(data) != `{"v":2}` || version != 2 {
+				t.Errorf("Get(2) = %q, %d, %v, want version 2", data, version, err)
+			}
+
+			info, err := store.Activate("orders", 2)
+			if err != nil || info.ActiveVersion != 2 || len(info.Versions) != 2 {
+				t.Errorf("Activate = %+v, %v, want version 2 active of 2", info, err)
+			}
+			if data, _, _ := store.Get("orders", 0); string(data) != `{"v":2}` {
+				t.Errorf("Get(active) after Activate = %q, want version 2", data)
+			}
+
+			if _, err := store.Upload("archive", []byte(`{}`), true); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			infos, err := store.List()
+			if err != nil || len(infos) != 2 || infos[0].Name != "archive" || infos[1].Name != "orders" {
+				t.Errorf("List = %+v, %v, want archive and orders", infos, err)
+			}
+		})
+	}
+}
+
+func TestRuleStoreErrors(t *testing.T) {
+	stores := map[string]ruleStore{
+		"memory": newMemoryRuleStore(),
+		"disk":   newDiskRuleStore(t.TempDir()),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			for _, bad := range []string{"", "../x", ".hidden", "a/b"} {
+				if _, err := store.Upload(bad, []byte(`{}`), true); err == nil {
+					t.Errorf("Upload(%q) succeeded, want an error", bad)
+				}
+			}
+			if _, _, err := store.Get("missing", 0); !errors.Is(err, errRulesNotFound) {
+				t.Errorf("Get(missing) error = %v, want errRulesNotFound", err)
+			}
+			if _, err := store.Upload("r", []byte(`{}`), false); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if _, err := store.Activate("r", 5); !errors.Is(err, errRulesNotFound) {
+				t.Errorf("Activate(5) error = %v, want errRulesNotFound", err)
+			}
+			if _, _, err := store.Get("r", 0); !errors.Is(err, errRulesNotFound) {
+				t.Errorf("Get(r) without an active version error = %v, want errRulesNotFound", err)
+			}
+		})
+	}
+}
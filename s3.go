@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3Scheme は、S3 のオブジェクトを表すURIの接頭辞です。
+const s3Scheme = "s3://"
+
+// s3PathStyleEnv は、S3互換ストレージ (MinIO など) 向けにパス形式のURLを使うための環境変数です。
+// 接続先は AWS SDK と同じく AWS_ENDPOINT_URL_S3 (または AWS_ENDPOINT_URL) で指定します。
+const s3PathStyleEnv = "OBUFUKU_S3_PATH_STYLE"
+
+var (
+	s3ClientOnce sync.Once
+	s3ClientVal  *s3.Client
+	s3ClientErr  error
+)
+
+// isS3URI は、パスが s3://bucket/key 形式のURIかを返します。
+func isS3URI(p string) bool {
+	return strings.HasPrefix(p, s3Scheme)
+}
+
+// parseS3URI は、s3://bucket/key 形式のURIをバケット名とキーに分解します。
+func parseS3URI(uri string) (string, string, error) {
+	bucket, key, found := strings.Cut(strings.TrimPrefix(uri, s3Scheme), "/")
+	if !found || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid S3 URI '%s' (expected s3://bucket/key)", uri)
+	}
+	return bucket, key, nil
+}
+
+// s3Client は、AWS SDK の既定の設定 (環境変数、共有設定ファイル、IAMロール) で作成したクライアントを返します。
+func s3Client() (*s3.Client, error) {
+	s3ClientOnce.Do(func() {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			s3ClientErr = fmt.Errorf("failed to load AWS configuration: %w", err)
+			return
+		}
+		s3ClientVal = s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = os.Getenv(s3PathStyleEnv) != ""
+		})
+	})
+	return s3ClientVal, s3ClientErr
+}
+
+// openS3Object は、S3 のオブジェクトを読み込むストリームを開きます。
+func openS3Object(uri string) (io.ReadCloser, error) {
+	bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s3Client()
+	if err != nil {
+		return nil, err
+	}
+	object, err := client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	return object.Body, nil
+}
+
+// readS3Object は、S3 のオブジェクトの内容をすべて読み込みます。
+func readS3Object(uri string) ([]byte, error) {
+	body, err := openS3Object(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// putS3Object は、data を S3 のオブジェクトとして書き込みます。
+func putS3Object(uri string, data []byte) error {
+	upload, err := newS3Upload(uri)
+	if err != nil {
+		return err
+	}
+	if _, err := upload.Write(data); err != nil {
+		upload.Abort()
+		return err
+	}
+	return upload.Close()
+}
+
+// s3Upload は、書き込まれたデータをそのまま S3 にアップロードする io.WriteCloser です。
+// 大きな出力はマルチパートアップロードで送るため、出力全体をメモリやディスクに保持しません。
+// Close でアップロードを完了させ、Abort で中止します (中止した場合、オブジェクトは作成されません)。
+type s3Upload struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+// newS3Upload は、uri へのアップロードを開始します。
+func newS3Upload(uri string) (*s3Upload, error) {
+	bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s3Client()
+	if err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	upload := &s3Upload{pipe: writer, done: make(chan error, 1)}
+	go func() {
+		_, err := manager.NewUploader(client).Upload(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   reader,
+		})
+		// アップロードが失敗した場合に、書き込み側が止まらないようにする
+		reader.CloseWithError(err)
+		upload.done <- err
+	}()
+	return upload, nil
+}
+
+// Write は io.Writer インターフェースを実装します。
+func (u *s3Upload) Write(p []byte) (int, error) {
+	return u.pipe.Write(p)
+}
+
+// Close は、アップロードを完了させ、その結果を返します。
+func (u *s3Upload) Close() error {
+	u.pipe.Close()
+	if err := <-u.done; err != nil {
+		return fmt.Errorf("failed to upload to S3: %w", err)
+	}
+	return nil
+}
+
+// Abort は、アップロードを中止します。
+func (u *s3Upload) Abort() {
+	u.pipe.CloseWithError(errS3UploadAborted)
+	<-u.done
+}
+
+// errS3UploadAborted は、アップロードを中止したことを表します。
+var errS3UploadAborted = errors.New("upload aborted")
+
+// openS3Input は、S3 のオブジェクトを入力として開きます。ファイルの入力と同じく、
+// キーの拡張子または先頭のマジックバイトから圧縮形式を判定します。
+// gzip はストリームのまま展開し、zip はランダムアクセスが必要なため一時ファイルに書き出してから読み込みます。
+func openS3Input(uri string) (io.ReadCloser, error) {
+	body, err := openS3Object(uri)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(body)
+	magic, _ := reader.Peek(len(zipMagic))
+
+	ext := strings.ToLower(path.Ext(uri))
+	switch {
+	case ext == ".gz" || bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			body.Close()
+			return nil, fmt.Errorf("failed to read gzip input '%s': %w", uri, err)
+		}
+		return &multiCloser{Reader: gz, closers: []io.Closer{gz, body}}, nil
+
+	case ext == ".zip" || bytes.HasPrefix(magic, zipMagic):
+		file, err := spoolS3Object(reader)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to download zip input '%s': %w", uri, err)
+		}
+		entry, err := openSingleZipEntry(file)
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return nil, fmt.Errorf("failed to read zip input '%s': %w", uri, err)
+		}
+		return &multiCloser{Reader: entry, closers: []io.Closer{entry, file, removeFile(file.Name())}}, nil
+
+	default:
+		return &multiCloser{Reader: reader, closers: []io.Closer{body}}, nil
+	}
+}
+
+// removeFile は、Close でファイルを削除する io.Closer です。
+type removeFile string
+
+// Close は io.Closer インターフェースを実装します。
+func (f removeFile) Close() error {
+	return os.Remove(string(f))
+}
+
+// spoolS3Object は、S3 のオブジェクトを一時ファイルに書き出して開きます (zip の読み込み用)。
+// 返されたファイルは、閉じた後に削除する必要があります。
+func spoolS3Object(body io.Reader) (*os.File, error) {
+	file, err := os.CreateTemp("", "obufuku-s3-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
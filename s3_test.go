@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestParseS3URI(t *testing.T) {
+	tests := []struct {
+		uri    string
+		bucket string
+		key    string
+		ok     bool
+	}{
+		{"s3://bucket/key.xml", "bucket", "key.xml", true},
+		{"s3://bucket/dir/sub/key.xml", "bucket", "dir/sub/key.xml", true},
+		{"s3://bucket", "", "", false},
+		{"s3://bucket/", "", "", false},
+		{"s3:///key.xml", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			bucket, key, err := parseS3URI(tt.uri)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseS3URI error = %v, want ok = %v", err, tt.ok)
+			}
+			if bucket != tt.bucket || key != tt.key {
+				t.Errorf("parseS3URI = %q, %q, want %q, %q", bucket, key, tt.bucket, tt.key)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpScheme は、SFTP サーバー上のファイルを表すURIの接頭辞です。
+const sftpScheme = "sftp://"
+
+// 認証と接続先の確認に使う環境変数です。
+const (
+	// sftpKeyEnv は、認証に使う秘密鍵ファイルのパスです。未指定の場合は ssh-agent と ~/.ssh/id_* を使います。
+	sftpKeyEnv = "OBUFUKU_SFTP_KEY"
+	// sftpKeyPassphraseEnv は、sftpKeyEnv の秘密鍵のパスフレーズです。
+	sftpKeyPassphraseEnv = "OBUFUKU_SFTP_KEY_PASSPHRASE"
+	// sftpKnownHostsEnv は、ホスト鍵の確認に使う known_hosts ファイルのパスです。既定は ~/.ssh/known_hosts です。
+	sftpKnownHostsEnv = "OBUFUKU_SFTP_KNOWN_HOSTS"
+)
+
+// sftpBufferSize は、SFTP のファイルを読み書きする単位です。
+// 1回の要求より大きな単位で読み書きすると、クライアントが複数の要求を並行して送るため、
+// 遅延の大きい回線でも転送速度が落ちにくくなります。
+const sftpBufferSize = 1 << 20
+
+// sftpDialTimeout は、SFTP サーバーへの接続とSSHの認証を待つ時間です。
+const sftpDialTimeout = 30 * time.Second
+
+// isSFTPURI は、パスが sftp://user@host/path 形式のURIかを返します。
+func isSFTPURI(p string) bool {
+	return strings.HasPrefix(p, sftpScheme)
+}
+
+// sftpLocation は、SFTP のURIが表す接続先とファイルのパスです。
+type sftpLocation struct {
+	user string
+	// address は、ポート番号を含むサーバーのアドレスです。
+	address string
+	// path は、サーバー上のファイルのパスです。/~/ で始まるURIの場合はログインディレクトリからの相対パスです。
+	path string
+}
+
+// parseSFTPURI は、sftp://user@host[:port]/path 形式のURIを分解します。
+// ユーザー名を省略した場合は、実行しているユーザーの名前を使います。
+func parseSFTPURI(uri string) (sftpLocation, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return sftpLocation{}, fmt.Errorf("invalid SFTP URI '%s': %w", uri, err)
+	}
+	if u.Hostname() == "" || u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return sftpLocation{}, fmt.Errorf("invalid SFTP URI '%s' (expected sftp://user@host/path)", uri)
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		return sftpLocation{}, fmt.Errorf("invalid SFTP URI '%s': passwords are not supported, use key-based authentication", uri)
+	}
+
+	loc := sftpLocation{address: u.Host, path: u.Path}
+	if u.Port() == "" {
+		loc.address = net.JoinHostPort(u.Hostname(), "22")
+	}
+	if rest, ok := strings.CutPrefix(u.Path, "/~/"); ok {
+		loc.path = rest
+	}
+	loc.user = u.User.Username()
+	if loc.user == "" {
+		current, err := user.Current()
+		if err != nil {
+			return sftpLocation{}, fmt.Errorf("invalid SFTP URI '%s': no user name given: %w", uri, err)
+		}
+		loc.user = current.Username
+	}
+	return loc, nil
+}
+
+// sftpConn は、1つのファイルを読み書きするためのSFTPの接続です。
+type sftpConn struct {
+	*sftp.Client
+	ssh *ssh.Client
+}
+
+// dialSFTP は、URIの接続先に鍵認証で接続し、接続とファイルのパスを返します。
+// サーバーのホスト鍵は known_hosts で確認し、登録されていないサーバーには接続しません。
+func dialSFTP(uri string) (*sftpConn, string, error) {
+	loc, err := parseSFTPURI(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	auth, err := sftpAuthMethods()
+	if err != nil {
+		return nil, "", err
+	}
+	hostKeyCallback, err := sftpHostKeyCallback()
+	if err != nil {
+		return nil, "", err
+	}
+
+	client, err := ssh.Dial("tcp", loc.address, &ssh.ClientConfig{
+		User:            loc.user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sftpDialTimeout,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to SFTP server '%s': %w", loc.address, err)
+	}
+	sftpClient, err := sftp.NewClient(client, sftp.UseConcurrentReads(true), sftp.UseConcurrentWrites(true))
+	if err != nil {
+		client.Close()
+		return nil, "", fmt.Errorf("failed to start SFTP session on '%s': %w", loc.address, err)
+	}
+	return &sftpConn{Client: sftpClient, ssh: client}, loc.path, nil
+}
+
+// Close は、SFTP のセッションとSSHの接続を閉じます。
+func (c *sftpConn) Close() error {
+	err := c.Client.Close()
+	if sshErr := c.ssh.Close(); err == nil {
+		err = sshErr
+	}
+	return err
+}
+
+// sftpAuthMethods は、SSHの認証に使う鍵を集めます。
+// sftpKeyEnv が指定されていればその鍵だけを使い、指定されていなければ
+// ssh-agent の鍵と ~/.ssh のパスフレーズのない既定の鍵を使います。
+func sftpAuthMethods() ([]ssh.AuthMethod, error) {
+	if keyPath := os.Getenv(sftpKeyEnv); keyPath != "" {
+		signer, err := loadSSHKey(keyPath, os.Getenv(sftpKeyPassphraseEnv))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load SSH key '%s': %w", keyPath, err)
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		var signers []ssh.Signer
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			// 存在しない鍵やパスフレーズが必要な鍵は使わない
+			if signer, err := loadSSHKey(filepath.Join(home, ".ssh", name), ""); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+		if len(signers) > 0 {
+			methods = append(methods, ssh.PublicKeys(signers...))
+		}
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no SSH keys available for SFTP (set %s or start ssh-agent)", sftpKeyEnv)
+	}
+	return methods, nil
+}
+
+// loadSSHKey は、秘密鍵ファイルを読み込みます。passphrase が空でなければ、それで鍵を復号します。
+func loadSSHKey(keyPath, passphrase string) (ssh.Signer, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("key is protected by a passphrase (set %s)", sftpKeyPassphraseEnv)
+	}
+	return signer, err
+}
+
+// sftpHostKeyCallback は、known_hosts ファイルでサーバーのホスト鍵を確認するコールバックを作成します。
+func sftpHostKeyCallback() (ssh.HostKeyCallback, error) {
+	knownHostsPath := os.Getenv(sftpKnownHostsEnv)
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate known_hosts (set %s): %w", sftpKnownHostsEnv, err)
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts '%s': %w", knownHostsPath, err)
+	}
+	return callback, nil
+}
+
+// sftpFile は、SFTP サーバー上の読み込み用のファイルです。Close で接続も閉じます。
+type sftpFile struct {
+	*sftp.File
+	conn *sftpConn
+}
+
+// openSFTPFile は、SFTP サーバー上のファイルを読み込み用に開きます。
+func openSFTPFile(uri string) (*sftpFile, error) {
+	conn, remotePath, err := dialSFTP(uri)
+	if err != nil {
+		return nil, err
+	}
+	file, err := conn.Open(remotePath)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &sftpFile{File: file, conn: conn}, nil
+}
+
+// Close は io.Closer インターフェースを実装します。
+func (f *sftpFile) Close() error {
+	err := f.File.Close()
+	if connErr := f.conn.Close(); err == nil {
+		err = connErr
+	}
+	return err
+}
+
+// readSFTPFile は、SFTP サーバー上のファイルの内容をすべて読み込みます。
+func readSFTPFile(uri string) ([]byte, error) {
+	file, err := openSFTPFile(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(bufio.NewReaderSize(file, sftpBufferSize))
+}
+
+// writeSFTPFile は、data を SFTP サーバー上のファイルとして書き込みます。
+func writeSFTPFile(uri string, data []byte) error {
+	upload, err := newSFTPUpload(uri)
+	if err != nil {
+		return err
+	}
+	if _, err := upload.Write(data); err != nil {
+		upload.Abort()
+		return err
+	}
+	return upload.Close()
+}
+
+// sftpUpload は、書き込まれたデータをそのまま SFTP サーバー上のファイルに書き込む io.WriteCloser です。
+// 出力全体をローカルに保持せずに転送します。Abort で書きかけのファイルを削除します。
+type sftpUpload struct {
+	*bufio.Writer
+	conn *sftpConn
+	file *sftp.File
+	path string
+}
+
+// newSFTPUpload は、uri のファイルを作成し、書き込みを開始します。
+func newSFTPUpload(uri string) (*sftpUpload, error) {
+	conn, remotePath, err := dialSFTP(uri)
+	if err != nil {
+		return nil, err
+	}
+	file, err := conn.Create(remotePath)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &sftpUpload{Writer: bufio.NewWriterSize(file, sftpBufferSize), conn: conn, file: file, path: remotePath}, nil
+}
+
+// Close は、残りのデータを書き込んでファイルと接続を閉じます。
+func (u *sftpUpload) Close() error {
+	err := u.Flush()
+	if closeErr := u.file.Close(); err == nil {
+		err = closeErr
+	}
+	u.conn.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write to SFTP server: %w", err)
+	}
+	return nil
+}
+
+// Abort は、書き込みを中止し、書きかけのファイルを削除します。
+func (u *sftpUpload) Abort() {
+	u.file.Close()
+	if err := u.conn.Remove(u.path); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove incomplete output file '%s' on SFTP server: %v\n", u.path, err)
+	}
+	u.conn.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// splitOutputPath は、出力ファイル名のパターン pattern に要素の番号 index を埋め込みます。
+func splitOutputPath(pattern string, index int) string {
+	return fmt.Sprintf(pattern, index)
+}
+
+// checkSplitOutputPattern は、出力ファイル名のパターンに要素の番号を埋め込む %d がちょうど1つあるかを確認します。
+func checkSplitOutputPattern(pattern string) error {
+	first := splitOutputPath(pattern, 1)
+	if strings.Contains(first, "%!") || first == splitOutputPath(pattern, 2) {
+		return fmt.Errorf("output path '%s' must contain exactly one %%d for the fragment number when splitting (e.g. 'out/record-%%05d.xml')", pattern)
+	}
+	return nil
+}
+
+// runTransformSplit は、入力のうち opts.Split に一致する要素ごとに、その要素を1つの文書として
+// ルールに基づいて変換し、outputPattern の %d に1から始まる番号を埋め込んだファイルに出力します。
+// カウンターは merge と同じく、要素をまたいで続けて採番されます。
+func runTransformSplit(ctx context.Context, rules *obufuku.RuleSet, ruleFilepath, inputFilepath, outputPattern string, opts transformOptions) error {
+	if err := checkSplitOutputPattern(outputPattern); err != nil {
+		return err
+	}
+
+	inputFile, err := openLimitedInput(inputFilepath, opts.MaxInputSize)
+	if err != nil {
+		return fmt.Errorf("error opening input file '%s': %w", inputFilepath, err)
+	}
+	defer inputFile.Close()
+
+	var files, skipped []string
+	result, count, err := rules.TransformFragments(ctx, inputFile, opts.Split, func(index int, write func(io.Writer) error) error {
+		outputFilepath := splitOutputPath(outputPattern, index)
+		opts.audit.setDocument(outputFilepath)
+		output, err := createOutput(outputFilepath, opts)
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+		if err := write(output); err != nil {
+			if aborted(ctx, err) {
+				output.Discard()
+			}
+			return err
+		}
+		if skipUnchanged(output, opts) {
+			skipped = append(skipped, outputFilepath)
+			return nil
+		}
+		files = append(files, outputFilepath)
+		if err := output.Finish(); err != nil {
+			return err
+		}
+		return validateOutput(outputFilepath, opts)
+	})
+	printWarnings(result)
+	if err != nil {
+		return fmt.Errorf("error processing XML '%s': %w", inputFilepath, err)
+	}
+	if count == 0 {
+		fmt.Fprintf(os.Stderr, "Warning: no element matched '%s'\n", opts.Split)
+	}
+
+	if opts.report != nil {
+		return opts.report.render(runReport{Command: "split", Rules: ruleFilepath, Inputs: []string{inputFilepath}, Output: outputPattern, Files: files, Skipped: skipped, TransformResult: result})
+	}
+	fmt.Printf("XML split completed. Rules: '%s', Input: '%s', Output: '%s'\n", ruleFilepath, inputFilepath, outputPattern)
+	fmt.Printf("  Fragments: %d\n", count)
+	printSkipped(skipped)
+	printResult(result)
+	return nil
+}
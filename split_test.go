@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestCheckSplitOutputPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		ok      bool
+	}{
+		{"out/record-%05d.xml", true},
+		{"out-%d.xml", true},
+		{"out.xml", false},
+		{"out-%d-%d.xml", false},
+		{"out-%s.xml", false},
+	}
+	for _, tt := range tests {
+		if err := checkSplitOutputPattern(tt.pattern); (err == nil) != tt.ok {
+			t.Errorf("checkSplitOutputPattern(%q) error = %v, want ok = %v", tt.pattern, err, tt.ok)
+		}
+	}
+}
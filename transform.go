@@ -1,118 +1,429 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
 )
 
+// transformOptions は、コマンドラインで指定された transform の追加オプションです。
+// ルールファイルの設定よりも優先されます。
+type transformOptions struct {
+	Canonical bool
+	// HTML が true の場合、入力をHTML互換の緩い規則で読み込みます。
+	HTML bool
+	// Secure が true の場合、外部DTDや外部実体を参照する入力を拒否します。
+	Secure bool
+	// Compress が true の場合、出力ファイル名に関わらず gzip 圧縮して書き込みます。
+	Compress bool
+	// Checksum が空でない場合、出力ファイルのチェックサムをこのアルゴリズムで計算し、
+	// 出力ファイルと同じ場所に拡張子 .<アルゴリズム名> のファイルとして書き込みます。
+	Checksum string
+	// Timeout が正の場合、処理がこの時間を超えると中断して書きかけの出力を削除します。
+	Timeout time.Duration
+	// MaxInputSize が正の場合、入力 (展開後の内容) がこのバイト数を超えると中断して書きかけの出力を削除します。
+	// ローカルのファイルがこの大きさを超えている場合は、読み込む前にエラーにします。
+	MaxInputSize int64
+	// MaxOutputSize が正の場合、出力 (圧縮前の内容) がこのバイト数を超えると中断して書きかけの出力を削除します。
+	MaxOutputSize int64
+	// Plugins が空でない場合、ルールファイルを読み込む前にこのディレクトリのプラグインを読み込みます。
+	Plugins string
+	// WASMPlugins が空でない場合、ルールファイルを読み込む前にこのディレクトリの WebAssembly モジュールを読み込みます。
+	WASMPlugins string
+	// ValidateOutput が空でない場合、出力ファイルをこの XML Schema で検証します。
+	ValidateOutput string
+	// ValidateWarn が true の場合、検証の違反をエラーにせず警告として出力します。
+	ValidateWarn bool
+	// ZipEntries は、出力がzipアーカイブの場合に変換するファイル名のパターンです。空の場合は *.xml です。
+	ZipEntries string
+	// CSVColumn が空でない場合、入力を CSV として読み込み、この名前の列の各セルをXMLとして変換します。
+	CSVColumn string
+	// Split が空でない場合、入力のうちこのパスに一致する要素ごとに、別の文書として変換して出力します。
+	Split string
+	// ReportTemplate が空でない場合、処理の終わりの報告を決まった書式ではなく、このテンプレートで出力します。
+	ReportTemplate string
+	// ReportOutput が空でない場合、テンプレートで出力する報告をこのファイルに書き込みます。
+	ReportOutput string
+	// Audit が空でない場合、ルールによるすべての変更 (ルール・要素のパス・入力での位置・変更前後の値) を
+	// このファイルに JSON Lines で記録します。
+	Audit string
+
+	// report は、ReportTemplate から読み込んだ報告の出力です (指定が無ければ nil)。
+	report *reportRenderer
+	// EmitPatch が空でない場合、入力 (展開後の内容) から出力 (圧縮前の内容) への変更を
+	// unified diff の形式でこのファイルに書き込みます。単一の入力ファイルの変換でだけ使えます。
+	EmitPatch string
+	// SkipUnchanged が true の場合、ルールが1つも適用されなかった出力は書き込まず、既存の出力ファイルを
+	// 更新日時も含めてそのまま残します。出力は、ルールが適用されたと分かるまで一時ファイルに保持します。
+	SkipUnchanged bool
+
+	// audit は、Audit の監査ファイルです (指定が無ければ nil)。
+	audit *auditLog
+	// matches は、SkipUnchanged のためにルールの適用を数える Observer です (指定が無ければ nil)。
+	matches *ruleMatchCounter
+	// untrusted が true の場合、ルールはサーバーがリクエストで受け取ったもので、サーバーのファイルや
+	// 環境変数、コマンドの値を読み書きする設定 (カウンターの start_from と persist、挿入ルールの csv) を拒否します。
+	untrusted bool
+}
+
 // runTransform は、ルールファイルに基づいてXML変換処理を実行します。
-func runTransform(ruleFilepath, inputFilepath, outputFilepath string) error {
-	// --- ルールファイルの読み込み ---
-	ruleFile, err := os.ReadFile(ruleFilepath)
+// CSV の列が指定されている場合は CSV の各行のセルを、分割のパスが指定されている場合は一致する要素ごとに変換します。出力ファイルの拡張子が .zip の場合は、zipアーカイブの入力に含まれるファイルを変換して新しいアーカイブを作成します。
+// ctx が取り消された場合は処理を中断し、書きかけの出力ファイルを削除します。
+// 正常に終えた場合は、persist を指定したカウンターの値を保存します。
+func runTransform(ctx context.Context, ruleFilepath, inputFilepath, outputFilepath string, opts transformOptions) (err error) {
+	rules, err := loadRuleSet(ruleFilepath, opts)
+	if err != nil {
+		return err
+	}
+	if opts.report, err = newReportRenderer(opts); err != nil {
+		return err
+	}
+	audit, err := openAuditLog(rules, opts, inputFilepath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := audit.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	opts.audit = audit
+	opts.matches = newRuleMatchCounter(rules, opts)
+	if opts.EmitPatch != "" && (opts.Split != "" || opts.CSVColumn != "" || isZipPath(outputFilepath)) {
+		return fmt.Errorf("--emit-patch can only be used when transforming a single XML file")
+	}
+	if opts.Split != "" {
+		return saveCounters(rules, runTransformSplit(ctx, rules, ruleFilepath, inputFilepath, outputFilepath, opts))
+	}
+	if opts.CSVColumn != "" {
+		return saveCounters(rules, runTransformCSV(ctx, rules, ruleFilepath, inputFilepath, outputFilepath, opts))
+	}
+	if isZipPath(outputFilepath) {
+		return saveCounters(rules, runTransformArchive(ctx, rules, ruleFilepath, inputFilepath, outputFilepath, opts))
+	}
+
+	// --- ファイルの準備 ---
+	inputFile, err := openLimitedInput(inputFilepath, opts.MaxInputSize)
 	if err != nil {
-		return fmt.Errorf("failed to read rule file '%s': %w", ruleFilepath, err)
+		return fmt.Errorf("error opening input file '%s': %w", inputFilepath, err)
 	}
+	defer inputFile.Close()
 
-	var config Config
-	if err := json.Unmarshal(ruleFile, &config); err != nil {
-		return fmt.Errorf("failed to parse rule file '%s': %w", ruleFilepath, err)
+	output, err := createOutput(outputFilepath, opts)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	// パッチを作る場合は、変換の前後の内容を保持する
+	var source io.Reader = inputFile
+	var sink io.Writer = output
+	var patchInput, patchOutput bytes.Buffer
+	if opts.EmitPatch != "" {
+		source = io.TeeReader(inputFile, &patchInput)
+		sink = io.MultiWriter(output, &patchOutput)
+	}
+
+	// --- 変換の実行 ---
+	result, err := rules.Transform(ctx, source, sink)
+	printWarnings(result)
+	if err == nil && opts.EmitPatch != "" {
+		// 変換で読み込まれなかった入力の残りも、パッチの入力に含める
+		_, err = io.Copy(io.Discard, source)
+	}
+	if err != nil {
+		if aborted(ctx, err) {
+			output.Discard()
+		}
+		return fmt.Errorf("error processing XML '%s': %w", inputFilepath, err)
+	}
+	files, skipped := []string{outputFilepath}, []string(nil)
+	if skipUnchanged(output, opts) {
+		files, skipped = nil, files
+	} else {
+		if err := output.Finish(); err != nil {
+			return err
+		}
+		if err := validateOutput(outputFilepath, opts); err != nil {
+			return err
+		}
+	}
+	if opts.EmitPatch != "" {
+		if err := writePatch(opts.EmitPatch, inputFilepath, outputFilepath, patchInput.Bytes(), patchOutput.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := rules.SaveCounters(); err != nil {
+		return err
+	}
+
+	if opts.report != nil {
+		return opts.report.render(runReport{Command: "transform", Rules: ruleFilepath, Inputs: []string{inputFilepath}, Output: outputFilepath, Files: files, Skipped: skipped, TransformResult: result})
+	}
+	fmt.Printf("XML processing completed. Rules: '%s', Input: '%s', Output: '%s'\n", ruleFilepath, inputFilepath, outputFilepath)
+	printSkipped(skipped)
+	printResult(result)
+	return nil
+}
+
+// saveCounters は、変換が正常に終えた (err が nil の) 場合に、persist を指定したカウンターの値を保存します。
+func saveCounters(rules *obufuku.RuleSet, err error) error {
+	if err != nil {
+		return err
+	}
+	return rules.SaveCounters()
+}
+
+// ruleMatchCounter は、--skip-unchanged のためにルールが適用された回数を数える Observer です。
+// assert_rules は文書を変更しないため、数えません。
+type ruleMatchCounter struct {
+	n atomic.Int64
+}
+
+// newRuleMatchCounter は、opts.SkipUnchanged が指定されていれば、rules のルールの適用を数えるよう登録します。
+func newRuleMatchCounter(rules *obufuku.RuleSet, opts transformOptions) *ruleMatchCounter {
+	if !opts.SkipUnchanged {
+		return nil
+	}
+	c := &ruleMatchCounter{}
+	rules.AddObserver(c)
+	return c
+}
+
+// RuleApplied は obufuku.Observer インターフェースを実装します。
+func (c *ruleMatchCounter) RuleApplied(obufuku.RuleEvent) {
+	c.n.Add(1)
+}
+
+// skipUnchanged は、--skip-unchanged が指定され、前回の呼び出しからルールが1つも適用されていなければ、
+// output を出力先に書き込まずに破棄して true を返します。
+func skipUnchanged(output *outputFile, opts transformOptions) bool {
+	if opts.matches == nil || opts.matches.n.Swap(0) > 0 {
+		return false
 	}
+	output.Skip()
+	return true
+}
 
-	// --- JSON設定から実行用ルールを組み立て ---
+// printSkipped は、ルールが適用されなかったため書き込まなかった出力を書き出します。
+func printSkipped(skipped []string) {
+	for _, name := range skipped {
+		fmt.Printf("  Skipped (no rule matched): '%s'\n", name)
+	}
+}
 
-	// カウンターの準備
-	counters := make(map[string]*Counter)
-	for name, counterConfig := range config.Counters {
-		counters[name] = &Counter{current: counterConfig.Start}
+// printWarnings は、変換処理中の警告を標準エラー出力に書き出します。
+func printWarnings(result obufuku.TransformResult) {
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
 	}
+}
 
-	// NameRules の組み立て
-	var nameRules []NameReplaceRule
-	for _, r := range config.NameRules {
-		nameRules = append(nameRules, NameReplaceRule{OldName: r.Old, NewName: r.New})
+// printResult は、変換処理の結果の概要を書き出します。
+func printResult(result obufuku.TransformResult) {
+	fmt.Printf("  Elements: %d, Bytes read: %d, Bytes written: %d\n", result.Elements, result.BytesRead, result.BytesWritten)
+	for _, name := range result.RuleHitNames() {
+		if description := result.RuleDescriptions[name]; description != "" {
+			fmt.Printf("  %s: %d hit(s) (%s)\n", name, result.RuleHits[name], description)
+			continue
+		}
+		fmt.Printf("  %s: %d hit(s)\n", name, result.RuleHits[name])
 	}
+}
 
-	// InsertRules の組み立て
-	var insertRules []InsertBeforeRule
-	for _, r := range config.InsertRules {
-		insertRules = append(insertRules, InsertBeforeRule{
-			TargetTag:   r.Target,
-			XMLTemplate: r.Template,
-			Counter:     counters[r.Counter],
-		})
+// loadRuleSet は、ルールファイルを読み込み、コマンドラインのオプションを反映して実行用のルールセットを組み立てます。
+func loadRuleSet(ruleFilepath string, opts transformOptions) (*obufuku.RuleSet, error) {
+	// --- プラグインの読み込み ---
+	if err := loadPluginDirs(opts); err != nil {
+		return nil, err
 	}
 
-	// InsertAfterRules の組み立て
-	var insertAfterRules []InsertBeforeRule
-	for _, r := range config.InsertAfterRules {
-		insertAfterRules = append(insertAfterRules, InsertBeforeRule{
-			TargetTag:   r.Target,
-			XMLTemplate: r.Template,
-			Counter:     counters[r.Counter],
-		})
+	// --- ルールファイルの読み込み ---
+	ruleFile, err := readConfigFile(ruleFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule file '%s': %w", ruleFilepath, err)
 	}
+	return parseRuleSet(ruleFile, ruleFilepath, opts)
+}
 
-	// PrependChildRules の組み立て
-	var prependChildRules []InsertBeforeRule
-	for _, r := range config.PrependChildRules {
-		prependChildRules = append(prependChildRules, InsertBeforeRule{
-			TargetTag:   r.Target,
-			XMLTemplate: r.Template,
-			Counter:     counters[r.Counter],
-		})
+// readConfigFile は、ルールファイルなどの設定ファイルを読み込みます。
+// S3 や SFTP のURIの場合は、リモートのファイルを読み込みます。
+func readConfigFile(p string) ([]byte, error) {
+	switch {
+	case isS3URI(p):
+		return readS3Object(p)
+	case isSFTPURI(p):
+		return readSFTPFile(p)
+	default:
+		return os.ReadFile(p)
 	}
+}
 
-	// ValueRules の組み立て
-	var valueRules []ValueReplaceRule
-	for _, r := range config.ValueRules {
-		replaceFunc, err := buildValueReplaceFunc(r)
-		if err != nil {
+// loadPluginDirs は、オプションで指定されたディレクトリのプラグインと WebAssembly モジュールを読み込みます。
+func loadPluginDirs(opts transformOptions) error {
+	if opts.Plugins != "" {
+		if err := loadPlugins(opts.Plugins); err != nil {
 			return err
 		}
-		valueRules = append(valueRules, ValueReplaceRule{
-			TargetTag:       r.Target,
-			ReplacementFunc: replaceFunc,
-		})
+	}
+	if opts.WASMPlugins != "" {
+		if err := loadWASMPlugins(opts.WASMPlugins); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseRuleSet は、ルールファイルの内容 ruleFile を解析し、コマンドラインのオプションを反映して
+// 実行用のルールセットを組み立てます。ruleFilepath は、エラーメッセージに使うルールの名前です。
+func parseRuleSet(ruleFile []byte, ruleFilepath string, opts transformOptions) (*obufuku.RuleSet, error) {
+	var config obufuku.Config
+	if err := json.Unmarshal(ruleFile, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse rule file '%s': %w", ruleFilepath, err)
+	}
+	if opts.untrusted {
+		if source := externalSource(config); source != "" {
+			return nil, fmt.Errorf("%s in rule file '%s' is not allowed for rules sent to the server", source, ruleFilepath)
+		}
+	}
+	if opts.Canonical {
+		config.Output.Canonical = true
+	}
+	if opts.HTML {
+		config.Input.HTML = true
+	}
+	if opts.Secure {
+		config.Input.Secure = true
 	}
 
-	// WrapRules の組み立て
-	var wrapRules []WrapRule
-	for _, r := range config.WrapRules {
-		wrapRules = append(wrapRules, WrapRule{TargetTag: r.Target, WrapperTag: r.Wrapper})
+	rules, err := obufuku.NewRuleSet(config)
+	if err != nil {
+		return nil, err
 	}
 
-	// CdataRules の組み立て
-	var cdataRules []CdataRule
-	for _, r := range config.CdataRules {
-		cdataRules = append(cdataRules, CdataRule{Old: r.Old, New: r.New})
+	// チェックサムのアルゴリズムを確認
+	if opts.Checksum != "" {
+		if _, err := newChecksumHash(opts.Checksum); err != nil {
+			return nil, err
+		}
 	}
 
-	// RawTags はそのままスライスとして使う
-	rawTags := config.RawTags
+	return rules, nil
+}
 
-	// --- ファイルの準備 ---
-	inputFile, err := os.Open(inputFilepath)
+// externalSource は、ルール (パスのものを含む) のうち、ファイルなどの外部の値を読み書きする設定を
+// エラーメッセージ用に返します (無ければ空)。
+func externalSource(config obufuku.Config) string {
+	for _, c := range append([]obufuku.Config{config}, config.Passes...) {
+		for name, counter := range c.Counters {
+			if counter.StartFrom != nil {
+				return fmt.Sprintf("'start_from' of counter '%s'", name)
+			}
+			if counter.Persist != "" {
+				return fmt.Sprintf("'persist' of counter '%s'", name)
+			}
+		}
+		for _, rules := range [][]obufuku.ConfigInsertRule{c.InsertRules, c.InsertAfterRules, c.PrependChildRules} {
+			for _, r := range rules {
+				if r.CSV != "" {
+					return fmt.Sprintf("'csv' of the insert rule for '%s'", r.Target)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// openRuleSetInput は、入力ファイルを開き、ルールセットの入力設定に従ってXMLとして読み込むReaderを返します。
+// 入力が gzip/zip の場合は展開しながら読み込み、入力エンコーディングが指定されていれば変換します。
+// 展開後の内容は limit バイト (0 は無制限) までしか読み込めません。
+// 読み込み後は、返された io.Closer で入力ファイルを閉じる必要があります。
+func openRuleSetInput(rules *obufuku.RuleSet, inputFilepath string, limit int64) (io.Reader, io.Closer, error) {
+	inputFile, err := openLimitedInput(inputFilepath, limit)
 	if err != nil {
-		return fmt.Errorf("error opening input file '%s': %w", inputFilepath, err)
+		return nil, nil, fmt.Errorf("error opening input file '%s': %w", inputFilepath, err)
 	}
-	defer inputFile.Close()
+	reader, err := rules.NewReader(inputFile)
+	if err != nil {
+		inputFile.Close()
+		return nil, nil, err
+	}
+	return reader, inputFile, nil
+}
 
-	outputFile, err := os.Create(outputFilepath)
+// runMerge は、複数の入力ファイルを root 要素の下に1つの文書としてまとめ、
+// ルールファイルに基づいて変換しながら出力します。カウンターは入力をまたいで続けて採番されます。
+// 正常に終えた場合は、persist を指定したカウンターの値を保存します。
+func runMerge(ctx context.Context, ruleFilepath string, inputFilepaths []string, outputFilepath, root string, opts transformOptions) (err error) {
+	rules, err := loadRuleSet(ruleFilepath, opts)
+	if err != nil {
+		return err
+	}
+	if rules.Output.Minimal {
+		return fmt.Errorf("output option 'minimal' cannot be used when merging inputs")
+	}
+	if opts.report, err = newReportRenderer(opts); err != nil {
+		return err
+	}
+	if root == "" || strings.ContainsAny(root, " \t\r\n<>&\"'/=") {
+		return fmt.Errorf("invalid root element name: '%s'", root)
+	}
+	audit, err := openAuditLog(rules, opts, outputFilepath)
 	if err != nil {
-		return fmt.Errorf("error creating output file '%s': %w", outputFilepath, err)
+		return err
 	}
-	defer outputFile.Close()
+	defer func() {
+		if closeErr := audit.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	opts.matches = newRuleMatchCounter(rules, opts)
 
-	// CRLF改行コードを強制するwriterでラップ
-	writer := newCRLFWriter(outputFile)
+	output, err := createOutput(outputFilepath, opts)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
 
-	// --- プロセッサの実行 ---
-	proc := newProcessor(inputFile, writer, nameRules, insertRules, insertAfterRules, prependChildRules, valueRules, wrapRules, cdataRules, rawTags)
-	if err := proc.Run(); err != nil {
+	open := func(inputFilepath string) (io.Reader, io.Closer, error) {
+		return openRuleSetInput(rules, inputFilepath, opts.MaxInputSize)
+	}
+	result, err := rules.TransformMerged(ctx, root, inputFilepaths, open, output)
+	printWarnings(result)
+	if err != nil {
+		if aborted(ctx, err) {
+			output.Discard()
+		}
 		return fmt.Errorf("error processing XML: %w", err)
 	}
+	files, skipped := []string{outputFilepath}, []string(nil)
+	if skipUnchanged(output, opts) {
+		files, skipped = nil, files
+	} else {
+		if err := output.Finish(); err != nil {
+			return err
+		}
+		if err := validateOutput(outputFilepath, opts); err != nil {
+			return err
+		}
+	}
+	if err := rules.SaveCounters(); err != nil {
+		return err
+	}
 
-	fmt.Printf("XML processing completed. Rules: '%s', Input: '%s', Output: '%s'\n", ruleFilepath, inputFilepath, outputFilepath)
+	if opts.report != nil {
+		return opts.report.render(runReport{Command: "merge", Rules: ruleFilepath, Inputs: inputFilepaths, Output: outputFilepath, Files: files, Skipped: skipped, TransformResult: result})
+	}
+	fmt.Printf("XML merge completed. Rules: '%s', Inputs: %d file(s), Output: '%s'\n", ruleFilepath, len(inputFilepaths), outputFilepath)
+	printSkipped(skipped)
+	printResult(result)
 	return nil
 }
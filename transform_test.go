@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// transformFile は、設定 cfg をルールファイルに書き出して input を変換し、出力ファイルの内容を返します。
+func transformFile(t *testing.T, cfg obufuku.Config, input string) string {
+	t.Helper()
+	output, err := tryTransformFile(t, cfg, input)
+	if err != nil {
+		t.Fatalf("runTransform: %v", err)
+	}
+	return output
+}
+
+// tryTransformFile は、transformFile と同じく input を変換し、変換のエラーを返します。
+func tryTransformFile(t *testing.T, cfg obufuku.Config, input string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	rulePath, inputPath, outputPath := writeRules(t, dir, cfg), writeFile(t, dir, "in.xml", input), filepath.Join(dir, "out.xml")
+	if err := runTransform(context.Background(), rulePath, inputPath, outputPath, transformOptions{}); err != nil {
+		return "", err
+	}
+	output, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), nil
+}
+
+// writeRules は、設定 cfg をルールファイルとして dir に書き出し、そのパスを返します。
+func writeRules(t *testing.T, dir string, cfg obufuku.Config) string {
+	t.Helper()
+	rules, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("marshal rules: %v", err)
+	}
+	return writeFile(t, dir, "rules.json", string(rules))
+}
+
+// writeFile は、dir に name のファイルを data の内容で作成し、そのパスを返します。
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCompactOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		compact bool
+		input   string
+		want    string
+	}{
+		{"indented", false, "<a><b>x</b><c/></a>", "<a>\r\n  <b>x</b>\r\n  <c></c>\r\n</a>"},
+		{"compact", true, "<a>\n  <b>x</b>\n  <c/>\n</a>", "<a><b>x</b><c></c></a>"},
+		{"compact keeps text", true, "<a><b> x y </b></a>", "<a><b> x y </b></a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := transformFile(t, obufuku.Config{Output: obufuku.ConfigOutput{Compact: tt.compact}}, tt.input)
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressedOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		compress bool
+		gzipped  bool
+	}{
+		{"plain", "out.xml", false, false},
+		{"gz extension", "out.xml.gz", false, true},
+		{"compress option", "out.xml", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			rulePath, inputPath := writeRules(t, dir, obufuku.Config{Output: obufuku.ConfigOutput{Compact: true}}), writeFile(t, dir, "in.xml", "<a><b/></a>")
+			outputPath := filepath.Join(dir, tt.output)
+			if err := runTransform(context.Background(), rulePath, inputPath, outputPath, transformOptions{Compress: tt.compress}); err != nil {
+				t.Fatalf("runTransform: %v", err)
+			}
+			data, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.gzipped {
+				zr, err := gzip.NewReader(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("output is not gzip: %v", err)
+				}
+				if data, err = io.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, want := string(data), "<a><b></b></a>"; got != want {
+				t.Errorf("output = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	rulePath := writeRules(t, dir, obufuku.Config{NameRules: []obufuku.ConfigNameRule{{Old: "item", New: "entry"}}, Output: obufuku.ConfigOutput{Compact: true}})
+	inputs := []string{
+		writeFile(t, dir, "1.xml", `<?xml version="1.0"?><doc><item>1</item></doc>`),
+		writeFile(t, dir, "2.xml", `<?xml version="1.0"?><!DOCTYPE doc><doc><item>2</item></doc>`),
+	}
+	outputPath := filepath.Join(dir, "out.xml")
+	if err := runMerge(context.Background(), rulePath, inputs, outputPath, "all", transformOptions{}); err != nil {
+		t.Fatalf("runMerge: %v", err)
+	}
+	got, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<?xml version="1.0"?><all><doc><entry>1</entry></doc><doc><entry>2</entry></doc></all>`; string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestTransformCanceled(t *testing.T) {
+	// 取り消された場合はエラーを返し、書きかけの出力ファイルを残さない
+	dir := t.TempDir()
+	rulePath, inputPath, outputPath := writeRules(t, dir, obufuku.Config{}), writeFile(t, dir, "in.xml", "<a><b/></a>"), filepath.Join(dir, "out.xml")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := runTransform(ctx, rulePath, inputPath, outputPath, transformOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("runTransform error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("output file was left behind: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// loadWASMPlugins は、ディレクトリ内の WebAssembly モジュール (*.wasm) をファイル名の順に読み込み、
+// 拡張子を除いたファイル名を値置換の種類として登録します。
+// モジュールはサンドボックス内で実行されるため、Go プラグインと異なりプラットフォームを問わず使えます。
+func loadWASMPlugins(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("failed to open WebAssembly plugin directory '%s': %w", dir, err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		wasm, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read WebAssembly plugin '%s': %w", path, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".wasm")
+		if err := obufuku.RegisterWASMValueFunc(name, wasm); err != nil {
+			return fmt.Errorf("WebAssembly plugin '%s': %w", path, err)
+		}
+	}
+	return nil
+}
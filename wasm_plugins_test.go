@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadWASMPluginsErrors(t *testing.T) {
+	if err := loadWASMPlugins(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loadWASMPlugins succeeded for a missing directory")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.wasm"), []byte("not wasm"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := loadWASMPlugins(dir)
+	if err == nil || !strings.Contains(err.Error(), "broken.wasm") {
+		t.Errorf("loadWASMPlugins error = %v, want an error naming broken.wasm", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// xmllintCommand は、XML Schema の検証に使う xmllint (libxml2) のコマンド名です。
+// 環境変数 OBUFUKU_XMLLINT でパスを指定できます。
+const xmllintCommand = "xmllint"
+
+// validateXML は、XMLファイル xmlPath を XML Schema schemaPath で検証し、違反の一覧を返します。
+// 違反が無い場合は空の一覧を返します。スキーマを読み込めない場合や xmllint を実行できない場合はエラーを返します。
+// gzip 圧縮されたファイルもそのまま検証できます。
+func validateXML(schemaPath, xmlPath string) ([]string, error) {
+	command := os.Getenv("OBUFUKU_XMLLINT")
+	if command == "" {
+		command = xmllintCommand
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("XML Schema validation requires xmllint (libxml2): %w", err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, "--noout", "--nonet", "--schema", schemaPath, xmlPath)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 3:
+		// 終了コード 3 は検証エラー。最後の "fails to validate" の行を除いた各行が違反
+		var violations []string
+		for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+			if line != "" && !strings.HasSuffix(line, " fails to validate") {
+				violations = append(violations, line)
+			}
+		}
+		return violations, nil
+	default:
+		return nil, fmt.Errorf("failed to validate '%s' against '%s': %v: %s", xmlPath, schemaPath, err, strings.TrimSpace(stderr.String()))
+	}
+}
+
+// runValidateXML は、各XMLファイルをスキーマで検証し、違反を出力します。
+// 違反のあるファイルが1つでもあればエラーを返します。
+func runValidateXML(schemaPath string, xmlPaths []string) error {
+	invalid := 0
+	for _, xmlPath := range xmlPaths {
+		violations, err := validateXML(schemaPath, xmlPath)
+		if err != nil {
+			return err
+		}
+		if len(violations) == 0 {
+			fmt.Printf("%s: valid\n", xmlPath)
+			continue
+		}
+		invalid++
+		for _, v := range violations {
+			fmt.Println(v)
+		}
+		fmt.Printf("%s: %d violation(s)\n", xmlPath, len(violations))
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d file(s) do not conform to schema '%s'", invalid, len(xmlPaths), schemaPath)
+	}
+	return nil
+}
+
+// validateOutput は、--validate-output が指定されていれば出力ファイルをスキーマで検証します。
+// 違反がある場合、--validate-warn では警告を出力し、そうでなければエラーを返します (出力ファイルは残します)。
+func validateOutput(outputFilepath string, opts transformOptions) error {
+	if opts.ValidateOutput == "" {
+		return nil
+	}
+	violations, err := validateXML(opts.ValidateOutput, outputFilepath)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	for _, v := range violations {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", v)
+	}
+	if opts.ValidateWarn {
+		return nil
+	}
+	return fmt.Errorf("output '%s' does not conform to schema '%s' (%d violation(s); the output was kept for inspection)", outputFilepath, opts.ValidateOutput, len(violations))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeXMLLint は、標準エラーに stderr を書き出して終了コード code で終わる xmllint の代わりのスクリプトを作成し、
+// OBUFUKU_XMLLINT に設定します。
+func fakeXMLLint(t *testing.T, stderr string, code int) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake xmllint is a shell script")
+	}
+	script := "#!/bin/sh\nprintf '%s' '" + stderr + "' >&2\nexit " + strconv.Itoa(code) + "\n"
+	path := filepath.Join(t.TempDir(), "xmllint")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OBUFUKU_XMLLINT", path)
+}
+
+func TestValidateXML(t *testing.T) {
+	tests := []struct {
+		name       string
+		stderr     string
+		code       int
+		violations []string
+		err        string
+	}{
+		{"valid", "out.xml validates\n", 0, nil, ""},
+		{"violations", "out.xml:2: element b: Schemas validity error : not expected.\nout.xml:3: element c: Schemas validity error : missing.\nout.xml fails to validate\n", 3,
+			[]string{"out.xml:2: element b: Schemas validity error : not expected.", "out.xml:3: element c: Schemas validity error : missing."}, ""},
+		{"unreadable schema", "schema.xsd: failed to load\n", 5, nil, "schema.xsd: failed to load"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeXMLLint(t, tt.stderr, tt.code)
+			violations, err := validateXML("schema.xsd", "out.xml")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("validateXML error = %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateXML: %v", err)
+			}
+			if !reflect.DeepEqual(violations, tt.violations) {
+				t.Errorf("violations = %q, want %q", violations, tt.violations)
+			}
+		})
+	}
+}
+
+func TestValidateXMLWithoutXMLLint(t *testing.T) {
+	t.Setenv("OBUFUKU_XMLLINT", filepath.Join(t.TempDir(), "missing-xmllint"))
+	if _, err := validateXML("schema.xsd", "out.xml"); err == nil || !strings.Contains(err.Error(), "requires xmllint") {
+		t.Errorf("validateXML error = %v, want an error about xmllint", err)
+	}
+}
+
+func TestValidateOutput(t *testing.T) {
+	fakeXMLLint(t, "out.xml:1: element a: Schemas validity error : bad.\nout.xml fails to validate\n", 3)
+	if err := validateOutput("out.xml", transformOptions{}); err != nil {
+		t.Errorf("validateOutput without a schema: %v", err)
+	}
+	if err := validateOutput("out.xml", transformOptions{ValidateOutput: "schema.xsd"}); err == nil || !strings.Contains(err.Error(), "1 violation(s)") {
+		t.Errorf("validateOutput error = %v, want an error reporting 1 violation", err)
+	}
+	if err := validateOutput("out.xml", transformOptions{ValidateOutput: "schema.xsd", ValidateWarn: true}); err != nil {
+		t.Errorf("validateOutput with ValidateWarn: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+// runImportXSLT は、XSLT のスタイルシートをルールファイルに変換し、outputFilepath (空の場合は標準出力) に書き込みます。
+// 変換できなかった箇所は警告として標準エラー出力に出力し、strict の場合はルールファイルを書き込まずにエラーを返します。
+func runImportXSLT(stylesheetFilepath, outputFilepath string, strict bool) error {
+	stylesheet, err := openInput(stylesheetFilepath)
+	if err != nil {
+		return fmt.Errorf("error opening stylesheet '%s': %w", stylesheetFilepath, err)
+	}
+	config, issues, err := obufuku.ImportXSLT(stylesheet)
+	stylesheet.Close()
+	if err != nil {
+		return fmt.Errorf("error importing stylesheet '%s': %w", stylesheetFilepath, err)
+	}
+	for _, issue := range issues {
+		fmt.Fprintf(os.Stderr, "Warning: %s:%s\n", stylesheetFilepath, issue)
+	}
+	if strict && len(issues) > 0 {
+		return fmt.Errorf("%d construct(s) in '%s' cannot be translated", len(issues), stylesheetFilepath)
+	}
+
+	data, err := marshalRules(config)
+	if err != nil {
+		return err
+	}
+	if outputFilepath == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(outputFilepath, data, 0644); err != nil {
+		return fmt.Errorf("error writing rule file '%s': %w", outputFilepath, err)
+	}
+	fmt.Printf("XSLT import completed. Stylesheet: '%s', Rules: '%s'\n", stylesheetFilepath, outputFilepath)
+	fmt.Printf("  Untranslated constructs: %d\n", len(issues))
+	return nil
+}
+
+// marshalRules は、ルールの設定をインデント付きの JSON にします。
+// 設定していない項目 (空の値) は、ルールファイルを読みやすくするため出力しません。
+func marshalRules(config obufuku.Config) ([]byte, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	// テンプレートの < や & をそのまま読めるよう、HTML向けのエスケープはしない
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(pruneEmpty(tree)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pruneEmpty は、JSON の値から空の値 (null、空文字列、false、0、空の配列とオブジェクト) を取り除きます。
+// 値全体が空の場合は nil を返します。
+func pruneEmpty(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if pruned := pruneEmpty(value); pruned != nil {
+				v[key] = pruned
+			} else {
+				delete(v, key)
+			}
+		}
+		if len(v) == 0 {
+			return nil
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		for i, value := range v {
+			// 配列の要素は位置に意味があるため、空でも取り除かない
+			if pruned := pruneEmpty(value); pruned != nil {
+				v[i] = pruned
+			}
+		}
+	case string:
+		if v == "" {
+			return nil
+		}
+	case bool:
+		if !v {
+			return nil
+		}
+	case float64:
+		if v == 0 {
+			return nil
+		}
+	case nil:
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hizuheka/go-ObuFuku/obufuku"
+)
+
+func TestMarshalRules(t *testing.T) {
+	// 空の項目は出力せず、テンプレートの < はエスケープしない
+	cfg := obufuku.Config{InsertRules: []obufuku.ConfigInsertRule{{Target: "a", Template: "<x/>"}}}
+	data, err := marshalRules(cfg)
+	if err != nil {
+		t.Fatalf("marshalRules: %v", err)
+	}
+	want := "{\n  \"insert_rules\": [\n    {\n      \"target\": \"a\",\n      \"template\": \"<x/>\"\n    }\n  ]\n}\n"
+	if string(data) != want {
+		t.Errorf("marshalRules = %q, want %q", data, want)
+	}
+}